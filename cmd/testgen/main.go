@@ -1,13 +1,34 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/Eranmonnie/testgen/internal/analyzer"
 	"github.com/Eranmonnie/testgen/internal/config"
+	"github.com/Eranmonnie/testgen/internal/coverage"
 	"github.com/Eranmonnie/testgen/internal/generator"
+	"github.com/Eranmonnie/testgen/internal/git"
+	"github.com/Eranmonnie/testgen/internal/lock"
+	"github.com/Eranmonnie/testgen/internal/parser"
+	"github.com/Eranmonnie/testgen/internal/report"
 	"github.com/Eranmonnie/testgen/pkg/models"
 	"github.com/spf13/cobra"
 )
@@ -16,14 +37,35 @@ var (
 	version = "0.1.0"
 
 	// Global flags
-	configFile string
-	verbose    bool
-	dryRun     bool
+	configFile    string
+	configFromEnv bool
+	configDebug   bool
+	offline       bool
+	verbose       bool
+	dryRun        bool
+	repoDir       string
+	quiet         bool
+	summaryOnly   bool
+	lockWait      time.Duration
+	stealStale    bool
+
+	// Hidden flags for profiling testgen itself
+	cpuProfile string
+	memProfile string
+	traceFile  string
+
+	// Open while the command runs; see startProfiling
+	cpuProfileOut *os.File
+	traceOut      *os.File
 )
 
 func main() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	err := rootCmd.Execute()
+	if stopErr := stopProfiling(); err == nil {
+		err = stopErr
+	}
+	if err != nil {
+		report.Errorf("%v\n", err)
 		os.Exit(1)
 	}
 }
@@ -33,21 +75,104 @@ var rootCmd = &cobra.Command{
 	Short: "AI-powered Go test generation tool",
 	Long: `Testgen automatically generates Go tests using AI.
 It can work in auto mode (triggered by git hooks) or manual mode (on-demand).`,
-	Version: version,
+	Version:           version,
+	PersistentPreRunE: startProfiling,
+}
+
+// startProfiling starts the CPU profile and execution trace the hidden
+// --cpuprofile and --trace flags ask for; main stops them once the command
+// returns
+func startProfiling(cmd *cobra.Command, args []string) error {
+	if cpuProfile != "" {
+		file, err := os.Create(cpuProfile)
+		if err != nil {
+			return fmt.Errorf("failed to create CPU profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(file); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to start CPU profile: %w", err)
+		}
+		cpuProfileOut = file
+	}
+	if traceFile != "" {
+		file, err := os.Create(traceFile)
+		if err != nil {
+			return fmt.Errorf("failed to create trace: %w", err)
+		}
+		if err := trace.Start(file); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to start trace: %w", err)
+		}
+		traceOut = file
+	}
+	return nil
+}
+
+// stopProfiling stops what startProfiling started and writes the heap
+// profile --memprofile asks for
+func stopProfiling() error {
+	var errs []error
+	if cpuProfileOut != nil {
+		pprof.StopCPUProfile()
+		errs = append(errs, cpuProfileOut.Close())
+		cpuProfileOut = nil
+	}
+	if traceOut != nil {
+		trace.Stop()
+		errs = append(errs, traceOut.Close())
+		traceOut = nil
+	}
+	if memProfile != "" {
+		file, err := os.Create(memProfile)
+		if err != nil {
+			return errors.Join(append(errs, fmt.Errorf("failed to create memory profile: %w", err))...)
+		}
+		// Up to date statistics of what's still allocated
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(file); err != nil {
+			errs = append(errs, fmt.Errorf("failed to write memory profile: %w", err))
+		}
+		errs = append(errs, file.Close())
+	}
+	return errors.Join(errs...)
 }
 
 func init() {
+	generator.Version = version
+
 	// Global flags
 	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "config file path")
+	rootCmd.PersistentFlags().BoolVar(&configFromEnv, "config-from-env", false, "build the configuration from defaults and TESTGEN_* environment variables only, without a config file (also "+config.ConfigFromEnvVar+"=true)")
+	rootCmd.MarkFlagsMutuallyExclusive("config", "config-from-env")
+	rootCmd.PersistentFlags().BoolVar(&configDebug, "config-debug", false, "print every location checked for a config file, in order, to stderr")
+	rootCmd.PersistentFlags().BoolVar(&offline, "offline", false, "use the cached copy of a remote base config (extends:), however old, instead of fetching it")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
 	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "show what would be done without doing it")
+	rootCmd.PersistentFlags().StringVar(&repoDir, "repo", "", "path to the git repository to operate on (default: current directory)")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "only print errors and a single final line (default in auto mode)")
+	rootCmd.PersistentFlags().BoolVar(&summaryOnly, "summary-only", false, "print the summary table without per-file detail")
+	rootCmd.MarkFlagsMutuallyExclusive("quiet", "summary-only", "verbose")
+	rootCmd.PersistentFlags().DurationVar(&lockWait, "wait", 0, "wait this long for another testgen run to finish, e.g. 30s (default: fail at once)")
+	rootCmd.PersistentFlags().BoolVar(&stealStale, "steal-stale", false, "take over the project lock if the run holding it is no longer running")
+	rootCmd.PersistentFlags().StringVar(&cpuProfile, "cpuprofile", "", "write a CPU profile of testgen to this file")
+	rootCmd.PersistentFlags().StringVar(&memProfile, "memprofile", "", "write a heap profile of testgen to this file when it exits")
+	rootCmd.PersistentFlags().StringVar(&traceFile, "trace", "", "write an execution trace of testgen to this file")
+	for _, name := range []string{"cpuprofile", "memprofile", "trace"} {
+		rootCmd.PersistentFlags().MarkHidden(name)
+	}
 
 	// Add subcommands
 	rootCmd.AddCommand(generateCmd)
 	rootCmd.AddCommand(initCmd)
 	rootCmd.AddCommand(configCmd)
 	rootCmd.AddCommand(hooksCmd)
+	rootCmd.AddCommand(queueCmd)
 	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(approveCmd)
+	rootCmd.AddCommand(proposalsCmd)
+	rootCmd.AddCommand(promptCmd)
+	rootCmd.AddCommand(cleanCmd)
+	rootCmd.AddCommand(explainTestCmd)
 }
 
 // Generate command - main functionality
@@ -59,364 +184,2143 @@ var generateCmd = &cobra.Command{
 Examples:
   testgen generate                    # Analyze recent git changes
   testgen generate user.go handler.go # Generate for specific files
+  testgen generate user.go:40-80      # Functions overlapping lines 40-80
   testgen generate --range HEAD~3..HEAD # Analyze specific git range
-  testgen generate --function ValidateUser # Generate for specific function`,
+  testgen generate --range main...feature # Changes since feature forked from main
+  testgen generate --function ValidateUser # Generate for specific function
+  testgen generate --function user.go:ValidateUser # A function of a specific file
+  testgen generate --provider anthropic --model claude-3-5-sonnet-latest # One-off model choice
+  testgen generate --goos windows file_windows.go # Tests for another platform's code
+  testgen generate --dump-prompts prompts/ # Write prompts for review, no API calls
+  testgen generate --propose          # Propose tests for approval (CI)
+  testgen generate --report-html testgen.html # HTML report for review or CI artifacts
+  testgen generate --resume           # Continue an interrupted run
+  testgen generate --emit-json tests.json # All generated tests as JSON, test files untouched
+  testgen generate --stats-only *.go  # Testability stats for dashboards, no API calls
+  testgen generate --dry-run --why --range origin/main..HEAD # Which pushed commit added each untested function
+  testgen generate --run-tests --fail-under 80 # Fail unless coverage reaches 80%
+  testgen generate --preview-diff     # Diff existing test files against what would be written
+  testgen generate --drain-queue      # Also write tests queued while offline`,
 	RunE: runGenerate,
 }
 
 var (
-	gitRange     string
-	functionName string
-	allFiles     bool
+	gitRange         string
+	functionName     string
+	allFiles         bool
+	providerOverride string
+	modelOverride    string
+	targetGOOS       string
+	targetGOARCH     string
+	jsonOutput       bool
+	dumpPromptsDir   string
+	proposeTests     bool
+	reportHTMLPath   string
+	runTimeout       time.Duration
+	resumeRun        bool
+	emitJSONPath     string
+	statsOnly        bool
+	runTests         bool
+	failUnder        float64
+	noBackup         bool
+	noFlaky          bool
+	fuzzTests        bool
+	testType         string
+	toBranch         string
+	forceBranch      bool
+	previewDiff      bool
+	stdoutTests      bool
+	drainQueue       bool
+	annotationFormat string
+	showTimings      bool
+	includeCgo       bool
+	abCompare        string
+	whyIntroduced    bool
+
+	stubMissingCoverage bool
+
+	// Hidden flags the installed hook scripts pass
+	triggeringHook string
+	hookBackground bool
 )
 
 func init() {
-	generateCmd.Flags().StringVar(&gitRange, "range", "", "git range to analyze (e.g., HEAD~1..HEAD)")
-	generateCmd.Flags().StringVar(&functionName, "function", "", "specific function to generate tests for")
+	generateCmd.Flags().StringVar(&gitRange, "range", "", "git range to analyze: from..to, from...to (since the merge base) or a single ref meaning ref..HEAD")
+	generateCmd.Flags().StringVar(&functionName, "function", "", "specific function to generate tests for, optionally as file.go:Func")
 	generateCmd.Flags().BoolVar(&allFiles, "all", false, "generate tests for all functions in specified files")
+	generateCmd.Flags().StringVar(&providerOverride, "provider", "", "AI provider for this run (overrides config and TESTGEN_PROVIDER)")
+	generateCmd.Flags().StringVar(&modelOverride, "model", "", "AI model for this run (overrides config and TESTGEN_MODEL)")
+	generateCmd.Flags().StringVar(&dumpPromptsDir, "dump-prompts", "", "write each function's prompt to a file in this directory instead of calling the AI")
+	generateCmd.Flags().BoolVar(&proposeTests, "propose", false, "write tests to "+generator.ProposalsDir+" for later approval instead of into test files")
+	generateCmd.Flags().StringVar(&emitJSONPath, "emit-json", "", "write all generated tests, mapped to their functions, to this JSON file instead of into test files")
+	generateCmd.Flags().BoolVar(&statsOnly, "stats-only", false, "print testability stats of the analysis and append them to "+analyzer.StatsFile+", without calling the AI")
+	generateCmd.Flags().BoolVar(&resumeRun, "resume", false, "continue an interrupted run, generating only the functions it didn't finish")
+	generateCmd.Flags().DurationVar(&runTimeout, "timeout", 0, "overall time budget for AI calls in this run, e.g. 10m (0 = no limit; ai.request_timeout bounds each call)")
+	generateCmd.Flags().StringVar(&reportHTMLPath, "report-html", "", "write a standalone HTML report of the generated tests to this path")
+	generateCmd.Flags().BoolVar(&jsonOutput, "json", false, "print a JSON run summary, including the confidence distribution, instead of text")
+	generateCmd.Flags().BoolVar(&runTests, "run-tests", false, "run the tests of the affected packages with coverage after generating and report before/after coverage")
+	generateCmd.Flags().Float64Var(&failUnder, "fail-under", 0, "exit nonzero if an affected package's coverage is still below this percentage after generating (implies --run-tests)")
+	generateCmd.Flags().BoolVar(&noBackup, "no-backup", false, "don't write .backup files before overwriting test files, relying on git (overrides output.backup_existing)")
+	generateCmd.Flags().BoolVar(&noFlaky, "no-flaky", false, "quarantine generated tests that sleep, use the real network, unseeded rand or the wall clock instead of writing them (output.flaky_tests: exclude)")
+	generateCmd.Flags().BoolVar(&stubMissingCoverage, "stub-missing-coverage", false, "add a skipped TODO table case for each coverage scenario a table-driven test has no subtest for (output.stub_missing_coverage)")
+	generateCmd.Flags().BoolVar(&fuzzTests, "fuzz", false, "also ask for a FuzzXxx test of each function whose parameters can be fuzzed, seeded with the literals the package calls it with (output.fuzz_tests)")
+	generateCmd.Flags().StringVar(&testType, "type", "", "kind of tests to ask for: unit, integration, benchmark, example or fuzz (default unit; ai.temperature_by_type can set a temperature per kind)")
+	generateCmd.Flags().StringVar(&toBranch, "to-branch", "", "commit the generated tests to this branch, through a temporary worktree, instead of writing them to the working tree")
+	generateCmd.Flags().BoolVar(&forceBranch, "force-branch", false, "with --to-branch, replace the branch if it already exists")
+	generateCmd.Flags().BoolVar(&previewDiff, "preview-diff", false, "generate tests but only print a unified diff of each existing test file against its merged or overwritten result, writing nothing")
+	generateCmd.Flags().BoolVar(&stdoutTests, "stdout", false, "generate tests for a single source file and print the complete test file to stdout instead of writing it (progress goes to stderr)")
+	generateCmd.Flags().BoolVar(&drainQueue, "drain-queue", false, "first generate and write the tests of runs queued in "+generator.QueueDir+" while the AI provider was unreachable")
+	generateCmd.Flags().StringVar(&annotationFormat, "annotations", "", "print CI annotations for untested functions, invalid and flaky tests: github or none (default github under GitHub Actions, none elsewhere)")
+	generateCmd.Flags().BoolVar(&showTimings, "timings", false, "print how long the run spent in each phase: git diff, parse, filtering, prompt build, provider calls, validation and write (also in --json and --stats-only records)")
+	generateCmd.Flags().StringVar(&abCompare, "ab-compare", "", "also send each prompt to this model of the same provider and report tokens, latency, validation pass rate, findings and confidence of both per function; only the configured model's tests are written")
+	generateCmd.Flags().BoolVar(&whyIntroduced, "why", false, "with --stats-only or --dry-run over a git range, name the commit in the range that introduced each function that would get tests")
+	generateCmd.Flags().BoolVar(&includeCgo, "include-cgo", false, "analyze files that import \"C\" instead of skipping them")
+	generateCmd.Flags().StringVar(&targetGOOS, "goos", "", "target operating system for build constraints (e.g. windows); adds a build tag to tests of platform-specific files")
+	generateCmd.Flags().StringVar(&targetGOARCH, "goarch", "", "target architecture for build constraints (e.g. arm64); adds a build tag to tests of platform-specific files")
+	generateCmd.Flags().StringVar(&triggeringHook, "hook", "", "git hook running this generate, bounding it by triggers.auto.hook_timeout")
+	generateCmd.Flags().BoolVar(&hookBackground, "hook-background", false, "the hook runs this generate detached, so no hook timeout applies")
+	for _, name := range []string{"hook", "hook-background"} {
+		generateCmd.Flags().MarkHidden(name)
+	}
 }
 
 func runGenerate(cmd *cobra.Command, args []string) error {
+	// An emergency commit or push goes through without a run
+	if os.Getenv(skipEnvVar) == "1" {
+		report.Resultf("testgen: skipped (%s=1)\n", skipEnvVar)
+		return nil
+	}
+	started := time.Now()
+
+	// --stdout keeps stdout for the test file alone
+	if stdoutTests {
+		report.SetOutput(os.Stderr, os.Stderr)
+	}
+
 	// Load configuration
 	cfg, err := loadConfig()
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
-	if verbose {
-		fmt.Printf("Using config: %s mode, %s provider\n", cfg.Mode, cfg.AI.Provider)
+	// One-off provider/model choices win over config and environment
+	if err := cfg.OverrideAI(providerOverride, modelOverride); err != nil {
+		return err
+	}
+	if noBackup {
+		cfg.Output.BackupExisting = false
+	}
+	if noFlaky {
+		cfg.Output.FlakyTests = generator.FlakyExclude
+	}
+	if fuzzTests {
+		cfg.Output.FuzzTests = true
+	}
+	if stubMissingCoverage {
+		cfg.Output.StubMissingCoverage = true
+	}
+	requestedType := models.UnitTest
+	if testType != "" {
+		if err := config.CheckTestType(testType); err != nil {
+			return err
+		}
+		requestedType = models.TestType(testType)
 	}
 
-	// Determine what to analyze
-	var result *analyzer.AnalysisResult
+	report.SetLevel(outputLevel(cfg))
+	report.Verbosef("Using config: %s mode, %s provider\n", cfg.Mode, cfg.AI.Provider)
+	hookTimeout, err := hookRunTimeout(cfg)
+	if err != nil {
+		return err
+	}
+	report.Verbosef("Temperature: %.2f for %s tests\n", cfg.AI.TemperatureFor(string(requestedType)), requestedType)
 
-	if len(args) > 0 {
-		// Specific files provided
-		var functions []string
-		if functionName != "" {
-			functions = []string{functionName}
+	// --fail-under gates on the coverage --run-tests measures
+	if failUnder != 0 {
+		runTests = true
+	}
+	if runTests {
+		if err := checkRunTests(cfg); err != nil {
+			return err
+		}
+	}
+	if toBranch != "" || forceBranch {
+		if err := checkToBranch(); err != nil {
+			return err
+		}
+	}
+	if previewDiff {
+		if err := checkPreviewDiff(); err != nil {
+			return err
+		}
+	}
+	if stdoutTests {
+		if err := checkStdout(args); err != nil {
+			return err
+		}
+	}
+	if drainQueue {
+		if err := checkDrainQueue(); err != nil {
+			return err
+		}
+	}
+	if whyIntroduced {
+		if err := checkWhy(args); err != nil {
+			return err
 		}
+	}
+	if err := setupAnnotations(); err != nil {
+		return err
+	}
 
-		result, err = analyzer.AnalyzeSpecificFunctions(args, functions)
+	// Runs that write tests, proposals or progress must not overlap
+	if !dryRun && dumpPromptsDir == "" && !statsOnly && !stdoutTests {
+		release, err := acquireProjectLock("generate")
 		if err != nil {
-			return fmt.Errorf("failed to analyze files: %w", err)
+			return err
 		}
+		defer release()
+	}
 
-		if verbose {
-			fmt.Printf("Analyzing %d specific files\n", len(args))
+	// Analyze the files that build for the requested platform, keeping
+	// always_include functions whatever the other filters say
+	analyzer.SetFiltering(cfg.Filtering)
+	analyzer.SetIncludeCgo(includeCgo)
+	analyzer.SetRecentCommits(cfg.AI.RecentCommits)
+
+	// Runs queued while the provider was unreachable go first; if it still
+	// is, this run may join them
+	if drainQueue {
+		written, err := runQueue(cfg)
+		if err != nil && !generator.ProviderUnreachable(err) {
+			return err
 		}
-	} else {
-		// Analyze git changes
-		fromRef, toRef := parseGitRange(gitRange, cfg)
+		if err != nil {
+			report.Warnf("queued runs are still pending: %v\n", err)
+		} else if written > 0 {
+			report.Infof("Wrote %d queued tests\n", written)
+		}
+	}
+	parser.SetPlatform(targetGOOS, targetGOARCH)
+	if parser.PlatformSet() {
+		report.Infof("Analyzing files that build for %s\n", parser.Platform())
+	}
 
-		result, err = analyzer.AnalyzeChanges(fromRef, toRef)
+	// Under go generate, $GOFILE is the file holding the directive
+	args, function := goGenerateTargets(args, functionName)
+
+	// Determine what to analyze: specific files, git changes, or both when
+	// files are combined with --range
+	sources := analyzer.Sources{
+		Files:    args,
+		UseRange: gitRange != "",
+	}
+	if function != "" {
+		sources.Functions = []string{function}
+	}
+	if sources.UseRange || len(args) == 0 {
+		sources.FromRef, sources.ToRef, err = parseGitRange(gitRange, cfg)
 		if err != nil {
-			return fmt.Errorf("failed to analyze git changes: %w", err)
+			return err
 		}
+		report.Verbosef("Analyzing git range: %s..%s\n", sources.FromRef, sources.ToRef)
+	}
+	if len(args) > 0 {
+		report.Verbosef("Analyzing %d specific files\n", len(args))
+	}
 
-		if verbose {
-			fmt.Printf("Analyzing git range: %s..%s\n", fromRef, toRef)
+	// Files and functions requested more than once are analyzed once
+	result, err := analyzer.Analyze(sources)
+	if err != nil {
+		return err
+	}
+	for _, rewrite := range result.PathRewrites {
+		if rewrite.Duplicate {
+			report.Verbosef("Skipping %s (same file as %s)\n", rewrite.Input, rewrite.Canonical)
+		} else {
+			report.Verbosef("Resolved %s to %s\n", rewrite.Input, rewrite.Canonical)
 		}
 	}
 
-	// Show analysis summary
-	if verbose || dryRun {
+	// Exported types exposing changed embedded methods get their own targets
+	if cfg.Filtering.IncludePromoted {
+		result.GenerationTargets = append(result.GenerationTargets, analyzer.PromotedTargets(result)...)
+	}
+
+	// Unmodified exported functions the change affects, per triggers.blast_radius
+	result.GenerationTargets = append(result.GenerationTargets, analyzer.BlastRadiusTargets(result, cfg.Triggers.BlastRadius)...)
+
+	// Show analysis summary; --stats-only prints its own
+	if !statsOnly {
 		analyzer.PrintAnalysisSummary(result)
 	}
 
+	excludeConfiguredTargets(cfg, result)
+
+	// Targets added above go back into reading order
+	analyzer.SortTargets(result.GenerationTargets)
+
+	// --why blames each target at the end of the range
+	var introductions []models.TargetIntroduction
+	if whyIntroduced {
+		if introductions, err = introducedTargets(sources.FromRef, sources.ToRef, result.GenerationTargets); err != nil {
+			return err
+		}
+	}
+
+	if statsOnly {
+		return recordStats(result, introductions)
+	}
+	warnDroppedContexts(result.GenerationTargets)
+
+	// Where the pipeline empties out is reported the same way everywhere
+	funnel := result.Funnel()
 	if len(result.GenerationTargets) == 0 {
-		fmt.Println("No functions found that need test generation.")
+		printNoTargets(funnel)
+		return nil
+	}
+
+	// Prompts can be written out for offline review instead of being sent
+	if dumpPromptsDir != "" {
+		request := models.TestGenerationRequest{
+			Functions: result.GenerationTargets,
+			Context:   analyzer.GetProjectContext(result),
+			TestType:  requestedType,
+		}
+		paths, err := generator.NewTestGenerator(cfg).DumpPrompts(request, dumpPromptsDir)
+		if err != nil {
+			return fmt.Errorf("failed to dump prompts: %w", err)
+		}
+		for _, path := range paths {
+			report.Verbosef("Wrote %s\n", path)
+		}
+		report.Resultf("Wrote %d prompts to %s\n", len(paths), dumpPromptsDir)
 		return nil
 	}
 
 	if dryRun {
-		fmt.Printf("Would generate tests for %d functions\n", len(result.GenerationTargets))
+		reportPromptTrimming(cfg, result)
+		report.Resultf("Would generate tests for %d functions\n", len(result.GenerationTargets))
+		printIntroductions(introductions)
 		return nil
 	}
 
-	// Generate actual tests using AI
-	fmt.Printf("Generating tests for %d functions...\n", len(result.GenerationTargets))
+	// Proposals, --emit-json, --to-branch, --preview-diff and --stdout leave test files alone
+	writeTests := !proposeTests && emitJSONPath == "" && toBranch == "" && !previewDiff && !stdoutTests
 
-	// Create test generator
-	generator := generator.NewTestGenerator(cfg)
+	// An interrupted run can be resumed: progress is saved as each source
+	// file's tests are written. Runs that don't write tests aren't tracked.
+	var progress *generator.Progress
+	if writeTests {
+		result.GenerationTargets, progress, err = startProgress(result.GenerationTargets, resumeRun)
+		if err != nil {
+			return err
+		}
+		funnel.Pending = len(result.GenerationTargets)
+		if len(result.GenerationTargets) == 0 {
+			if err := progress.Finish(); err != nil {
+				return err
+			}
+			printNoTargets(funnel)
+			return nil
+		}
+	}
 
-	// Build request context
-	context := analyzer.GetProjectContext(result)
+	// Coverage before generating, to compare with once the tests are written
+	var coverageBefore []coverage.Result
+	coverageDirs := packageDirs(result.GenerationTargets)
+	if runTests {
+		report.Infof("Measuring coverage of %d packages...\n", len(coverageDirs))
+		if coverageBefore, err = coverage.Measure(cmd.Context(), coverageDirs); err != nil {
+			return err
+		}
+	}
 
-	// Create generation request
-	request := models.TestGenerationRequest{
-		Functions: result.GenerationTargets,
-		Context:   context,
+	// Everything generated is collected for --report-html
+	htmlReport := report.HTMLReport{
+		GeneratedAt:            time.Now(),
+		Provider:               cfg.AI.Provider,
+		Model:                  cfg.AI.Model,
+		LowConfidenceThreshold: generator.LowConfidenceThreshold,
+	}
+	emitted := generator.EmittedRun{
+		GeneratedAt: time.Now().UTC(),
+		Provider:    cfg.AI.Provider,
+		Model:       cfg.AI.Model,
 	}
 
-	// Generate tests
-	response, err := generator.GenerateTests(request)
+	// Create test generator, bounded by the run timeout, the hook timeout
+	// counted from the start of the run, and Ctrl-C
+	ctx, cancel, err := runContext(runTimeout)
 	if err != nil {
-		return fmt.Errorf("failed to generate tests: %w", err)
+		return err
+	}
+	defer cancel()
+	ctx, cancelHook := hookContext(ctx, started, hookTimeout)
+	defer cancelHook()
+	generator := generator.NewTestGenerator(cfg)
+	generator.SetContext(ctx)
+
+	// Extend existing generated table tests in place where possible. Runs
+	// that don't touch test files always regenerate.
+	var responses []*models.TestGenerationResponse
+	deltas, targets := generator.PlanDeltas(result.GenerationTargets)
+	if !writeTests {
+		deltas, targets = nil, result.GenerationTargets
+	}
+	for _, delta := range deltas {
+		deltaResponse, err := generator.RegenerateDelta(delta)
+		if err != nil {
+			report.Warnf("could not extend %s (%v); regenerating tests for %s\n",
+				delta.Existing.Name, err, delta.Function.Name)
+			targets = append(targets, delta.Function)
+			continue
+		}
+		responses = append(responses, deltaResponse)
+		htmlReport.Add([]models.FunctionInfo{delta.Function}, deltaResponse)
+		if err := progress.Done(analyzer.QualifiedName(delta.Function)); err != nil {
+			return err
+		}
 	}
+	extended := len(responses)
 
-	if verbose {
-		fmt.Printf("AI Response: %s (confidence: %.2f)\n", response.Reasoning, response.Confidence)
-		if len(response.Warnings) > 0 {
-			fmt.Printf("Warnings: %v\n", response.Warnings)
+	if len(targets) == 0 {
+		writeHTMLReport(reportHTMLPath, htmlReport)
+		if err := progress.Finish(); err != nil {
+			return err
 		}
+		summary := newRunSummary(responses, 0, extended)
+		coverageErr := runCoverage(cmd.Context(), coverageDirs, coverageBefore, &summary)
+		printRunResult(summary, fmt.Sprintf("Extended existing tests for %d functions\n", extended))
+		return coverageErr
 	}
 
-	// Write test files
-	if err := generator.WriteTestFiles(result.GenerationTargets, response.Tests); err != nil {
-		return fmt.Errorf("failed to write test files: %w", err)
+	// Generate actual tests using AI, one source file at a time so each
+	// file's tests are written (and recorded) as soon as they arrive
+	report.Infof("Generating tests for %d functions...\n", len(targets))
+	projectContext := analyzer.GetProjectContext(result)
+
+	var warnings []string
+	var pendingFunctions []models.FunctionInfo
+	var pendingTests []models.GeneratedTest
+	var comparisons []report.ModelComparison
+	generated := 0
+	runID := time.Now().UTC().Format("20060102-150405")
+	batches := batchBySource(targets)
+	for i, batch := range batches {
+		response, err := generateBatch(generator, models.TestGenerationRequest{
+			Functions: batch,
+			Context:   projectContext,
+			TestType:  requestedType,
+		}, &comparisons)
+		if err != nil && writeTests && queueable(cfg, err) {
+			// Offline: keep what's left for testgen queue run instead of failing
+			return enqueueRun(models.TestGenerationRequest{
+				Functions: slices.Concat(batches[i:]...),
+				Context:   projectContext,
+				TestType:  requestedType,
+			}, progress, err)
+		}
+		if err != nil && errors.Is(context.Cause(ctx), errHookTimeout) {
+			return hookTimedOut(cfg, hookTimeout, progress)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to generate tests: %w%s", err, resumeHint(progress))
+		}
+
+		report.Verbosef("AI Response: %s (confidence: %.2f)\n", response.Reasoning, response.Confidence)
+		for _, test := range response.Tests {
+			report.Verbosef("  %s -> %s (matched by %s)\n", test.Name, test.TargetFunction, test.MatchedBy)
+		}
+		if len(response.Warnings) > 0 {
+			report.Verbosef("Warnings: %v\n", response.Warnings)
+		}
+		for _, annotation := range generator.Annotations(batch, response) {
+			report.Annotate(annotation)
+		}
+		htmlReport.Add(batch, response)
+		responses = append(responses, response)
+		warnings = append(warnings, response.Warnings...)
+		generated += len(response.Tests)
+		if emitJSONPath != "" {
+			emitted.Responses = append(emitted.Responses, generator.EmittedResponse(batch, response))
+		}
+
+		// Proposals, branch commits, previews and --stdout are rendered together once
+		// every batch is in; tests are paired with their functions within
+		// their batch, where function names are unambiguous
+		pairedFunctions, pairedTests := generator.PairTests(batch, response.Tests)
+		if proposeTests || toBranch != "" || previewDiff || stdoutTests {
+			pendingFunctions = append(pendingFunctions, pairedFunctions...)
+			pendingTests = append(pendingTests, pairedTests...)
+		}
+		if !writeTests {
+			continue
+		}
+
+		if err := generator.WriteTestFiles(pairedFunctions, pairedTests); err != nil {
+			return fmt.Errorf("failed to write test files: %w%s", err, resumeHint(progress))
+		}
+		recordHistory(generator, runID, projectContext, pairedFunctions, pairedTests, response.Confidence)
+		names := make([]string, len(batch))
+		for i, fn := range batch {
+			names[i] = analyzer.QualifiedName(fn)
+		}
+		if err := progress.Done(names...); err != nil {
+			return err
+		}
 	}
 
-	fmt.Printf("Successfully generated %d test functions\n", len(response.Tests))
+	htmlReport.Comparison = comparisons
+	writeHTMLReport(reportHTMLPath, htmlReport)
 
-	return nil
-}
+	// Show which tests deserve the closest review
+	summary := newRunSummary(responses, len(targets), extended)
+	summary.TestsGenerated = generated
+	summary.Warnings = warnings
+	summary.Comparison = comparisons
 
-// Init command - setup configuration and hooks
-var initCmd = &cobra.Command{
-	Use:   "init",
-	Short: "Initialize testgen in current project",
-	Long: `Create default configuration file and optionally install git hooks.
-	
-This will create a .testgen.yml file with sensible defaults.`,
-	RunE: runInit,
-}
+	if emitJSONPath != "" {
+		if err := emitted.Write(emitJSONPath); err != nil {
+			return err
+		}
+		if !proposeTests {
+			printRunResult(summary, fmt.Sprintf("Wrote %d tests for %d functions to %s\n", emitted.Tests(), len(targets), emitJSONPath))
+			return nil
+		}
+		report.Infof("Wrote %d tests to %s\n", emitted.Tests(), emitJSONPath)
+	}
 
-var (
-	installHooks bool
-	autoMode     bool
-)
+	// Propose the tests for approval
+	if proposeTests {
+		proposal, err := generator.Propose(proposalsDir, pendingFunctions, pendingTests)
+		if err != nil {
+			return fmt.Errorf("failed to write proposal: %w", err)
+		}
+		printRunResult(summary, fmt.Sprintf("Proposed %d tests as run %s; review and run: testgen approve %s\n",
+			len(pendingTests), proposal.RunID, proposal.RunID))
+		return nil
+	}
 
-func init() {
-	initCmd.Flags().BoolVar(&installHooks, "hooks", false, "install git hooks for auto mode")
-	initCmd.Flags().BoolVar(&autoMode, "auto", false, "set up for auto mode")
-}
+	// Commit the tests to a branch for review, working tree untouched
+	if toBranch != "" {
+		names := make([]string, len(targets))
+		for i, fn := range targets {
+			names[i] = analyzer.QualifiedName(fn)
+		}
+		commit, err := generator.CommitToBranch(toBranch, forceBranch, pendingFunctions, pendingTests, names)
+		if err != nil {
+			return fmt.Errorf("failed to commit to branch %s: %w", toBranch, err)
+		}
+		summary.Branch, summary.Commit = commit.Branch, commit.Commit
+		printRunResult(summary, fmt.Sprintf("Committed %d tests in %d files to branch %s (run %s); push it to open a PR\n",
+			len(pendingTests), len(commit.Files), commit.Branch, commit.RunID))
+		return nil
+	}
 
-func runInit(cmd *cobra.Command, args []string) error {
-	// Check if config already exists
-	if _, err := os.Stat(config.DefaultConfigFile); err == nil {
-		fmt.Printf("Configuration file %s already exists.\n", config.DefaultConfigFile)
+	// Show what writing the tests would change, writing nothing
+	if previewDiff {
+		diffs, err := generator.DiffTestFiles(pendingFunctions, pendingTests)
+		summary.Diffs = diffs
+		printRunResult(summary, previewDiffText(diffs))
+		if err != nil {
+			return fmt.Errorf("failed to preview test files: %w", err)
+		}
 		return nil
 	}
 
-	// Create default config
-	cfg := config.DefaultConfig()
+	// Print the test file for an editor or script to place itself
+	if stdoutTests {
+		path, content, fileWarnings, err := generator.RenderTestFile(pendingFunctions, pendingTests)
+		for _, warning := range fileWarnings {
+			report.Warnf("%s\n", warning.Message)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to render test file: %w", err)
+		}
+		fmt.Fprint(os.Stdout, content)
+		printRunResult(summary, fmt.Sprintf("Printed %d tests for %s; nothing was written\n", len(pendingTests), path))
+		return nil
+	}
 
-	// Modify based on flags
-	if autoMode {
-		cfg.Mode = "auto"
-		cfg.Hooks = []string{"post-commit"}
+	if err := progress.Finish(); err != nil {
+		return err
 	}
 
-	// Save configuration
-	if err := config.SaveConfig(cfg); err != nil {
-		return fmt.Errorf("failed to save config: %w", err)
+	coverageErr := runCoverage(cmd.Context(), coverageDirs, coverageBefore, &summary)
+	if report.CurrentLevel() == report.Quiet {
+		printRunResult(summary, fmt.Sprintf("testgen: %d tests generated for %d functions\n", generated, len(targets)))
+	} else {
+		printRunResult(summary, fmt.Sprintf("Successfully generated %d test functions\n", generated))
 	}
 
-	fmt.Printf("Created configuration file: %s\n", config.DefaultConfigFile)
+	return coverageErr
+}
 
-	// Install hooks if requested
-	if installHooks {
-		if err := installGitHooks(cfg); err != nil {
-			return fmt.Errorf("failed to install git hooks: %w", err)
-		}
-		fmt.Println("Git hooks installed successfully")
+// generateBatch generates tests for a batch, also sending its prompt to the
+// --ab-compare model if one is set and adding how both did to comparisons
+func generateBatch(tg *generator.TestGenerator, request models.TestGenerationRequest, comparisons *[]report.ModelComparison) (*models.TestGenerationResponse, error) {
+	if abCompare == "" {
+		return tg.GenerateTests(request)
+	}
+	response, runs, err := tg.GenerateCompared(request, abCompare)
+	if err != nil {
+		return nil, err
 	}
+	*comparisons = append(*comparisons, generator.CompareRuns(request.Functions, runs)...)
+	return response, nil
+}
 
-	// Show next steps
-	fmt.Println("\nNext steps:")
-	fmt.Printf("1. Edit %s to customize settings\n", config.DefaultConfigFile)
-	fmt.Println("2. Set TESTGEN_API_KEY environment variable")
-	if !installHooks && autoMode {
-		fmt.Println("3. Run 'testgen hooks install' to enable auto mode")
+// recordHistory appends the tests a run wrote to the history explain-test
+// reads. Failing to record them only warns: the tests are written.
+func recordHistory(tg *generator.TestGenerator, runID string, context models.RequestContext, functions []models.FunctionInfo, tests []models.GeneratedTest, confidence float64) {
+	records := tg.HistoryRecords(runID, context, functions, tests, confidence)
+	if err := generator.RecordHistory(generator.HistoryFile, records); err != nil {
+		report.Warnf("tests written but not recorded for explain-test: %v\n", err)
 	}
-	fmt.Println("4. Run 'testgen generate' to start generating tests")
+}
 
+// checkRunTests rejects --run-tests and --fail-under for runs that don't
+// write test files next to the code they cover
+func checkRunTests(cfg *config.Config) error {
+	if failUnder < 0 || failUnder > 100 {
+		return fmt.Errorf("--fail-under must be a percentage between 0 and 100, got %g", failUnder)
+	}
+	switch {
+	case dryRun, statsOnly, dumpPromptsDir != "", proposeTests, emitJSONPath != "":
+		return fmt.Errorf("--run-tests needs a run that writes test files (not --dry-run, --stats-only, --dump-prompts, --propose or --emit-json)")
+	case cfg.Output.Directory != "":
+		return fmt.Errorf("--run-tests measures the coverage of the packages under test, which needs tests written next to them (output.directory is %q)", cfg.Output.Directory)
+	}
 	return nil
 }
 
-// Config command - manage configuration
-var configCmd = &cobra.Command{
-	Use:   "config",
-	Short: "Manage configuration",
-	Long:  `View and manage testgen configuration settings.`,
+// checkToBranch rejects --to-branch for runs that don't write test files,
+// and an existing branch unless --force-branch is given
+func checkToBranch() error {
+	switch {
+	case toBranch == "":
+		return fmt.Errorf("--force-branch needs --to-branch")
+	case proposeTests, emitJSONPath != "", runTests, resumeRun:
+		return fmt.Errorf("--to-branch can't be combined with --propose, --emit-json, --run-tests or --resume")
+	}
+	return generator.CheckBranch(toBranch, forceBranch)
 }
 
-var configShowCmd = &cobra.Command{
-	Use:   "show",
-	Short: "Show current configuration",
-	RunE: func(cmd *cobra.Command, args []string) error {
-		cfg, err := loadConfig()
-		if err != nil {
-			return err
-		}
+// checkPreviewDiff rejects --preview-diff for runs that don't generate tests
+// or that do something else with them
+func checkPreviewDiff() error {
+	switch {
+	case dryRun, statsOnly, dumpPromptsDir != "":
+		return fmt.Errorf("--preview-diff generates tests to compare; it can't be combined with --dry-run, --stats-only or --dump-prompts")
+	case proposeTests, emitJSONPath != "", toBranch != "", runTests, resumeRun:
+		return fmt.Errorf("--preview-diff can't be combined with --propose, --emit-json, --to-branch, --run-tests or --resume")
+	}
+	return nil
+}
 
-		config.PrintConfig(cfg)
-		return nil
-	},
+// checkDrainQueue rejects --drain-queue for runs that don't write test
+// files, which is what draining the queue does
+func checkDrainQueue() error {
+	if dryRun || statsOnly || dumpPromptsDir != "" || proposeTests || emitJSONPath != "" || toBranch != "" || previewDiff || stdoutTests {
+		return fmt.Errorf("--drain-queue writes queued tests; it can't be combined with --dry-run, --stats-only, --dump-prompts, --propose, --emit-json, --to-branch, --preview-diff or --stdout")
+	}
+	return nil
 }
 
-var configValidateCmd = &cobra.Command{
-	Use:   "validate",
-	Short: "Validate configuration",
-	RunE: func(cmd *cobra.Command, args []string) error {
-		cfg, err := loadConfig()
+// checkWhy rejects --why unless the run only reports on the targets of a git
+// range
+func checkWhy(args []string) error {
+	switch {
+	case !dryRun && !statsOnly:
+		return fmt.Errorf("--why reports where untested functions came from; combine it with --stats-only or --dry-run")
+	case len(args) > 0 && gitRange == "":
+		return fmt.Errorf("--why attributes functions to the commits of a git range; give --range along with files")
+	}
+	return nil
+}
+
+// introducedTargets attributes each target to the commit in from..to that
+// introduced it, blaming each source file once
+func introducedTargets(from, to string, targets []models.FunctionInfo) ([]models.TargetIntroduction, error) {
+	blamer := git.NewBlamer(from, to)
+	introductions := make([]models.TargetIntroduction, 0, len(targets))
+	for _, fn := range targets {
+		// git takes an absolute path whichever directory it runs in
+		path, err := filepath.Abs(fn.SourcePath())
 		if err != nil {
-			return err
+			return nil, err
 		}
-
-		fmt.Println("Configuration is valid ✓")
-		if cfg.AI.APIKey == "" {
-			fmt.Println("Warning: No API key configured")
+		introduced, err := blamer.Introduced(path, fn.StartLine, fn.EndLine)
+		if err != nil {
+			return nil, err
 		}
+		introduction := models.TargetIntroduction{Function: analyzer.MethodName(fn), File: fn.File, Line: fn.StartLine}
+		if introduced != nil {
+			introduction.Commit, introduction.Author, introduction.Summary = introduced.Commit, introduced.Author, introduced.Summary
+		}
+		introductions = append(introductions, introduction)
+	}
+	return introductions, nil
+}
 
-		return nil
-	},
+// printIntroductions prints what --why found, a line per target
+func printIntroductions(introductions []models.TargetIntroduction) {
+	if len(introductions) == 0 {
+		return
+	}
+	report.Resultf("Introduced by:\n")
+	for _, introduction := range introductions {
+		where := fmt.Sprintf("%s (%s:%d)", introduction.Function, introduction.File, introduction.Line)
+		if introduction.Commit == "" {
+			report.Resultf("  %s: before the range\n", where)
+			continue
+		}
+		report.Resultf("  %s: %.12s %s: %s\n", where, introduction.Commit, introduction.Author, introduction.Summary)
+	}
 }
 
-func init() {
-	configCmd.AddCommand(configShowCmd)
-	configCmd.AddCommand(configValidateCmd)
+// checkStdout rejects --stdout unless the run generates tests for exactly
+// one source file and does nothing else with them
+func checkStdout(args []string) error {
+	switch {
+	case len(args) != 1 || gitRange != "":
+		return fmt.Errorf("--stdout prints a single test file; give exactly one source file and no --range")
+	case dryRun, statsOnly, dumpPromptsDir != "":
+		return fmt.Errorf("--stdout generates tests to print; it can't be combined with --dry-run, --stats-only or --dump-prompts")
+	case proposeTests, emitJSONPath != "", toBranch != "", previewDiff, runTests, resumeRun, jsonOutput:
+		return fmt.Errorf("--stdout can't be combined with --propose, --emit-json, --to-branch, --preview-diff, --run-tests, --resume or --json")
+	}
+	return nil
 }
 
-// Hooks command - manage git hooks
+// previewDiffText renders --preview-diff results: a unified diff per
+// existing test file that would change, and a line per new one
+func previewDiffText(diffs []generator.TestFileDiff) string {
+	var out strings.Builder
+	changed, created := 0, 0
+	for _, diff := range diffs {
+		switch {
+		case diff.Created:
+			created++
+			out.WriteString(fmt.Sprintf("new file %s (%d lines)\n", diff.Path, diff.Lines))
+		case diff.Diff == "":
+			out.WriteString(fmt.Sprintf("unchanged %s\n", diff.Path))
+		default:
+			changed++
+			out.WriteString(diff.Diff)
+		}
+	}
+	out.WriteString(fmt.Sprintf("Would change %d existing test files and create %d; nothing was written\n", changed, created))
+	return out.String()
+}
+
+// packageDirs lists the directories of the targets' source files, in order
+func packageDirs(functions []models.FunctionInfo) []string {
+	var dirs []string
+	seen := make(map[string]bool)
+	for _, fn := range functions {
+		dir := filepath.Dir(fn.SourcePath())
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// runCoverage measures the coverage of dirs after generating, when
+// --run-tests is set, and reports it against before. It records the changes
+// in summary and returns an error when tests fail or a package is still
+// below --fail-under.
+func runCoverage(ctx context.Context, dirs []string, before []coverage.Result, summary *runSummary) error {
+	if !runTests {
+		return nil
+	}
+
+	report.Infof("Running tests with coverage...\n")
+	after, err := coverage.Measure(ctx, dirs)
+	if err != nil {
+		return err
+	}
+	summary.Coverage = coverage.Compare(before, after)
+
+	var failed []string
+	for _, change := range summary.Coverage {
+		if change.Failed {
+			failed = append(failed, change.Package)
+		}
+	}
+	if !jsonOutput {
+		report.Summaryf("Coverage (before -> after):\n")
+		for _, change := range summary.Coverage {
+			status := ""
+			if change.Failed {
+				status = " (tests fail)"
+			}
+			report.Summaryf("  %s: %.1f%% -> %.1f%%%s\n", change.Package, change.Before, change.After, status)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("tests fail in %s", strings.Join(failed, ", "))
+	}
+	if below := coverage.Below(summary.Coverage, failUnder); len(below) > 0 {
+		names := make([]string, len(below))
+		for i, change := range below {
+			names[i] = fmt.Sprintf("%s (%.1f%%)", change.Package, change.After)
+		}
+		return fmt.Errorf("coverage is below --fail-under %g%%: %s", failUnder, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// startProgress starts tracking a run over targets in generator.ProgressFile.
+// With resume it continues an interrupted run instead, returning only the
+// targets that run didn't finish.
+func startProgress(targets []models.FunctionInfo, resume bool) ([]models.FunctionInfo, *generator.Progress, error) {
+	if resume {
+		progress, err := generator.LoadProgress(generator.ProgressFile)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var pending []models.FunctionInfo
+		for _, fn := range targets {
+			if !progress.IsDone(analyzer.QualifiedName(fn)) {
+				pending = append(pending, fn)
+			}
+		}
+		report.Infof("Resuming run from %s: %d of %d functions already done\n",
+			progress.StartedAt.Local().Format("2006-01-02 15:04"), len(targets)-len(pending), len(targets))
+		return pending, progress, nil
+	}
+
+	if _, err := os.Stat(generator.ProgressFile); err == nil {
+		report.Warnf("discarding the progress of an interrupted run (use --resume to continue it)\n")
+	}
+
+	names := make([]string, len(targets))
+	for i, fn := range targets {
+		names[i] = analyzer.QualifiedName(fn)
+	}
+	progress, err := generator.NewProgress(generator.ProgressFile, names)
+	if err != nil {
+		return nil, nil, err
+	}
+	return targets, progress, nil
+}
+
+// resumeHint tells the user how to continue a run that stopped part way
+func resumeHint(progress *generator.Progress) string {
+	if progress == nil {
+		return ""
+	}
+	return fmt.Sprintf("\n%d functions still need tests; run 'testgen generate --resume' with the same arguments to continue", progress.Remaining())
+}
+
+// batchBySource groups functions by source file, one batch per file, keeping
+// first-seen order; for targets in SortTargets order, batches follow the
+// files and each batch the functions' lines
+func batchBySource(functions []models.FunctionInfo) [][]models.FunctionInfo {
+	var batches [][]models.FunctionInfo
+	index := make(map[string]int)
+	for _, fn := range functions {
+		i, ok := index[fn.File]
+		if !ok {
+			i = len(batches)
+			index[fn.File] = i
+			batches = append(batches, nil)
+		}
+		batches[i] = append(batches[i], fn)
+	}
+	return batches
+}
+
+// goGenerateTargets adapts a run started by a //go:generate directive, where
+// go generate exports GOFILE and GOPACKAGE and runs in the package
+// directory. $GOFILE and $GOPACKAGE left unexpanded in the arguments are
+// resolved, --function accepts file.go:Func (the directive's
+// "--function $GOFILE:Func") and the directive's file is the default target,
+// rather than the git range.
+func goGenerateTargets(args []string, function string) ([]string, string) {
+	goFile := os.Getenv("GOFILE")
+	expand := func(s string) string {
+		if goFile == "" {
+			return s
+		}
+		return os.Expand(s, func(name string) string {
+			if name == "GOFILE" || name == "GOPACKAGE" {
+				return os.Getenv(name)
+			}
+			return "$" + name
+		})
+	}
+
+	var files []string
+	for _, arg := range args {
+		files = append(files, expand(arg))
+	}
+	function = expand(function)
+
+	if i := strings.LastIndex(function, ":"); i > 0 && strings.HasSuffix(function[:i], ".go") {
+		files = append(files, function[:i])
+		function = function[i+1:]
+	}
+
+	if goFile != "" && len(files) == 0 && gitRange == "" {
+		report.Verbosef("Running under go generate for %s (package %s)\n", goFile, os.Getenv("GOPACKAGE"))
+		files = []string{goFile}
+	}
+	return files, function
+}
+
+// runContext bounds a run by --timeout, where 0 means no limit, and ends it
+// on Ctrl-C or SIGTERM so in-flight requests stop cleanly
+func runContext(timeout time.Duration) (context.Context, context.CancelFunc, error) {
+	if timeout < 0 {
+		return nil, nil, fmt.Errorf("--timeout cannot be negative, got %s", timeout)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if timeout == 0 {
+		return ctx, stop, nil
+	}
+	if timeout < config.MinSensibleTimeout*time.Second {
+		report.Warnf("--timeout of %s is shorter than most AI responses take; use 0 for no limit\n", timeout)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() { cancel(); stop() }, nil
+}
+
+// skipEnvVar set to 1 makes generate exit at once, for emergency commits
+// and pushes
+const skipEnvVar = "TESTGEN_SKIP"
+
+// hookTimeoutEnvVar overrides triggers.auto.hook_timeout, as a duration
+// like 90s or in seconds
+const hookTimeoutEnvVar = "TESTGEN_HOOK_TIMEOUT"
+
+// errHookTimeout is the cause of a hook run's context ending at its deadline
+var errHookTimeout = errors.New("hook timeout")
+
+// blockingHooks are the hooks whose failure stops the commit or push, which
+// triggers.auto.enforce fails on a timeout
+var blockingHooks = []string{"pre-commit", "pre-push"}
+
+// hookRunTimeout returns the deadline of a run triggered by a hook:
+// TESTGEN_HOOK_TIMEOUT, else triggers.auto.hook_timeout. Runs not triggered
+// by a hook, or detached from it, have none.
+func hookRunTimeout(cfg *config.Config) (time.Duration, error) {
+	if triggeringHook == "" || hookBackground {
+		return 0, nil
+	}
+	value := os.Getenv(hookTimeoutEnvVar)
+	if value == "" {
+		return time.Duration(cfg.Triggers.Auto.HookTimeout) * time.Second, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, convErr := strconv.Atoi(value)
+		if convErr != nil {
+			return 0, fmt.Errorf("%s must be a duration like 90s or a number of seconds, got %q", hookTimeoutEnvVar, value)
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+	if timeout < 0 {
+		return 0, fmt.Errorf("%s cannot be negative, got %s", hookTimeoutEnvVar, value)
+	}
+	return timeout, nil
+}
+
+// hookContext ends ctx timeout after start, with errHookTimeout as the
+// cause; a timeout of 0 leaves it unbounded
+func hookContext(ctx context.Context, start time.Time, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return ctx, func() {}
+	}
+	return context.WithDeadlineCause(ctx, start.Add(timeout), errHookTimeout)
+}
+
+// hookTimedOut ends a hook run that ran out of time. A blocking hook fails
+// with a one-line message under triggers.auto.enforce; otherwise the run
+// ends cleanly so the commit or push goes through, noting what's left.
+func hookTimedOut(cfg *config.Config, timeout time.Duration, progress *generator.Progress) error {
+	if cfg.Triggers.Auto.Enforce && slices.Contains(blockingHooks, triggeringHook) {
+		return fmt.Errorf("testgen %s hook timed out after %s; set %s=1 to skip it", triggeringHook, timeout, skipEnvVar)
+	}
+	remaining := ""
+	if progress != nil {
+		remaining = fmt.Sprintf("; %d functions still need tests, run 'testgen generate --resume' to finish them", progress.Remaining())
+	}
+	report.Resultf("testgen %s hook timed out after %s%s\n", triggeringHook, timeout, remaining)
+	return nil
+}
+
+// writeHTMLReport writes the --report-html page when a path was given. A
+// failure is only a warning: the tests themselves are still written.
+func writeHTMLReport(path string, r report.HTMLReport) {
+	if path == "" {
+		return
+	}
+
+	file, err := os.Create(path)
+	if err == nil {
+		err = report.WriteHTML(file, r)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		report.Warnf("could not write HTML report %s: %v\n", path, err)
+		return
+	}
+	report.Infof("HTML report: %s\n", path)
+}
+
+// runSummary is the result of a generate run as printed by --json
+type runSummary struct {
+	Outcome           string                      `json:"outcome"`          // "generated" or "no_targets"
+	Reason            string                      `json:"reason,omitempty"` // why there were no targets
+	Stages            *analyzer.Funnel            `json:"stages,omitempty"`
+	TestsGenerated    int                         `json:"tests_generated"`
+	Functions         int                         `json:"functions"`
+	ExtendedFunctions int                         `json:"extended_functions"`
+	Confidence        generator.ConfidenceSummary `json:"confidence"`
+	Matches           map[models.MatchMethod]int  `json:"matches,omitempty"` // tests by how they were paired with their function
+	Warnings          []string                    `json:"warnings,omitempty"`
+	Coverage          []coverage.Change           `json:"coverage,omitempty"`
+	Branch            string                      `json:"branch,omitempty"` // --to-branch
+	Commit            string                      `json:"commit,omitempty"`
+	Diffs             []generator.TestFileDiff    `json:"diffs,omitempty"`      // --preview-diff
+	Timings           []models.PhaseTiming        `json:"timings,omitempty"`    // --timings
+	Comparison        []report.ModelComparison    `json:"comparison,omitempty"` // --ab-compare
+}
+
+// outcomeGenerated is the JSON outcome of a run that had targets; see
+// analyzer.OutcomeNoTargets for the other one
+const outcomeGenerated = "generated"
+
+// newRunSummary summarizes a run's AI responses
+func newRunSummary(responses []*models.TestGenerationResponse, functions, extended int) runSummary {
+	var tests []models.GeneratedTest
+	for _, response := range responses {
+		tests = append(tests, response.Tests...)
+	}
+	summary := runSummary{
+		Outcome:           outcomeGenerated,
+		Functions:         functions,
+		ExtendedFunctions: extended,
+		Confidence:        generator.SummarizeConfidence(responses),
+	}
+	if counts := generator.MatchCounts(tests); len(counts) > 0 {
+		summary.Matches = counts
+	}
+	return summary
+}
+
+// printNoTargets reports a run with nothing to generate tests for, naming
+// the stage that emptied out. It's an outcome, not an error: the run exits 0.
+func printNoTargets(funnel analyzer.Funnel) {
+	reason := funnel.NoTargetsReason()
+	summary := runSummary{Outcome: analyzer.OutcomeNoTargets, Reason: reason, Stages: &funnel}
+	printRunResult(summary, analyzer.NoTargetsMessage(reason)+"\n")
+}
+
+// excludeConfiguredTargets drops the targets filtering.side_effects and
+// filtering.include_deprecated leave out, saying why for each
+func excludeConfiguredTargets(cfg *config.Config, result *analyzer.AnalysisResult) {
+	defer report.Time(report.PhaseFilter)()
+	// Functions that only have side effects are tested unless configured otherwise
+	if cfg.Filtering.SideEffects == "skip" {
+		var skipped []models.FunctionInfo
+		result.GenerationTargets, skipped = analyzer.ExcludeSideEffectOnly(result.GenerationTargets)
+		for _, fn := range skipped {
+			report.Infof("Skipping %s: returns nothing (filtering.side_effects is 'skip')\n", fn.Name)
+		}
+	}
+
+	// Deprecated functions are on their way out; they're only tested on request
+	if !cfg.Filtering.IncludeDeprecated {
+		var skipped []models.FunctionInfo
+		result.GenerationTargets, skipped = analyzer.ExcludeDeprecated(result.GenerationTargets)
+		for _, fn := range skipped {
+			report.Infof("Skipping %s: deprecated (set filtering.include_deprecated to test it)\n", fn.Name)
+		}
+	}
+}
+
+// setupAnnotations turns CI annotations on per --annotations, detecting
+// GitHub Actions when it isn't given. Detection leaves them off under --json,
+// which keeps stdout for the summary.
+func setupAnnotations() error {
+	format := report.DetectAnnotations(annotationFormat)
+	if annotationFormat == "" && jsonOutput {
+		format = report.AnnotationsNone
+	}
+
+	// Outside a repository, paths are printed as given
+	root, err := git.TopLevel()
+	if err != nil {
+		root = ""
+	}
+	return report.SetAnnotations(format, root)
+}
+
+// warnDroppedContexts warns about targets that accept a context.Context
+// but never pass it on, since cancellation then can't reach their work
+func warnDroppedContexts(targets []models.FunctionInfo) {
+	for _, fn := range targets {
+		if fn.DroppedContext != "" {
+			message := fmt.Sprintf("%s accepts context %s but never passes it to a call; cancelling it has no effect", fn.Name, fn.DroppedContext)
+			report.Warnf("%s\n", message)
+			report.Annotate(report.Annotation{Severity: report.SeverityWarning, File: fn.File, Line: fn.StartLine, Title: "testgen: context not propagated", Message: message})
+		}
+	}
+}
+
+// reportPromptTrimming reports, per source file, the reductions its prompt
+// would need to fit ai.max_prompt_tokens
+func reportPromptTrimming(cfg *config.Config, result *analyzer.AnalysisResult) {
+	if cfg.AI.MaxPromptTokens <= 0 {
+		return
+	}
+
+	tg := generator.NewTestGenerator(cfg)
+	projectContext := analyzer.GetProjectContext(result)
+	for _, batch := range batchBySource(result.GenerationTargets) {
+		reductions, fits := tg.PromptReductions(models.TestGenerationRequest{Functions: batch, Context: projectContext})
+		if len(reductions) > 0 {
+			report.Infof("%s: would trim %s to fit ai.max_prompt_tokens\n", batch[0].File, strings.Join(reductions, ", "))
+		}
+		if !fits {
+			report.Warnf("%s: prompt would exceed ai.max_prompt_tokens even after trimming\n", batch[0].File)
+		}
+	}
+}
+
+// recordStats prints the analysis stats, as JSON with --json, and appends
+// them to analyzer.StatsFile stamped with the current commit, along with what
+// --why found
+func recordStats(result *analyzer.AnalysisResult, introductions []models.TargetIntroduction) error {
+	stats := analyzer.Stats(result)
+	stats.RecordedAt = time.Now().UTC()
+	stats.Introduced = introductions
+	if commit, err := git.HeadCommit(); err == nil {
+		stats.Commit = commit
+	}
+	if showTimings {
+		stats.Timings = report.Timings()
+	}
+
+	if err := analyzer.RecordStats(analyzer.StatsFile, stats); err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		data, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode stats: %w", err)
+		}
+		report.Resultf("%s\n", data)
+		return nil
+	}
+
+	analyzer.PrintStats(stats)
+	report.Resultf("%d functions found, %d would get tests; stats appended to %s\n",
+		stats.FunctionsFound, stats.WouldGenerate, analyzer.StatsFile)
+	printIntroductions(stats.Introduced)
+	if showTimings {
+		report.PrintTimings(stats.Timings)
+	}
+	return nil
+}
+
+// printRunResult prints the final result: the summary as JSON with --json,
+// otherwise the confidence histogram (verbose only) followed by text
+func printRunResult(summary runSummary, text string) {
+	if showTimings {
+		summary.Timings = report.Timings()
+	}
+	if !jsonOutput {
+		generator.PrintConfidenceSummary(summary.Confidence)
+		report.Resultf("%s", text)
+		if len(summary.Comparison) > 0 {
+			report.PrintComparison(summary.Comparison)
+		}
+		if showTimings {
+			report.PrintTimings(summary.Timings)
+		}
+		return
+	}
+
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		report.Errorf("failed to encode run summary: %v\n", err)
+		return
+	}
+	report.Resultf("%s\n", data)
+}
+
+// Init command - setup configuration and hooks
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Initialize testgen in current project",
+	Long: `Create default configuration file and optionally install git hooks.
+	
+This will create a .testgen.yml file with sensible defaults.`,
+	RunE: runInit,
+}
+
+var (
+	installHooks bool
+	autoMode     bool
+)
+
+func init() {
+	initCmd.Flags().BoolVar(&installHooks, "hooks", false, "install git hooks for auto mode")
+	initCmd.Flags().BoolVar(&autoMode, "auto", false, "set up for auto mode")
+}
+
+func runInit(cmd *cobra.Command, args []string) error {
+	release, err := acquireProjectLock("init")
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// Check if config already exists
+	if _, err := os.Stat(config.DefaultConfigFile); err == nil {
+		fmt.Printf("Configuration file %s already exists.\n", config.DefaultConfigFile)
+		return nil
+	}
+
+	// Create default config
+	cfg := config.DefaultConfig()
+
+	// Modify based on flags
+	if autoMode {
+		cfg.Mode = "auto"
+		cfg.Hooks = []string{"post-commit"}
+	}
+
+	// Save configuration
+	if err := config.SaveConfig(cfg); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+
+	fmt.Printf("Created configuration file: %s\n", config.DefaultConfigFile)
+
+	// Install hooks if requested
+	if installHooks {
+		if err := installGitHooks(cfg); err != nil {
+			return fmt.Errorf("failed to install git hooks: %w", err)
+		}
+		fmt.Println("Git hooks installed successfully")
+	}
+
+	// Show next steps
+	fmt.Println("\nNext steps:")
+	fmt.Printf("1. Edit %s to customize settings\n", config.DefaultConfigFile)
+	fmt.Println("2. Set TESTGEN_API_KEY environment variable")
+	if !installHooks && autoMode {
+		fmt.Println("3. Run 'testgen hooks install' to enable auto mode")
+	}
+	fmt.Println("4. Run 'testgen generate' to start generating tests")
+
+	return nil
+}
+
+// Config command - manage configuration
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage configuration",
+	Long:  `View and manage testgen configuration settings.`,
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show current configuration",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		if configShowSource {
+			fmt.Println(configSource())
+			return nil
+		}
+		config.PrintConfig(cfg)
+		return nil
+	},
+}
+
+var configShowSource bool
+
+func init() {
+	configShowCmd.Flags().BoolVar(&configShowSource, "source", false, "only print which config file is used and why")
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate configuration",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("Configuration is valid ✓")
+		if cfg.AI.APIKey == "" && cfg.AI.Provider != "local" {
+			fmt.Println("Warning: No API key configured")
+		}
+
+		return nil
+	},
+}
+
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print a JSON Schema for the config file",
+	Long: `Print a JSON Schema describing .testgen.yml: every field with its type,
+default and allowed values. Point an editor or CI validator at it to check
+config files and offer completion.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		schema, err := config.JSONSchema()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), string(schema))
+		return nil
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configSchemaCmd)
+}
+
+// Hooks command - manage git hooks
 var hooksCmd = &cobra.Command{
 	Use:   "hooks",
 	Short: "Manage git hooks",
 	Long:  `Install, uninstall, or check git hooks for auto mode.`,
 }
 
-var hooksInstallCmd = &cobra.Command{
-	Use:   "install",
-	Short: "Install git hooks",
+var hooksInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Install git hooks",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		release, err := acquireProjectLock("hooks install")
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		return installGitHooks(cfg)
+	},
+}
+
+var hooksUninstallCmd = &cobra.Command{
+	Use:   "uninstall",
+	Short: "Uninstall git hooks",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		release, err := acquireProjectLock("hooks uninstall")
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		return uninstallGitHooks()
+	},
+}
+
+var hooksStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show git hooks status",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return showHooksStatus()
+	},
+}
+
+func init() {
+	hooksCmd.AddCommand(hooksInstallCmd)
+	hooksCmd.AddCommand(hooksUninstallCmd)
+	hooksCmd.AddCommand(hooksStatusCmd)
+}
+
+// Status command - show overall status
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show testgen status",
+	Long:  `Show configuration, git hooks status, and recent activity.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		fmt.Printf("Testgen Status\n")
+		fmt.Printf("==============\n")
+		fmt.Printf("Version: %s\n", version)
+		fmt.Printf("Mode: %s\n", cfg.Mode)
+		fmt.Printf("AI Provider: %s (%s)\n", cfg.AI.Provider, cfg.AI.Model)
+
+		if cfg.AI.APIKey != "" {
+			fmt.Printf("API Key: configured ✓\n")
+		} else if cfg.AI.Provider == "local" {
+			fmt.Printf("API Key: not needed (local)\n")
+		} else {
+			fmt.Printf("API Key: not configured ✗\n")
+		}
+
+		fmt.Printf("\nGit Hooks:\n")
+		if err := showHooksStatus(); err != nil {
+			fmt.Printf("  Error checking hooks: %v\n", err)
+		}
+
+		// Show recent changes
+		fmt.Printf("\nRecent Changes:\n")
+		analyzer.SetFiltering(cfg.Filtering)
+		result, err := analyzer.AnalyzeChanges("HEAD~1", "HEAD")
+		if err != nil {
+			fmt.Printf("  Error analyzing recent changes: %v\n", err)
+		} else {
+			excludeConfiguredTargets(cfg, result)
+			if reason := result.Funnel().NoTargetsReason(); reason != "" {
+				fmt.Printf("  %s\n", analyzer.NoTargetsMessage(reason))
+			} else {
+				fmt.Printf("  %d functions ready for test generation\n", len(result.GenerationTargets))
+			}
+		}
+
+		return nil
+	},
+}
+
+// proposalsDir is where generate --propose writes runs for approve to read
+const proposalsDir = generator.ProposalsDir
+
+var approveOnly []string
+
+// Approve command - moves proposed tests into their test files
+var approveCmd = &cobra.Command{
+	Use:   "approve <run-id>",
+	Short: "Write approved tests from a proposal into their test files",
+	Long: `Write the tests of a proposal made with generate --propose into their
+real test files, merging like a normal generate run. Tests whose source file
+changed since the proposal are left pending; regenerate them instead.
+
+Examples:
+  testgen approve 20261017-120000                       # Approve every test
+  testgen approve 20261017-120000 --only TestParse,TestRun # Approve some tests`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		report.SetLevel(outputLevel(cfg))
+
+		release, err := acquireProjectLock("approve")
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		result, err := generator.NewTestGenerator(cfg).ApproveProposal(proposalsDir, args[0], approveOnly)
+		if err != nil {
+			return fmt.Errorf("failed to approve %s: %w", args[0], err)
+		}
+
+		for _, name := range result.Conflicts {
+			report.Warnf("%s not approved: its source changed since the proposal\n", name)
+		}
+		if result.Remaining > 0 {
+			report.Resultf("Approved %d tests; %d still pending in %s\n", len(result.Approved), result.Remaining, args[0])
+		} else {
+			report.Resultf("Approved %d tests; proposal %s is done\n", len(result.Approved), args[0])
+		}
+		return nil
+	},
+}
+
+func init() {
+	approveCmd.Flags().StringSliceVar(&approveOnly, "only", nil, "approve only these tests (comma-separated names)")
+}
+
+// Proposals command - inspects pending proposals
+var proposalsCmd = &cobra.Command{
+	Use:   "proposals",
+	Short: "Manage proposed tests awaiting approval",
+}
+
+var proposalsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List pending proposals",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cfg, err := loadConfig()
+		proposals, err := generator.ListProposals(proposalsDir)
 		if err != nil {
 			return err
 		}
+		if len(proposals) == 0 {
+			fmt.Printf("No pending proposals\n")
+			return nil
+		}
 
-		return installGitHooks(cfg)
+		for _, proposal := range proposals {
+			fmt.Printf("%s  %s  %d tests\n", proposal.RunID, proposal.CreatedAt.Local().Format("2006-01-02 15:04"), len(proposal.Tests))
+			for _, proposed := range proposal.Tests {
+				fmt.Printf("  %s -> %s\n", proposed.Name, proposed.Destination)
+			}
+		}
+		return nil
 	},
 }
 
-var hooksUninstallCmd = &cobra.Command{
-	Use:   "uninstall",
-	Short: "Uninstall git hooks",
+func init() {
+	proposalsCmd.AddCommand(proposalsListCmd)
+}
+
+// queueDir is where runs wait while the AI provider is unreachable
+const queueDir = generator.QueueDir
+
+// Queue command - runs deferred while the provider was unreachable
+var queueCmd = &cobra.Command{
+	Use:   "queue",
+	Short: "Manage runs queued while the AI provider was unreachable",
+	Long: `With triggers.auto.queue_on_failure, a generate run that can't reach the
+AI provider saves its analyzed targets to ` + queueDir + ` instead of failing.
+Run them once you're back online, or pass --drain-queue to the next generate.`,
+}
+
+var queueListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List queued runs with their age",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return uninstallGitHooks()
+		runs, err := generator.ListQueue(queueDir)
+		if err != nil {
+			return err
+		}
+		if len(runs) == 0 {
+			fmt.Printf("No queued runs\n")
+			return nil
+		}
+
+		now := time.Now()
+		for _, run := range runs {
+			fmt.Printf("%s  queued %s  %d functions\n", run.ID, run.Age(now), len(run.Functions))
+			for _, fn := range run.Functions {
+				if run.Stale(fn) {
+					fmt.Printf("  %s (source changed since; analyzed again when run)\n", analyzer.QualifiedName(fn))
+				} else {
+					fmt.Printf("  %s\n", analyzer.QualifiedName(fn))
+				}
+			}
+		}
+		return nil
 	},
 }
 
-var hooksStatusCmd = &cobra.Command{
-	Use:   "status",
-	Short: "Show git hooks status",
+var queueRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Generate and write the tests of queued runs",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return showHooksStatus()
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		report.SetLevel(outputLevel(cfg))
+
+		release, err := acquireProjectLock("queue run")
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		analyzer.SetFiltering(cfg.Filtering)
+		written, err := runQueue(cfg)
+		if err != nil {
+			return err
+		}
+		report.Resultf("Wrote %d queued tests\n", written)
+		return nil
 	},
 }
 
 func init() {
-	hooksCmd.AddCommand(hooksInstallCmd)
-	hooksCmd.AddCommand(hooksUninstallCmd)
-	hooksCmd.AddCommand(hooksStatusCmd)
+	queueCmd.AddCommand(queueListCmd)
+	queueCmd.AddCommand(queueRunCmd)
 }
 
-// Status command - show overall status
-var statusCmd = &cobra.Command{
-	Use:   "status",
-	Short: "Show testgen status",
-	Long:  `Show configuration, git hooks status, and recent activity.`,
-	RunE: func(cmd *cobra.Command, args []string) error {
-		cfg, err := loadConfig()
+// queueable reports whether a run that failed with err is queued for later
+// instead: the provider was unreachable and triggers.auto.queue_on_failure
+// asks for it
+func queueable(cfg *config.Config, err error) bool {
+	return cfg.Triggers.Auto.QueueOnFailure && generator.ProviderUnreachable(err)
+}
+
+// enqueueRun saves the targets of a run the provider couldn't be reached
+// for, reporting cause, and ends the run without failing it
+func enqueueRun(request models.TestGenerationRequest, progress *generator.Progress, cause error) error {
+	run, err := generator.Enqueue(queueDir, request)
+	if err != nil {
+		return fmt.Errorf("failed to queue run after %v: %w", cause, err)
+	}
+	// The queue now holds what the progress would have resumed
+	if err := progress.Finish(); err != nil {
+		return err
+	}
+	report.Warnf("AI provider unreachable: %v\n", cause)
+	report.Resultf("Queued %d functions as %s; run testgen queue run once online\n", len(request.Functions), run.ID)
+	return nil
+}
+
+// runQueue generates and writes the tests of queued runs, oldest first,
+// removing each once its tests are written. A target queued by several
+// runs is generated once, from the latest. It stops at the first failure,
+// keeping what's left of that run and the later ones queued.
+func runQueue(cfg *config.Config) (int, error) {
+	runs, err := generator.ListQueue(queueDir)
+	if err != nil || len(runs) == 0 {
+		return 0, err
+	}
+
+	ctx, cancel, err := runContext(runTimeout)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+	tg := generator.NewTestGenerator(cfg)
+	tg.SetContext(ctx)
+
+	latest := latestQueued(runs)
+	written := 0
+	for _, run := range runs {
+		targets, err := queuedTargets(run, latest)
 		if err != nil {
-			return fmt.Errorf("failed to load config: %w", err)
+			return written, err
+		}
+		report.Infof("Generating tests for %d functions queued %s...\n", len(targets), run.Age(time.Now()))
+
+		for _, batch := range batchBySource(targets) {
+			response, err := tg.GenerateTests(models.TestGenerationRequest{
+				Functions: batch,
+				Context:   run.Context,
+				TestType:  run.TestType,
+			})
+			if err == nil {
+				pairedFunctions, pairedTests := tg.PairTests(batch, response.Tests)
+				if err = tg.WriteTestFiles(pairedFunctions, pairedTests); err == nil {
+					recordHistory(tg, run.ID, run.Context, pairedFunctions, pairedTests, response.Confidence)
+					written += len(pairedTests)
+					run.Functions = slices.DeleteFunc(run.Functions, func(fn models.FunctionInfo) bool {
+						return slices.ContainsFunc(batch, func(done models.FunctionInfo) bool {
+							return analyzer.QualifiedName(done) == analyzer.QualifiedName(fn)
+						})
+					})
+					continue
+				}
+			}
+			if saveErr := run.Save(queueDir); saveErr != nil {
+				return written, errors.Join(err, saveErr)
+			}
+			return written, fmt.Errorf("failed to generate queued run %s: %w", run.ID, err)
 		}
 
-		fmt.Printf("Testgen Status\n")
-		fmt.Printf("==============\n")
-		fmt.Printf("Version: %s\n", version)
-		fmt.Printf("Mode: %s\n", cfg.Mode)
-		fmt.Printf("AI Provider: %s (%s)\n", cfg.AI.Provider, cfg.AI.Model)
+		if err := run.Remove(queueDir); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
 
-		if cfg.AI.APIKey != "" {
-			fmt.Printf("API Key: configured ✓\n")
-		} else {
-			fmt.Printf("API Key: not configured ✗\n")
+// latestQueued maps each queued target to the ID of the latest run holding it
+func latestQueued(runs []*generator.QueuedRun) map[string]string {
+	latest := make(map[string]string)
+	for _, run := range runs {
+		for _, fn := range run.Functions {
+			latest[analyzer.QualifiedName(fn)] = run.ID
 		}
+	}
+	return latest
+}
 
-		fmt.Printf("\nGit Hooks:\n")
-		if err := showHooksStatus(); err != nil {
-			fmt.Printf("  Error checking hooks: %v\n", err)
+// queuedTargets returns the targets a queued run still has to generate:
+// those no later run queued again, with targets whose source changed since
+// analyzed again, and dropped if they're gone or now filtered out
+func queuedTargets(run *generator.QueuedRun, latest map[string]string) ([]models.FunctionInfo, error) {
+	var targets []models.FunctionInfo
+	stale := make(map[string][]string) // file -> names of its changed targets
+	sources := make(map[string]string) // file -> path to read it from here
+	for _, fn := range run.Functions {
+		name := analyzer.QualifiedName(fn)
+		switch {
+		case latest[name] != run.ID:
+			report.Verbosef("Skipping queued %s: queued again later\n", name)
+		case run.Stale(fn):
+			stale[fn.File] = append(stale[fn.File], name)
+			sources[fn.File] = fn.SourcePath()
+		default:
+			targets = append(targets, fn)
 		}
+	}
 
-		// Show recent changes
-		fmt.Printf("\nRecent Changes:\n")
-		result, err := analyzer.AnalyzeChanges("HEAD~1", "HEAD")
-		if err != nil {
-			fmt.Printf("  Error analyzing recent changes: %v\n", err)
-		} else {
-			if len(result.GenerationTargets) > 0 {
-				fmt.Printf("  %d functions ready for test generation\n", len(result.GenerationTargets))
+	files := make([]string, 0, len(stale))
+	for file := range stale {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		fresh := make(map[string]models.FunctionInfo)
+		if _, err := os.Stat(sources[file]); err == nil {
+			result, err := analyzer.Analyze(analyzer.Sources{Files: []string{sources[file]}})
+			if err != nil {
+				return nil, fmt.Errorf("failed to analyze queued %s again: %w", file, err)
+			}
+			for _, fn := range result.GenerationTargets {
+				fresh[analyzer.QualifiedName(fn)] = fn
+			}
+		}
+		for _, name := range stale[file] {
+			if fn, ok := fresh[name]; ok {
+				report.Verbosef("Analyzed queued %s again: its source changed\n", name)
+				targets = append(targets, fn)
 			} else {
-				fmt.Printf("  No functions need test generation\n")
+				report.Infof("Dropping queued %s: gone from its source or filtered out since\n", name)
 			}
 		}
+	}
+	analyzer.SortTargets(targets)
+	return targets, nil
+}
+
+// Clean command - removes files earlier runs left behind
+var cleanCmd = &cobra.Command{
+	Use:   "clean",
+	Short: "Remove files left behind by earlier runs",
+	Long: `Remove files earlier runs left behind in the repository.
+
+Examples:
+  testgen clean --backups           # Remove .backup files of overwritten tests
+  testgen clean --backups --dry-run # List them without removing anything`,
+	RunE: runClean,
+}
+
+var cleanBackups bool
+
+func init() {
+	cleanCmd.Flags().BoolVar(&cleanBackups, "backups", false, "remove the .backup files written before overwriting test files")
+}
+
+func runClean(cmd *cobra.Command, args []string) error {
+	if !cleanBackups {
+		return fmt.Errorf("nothing to clean: pass --backups to remove .backup files")
+	}
+
+	root := repoDir
+	if root == "" {
+		root = "."
+	}
+	backups, err := generator.FindBackups(root)
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		report.Resultf("No backups to remove\n")
+		return nil
+	}
+
+	var failures []error
+	for _, backup := range backups {
+		if dryRun {
+			report.Infof("Would remove %s\n", backup)
+			continue
+		}
+		if err := os.Remove(backup); err != nil {
+			failures = append(failures, err)
+			continue
+		}
+		report.Infof("Removed %s\n", backup)
+	}
+
+	if dryRun {
+		report.Resultf("%d backups would be removed\n", len(backups))
+		return nil
+	}
+	report.Resultf("Removed %d of %d backups\n", len(backups)-len(failures), len(backups))
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to remove %d backups: %w", len(failures), errors.Join(failures...))
+	}
+	return nil
+}
+
+// Explain-test command - traces a test back to the run that wrote it
+var explainTestCmd = &cobra.Command{
+	Use:   "explain-test <file_test.go:TestName>",
+	Short: "Show which run wrote a test and whether its function changed since",
+	Long: `Look up the generate run that last wrote a test in the local history
+(` + generator.HistoryFile + `) and print its run id, model, confidence and
+what the prompt said about the function under test. The function is compared
+with the one the test was generated from, so tests of changed functions are
+flagged as possibly stale. Nothing is fetched: only local state is read.
+
+Examples:
+  testgen explain-test user/user_test.go:TestValidateUser_EmptyEmail`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		testFile, testName, ok := strings.Cut(args[0], ":")
+		if !ok || testFile == "" || testName == "" {
+			return fmt.Errorf("expected file_test.go:TestName, got %q", args[0])
+		}
 
+		explanation, err := generator.ExplainTest(generator.HistoryFile, testFile, testName)
+		if err != nil {
+			return err
+		}
+		generator.PrintExplanation(explanation)
 		return nil
 	},
 }
 
+// Prompt command - shows what generate would ask the AI
+var promptCmd = &cobra.Command{
+	Use:   "prompt <files...>",
+	Short: "Print the prompt generate would send for functions",
+	Long: `Analyze files like generate and print the prompt each source file's
+functions would be sent with, without calling the AI or using the API key.
+Useful for iterating on templates and instructions.
+
+Examples:
+  testgen prompt user.go --function ValidateUser # One function's prompt
+  testgen prompt user.go --system                # Include the system message
+  testgen prompt user.go --format json           # The structured request
+  testgen prompt user.go --function ValidateUser --copy # Also copy it to the clipboard`,
+	RunE: runPrompt,
+}
+
+var (
+	promptFunction string
+	promptSystem   bool
+	promptCopy     bool
+	promptFormat   string
+)
+
+func init() {
+	promptCmd.Flags().StringVar(&promptFunction, "function", "", "only this function, optionally as file.go:Func")
+	promptCmd.Flags().BoolVar(&promptSystem, "system", false, "also print the provider's system message")
+	promptCmd.Flags().BoolVar(&promptCopy, "copy", false, "also copy the output to the clipboard, where supported")
+	promptCmd.Flags().StringVar(&promptFormat, "format", "text", "output format: text, or json for the structured request")
+}
+
+func runPrompt(cmd *cobra.Command, args []string) error {
+	if promptFormat != "text" && promptFormat != "json" {
+		return fmt.Errorf("--format must be 'text' or 'json', got '%s'", promptFormat)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	// The prompt never reaches the API, so the key isn't kept around
+	cfg.AI.APIKey = ""
+	report.SetLevel(outputLevel(cfg))
+	analyzer.SetFiltering(cfg.Filtering)
+	analyzer.SetRecentCommits(cfg.AI.RecentCommits)
+
+	files, function := goGenerateTargets(args, promptFunction)
+	if len(files) == 0 {
+		return fmt.Errorf("name the files to build prompts for, or use --function file.go:Func")
+	}
+	sources := analyzer.Sources{Files: files}
+	if function != "" {
+		sources.Functions = []string{function}
+	}
+	result, err := analyzer.Analyze(sources)
+	if err != nil {
+		return err
+	}
+
+	// Functions the filters exclude still get a prompt when asked for
+	targets := result.GenerationTargets
+	if len(targets) == 0 {
+		for _, file := range result.ChangedFiles {
+			targets = append(targets, file.FunctionDetails...)
+		}
+		if len(targets) > 0 {
+			report.Warnf("generate would skip these functions under the current filtering; showing their prompts anyway\n")
+		}
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("no functions found to build a prompt for")
+	}
+
+	batches := batchBySource(targets)
+	projectContext := analyzer.GetProjectContext(result)
+	var out strings.Builder
+	for i, batch := range batches {
+		request := models.TestGenerationRequest{Functions: batch, Context: projectContext}
+		if promptFormat == "json" {
+			data, err := json.MarshalIndent(request, "", "  ")
+			if err != nil {
+				return fmt.Errorf("failed to encode request: %w", err)
+			}
+			out.WriteString(string(data) + "\n")
+			continue
+		}
+
+		preview := generator.PreviewRequest(cfg, request)
+		if len(batches) > 1 {
+			if i > 0 {
+				out.WriteString("\n")
+			}
+			out.WriteString(fmt.Sprintf("=== %s ===\n", batch[0].File))
+		}
+		if promptSystem {
+			system := preview.System
+			if system == "" {
+				system = fmt.Sprintf("(%s gets no system message)", cfg.AI.Provider)
+			}
+			out.WriteString("--- system ---\n" + system + "\n--- prompt ---\n")
+		}
+		out.WriteString(strings.TrimRight(preview.Prompt, "\n") + "\n")
+	}
+
+	report.Resultf("%s", out.String())
+	if promptCopy {
+		if err := copyToClipboard(out.String()); err != nil {
+			report.Warnf("could not copy to the clipboard: %v\n", err)
+		} else {
+			report.Infof("Copied to the clipboard\n")
+		}
+	}
+	return nil
+}
+
+// clipboardCommands are the clipboard tools tried by copyToClipboard, per OS
+var clipboardCommands = map[string][][]string{
+	"darwin":  {{"pbcopy"}},
+	"windows": {{"clip"}},
+	"linux":   {{"wl-copy"}, {"xclip", "-selection", "clipboard"}, {"xsel", "--clipboard", "--input"}},
+}
+
+// copyToClipboard pipes text into the first clipboard tool available
+func copyToClipboard(text string) error {
+	candidates := clipboardCommands[runtime.GOOS]
+	for _, candidate := range candidates {
+		path, err := exec.LookPath(candidate[0])
+		if err != nil {
+			continue
+		}
+		command := exec.Command(path, candidate[1:]...)
+		command.Stdin = strings.NewReader(text)
+		if output, err := command.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s failed: %w: %s", candidate[0], err, strings.TrimSpace(string(output)))
+		}
+		return nil
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("not supported on %s", runtime.GOOS)
+	}
+	names := make([]string, len(candidates))
+	for i, candidate := range candidates {
+		names[i] = candidate[0]
+	}
+	return fmt.Errorf("none of %s found", strings.Join(names, ", "))
+}
+
 // Helper functions
 
+// acquireProjectLock keeps mutating commands from running concurrently in
+// the project (hooks firing during a rebase, say), per --wait and
+// --steal-stale. Read-only commands don't take it.
+func acquireProjectLock(command string) (func(), error) {
+	// One lock per project, wherever in it testgen runs; --repo may not be
+	// applied to git yet
+	root := repoDir
+	if root == "" {
+		root = analyzer.ProjectRoot()
+	}
+	projectLock, err := lock.Acquire(filepath.Join(root, lock.File), lock.Options{
+		Command:    command,
+		Wait:       lockWait,
+		StealStale: stealStale,
+		Waiting: func(holder lock.Info) {
+			report.Infof("Waiting up to %s for another testgen run (%s, pid %d)...\n", lockWait, holder.Command, holder.PID)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		if err := projectLock.Release(); err != nil {
+			report.Warnf("%v\n", err)
+		}
+	}, nil
+}
+
 func loadConfig() (*config.Config, error) {
-	if configFile != "" {
-		return config.LoadConfigFromFile(configFile)
+	var cfg *config.Config
+	var err error
+
+	config.SetOffline(offline)
+	if configDebug {
+		printConfigSearch(os.Stderr)
+	}
+	switch {
+	case configFromEnv || (configFile == "" && config.ConfigFromEnvRequested()):
+		cfg, err = config.LoadConfigFromEnv()
+	case configFile != "":
+		cfg, err = config.LoadConfigFromFile(configFile)
+	default:
+		cfg, err = config.LoadConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Point every git invocation at the configured binary and repository
+	git.Configure(cfg.Git.Binary, repoDir)
+
+	return cfg, nil
+}
+
+// configSource describes which config file loadConfig reads and why
+func configSource() string {
+	switch {
+	case configFromEnv || (configFile == "" && config.ConfigFromEnvRequested()):
+		return "none: defaults and TESTGEN_* environment variables (--config-from-env)"
+	case configFile != "":
+		return configFile + " (--config)"
+	}
+	if chosen := config.ChosenConfig(config.ConfigLocations(".")); chosen != nil {
+		return fmt.Sprintf("%s (%s)", chosen.Path, chosen.Reason)
 	}
-	return config.LoadConfig()
+	return "none found: defaults and TESTGEN_* environment variables"
 }
 
-func parseGitRange(rangeFlag string, cfg *config.Config) (string, string) {
-	if rangeFlag != "" {
-		parts := strings.Split(rangeFlag, "..")
-		if len(parts) == 2 {
-			return parts[0], parts[1]
+// printConfigSearch prints every location a config file is looked for, in
+// order, marking the one used (--config-debug)
+func printConfigSearch(w io.Writer) {
+	locations := config.ConfigLocations(".")
+	chosen := config.ChosenConfig(locations)
+	if configFile != "" || configFromEnv || config.ConfigFromEnvRequested() {
+		chosen = nil // the search is skipped
+	}
+
+	fmt.Fprintf(w, "Config locations, in order:\n")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, location := range locations {
+		status := "not found"
+		if chosen != nil && location.Path == chosen.Path {
+			status = "found, used"
+		} else if location.Found {
+			status = "found, not used"
 		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", location.Reason, location.Path, status)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "Using: %s\n", configSource())
+}
+
+// outputLevel picks the verbosity from the output flags. Auto mode (hooks)
+// defaults to quiet.
+func outputLevel(cfg *config.Config) report.Level {
+	switch {
+	case quiet, jsonOutput:
+		return report.Quiet
+	case summaryOnly:
+		return report.Summary
+	case verbose:
+		return report.Verbose
+	case cfg.IsAutoMode():
+		return report.Quiet
+	default:
+		return report.Normal
+	}
+}
+
+// parseGitRange resolves --range, or the configured default range, to the
+// refs to diff. It accepts "from..to", "from...to" (diffed from their merge
+// base, as git does) and a single ref meaning "ref..HEAD"; an empty side
+// means HEAD. Every ref is verified to exist before analysis.
+func parseGitRange(rangeFlag string, cfg *config.Config) (string, string, error) {
+	spec := rangeFlag
+	if spec == "" {
+		spec = cfg.Triggers.Manual.DefaultRange
+	}
+	if spec == "" {
+		spec = "HEAD~1..HEAD"
+	}
+
+	from, to, threeDot := spec, "HEAD", false
+	if parts := strings.SplitN(spec, "...", 2); len(parts) == 2 {
+		from, to, threeDot = parts[0], parts[1], true
+	} else if parts := strings.SplitN(spec, "..", 2); len(parts) == 2 {
+		from, to = parts[0], parts[1]
+	}
+	if from == "" {
+		from = "HEAD"
+	}
+	if to == "" {
+		to = "HEAD"
 	}
 
-	// Use default from config
-	defaultRange := cfg.Triggers.Manual.DefaultRange
-	parts := strings.Split(defaultRange, "..")
-	if len(parts) == 2 {
-		return parts[0], parts[1]
+	var invalid []string
+	for _, ref := range []string{from, to} {
+		if !git.VerifyRef(ref) {
+			invalid = append(invalid, ref)
+		}
+	}
+	if len(invalid) > 0 {
+		return "", "", fmt.Errorf("invalid git range %q: unknown ref %s", spec, strings.Join(invalid, ", "))
 	}
 
-	// Fallback
-	return "HEAD~1", "HEAD"
+	if threeDot {
+		base, err := git.MergeBase(from, to)
+		if err != nil {
+			return "", "", err
+		}
+		from = base
+	}
+	return from, to, nil
 }
 
+// testgenHooks are the hooks uninstall and status look for
+var testgenHooks = []string{"post-commit", "pre-push", "pre-commit"}
+
 func installGitHooks(cfg *config.Config) error {
-	// Check if .git directory exists
-	if _, err := os.Stat(".git"); os.IsNotExist(err) {
-		return fmt.Errorf("not a git repository")
+	// Hooks live under the repository root, wherever testgen runs from
+	hooksDir, err := git.HooksDir()
+	if err != nil {
+		return err
 	}
 
 	// Create hooks directory if it doesn't exist
-	hooksDir := ".git/hooks"
 	if err := os.MkdirAll(hooksDir, 0755); err != nil {
 		return fmt.Errorf("failed to create hooks directory: %w", err)
 	}
 
+	// Hooks run testgen by absolute path, since GUI clients often run them
+	// without the shell's PATH
+	binary, err := os.Executable()
+	if err != nil {
+		binary = "testgen"
+	}
+
 	// Install each configured hook
 	for _, hookName := range cfg.Hooks {
-		hookPath := fmt.Sprintf("%s/%s", hooksDir, hookName)
-
-		// Create hook script
-		hookContent := fmt.Sprintf(`#!/bin/sh
-# testgen %s hook
-exec testgen generate
-`, hookName)
+		hookPath := filepath.Join(hooksDir, hookName)
 
-		if err := os.WriteFile(hookPath, []byte(hookContent), 0755); err != nil {
+		script := hookScript(hookName, binary, cfg.Triggers.Auto.Background)
+		if err := os.WriteFile(hookPath, []byte(script), 0755); err != nil {
 			return fmt.Errorf("failed to install %s hook: %w", hookName, err)
 		}
 
@@ -426,12 +2330,36 @@ exec testgen generate
 	return nil
 }
 
+// hookScript is the script of a testgen hook running binary, quoted so
+// paths with spaces survive the shell. With background, a post-commit hook
+// starts the run detached and returns at once; the other hooks always wait,
+// since their outcome decides the commit or push.
+func hookScript(hookName, binary string, background bool) string {
+	if background && hookName == "post-commit" {
+		return fmt.Sprintf(`#!/bin/sh
+# testgen %s hook
+nohup %s generate --quiet --hook %s --hook-background >/dev/null 2>&1 &
+`, hookName, shellQuote(binary), hookName)
+	}
+	return fmt.Sprintf(`#!/bin/sh
+# testgen %s hook
+exec %s generate --quiet --hook %s
+`, hookName, shellQuote(binary), hookName)
+}
+
+// shellQuote quotes s as a single sh word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
 func uninstallGitHooks() error {
-	hooksDir := ".git/hooks"
-	hookNames := []string{"post-commit", "pre-push", "pre-commit"}
+	hooksDir, err := git.HooksDir()
+	if err != nil {
+		return err
+	}
 
-	for _, hookName := range hookNames {
-		hookPath := fmt.Sprintf("%s/%s", hooksDir, hookName)
+	for _, hookName := range testgenHooks {
+		hookPath := filepath.Join(hooksDir, hookName)
 
 		// Check if it's our hook
 		if content, err := os.ReadFile(hookPath); err == nil {
@@ -449,11 +2377,13 @@ func uninstallGitHooks() error {
 }
 
 func showHooksStatus() error {
-	hooksDir := ".git/hooks"
-	hookNames := []string{"post-commit", "pre-push", "pre-commit"}
+	hooksDir, err := git.HooksDir()
+	if err != nil {
+		return err
+	}
 
-	for _, hookName := range hookNames {
-		hookPath := fmt.Sprintf("%s/%s", hooksDir, hookName)
+	for _, hookName := range testgenHooks {
+		hookPath := filepath.Join(hooksDir, hookName)
 
 		if _, err := os.Stat(hookPath); err == nil {
 			// Check if it's our hook
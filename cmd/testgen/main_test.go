@@ -1,15 +1,81 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/Eranmonnie/testgen/internal/analyzer"
 	"github.com/Eranmonnie/testgen/internal/config"
+	"github.com/Eranmonnie/testgen/internal/generator"
+	"github.com/Eranmonnie/testgen/internal/git"
+	"github.com/Eranmonnie/testgen/internal/lock"
+	"github.com/Eranmonnie/testgen/internal/report"
+	"github.com/Eranmonnie/testgen/pkg/models"
 )
 
+// initRangeRepo creates a repository with three commits on main and a
+// feature branch forked from the second, and points git at it for the test
+func initRangeRepo(t *testing.T) (repo, forkPoint string) {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repo = t.TempDir()
+	run := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+	commit := func(message string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(repo, "log.txt"), []byte(message), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		run("add", "log.txt")
+		run("commit", "-q", "-m", message)
+	}
+
+	run("init", "-q")
+	run("config", "user.email", "test@example.com")
+	run("config", "user.name", "Test User")
+	run("config", "commit.gpgsign", "false")
+	commit("first")
+	run("branch", "-M", "main")
+	commit("second")
+	forkPoint = run("rev-parse", "HEAD")
+	run("checkout", "-q", "-b", "feature")
+	commit("feature work")
+	run("checkout", "-q", "main")
+	commit("third")
+
+	originalDir := git.RepoDir
+	t.Cleanup(func() { git.RepoDir = originalDir })
+	git.RepoDir = repo
+	return repo, forkPoint
+}
+
 func TestParseGitRange(t *testing.T) {
+	_, forkPoint := initRangeRepo(t)
+
 	cfg := &config.Config{
 		Triggers: config.TriggerConfig{
 			Manual: config.ManualTrigger{
@@ -23,9 +89,10 @@ func TestParseGitRange(t *testing.T) {
 		rangeFlag    string
 		expectedFrom string
 		expectedTo   string
+		expectedErr  string
 	}{
 		{
-			name:         "explicit range",
+			name:         "two-dot range",
 			rangeFlag:    "main..feature",
 			expectedFrom: "main",
 			expectedTo:   "feature",
@@ -37,16 +104,52 @@ func TestParseGitRange(t *testing.T) {
 			expectedTo:   "HEAD",
 		},
 		{
-			name:         "HEAD range",
-			rangeFlag:    "HEAD~3..HEAD",
-			expectedFrom: "HEAD~3",
+			name:         "three-dot range diffs from the merge base",
+			rangeFlag:    "main...feature",
+			expectedFrom: forkPoint,
+			expectedTo:   "feature",
+		},
+		{
+			name:         "single ref means ref..HEAD",
+			rangeFlag:    "HEAD~1",
+			expectedFrom: "HEAD~1",
+			expectedTo:   "HEAD",
+		},
+		{
+			name:         "open-ended range",
+			rangeFlag:    "feature..",
+			expectedFrom: "feature",
 			expectedTo:   "HEAD",
 		},
+		{
+			name:        "invalid single ref",
+			rangeFlag:   "abc123",
+			expectedErr: "unknown ref abc123",
+		},
+		{
+			name:        "typo in one side",
+			rangeFlag:   "main..featuer",
+			expectedErr: "unknown ref featuer",
+		},
+		{
+			name:        "both sides invalid",
+			rangeFlag:   "nope...HEAD~10",
+			expectedErr: "unknown ref nope, HEAD~10",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			from, to := parseGitRange(tt.rangeFlag, cfg)
+			from, to, err := parseGitRange(tt.rangeFlag, cfg)
+			if tt.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+					t.Fatalf("Expected error containing %q, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseGitRange failed: %v", err)
+			}
 			if from != tt.expectedFrom {
 				t.Errorf("Expected from '%s', got '%s'", tt.expectedFrom, from)
 			}
@@ -55,40 +158,65 @@ func TestParseGitRange(t *testing.T) {
 			}
 		})
 	}
+
+	// The configured default is validated the same way
+	cfg.Triggers.Manual.DefaultRange = "v9.9.9..HEAD"
+	if _, _, err := parseGitRange("", cfg); err == nil || !strings.Contains(err.Error(), "v9.9.9") {
+		t.Errorf("Expected an invalid default range to be reported, got %v", err)
+	}
 }
 
-func TestInstallGitHooks(t *testing.T) {
-	// Create a temporary git repository
-	tmpDir := t.TempDir()
-	originalDir, _ := os.Getwd()
-	defer os.Chdir(originalDir)
+// initHooksRepo creates a repository in a directory with a space in its
+// name and changes into a nested directory of it for the test, returning the
+// repository's hooks directory
+func initHooksRepo(t *testing.T) string {
+	t.Helper()
 
-	// Change to temp directory
-	err := os.Chdir(tmpDir)
-	if err != nil {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repo := filepath.Join(t.TempDir(), "my repo")
+	nested := filepath.Join(repo, "pkg", "sub")
+	if err := os.MkdirAll(nested, 0755); err != nil {
+		t.Fatalf("Failed to create directories: %v", err)
+	}
+	cmd := exec.Command("git", "init", "-q")
+	cmd.Dir = repo
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git init failed: %v\n%s", err, output)
+	}
+
+	originalDir, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(originalDir) })
+	if err := os.Chdir(nested); err != nil {
 		t.Fatalf("Failed to change directory: %v", err)
 	}
 
-	// Create .git directory
-	err = os.MkdirAll(".git/hooks", 0755)
+	hooksDir, err := filepath.EvalSymlinks(filepath.Join(repo, ".git", "hooks"))
 	if err != nil {
-		t.Fatalf("Failed to create .git directory: %v", err)
+		t.Fatalf("Failed to resolve hooks directory: %v", err)
 	}
+	return hooksDir
+}
+
+func TestInstallGitHooks(t *testing.T) {
+	hooksDir := initHooksRepo(t)
 
 	// Test config with hooks
 	cfg := &config.Config{
 		Hooks: []string{"post-commit", "pre-push"},
 	}
 
-	// Install hooks
-	err = installGitHooks(cfg)
+	// Install hooks from the nested directory
+	err := installGitHooks(cfg)
 	if err != nil {
 		t.Fatalf("Failed to install git hooks: %v", err)
 	}
 
-	// Verify hooks were created
+	// Verify hooks were created at the repository root
 	for _, hookName := range cfg.Hooks {
-		hookPath := filepath.Join(".git", "hooks", hookName)
+		hookPath := filepath.Join(hooksDir, hookName)
 
 		// Check file exists
 		if _, err := os.Stat(hookPath); os.IsNotExist(err) {
@@ -107,37 +235,54 @@ func TestInstallGitHooks(t *testing.T) {
 			t.Errorf("Hook %s is not executable", hookName)
 		}
 
-		// Check content contains testgen
+		// Check content runs testgen
 		content, err := os.ReadFile(hookPath)
 		if err != nil {
 			t.Errorf("Failed to read hook %s: %v", hookName, err)
 			continue
 		}
 
-		if !strings.Contains(string(content), "testgen") {
-			t.Errorf("Hook %s does not contain testgen command", hookName)
+		if !strings.Contains(string(content), "testgen") || !strings.Contains(string(content), "' generate --quiet") {
+			t.Errorf("Hook %s does not run testgen by its quoted path:\n%s", hookName, content)
 		}
 	}
+
+	// Nothing is written relative to the nested directory
+	if _, err := os.Stat(".git"); !os.IsNotExist(err) {
+		t.Error("Expected no .git directory in the nested directory")
+	}
 }
 
-func TestUninstallGitHooks(t *testing.T) {
-	// Create a temporary git repository
-	tmpDir := t.TempDir()
-	originalDir, _ := os.Getwd()
-	defer os.Chdir(originalDir)
+func TestHookScript(t *testing.T) {
+	binary := "/opt/my tools/it's/testgen"
+	script := hookScript("post-commit", binary, false)
+	if !strings.Contains(script, `exec '/opt/my tools/it'\''s/testgen' generate --quiet`) {
+		t.Errorf("Expected the binary path quoted, got:\n%s", script)
+	}
+	if !strings.Contains(script, "--hook post-commit\n") {
+		t.Errorf("Expected the hook named to generate, got:\n%s", script)
+	}
 
-	// Change to temp directory
-	err := os.Chdir(tmpDir)
-	if err != nil {
-		t.Fatalf("Failed to change directory: %v", err)
+	// Only post-commit can run detached; pre-push waits for its outcome
+	background := hookScript("post-commit", binary, true)
+	if !strings.HasPrefix(strings.Split(background, "\n")[2], "nohup ") || !strings.Contains(background, "--hook-background >/dev/null 2>&1 &") {
+		t.Errorf("Expected a detached post-commit run, got:\n%s", background)
+	}
+	if script := hookScript("pre-push", binary, true); !strings.Contains(script, "exec ") || strings.Contains(script, "--hook-background") {
+		t.Errorf("Expected pre-push to wait for the run, got:\n%s", script)
 	}
 
-	// Create .git directory
-	hooksDir := ".git/hooks"
-	err = os.MkdirAll(hooksDir, 0755)
-	if err != nil {
-		t.Fatalf("Failed to create .git directory: %v", err)
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
 	}
+	output, err := exec.Command("sh", "-c", "set -- "+shellQuote(binary)+`; printf %s "$1"`).Output()
+	if err != nil || string(output) != binary {
+		t.Errorf("Expected sh to read back %q, got %q (%v)", binary, output, err)
+	}
+}
+
+func TestUninstallGitHooks(t *testing.T) {
+	hooksDir := initHooksRepo(t)
 
 	// Create testgen hooks
 	testgenHook := `#!/bin/sh
@@ -151,7 +296,7 @@ echo "other hook"
 `
 
 	// Install hooks
-	err = os.WriteFile(filepath.Join(hooksDir, "post-commit"), []byte(testgenHook), 0755)
+	err := os.WriteFile(filepath.Join(hooksDir, "post-commit"), []byte(testgenHook), 0755)
 	if err != nil {
 		t.Fatalf("Failed to create testgen hook: %v", err)
 	}
@@ -161,7 +306,7 @@ echo "other hook"
 		t.Fatalf("Failed to create other hook: %v", err)
 	}
 
-	// Uninstall hooks
+	// Uninstall hooks from the nested directory
 	err = uninstallGitHooks()
 	if err != nil {
 		t.Fatalf("Failed to uninstall git hooks: %v", err)
@@ -179,23 +324,7 @@ echo "other hook"
 }
 
 func TestShowHooksStatus(t *testing.T) {
-	// Create a temporary git repository
-	tmpDir := t.TempDir()
-	originalDir, _ := os.Getwd()
-	defer os.Chdir(originalDir)
-
-	// Change to temp directory
-	err := os.Chdir(tmpDir)
-	if err != nil {
-		t.Fatalf("Failed to change directory: %v", err)
-	}
-
-	// Create .git directory
-	hooksDir := ".git/hooks"
-	err = os.MkdirAll(hooksDir, 0755)
-	if err != nil {
-		t.Fatalf("Failed to create .git directory: %v", err)
-	}
+	hooksDir := initHooksRepo(t)
 
 	// Create a testgen hook
 	testgenHook := `#!/bin/sh
@@ -203,19 +332,31 @@ func TestShowHooksStatus(t *testing.T) {
 exec testgen generate
 `
 
-	err = os.WriteFile(filepath.Join(hooksDir, "post-commit"), []byte(testgenHook), 0755)
+	err := os.WriteFile(filepath.Join(hooksDir, "post-commit"), []byte(testgenHook), 0755)
 	if err != nil {
 		t.Fatalf("Failed to create testgen hook: %v", err)
 	}
 
-	// Test showHooksStatus (this mainly tests it doesn't crash)
+	// Capture what showHooksStatus prints from the nested directory
+	originalStdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
 	err = showHooksStatus()
+	w.Close()
+	os.Stdout = originalStdout
 	if err != nil {
 		t.Errorf("showHooksStatus failed: %v", err)
 	}
+	output, _ := io.ReadAll(r)
 
-	// Note: In a real test, we'd capture stdout and verify the output,
-	// but for simplicity we're just testing that it doesn't error
+	for _, want := range []string{"post-commit: installed", "pre-push: not installed"} {
+		if !strings.Contains(string(output), want) {
+			t.Errorf("Expected %q in:\n%s", want, output)
+		}
+	}
 }
 
 func TestLoadConfig(t *testing.T) {
@@ -286,3 +427,721 @@ type AIConfig struct {
 	Provider string
 	Model    string
 }
+
+func TestPrintRunResultJSON(t *testing.T) {
+	var out bytes.Buffer
+	report.SetOutput(&out, &out)
+	defer report.SetOutput(os.Stdout, os.Stderr)
+
+	jsonOutput = true
+	defer func() { jsonOutput = false }()
+
+	summary := newRunSummary([]*models.TestGenerationResponse{{
+		Confidence: 0.8,
+		Tests: []models.GeneratedTest{
+			{Name: "TestValidateUser", MatchedBy: models.MatchByTargetFunction},
+			{Name: "TestValidateUser_Nil", Confidence: 0.4, MatchedBy: models.MatchByName},
+		},
+	}}, 1, 0)
+	summary.TestsGenerated = 2
+	printRunResult(summary, "Successfully generated 2 test functions\n")
+
+	var decoded runSummary
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("Expected JSON output, got %q: %v", out.String(), err)
+	}
+	if decoded.Outcome != "generated" || decoded.TestsGenerated != 2 || decoded.Confidence.Tests != 2 {
+		t.Errorf("Unexpected run summary: %+v", decoded)
+	}
+	want := []generator.LowConfidenceTest{{Name: "TestValidateUser_Nil", Confidence: 0.4}}
+	if len(decoded.Confidence.LowConfidence) != 1 || decoded.Confidence.LowConfidence[0] != want[0] {
+		t.Errorf("Expected low confidence %v, got %v", want, decoded.Confidence.LowConfidence)
+	}
+	if decoded.Matches[models.MatchByTargetFunction] != 1 || decoded.Matches[models.MatchByName] != 1 {
+		t.Errorf("Expected one test matched by target_function and one by name, got %v", decoded.Matches)
+	}
+	if strings.Contains(out.String(), "Successfully generated") {
+		t.Error("Expected the text result to be replaced by JSON")
+	}
+}
+
+func TestPrintNoTargets(t *testing.T) {
+	var out bytes.Buffer
+	report.SetOutput(&out, &out)
+	defer report.SetOutput(os.Stdout, os.Stderr)
+
+	tests := []struct {
+		funnel analyzer.Funnel
+		reason string
+	}{
+		{analyzer.Funnel{}, "no Go files changed"},
+		{analyzer.Funnel{GoFiles: 1}, "no functions changed"},
+		{analyzer.Funnel{GoFiles: 1, Functions: 3}, "all filtered"},
+		{analyzer.Funnel{GoFiles: 1, Functions: 3, Filtered: 2}, "all have tests"},
+	}
+	for _, tt := range tests {
+		out.Reset()
+		printNoTargets(tt.funnel)
+		if want := "No functions need test generation: " + tt.reason + "\n"; out.String() != want {
+			t.Errorf("Expected %q, got %q", want, out.String())
+		}
+
+		out.Reset()
+		jsonOutput = true
+		printNoTargets(tt.funnel)
+		jsonOutput = false
+		var decoded runSummary
+		if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+			t.Fatalf("Expected JSON output, got %q: %v", out.String(), err)
+		}
+		if decoded.Outcome != "no_targets" || decoded.Reason != tt.reason || decoded.Stages == nil || *decoded.Stages != tt.funnel {
+			t.Errorf("Expected outcome no_targets with reason %q and stages %+v, got %+v", tt.reason, tt.funnel, decoded)
+		}
+	}
+}
+
+func TestBatchBySource(t *testing.T) {
+	batches := batchBySource([]models.FunctionInfo{
+		{Name: "ValidateUser", File: "user.go"},
+		{Name: "PlaceOrder", File: "order.go"},
+		{Name: "CreateUser", File: "user.go"},
+	})
+
+	if len(batches) != 2 {
+		t.Fatalf("Expected 2 batches, got %d", len(batches))
+	}
+	if len(batches[0]) != 2 || batches[0][0].Name != "ValidateUser" || batches[0][1].Name != "CreateUser" {
+		t.Errorf("Expected user.go functions first and in order, got %v", batches[0])
+	}
+	if len(batches[1]) != 1 || batches[1][0].Name != "PlaceOrder" {
+		t.Errorf("Expected order.go functions second, got %v", batches[1])
+	}
+}
+
+func TestBatchBySourceOrder(t *testing.T) {
+	// As a diff might list them: files interleaved, functions out of order
+	targets := []models.FunctionInfo{
+		{Name: "Format", File: "user.go", StartLine: 30},
+		{Name: "PlaceOrder", File: "order.go", StartLine: 8},
+		{Name: "Parse", File: "user.go", StartLine: 5},
+		{Name: "CancelOrder", File: "order.go", StartLine: 3},
+		{Name: "Validate", File: "user.go", StartLine: 12},
+	}
+	analyzer.SortTargets(targets)
+	batches := batchBySource(targets)
+
+	var got [][]string
+	for _, batch := range batches {
+		var names []string
+		for _, fn := range batch {
+			names = append(names, fn.Name)
+		}
+		got = append(got, names)
+	}
+	want := [][]string{{"CancelOrder", "PlaceOrder"}, {"Parse", "Validate", "Format"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected one batch per file in reading order %v, got %v", want, got)
+	}
+}
+
+func TestStartProgressResume(t *testing.T) {
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+
+	targets := []models.FunctionInfo{{Name: "ValidateUser", File: "user.go"}, {Name: "PlaceOrder", File: "order.go"}}
+	if _, _, err := startProgress(targets, true); err == nil {
+		t.Error("Expected an error resuming without an interrupted run")
+	}
+
+	_, progress, err := startProgress(targets, false)
+	if err != nil {
+		t.Fatalf("startProgress failed: %v", err)
+	}
+	if err := progress.Done(analyzer.QualifiedName(targets[0])); err != nil {
+		t.Fatalf("Done failed: %v", err)
+	}
+
+	pending, _, err := startProgress(targets, true)
+	if err != nil {
+		t.Fatalf("startProgress failed to resume: %v", err)
+	}
+	if len(pending) != 1 || pending[0].Name != "PlaceOrder" {
+		t.Errorf("Expected only PlaceOrder left to generate, got %v", pending)
+	}
+}
+
+func TestGoGenerateTargets(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		args      []string
+		function  string
+		wantFiles []string
+		wantFunc  string
+	}{
+		{
+			name:      "directive with the file expanded by go generate",
+			env:       map[string]string{"GOFILE": "user.go", "GOPACKAGE": "user"},
+			function:  "user.go:ValidateUser",
+			wantFiles: []string{"user.go"},
+			wantFunc:  "ValidateUser",
+		},
+		{
+			name:      "unexpanded $GOFILE is resolved",
+			env:       map[string]string{"GOFILE": "user.go", "GOPACKAGE": "user"},
+			function:  "$GOFILE:ValidateUser",
+			wantFiles: []string{"user.go"},
+			wantFunc:  "ValidateUser",
+		},
+		{
+			name:      "bare directive targets the directive's file",
+			env:       map[string]string{"GOFILE": "order.go", "GOPACKAGE": "order"},
+			wantFiles: []string{"order.go"},
+		},
+		{
+			name:      "explicit files win over the directive's file",
+			env:       map[string]string{"GOFILE": "order.go", "GOPACKAGE": "order"},
+			args:      []string{"${GOPACKAGE}_helpers.go"},
+			wantFiles: []string{"order_helpers.go"},
+		},
+		{
+			name:     "outside go generate nothing is expanded or defaulted",
+			function: "$GOFILE",
+			wantFunc: "$GOFILE",
+		},
+		{
+			name:      "file-qualified function outside go generate",
+			function:  "internal/user/user.go:ValidateUser",
+			wantFiles: []string{"internal/user/user.go"},
+			wantFunc:  "ValidateUser",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GOFILE", tt.env["GOFILE"])
+			t.Setenv("GOPACKAGE", tt.env["GOPACKAGE"])
+
+			files, function := goGenerateTargets(tt.args, tt.function)
+			if strings.Join(files, ",") != strings.Join(tt.wantFiles, ",") || function != tt.wantFunc {
+				t.Errorf("Expected %v %q, got %v %q", tt.wantFiles, tt.wantFunc, files, function)
+			}
+		})
+	}
+}
+
+func TestRunPrompt(t *testing.T) {
+	var out bytes.Buffer
+	report.SetOutput(&out, &out)
+	defer report.SetOutput(os.Stdout, os.Stderr)
+
+	tmpDir := t.TempDir()
+	source := filepath.Join(tmpDir, "user.go")
+	code := "package user\n\nimport \"errors\"\n\n" +
+		"// ValidateUser requires a name\nfunc ValidateUser(name string) error {\n\tif name == \"\" {\n\t\treturn errors.New(\"name required\")\n\t}\n\treturn nil\n}\n\n" +
+		"func Other() int { return 1 }\n"
+	if err := os.WriteFile(source, []byte(code), 0644); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(tmpDir, "testgen.yml")
+	if err := os.WriteFile(configPath, []byte("ai:\n  provider: openai\n  api_key: sk-secret\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	originalConfigFile, originalFunction := configFile, promptFunction
+	defer func() {
+		configFile, promptFunction, promptSystem, promptFormat = originalConfigFile, originalFunction, false, "text"
+	}()
+	configFile = configPath
+
+	// Text with the system message, for the one function asked for
+	promptFunction, promptSystem, promptFormat = source+":ValidateUser", true, "text"
+	if err := runPrompt(promptCmd, nil); err != nil {
+		t.Fatalf("runPrompt failed: %v", err)
+	}
+	text := out.String()
+	for _, want := range []string{"--- system ---\nYou are an expert Go test writer", "--- prompt ---\nGenerate comprehensive Go tests", "1. Function: ValidateUser"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "Function: Other") || strings.Contains(text, "sk-secret") {
+		t.Errorf("Expected only ValidateUser and no API key, got:\n%s", text)
+	}
+
+	// JSON is the structured request
+	out.Reset()
+	promptSystem, promptFormat = false, "json"
+	if err := runPrompt(promptCmd, nil); err != nil {
+		t.Fatalf("runPrompt failed: %v", err)
+	}
+	var request models.TestGenerationRequest
+	if err := json.Unmarshal(out.Bytes(), &request); err != nil {
+		t.Fatalf("Expected a JSON request, got %q: %v", out.String(), err)
+	}
+	if len(request.Functions) != 1 || request.Functions[0].Name != "ValidateUser" || request.Context.PackageName != "user" {
+		t.Errorf("Unexpected request: %+v", request)
+	}
+
+	promptFormat = "yaml"
+	if err := runPrompt(promptCmd, nil); err == nil || !strings.Contains(err.Error(), "--format") {
+		t.Errorf("Expected a --format error, got %v", err)
+	}
+}
+
+func TestRunClean(t *testing.T) {
+	var out bytes.Buffer
+	report.SetOutput(&out, &out)
+	defer report.SetOutput(os.Stdout, os.Stderr)
+
+	root := t.TempDir()
+	backup := filepath.Join(root, "user", "user_test.go.backup")
+	kept := filepath.Join(root, "user", "notes.backup")
+	for _, path := range []string{backup, kept} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("package user\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	originalRepo := repoDir
+	defer func() { repoDir, cleanBackups, dryRun = originalRepo, false, false }()
+	repoDir = root
+
+	if err := runClean(cleanCmd, nil); err == nil {
+		t.Error("Expected clean without --backups to fail")
+	}
+
+	cleanBackups, dryRun = true, true
+	if err := runClean(cleanCmd, nil); err != nil {
+		t.Fatalf("runClean failed: %v", err)
+	}
+	if _, err := os.Stat(backup); err != nil {
+		t.Errorf("Expected --dry-run to keep the backup: %v", err)
+	}
+
+	dryRun = false
+	if err := runClean(cleanCmd, nil); err != nil {
+		t.Fatalf("runClean failed: %v", err)
+	}
+	if _, err := os.Stat(backup); !os.IsNotExist(err) {
+		t.Errorf("Expected the backup removed")
+	}
+	if _, err := os.Stat(kept); err != nil {
+		t.Errorf("Expected non-Go backups kept: %v", err)
+	}
+	if !strings.Contains(out.String(), "Removed 1 of 1 backups") {
+		t.Errorf("Unexpected output:\n%s", out.String())
+	}
+}
+
+func TestPreviewDiff(t *testing.T) {
+	text := previewDiffText([]generator.TestFileDiff{
+		{Path: "order_test.go", Created: true, Lines: 12},
+		{Path: "user_test.go", Diff: "--- a/user_test.go\n+++ b/user_test.go\n@@ -1 +1,2 @@\n package user\n+// more\n"},
+		{Path: "util_test.go"},
+	})
+	for _, want := range []string{
+		"new file order_test.go (12 lines)\n",
+		"--- a/user_test.go\n+++ b/user_test.go\n",
+		"unchanged util_test.go\n",
+		"Would change 1 existing test files and create 1; nothing was written\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in:\n%s", want, text)
+		}
+	}
+
+	defer func() { dryRun, proposeTests = false, false }()
+	if err := checkPreviewDiff(); err != nil {
+		t.Errorf("Expected --preview-diff alone to be accepted, got %v", err)
+	}
+	dryRun = true
+	if err := checkPreviewDiff(); err == nil || !strings.Contains(err.Error(), "--dry-run") {
+		t.Errorf("Expected --dry-run to be rejected, got %v", err)
+	}
+	dryRun, proposeTests = false, true
+	if err := checkPreviewDiff(); err == nil || !strings.Contains(err.Error(), "--propose") {
+		t.Errorf("Expected --propose to be rejected, got %v", err)
+	}
+}
+
+func TestCheckStdout(t *testing.T) {
+	defer func() { gitRange, jsonOutput = "", false }()
+	if err := checkStdout([]string{"user.go"}); err != nil {
+		t.Errorf("Expected one file to be accepted, got %v", err)
+	}
+	if err := checkStdout([]string{"user.go", "order.go"}); err == nil || !strings.Contains(err.Error(), "exactly one source file") {
+		t.Errorf("Expected two files to be rejected, got %v", err)
+	}
+	gitRange = "HEAD~1..HEAD"
+	if err := checkStdout([]string{"user.go"}); err == nil || !strings.Contains(err.Error(), "no --range") {
+		t.Errorf("Expected --range to be rejected, got %v", err)
+	}
+	gitRange, jsonOutput = "", true
+	if err := checkStdout([]string{"user.go"}); err == nil || !strings.Contains(err.Error(), "--json") {
+		t.Errorf("Expected --json to be rejected, got %v", err)
+	}
+}
+
+func TestQueuedTargets(t *testing.T) {
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	analyzer.SetFiltering(config.DefaultConfig().Filtering)
+
+	source := "package user\n\nfunc ValidateUser(name string) bool {\n\treturn name != \"\"\n}\n\nfunc CreateUser(name string) string {\n\treturn name\n}\n"
+	if err := os.WriteFile("user.go", []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err := analyzer.Analyze(analyzer.Sources{Files: []string{"user.go"}})
+	if err != nil || len(result.GenerationTargets) != 2 {
+		t.Fatalf("Expected two targets, got %v (%v)", result.GenerationTargets, err)
+	}
+	validate, create := result.GenerationTargets[0], result.GenerationTargets[1]
+
+	// CreateUser is queued twice; the latest run owns it
+	older, err := generator.Enqueue(queueDir, models.TestGenerationRequest{Functions: []models.FunctionInfo{validate, create}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer, err := generator.Enqueue(queueDir, models.TestGenerationRequest{Functions: []models.FunctionInfo{create}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs, err := generator.ListQueue(queueDir)
+	if err != nil || len(runs) != 2 {
+		t.Fatalf("Expected two queued runs, got %v (%v)", runs, err)
+	}
+	latest := latestQueued(runs)
+
+	targets, err := queuedTargets(runs[0], latest)
+	if err != nil {
+		t.Fatalf("queuedTargets failed: %v", err)
+	}
+	if len(targets) != 1 || targets[0].Name != "ValidateUser" || targets[0].Body != validate.Body {
+		t.Errorf("Expected only the unchanged ValidateUser from %s, got %+v", older.ID, targets)
+	}
+
+	// Once the source changes, ValidateUser is analyzed again and the
+	// deleted CreateUser is dropped
+	changed := "package user\n\nfunc ValidateUser(name string) bool {\n\treturn len(name) > 2\n}\n"
+	if err := os.WriteFile("user.go", []byte(changed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	targets, err = queuedTargets(runs[0], latest)
+	if err != nil {
+		t.Fatalf("queuedTargets failed: %v", err)
+	}
+	if len(targets) != 1 || !strings.Contains(targets[0].Body, "len(name) > 2") {
+		t.Errorf("Expected ValidateUser analyzed again, got %+v", targets)
+	}
+	targets, err = queuedTargets(runs[1], latest)
+	if err != nil {
+		t.Fatalf("queuedTargets failed: %v", err)
+	}
+	if len(targets) != 0 {
+		t.Errorf("Expected the deleted CreateUser dropped from %s, got %+v", newer.ID, targets)
+	}
+}
+
+func TestQueuedTargetsNestedDirectory(t *testing.T) {
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	root := t.TempDir()
+	pkgDir := filepath.Join(root, "pkg", "user")
+	if err := os.MkdirAll(pkgDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "go.mod"), []byte("module example.com/app\n\ngo 1.22\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	source := filepath.Join(pkgDir, "user.go")
+	if err := os.WriteFile(source, []byte("package user\n\nfunc ValidateUser(name string) bool {\n\treturn name != \"\"\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Drained from below the project root, where paths relative to it
+	// don't resolve
+	if err := os.Chdir(filepath.Join(root, "pkg")); err != nil {
+		t.Fatal(err)
+	}
+	analyzer.SetFiltering(config.DefaultConfig().Filtering)
+
+	result, err := analyzer.Analyze(analyzer.Sources{Files: []string{filepath.Join("user", "user.go")}})
+	if err != nil || len(result.GenerationTargets) != 1 {
+		t.Fatalf("Expected one target, got %v (%v)", result.GenerationTargets, err)
+	}
+	if _, err := generator.Enqueue(queueDir, models.TestGenerationRequest{Functions: result.GenerationTargets}); err != nil {
+		t.Fatal(err)
+	}
+	runs, err := generator.ListQueue(queueDir)
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("Expected one queued run, got %v (%v)", runs, err)
+	}
+
+	if err := os.WriteFile(source, []byte("package user\n\nfunc ValidateUser(name string) bool {\n\treturn len(name) > 2\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	targets, err := queuedTargets(runs[0], latestQueued(runs))
+	if err != nil {
+		t.Fatalf("queuedTargets failed: %v", err)
+	}
+	if len(targets) != 1 || !strings.Contains(targets[0].Body, "len(name) > 2") {
+		t.Fatalf("Expected ValidateUser analyzed again, got %+v", targets)
+	}
+	if want := filepath.Join("pkg", "user", "user.go"); targets[0].File != want {
+		t.Errorf("Expected the target's file relative to the project root, %s, got %s", want, targets[0].File)
+	}
+}
+
+func TestProjectLockNestedDirectory(t *testing.T) {
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	root := t.TempDir()
+	nested := filepath.Join(root, "pkg", "user")
+	if err := os.MkdirAll(nested, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "go.mod"), []byte("module example.com/app\n\ngo 1.22\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	release, err := acquireProjectLock("generate")
+	if err != nil {
+		t.Fatalf("acquireProjectLock failed: %v", err)
+	}
+	defer release()
+
+	// A run from a subdirectory is in the same project and must wait its turn
+	if err := os.Chdir(nested); err != nil {
+		t.Fatal(err)
+	}
+	var held *lock.HeldError
+	if _, err := acquireProjectLock("generate"); !errors.As(err, &held) {
+		t.Fatalf("Expected the lock taken at the root to be held, got %v", err)
+	}
+	if want := filepath.Join(root, lock.File); held.Path != want {
+		t.Errorf("Expected the lock at %s, got %s", want, held.Path)
+	}
+	if _, err := os.Stat(filepath.Join(nested, lock.File)); !os.IsNotExist(err) {
+		t.Errorf("Expected no lock in the subdirectory, got %v", err)
+	}
+}
+
+func TestWhyIntroduced(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	var out bytes.Buffer
+	report.SetOutput(&out, &out)
+	defer report.SetOutput(os.Stdout, os.Stderr)
+
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	gitRun := func(args ...string) string {
+		t.Helper()
+		output, err := exec.Command("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+	commitAs := func(author, source, message string) string {
+		t.Helper()
+		if err := os.WriteFile("user.go", []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+		gitRun("add", "user.go")
+		gitRun("commit", "-q", "--author", author+" <"+strings.ToLower(author)+"@example.com>", "-m", message)
+		return gitRun("rev-parse", "HEAD")
+	}
+
+	// Two pushed commits, each adding a function
+	gitRun("init", "-q")
+	if err := os.WriteFile("go.mod", []byte("module example.com/user\n\ngo 1.22\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitRun("add", "go.mod")
+	commitAs("Base", "package user\n", "initial")
+	validate := "package user\n\nfunc ValidateUser(name string) bool {\n\treturn name != \"\"\n}\n"
+	first := commitAs("Ann", validate, "add ValidateUser")
+	second := commitAs("Ben", validate+"\nfunc CreateUser(name string) string {\n\treturn \"user:\" + name\n}\n", "add CreateUser")
+	if err := os.WriteFile("testgen.yml", []byte("ai:\n  provider: local\n  model: llama3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	originalConfigFile := configFile
+	defer func() {
+		configFile, gitRange, statsOnly, dryRun, jsonOutput, whyIntroduced = originalConfigFile, "", false, false, false, false
+	}()
+	configFile, gitRange, whyIntroduced = "testgen.yml", "HEAD~2..HEAD", true
+
+	// --stats-only --json lists each function under the commit that added it
+	statsOnly, jsonOutput = true, true
+	if err := runGenerate(generateCmd, nil); err != nil {
+		t.Fatalf("runGenerate failed: %v", err)
+	}
+	var stats models.GenerationStats
+	if err := json.Unmarshal(out.Bytes(), &stats); err != nil {
+		t.Fatalf("Expected JSON stats, got %v:\n%s", err, out.String())
+	}
+	want := []models.TargetIntroduction{
+		{Function: "ValidateUser", File: "user.go", Line: 3, Commit: first, Author: "Ann", Summary: "add ValidateUser"},
+		{Function: "CreateUser", File: "user.go", Line: 7, Commit: second, Author: "Ben", Summary: "add CreateUser"},
+	}
+	if !reflect.DeepEqual(stats.Introduced, want) {
+		t.Errorf("Expected introductions %+v, got %+v", want, stats.Introduced)
+	}
+
+	// --dry-run prints them
+	out.Reset()
+	statsOnly, jsonOutput, dryRun = false, false, true
+	if err := runGenerate(generateCmd, nil); err != nil {
+		t.Fatalf("runGenerate failed: %v", err)
+	}
+	for _, line := range []string{
+		"ValidateUser (user.go:3): " + first[:12] + " Ann: add ValidateUser",
+		"CreateUser (user.go:7): " + second[:12] + " Ben: add CreateUser",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Expected %q in the output, got:\n%s", line, out.String())
+		}
+	}
+
+	// Attribution needs a range and a run that only reports
+	dryRun = false
+	if err := runGenerate(generateCmd, nil); err == nil || !strings.Contains(err.Error(), "--why") {
+		t.Errorf("Expected --why without --stats-only or --dry-run rejected, got %v", err)
+	}
+}
+
+func TestHookTimeout(t *testing.T) {
+	var out bytes.Buffer
+	report.SetOutput(&out, &out)
+	defer report.SetOutput(os.Stdout, os.Stderr)
+
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A provider slower than the hook timeout
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	source := "package user\n\nfunc ValidateUser(name string) bool {\n\treturn name != \"\"\n}\n"
+	if err := os.WriteFile("user.go", []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeConfig := func(enforce bool) {
+		yml := fmt.Sprintf("ai:\n  provider: local\n  model: llama3\n  base_url: %s\ntriggers:\n  auto:\n    enforce: %t\n", server.URL, enforce)
+		if err := os.WriteFile("testgen.yml", []byte(yml), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	originalConfigFile := configFile
+	defer func() {
+		configFile, triggeringHook, hookBackground = originalConfigFile, "", false
+	}()
+	configFile = "testgen.yml"
+	t.Setenv(hookTimeoutEnvVar, "200ms")
+
+	tests := []struct {
+		hook    string
+		enforce bool
+		wantErr bool
+	}{
+		{hook: "post-commit", enforce: true},
+		{hook: "pre-push", enforce: false},
+		{hook: "pre-push", enforce: true, wantErr: true},
+		{hook: "pre-commit", enforce: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		out.Reset()
+		writeConfig(tt.enforce)
+		triggeringHook = tt.hook
+
+		start := time.Now()
+		err := runGenerate(generateCmd, []string{"user.go"})
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("%s: expected the run to stop at the hook timeout, took %s", tt.hook, elapsed)
+		}
+		if tt.wantErr {
+			if err == nil || strings.Contains(err.Error(), "\n") || !strings.Contains(err.Error(), skipEnvVar+"=1") {
+				t.Errorf("%s with enforce: expected a one-line error naming %s, got %v", tt.hook, skipEnvVar, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s (enforce %t): expected a clean exit, got %v", tt.hook, tt.enforce, err)
+		}
+		if !strings.Contains(out.String(), "hook timed out after 200ms") {
+			t.Errorf("%s: expected the timeout noted, got:\n%s", tt.hook, out.String())
+		}
+		os.RemoveAll(generator.ProgressFile)
+	}
+
+	// TESTGEN_SKIP exits at once, before the config is even read
+	out.Reset()
+	configFile = "missing.yml"
+	t.Setenv(skipEnvVar, "1")
+	if err := runGenerate(generateCmd, []string{"user.go"}); err != nil || !strings.Contains(out.String(), "skipped") {
+		t.Errorf("Expected a logged skip, got %v:\n%s", err, out.String())
+	}
+}
+
+func TestHookRunTimeout(t *testing.T) {
+	defer func() { triggeringHook, hookBackground = "", false }()
+	cfg := config.DefaultConfig()
+
+	tests := []struct {
+		name       string
+		hook       string
+		background bool
+		env        string
+		want       time.Duration
+		wantErr    bool
+	}{
+		{name: "not a hook", env: "5s"},
+		{name: "config default", hook: "post-commit", want: 60 * time.Second},
+		{name: "duration", hook: "pre-push", env: "90s", want: 90 * time.Second},
+		{name: "seconds", hook: "pre-push", env: "15", want: 15 * time.Second},
+		{name: "backgrounded", hook: "post-commit", background: true, env: "5s"},
+		{name: "invalid", hook: "pre-push", env: "soon", wantErr: true},
+		{name: "negative", hook: "pre-push", env: "-1s", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(hookTimeoutEnvVar, tt.env)
+			triggeringHook, hookBackground = tt.hook, tt.background
+			got, err := hookRunTimeout(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("hookRunTimeout() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("hookRunTimeout() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
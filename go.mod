@@ -3,6 +3,7 @@ module github.com/Eranmonnie/testgen
 go 1.22.2
 
 require (
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/spf13/cobra v1.10.1
 	gopkg.in/yaml.v3 v3.0.1
 )
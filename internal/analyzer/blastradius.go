@@ -0,0 +1,91 @@
+package analyzer
+
+import (
+	"path/filepath"
+
+	"github.com/Eranmonnie/testgen/internal/config"
+	"github.com/Eranmonnie/testgen/internal/parser"
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// BlastRadiusTargets returns extra targets for functions a change affects
+// without modifying them, per triggers.blast_radius: "callers" adds the
+// exported functions of the package that call a modified function directly,
+// "package" every exported function of a package with modified functions.
+// Functions already targeted or modified themselves aren't added again.
+func BlastRadiusTargets(result *AnalysisResult, mode string) []models.FunctionInfo {
+	if mode != config.BlastRadiusCallers && mode != config.BlastRadiusPackage {
+		return nil
+	}
+
+	known := make(map[string]bool)
+	for _, fn := range result.GenerationTargets {
+		known[QualifiedName(fn)] = true
+	}
+
+	// Modified functions, grouped by package directory in first-seen order
+	var dirs []string
+	modified := make(map[string][]models.FunctionInfo)
+	sourceFile := make(map[string]string)
+	for _, file := range result.ChangedFiles {
+		for _, fn := range file.FunctionDetails {
+			known[QualifiedName(fn)] = true
+			dir := filepath.Dir(fn.SourcePath())
+			if _, ok := modified[dir]; !ok {
+				dirs = append(dirs, dir)
+				sourceFile[dir] = fn.SourcePath()
+			}
+			modified[dir] = append(modified[dir], fn)
+		}
+	}
+
+	var targets []models.FunctionInfo
+	targetRoot := functionRoot(result.Root)
+	packages := make(map[string]packageDecls)
+	for _, dir := range dirs {
+		files, err := parser.ParsePackage(sourceFile[dir])
+		if err != nil {
+			continue
+		}
+
+		// Which modified functions each package function calls
+		callsModified := make(map[string][]string)
+		graph := parser.BuildCallGraph(files)
+		for _, fn := range modified[dir] {
+			for _, caller := range graph.Callers(MethodName(fn)) {
+				key := fileKey(caller.File) + ":" + parser.CallName(caller)
+				callsModified[key] = append(callsModified[key], MethodName(fn))
+			}
+		}
+
+		for _, file := range files {
+			if file.IsCgo && !includeCgo {
+				continue
+			}
+			for _, fn := range file.Functions {
+				target := convertToModelFunction(fn, file)
+				name := QualifiedName(target)
+				if known[name] || !isExported(fn.Name) {
+					continue
+				}
+				if mode == config.BlastRadiusCallers && len(callsModified[name]) == 0 {
+					continue
+				}
+				if !shouldGenerateTest(target) || target.IsExternalImpl {
+					continue
+				}
+
+				known[name] = true
+				target.BlastRadius = mode
+				target.CallsModified = callsModified[name]
+				if target.IsMethod && target.Receiver != nil {
+					resolveReceiver(&target, packages)
+				}
+				normalizeFunction(&target, result.Root, targetRoot)
+				targets = append(targets, target)
+			}
+		}
+	}
+
+	return targets
+}
@@ -0,0 +1,55 @@
+package analyzer
+
+import "fmt"
+
+// OutcomeNoTargets is the JSON outcome of a run that found nothing to test
+const OutcomeNoTargets = "no_targets"
+
+// Why a run has nothing to generate tests for, by the stage of the pipeline
+// that emptied out
+const (
+	NoTargetsNoGoFiles   = "no Go files changed"
+	NoTargetsNoFunctions = "no functions changed"
+	NoTargetsFiltered    = "all filtered"
+	NoTargetsHaveTests   = "all have tests"
+)
+
+// Funnel counts what is left after each stage of choosing targets
+type Funnel struct {
+	GoFiles   int `json:"go_files"`  // Go source files changed or requested
+	Functions int `json:"functions"` // functions in them that changed or were requested
+	Filtered  int `json:"filtered"`  // targets left after the filters
+	Pending   int `json:"pending"`   // targets that don't have their tests yet
+}
+
+// Funnel returns the stage counts of an analysis. Targets later dropped by
+// the caller's own filters or existing tests lower Filtered and Pending.
+func (r *AnalysisResult) Funnel() Funnel {
+	return Funnel{
+		GoFiles:   r.GoFiles,
+		Functions: r.TotalFunctions,
+		Filtered:  len(r.GenerationTargets),
+		Pending:   len(r.GenerationTargets),
+	}
+}
+
+// NoTargetsReason names the first stage that left nothing, or returns ""
+// when there are targets
+func (f Funnel) NoTargetsReason() string {
+	switch {
+	case f.GoFiles == 0:
+		return NoTargetsNoGoFiles
+	case f.Functions == 0:
+		return NoTargetsNoFunctions
+	case f.Filtered == 0:
+		return NoTargetsFiltered
+	case f.Pending == 0:
+		return NoTargetsHaveTests
+	}
+	return ""
+}
+
+// NoTargetsMessage is what every command says when there's nothing to test
+func NoTargetsMessage(reason string) string {
+	return fmt.Sprintf("No functions need test generation: %s", reason)
+}
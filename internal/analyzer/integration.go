@@ -2,23 +2,65 @@
 package analyzer
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 
+	"github.com/Eranmonnie/testgen/internal/config"
 	"github.com/Eranmonnie/testgen/internal/git"
 	"github.com/Eranmonnie/testgen/internal/parser"
+	"github.com/Eranmonnie/testgen/internal/report"
 	"github.com/Eranmonnie/testgen/pkg/models"
 )
 
+// filtering holds the configured filter rules. The analyzer consults
+// always_include; its own built-in rules decide the rest.
+var filtering config.FilterConfig
+
+// SetFiltering configures the filter rules used when choosing targets
+func SetFiltering(rules config.FilterConfig) {
+	filtering = rules
+}
+
+// includeCgo analyzes files importing "C" instead of skipping them (--include-cgo)
+var includeCgo bool
+
+// SetIncludeCgo makes cgo files be analyzed like any other
+func SetIncludeCgo(on bool) {
+	includeCgo = on
+}
+
+// recentCommits is how many of the latest commits to each target file
+// GetProjectContext reports (ai.recent_commits)
+var recentCommits int
+
+// SetRecentCommits makes GetProjectContext report the last n commits to
+// each target file, none when n is 0
+func SetRecentCommits(n int) {
+	recentCommits = n
+}
+
+// errCgoFile is returned for cgo files, which are skipped unless includeCgo
+var errCgoFile = errors.New("cgo file skipped")
+
 // AnalysisResult combines git diff and AST analysis
 type AnalysisResult struct {
 	ChangedFiles      []ChangedFileAnalysis
+	GoFiles           int // Go source files in the diff or on the command line
 	TotalFunctions    int
 	ModifiedFunctions int
 	GenerationTargets []models.FunctionInfo
+	DiffFiles         []string // every file touched by the git diff, Go or not
+	Root              string   // absolute project root the stored paths are relative to
+
+	PathRewrites     []PathRewrite // command-line files resolved to another path or dropped as duplicates
+	DuplicateTargets int           // targets requested more than once and merged
+	CgoFiles         []string      // files importing "C", skipped without --include-cgo
 }
 
 // ChangedFileAnalysis represents analysis of a single changed file
@@ -31,8 +73,15 @@ type ChangedFileAnalysis struct {
 
 // AnalyzeChanges performs complete analysis of git changes
 func AnalyzeChanges(fromRef, toRef string) (*AnalysisResult, error) {
+	return analyzeChanges(fromRef, toRef, ProjectRoot())
+}
+
+// analyzeChanges is AnalyzeChanges storing paths relative to root
+func analyzeChanges(fromRef, toRef, root string) (*AnalysisResult, error) {
 	// Step 1: Get git diff
+	stopTiming := report.Time(report.PhaseGitDiff)
 	diffResult, err := git.GetDiff(fromRef, toRef)
+	stopTiming()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get git diff: %w", err)
 	}
@@ -42,14 +91,27 @@ func AnalyzeChanges(fromRef, toRef string) (*AnalysisResult, error) {
 
 	result := &AnalysisResult{
 		ChangedFiles: make([]ChangedFileAnalysis, 0, len(goFiles.Files)),
+		GoFiles:      len(goFiles.Files),
+	}
+
+	for _, fileDiff := range diffResult.Files {
+		if fileDiff.NewPath != "" {
+			result.DiffFiles = append(result.DiffFiles, fileDiff.NewPath)
+		} else {
+			result.DiffFiles = append(result.DiffFiles, fileDiff.OldPath)
+		}
 	}
 
 	// Step 2: Analyze each changed Go file
 	for _, fileDiff := range goFiles.Files {
 		fileAnalysis, err := analyzeChangedFile(fileDiff)
+		if errors.Is(err, errCgoFile) {
+			result.CgoFiles = append(result.CgoFiles, git.ResolvePath(fileDiff.NewPath))
+			continue
+		}
 		if err != nil {
 			// Log error but continue with other files
-			fmt.Printf("Warning: failed to analyze %s: %v\n", fileDiff.NewPath, err)
+			report.Warnf("failed to analyze %s: %v\n", fileDiff.NewPath, err)
 			continue
 		}
 
@@ -63,9 +125,16 @@ func AnalyzeChanges(fromRef, toRef string) (*AnalysisResult, error) {
 	// Step 3: Build generation targets
 	result.GenerationTargets = buildGenerationTargets(result.ChangedFiles)
 
+	normalizePaths(result, root)
 	return result, nil
 }
 
+// parseFile parses a Go file, timed as the parse phase
+func parseFile(path string) (*parser.FileAnalysis, error) {
+	defer report.Time(report.PhaseParse)()
+	return parser.ParseFile(path)
+}
+
 // analyzeChangedFile analyzes a single file from git diff
 func analyzeChangedFile(fileDiff git.FileDiff) (*ChangedFileAnalysis, error) {
 	// Skip if file was deleted
@@ -73,11 +142,20 @@ func analyzeChangedFile(fileDiff git.FileDiff) (*ChangedFileAnalysis, error) {
 		return nil, nil
 	}
 
-	// Parse the Go file using AST
-	fileAnalysis, err := parser.ParseFile(fileDiff.NewPath)
+	// Skip files excluded from the target platform's build
+	path := git.ResolvePath(fileDiff.NewPath)
+	if !matchesPlatform(path) {
+		return nil, nil
+	}
+
+	// Parse the Go file using AST (diff paths are relative to the repo root)
+	fileAnalysis, err := parseFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse Go file: %w", err)
 	}
+	if fileAnalysis.IsCgo && !includeCgo {
+		return nil, errCgoFile
+	}
 
 	// Get functions that were actually modified (not just context)
 	modifiedFunctionNames := fileDiff.GetModifiedFunctions()
@@ -94,26 +172,78 @@ func analyzeChangedFile(fileDiff git.FileDiff) (*ChangedFileAnalysis, error) {
 	var functionDetails []models.FunctionInfo
 	for _, fn := range modifiedFunctions {
 		modelFunc := convertToModelFunction(fn, fileAnalysis)
+		modelFunc.ChangeDiff = functionChangeDiff(fileDiff, fn.Name)
+		modelFunc.ChangedLines = fileDiff.ChangedLines(fn.Name)
+		modelFunc.ChangeFocus = changeFocus(path, fn, modelFunc.ChangedLines)
 		functionDetails = append(functionDetails, modelFunc)
 	}
 
 	return &ChangedFileAnalysis{
-		FilePath:          fileDiff.NewPath,
+		FilePath:          path,
 		ModifiedFunctions: modifiedFunctionNames,
 		FunctionDetails:   functionDetails,
 		FileAnalysis:      fileAnalysis,
 	}, nil
 }
 
+// functionChangeDiff renders the added and removed lines attributed to a function
+func functionChangeDiff(fileDiff git.FileDiff, functionName string) string {
+	var diff strings.Builder
+	for _, change := range fileDiff.Changes {
+		if change.Function != functionName {
+			continue
+		}
+		switch change.Type {
+		case git.Added:
+			diff.WriteString("+" + change.Line + "\n")
+		case git.Removed:
+			diff.WriteString("-" + change.Line + "\n")
+		}
+	}
+	return diff.String()
+}
+
+// Functions at least changeFocusMinLines long whose diff touched at most
+// changeFocusMaxShare of their lines get tests aimed at the change
+const (
+	changeFocusMinLines = 40
+	changeFocusMaxShare = 0.25
+)
+
+// changeFocus picks out the changed code of a long function that a diff
+// barely touched, or returns nil
+func changeFocus(path string, fn parser.FunctionInfo, changedLines []int) *models.ChangeFocus {
+	length := fn.EndLine - fn.StartLine + 1
+	if len(changedLines) == 0 || length < changeFocusMinLines || float64(len(changedLines)) > changeFocusMaxShare*float64(length) {
+		return nil
+	}
+	lines, branches, err := parser.ChangeFocus(path, fn.StartLine, changedLines)
+	if err != nil || len(lines) == 0 {
+		return nil
+	}
+	return &models.ChangeFocus{Lines: lines, Branches: branches}
+}
+
 // convertToModelFunction converts parser.FunctionInfo to models.FunctionInfo
 func convertToModelFunction(fn parser.FunctionInfo, fileAnalysis *parser.FileAnalysis) models.FunctionInfo {
 	modelFunc := models.FunctionInfo{
 		Name:      fn.Name,
 		Package:   fn.Package,
 		File:      fn.File,
+		StartLine: fn.StartLine,
+		EndLine:   fn.EndLine,
 		Signature: fn.Signature,
 		IsMethod:  fn.IsMethod,
 		Comments:  fn.Comments,
+		Body:      fn.Body,
+
+		IsDeprecated: fn.IsDeprecated,
+		IsGeneric:    fn.IsGeneric,
+		UnusedParams: fn.UnusedParams,
+
+		IsExternalImpl: fn.IsExternalImpl,
+
+		DroppedContext: fn.DroppedContext,
 	}
 
 	// Convert parameters
@@ -131,6 +261,7 @@ func convertToModelFunction(fn parser.FunctionInfo, fileAnalysis *parser.FileAna
 			Type: ret.Type,
 		})
 	}
+	modelFunc.ReturnPattern = classifyReturns(modelFunc.Returns)
 
 	// Convert receiver if method
 	if fn.IsMethod && fn.Receiver != nil {
@@ -138,6 +269,9 @@ func convertToModelFunction(fn parser.FunctionInfo, fileAnalysis *parser.FileAna
 			Name: fn.Receiver.Name,
 			Type: fn.Receiver.Type,
 		}
+		for _, name := range fn.Receiver.TypeParams {
+			modelFunc.Receiver.TypeParams = append(modelFunc.Receiver.TypeParams, models.TypeParam{Name: name})
+		}
 	}
 
 	// Convert complexity info
@@ -147,6 +281,10 @@ func convertToModelFunction(fn parser.FunctionInfo, fileAnalysis *parser.FileAna
 		HasInterfaces:        fn.Complexity.HasInterfaces,
 		HasChannels:          fn.Complexity.HasChannels,
 		HasGoroutines:        fn.Complexity.HasGoroutines,
+		MutatesArgs:          fn.Complexity.MutatesArgs,
+		UsesEnv:              fn.Complexity.UsesEnv,
+		UsesWorkingDir:       fn.Complexity.UsesWorkingDir,
+		UsesFilesystem:       fn.Complexity.UsesFilesystem,
 		Dependencies:         fn.Complexity.Dependencies,
 		CyclomaticComplexity: fn.Complexity.CyclomaticComplexity,
 	}
@@ -154,21 +292,191 @@ func convertToModelFunction(fn parser.FunctionInfo, fileAnalysis *parser.FileAna
 	return modelFunc
 }
 
+// classifyReturns names the shape of a function's results; results of
+// another shape, such as a plain value, have none
+func classifyReturns(returns []models.ReturnInfo) models.ReturnPattern {
+	n := len(returns)
+	switch {
+	case n == 0:
+		return models.ReturnsNone
+	case returns[n-1].Type == "error" && n == 1:
+		return models.ReturnsErrOnly
+	case returns[n-1].Type == "error" && n == 2:
+		return models.ReturnsValueErr
+	case returns[n-1].Type == "error":
+		return models.ReturnsMultiValueErr
+	case returns[n-1].Type == "bool" && n == 2:
+		return models.ReturnsValueOk
+	default:
+		return ""
+	}
+}
+
 // buildGenerationTargets creates the list of functions to generate tests for
 func buildGenerationTargets(changedFiles []ChangedFileAnalysis) []models.FunctionInfo {
+	defer report.Time(report.PhaseFilter)()
 	var targets []models.FunctionInfo
+	packages := make(map[string]packageDecls)
+	testValues := make(map[string]*parser.TestValues)
+	fuzzSeeds := make(map[string]*parser.FuzzSeeds)
 
 	for _, file := range changedFiles {
 		for _, fn := range file.FunctionDetails {
-			if shouldGenerateTest(fn) {
+			// Functions asked for by name are targeted whatever the filters
+			// say; bodyless declarations only make sense as targets then
+			if fn.Explicit || shouldGenerateTest(fn) && !fn.IsExternalImpl {
+				if fn.IsMethod && fn.Receiver != nil {
+					resolveReceiver(&fn, packages)
+				}
+				if file.FileAnalysis != nil {
+					resolveInterfaceReturns(&fn, packages, file.FileAnalysis.Imports)
+				}
+				attachExampleValues(&fn, testValues)
+				attachFuzzSeeds(&fn, fuzzSeeds)
 				targets = append(targets, fn)
 			}
 		}
 	}
 
+	SortTargets(targets)
 	return targets
 }
 
+// SortTargets orders targets as they appear in the source, by file and then
+// by line, whatever order the diff listed them in, so prompts, batches and
+// test files follow reading order
+func SortTargets(targets []models.FunctionInfo) {
+	slices.SortStableFunc(targets, func(a, b models.FunctionInfo) int {
+		return cmp.Or(strings.Compare(a.File, b.File), cmp.Compare(a.StartLine, b.StartLine))
+	})
+}
+
+// packageDecls caches a package's parsed declarations by directory
+type packageDecls struct {
+	decls *parser.PackageDecls
+	err   error
+}
+
+// resolveReceiver attaches the method's receiver type definition, which is
+// usually declared in another file of the package, and the constraints of a
+// generic receiver's type parameters, or notes why it couldn't be resolved
+func resolveReceiver(fn *models.FunctionInfo, packages map[string]packageDecls) {
+	pkg := loadPackageDecls(fn.File, packages)
+	if pkg.err != nil {
+		fn.ReceiverUnresolved = pkg.err.Error()
+		return
+	}
+	if params := fn.Receiver.TypeParams; len(params) > 0 {
+		// Matched by position: methods may rename the declaration's parameters
+		constraints := pkg.decls.TypeParamConstraints(fn.Receiver.Type)
+		for i := range params {
+			if i < len(constraints) {
+				params[i].Constraint = constraints[i]
+			}
+		}
+	}
+	definition, err := pkg.decls.ReceiverDefinition(fn.Receiver.Type)
+	if err != nil {
+		fn.ReceiverUnresolved = err.Error()
+		report.Verbosef("Receiver of %s not resolved: %v\n", MethodName(*fn), err)
+		return
+	}
+	fn.ReceiverDefinition = definition
+}
+
+// loadPackageDecls returns the declarations of the package containing
+// file, parsing them once per directory
+func loadPackageDecls(file string, packages map[string]packageDecls) packageDecls {
+	dir := filepath.Dir(file)
+	pkg, ok := packages[dir]
+	if !ok {
+		pkg.decls, pkg.err = parser.ParsePackageDecls(file)
+		packages[dir] = pkg
+	}
+	return pkg
+}
+
+// resolveInterfaceReturns records the function's results of interface type
+// with their method sets and the package types it returns for them, so
+// tests can assert behavior through the interface. error and empty
+// interfaces have nothing worth calling and are skipped.
+func resolveInterfaceReturns(fn *models.FunctionInfo, packages map[string]packageDecls, imports []parser.ImportInfo) {
+	var pkg packageDecls
+	for i, ret := range fn.Returns {
+		switch {
+		case ret.Type == "error", ret.Type == "any", strings.HasPrefix(ret.Type, "interface{"), strings.ContainsAny(ret.Type, "*[]()"):
+			continue
+		}
+		if pkg.decls == nil && pkg.err == nil {
+			if pkg = loadPackageDecls(fn.File, packages); pkg.err != nil {
+				return
+			}
+		}
+
+		methods, ok, err := pkg.decls.InterfaceMethods(ret.Type, imports)
+		if err != nil {
+			report.Verbosef("Methods of %s returned by %s not resolved: %v\n", ret.Type, fn.Name, err)
+		}
+		if !ok || (len(methods) == 0 && err == nil) {
+			continue // not an interface, or an empty one
+		}
+		result := models.InterfaceReturn{Type: ret.Type, Methods: methods}
+		if err != nil {
+			result.Unresolved = err.Error()
+		}
+		if fn.Body != "" {
+			result.Concrete = pkg.decls.ConcreteTypesIn(fn.Body, i)
+		}
+		fn.InterfaceReturns = append(fn.InterfaceReturns, result)
+	}
+}
+
+// attachExampleValues attaches the literal values the package's existing
+// tests use for the function, so new tests stay consistent with them
+func attachExampleValues(fn *models.FunctionInfo, testValues map[string]*parser.TestValues) {
+	dir := filepath.Dir(fn.File)
+	values, ok := testValues[dir]
+	if !ok {
+		values, _ = parser.ParseTestValues(fn.File)
+		testValues[dir] = values
+	}
+	if values == nil {
+		return
+	}
+
+	receiverType := ""
+	if fn.IsMethod && fn.Receiver != nil {
+		receiverType = fn.Receiver.Type
+	}
+	fn.ExampleValues = values.ExamplesFor(fn.Name, receiverType)
+}
+
+// attachFuzzSeeds attaches, for functions whose parameters can all be
+// fuzzed, the literal arguments the package and its tests call them with,
+// as seeds for a fuzz test's corpus
+func attachFuzzSeeds(fn *models.FunctionInfo, fuzzSeeds map[string]*parser.FuzzSeeds) {
+	if len(fn.Parameters) == 0 || fn.IsGeneric {
+		return
+	}
+	paramTypes := make([]string, len(fn.Parameters))
+	for i, param := range fn.Parameters {
+		if !parser.FuzzableType(param.Type) {
+			return
+		}
+		paramTypes[i] = param.Type
+	}
+
+	dir := filepath.Dir(fn.File)
+	seeds, ok := fuzzSeeds[dir]
+	if !ok {
+		seeds, _ = parser.ParseFuzzSeeds(fn.File)
+		fuzzSeeds[dir] = seeds
+	}
+	if seeds != nil {
+		fn.FuzzSeeds = seeds.SeedsFor(fn.Name, paramTypes)
+	}
+}
+
 // shouldGenerateTest determines if we should generate a test for this function
 func shouldGenerateTest(fn models.FunctionInfo) bool {
 	// Skip main functions
@@ -186,6 +494,11 @@ func shouldGenerateTest(fn models.FunctionInfo) bool {
 		return false
 	}
 
+	// Functions on the always_include list bypass the remaining filters
+	if filtering.AlwaysIncludes(MethodName(fn)) {
+		return true
+	}
+
 	// Only include exported functions by default (this is our main filter now)
 	if !isExported(fn.Name) {
 		return false
@@ -196,7 +509,9 @@ func shouldGenerateTest(fn models.FunctionInfo) bool {
 		return false
 	}
 
-	// Skip functions with no parameters and no return values (usually not worth testing)
+	// Skip functions with no parameters and no return values (usually not worth testing).
+	// Functions that take parameters but return nothing are kept: their behavior is
+	// observable through side effects (see IsSideEffectOnly).
 	if len(fn.Parameters) == 0 && len(fn.Returns) == 0 {
 		return false
 	}
@@ -204,6 +519,111 @@ func shouldGenerateTest(fn models.FunctionInfo) bool {
 	return true
 }
 
+// filteredOut reports whether the filters would leave fn out of the targets
+// had it not been asked for by name
+func filteredOut(fn models.FunctionInfo) bool {
+	if filtering.AlwaysIncludes(MethodName(fn)) {
+		return !shouldGenerateTest(fn)
+	}
+	return !shouldGenerateTest(fn) || fn.IsExternalImpl ||
+		filtering.SideEffects == "skip" && IsSideEffectOnly(fn) ||
+		fn.IsDeprecated && !filtering.IncludeDeprecated
+}
+
+// IsSideEffectOnly reports whether fn takes parameters but returns nothing, so
+// its behavior is only observable through side effects such as logging,
+// metrics or writes to its dependencies
+func IsSideEffectOnly(fn models.FunctionInfo) bool {
+	return len(fn.Parameters) > 0 && len(fn.Returns) == 0
+}
+
+// ExcludeSideEffectOnly removes side-effect-only functions from targets and
+// returns them separately so callers can report what was skipped. Functions
+// on the always_include list or asked for by name are kept.
+func ExcludeSideEffectOnly(targets []models.FunctionInfo) ([]models.FunctionInfo, []models.FunctionInfo) {
+	var kept, skipped []models.FunctionInfo
+	for _, fn := range targets {
+		if IsSideEffectOnly(fn) && !fn.Explicit && !filtering.AlwaysIncludes(MethodName(fn)) {
+			skipped = append(skipped, fn)
+		} else {
+			kept = append(kept, fn)
+		}
+	}
+	return kept, skipped
+}
+
+// ExcludeDeprecated removes deprecated functions from targets and returns
+// them separately so callers can report what was skipped. Functions on the
+// always_include list or asked for by name are kept.
+func ExcludeDeprecated(targets []models.FunctionInfo) ([]models.FunctionInfo, []models.FunctionInfo) {
+	var kept, skipped []models.FunctionInfo
+	for _, fn := range targets {
+		if fn.IsDeprecated && !fn.Explicit && !filtering.AlwaysIncludes(MethodName(fn)) {
+			skipped = append(skipped, fn)
+		} else {
+			kept = append(kept, fn)
+		}
+	}
+	return kept, skipped
+}
+
+// PromotedTargets returns extra targets for changed methods that exported
+// outer types expose through embedding, so the outer type's behavior is tested
+// too. Each target is the method as seen on the outer type, carrying both
+// type definitions for the prompt.
+func PromotedTargets(result *AnalysisResult) []models.FunctionInfo {
+	var targets []models.FunctionInfo
+
+	for _, file := range result.ChangedFiles {
+		if file.FileAnalysis == nil {
+			continue
+		}
+
+		for _, fn := range file.FunctionDetails {
+			if !fn.IsMethod || fn.Receiver == nil || !isExported(fn.Name) {
+				continue
+			}
+
+			embedded := parser.BaseTypeName(fn.Receiver.Type)
+			for _, outer := range file.FileAnalysis.PromotingTypes(embedded, fn.Name) {
+				target := fn
+				target.Receiver = &models.ReceiverInfo{Type: outer}
+				target.PromotedFrom = embedded
+				target.Signature = promotedSignature(fn.Signature, outer)
+				target.TypeDefinitions = []string{
+					file.FileAnalysis.TypeDefinition(outer),
+					file.FileAnalysis.TypeDefinition(embedded),
+				}
+				targets = append(targets, target)
+			}
+		}
+	}
+
+	return targets
+}
+
+// promotedSignature rewrites a method signature's receiver to the outer type
+func promotedSignature(signature, outer string) string {
+	if i := strings.Index(signature, ") "); strings.HasPrefix(signature, "func (") && i >= 0 {
+		return fmt.Sprintf("func (%s) %s", outer, signature[i+2:])
+	}
+	return signature
+}
+
+// matchesPlatform reports whether filePath builds for the --goos/--goarch
+// target, noting skipped files in verbose output
+func matchesPlatform(filePath string) bool {
+	match, err := parser.MatchesPlatform(filePath)
+	if err != nil {
+		report.Warnf("failed to read build constraints of %s: %v\n", filePath, err)
+		return true
+	}
+	if !match {
+		report.Verbosef("Skipping %s: excluded by build constraints for %s\n", filePath, parser.Platform())
+	}
+	return match
+}
+
 // isTestFunction checks if function name indicates it's a test
 func isTestFunction(name string) bool {
 	if len(name) < 5 { // Need at least "TestX" (5 chars)
@@ -229,17 +649,39 @@ func GetProjectContext(analysisResult *AnalysisResult) models.RequestContext {
 	context := models.RequestContext{
 		ProjectName: getProjectName(),
 		GitContext:  getGitContext(),
+		GoVersion:   getGoVersion(),
 	}
 
-	// Aggregate imports and constants across all files
+	context.GitContext.FilesDiff = analysisResult.DiffFiles
+	context.GitContext.ChangedLines = targetChangedLines(analysisResult.GenerationTargets)
+	context.GitContext.RecentCommits = targetRecentCommits(analysisResult.GenerationTargets, recentCommits)
+
+	// Aggregate imports, constants and variables across all files
 	importSet := make(map[string]bool)
+	aliases := make(map[string]string)
+	dotImports := make(map[string]bool)
 	allConstants := make(map[string]string)
+	allVariables := make(map[string]string)
 
 	for _, file := range analysisResult.ChangedFiles {
 		if file.FileAnalysis != nil {
 			// Collect unique imports
 			for _, imp := range file.FileAnalysis.Imports {
 				importSet[imp.Path] = true
+				switch imp.Name {
+				case "", "_":
+				case ".":
+					// Tests can't see which identifiers come from a dot
+					// import, so they're asked to qualify them instead
+					if !dotImports[imp.Path] {
+						dotImports[imp.Path] = true
+						report.Warnf("%s dot-imports %q; generated tests will qualify its identifiers instead\n", file.FilePath, imp.Path)
+					}
+				default:
+					if _, ok := aliases[imp.Name]; !ok {
+						aliases[imp.Name] = imp.Path
+					}
+				}
 			}
 
 			// Collect constants
@@ -247,6 +689,11 @@ func GetProjectContext(analysisResult *AnalysisResult) models.RequestContext {
 				allConstants[name] = value
 			}
 
+			// Collect package-level variables
+			for name, value := range file.FileAnalysis.Variables {
+				allVariables[name] = value
+			}
+
 			// Set package name from first file
 			if context.PackageName == "" {
 				context.PackageName = file.FileAnalysis.PackageName
@@ -258,15 +705,71 @@ func GetProjectContext(analysisResult *AnalysisResult) models.RequestContext {
 	for imp := range importSet {
 		context.Imports = append(context.Imports, imp)
 	}
+	if len(aliases) > 0 {
+		context.ImportAliases = aliases
+	}
+	for path := range dotImports {
+		context.DotImports = append(context.DotImports, path)
+	}
+	sort.Strings(context.DotImports)
 	context.Constants = allConstants
+	context.Variables = allVariables
 
 	return context
 }
 
+// targetChangedLines merges the changed lines of the targets. Line numbers
+// are only meaningful within one file, so targets spanning files yield nil.
+func targetChangedLines(targets []models.FunctionInfo) []int {
+	var lines []int
+	for i, fn := range targets {
+		if i > 0 && fn.File != targets[0].File {
+			return nil
+		}
+		lines = append(lines, fn.ChangedLines...)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// targetRecentCommits returns the last n commits to each target's file. It
+// returns nil once git can't read a file's history, as when explicit files
+// are given outside a repository, rather than trying every file.
+func targetRecentCommits(targets []models.FunctionInfo, n int) map[string][]models.RecentCommit {
+	if n <= 0 {
+		return nil
+	}
+	recent := make(map[string][]models.RecentCommit)
+	seen := make(map[string]bool)
+	for _, fn := range targets {
+		if seen[fn.File] {
+			continue
+		}
+		seen[fn.File] = true
+
+		path := fn.SourcePath()
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		commits, err := git.GetRecentCommitsForFile(path, n)
+		if err != nil {
+			report.Verbosef("Skipping recent commits: %v\n", err)
+			return nil
+		}
+		for _, commit := range commits {
+			recent[fn.File] = append(recent[fn.File], models.RecentCommit{Subject: commit.Subject, Author: commit.Author})
+		}
+	}
+	if len(recent) == 0 {
+		return nil
+	}
+	return recent
+}
+
 // getProjectName tries to determine project name from go.mod or directory
 func getProjectName() string {
 	// Try to read go.mod first
-	if content, err := os.ReadFile("go.mod"); err == nil {
+	if content, err := os.ReadFile(git.ResolvePath("go.mod")); err == nil {
 		lines := strings.Split(string(content), "\n")
 		for _, line := range lines {
 			if strings.HasPrefix(line, "module ") {
@@ -280,7 +783,12 @@ func getProjectName() string {
 		}
 	}
 
-	// Fallback to current directory name
+	// Fallback to the repo or current directory name
+	if git.RepoDir != "" {
+		if abs, err := filepath.Abs(git.RepoDir); err == nil {
+			return filepath.Base(abs)
+		}
+	}
 	if wd, err := os.Getwd(); err == nil {
 		return filepath.Base(wd)
 	}
@@ -288,26 +796,35 @@ func getProjectName() string {
 	return "unknown"
 }
 
+// getGoVersion reads the go directive from go.mod (e.g. "1.22.2")
+func getGoVersion() string {
+	version, err := parser.GoLanguageVersion(git.ResolvePath("go.mod"))
+	if err != nil {
+		return ""
+	}
+	return version
+}
+
 // getGitContext extracts git-related context
 func getGitContext() models.GitContext {
 	context := models.GitContext{}
 
 	// Get current branch
-	if cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD"); cmd != nil {
+	if cmd := git.Command("rev-parse", "--abbrev-ref", "HEAD"); cmd != nil {
 		if output, err := cmd.Output(); err == nil {
 			context.Branch = strings.TrimSpace(string(output))
 		}
 	}
 
 	// Get last commit message
-	if cmd := exec.Command("git", "log", "-1", "--pretty=format:%s"); cmd != nil {
+	if cmd := git.Command("log", "-1", "--pretty=format:%s"); cmd != nil {
 		if output, err := cmd.Output(); err == nil {
 			context.CommitMessage = strings.TrimSpace(string(output))
 		}
 	}
 
 	// Get author of last commit
-	if cmd := exec.Command("git", "log", "-1", "--pretty=format:%an"); cmd != nil {
+	if cmd := git.Command("log", "-1", "--pretty=format:%an"); cmd != nil {
 		if output, err := cmd.Output(); err == nil {
 			context.Author = strings.TrimSpace(string(output))
 		}
@@ -318,6 +835,13 @@ func getGitContext() models.GitContext {
 
 // AnalyzeSpecificFunctions analyzes only specific functions in specific files
 func AnalyzeSpecificFunctions(filePaths []string, functionNames []string) (*AnalysisResult, error) {
+	return analyzeFiles(filePaths, functionNames, nil, ProjectRoot())
+}
+
+// analyzeFiles analyzes the named functions of files, further limited to the
+// functions overlapping the files' line ranges (keyed by fileKey) if any,
+// storing paths relative to root
+func analyzeFiles(filePaths []string, functionNames []string, ranges map[string][]LineRange, root string) (*AnalysisResult, error) {
 	result := &AnalysisResult{
 		ChangedFiles: make([]ChangedFileAnalysis, 0, len(filePaths)),
 	}
@@ -332,11 +856,20 @@ func AnalyzeSpecificFunctions(filePaths []string, functionNames []string) (*Anal
 		if !strings.HasSuffix(filePath, ".go") || strings.HasSuffix(filePath, "_test.go") {
 			continue
 		}
+		result.GoFiles++
+
+		if !matchesPlatform(filePath) {
+			continue
+		}
 
 		// Parse the file
-		fileAnalysis, err := parser.ParseFile(filePath)
+		fileAnalysis, err := parseFile(filePath)
 		if err != nil {
-			fmt.Printf("Warning: failed to analyze %s: %v\n", filePath, err)
+			report.Warnf("failed to analyze %s: %v\n", filePath, err)
+			continue
+		}
+		if fileAnalysis.IsCgo && !includeCgo {
+			result.CgoFiles = append(result.CgoFiles, filePath)
 			continue
 		}
 
@@ -344,14 +877,18 @@ func AnalyzeSpecificFunctions(filePaths []string, functionNames []string) (*Anal
 		var filteredFunctions []parser.FunctionInfo
 		var matchedNames []string
 
+		fileRanges := ranges[fileKey(filePath)]
 		for _, fn := range fileAnalysis.Functions {
-			if len(functionNames) == 0 || functionSet[fn.Name] {
+			if (len(functionNames) == 0 || functionSet[fn.Name]) && inLineRanges(fn, fileRanges) {
 				filteredFunctions = append(filteredFunctions, fn)
 				matchedNames = append(matchedNames, fn.Name)
 			}
 		}
 
 		if len(filteredFunctions) == 0 {
+			if len(fileRanges) > 0 {
+				report.Warnf("no functions in %s overlap lines %s\n", filePath, formatLineRanges(fileRanges))
+			}
 			continue
 		}
 
@@ -359,6 +896,7 @@ func AnalyzeSpecificFunctions(filePaths []string, functionNames []string) (*Anal
 		var functionDetails []models.FunctionInfo
 		for _, fn := range filteredFunctions {
 			modelFunc := convertToModelFunction(fn, fileAnalysis)
+			modelFunc.Explicit = len(functionNames) > 0 || len(fileRanges) > 0
 			functionDetails = append(functionDetails, modelFunc)
 		}
 
@@ -375,44 +913,126 @@ func AnalyzeSpecificFunctions(filePaths []string, functionNames []string) (*Anal
 	}
 
 	result.GenerationTargets = buildGenerationTargets(result.ChangedFiles)
+	normalizePaths(result, root)
 	return result, nil
 }
 
-// PrintAnalysisSummary prints a summary of the analysis results
+// inLineRanges reports whether a function overlaps any of ranges; every
+// function does when there are none
+func inLineRanges(fn parser.FunctionInfo, ranges []LineRange) bool {
+	if len(ranges) == 0 {
+		return true
+	}
+	for _, lineRange := range ranges {
+		if lineRange.Overlaps(fn.StartLine, fn.EndLine) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatLineRanges renders ranges for messages ("40-80, 120")
+func formatLineRanges(ranges []LineRange) string {
+	parts := make([]string, len(ranges))
+	for i, lineRange := range ranges {
+		parts[i] = lineRange.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// duplicateFiles counts command-line files dropped as repeats of another argument
+func (r *AnalysisResult) duplicateFiles() int {
+	count := 0
+	for _, rewrite := range r.PathRewrites {
+		if rewrite.Duplicate {
+			count++
+		}
+	}
+	return count
+}
+
+// PrintAnalysisSummary prints a summary of the analysis results. The totals are
+// shown from --summary-only upwards; per-file detail only with --verbose.
 func PrintAnalysisSummary(result *AnalysisResult) {
-	fmt.Printf("Analysis Summary:\n")
-	fmt.Printf("================\n")
-	fmt.Printf("Files analyzed: %d\n", len(result.ChangedFiles))
-	fmt.Printf("Total functions found: %d\n", result.TotalFunctions)
-	fmt.Printf("Modified functions: %d\n", result.ModifiedFunctions)
-	fmt.Printf("Test generation targets: %d\n", len(result.GenerationTargets))
-	fmt.Printf("\n")
+	report.Summaryf("Analysis Summary:\n")
+	report.Summaryf("================\n")
+	report.Summaryf("Files analyzed: %d\n", len(result.ChangedFiles))
+	report.Summaryf("Total functions found: %d\n", result.TotalFunctions)
+	report.Summaryf("Modified functions: %d\n", result.ModifiedFunctions)
+	report.Summaryf("Test generation targets: %d\n", len(result.GenerationTargets))
+	if files, targets := result.duplicateFiles(), result.DuplicateTargets; files > 0 || targets > 0 {
+		report.Summaryf("Duplicates merged: %d files, %d functions\n", files, targets)
+	}
+	var overridden []string
+	for _, fn := range result.GenerationTargets {
+		if fn.Explicit && filteredOut(fn) {
+			overridden = append(overridden, MethodName(fn))
+		}
+	}
+	if len(overridden) > 0 {
+		report.Summaryf("Included despite filters (explicitly requested): %s\n", strings.Join(overridden, ", "))
+	}
+	if len(result.CgoFiles) > 0 {
+		report.Summaryf("Skipped cgo files: %d (--include-cgo analyzes them)\n", len(result.CgoFiles))
+		for _, path := range result.CgoFiles {
+			report.Verbosef("  - %s\n", path)
+		}
+	}
+	var blastRadius []models.FunctionInfo
+	for _, fn := range result.GenerationTargets {
+		if fn.BlastRadius != "" {
+			blastRadius = append(blastRadius, fn)
+		}
+	}
+	if len(blastRadius) > 0 {
+		report.Summaryf("Added by blast radius (%s): %d\n", blastRadius[0].BlastRadius, len(blastRadius))
+		for _, fn := range blastRadius {
+			if len(fn.CallsModified) > 0 {
+				report.Verbosef("  + %s (calls %s)\n", MethodName(fn), strings.Join(fn.CallsModified, ", "))
+			} else {
+				report.Verbosef("  + %s (same package)\n", MethodName(fn))
+			}
+		}
+	}
+	report.Summaryf("\n")
 
 	for _, file := range result.ChangedFiles {
-		fmt.Printf("File: %s\n", file.FilePath)
-		fmt.Printf("  Modified functions: %v\n", file.ModifiedFunctions)
-		fmt.Printf("  Package: %s\n", file.FileAnalysis.PackageName)
-		fmt.Printf("  Imports: %d\n", len(file.FileAnalysis.Imports))
+		report.Verbosef("File: %s\n", file.FilePath)
+		report.Verbosef("  Modified functions: %v\n", file.ModifiedFunctions)
+		report.Verbosef("  Package: %s\n", file.FileAnalysis.PackageName)
+		report.Verbosef("  Imports: %d\n", len(file.FileAnalysis.Imports))
 
 		for _, fn := range file.FunctionDetails {
-			fmt.Printf("    - %s (complexity: %d, params: %d, returns: %d)\n",
+			report.Verbosef("    - %s (complexity: %d, params: %d, returns: %d)\n",
 				fn.Name, fn.Complexity.CyclomaticComplexity,
 				len(fn.Parameters), len(fn.Returns))
 
 			if fn.Complexity.HasErrors {
-				fmt.Printf("      [handles errors]")
+				report.Verbosef("      [handles errors]")
 			}
 			if fn.Complexity.HasGoroutines {
-				fmt.Printf("      [uses goroutines]")
+				report.Verbosef("      [uses goroutines]")
 			}
 			if fn.Complexity.HasPointers {
-				fmt.Printf("      [uses pointers]")
+				report.Verbosef("      [uses pointers]")
 			}
 			if fn.IsMethod {
-				fmt.Printf("      [method]")
+				report.Verbosef("      [method]")
+			}
+			if fn.IsDeprecated {
+				report.Verbosef("      [deprecated]")
+			}
+			if fn.IsExternalImpl {
+				report.Verbosef("      [no body]")
+			}
+			if len(fn.UnusedParams) > 0 {
+				report.Verbosef("      [unused params: %s]", strings.Join(fn.UnusedParams, ", "))
+			}
+			if fn.DroppedContext != "" {
+				report.Verbosef("      [context not propagated: %s]", fn.DroppedContext)
 			}
-			fmt.Printf("\n")
+			report.Verbosef("\n")
 		}
-		fmt.Printf("\n")
+		report.Verbosef("\n")
 	}
 }
@@ -1,14 +1,26 @@
 package analyzer
 
 import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/Eranmonnie/testgen/internal/config"
+	"github.com/Eranmonnie/testgen/internal/git"
 	"github.com/Eranmonnie/testgen/internal/parser"
+	"github.com/Eranmonnie/testgen/internal/report"
 	"github.com/Eranmonnie/testgen/pkg/models"
 )
 
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
 func TestShouldGenerateTest(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -85,6 +97,21 @@ func TestShouldGenerateTest(t *testing.T) {
 			},
 			expected: false,
 		},
+		{
+			name: "function with params but no returns is kept for side effect testing",
+			function: models.FunctionInfo{
+				Name: "LogEvent",
+				Parameters: []models.ParameterInfo{
+					{Name: "logger", Type: "*log.Logger"},
+					{Name: "event", Type: "string"},
+				},
+				Returns: []models.ReturnInfo{},
+				Complexity: models.ComplexityInfo{
+					CyclomaticComplexity: 1,
+				},
+			},
+			expected: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -97,6 +124,111 @@ func TestShouldGenerateTest(t *testing.T) {
 	}
 }
 
+func TestShouldGenerateTestAlwaysInclude(t *testing.T) {
+	original := filtering
+	t.Cleanup(func() { filtering = original })
+	SetFiltering(config.FilterConfig{AlwaysInclude: []string{"validateToken", "Session.Refresh"}})
+
+	tests := []struct {
+		name     string
+		function models.FunctionInfo
+		expected bool
+	}{
+		{
+			name:     "unexported function on the list",
+			function: models.FunctionInfo{Name: "validateToken", Parameters: []models.ParameterInfo{{Name: "token", Type: "string"}}},
+			expected: true,
+		},
+		{
+			name:     "complex method on the list without params or returns",
+			function: models.FunctionInfo{Name: "Refresh", Receiver: &models.ReceiverInfo{Name: "s", Type: "*Session"}, Complexity: models.ComplexityInfo{CyclomaticComplexity: 30}},
+			expected: true,
+		},
+		{
+			name:     "same method name on another type",
+			function: models.FunctionInfo{Name: "Refresh", Receiver: &models.ReceiverInfo{Name: "c", Type: "*Cache"}},
+			expected: false,
+		},
+		{
+			name:     "test functions stay excluded",
+			function: models.FunctionInfo{Name: "TestvalidateToken"},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := shouldGenerateTest(tt.function); result != tt.expected {
+				t.Errorf("shouldGenerateTest() = %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExcludeSideEffectOnly(t *testing.T) {
+	targets := []models.FunctionInfo{
+		{
+			Name:       "ValidateUser",
+			Parameters: []models.ParameterInfo{{Name: "u", Type: "*User"}},
+			Returns:    []models.ReturnInfo{{Type: "error"}},
+		},
+		{
+			Name:       "LogEvent",
+			Parameters: []models.ParameterInfo{{Name: "event", Type: "string"}},
+		},
+	}
+
+	kept, skipped := ExcludeSideEffectOnly(targets)
+
+	if len(kept) != 1 || kept[0].Name != "ValidateUser" {
+		t.Errorf("Expected only ValidateUser to be kept, got %v", kept)
+	}
+	if len(skipped) != 1 || skipped[0].Name != "LogEvent" {
+		t.Errorf("Expected LogEvent to be skipped, got %v", skipped)
+	}
+}
+
+func TestExcludeDeprecated(t *testing.T) {
+	original := filtering
+	t.Cleanup(func() { filtering = original })
+	SetFiltering(config.FilterConfig{AlwaysInclude: []string{"LegacyHash"}})
+
+	targets := []models.FunctionInfo{
+		{Name: "ValidateUser"},
+		{Name: "OldValidate", IsDeprecated: true},
+		{Name: "LegacyHash", IsDeprecated: true},
+	}
+
+	kept, skipped := ExcludeDeprecated(targets)
+
+	if len(kept) != 2 || kept[0].Name != "ValidateUser" || kept[1].Name != "LegacyHash" {
+		t.Errorf("Expected ValidateUser and the always-included LegacyHash to be kept, got %v", kept)
+	}
+	if len(skipped) != 1 || skipped[0].Name != "OldValidate" {
+		t.Errorf("Expected OldValidate to be skipped, got %v", skipped)
+	}
+}
+
+func TestSortTargets(t *testing.T) {
+	targets := []models.FunctionInfo{
+		{Name: "Validate", File: "user.go", StartLine: 12},
+		{Name: "Parse", File: "user.go", StartLine: 5},
+		{Name: "Handle", File: "handler.go", StartLine: 40},
+		{Name: "Parse", File: "user.go", StartLine: 5, PromotedFrom: "Base"},
+	}
+
+	SortTargets(targets)
+
+	var got []string
+	for _, fn := range targets {
+		got = append(got, fmt.Sprintf("%s:%d:%s%s", fn.File, fn.StartLine, fn.Name, fn.PromotedFrom))
+	}
+	want := []string{"handler.go:40:Handle", "user.go:5:Parse", "user.go:5:ParseBase", "user.go:12:Validate"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected targets by file and line, ties in their order, got %v", got)
+	}
+}
+
 func TestIsTestFunction(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -222,6 +354,40 @@ func TestConvertToModelFunction(t *testing.T) {
 	if !modelFunc.Complexity.HasErrors {
 		t.Error("Expected HasErrors to be true")
 	}
+
+	if modelFunc.ReturnPattern != models.ReturnsErrOnly {
+		t.Errorf("Expected return pattern %q, got %q", models.ReturnsErrOnly, modelFunc.ReturnPattern)
+	}
+}
+
+func TestClassifyReturns(t *testing.T) {
+	tests := []struct {
+		name    string
+		returns []string
+		want    models.ReturnPattern
+	}{
+		{"no results", nil, models.ReturnsNone},
+		{"error only", []string{"error"}, models.ReturnsErrOnly},
+		{"value and error", []string{"*User", "error"}, models.ReturnsValueErr},
+		{"values and error", []string{"string", "int", "error"}, models.ReturnsMultiValueErr},
+		{"comma ok", []string{"string", "bool"}, models.ReturnsValueOk},
+		{"plain value", []string{"int"}, ""},
+		{"plain bool", []string{"bool"}, ""},
+		{"error not last", []string{"error", "int"}, ""},
+		{"three with bool last", []string{"string", "int", "bool"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var returns []models.ReturnInfo
+			for _, typ := range tt.returns {
+				returns = append(returns, models.ReturnInfo{Type: typ})
+			}
+			if got := classifyReturns(returns); got != tt.want {
+				t.Errorf("classifyReturns(%v) = %q, want %q", tt.returns, got, tt.want)
+			}
+		})
+	}
 }
 
 func TestGetProjectName(t *testing.T) {
@@ -300,3 +466,1025 @@ func processData(data []byte) error {
 		t.Error("ValidateUser not found in modified functions")
 	}
 }
+
+func TestAnalyzeBodylessAndCgo(t *testing.T) {
+	tmpDir := t.TempDir()
+	mathFile := filepath.Join(tmpDir, "mathx.go")
+	cgoFile := filepath.Join(tmpDir, "sys.go")
+	files := map[string]string{
+		mathFile: "package mathx\n\nfunc Sqrt(x float64) float64\n\nfunc Abs(x float64) float64 {\n\tif x < 0 {\n\t\treturn -x\n\t}\n\treturn x\n}\n",
+		cgoFile:  "package mathx\n\n// #include <unistd.h>\nimport \"C\"\n\nfunc PageSize() int {\n\treturn int(C.getpagesize())\n}\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+	targetNames := func(result *AnalysisResult) []string {
+		var names []string
+		for _, fn := range result.GenerationTargets {
+			names = append(names, fn.Name)
+		}
+		return names
+	}
+
+	result, err := analyzeFiles([]string{mathFile, cgoFile}, nil, nil, ProjectRoot())
+	if err != nil {
+		t.Fatalf("analyzeFiles failed: %v", err)
+	}
+	if names := targetNames(result); !reflect.DeepEqual(names, []string{"Abs"}) {
+		t.Errorf("Expected only Abs as a target, got %v", names)
+	}
+	if !reflect.DeepEqual(result.CgoFiles, []string{cgoFile}) {
+		t.Errorf("Expected the cgo file to be skipped, got %v", result.CgoFiles)
+	}
+
+	// Named with --function, a bodyless declaration is targeted
+	result, err = analyzeFiles([]string{mathFile}, []string{"Sqrt"}, nil, ProjectRoot())
+	if err != nil {
+		t.Fatalf("analyzeFiles failed: %v", err)
+	}
+	if len(result.GenerationTargets) != 1 || !result.GenerationTargets[0].IsExternalImpl {
+		t.Errorf("Expected Sqrt as an external target, got %+v", result.GenerationTargets)
+	}
+
+	SetIncludeCgo(true)
+	defer SetIncludeCgo(false)
+	result, err = analyzeFiles([]string{cgoFile}, nil, nil, ProjectRoot())
+	if err != nil {
+		t.Fatalf("analyzeFiles failed: %v", err)
+	}
+	if names := targetNames(result); !reflect.DeepEqual(names, []string{"PageSize"}) || len(result.CgoFiles) != 0 {
+		t.Errorf("Expected PageSize as a target with --include-cgo, got %v (skipped %v)", names, result.CgoFiles)
+	}
+}
+
+func TestPromotedTargets(t *testing.T) {
+	fixture := filepath.Join("..", "..", "pkg", "parser", "testdata", "embedding", "store.go")
+
+	result, err := AnalyzeSpecificFunctions([]string{fixture}, []string{"Get"})
+	if err != nil {
+		t.Fatalf("AnalyzeSpecificFunctions failed: %v", err)
+	}
+
+	targets := PromotedTargets(result)
+
+	var outers []string
+	for _, target := range targets {
+		outers = append(outers, target.Receiver.Type)
+		if target.PromotedFrom != "cache" {
+			t.Errorf("%s: expected PromotedFrom cache, got %q", target.Receiver.Type, target.PromotedFrom)
+		}
+		wantSignature := "func (" + target.Receiver.Type + ") Get(key string) (string, bool)"
+		if target.Signature != wantSignature {
+			t.Errorf("Expected signature %q, got %q", wantSignature, target.Signature)
+		}
+		if len(target.TypeDefinitions) != 2 || !strings.Contains(target.TypeDefinitions[1], "type cache struct") {
+			t.Errorf("%s: expected outer and cache definitions, got %v", target.Receiver.Type, target.TypeDefinitions)
+		}
+	}
+
+	// Shadowed.Get is its own method and private is unexported
+	if want := []string{"Store", "Layered"}; !reflect.DeepEqual(outers, want) {
+		t.Errorf("Expected promoted targets on %v, got %v", want, outers)
+	}
+}
+
+func TestBuildGenerationTargetsReceiverDefinition(t *testing.T) {
+	fixture := filepath.Join("..", "parser", "testdata", "receiver", "methods.go")
+
+	result, err := AnalyzeSpecificFunctions([]string{fixture}, []string{"Withdraw"})
+	if err != nil {
+		t.Fatalf("AnalyzeSpecificFunctions failed: %v", err)
+	}
+	if len(result.GenerationTargets) != 1 {
+		t.Fatalf("Expected 1 target, got %d", len(result.GenerationTargets))
+	}
+
+	// Account is declared in types.go, not next to the method
+	target := result.GenerationTargets[0]
+	if !strings.Contains(target.ReceiverDefinition, "type Account struct") || target.ReceiverUnresolved != "" {
+		t.Errorf("Expected Account's definition from types.go, got %q (unresolved: %q)",
+			target.ReceiverDefinition, target.ReceiverUnresolved)
+	}
+}
+
+func TestBlastRadiusTargets(t *testing.T) {
+	fixture := filepath.Join("..", "parser", "testdata", "blastradius", "amount.go")
+
+	// Only the unexported helper changed; asked for by name, it's the only direct target
+	result, err := AnalyzeSpecificFunctions([]string{fixture}, []string{"parseAmount"})
+	if err != nil {
+		t.Fatalf("AnalyzeSpecificFunctions failed: %v", err)
+	}
+	if len(result.GenerationTargets) != 1 || result.GenerationTargets[0].Name != "parseAmount" {
+		t.Fatalf("Expected parseAmount as the only direct target, got %d", len(result.GenerationTargets))
+	}
+
+	names := func(targets []models.FunctionInfo) []string {
+		var names []string
+		for _, fn := range targets {
+			names = append(names, MethodName(fn))
+		}
+		return names
+	}
+
+	if targets := BlastRadiusTargets(result, config.BlastRadiusFunction); len(targets) != 0 {
+		t.Errorf("Expected no extra targets for 'function', got %v", names(targets))
+	}
+
+	// Direct exported callers only: not AddAll (calls Add) or sumParsed (unexported)
+	callers := BlastRadiusTargets(result, config.BlastRadiusCallers)
+	if got, want := names(callers), []string{"Total", "Invoice.Add"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected callers %v, got %v", want, got)
+	}
+	for _, fn := range callers {
+		if fn.BlastRadius != config.BlastRadiusCallers || !reflect.DeepEqual(fn.CallsModified, []string{"parseAmount"}) {
+			t.Errorf("%s: expected to be marked as calling parseAmount, got %q %v", fn.Name, fn.BlastRadius, fn.CallsModified)
+		}
+	}
+
+	pkg := BlastRadiusTargets(result, config.BlastRadiusPackage)
+	if got, want := names(pkg), []string{"Total", "Invoice.Add", "Invoice.AddAll", "Describe"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected package targets %v, got %v", want, got)
+	}
+	if len(pkg[3].CallsModified) != 0 || pkg[3].BlastRadius != config.BlastRadiusPackage {
+		t.Errorf("Expected Describe added for its package only, got %q %v", pkg[3].BlastRadius, pkg[3].CallsModified)
+	}
+}
+
+func TestGetGoVersion(t *testing.T) {
+	originalDir, _ := os.Getwd()
+	tmpDir := t.TempDir()
+
+	goModContent := `module github.com/user/legacy
+
+go 1.16
+
+require github.com/pkg/errors v0.9.1
+`
+	if err := os.WriteFile(filepath.Join(tmpDir, "go.mod"), []byte(goModContent), 0644); err != nil {
+		t.Fatalf("Failed to write go.mod: %v", err)
+	}
+
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+	defer os.Chdir(originalDir)
+
+	if version := getGoVersion(); version != "1.16" {
+		t.Errorf("Expected go version %q, got %q", "1.16", version)
+	}
+
+	context := GetProjectContext(&AnalysisResult{})
+	if context.GoVersion != "1.16" {
+		t.Errorf("Expected context go version %q, got %q", "1.16", context.GoVersion)
+	}
+}
+
+func TestCanonicalizePathsSymlinks(t *testing.T) {
+	originalDir, _ := os.Getwd()
+	tmpDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to resolve temp dir: %v", err)
+	}
+
+	project := filepath.Join(tmpDir, "project")
+	outside := filepath.Join(tmpDir, "outside")
+	for _, dir := range []string{filepath.Join(project, "pkg"), outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	files := map[string]string{
+		filepath.Join(project, "go.mod"):         "module example.com/project\n",
+		filepath.Join(project, "pkg", "user.go"): "package pkg\n",
+		filepath.Join(outside, "other.go"):       "package other\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+	if err := os.Symlink(filepath.Join("pkg", "user.go"), filepath.Join(project, "link.go")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "other.go"), filepath.Join(project, "escape.go")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	if err := os.Chdir(project); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+	defer os.Chdir(originalDir)
+
+	paths, rewrites, err := CanonicalizePaths([]string{"link.go", "pkg/user.go", "missing.go"})
+	if err != nil {
+		t.Fatalf("CanonicalizePaths failed: %v", err)
+	}
+
+	want := []string{filepath.Join("pkg", "user.go"), "missing.go"}
+	if len(paths) != len(want) || paths[0] != want[0] || paths[1] != want[1] {
+		t.Errorf("Expected paths %v, got %v", want, paths)
+	}
+
+	wantRewrites := []PathRewrite{
+		{Input: "link.go", Canonical: filepath.Join("pkg", "user.go")},
+		{Input: "pkg/user.go", Canonical: filepath.Join("pkg", "user.go"), Duplicate: true},
+	}
+	if len(rewrites) != len(wantRewrites) {
+		t.Fatalf("Expected rewrites %v, got %v", wantRewrites, rewrites)
+	}
+	for i := range wantRewrites {
+		if rewrites[i] != wantRewrites[i] {
+			t.Errorf("Rewrite %d: expected %+v, got %+v", i, wantRewrites[i], rewrites[i])
+		}
+	}
+
+	if _, _, err := CanonicalizePaths([]string{"escape.go"}); err == nil {
+		t.Error("Expected error for symlink escaping the project root")
+	}
+
+	// Naming a file outside the root directly is not a symlink escape
+	if _, _, err := CanonicalizePaths([]string{filepath.Join(outside, "other.go")}); err != nil {
+		t.Errorf("Unexpected error for explicit outside path: %v", err)
+	}
+}
+
+func TestCanonicalizePathsCase(t *testing.T) {
+	originalDir, _ := os.Getwd()
+	tmpDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to resolve temp dir: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "go.mod"), []byte("module example.com/p\n"), 0644); err != nil {
+		t.Fatalf("Failed to write go.mod: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "user.go"), []byte("package p\n"), 0644); err != nil {
+		t.Fatalf("Failed to write user.go: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "USER.GO")); err != nil {
+		t.Skip("filesystem is case-sensitive")
+	}
+
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+	defer os.Chdir(originalDir)
+
+	paths, rewrites, err := CanonicalizePaths([]string{"User.go", "user.go"})
+	if err != nil {
+		t.Fatalf("CanonicalizePaths failed: %v", err)
+	}
+
+	if len(paths) != 1 || paths[0] != "user.go" {
+		t.Errorf("Expected [user.go], got %v", paths)
+	}
+	if len(rewrites) != 2 || rewrites[0].Input != "User.go" || rewrites[0].Canonical != "user.go" || !rewrites[1].Duplicate {
+		t.Errorf("Expected User.go rewritten to user.go and user.go deduplicated, got %v", rewrites)
+	}
+}
+
+func TestAnalysisOutputVerbosity(t *testing.T) {
+	originalDir, _ := os.Getwd()
+	goldenDir := filepath.Join(originalDir, "testdata")
+	tmpDir := t.TempDir()
+
+	source := `package audit
+
+import "log"
+
+// LogEvent records an audit event
+func LogEvent(logger *log.Logger, event string) {
+	logger.Printf("audit: %s", event)
+}
+
+// Count returns the number of events
+func Count(events []string) (int, error) {
+	return len(events), nil
+}
+`
+	if err := os.WriteFile(filepath.Join(tmpDir, "audit.go"), []byte(source), 0644); err != nil {
+		t.Fatalf("Failed to write fixture: %v", err)
+	}
+
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+	defer os.Chdir(originalDir)
+	defer report.SetLevel(report.Normal)
+	defer report.SetOutput(os.Stdout, os.Stderr)
+
+	levels := map[string]report.Level{
+		"quiet":   report.Quiet,
+		"summary": report.Summary,
+		"normal":  report.Normal,
+		"verbose": report.Verbose,
+	}
+
+	for name, level := range levels {
+		t.Run(name, func(t *testing.T) {
+			var out, errOut bytes.Buffer
+			report.SetOutput(&out, &errOut)
+			report.SetLevel(level)
+
+			// The same fixture run at every level: one file that fails to parse, one that analyzes
+			result, err := AnalyzeSpecificFunctions([]string{"missing.go", "audit.go"}, nil)
+			if err != nil {
+				t.Fatalf("AnalyzeSpecificFunctions failed: %v", err)
+			}
+			PrintAnalysisSummary(result)
+			report.Errorf("provider unavailable\n")
+
+			if errOut.String() != "Error: provider unavailable\n" {
+				t.Errorf("Expected errors to stay visible, got %q", errOut.String())
+			}
+
+			got := out.String()
+			path := filepath.Join(goldenDir, "output_"+name+".golden")
+			if *updateGolden {
+				if err := os.MkdirAll(goldenDir, 0755); err != nil {
+					t.Fatalf("Failed to create testdata dir: %v", err)
+				}
+				if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+					t.Fatalf("Failed to update golden file: %v", err)
+				}
+			}
+
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Failed to read golden file %s: %v", path, err)
+			}
+			if got != string(want) {
+				t.Errorf("Output does not match %s\n--- got ---\n%s\n--- want ---\n%s", path, got, want)
+			}
+		})
+	}
+}
+
+// runGit runs a git command in dir and fails the test on error
+func runGit(t *testing.T, dir string, args ...string) {
+	t.Helper()
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v failed: %v\n%s", args, err, output)
+	}
+}
+
+func TestGetProjectContextGitChange(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repo := t.TempDir()
+	runGit(t, repo, "init", "-q", "-b", "fix/validation")
+	runGit(t, repo, "config", "user.email", "jane@example.com")
+	runGit(t, repo, "config", "user.name", "Jane")
+	runGit(t, repo, "config", "commit.gpgsign", "false")
+
+	before := `package user
+
+import "errors"
+
+func ValidateUser(name string) error {
+	if name == "" {
+		return errors.New("empty")
+	}
+	return nil
+}
+`
+	after := `package user
+
+import "errors"
+
+func ValidateUser(name string) error {
+	if name == "" {
+		return errors.New("empty")
+	}
+	if name == "nil" {
+		return errors.New("nil user")
+	}
+	return nil
+}
+`
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(repo, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	write("go.mod", "module example.com/user\n\ngo 1.21\n")
+	write("user.go", before)
+	runGit(t, repo, "add", ".")
+	runGit(t, repo, "commit", "-q", "-m", "initial")
+
+	write("user.go", after)
+	write("NOTES.md", "handle nil users\n")
+	runGit(t, repo, "add", ".")
+	runGit(t, repo, "commit", "-q", "-m", "handle nil user")
+
+	originalDir := git.RepoDir
+	git.Configure("", repo)
+	defer func() { git.RepoDir = originalDir }()
+
+	result, err := AnalyzeChanges("HEAD~1", "HEAD")
+	if err != nil {
+		t.Fatalf("AnalyzeChanges failed: %v", err)
+	}
+	if len(result.GenerationTargets) != 1 {
+		t.Fatalf("Expected 1 generation target, got %d", len(result.GenerationTargets))
+	}
+
+	if lines := result.GenerationTargets[0].ChangedLines; !reflect.DeepEqual(lines, []int{9, 10, 11}) {
+		t.Errorf("Expected target changed lines [9 10 11], got %v", lines)
+	}
+
+	context := GetProjectContext(result)
+	gitContext := context.GitContext
+
+	if !reflect.DeepEqual(gitContext.FilesDiff, []string{"NOTES.md", "user.go"}) {
+		t.Errorf("Expected files diff [NOTES.md user.go], got %v", gitContext.FilesDiff)
+	}
+	if !reflect.DeepEqual(gitContext.ChangedLines, []int{9, 10, 11}) {
+		t.Errorf("Expected changed lines [9 10 11], got %v", gitContext.ChangedLines)
+	}
+	if gitContext.Branch != "fix/validation" || gitContext.Author != "Jane" || gitContext.CommitMessage != "handle nil user" {
+		t.Errorf("Unexpected git context: %+v", gitContext)
+	}
+	if gitContext.RecentCommits != nil {
+		t.Errorf("Expected no recent commits unless ai.recent_commits is set, got %v", gitContext.RecentCommits)
+	}
+
+	SetRecentCommits(1)
+	defer SetRecentCommits(0)
+	file := result.GenerationTargets[0].File
+	want := map[string][]models.RecentCommit{file: {{Subject: "handle nil user", Author: "Jane"}}}
+	if got := GetProjectContext(result).GitContext.RecentCommits; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected recent commits %v, got %v", want, got)
+	}
+
+	// Explicit files outside a repository have no history to show
+	outside := filepath.Join(t.TempDir(), "user.go")
+	if err := os.WriteFile(outside, []byte(after), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git.Configure("", filepath.Dir(outside))
+	explicit, err := AnalyzeSpecificFunctions([]string{outside}, nil)
+	if err != nil {
+		t.Fatalf("AnalyzeSpecificFunctions failed: %v", err)
+	}
+	if len(explicit.GenerationTargets) != 1 {
+		t.Fatalf("Expected 1 generation target, got %d", len(explicit.GenerationTargets))
+	}
+	if got := GetProjectContext(explicit).GitContext.RecentCommits; got != nil {
+		t.Errorf("Expected no recent commits outside a repository, got %v", got)
+	}
+}
+
+func TestAnalyzeChangesTimings(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repo := t.TempDir()
+	runGit(t, repo, "init", "-q")
+	runGit(t, repo, "config", "user.email", "test@example.com")
+	runGit(t, repo, "config", "user.name", "Test User")
+	runGit(t, repo, "config", "commit.gpgsign", "false")
+	write := func(content string) {
+		if err := os.WriteFile(filepath.Join(repo, "sum.go"), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write sum.go: %v", err)
+		}
+	}
+	write("package sum\n\nfunc Sum(a, b int) int {\n\treturn a + b\n}\n")
+	runGit(t, repo, "add", ".")
+	runGit(t, repo, "commit", "-q", "-m", "initial")
+	write("package sum\n\nfunc Sum(a, b int) int {\n\treturn b + a\n}\n")
+	runGit(t, repo, "commit", "-q", "-am", "swap")
+
+	originalDir := git.RepoDir
+	git.Configure("", repo)
+	defer func() { git.RepoDir = originalDir }()
+
+	report.ResetTimings()
+	defer report.ResetTimings()
+	if _, err := AnalyzeChanges("HEAD~1", "HEAD"); err != nil {
+		t.Fatalf("AnalyzeChanges failed: %v", err)
+	}
+
+	ran := map[string]bool{report.PhaseGitDiff: true, report.PhaseParse: true, report.PhaseFilter: true}
+	for _, timing := range report.Timings() {
+		if timing.Seconds < 0 {
+			t.Errorf("Expected a non-negative duration for %s, got %v", timing.Phase, timing.Seconds)
+		}
+		if ran[timing.Phase] != (timing.Count > 0) {
+			t.Errorf("Unexpected %d passes through %s", timing.Count, timing.Phase)
+		}
+	}
+}
+
+func TestExplicitTargetsBypassFilters(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	// Unexported and over the complexity limit: the filters skip it
+	var body strings.Builder
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&body, "\tif n == %d {\n\t\treturn %d\n\t}\n", i, i*i)
+	}
+	source := func(fallback string) string {
+		return "package mathx\n\nfunc square(n int) int {\n" + body.String() + "\treturn " + fallback + "\n}\n"
+	}
+
+	repo := t.TempDir()
+	runGit(t, repo, "init", "-q")
+	runGit(t, repo, "config", "user.email", "test@example.com")
+	runGit(t, repo, "config", "user.name", "Test User")
+	runGit(t, repo, "config", "commit.gpgsign", "false")
+	path := filepath.Join(repo, "square.go")
+	for i, fallback := range []string{"n * n", "n*n + 0"} {
+		if err := os.WriteFile(path, []byte(source(fallback)), 0644); err != nil {
+			t.Fatalf("Failed to write square.go: %v", err)
+		}
+		runGit(t, repo, "add", ".")
+		runGit(t, repo, "commit", "-q", "-m", fmt.Sprintf("change %d", i))
+	}
+
+	originalDir := git.RepoDir
+	git.Configure("", repo)
+	defer func() { git.RepoDir = originalDir }()
+
+	// Swept up by a range, it's still filtered
+	result, err := AnalyzeChanges("HEAD~1", "HEAD")
+	if err != nil {
+		t.Fatalf("AnalyzeChanges failed: %v", err)
+	}
+	if result.ModifiedFunctions != 1 || len(result.GenerationTargets) != 0 {
+		t.Errorf("Expected square to be modified but filtered, got %d modified, targets %v", result.ModifiedFunctions, result.GenerationTargets)
+	}
+
+	// Named, it's generated whatever the filters say, and the summary says so
+	result, err = analyzeFiles([]string{path}, []string{"square"}, nil, ProjectRoot())
+	if err != nil {
+		t.Fatalf("analyzeFiles failed: %v", err)
+	}
+	if len(result.GenerationTargets) != 1 || !result.GenerationTargets[0].Explicit {
+		t.Fatalf("Expected square as an explicit target, got %+v", result.GenerationTargets)
+	}
+	if complexity := result.GenerationTargets[0].Complexity.CyclomaticComplexity; complexity <= 15 {
+		t.Errorf("Expected the fixture to be over the complexity limit, got %d", complexity)
+	}
+
+	var out bytes.Buffer
+	report.SetOutput(&out, &out)
+	defer report.SetOutput(os.Stdout, os.Stderr)
+	PrintAnalysisSummary(result)
+	if !strings.Contains(out.String(), "Included despite filters (explicitly requested): square\n") {
+		t.Errorf("Expected the summary to note the override, got:\n%s", out.String())
+	}
+
+	// Picked by a line range inside it, it's explicit too
+	result, err = analyzeFiles([]string{path}, nil, map[string][]LineRange{fileKey(path): {{Start: 5, End: 5}}}, ProjectRoot())
+	if err != nil {
+		t.Fatalf("analyzeFiles failed: %v", err)
+	}
+	if len(result.GenerationTargets) != 1 || !result.GenerationTargets[0].Explicit {
+		t.Errorf("Expected square as an explicit target by line range, got %+v", result.GenerationTargets)
+	}
+
+	// --all sweeps the file up, so the filters apply again
+	result, err = analyzeFiles([]string{path}, nil, nil, ProjectRoot())
+	if err != nil {
+		t.Fatalf("analyzeFiles failed: %v", err)
+	}
+	if len(result.GenerationTargets) != 0 {
+		t.Errorf("Expected no targets for the whole file, got %+v", result.GenerationTargets)
+	}
+}
+
+func TestParseFileArg(t *testing.T) {
+	tests := []struct {
+		arg       string
+		path      string
+		lineRange *LineRange
+		wantErr   bool
+	}{
+		{arg: "user.go", path: "user.go"},
+		{arg: "internal/user/user.go:40-80", path: "internal/user/user.go", lineRange: &LineRange{Start: 40, End: 80}},
+		{arg: "user.go:42", path: "user.go", lineRange: &LineRange{Start: 42, End: 42}},
+		{arg: `C:\src\user.go:7-9`, path: `C:\src\user.go`, lineRange: &LineRange{Start: 7, End: 9}},
+		{arg: `C:\src\user.go`, path: `C:\src\user.go`},
+		{arg: "user.go:80-40", wantErr: true},
+		{arg: "user.go:0-5", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			path, lineRange, err := ParseFileArg(tt.arg)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error for %q", tt.arg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFileArg failed: %v", err)
+			}
+			if path != tt.path || !reflect.DeepEqual(lineRange, tt.lineRange) {
+				t.Errorf("Expected %q %v, got %q %v", tt.path, tt.lineRange, path, lineRange)
+			}
+		})
+	}
+}
+
+func TestAnalyzeLineRanges(t *testing.T) {
+	testCode := `package user
+
+func First(a int) int {
+	return a
+}
+
+func Second(a int) int {
+	return a * 2
+}
+
+func Third(a int) int {
+	return a * 3
+}
+`
+	userFile := filepath.Join(t.TempDir(), "user.go")
+	if err := os.WriteFile(userFile, []byte(testCode), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		files []string
+		want  []string
+	}{
+		{name: "cursor inside one function", files: []string{userFile + ":8"}, want: []string{"Second"}},
+		{name: "range spanning two functions", files: []string{userFile + ":5-7"}, want: []string{"First", "Second"}},
+		{name: "two ranges of one file", files: []string{userFile + ":3", userFile + ":11-12"}, want: []string{"First", "Third"}},
+		{name: "plain path wins over a range", files: []string{userFile + ":3", userFile}, want: []string{"First", "Second", "Third"}},
+		{name: "range between functions", files: []string{userFile + ":6"}, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Analyze(Sources{Files: tt.files})
+			if err != nil {
+				t.Fatalf("Analyze failed: %v", err)
+			}
+
+			var got []string
+			for _, fn := range result.GenerationTargets {
+				got = append(got, fn.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected targets %v, got %v", tt.want, got)
+			}
+			if result.duplicateFiles() != 0 {
+				t.Errorf("Expected ranges of one file not to count as duplicates, got %d", result.duplicateFiles())
+			}
+		})
+	}
+}
+
+func TestAnalyzeDeduplicatesSources(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repo := t.TempDir()
+	runGit(t, repo, "init", "-q")
+	runGit(t, repo, "config", "user.email", "jane@example.com")
+	runGit(t, repo, "config", "user.name", "Jane")
+	runGit(t, repo, "config", "commit.gpgsign", "false")
+
+	write := func(content string) {
+		if err := os.WriteFile(filepath.Join(repo, "user.go"), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write user.go: %v", err)
+		}
+	}
+	write("package user\n\nfunc ValidateUser(name string) bool {\n\treturn name != \"\"\n}\n")
+	runGit(t, repo, "add", ".")
+	runGit(t, repo, "commit", "-q", "-m", "initial")
+	write("package user\n\nfunc ValidateUser(name string) bool {\n\treturn name != \"\" && name != \"nil\"\n}\n")
+	runGit(t, repo, "commit", "-q", "-am", "reject nil")
+
+	originalDir := git.RepoDir
+	git.Configure("", repo)
+	defer func() { git.RepoDir = originalDir }()
+
+	userFile := filepath.Join(repo, "user.go")
+
+	tests := []struct {
+		name           string
+		sources        Sources
+		duplicateFiles int
+		duplicateFuncs int
+	}{
+		{
+			name:           "same file twice",
+			sources:        Sources{Files: []string{userFile, userFile}},
+			duplicateFiles: 1,
+		},
+		{
+			name:           "file overlapping the git range",
+			sources:        Sources{Files: []string{userFile}, UseRange: true, FromRef: "HEAD~1", ToRef: "HEAD"},
+			duplicateFuncs: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Analyze(tt.sources)
+			if err != nil {
+				t.Fatalf("Analyze failed: %v", err)
+			}
+
+			if len(result.ChangedFiles) != 1 {
+				t.Errorf("Expected 1 analyzed file, got %d", len(result.ChangedFiles))
+			}
+			if len(result.GenerationTargets) != 1 || result.GenerationTargets[0].Name != "ValidateUser" {
+				t.Errorf("Expected a single ValidateUser target, got %v", result.GenerationTargets)
+			}
+			if result.TotalFunctions != 1 {
+				t.Errorf("Expected 1 function counted, got %d", result.TotalFunctions)
+			}
+			if got := result.duplicateFiles(); got != tt.duplicateFiles {
+				t.Errorf("Expected %d duplicate files, got %d", tt.duplicateFiles, got)
+			}
+			if result.DuplicateTargets != tt.duplicateFuncs {
+				t.Errorf("Expected %d duplicate functions, got %d", tt.duplicateFuncs, result.DuplicateTargets)
+			}
+
+			var out bytes.Buffer
+			report.SetOutput(&out, &out)
+			defer report.SetOutput(os.Stdout, os.Stderr)
+			PrintAnalysisSummary(result)
+
+			want := fmt.Sprintf("Duplicates merged: %d files, %d functions", tt.duplicateFiles, tt.duplicateFuncs)
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("Expected summary to note %q, got:\n%s", want, out.String())
+			}
+		})
+	}
+}
+
+func TestAnalyzeNormalizesPaths(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repo := t.TempDir()
+	runGit(t, repo, "init", "-q")
+	runGit(t, repo, "config", "user.email", "jane@example.com")
+	runGit(t, repo, "config", "user.name", "Jane")
+	runGit(t, repo, "config", "commit.gpgsign", "false")
+	pkgDir := filepath.Join(repo, "pkg", "user")
+	if err := os.MkdirAll(pkgDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "go.mod"), []byte("module example.com/app\n\ngo 1.22\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	write := func(content string) {
+		if err := os.WriteFile(filepath.Join(pkgDir, "user.go"), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write user.go: %v", err)
+		}
+	}
+	write("package user\n\nfunc ValidateUser(name string) bool {\n\treturn name != \"\"\n}\n")
+	runGit(t, repo, "add", ".")
+	runGit(t, repo, "commit", "-q", "-m", "initial")
+	write("package user\n\nfunc ValidateUser(name string) bool {\n\treturn name != \"\" && name != \"nil\"\n}\n")
+	runGit(t, repo, "commit", "-q", "-am", "reject nil")
+
+	originalDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(originalDir)
+	originalRepo := git.RepoDir
+	defer func() { git.RepoDir = originalRepo }()
+
+	userFile := filepath.Join(pkgDir, "user.go")
+	wantFile := filepath.Join("pkg", "user", "user.go")
+
+	tests := []struct {
+		name    string
+		dir     string // working directory
+		repoDir string
+		sources Sources
+	}{
+		{name: "relative from the root", dir: repo, sources: Sources{Files: []string{wantFile}}},
+		{name: "absolute from the root", dir: repo, sources: Sources{Files: []string{userFile}}},
+		{name: "relative from a subdirectory", dir: pkgDir, sources: Sources{Files: []string{"user.go"}}},
+		{name: "absolute from a subdirectory", dir: pkgDir, sources: Sources{Files: []string{userFile}}},
+		{name: "relative outside with --repo", dir: filepath.Dir(repo), repoDir: repo, sources: Sources{Files: []string{filepath.Join(filepath.Base(repo), wantFile)}}},
+		{name: "absolute outside with --repo", dir: filepath.Dir(repo), repoDir: repo, sources: Sources{Files: []string{userFile}}},
+		{name: "git range with --repo", dir: filepath.Dir(repo), repoDir: repo, sources: Sources{FromRef: "HEAD~1", ToRef: "HEAD"}},
+	}
+
+	var want []byte
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.Chdir(tt.dir); err != nil {
+				t.Fatal(err)
+			}
+			git.RepoDir = tt.repoDir
+
+			result, err := Analyze(tt.sources)
+			if err != nil {
+				t.Fatalf("Analyze failed: %v", err)
+			}
+			if len(result.GenerationTargets) != 1 {
+				t.Fatalf("Expected 1 generation target, got %d", len(result.GenerationTargets))
+			}
+			target := result.GenerationTargets[0]
+			if target.File != wantFile || result.ChangedFiles[0].FilePath != wantFile {
+				t.Errorf("Expected paths relative to the root, %s, got %s and %s", wantFile, target.File, result.ChangedFiles[0].FilePath)
+			}
+			if _, err := os.Stat(target.SourcePath()); err != nil {
+				t.Errorf("Expected the source to be readable from the working directory: %v", err)
+			}
+
+			// Git ranges also record what changed; explicit files must match byte for byte
+			if tt.sources.FromRef != "" {
+				return
+			}
+			target.ChangedLines, target.ChangeDiff, target.ChangeFocus = nil, "", nil
+			data, err := json.Marshal(result.GenerationTargets)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want == nil {
+				want = data
+			} else if !bytes.Equal(data, want) {
+				t.Errorf("Expected the same JSON for every input form\ngot:  %s\nwant: %s", data, want)
+			}
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	result := &AnalysisResult{
+		ChangedFiles: []ChangedFileAnalysis{{
+			FunctionDetails: []models.FunctionInfo{
+				{Name: "Parse", Complexity: models.ComplexityInfo{CyclomaticComplexity: 3, Dependencies: []string{"fmt", "strings"}}},
+				{Name: "Validate", IsMethod: true, Complexity: models.ComplexityInfo{CyclomaticComplexity: 12, Dependencies: []string{"fmt"}}},
+				{Name: "Route", Complexity: models.ComplexityInfo{CyclomaticComplexity: 25}},
+			},
+		}},
+		TotalFunctions:    3,
+		GenerationTargets: []models.FunctionInfo{{Name: "Parse"}, {Name: "Validate"}},
+	}
+
+	stats := Stats(result)
+	if stats.FilesProcessed != 1 || stats.FunctionsFound != 3 || stats.WouldGenerate != 2 {
+		t.Errorf("Expected 1 file, 3 functions, 2 targets, got %d, %d, %d",
+			stats.FilesProcessed, stats.FunctionsFound, stats.WouldGenerate)
+	}
+	if want := map[string]int{"function": 2, "method": 1}; !reflect.DeepEqual(stats.FunctionsByType, want) {
+		t.Errorf("Expected functions by type %v, got %v", want, stats.FunctionsByType)
+	}
+	if want := map[string]int{"1-5": 1, "11-20": 1, "21+": 1}; !reflect.DeepEqual(stats.ComplexityDistribution, want) {
+		t.Errorf("Expected complexity distribution %v, got %v", want, stats.ComplexityDistribution)
+	}
+	if got := hotspots(stats.DependencyHotspots); !reflect.DeepEqual(got, []string{"fmt", "strings"}) {
+		t.Errorf("Expected hotspots [fmt strings], got %v", got)
+	}
+
+	// Each run appends one line
+	path := filepath.Join(t.TempDir(), ".testgen", "stats.jsonl")
+	for i := 0; i < 2; i++ {
+		if err := RecordStats(path, stats); err != nil {
+			t.Fatalf("RecordStats failed: %v", err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"would_generate":2`) {
+		t.Errorf("Expected two JSON lines with would_generate, got:\n%s", data)
+	}
+}
+
+func TestNoTargetsReason(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repo := t.TempDir()
+	runGit(t, repo, "init", "-q")
+	runGit(t, repo, "config", "user.email", "jane@example.com")
+	runGit(t, repo, "config", "user.name", "Jane")
+	runGit(t, repo, "config", "commit.gpgsign", "false")
+
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(repo, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	commit := func(message string) {
+		runGit(t, repo, "add", ".")
+		runGit(t, repo, "commit", "-q", "-m", message)
+	}
+
+	write("go.mod", "module example.com/user\n\ngo 1.21\n")
+	write("user.go", "package user\n\nvar limit = 1\n\nfunc Validate(name string) bool {\n\treturn name != \"\"\n}\n\nfunc normalize(name string) string {\n\treturn name\n}\n")
+	commit("initial")
+
+	write("README.md", "docs\n")
+	commit("docs only")
+
+	write("user.go", "package user\n\nvar limit = 2\n\nfunc Validate(name string) bool {\n\treturn name != \"\"\n}\n\nfunc normalize(name string) string {\n\treturn name\n}\n")
+	commit("change a variable")
+
+	write("user.go", "package user\n\nvar limit = 2\n\nfunc Validate(name string) bool {\n\treturn name != \"\"\n}\n\nfunc normalize(name string) string {\n\treturn name + \"\"\n}\n")
+	commit("change an unexported function")
+
+	write("user.go", "package user\n\nvar limit = 2\n\nfunc Validate(name string) bool {\n\treturn len(name) > 0\n}\n\nfunc normalize(name string) string {\n\treturn name + \"\"\n}\n")
+	commit("change an exported function")
+
+	originalDir := git.RepoDir
+	git.Configure("", repo)
+	defer func() { git.RepoDir = originalDir }()
+
+	tests := []struct {
+		from, to string
+		want     string
+	}{
+		{"HEAD~4", "HEAD~3", NoTargetsNoGoFiles},
+		{"HEAD~3", "HEAD~2", NoTargetsNoFunctions},
+		{"HEAD~2", "HEAD~1", NoTargetsFiltered},
+		{"HEAD~1", "HEAD", ""},
+	}
+	for _, tt := range tests {
+		result, err := AnalyzeChanges(tt.from, tt.to)
+		if err != nil {
+			t.Fatalf("AnalyzeChanges(%s, %s) failed: %v", tt.from, tt.to, err)
+		}
+		if got := result.Funnel().NoTargetsReason(); got != tt.want {
+			t.Errorf("%s..%s: expected reason %q, got %q (stages %+v)", tt.from, tt.to, tt.want, got, result.Funnel())
+		}
+	}
+
+	// Targets that all have their tests already empty out last
+	funnel := Funnel{GoFiles: 1, Functions: 2, Filtered: 1}
+	if got := funnel.NoTargetsReason(); got != NoTargetsHaveTests {
+		t.Errorf("Expected reason %q, got %q", NoTargetsHaveTests, got)
+	}
+
+	// Requested files go through the same stages
+	result, err := analyzeFiles([]string{filepath.Join(repo, "README.md")}, nil, nil, ProjectRoot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Funnel().NoTargetsReason(); got != NoTargetsNoGoFiles {
+		t.Errorf("Expected reason %q for a non-Go file, got %q", NoTargetsNoGoFiles, got)
+	}
+	result, err = analyzeFiles([]string{filepath.Join(repo, "user.go")}, []string{"normalize"}, nil, ProjectRoot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Funnel().NoTargetsReason(); got != "" {
+		t.Errorf("Expected an unexported function asked for by name to be targeted, got reason %q", got)
+	}
+}
+
+func TestGetProjectContextValues(t *testing.T) {
+	result := &AnalysisResult{
+		ChangedFiles: []ChangedFileAnalysis{
+			{FilePath: "user.go", FileAnalysis: &parser.FileAnalysis{
+				PackageName: "user",
+				Constants:   map[string]string{"MaxUsers": "100"},
+				Variables:   map[string]string{"DefaultRole": `"member"`},
+			}},
+			{FilePath: "order.go", FileAnalysis: &parser.FileAnalysis{
+				PackageName: "user",
+				Constants:   map[string]string{"MaxItems": "50"},
+				Variables:   map[string]string{"ErrEmpty": "unknown", "retries": "3"},
+			}},
+			{FilePath: "deleted.go"},
+		},
+	}
+
+	context := GetProjectContext(result)
+
+	if want := map[string]string{"MaxUsers": "100", "MaxItems": "50"}; !reflect.DeepEqual(context.Constants, want) {
+		t.Errorf("Expected constants %v, got %v", want, context.Constants)
+	}
+	if want := map[string]string{"DefaultRole": `"member"`, "ErrEmpty": "unknown", "retries": "3"}; !reflect.DeepEqual(context.Variables, want) {
+		t.Errorf("Expected variables %v, got %v", want, context.Variables)
+	}
+	if context.PackageName != "user" {
+		t.Errorf("Expected package user, got %q", context.PackageName)
+	}
+}
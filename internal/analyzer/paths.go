@@ -0,0 +1,217 @@
+package analyzer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Eranmonnie/testgen/internal/git"
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// PathRewrite records an input path that was canonicalized or dropped as a duplicate
+type PathRewrite struct {
+	Input     string
+	Canonical string
+	Duplicate bool // Input resolved to a file already being analyzed
+}
+
+// CanonicalizePaths resolves symlinks and filename case in the input paths so
+// each source file is analyzed once, under its real name and location. Paths
+// that don't exist are passed through unchanged for the parser to report.
+// A symlink that resolves outside the project root is refused.
+func CanonicalizePaths(paths []string) ([]string, []PathRewrite, error) {
+	return canonicalizePaths(paths, ProjectRoot())
+}
+
+// canonicalizePaths is CanonicalizePaths against an already resolved root
+func canonicalizePaths(paths []string, root string) ([]string, []PathRewrite, error) {
+	var canonical []string
+	var rewrites []PathRewrite
+	seen := make(map[string]bool)
+
+	for _, path := range paths {
+		resolved, err := canonicalPath(path, root)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if seen[resolved] {
+			rewrites = append(rewrites, PathRewrite{Input: path, Canonical: resolved, Duplicate: true})
+			continue
+		}
+		seen[resolved] = true
+
+		if resolved != path {
+			rewrites = append(rewrites, PathRewrite{Input: path, Canonical: resolved})
+		}
+		canonical = append(canonical, resolved)
+	}
+
+	return canonical, rewrites, nil
+}
+
+// canonicalPath resolves symlinks in path and corrects its case against the
+// directory entries on disk. The result is relative to the working directory
+// when the file lies beneath it.
+func canonicalPath(path, root string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path, nil
+	}
+
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return path, nil
+	}
+	resolved = correctCase(resolved)
+
+	if root != "" {
+		// Only links are refused: explicitly naming a file outside the root is allowed
+		realRoot := root
+		if r, err := filepath.EvalSymlinks(root); err == nil {
+			realRoot = correctCase(r)
+		}
+		if isWithin(root, abs) && !isWithin(realRoot, resolved) {
+			return "", fmt.Errorf("%s is a symlink to %s, outside the project root %s", path, resolved, root)
+		}
+	}
+
+	if wd, err := os.Getwd(); err == nil {
+		if wd, err := filepath.EvalSymlinks(wd); err == nil && isWithin(correctCase(wd), resolved) {
+			if rel, err := filepath.Rel(correctCase(wd), resolved); err == nil {
+				return rel, nil
+			}
+		}
+	}
+
+	return resolved, nil
+}
+
+// correctCase rewrites each element of an absolute path to the spelling used
+// by its directory entry. On case-sensitive filesystems this is a no-op.
+func correctCase(path string) string {
+	volume := filepath.VolumeName(path)
+	corrected := volume + string(filepath.Separator)
+
+	for _, elem := range strings.Split(strings.TrimPrefix(path[len(volume):], string(filepath.Separator)), string(filepath.Separator)) {
+		if elem == "" {
+			continue
+		}
+		corrected = filepath.Join(corrected, matchDirEntry(corrected, elem))
+	}
+
+	return corrected
+}
+
+// matchDirEntry returns the entry in dir named name, matching case-insensitively
+// when there is no exact match
+func matchDirEntry(dir, name string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return name
+	}
+
+	match := name
+	for _, entry := range entries {
+		if entry.Name() == name {
+			return name
+		}
+		if match == name && strings.EqualFold(entry.Name(), name) {
+			match = entry.Name()
+		}
+	}
+	return match
+}
+
+// isWithin reports whether path is root or lies beneath it
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// ProjectRoot returns the repository directory if configured, otherwise the
+// nearest directory containing go.mod, otherwise the working directory
+func ProjectRoot() string {
+	if git.RepoDir != "" {
+		if abs, err := filepath.Abs(git.RepoDir); err == nil {
+			return abs
+		}
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+
+	for dir := wd; ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir
+		}
+		if filepath.Dir(dir) == dir {
+			return wd
+		}
+	}
+}
+
+// normalizePaths rewrites the paths result stores, of its files, functions
+// and targets, relative to root, so a run stores and emits the same paths
+// however its files were named. Functions record root when it isn't the
+// working directory, for reading their files through SourcePath.
+func normalizePaths(result *AnalysisResult, root string) {
+	result.Root = root
+	functionRoot := functionRoot(root)
+
+	for i := range result.ChangedFiles {
+		file := &result.ChangedFiles[i]
+		file.FilePath = rootRelative(file.FilePath, root)
+		for j := range file.FunctionDetails {
+			normalizeFunction(&file.FunctionDetails[j], root, functionRoot)
+		}
+	}
+	for i := range result.GenerationTargets {
+		normalizeFunction(&result.GenerationTargets[i], root, functionRoot)
+	}
+	for i, path := range result.CgoFiles {
+		result.CgoFiles[i] = rootRelative(path, root)
+	}
+}
+
+// functionRoot returns root for functions to record, or "" when it's the
+// working directory, which their files are read from anyway
+func functionRoot(root string) string {
+	if wd, err := os.Getwd(); err != nil || fileKey(wd) == fileKey(root) {
+		return ""
+	}
+	return root
+}
+
+// normalizeFunction makes fn's file relative to root, which functionRoot
+// records unless it's the working directory
+func normalizeFunction(fn *models.FunctionInfo, root, functionRoot string) {
+	if fn.Root != "" {
+		return // already normalized
+	}
+	fn.File = rootRelative(fn.File, root)
+	if !filepath.IsAbs(fn.File) {
+		fn.Root = functionRoot
+	}
+}
+
+// rootRelative returns path, relative to the working directory or
+// absolute, relative to root, or absolute when it lies outside root
+func rootRelative(path, root string) string {
+	abs, err := filepath.Abs(path)
+	if root == "" || err != nil {
+		return path
+	}
+	for _, candidate := range [][2]string{{root, abs}, {fileKey(root), fileKey(abs)}} {
+		if isWithin(candidate[0], candidate[1]) {
+			if rel, err := filepath.Rel(candidate[0], candidate[1]); err == nil {
+				return rel
+			}
+		}
+	}
+	return abs
+}
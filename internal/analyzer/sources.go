@@ -0,0 +1,225 @@
+package analyzer
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Eranmonnie/testgen/internal/parser"
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// Sources describes what a generate run analyzes: explicit files, a git
+// range, or both. With no files the git range is always analyzed.
+type Sources struct {
+	Files     []string // files given on the command line, optionally as file.go:start-end
+	Functions []string // limit explicit files to these functions (empty = all)
+	FromRef   string
+	ToRef     string
+	UseRange  bool // also analyze FromRef..ToRef when files are given
+}
+
+// Analyze analyzes every requested source and merges the results so each
+// file and each function appears once, however often it was requested
+func Analyze(sources Sources) (*AnalysisResult, error) {
+	var results []*AnalysisResult
+	var rewrites []PathRewrite
+	root := ProjectRoot()
+
+	if len(sources.Files) > 0 {
+		paths, ranges, err := splitLineRanges(sources.Files)
+		if err != nil {
+			return nil, err
+		}
+		files, fileRewrites, err := canonicalizePaths(paths, root)
+		if err != nil {
+			return nil, err
+		}
+		rewrites = fileRewrites
+
+		result, err := analyzeFiles(files, sources.Functions, ranges, root)
+		if err != nil {
+			return nil, fmt.Errorf("failed to analyze files: %w", err)
+		}
+		results = append(results, result)
+	}
+
+	if sources.UseRange || len(sources.Files) == 0 {
+		result, err := analyzeChanges(sources.FromRef, sources.ToRef, root)
+		if err != nil {
+			return nil, fmt.Errorf("failed to analyze git changes: %w", err)
+		}
+		results = append(results, result)
+	}
+
+	merged := mergeResults(results)
+	merged.Root = root
+	merged.PathRewrites = rewrites
+	return merged, nil
+}
+
+// LineRange is an inclusive range of source lines, from a file.go:start-end
+// argument
+type LineRange struct {
+	Start, End int
+}
+
+// Overlaps reports whether lines start through end intersect the range
+func (r LineRange) Overlaps(start, end int) bool {
+	return start <= r.End && end >= r.Start
+}
+
+func (r LineRange) String() string {
+	if r.Start == r.End {
+		return strconv.Itoa(r.Start)
+	}
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+// lineRangeSuffix matches the :start-end (or :line) suffix of a file argument
+var lineRangeSuffix = regexp.MustCompile(`:(\d+)(?:-(\d+))?$`)
+
+// ParseFileArg splits a file argument into its path and optional line range
+// ("user.go:40-80" or "user.go:42", the way editors address the code under
+// the cursor). The range is nil for a plain path.
+func ParseFileArg(arg string) (string, *LineRange, error) {
+	match := lineRangeSuffix.FindStringSubmatch(arg)
+	if match == nil {
+		return arg, nil, nil
+	}
+
+	start, _ := strconv.Atoi(match[1])
+	end := start
+	if match[2] != "" {
+		end, _ = strconv.Atoi(match[2])
+	}
+	if start < 1 || end < start {
+		return "", nil, fmt.Errorf("invalid line range in %q: want start-end with 1 <= start <= end", arg)
+	}
+	return strings.TrimSuffix(arg, match[0]), &LineRange{Start: start, End: end}, nil
+}
+
+// splitLineRanges strips line ranges from file arguments, returning each
+// path once with its ranges keyed by fileKey. A path also given without a
+// range is analyzed whole.
+func splitLineRanges(args []string) ([]string, map[string][]LineRange, error) {
+	var paths []string
+	ranges := make(map[string][]LineRange)
+	whole := make(map[string]bool)
+	seen := make(map[string]bool)
+
+	for _, arg := range args {
+		path, lineRange, err := ParseFileArg(arg)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// A file given with ranges is one file to analyze, not duplicates;
+		// only a plain path repeated is left for CanonicalizePaths to report
+		key := fileKey(path)
+		if !seen[key] || (lineRange == nil && whole[key]) {
+			paths = append(paths, path)
+		}
+		seen[key] = true
+
+		if lineRange == nil {
+			whole[key] = true
+		} else {
+			ranges[key] = append(ranges[key], *lineRange)
+		}
+	}
+
+	for key := range whole {
+		delete(ranges, key)
+	}
+	return paths, ranges, nil
+}
+
+// mergeResults combines analysis results, keeping the first analysis of each
+// file and the first target for each qualified function name
+func mergeResults(results []*AnalysisResult) *AnalysisResult {
+	merged := &AnalysisResult{}
+	if len(results) > 0 {
+		merged.Root = results[0].Root
+	}
+	seenFiles := make(map[string]bool)
+	seenTargets := make(map[string]bool)
+	seenDiffFiles := make(map[string]bool)
+
+	for _, result := range results {
+		for _, file := range result.ChangedFiles {
+			key := changedFileKey(file, result.Root)
+			if seenFiles[key] {
+				continue
+			}
+			seenFiles[key] = true
+			merged.ChangedFiles = append(merged.ChangedFiles, file)
+			merged.TotalFunctions += len(file.FunctionDetails)
+			merged.ModifiedFunctions += len(file.ModifiedFunctions)
+		}
+
+		for _, target := range result.GenerationTargets {
+			key := QualifiedName(target)
+			if seenTargets[key] {
+				merged.DuplicateTargets++
+				continue
+			}
+			seenTargets[key] = true
+			merged.GenerationTargets = append(merged.GenerationTargets, target)
+		}
+		merged.DuplicateTargets += result.DuplicateTargets
+		merged.GoFiles += result.GoFiles
+		merged.CgoFiles = append(merged.CgoFiles, result.CgoFiles...)
+
+		for _, path := range result.DiffFiles {
+			if !seenDiffFiles[path] {
+				seenDiffFiles[path] = true
+				merged.DiffFiles = append(merged.DiffFiles, path)
+			}
+		}
+	}
+
+	return merged
+}
+
+// QualifiedName identifies a function across analysis sources by its
+// resolved file, receiver type and name ("/repo/user.go:User.Validate")
+func QualifiedName(fn models.FunctionInfo) string {
+	return fileKey(fn.SourcePath()) + ":" + MethodName(fn)
+}
+
+// MethodName is a function's name qualified by its receiver type for
+// methods ("User.Validate"), or just its name for functions
+func MethodName(fn models.FunctionInfo) string {
+	if fn.Receiver == nil {
+		return fn.Name
+	}
+	return parser.BaseTypeName(fn.Receiver.Type) + "." + fn.Name
+}
+
+// changedFileKey identifies an analyzed file, whose path is relative to
+// root. The parsed functions' resolved file is preferred when known.
+func changedFileKey(file ChangedFileAnalysis, root string) string {
+	if len(file.FunctionDetails) > 0 {
+		return fileKey(file.FunctionDetails[0].SourcePath())
+	}
+	if root != "" && !filepath.IsAbs(file.FilePath) {
+		return fileKey(filepath.Join(root, file.FilePath))
+	}
+	return fileKey(file.FilePath)
+}
+
+// fileKey resolves a path to an absolute, symlink-free form so the same file
+// reached through git (repo-relative) and the command line compares equal
+func fileKey(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		return resolved
+	}
+	return abs
+}
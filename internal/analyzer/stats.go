@@ -0,0 +1,126 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/Eranmonnie/testgen/internal/report"
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// StatsFile collects the stats of every --stats-only run, one JSON object
+// per line, so they can be charted across commits
+const StatsFile = ".testgen/stats.jsonl"
+
+// maxHotspots is how many dependencies PrintStats lists
+const maxHotspots = 5
+
+// complexityBuckets group functions by cyclomatic complexity
+var complexityBuckets = []struct {
+	label string
+	max   int
+}{
+	{"1-5", 5},
+	{"6-10", 10},
+	{"11-20", 20},
+	{"21+", int(^uint(0) >> 1)},
+}
+
+// Stats measures the analyzed functions: how many there are, how many a
+// generate run would target, how complex they are and which imported
+// packages most of them use
+func Stats(result *AnalysisResult) models.GenerationStats {
+	stats := models.GenerationStats{
+		FilesProcessed:         len(result.ChangedFiles),
+		FunctionsFound:         result.TotalFunctions,
+		WouldGenerate:          len(result.GenerationTargets),
+		FunctionsByType:        make(map[string]int),
+		ComplexityDistribution: make(map[string]int),
+		DependencyHotspots:     make(map[string]int),
+	}
+
+	for _, file := range result.ChangedFiles {
+		for _, fn := range file.FunctionDetails {
+			if fn.IsMethod {
+				stats.FunctionsByType["method"]++
+			} else {
+				stats.FunctionsByType["function"]++
+			}
+			for _, bucket := range complexityBuckets {
+				if fn.Complexity.CyclomaticComplexity <= bucket.max {
+					stats.ComplexityDistribution[bucket.label]++
+					break
+				}
+			}
+			for _, dependency := range fn.Complexity.Dependencies {
+				stats.DependencyHotspots[dependency]++
+			}
+		}
+	}
+
+	return stats
+}
+
+// PrintStats prints the stats of an analysis
+func PrintStats(stats models.GenerationStats) {
+	report.Summaryf("Testability Stats:\n")
+	report.Summaryf("==================\n")
+	report.Summaryf("Files analyzed: %d\n", stats.FilesProcessed)
+	report.Summaryf("Functions found: %d (%d functions, %d methods)\n",
+		stats.FunctionsFound, stats.FunctionsByType["function"], stats.FunctionsByType["method"])
+	report.Summaryf("Would generate tests for: %d\n", stats.WouldGenerate)
+
+	report.Summaryf("Cyclomatic complexity:\n")
+	for _, bucket := range complexityBuckets {
+		report.Summaryf("  %-6s %d\n", bucket.label, stats.ComplexityDistribution[bucket.label])
+	}
+
+	if len(stats.DependencyHotspots) > 0 {
+		report.Summaryf("Dependency hotspots (functions using each):\n")
+		for _, dependency := range hotspots(stats.DependencyHotspots) {
+			report.Summaryf("  %-30s %d\n", dependency, stats.DependencyHotspots[dependency])
+		}
+	}
+}
+
+// hotspots returns the most used dependencies, most used first
+func hotspots(counts map[string]int) []string {
+	var dependencies []string
+	for dependency := range counts {
+		dependencies = append(dependencies, dependency)
+	}
+	sort.Slice(dependencies, func(i, j int) bool {
+		if counts[dependencies[i]] != counts[dependencies[j]] {
+			return counts[dependencies[i]] > counts[dependencies[j]]
+		}
+		return dependencies[i] < dependencies[j]
+	})
+	if len(dependencies) > maxHotspots {
+		dependencies = dependencies[:maxHotspots]
+	}
+	return dependencies
+}
+
+// RecordStats appends stats to the JSON lines file at path
+func RecordStats(path string, stats models.GenerationStats) error {
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return fmt.Errorf("failed to encode stats: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create stats directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open stats: %w", err)
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write stats: %w", err)
+	}
+	return file.Close()
+}
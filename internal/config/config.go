@@ -1,11 +1,15 @@
 package config
 
 import (
+	"cmp"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 
+	"github.com/Eranmonnie/testgen/internal/parser"
 	"gopkg.in/yaml.v3"
 )
 
@@ -17,19 +21,49 @@ type Config struct {
 	AI        AIConfig      `yaml:"ai"`        // AI model settings
 	Output    OutputConfig  `yaml:"output"`    // output settings
 	Filtering FilterConfig  `yaml:"filtering"` // function filtering rules
+	Git       GitConfig     `yaml:"git"`       // git invocation settings
+
+	Extends string `yaml:"extends,omitempty"` // base config, a path or HTTPS URL, this one is merged over
+
+	layers []configLayer // the extends chain, config file first, when Extends is set
 }
 
 // TriggerConfig defines when test generation should trigger
 type TriggerConfig struct {
-	Auto   AutoTrigger   `yaml:"auto"`   // auto mode settings
-	Manual ManualTrigger `yaml:"manual"` // manual mode settings
+	Auto        AutoTrigger   `yaml:"auto"`         // auto mode settings
+	Manual      ManualTrigger `yaml:"manual"`       // manual mode settings
+	BlastRadius string        `yaml:"blast_radius"` // "function", "callers" or "package": what else a change targets
 }
 
+// Blast radius modes understood by triggers.blast_radius
+const (
+	BlastRadiusFunction = "function" // only the modified functions
+	BlastRadiusCallers  = "callers"  // plus exported functions in the package calling them
+	BlastRadiusPackage  = "package"  // plus every exported function in the package
+)
+
+// OpenAI response modes understood by ai.openai_mode
+const (
+	OpenAIModeJSONObject = "json_object" // response_format json_object; the JSON is read from the message content
+	OpenAIModeJSONSchema = "json_schema" // structured outputs with a strict response schema
+	OpenAIModeTool       = "tool"        // a forced function call whose arguments are the response
+)
+
+// openAISchemaModels are the model families that support structured outputs,
+// which get json_schema by default
+var openAISchemaModels = []string{"gpt-4o", "gpt-4.1", "gpt-5", "o3", "o4"}
+
 type AutoTrigger struct {
 	FilePatterns []string `yaml:"file_patterns"` // patterns that trigger auto generation
 	ExcludeFiles []string `yaml:"exclude_files"` // files to exclude
 	OnCommit     bool     `yaml:"on_commit"`     // trigger on commit
 	OnPush       bool     `yaml:"on_push"`       // trigger on push
+
+	QueueOnFailure bool `yaml:"queue_on_failure"` // queue targets in .testgen/queue when the AI provider is unreachable instead of failing
+
+	HookTimeout int  `yaml:"hook_timeout"` // deadline of a hook-triggered run, in seconds (0 = no limit); TESTGEN_HOOK_TIMEOUT overrides it
+	Enforce     bool `yaml:"enforce"`      // fail pre-commit and pre-push hooks that run out of hook_timeout instead of letting them through
+	Background  bool `yaml:"background"`   // run the post-commit hook detached, without hook_timeout
 }
 
 type ManualTrigger struct {
@@ -41,19 +75,164 @@ type AIConfig struct {
 	Provider    string  `yaml:"provider"`    // "openai", "anthropic", "local"
 	Model       string  `yaml:"model"`       // specific model name
 	APIKey      string  `yaml:"api_key"`     // API key (or use env var)
-	BaseURL     string  `yaml:"base_url"`    // for custom endpoints
+	BaseURL     string  `yaml:"base_url"`    // for custom endpoints: the openai and groq API root, or the local provider's Ollama server
 	Temperature float64 `yaml:"temperature"` // creativity level 0-1
 	MaxTokens   int     `yaml:"max_tokens"`  // max response length
-	Timeout     int     `yaml:"timeout"`     // timeout in seconds
+
+	TemperatureByType map[string]float64 `yaml:"temperature_by_type"` // temperature for runs asking for one --type of test, overriding temperature
+
+	RequestTimeout int `yaml:"request_timeout"` // per API call, in seconds (0 = no limit); the older "timeout" key is read too
+	ReadTimeout    int `yaml:"read_timeout"`    // reading an API response once its headers arrive, in seconds (0 = no limit)
+
+	MaxRetries          int     `yaml:"max_retries"`           // retries of an API call that timed out, was rate limited or hit a server error (0 = none)
+	RetryBackoffSeconds float64 `yaml:"retry_backoff_seconds"` // wait before the first retry, doubling per retry, with jitter (0 = DefaultRetryBackoffSeconds)
+
+	MaxResponseBytes int `yaml:"max_response_bytes"` // largest API response body read (0 = DefaultMaxResponseBytes)
+
+	MaxBodyLines int `yaml:"max_body_lines"` // summarize longer function bodies in prompts (0 = no limit)
+
+	MaxPromptTokens int `yaml:"max_prompt_tokens"` // trim prompts estimated above this many tokens (0 = no limit)
+
+	RecentCommits int `yaml:"recent_commits"` // subjects of the last commits to each target file shown in prompts (0 = none)
+
+	Organization string `yaml:"organization"` // OpenAI-Organization header (openai only)
+	Project      string `yaml:"project"`      // OpenAI-Project header (openai only)
+	OpenAIMode   string `yaml:"openai_mode"`  // "json_object", "json_schema" or "tool" (openai only; empty = best for the model)
+
+	FewShotExamples []FewShotExample `yaml:"few_shot_examples"` // function/test pairs shown to the model as the style to follow
+
+	AllowedProviders []string `yaml:"allowed_providers"` // providers source may be sent to (empty = any); TESTGEN_ALLOWED_PROVIDERS restricts further
+
+	MetadataCacheMaxAge int `yaml:"metadata_cache_max_age"` // seconds cached provider metadata (GETs) stays fresh (0 = as the provider's Cache-Control says)
+}
+
+// DefaultMaxResponseBytes caps API response bodies when ai.max_response_bytes
+// isn't set: far more than any batch of tests, far less than a runaway stream
+const DefaultMaxResponseBytes = 10 << 20
+
+// DefaultRetryBackoffSeconds is the wait before the first retry of an API
+// call when ai.retry_backoff_seconds isn't set
+const DefaultRetryBackoffSeconds = 2
+
+// DefaultOllamaURL is where the local provider finds Ollama when
+// ai.base_url isn't set
+const DefaultOllamaURL = "http://localhost:11434"
+
+// DefaultOpenAIURL and DefaultGroqURL are the API roots the openai and groq
+// providers use when ai.base_url isn't set
+const (
+	DefaultOpenAIURL = "https://api.openai.com/v1"
+	DefaultGroqURL   = "https://api.groq.com/openai/v1"
+)
+
+// AllowedProvidersEnv names the environment variable listing, comma
+// separated, the only providers testgen may send source code to
+const AllowedProvidersEnv = "TESTGEN_ALLOWED_PROVIDERS"
+
+// CheckProviderAllowed returns an error unless the configured provider is
+// allowed by both ai.allowed_providers and TESTGEN_ALLOWED_PROVIDERS, when
+// they are set. The environment is read on every call so neither a config
+// file nor a later override can widen what it allows.
+func (ai AIConfig) CheckProviderAllowed() error {
+	if len(ai.AllowedProviders) > 0 && !contains(ai.AllowedProviders, ai.Provider) {
+		return fmt.Errorf("AI provider '%s' is not allowed by ai.allowed_providers (%s)",
+			ai.Provider, strings.Join(ai.AllowedProviders, ", "))
+	}
+	if allowed := allowedProvidersFromEnv(); len(allowed) > 0 && !contains(allowed, ai.Provider) {
+		return fmt.Errorf("AI provider '%s' is not allowed by %s (%s)",
+			ai.Provider, AllowedProvidersEnv, strings.Join(allowed, ", "))
+	}
+	return nil
+}
+
+// TemperatureFor returns the temperature for a run asking for testType
+// tests: its ai.temperature_by_type entry, else ai.temperature
+func (ai AIConfig) TemperatureFor(testType string) float64 {
+	if temperature, ok := ai.TemperatureByType[testType]; ok {
+		return temperature
+	}
+	return ai.Temperature
+}
+
+// ResponseMode returns the OpenAI response mode to use: ai.openai_mode, or
+// by default json_schema for models supporting structured outputs and
+// json_object for the rest
+func (ai AIConfig) ResponseMode() string {
+	if ai.OpenAIMode != "" {
+		return ai.OpenAIMode
+	}
+	// The first gpt-4o snapshot predates structured outputs
+	if ai.Model == "gpt-4o-2024-05-13" {
+		return OpenAIModeJSONObject
+	}
+	for _, family := range openAISchemaModels {
+		if ai.Model == family || strings.HasPrefix(ai.Model, family+"-") {
+			return OpenAIModeJSONSchema
+		}
+	}
+	return OpenAIModeJSONObject
+}
+
+// CheckTestType returns an error unless testType is a kind of test --type
+// can ask for
+func CheckTestType(testType string) error {
+	if !contains(validTestTypes, testType) {
+		return fmt.Errorf("invalid test type '%s', must be one of: %s", testType, strings.Join(validTestTypes, ", "))
+	}
+	return nil
+}
+
+// allowedProvidersFromEnv parses TESTGEN_ALLOWED_PROVIDERS
+func allowedProvidersFromEnv() []string {
+	var allowed []string
+	for _, provider := range strings.Split(os.Getenv(AllowedProvidersEnv), ",") {
+		if provider = strings.TrimSpace(provider); provider != "" {
+			allowed = append(allowed, provider)
+		}
+	}
+	return allowed
+}
+
+// FewShotExample points at a function in the project and the test written
+// for it, shown to the model as an example of the tests the project wants.
+// Methods are named "Type.Method".
+type FewShotExample struct {
+	FunctionFile string `yaml:"function_file"`
+	FunctionName string `yaml:"function_name"`
+	TestFile     string `yaml:"test_file"`
+	TestName     string `yaml:"test_name"`
 }
 
 // OutputConfig defines where and how tests are generated
 type OutputConfig struct {
-	Directory      string `yaml:"directory"`       // test output directory
-	Suffix         string `yaml:"suffix"`          // test file suffix
-	Overwrite      bool   `yaml:"overwrite"`       // overwrite existing tests
-	BackupExisting bool   `yaml:"backup_existing"` // backup before overwriting
-	TestTemplate   string `yaml:"test_template"`   // custom test template
+	Directory       string `yaml:"directory"`        // test output directory
+	ExternalPackage bool   `yaml:"external_package"` // next to the source, in an external <pkg>_test package
+	Suffix          string `yaml:"suffix"`           // test file suffix
+	Overwrite       bool   `yaml:"overwrite"`        // overwrite existing tests
+	Merge           bool   `yaml:"merge"`            // append new tests to existing test files
+	BackupExisting  bool   `yaml:"backup_existing"`  // backup before overwriting
+	TestTemplate    string `yaml:"test_template"`    // custom test template
+	TestNameStyle   string `yaml:"test_name_style"`  // "go-default", "underscore", or a custom regex
+	CommentStyle    string `yaml:"comment_style"`    // "minimal" or "full" comment above each test
+	DoNotEdit       *bool  `yaml:"do_not_edit"`      // add "DO NOT EDIT." to the generated header (default true, false with merge)
+
+	DeltaRegeneration bool `yaml:"delta_regeneration"` // extend existing generated table tests instead of rewriting them
+	ParallelSubtests  bool `yaml:"parallel_subtests"`  // ask for t.Parallel() in independent subtests
+	FuzzTests         bool `yaml:"fuzz_tests"`         // also ask for a FuzzXxx test of functions with fuzzable parameters
+
+	StubMissingCoverage bool `yaml:"stub_missing_coverage"` // add skipped TODO table cases for coverage scenarios without a subtest
+
+	FlakyTests string `yaml:"flaky_tests"` // "warn", "exclude" or "repair" tests with sleeps, real network, unseeded rand or wall-clock checks
+
+	PostProcess         []string `yaml:"post_process"`          // commands run on each generated file ({} = file path, else stdin/stdout)
+	PostProcessTimeout  int      `yaml:"post_process_timeout"`  // per-command timeout in seconds
+	PostProcessRequired bool     `yaml:"post_process_required"` // fail the write if a post-processor fails
+}
+
+// GitConfig defines how git is invoked
+type GitConfig struct {
+	Binary     string `yaml:"binary"`      // git executable (defaults to "git" on PATH)
+	OmitAuthor bool   `yaml:"omit_author"` // keep commit author names out of prompts
 }
 
 // FilterConfig defines function filtering rules
@@ -64,8 +243,23 @@ type FilterConfig struct {
 	SkipPatterns      []string `yaml:"skip_patterns"`      // function name patterns to skip
 	RequireParams     bool     `yaml:"require_params"`     // require functions to have parameters
 	RequireReturns    bool     `yaml:"require_returns"`    // require functions to have returns
+	SideEffects       string   `yaml:"side_effects"`       // "test" or "skip" functions that take params but return nothing
+	IncludePromoted   bool     `yaml:"include_promoted"`   // also target exported types that promote changed embedded methods
+	AlwaysInclude     []string `yaml:"always_include"`     // name or Type.Method patterns tested regardless of the other filters
+	IncludeDeprecated bool     `yaml:"include_deprecated"` // also target functions documented as Deprecated:
 }
 
+// Test name styles understood by output.test_name_style. Any other value is
+// treated as a custom regular expression that test names must match.
+const (
+	TestNameStyleGoDefault  = "go-default" // TestValidateUser_NilUser
+	TestNameStyleUnderscore = "underscore" // Test_ValidateUser_returns_error_when_nil
+)
+
+// MinSensibleTimeout is the shortest timeout, in seconds, that doesn't draw
+// a warning: AI responses rarely arrive faster
+const MinSensibleTimeout = 5
+
 const (
 	DefaultConfigFile = ".testgen.yml"
 	GlobalConfigFile  = "testgen.yml"
@@ -83,17 +277,28 @@ func DefaultConfig() *Config {
 				ExcludeFiles: []string{"*_test.go", "vendor/*", ".git/*"},
 				OnCommit:     true,
 				OnPush:       false,
+				HookTimeout:  60,
 			},
 			Manual: ManualTrigger{
 				DefaultRange: "HEAD~1..HEAD",
 			},
+			BlastRadius: BlastRadiusFunction,
 		},
 		AI: AIConfig{
 			Provider:    "openai",
 			Model:       "gpt-4",
 			Temperature: 0.2,
 			MaxTokens:   2000,
-			Timeout:     30,
+
+			RequestTimeout: 30,
+			ReadTimeout:    60,
+
+			MaxRetries:          2,
+			RetryBackoffSeconds: DefaultRetryBackoffSeconds,
+
+			MaxResponseBytes: DefaultMaxResponseBytes,
+
+			MaxBodyLines: 150,
 		},
 		Output: OutputConfig{
 			Directory:      "", // same directory as source
@@ -101,6 +306,15 @@ func DefaultConfig() *Config {
 			Overwrite:      false,
 			BackupExisting: true,
 			TestTemplate:   "default",
+			TestNameStyle:  TestNameStyleGoDefault,
+			CommentStyle:   "minimal",
+
+			DeltaRegeneration: true,
+			FlakyTests:        "warn",
+
+			PostProcess:         []string{},
+			PostProcessTimeout:  30,
+			PostProcessRequired: false,
 		},
 		Filtering: FilterConfig{
 			IncludeUnexported: false,
@@ -109,6 +323,7 @@ func DefaultConfig() *Config {
 			SkipPatterns:      []string{"main", "init"},
 			RequireParams:     false,
 			RequireReturns:    false,
+			SideEffects:       "test",
 		},
 	}
 }
@@ -121,7 +336,10 @@ func LoadConfig() (*Config, error) {
 	// Try to find and load config file
 	configPath, err := findConfigFile()
 	if err != nil {
-		// No config file found, use defaults
+		// No config file found, use defaults plus environment overrides
+		if err := overrideWithEnv(config); err != nil {
+			return nil, fmt.Errorf("invalid environment: %w", err)
+		}
 		return config, nil
 	}
 
@@ -131,7 +349,9 @@ func LoadConfig() (*Config, error) {
 	}
 
 	// Override with environment variables
-	overrideWithEnv(config)
+	if err := overrideWithEnv(config); err != nil {
+		return nil, fmt.Errorf("invalid environment: %w", err)
+	}
 
 	// Validate configuration
 	if err := validateConfig(config); err != nil {
@@ -149,7 +369,9 @@ func LoadConfigFromFile(filePath string) (*Config, error) {
 		return nil, err
 	}
 
-	overrideWithEnv(config)
+	if err := overrideWithEnv(config); err != nil {
+		return nil, fmt.Errorf("invalid environment: %w", err)
+	}
 
 	if err := validateConfig(config); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
@@ -176,53 +398,19 @@ func SaveConfig(config *Config) error {
 	return nil
 }
 
-// findConfigFile looks for config file in various locations
+// findConfigFile returns the first config file of ConfigLocations for the
+// working directory
 func findConfigFile() (string, error) {
-	// 1. Check environment variable
-	if configPath := os.Getenv(ConfigEnvVar); configPath != "" {
-		if _, err := os.Stat(configPath); err == nil {
-			return configPath, nil
-		}
-	}
-
-	// 2. Check current directory
-	if _, err := os.Stat(DefaultConfigFile); err == nil {
-		return DefaultConfigFile, nil
-	}
-
-	// 3. Check project root (look for go.mod)
-	if projectRoot := findProjectRoot(); projectRoot != "" {
-		configPath := filepath.Join(projectRoot, DefaultConfigFile)
-		if _, err := os.Stat(configPath); err == nil {
-			return configPath, nil
-		}
+	dir, err := os.Getwd()
+	if err != nil {
+		dir = "."
 	}
-
-	// 4. Check home directory
-	if homeDir, err := os.UserHomeDir(); err == nil {
-		configPath := filepath.Join(homeDir, GlobalConfigFile)
-		if _, err := os.Stat(configPath); err == nil {
-			return configPath, nil
-		}
+	if chosen := ChosenConfig(ConfigLocations(dir)); chosen != nil {
+		return chosen.Path, nil
 	}
-
 	return "", fmt.Errorf("no config file found")
 }
 
-// findProjectRoot looks for project root by finding go.mod
-func findProjectRoot() string {
-	dir, _ := os.Getwd()
-
-	for dir != "/" && dir != "." {
-		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
-			return dir
-		}
-		dir = filepath.Dir(dir)
-	}
-
-	return ""
-}
-
 // loadConfigFromFile loads config from file and merges with existing config
 func loadConfigFromFile(filePath string, config *Config) error {
 	data, err := os.ReadFile(filePath)
@@ -230,75 +418,248 @@ func loadConfigFromFile(filePath string, config *Config) error {
 		return fmt.Errorf("failed to read config file: %w", err)
 	}
 
+	// A config extending a base is merged over it before it's decoded
+	var head struct {
+		Extends interface{} `yaml:"extends"`
+	}
+	if yaml.Unmarshal(data, &head) == nil && head.Extends != nil {
+		doc, layers, err := loadLayers(filePath, nil)
+		if err != nil {
+			return err
+		}
+		if data, err = yaml.Marshal(doc); err != nil {
+			return fmt.Errorf("failed to merge %s over its base: %w", filePath, err)
+		}
+		config.Extends, _ = head.Extends.(string)
+		config.layers = layers
+	}
+
 	if err := yaml.Unmarshal(data, config); err != nil {
 		return fmt.Errorf("failed to parse YAML: %w", err)
 	}
 
-	return nil
-}
-
-// overrideWithEnv overrides config with environment variables
-func overrideWithEnv(config *Config) {
-	if mode := os.Getenv("TESTGEN_MODE"); mode != "" {
-		config.Mode = mode
+	// ai.timeout was the per-request timeout before ai.request_timeout
+	var legacy struct {
+		AI struct {
+			Timeout        *int `yaml:"timeout"`
+			RequestTimeout *int `yaml:"request_timeout"`
+		} `yaml:"ai"`
 	}
-
-	if apiKey := os.Getenv("TESTGEN_API_KEY"); apiKey != "" {
-		config.AI.APIKey = apiKey
+	if err := yaml.Unmarshal(data, &legacy); err == nil && legacy.AI.Timeout != nil && legacy.AI.RequestTimeout == nil {
+		config.AI.RequestTimeout = *legacy.AI.Timeout
 	}
 
-	if model := os.Getenv("TESTGEN_MODEL"); model != "" {
-		config.AI.Model = model
-	}
+	return nil
+}
 
-	if provider := os.Getenv("TESTGEN_PROVIDER"); provider != "" {
-		config.AI.Provider = provider
+// OverrideAI applies per-run provider and model overrides (the generate
+// --provider and --model flags) and re-validates the result. Flags take
+// precedence over both the config file and TESTGEN_* environment variables.
+func (c *Config) OverrideAI(provider, model string) error {
+	if provider != "" {
+		c.AI.Provider = provider
+	}
+	if model != "" {
+		c.AI.Model = model
 	}
 
-	if baseURL := os.Getenv("TESTGEN_BASE_URL"); baseURL != "" {
-		config.AI.BaseURL = baseURL
+	if err := validateConfig(c); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
 	}
+	return nil
 }
 
+// Values validateConfig accepts for enumerated settings; the JSON Schema
+// lists the same ones
+var (
+	validModes         = []string{"auto", "manual"}
+	validProviders     = []string{"openai", "anthropic", "groq", "local"}
+	validBlastRadii    = []string{BlastRadiusFunction, BlastRadiusCallers, BlastRadiusPackage}
+	validSideEffects   = []string{"test", "skip"}
+	validCommentStyles = []string{"minimal", "full"}
+	validFlakyModes    = []string{"warn", "exclude", "repair"}
+	validTestTypes     = []string{"unit", "integration", "benchmark", "example", "fuzz"}
+	validOpenAIModes   = []string{OpenAIModeJSONObject, OpenAIModeJSONSchema, OpenAIModeTool}
+)
+
 // validateConfig validates the configuration for common errors
 func validateConfig(config *Config) error {
 	// Validate mode
-	if config.Mode != "auto" && config.Mode != "manual" {
+	if !contains(validModes, config.Mode) {
 		return fmt.Errorf("mode must be 'auto' or 'manual', got '%s'", config.Mode)
 	}
 
 	// Validate AI provider
-	validProviders := []string{"openai", "anthropic", "groq", "local"}
 	if !contains(validProviders, config.AI.Provider) {
 		return fmt.Errorf("unsupported AI provider '%s', must be one of: %s",
 			config.AI.Provider, strings.Join(validProviders, ", "))
 	}
 
+	// Governance: source may only go to allowed providers
+	for _, provider := range config.AI.AllowedProviders {
+		if !contains(validProviders, provider) {
+			return fmt.Errorf("unsupported AI provider '%s' in allowed_providers, must be one of: %s",
+				provider, strings.Join(validProviders, ", "))
+		}
+	}
+	if err := config.AI.CheckProviderAllowed(); err != nil {
+		return err
+	}
+
+	// Organization and project headers only exist on the OpenAI API
+	if (config.AI.Organization != "" || config.AI.Project != "") && config.AI.Provider != "openai" {
+		return fmt.Errorf("ai.organization and ai.project are only supported by the openai provider, got '%s'", config.AI.Provider)
+	}
+	if mode := config.AI.OpenAIMode; mode != "" {
+		if !contains(validOpenAIModes, mode) {
+			return fmt.Errorf("ai.openai_mode must be one of: %s, got '%s'", strings.Join(validOpenAIModes, ", "), mode)
+		}
+		if config.AI.Provider != "openai" {
+			return fmt.Errorf("ai.openai_mode is only supported by the openai provider, got '%s'", config.AI.Provider)
+		}
+	}
+
 	// Validate temperature
 	if config.AI.Temperature < 0 || config.AI.Temperature > 1 {
 		return fmt.Errorf("temperature must be between 0 and 1, got %f", config.AI.Temperature)
 	}
+	testTypes := make([]string, 0, len(config.AI.TemperatureByType))
+	for testType := range config.AI.TemperatureByType {
+		testTypes = append(testTypes, testType)
+	}
+	sort.Strings(testTypes)
+	for _, testType := range testTypes {
+		if err := CheckTestType(testType); err != nil {
+			return fmt.Errorf("temperature_by_type: %w", err)
+		}
+		if temperature := config.AI.TemperatureByType[testType]; temperature < 0 || temperature > 1 {
+			return fmt.Errorf("temperature_by_type.%s must be between 0 and 1, got %f", testType, temperature)
+		}
+	}
 
 	// Validate max tokens
 	if config.AI.MaxTokens <= 0 {
 		return fmt.Errorf("max_tokens must be positive, got %d", config.AI.MaxTokens)
 	}
 
+	// Validate body size limit
+	if config.AI.MaxBodyLines < 0 {
+		return fmt.Errorf("max_body_lines cannot be negative, got %d", config.AI.MaxBodyLines)
+	}
+
+	// Validate prompt budget (0 means no limit)
+	if config.AI.MaxPromptTokens < 0 {
+		return fmt.Errorf("max_prompt_tokens cannot be negative, got %d", config.AI.MaxPromptTokens)
+	}
+
+	if config.AI.RecentCommits < 0 {
+		return fmt.Errorf("recent_commits cannot be negative, got %d", config.AI.RecentCommits)
+	}
+
+	// Validate hook timeout (0 means no limit)
+	if config.Triggers.Auto.HookTimeout < 0 {
+		return fmt.Errorf("hook_timeout cannot be negative, got %d", config.Triggers.Auto.HookTimeout)
+	}
+
+	// Validate request timeout (0 means no limit)
+	if config.AI.RequestTimeout < 0 {
+		return fmt.Errorf("request_timeout cannot be negative, got %d", config.AI.RequestTimeout)
+	}
+	if config.AI.RequestTimeout > 0 && config.AI.RequestTimeout < MinSensibleTimeout {
+		fmt.Fprintf(os.Stderr, "Warning: ai.request_timeout of %ds is shorter than most AI responses take; use 0 for no limit.\n",
+			config.AI.RequestTimeout)
+	}
+
+	// Validate few-shot examples: both functions must exist
+	for i, example := range config.AI.FewShotExamples {
+		if _, err := parser.FunctionSource(example.FunctionFile, example.FunctionName); err != nil {
+			return fmt.Errorf("few_shot_examples[%d]: %w", i, err)
+		}
+		if _, err := parser.FunctionSource(example.TestFile, example.TestName); err != nil {
+			return fmt.Errorf("few_shot_examples[%d]: %w", i, err)
+		}
+	}
+
 	// Validate complexity bounds
 	if config.Filtering.MinComplexity > config.Filtering.MaxComplexity {
 		return fmt.Errorf("min_complexity (%d) cannot be greater than max_complexity (%d)",
 			config.Filtering.MinComplexity, config.Filtering.MaxComplexity)
 	}
 
+	// Validate always-include patterns
+	for _, pattern := range config.Filtering.AlwaysInclude {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid always_include pattern '%s': %w", pattern, err)
+		}
+	}
+
+	// Validate test name style (anything that isn't a named style must be a valid regex)
+	if style := config.Output.TestNameStyle; style != "" && !isNamedTestNameStyle(style) {
+		if _, err := regexp.Compile(style); err != nil {
+			return fmt.Errorf("test_name_style must be '%s', '%s', or a valid regex: %w",
+				TestNameStyleGoDefault, TestNameStyleUnderscore, err)
+		}
+	}
+
+	// Validate blast radius
+	if radius := config.Triggers.BlastRadius; radius != "" && !contains(validBlastRadii, radius) {
+		return fmt.Errorf("blast_radius must be '%s', '%s' or '%s', got '%s'",
+			BlastRadiusFunction, BlastRadiusCallers, BlastRadiusPackage, config.Triggers.BlastRadius)
+	}
+
+	// Validate side effect mode
+	if mode := config.Filtering.SideEffects; mode != "" && !contains(validSideEffects, mode) {
+		return fmt.Errorf("side_effects must be 'test' or 'skip', got '%s'", mode)
+	}
+
+	// Validate comment style
+	if style := config.Output.CommentStyle; style != "" && !contains(validCommentStyles, style) {
+		return fmt.Errorf("comment_style must be 'minimal' or 'full', got '%s'", style)
+	}
+	if mode := config.Output.FlakyTests; mode != "" && !contains(validFlakyModes, mode) {
+		return fmt.Errorf("flaky_tests must be 'warn', 'exclude' or 'repair', got '%s'", mode)
+	}
+
+	// Validate response limits (0 means the default size, or no read timeout)
+	if config.AI.ReadTimeout < 0 {
+		return fmt.Errorf("read_timeout cannot be negative, got %d", config.AI.ReadTimeout)
+	}
+	if config.AI.MaxResponseBytes < 0 {
+		return fmt.Errorf("max_response_bytes cannot be negative, got %d", config.AI.MaxResponseBytes)
+	}
+
+	// Validate retries (0 means no retries, or the default backoff)
+	if config.AI.MaxRetries < 0 {
+		return fmt.Errorf("max_retries cannot be negative, got %d", config.AI.MaxRetries)
+	}
+	if config.AI.RetryBackoffSeconds < 0 {
+		return fmt.Errorf("retry_backoff_seconds cannot be negative, got %g", config.AI.RetryBackoffSeconds)
+	}
+
+	// Validate metadata cache age (0 means the provider decides)
+	if config.AI.MetadataCacheMaxAge < 0 {
+		return fmt.Errorf("metadata_cache_max_age cannot be negative, got %d", config.AI.MetadataCacheMaxAge)
+	}
+
+	// Validate post-processing timeout
+	if config.Output.PostProcessTimeout < 0 {
+		return fmt.Errorf("post_process_timeout cannot be negative, got %d", config.Output.PostProcessTimeout)
+	}
+
 	// Warn if API key is missing for remote providers
 	if (config.AI.Provider == "openai" || config.AI.Provider == "anthropic") && config.AI.APIKey == "" {
-		fmt.Printf("Warning: No API key configured for provider '%s'. Set TESTGEN_API_KEY environment variable.\n",
+		fmt.Fprintf(os.Stderr, "Warning: No API key configured for provider '%s'. Set TESTGEN_API_KEY environment variable.\n",
 			config.AI.Provider)
 	}
 
 	return nil
 }
 
+// isNamedTestNameStyle reports whether style is one of the built-in test name styles
+func isNamedTestNameStyle(style string) bool {
+	return style == TestNameStyleGoDefault || style == TestNameStyleUnderscore
+}
+
 // contains checks if a slice contains a string
 func contains(slice []string, item string) bool {
 	for _, s := range slice {
@@ -322,8 +683,30 @@ func (c *Config) GetTestOutputPath(sourceFile string) string {
 	return filepath.Join(dir, testFileName)
 }
 
-// ShouldIncludeFunction determines if a function should be included based on filtering rules
+// SamePackage reports whether tests are written in the package under test,
+// rather than in an external <pkg>_test package (output.directory or
+// output.external_package)
+func (o OutputConfig) SamePackage() bool {
+	return o.Directory == "" && !o.ExternalPackage
+}
+
+// MarkDoNotEdit reports whether generated headers should carry the "DO NOT EDIT."
+// clause. It defaults to true, except with merge, whose files users are
+// expected to edit; do_not_edit overrides either way.
+func (o OutputConfig) MarkDoNotEdit() bool {
+	if o.DoNotEdit != nil {
+		return *o.DoNotEdit
+	}
+	return !o.Merge
+}
+
+// ShouldIncludeFunction determines if a function should be included based on filtering rules.
+// funcName may be qualified as Type.Method; always_include patterns win over every other rule.
 func (c *Config) ShouldIncludeFunction(funcName string, isExported bool, complexity int) bool {
+	if c.Filtering.AlwaysIncludes(funcName) {
+		return true
+	}
+
 	// Check export status
 	if !isExported && !c.Filtering.IncludeUnexported {
 		return false
@@ -348,6 +731,28 @@ func (c *Config) ShouldIncludeFunction(funcName string, isExported bool, complex
 	return true
 }
 
+// AlwaysIncludes reports whether a function matches an always_include
+// pattern. qualifiedName is "Name" for functions and "Type.Method" for
+// methods; patterns containing a dot match the qualified name, others match
+// the bare name, so "Validate*" covers functions and methods alike.
+func (f FilterConfig) AlwaysIncludes(qualifiedName string) bool {
+	name := qualifiedName
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		name = name[dot+1:]
+	}
+
+	for _, pattern := range f.AlwaysInclude {
+		target := name
+		if strings.Contains(pattern, ".") {
+			target = qualifiedName
+		}
+		if matched, _ := filepath.Match(pattern, target); matched {
+			return true
+		}
+	}
+	return false
+}
+
 // IsAutoMode returns true if running in auto mode
 func (c *Config) IsAutoMode() bool {
 	return c.Mode == "auto"
@@ -423,15 +828,74 @@ func (c *Config) ShouldTriggerOnFile(filePath string) bool {
 func PrintConfig(config *Config) {
 	fmt.Printf("Testgen Configuration:\n")
 	fmt.Printf("======================\n")
+	if config.Extends != "" {
+		fmt.Printf("Extends: %s\n", config.Extends)
+		for _, layer := range config.layers {
+			if len(layer.Keys) > 0 {
+				fmt.Printf("  From %s: %s\n", layer.Source, strings.Join(layer.Keys, ", "))
+			}
+		}
+		fmt.Printf("  Everything else: defaults\n")
+		fmt.Printf("\n")
+	}
 	fmt.Printf("Mode: %s\n", config.Mode)
 	fmt.Printf("Git Hooks: %v\n", config.Hooks)
+	fmt.Printf("Blast Radius: %s\n", orDefault(config.Triggers.BlastRadius, BlastRadiusFunction))
+	if config.Triggers.Auto.QueueOnFailure {
+		fmt.Printf("Queue On Failure: %t\n", config.Triggers.Auto.QueueOnFailure)
+	}
+	if config.IsAutoMode() || len(config.Hooks) > 0 {
+		fmt.Printf("Hook Timeout: %ds (enforce: %t, background: %t)\n",
+			config.Triggers.Auto.HookTimeout, config.Triggers.Auto.Enforce, config.Triggers.Auto.Background)
+	}
 	fmt.Printf("\n")
 
 	fmt.Printf("AI Settings:\n")
 	fmt.Printf("  Provider: %s\n", config.AI.Provider)
 	fmt.Printf("  Model: %s\n", config.AI.Model)
 	fmt.Printf("  Temperature: %.2f\n", config.AI.Temperature)
+	for _, testType := range validTestTypes {
+		if temperature, ok := config.AI.TemperatureByType[testType]; ok {
+			fmt.Printf("    %s: %.2f\n", testType, temperature)
+		}
+	}
 	fmt.Printf("  Max Tokens: %d\n", config.AI.MaxTokens)
+	fmt.Printf("  Request Timeout: %s\n", formatTimeout(config.AI.RequestTimeout))
+	fmt.Printf("  Max Retries: %d (backoff from %gs)\n", config.AI.MaxRetries, cmp.Or(config.AI.RetryBackoffSeconds, DefaultRetryBackoffSeconds))
+	fmt.Printf("  Max Body Lines: %d\n", config.AI.MaxBodyLines)
+	if len(config.AI.AllowedProviders) > 0 {
+		fmt.Printf("  Allowed Providers: %s\n", strings.Join(config.AI.AllowedProviders, ", "))
+	}
+	if config.AI.MaxPromptTokens > 0 {
+		fmt.Printf("  Max Prompt Tokens: %d\n", config.AI.MaxPromptTokens)
+	}
+	if config.AI.RecentCommits > 0 {
+		fmt.Printf("  Recent Commits: %d per file\n", config.AI.RecentCommits)
+	}
+	if config.AI.MetadataCacheMaxAge > 0 {
+		fmt.Printf("  Metadata Cache Max Age: %ds\n", config.AI.MetadataCacheMaxAge)
+	}
+	switch config.AI.Provider {
+	case "local":
+		fmt.Printf("  Base URL: %s\n", orDefault(config.AI.BaseURL, DefaultOllamaURL))
+	case "openai":
+		fmt.Printf("  Base URL: %s\n", orDefault(config.AI.BaseURL, DefaultOpenAIURL))
+	case "groq":
+		fmt.Printf("  Base URL: %s\n", orDefault(config.AI.BaseURL, DefaultGroqURL))
+	}
+	if config.AI.Organization != "" {
+		fmt.Printf("  Organization: %s\n", config.AI.Organization)
+	}
+	if config.AI.Project != "" {
+		fmt.Printf("  Project: %s\n", config.AI.Project)
+	}
+	if config.AI.Provider == "openai" {
+		fmt.Printf("  OpenAI Mode: %s\n", config.AI.ResponseMode())
+	}
+	for _, example := range config.AI.FewShotExamples {
+		fmt.Printf("  Few-Shot Example: %s (%s) -> %s (%s)\n",
+			example.FunctionName, example.FunctionFile, example.TestName, example.TestFile)
+	}
 	if config.AI.APIKey != "" {
 		fmt.Printf("  API Key: %s***\n", config.AI.APIKey[:min(8, len(config.AI.APIKey))])
 	}
@@ -439,18 +903,52 @@ func PrintConfig(config *Config) {
 
 	fmt.Printf("Output Settings:\n")
 	fmt.Printf("  Directory: %s\n", orDefault(config.Output.Directory, "same as source"))
+	fmt.Printf("  External Package: %t\n", !config.Output.SamePackage())
 	fmt.Printf("  Suffix: %s\n", config.Output.Suffix)
 	fmt.Printf("  Overwrite: %t\n", config.Output.Overwrite)
+	fmt.Printf("  Merge: %t\n", config.Output.Merge)
 	fmt.Printf("  Backup: %t\n", config.Output.BackupExisting)
+	fmt.Printf("  Test Name Style: %s\n", orDefault(config.Output.TestNameStyle, TestNameStyleGoDefault))
+	fmt.Printf("  Comment Style: %s\n", orDefault(config.Output.CommentStyle, "minimal"))
+	fmt.Printf("  Flaky Tests: %s\n", orDefault(config.Output.FlakyTests, "warn"))
+	fmt.Printf("  DO NOT EDIT Header: %t\n", config.Output.MarkDoNotEdit())
+	fmt.Printf("  Delta Regeneration: %t\n", config.Output.DeltaRegeneration)
+	fmt.Printf("  Parallel Subtests: %t\n", config.Output.ParallelSubtests)
+	fmt.Printf("  Fuzz Tests: %t\n", config.Output.FuzzTests)
+	fmt.Printf("  Stub Missing Coverage: %t\n", config.Output.StubMissingCoverage)
+	if len(config.Output.PostProcess) > 0 {
+		fmt.Printf("  Post-process: %v (required: %t)\n", config.Output.PostProcess, config.Output.PostProcessRequired)
+	}
 	fmt.Printf("\n")
 
+	if config.Git.Binary != "" || config.Git.OmitAuthor {
+		fmt.Printf("Git Settings:\n")
+		if config.Git.Binary != "" {
+			fmt.Printf("  Binary: %s\n", config.Git.Binary)
+		}
+		fmt.Printf("  Omit Author: %t\n", config.Git.OmitAuthor)
+		fmt.Printf("\n")
+	}
+
 	fmt.Printf("Filtering Rules:\n")
 	fmt.Printf("  Include Unexported: %t\n", config.Filtering.IncludeUnexported)
 	fmt.Printf("  Complexity Range: %d-%d\n", config.Filtering.MinComplexity, config.Filtering.MaxComplexity)
 	fmt.Printf("  Skip Patterns: %v\n", config.Filtering.SkipPatterns)
+	fmt.Printf("  Always Include: %v\n", config.Filtering.AlwaysInclude)
+	fmt.Printf("  Side Effects: %s\n", config.Filtering.SideEffects)
+	fmt.Printf("  Include Deprecated: %t\n", config.Filtering.IncludeDeprecated)
+	fmt.Printf("  Include Promoted: %t\n", config.Filtering.IncludePromoted)
 	fmt.Printf("\n")
 }
 
+// formatTimeout renders a timeout in seconds, where 0 means no limit
+func formatTimeout(seconds int) string {
+	if seconds == 0 {
+		return "none"
+	}
+	return fmt.Sprintf("%ds", seconds)
+}
+
 func orDefault(value, defaultValue string) string {
 	if value == "" {
 		return defaultValue
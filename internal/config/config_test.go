@@ -1,9 +1,18 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+
+	"gopkg.in/yaml.v3"
 )
 
 func TestDefaultConfig(t *testing.T) {
@@ -133,6 +142,36 @@ filtering:
 	}
 }
 
+func TestRequestTimeoutConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		ai       string
+		expected int
+	}{
+		{name: "default", ai: "  provider: local\n", expected: 30},
+		{name: "zero means no limit", ai: "  provider: local\n  request_timeout: 0\n", expected: 0},
+		{name: "legacy timeout key", ai: "  provider: local\n  timeout: 90\n", expected: 90},
+		{name: "request_timeout wins over timeout", ai: "  provider: local\n  timeout: 90\n  request_timeout: 45\n", expected: 45},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configFile := filepath.Join(t.TempDir(), "testgen.yml")
+			if err := os.WriteFile(configFile, []byte("mode: manual\nai:\n"+tt.ai), 0644); err != nil {
+				t.Fatalf("Failed to write config file: %v", err)
+			}
+
+			config, err := LoadConfigFromFile(configFile)
+			if err != nil {
+				t.Fatalf("Failed to load config: %v", err)
+			}
+			if config.AI.RequestTimeout != tt.expected {
+				t.Errorf("Expected request timeout %d, got %d", tt.expected, config.AI.RequestTimeout)
+			}
+		})
+	}
+}
+
 func TestValidateConfig(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -197,6 +236,56 @@ func TestValidateConfig(t *testing.T) {
 			expectError: true,
 			errorMsg:    "temperature must be between 0 and 1",
 		},
+		{
+			name: "invalid temperature for a test type",
+			config: &Config{
+				Mode: "manual",
+				AI: AIConfig{
+					Provider:          "openai",
+					Temperature:       0.2,
+					TemperatureByType: map[string]float64{"unit": 0.1, "fuzz": 1.5},
+					MaxTokens:         1000,
+				},
+				Filtering: DefaultConfig().Filtering,
+			},
+			expectError: true,
+			errorMsg:    "temperature_by_type.fuzz must be between 0 and 1",
+		},
+		{
+			name: "unknown test type in temperature_by_type",
+			config: &Config{
+				Mode: "manual",
+				AI: AIConfig{
+					Provider:          "openai",
+					Temperature:       0.2,
+					TemperatureByType: map[string]float64{"smoke": 0.5},
+					MaxTokens:         1000,
+				},
+				Filtering: DefaultConfig().Filtering,
+			},
+			expectError: true,
+			errorMsg:    "smoke",
+		},
+		{
+			name: "invalid openai mode",
+			config: &Config{
+				Mode:      "manual",
+				AI:        AIConfig{Provider: "openai", Temperature: 0.2, MaxTokens: 1000, OpenAIMode: "functions"},
+				Filtering: DefaultConfig().Filtering,
+			},
+			expectError: true,
+			errorMsg:    "ai.openai_mode must be one of",
+		},
+		{
+			name: "openai mode with another provider",
+			config: &Config{
+				Mode:      "manual",
+				AI:        AIConfig{Provider: "anthropic", Temperature: 0.2, MaxTokens: 1000, OpenAIMode: "tool"},
+				Filtering: DefaultConfig().Filtering,
+			},
+			expectError: true,
+			errorMsg:    "only supported by the openai provider",
+		},
 		{
 			name: "invalid max tokens",
 			config: &Config{
@@ -224,6 +313,204 @@ func TestValidateConfig(t *testing.T) {
 			expectError: true,
 			errorMsg:    "min_complexity (10) cannot be greater than max_complexity (5)",
 		},
+		{
+			name: "valid custom test name regex",
+			config: &Config{
+				Mode:      "manual",
+				AI:        DefaultConfig().AI,
+				Filtering: DefaultConfig().Filtering,
+				Output: OutputConfig{
+					TestNameStyle: `^Test_[A-Z]\w+$`,
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "invalid test name regex",
+			config: &Config{
+				Mode:      "manual",
+				AI:        DefaultConfig().AI,
+				Filtering: DefaultConfig().Filtering,
+				Output: OutputConfig{
+					TestNameStyle: "^Test_(",
+				},
+			},
+			expectError: true,
+			errorMsg:    "test_name_style must be",
+		},
+		{
+			name: "invalid flaky tests mode",
+			config: &Config{
+				Mode:      "manual",
+				AI:        DefaultConfig().AI,
+				Filtering: DefaultConfig().Filtering,
+				Output: OutputConfig{
+					FlakyTests: "ignore",
+				},
+			},
+			expectError: true,
+			errorMsg:    "flaky_tests must be 'warn', 'exclude' or 'repair'",
+		},
+		{
+			name: "negative metadata cache max age",
+			config: &Config{
+				Mode: "manual",
+				AI: AIConfig{
+					Provider:            "openai",
+					Temperature:         0.2,
+					MaxTokens:           2000,
+					MetadataCacheMaxAge: -1,
+				},
+				Filtering: DefaultConfig().Filtering,
+			},
+			expectError: true,
+			errorMsg:    "metadata_cache_max_age cannot be negative",
+		},
+		{
+			name: "organization with non-openai provider",
+			config: &Config{
+				Mode: "manual",
+				AI: AIConfig{
+					Provider:     "anthropic",
+					Temperature:  0.3,
+					MaxTokens:    1000,
+					Organization: "org-123",
+				},
+				Filtering: DefaultConfig().Filtering,
+			},
+			expectError: true,
+			errorMsg:    "only supported by the openai provider",
+		},
+		{
+			name: "negative max body lines",
+			config: &Config{
+				Mode: "manual",
+				AI: AIConfig{
+					Provider:     "openai",
+					Temperature:  0.3,
+					MaxTokens:    1000,
+					MaxBodyLines: -1,
+				},
+				Filtering: DefaultConfig().Filtering,
+			},
+			expectError: true,
+			errorMsg:    "max_body_lines cannot be negative",
+		},
+		{
+			name: "negative max prompt tokens",
+			config: &Config{
+				Mode: "manual",
+				AI: AIConfig{
+					Provider:        "openai",
+					Temperature:     0.3,
+					MaxTokens:       1000,
+					MaxPromptTokens: -1,
+				},
+				Filtering: DefaultConfig().Filtering,
+			},
+			expectError: true,
+			errorMsg:    "max_prompt_tokens cannot be negative",
+		},
+		{
+			name: "negative recent commits",
+			config: &Config{
+				Mode: "manual",
+				AI: AIConfig{
+					Provider:      "openai",
+					Temperature:   0.3,
+					MaxTokens:     1000,
+					RecentCommits: -1,
+				},
+				Filtering: DefaultConfig().Filtering,
+			},
+			expectError: true,
+			errorMsg:    "recent_commits cannot be negative",
+		},
+		{
+			name: "negative max retries",
+			config: &Config{
+				Mode: "manual",
+				AI: AIConfig{
+					Provider:    "openai",
+					Temperature: 0.3,
+					MaxTokens:   1000,
+					MaxRetries:  -1,
+				},
+				Filtering: DefaultConfig().Filtering,
+			},
+			expectError: true,
+			errorMsg:    "max_retries cannot be negative",
+		},
+		{
+			name: "negative hook timeout",
+			config: &Config{
+				Mode:     "manual",
+				Triggers: TriggerConfig{Auto: AutoTrigger{HookTimeout: -1}},
+				AI: AIConfig{
+					Provider:    "openai",
+					Temperature: 0.3,
+					MaxTokens:   1000,
+				},
+				Filtering: DefaultConfig().Filtering,
+			},
+			expectError: true,
+			errorMsg:    "hook_timeout cannot be negative",
+		},
+		{
+			name: "negative request timeout",
+			config: &Config{
+				Mode: "manual",
+				AI: AIConfig{
+					Provider:       "openai",
+					Temperature:    0.3,
+					MaxTokens:      1000,
+					RequestTimeout: -1,
+				},
+				Filtering: DefaultConfig().Filtering,
+			},
+			expectError: true,
+			errorMsg:    "request_timeout cannot be negative",
+		},
+		{
+			name: "negative max response bytes",
+			config: &Config{
+				Mode: "manual",
+				AI: AIConfig{
+					Provider:         "openai",
+					Temperature:      0.3,
+					MaxTokens:        1000,
+					MaxResponseBytes: -1,
+				},
+				Filtering: DefaultConfig().Filtering,
+			},
+			expectError: true,
+			errorMsg:    "max_response_bytes cannot be negative",
+		},
+		{
+			name: "invalid side effects mode",
+			config: &Config{
+				Mode: "manual",
+				AI:   DefaultConfig().AI,
+				Filtering: FilterConfig{
+					MaxComplexity: 15,
+					MinComplexity: 1,
+					SideEffects:   "ignore",
+				},
+			},
+			expectError: true,
+			errorMsg:    "side_effects must be",
+		},
+		{
+			name: "invalid blast radius",
+			config: &Config{
+				Mode:      "manual",
+				Triggers:  TriggerConfig{BlastRadius: "module"},
+				AI:        DefaultConfig().AI,
+				Filtering: DefaultConfig().Filtering,
+			},
+			expectError: true,
+			errorMsg:    "blast_radius must be",
+		},
 	}
 
 	for _, tt := range tests {
@@ -281,6 +568,134 @@ func TestEnvironmentOverrides(t *testing.T) {
 	}
 }
 
+func TestLoadConfigFromEnv(t *testing.T) {
+	// No config file anywhere testgen looks
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", t.TempDir())
+
+	for name, value := range map[string]string{
+		"TESTGEN_MODE":                       "auto",
+		"TESTGEN_HOOKS":                      "post-commit, pre-push",
+		"TESTGEN_TRIGGERS_AUTO_ON_PUSH":      "true",
+		"TESTGEN_TRIGGERS_BLAST_RADIUS":      "callers",
+		"TESTGEN_AI_PROVIDER":                "anthropic",
+		"TESTGEN_AI_MODEL":                   "claude-3-5-sonnet-latest",
+		"TESTGEN_MODEL":                      "claude-3-5-haiku-latest",
+		"TESTGEN_AI_TEMPERATURE":             "0.4",
+		"TESTGEN_AI_TEMPERATURE_BY_TYPE":     "benchmark=0.6, fuzz=0.1",
+		"TESTGEN_AI_MAX_TOKENS":              "4000",
+		"TESTGEN_OUTPUT_MERGE":               "true",
+		"TESTGEN_OUTPUT_DO_NOT_EDIT":         "false",
+		"TESTGEN_OUTPUT_FLAKY_TESTS":         "exclude",
+		"TESTGEN_FILTERING_SKIP_PATTERNS":    "main,init,String",
+		"TESTGEN_FILTERING_MAX_COMPLEXITY":   "20",
+		"TESTGEN_GIT_OMIT_AUTHOR":            "1",
+		"TESTGEN_OUTPUT_POST_PROCESS":        "gofumpt -w {}",
+		"TESTGEN_FILTERING_INCLUDE_PROMOTED": "true",
+	} {
+		t.Setenv(name, value)
+	}
+
+	config, err := LoadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("LoadConfigFromEnv failed: %v", err)
+	}
+
+	doNotEdit := false
+	want := DefaultConfig()
+	want.Mode = "auto"
+	want.Hooks = []string{"post-commit", "pre-push"}
+	want.Triggers.Auto.OnPush = true
+	want.Triggers.BlastRadius = "callers"
+	want.AI.Provider = "anthropic"
+	want.AI.Model = "claude-3-5-haiku-latest" // the short alias wins
+	want.AI.Temperature = 0.4
+	want.AI.TemperatureByType = map[string]float64{"benchmark": 0.6, "fuzz": 0.1}
+	want.AI.MaxTokens = 4000
+	want.Output.Merge = true
+	want.Output.DoNotEdit = &doNotEdit
+	want.Output.FlakyTests = "exclude"
+	want.Output.PostProcess = []string{"gofumpt -w {}"}
+	want.Filtering.SkipPatterns = []string{"main", "init", "String"}
+	want.Filtering.MaxComplexity = 20
+	want.Filtering.IncludePromoted = true
+	want.Git.OmitAuthor = true
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("Expected %+v, got %+v", want, config)
+	}
+
+	// The assembled configuration is validated
+	t.Setenv("TESTGEN_OUTPUT_FLAKY_TESTS", "sometimes")
+	if _, err := LoadConfigFromEnv(); err == nil || !strings.Contains(err.Error(), "invalid configuration") {
+		t.Errorf("Expected a validation error, got %v", err)
+	}
+	t.Setenv("TESTGEN_OUTPUT_FLAKY_TESTS", "")
+	t.Setenv("TESTGEN_AI_MAX_TOKENS", "lots")
+	if _, err := LoadConfigFromEnv(); err == nil || !strings.Contains(err.Error(), "TESTGEN_AI_MAX_TOKENS (ai.max_tokens): invalid integer") {
+		t.Errorf("Expected a parse error naming the variable, got %v", err)
+	}
+}
+
+func TestEnvSettings(t *testing.T) {
+	names := make(map[string]bool)
+	for _, setting := range envSettings() {
+		if names[setting.name] {
+			t.Errorf("Duplicate environment variable %s", setting.name)
+		}
+		names[setting.name] = true
+		if setting.name == ConfigEnvVar || setting.name == AllowedProvidersEnv || setting.name == ConfigFromEnvVar {
+			t.Errorf("%s (%s) collides with a reserved variable", setting.name, setting.key)
+		}
+	}
+	for _, want := range []string{"TESTGEN_MODE", "TESTGEN_AI_API_KEY", "TESTGEN_TRIGGERS_AUTO_FILE_PATTERNS", "TESTGEN_OUTPUT_DIRECTORY", "TESTGEN_GIT_BINARY"} {
+		if !names[want] {
+			t.Errorf("Expected %s to be settable", want)
+		}
+	}
+	if names["TESTGEN_AI_FEW_SHOT_EXAMPLES"] {
+		t.Error("Expected ai.few_shot_examples to be left to the config file")
+	}
+}
+
+func TestOverrideAI(t *testing.T) {
+	os.Setenv("TESTGEN_MODEL", "gpt-3.5-turbo")
+	defer os.Unsetenv("TESTGEN_MODEL")
+
+	config := DefaultConfig()
+	overrideWithEnv(config)
+
+	// Flags win over environment variables
+	if err := config.OverrideAI("anthropic", "claude-3-5-sonnet-latest"); err != nil {
+		t.Fatalf("OverrideAI failed: %v", err)
+	}
+	if config.AI.Provider != "anthropic" || config.AI.Model != "claude-3-5-sonnet-latest" {
+		t.Errorf("Expected anthropic/claude-3-5-sonnet-latest, got %s/%s", config.AI.Provider, config.AI.Model)
+	}
+
+	// Empty overrides keep the loaded values
+	if err := config.OverrideAI("", ""); err != nil {
+		t.Fatalf("OverrideAI failed: %v", err)
+	}
+	if config.AI.Provider != "anthropic" {
+		t.Errorf("Expected provider to stay anthropic, got %s", config.AI.Provider)
+	}
+
+	if err := config.OverrideAI("bogus", ""); err == nil || !strings.Contains(err.Error(), "unsupported AI provider") {
+		t.Errorf("Expected unsupported provider error, got %v", err)
+	}
+
+	// Invalid combinations are caught after applying the override
+	config = DefaultConfig()
+	config.AI.Organization = "org-123"
+	if err := config.OverrideAI("groq", ""); err == nil {
+		t.Error("Expected error for organization with the groq provider")
+	}
+}
+
 func TestGetTestOutputPath(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -335,6 +750,7 @@ func TestShouldIncludeFunction(t *testing.T) {
 			MinComplexity:     2,
 			MaxComplexity:     10,
 			SkipPatterns:      []string{"helper*", "internal*", "temp"},
+			AlwaysInclude:     []string{"helperCritical", "Token.Validate", "Check*"},
 		},
 	}
 
@@ -394,6 +810,41 @@ func TestShouldIncludeFunction(t *testing.T) {
 			complexity: 5,
 			expected:   false,
 		},
+		{
+			name:       "always_include wins over skip pattern and export status",
+			funcName:   "helperCritical",
+			isExported: false,
+			complexity: 5,
+			expected:   true,
+		},
+		{
+			name:       "always_include wins over complexity bounds",
+			funcName:   "CheckSignature",
+			isExported: true,
+			complexity: 25,
+			expected:   true,
+		},
+		{
+			name:       "always_include Type.Method pattern matches the method",
+			funcName:   "Token.Validate",
+			isExported: true,
+			complexity: 1,
+			expected:   true,
+		},
+		{
+			name:       "always_include Type.Method pattern ignores other receivers",
+			funcName:   "Session.Validate",
+			isExported: true,
+			complexity: 1,
+			expected:   false,
+		},
+		{
+			name:       "always_include name pattern matches methods",
+			funcName:   "Token.CheckExpiry",
+			isExported: true,
+			complexity: 1,
+			expected:   true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -570,3 +1021,614 @@ func findInString(s, substr string) bool {
 	}
 	return false
 }
+
+func TestValidateFewShotExamples(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "calc.go")
+	test := filepath.Join(dir, "calc_test.go")
+	if err := os.WriteFile(source, []byte("package calc\n\nfunc Add(a, b int) int { return a + b }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(test, []byte("package calc\n\nimport \"testing\"\n\nfunc TestAdd(t *testing.T) {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.AI.FewShotExamples = []FewShotExample{{FunctionFile: source, FunctionName: "Add", TestFile: test, TestName: "TestAdd"}}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("Expected valid examples, got: %v", err)
+	}
+
+	cfg.AI.FewShotExamples[0].TestName = "TestSubtract"
+	err := validateConfig(cfg)
+	if err == nil || !strings.Contains(err.Error(), "few_shot_examples[0]") || !strings.Contains(err.Error(), "TestSubtract") {
+		t.Errorf("Expected an error naming the missing test, got: %v", err)
+	}
+}
+
+func TestTemperatureFor(t *testing.T) {
+	ai := AIConfig{Temperature: 0.2, TemperatureByType: map[string]float64{"fuzz": 0.7, "example": 0}}
+	tests := map[string]float64{"unit": 0.2, "fuzz": 0.7, "example": 0, "benchmark": 0.2}
+	for testType, expected := range tests {
+		if got := ai.TemperatureFor(testType); got != expected {
+			t.Errorf("TemperatureFor(%q) = %v, expected %v", testType, got, expected)
+		}
+	}
+
+	if err := CheckTestType("benchmark"); err != nil {
+		t.Errorf("Expected benchmark to be a valid test type, got %v", err)
+	}
+	if err := CheckTestType("smoke"); err == nil {
+		t.Error("Expected an error for an unknown test type")
+	}
+}
+
+func TestResponseMode(t *testing.T) {
+	tests := []struct {
+		model, mode, want string
+	}{
+		{"gpt-4", "", OpenAIModeJSONObject},
+		{"gpt-3.5-turbo", "", OpenAIModeJSONObject},
+		{"gpt-4o", "", OpenAIModeJSONSchema},
+		{"gpt-4o-mini", "", OpenAIModeJSONSchema},
+		{"gpt-4o-2024-05-13", "", OpenAIModeJSONObject},
+		{"gpt-4.1-nano", "", OpenAIModeJSONSchema},
+		{"o3-mini", "", OpenAIModeJSONSchema},
+		{"gpt-4o", OpenAIModeTool, OpenAIModeTool},
+		{"gpt-4", OpenAIModeJSONSchema, OpenAIModeJSONSchema},
+	}
+	for _, tt := range tests {
+		ai := AIConfig{Provider: "openai", Model: tt.model, OpenAIMode: tt.mode}
+		if got := ai.ResponseMode(); got != tt.want {
+			t.Errorf("ResponseMode() for %s with openai_mode %q = %s, expected %s", tt.model, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestMarkDoNotEdit(t *testing.T) {
+	on, off := true, false
+	tests := []struct {
+		name      string
+		merge     bool
+		doNotEdit *bool
+		want      bool
+	}{
+		{name: "default", want: true},
+		{name: "merged files are edited by hand", merge: true, want: false},
+		{name: "explicitly on with merge", merge: true, doNotEdit: &on, want: true},
+		{name: "explicitly off", doNotEdit: &off, want: false},
+	}
+	for _, tt := range tests {
+		output := OutputConfig{Merge: tt.merge, DoNotEdit: tt.doNotEdit}
+		if got := output.MarkDoNotEdit(); got != tt.want {
+			t.Errorf("%s: MarkDoNotEdit() = %t, expected %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckProviderAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  []string
+		env     string
+		wantErr string
+	}{
+		{name: "no restrictions"},
+		{name: "allowed by config", config: []string{"anthropic", "openai"}},
+		{name: "denied by config", config: []string{"anthropic"}, wantErr: "not allowed by ai.allowed_providers (anthropic)"},
+		{name: "allowed by env", env: "anthropic, openai"},
+		{name: "denied by env", env: "anthropic,local", wantErr: "not allowed by TESTGEN_ALLOWED_PROVIDERS (anthropic, local)"},
+		{name: "env can't be widened by config", config: []string{"openai"}, env: "anthropic", wantErr: "not allowed by TESTGEN_ALLOWED_PROVIDERS"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(AllowedProvidersEnv, tt.env)
+			cfg := DefaultConfig()
+			cfg.AI.APIKey = "key"
+			cfg.AI.AllowedProviders = tt.config
+
+			err := validateConfig(cfg)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Expected openai to be allowed, got: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Expected error containing %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	// Unknown providers in the list are configuration mistakes
+	cfg := DefaultConfig()
+	cfg.AI.AllowedProviders = []string{"openia"}
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "'openia' in allowed_providers") {
+		t.Errorf("Expected an unsupported provider error, got: %v", err)
+	}
+}
+
+// schemaProperty walks a decoded JSON Schema to the property at a dotted yaml path
+func schemaProperty(schema map[string]interface{}, path string) map[string]interface{} {
+	node := schema
+	for _, key := range strings.Split(path, ".") {
+		if items, ok := node["items"].(map[string]interface{}); ok {
+			node = items
+		}
+		properties, _ := node["properties"].(map[string]interface{})
+		next, ok := properties[key].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		node = next
+	}
+	return node
+}
+
+func TestJSONSchema(t *testing.T) {
+	data, err := JSONSchema()
+	if err != nil {
+		t.Fatalf("JSONSchema failed: %v", err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("Schema is not valid JSON: %v", err)
+	}
+
+	// Every yaml field of Config has a property
+	var walk func(typ reflect.Type, path string)
+	walk = func(typ reflect.Type, path string) {
+		for typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice {
+			typ = typ.Elem()
+		}
+		if typ.Kind() != reflect.Struct {
+			return
+		}
+		for i := 0; i < typ.NumField(); i++ {
+			if !typ.Field(i).IsExported() {
+				continue
+			}
+			name := strings.Split(typ.Field(i).Tag.Get("yaml"), ",")[0]
+			fieldPath := joinPath(path, name)
+			if schemaProperty(schema, fieldPath) == nil {
+				t.Errorf("Schema is missing %s", fieldPath)
+				continue
+			}
+			walk(typ.Field(i).Type, fieldPath)
+		}
+	}
+	walk(reflect.TypeOf(Config{}), "")
+
+	// Rules must name real fields, so renaming one can't orphan its rule
+	for path := range schemaRules {
+		if schemaProperty(schema, path) == nil {
+			t.Errorf("Schema rule for %s matches no config field", path)
+		}
+	}
+
+	provider := schemaProperty(schema, "ai.provider")
+	if provider["default"] != "openai" || len(provider["enum"].([]interface{})) != len(validProviders) {
+		t.Errorf("Expected ai.provider to list the providers with default openai, got %v", provider)
+	}
+	if temperature := schemaProperty(schema, "ai.temperature"); temperature["minimum"] != 0.0 || temperature["maximum"] != 1.0 {
+		t.Errorf("Expected ai.temperature to be bounded by 0 and 1, got %v", temperature)
+	}
+	byType := schemaProperty(schema, "ai.temperature_by_type")
+	if values, ok := byType["additionalProperties"].(map[string]interface{}); !ok || values["maximum"] != 1.0 {
+		t.Errorf("Expected ai.temperature_by_type values to be bounded by 1, got %v", byType)
+	}
+	if names, ok := byType["propertyNames"].(map[string]interface{}); !ok || len(names["enum"].([]interface{})) != len(validTestTypes) {
+		t.Errorf("Expected ai.temperature_by_type keys to be the test types, got %v", byType)
+	}
+	if doNotEdit := schemaProperty(schema, "output.do_not_edit"); doNotEdit["type"] != "boolean" || doNotEdit["default"] != true {
+		t.Errorf("Expected output.do_not_edit to default to true, got %v", doNotEdit)
+	}
+	if timeout := schemaProperty(schema, "ai.timeout"); timeout == nil || timeout["deprecated"] != true {
+		t.Errorf("Expected the legacy ai.timeout key to be deprecated, got %v", timeout)
+	}
+	if example := schemaProperty(schema, "ai.few_shot_examples.test_name"); example == nil || example["type"] != "string" {
+		t.Errorf("Expected few_shot_examples items to be described, got %v", example)
+	}
+}
+
+func TestJSONSchemaEnumsMatchValidation(t *testing.T) {
+	t.Setenv(AllowedProvidersEnv, "")
+	for path, rule := range schemaRules {
+		if len(rule.Enum) == 0 {
+			continue
+		}
+		for _, value := range append(append([]string{}, rule.Enum...), "bogus") {
+			// Build the YAML setting path to value
+			var setting interface{} = value
+			if path == "ai.allowed_providers" {
+				setting = []string{value}
+			}
+			keys := strings.Split(path, ".")
+			doc := map[string]interface{}{keys[len(keys)-1]: setting}
+			if path == "ai.allowed_providers" {
+				doc["provider"] = value
+			}
+			for i := len(keys) - 2; i >= 0; i-- {
+				doc = map[string]interface{}{keys[i]: doc}
+			}
+			data, err := yaml.Marshal(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := DefaultConfig()
+			cfg.AI.APIKey = "key"
+			if err := yaml.Unmarshal(data, cfg); err != nil {
+				t.Fatal(err)
+			}
+			err = validateConfig(cfg)
+			if value != "bogus" && err != nil {
+				t.Errorf("Schema allows %s: %s but validation rejects it: %v", path, value, err)
+			}
+			if value == "bogus" && err == nil {
+				t.Errorf("Validation accepts %s: bogus, which the schema doesn't list", path)
+			}
+		}
+	}
+}
+
+func TestMergeYAML(t *testing.T) {
+	tests := []struct {
+		name  string
+		base  string
+		local string
+		want  string
+	}{
+		{
+			name:  "scalar replaced",
+			base:  "mode: manual\n",
+			local: "mode: auto\n",
+			want:  "mode: auto\n",
+		},
+		{
+			name:  "base-only and local-only keys kept",
+			base:  "mode: manual\n",
+			local: "hooks: [post-commit]\n",
+			want:  "mode: manual\nhooks: [post-commit]\n",
+		},
+		{
+			name:  "skip_patterns replaced, not appended",
+			base:  "filtering:\n  skip_patterns: [main, init, \"Test*\"]\n",
+			local: "filtering:\n  skip_patterns: [\"Example*\"]\n",
+			want:  "filtering:\n  skip_patterns: [\"Example*\"]\n",
+		},
+		{
+			name:  "empty list clears the base's",
+			base:  "filtering:\n  skip_patterns: [main, init]\n",
+			local: "filtering:\n  skip_patterns: []\n",
+			want:  "filtering:\n  skip_patterns: []\n",
+		},
+		{
+			name:  "list left out keeps the base's",
+			base:  "filtering:\n  skip_patterns: [main]\n  max_complexity: 10\n",
+			local: "filtering:\n  max_complexity: 20\n",
+			want:  "filtering:\n  skip_patterns: [main]\n  max_complexity: 20\n",
+		},
+		{
+			name:  "sections merged key by key",
+			base:  "ai:\n  provider: anthropic\n  model: claude-3-sonnet\n  temperature: 0.2\n",
+			local: "ai:\n  model: claude-3-opus\n",
+			want:  "ai:\n  provider: anthropic\n  model: claude-3-opus\n  temperature: 0.2\n",
+		},
+		{
+			name:  "maps merged key by key",
+			base:  "ai:\n  temperature_by_type:\n    unit: 0.1\n    integration: 0.3\n",
+			local: "ai:\n  temperature_by_type:\n    unit: 0.5\n",
+			want:  "ai:\n  temperature_by_type:\n    unit: 0.5\n    integration: 0.3\n",
+		},
+		{
+			name:  "nested sections merged",
+			base:  "triggers:\n  auto:\n    on_commit: true\n    exclude_files: [\"vendor/*\"]\n  blast_radius: callers\n",
+			local: "triggers:\n  auto:\n    on_push: true\n",
+			want:  "triggers:\n  auto:\n    on_commit: true\n    on_push: true\n    exclude_files: [\"vendor/*\"]\n  blast_radius: callers\n",
+		},
+		{
+			name:  "map replaces a scalar",
+			base:  "ai: none\n",
+			local: "ai:\n  model: gpt-4o\n",
+			want:  "ai:\n  model: gpt-4o\n",
+		},
+		{
+			name:  "scalar replaces a map",
+			base:  "ai:\n  model: gpt-4o\n",
+			local: "ai: none\n",
+			want:  "ai: none\n",
+		},
+		{
+			name:  "list of maps replaced whole",
+			base:  "ai:\n  few_shot_examples:\n    - {function_name: A}\n    - {function_name: B}\n",
+			local: "ai:\n  few_shot_examples:\n    - {function_name: C}\n",
+			want:  "ai:\n  few_shot_examples:\n    - {function_name: C}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var base, local, want map[string]interface{}
+			for _, doc := range []struct {
+				src string
+				dst *map[string]interface{}
+			}{{tt.base, &base}, {tt.local, &local}, {tt.want, &want}} {
+				if err := yaml.Unmarshal([]byte(doc.src), doc.dst); err != nil {
+					t.Fatalf("Bad fixture %q: %v", doc.src, err)
+				}
+			}
+			baseBefore := fmt.Sprint(base)
+
+			if got := mergeYAML(base, local); !reflect.DeepEqual(got, want) {
+				t.Errorf("Expected %v, got %v", want, got)
+			}
+			if fmt.Sprint(base) != baseBefore {
+				t.Errorf("Expected the base left alone, got %v", base)
+			}
+		})
+	}
+}
+
+func TestLoadConfigExtends(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write("org/root.yml", "ai:\n  provider: anthropic\n  model: claude-3-sonnet\nfiltering:\n  skip_patterns: [main, init, \"Mock*\"]\n  max_complexity: 10\n")
+	write("org/base.yml", "extends: root.yml\nai:\n  model: claude-3-opus\n  temperature_by_type:\n    unit: 0.1\nfiltering:\n  always_include: [\"Parse*\"]\n")
+	local := write("repo/.testgen.yml", "extends: ../org/base.yml\nmode: auto\nfiltering:\n  skip_patterns: [\"Example*\"]\n")
+
+	config, err := LoadConfigFromFile(local)
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile failed: %v", err)
+	}
+	if config.Mode != "auto" || config.AI.Provider != "anthropic" || config.AI.Model != "claude-3-opus" || config.AI.TemperatureByType["unit"] != 0.1 {
+		t.Errorf("Expected settings from all three files, got mode %s, provider %s, model %s, temperatures %v",
+			config.Mode, config.AI.Provider, config.AI.Model, config.AI.TemperatureByType)
+	}
+	if !reflect.DeepEqual(config.Filtering.SkipPatterns, []string{"Example*"}) {
+		t.Errorf("Expected the local skip_patterns to replace the base's, got %v", config.Filtering.SkipPatterns)
+	}
+	if config.Filtering.MaxComplexity != 10 || !reflect.DeepEqual(config.Filtering.AlwaysInclude, []string{"Parse*"}) {
+		t.Errorf("Expected untouched base settings kept, got max_complexity %d, always_include %v", config.Filtering.MaxComplexity, config.Filtering.AlwaysInclude)
+	}
+	if config.Output.Suffix != "_test.go" {
+		t.Errorf("Expected defaults under the bases, got suffix %q", config.Output.Suffix)
+	}
+	if config.Extends != "../org/base.yml" {
+		t.Errorf("Expected the local extends recorded, got %q", config.Extends)
+	}
+
+	// config show tells each value's file apart
+	want := []configLayer{
+		{Source: local, Keys: []string{"filtering.skip_patterns", "mode"}},
+		{Source: filepath.Join(dir, "org", "base.yml"), Keys: []string{"ai.model", "ai.temperature_by_type.unit", "filtering.always_include"}},
+		{Source: filepath.Join(dir, "org", "root.yml"), Keys: []string{"ai.provider", "filtering.max_complexity"}},
+	}
+	if !reflect.DeepEqual(config.layers, want) {
+		t.Errorf("Expected layers %+v, got %+v", want, config.layers)
+	}
+}
+
+func TestLoadConfigExtendsErrors(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write("a.yml", "extends: b.yml\n")
+	write("b.yml", "extends: a.yml\n")
+	write("d1.yml", "extends: d2.yml\n")
+	write("d2.yml", "extends: d3.yml\n")
+	write("d3.yml", "extends: d4.yml\n")
+	write("d4.yml", "mode: auto\n")
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"loop", "extends: a.yml\n", "extends loops back to"},
+		{"too deep", "extends: d1.yml\n", "more than 3 configs deep"},
+		{"plain http", "extends: http://example.com/base.yml\n", "must be a path or HTTPS URL"},
+		{"not a string", "extends: [a.yml]\n", "must be a path or HTTPS URL"},
+		{"missing base", "extends: nowhere.yml\n", "failed to read base config"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfigFromFile(write("local.yml", tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	// Three bases deep is allowed
+	if _, err := LoadConfigFromFile(write("local.yml", "extends: d2.yml\n")); err != nil {
+		t.Errorf("Expected a chain of %d bases to load, got %v", MaxExtendsDepth, err)
+	}
+}
+
+func TestLoadConfigExtendsRemote(t *testing.T) {
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/org/base.yml":
+			io.WriteString(w, "extends: common.yml\nai:\n  model: gpt-4o\n")
+		case "/org/common.yml":
+			io.WriteString(w, "filtering:\n  skip_patterns: [\"Mock*\"]\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	extendsTransport = server.Client().Transport
+	defer func() { extendsTransport = nil }()
+	defer SetOffline(false)
+
+	local := filepath.Join(dir, DefaultConfigFile)
+	if err := os.WriteFile(local, []byte("extends: "+server.URL+"/org/base.yml\nmode: auto\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		config, err := LoadConfigFromFile(local)
+		if err != nil {
+			t.Fatalf("LoadConfigFromFile failed: %v", err)
+		}
+		if config.AI.Model != "gpt-4o" || !reflect.DeepEqual(config.Filtering.SkipPatterns, []string{"Mock*"}) {
+			t.Errorf("Expected the remote bases merged, relative extends resolved against the URL, got model %s, skip_patterns %v", config.AI.Model, config.Filtering.SkipPatterns)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("Expected each base fetched once within the TTL, got %d requests", requests)
+	}
+
+	// Past the TTL with the server gone, only --offline loads
+	entries, _ := filepath.Glob(filepath.Join(ExtendsCacheDir, "*.json"))
+	for _, path := range entries {
+		data, _ := os.ReadFile(path)
+		var entry map[string]interface{}
+		json.Unmarshal(data, &entry)
+		entry["expires"] = "2000-01-01T00:00:00Z"
+		data, _ = json.Marshal(entry)
+		os.WriteFile(path, data, 0644)
+	}
+	server.Close()
+	if _, err := LoadConfigFromFile(local); err == nil || !strings.Contains(err.Error(), "--offline uses the cached copy") {
+		t.Errorf("Expected a failed fetch to point at --offline, got %v", err)
+	}
+	SetOffline(true)
+	config, err := LoadConfigFromFile(local)
+	if err != nil || config.AI.Model != "gpt-4o" {
+		t.Errorf("Expected the stale cache used offline, got %v", err)
+	}
+
+	os.RemoveAll(ExtendsCacheDir)
+	if _, err := LoadConfigFromFile(local); err == nil || !strings.Contains(err.Error(), "never been fetched") {
+		t.Errorf("Expected offline without a cached copy to fail, got %v", err)
+	}
+}
+
+func TestConfigLocations(t *testing.T) {
+	// A monorepo with a root config, and a nested module with its own
+	repo := t.TempDir()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(ConfigEnvVar, "")
+	service := filepath.Join(repo, "services", "billing")
+	pkg := filepath.Join(service, "internal", "invoice")
+	for _, dir := range []string{filepath.Join(repo, ".git"), pkg} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(service, "go.mod"), "module example.com/billing\n")
+
+	chosen := func(dir string) string {
+		t.Helper()
+		location := ChosenConfig(ConfigLocations(dir))
+		if location == nil {
+			return ""
+		}
+		return location.Path + " (" + location.Reason + ")"
+	}
+
+	if got := chosen(pkg); got != "" {
+		t.Errorf("Expected no config anywhere, got %s", got)
+	}
+
+	write(filepath.Join(home, GlobalConfigFile), "mode: manual\n")
+	if got, want := chosen(pkg), filepath.Join(home, GlobalConfigFile)+" (home directory)"; got != want {
+		t.Errorf("Expected the home config last, got %q, want %q", got, want)
+	}
+
+	// Past the nested module's go.mod, up to the git root
+	write(filepath.Join(repo, DefaultConfigFile), "mode: manual\n")
+	if got, want := chosen(pkg), filepath.Join(repo, DefaultConfigFile)+" (git root)"; got != want {
+		t.Errorf("Expected the git root config over home, got %q, want %q", got, want)
+	}
+
+	write(filepath.Join(service, DefaultConfigFile), "mode: manual\n")
+	if got, want := chosen(pkg), filepath.Join(service, DefaultConfigFile)+" (parent directory)"; got != want {
+		t.Errorf("Expected the nearest config over the git root's, got %q, want %q", got, want)
+	}
+	if got, want := chosen(repo), filepath.Join(repo, DefaultConfigFile)+" (working directory)"; got != want {
+		t.Errorf("Expected the root config from the root, got %q, want %q", got, want)
+	}
+
+	explicit := filepath.Join(t.TempDir(), "ci.yml")
+	write(explicit, "mode: manual\n")
+	t.Setenv(ConfigEnvVar, explicit)
+	if got, want := chosen(pkg), explicit+" ($"+ConfigEnvVar+")"; got != want {
+		t.Errorf("Expected %s first, got %q, want %q", ConfigEnvVar, got, want)
+	}
+	t.Setenv(ConfigEnvVar, "")
+
+	// Every location is listed, in order, whether or not it exists
+	var reasons []string
+	for _, location := range ConfigLocations(pkg) {
+		reasons = append(reasons, location.Reason)
+	}
+	want := []string{"working directory", "parent directory", "parent directory", "parent directory", "git root", "home directory"}
+	if !reflect.DeepEqual(reasons, want) {
+		t.Errorf("Expected locations %v, got %v", want, reasons)
+	}
+
+	// Outside a repository the walk stops at the nearest go.mod
+	os.RemoveAll(filepath.Join(repo, ".git"))
+	os.Remove(filepath.Join(service, DefaultConfigFile))
+	if got, want := chosen(pkg), filepath.Join(home, GlobalConfigFile)+" (home directory)"; got != want {
+		t.Errorf("Expected the walk to stop at the module root, got %q, want %q", got, want)
+	}
+}
+
+func TestLoadConfigNearest(t *testing.T) {
+	repo := t.TempDir()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(ConfigEnvVar, "")
+	nested := filepath.Join(repo, "tools", "lint")
+	if err := os.MkdirAll(filepath.Join(repo, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(nested, 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(repo, DefaultConfigFile), []byte("ai:\n  provider: local\n  model: root-model\n"), 0644)
+	os.WriteFile(filepath.Join(repo, "tools", DefaultConfigFile), []byte("ai:\n  provider: local\n  model: tools-model\n"), 0644)
+
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	if err := os.Chdir(nested); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.AI.Model != "tools-model" {
+		t.Errorf("Expected the nearest config's model, got %q", cfg.AI.Model)
+	}
+}
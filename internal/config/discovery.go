@@ -0,0 +1,88 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// ConfigLocation is a place a config file is looked for, in the order
+// LoadConfig tries them
+type ConfigLocation struct {
+	Path   string
+	Reason string // why it's checked, e.g. "git root"
+	Found  bool
+}
+
+// ConfigLocations lists where LoadConfig looks for a config file when run
+// in dir, most specific first: $TESTGEN_CONFIG, then .testgen.yml in dir
+// and each directory above it up to the git root, so the nearest one wins
+// over a monorepo root's, then the home directory. Outside a git
+// repository the walk stops at the nearest go.mod instead, or at dir
+// itself without one.
+func ConfigLocations(dir string) []ConfigLocation {
+	var locations []ConfigLocation
+	seen := make(map[string]bool)
+	add := func(path, reason string) {
+		if seen[path] {
+			return
+		}
+		seen[path] = true
+		_, err := os.Stat(path)
+		locations = append(locations, ConfigLocation{Path: path, Reason: reason, Found: err == nil})
+	}
+
+	if configPath := os.Getenv(ConfigEnvVar); configPath != "" {
+		add(configPath, "$"+ConfigEnvVar)
+	}
+
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	top, topReason := findUp(dir, ".git"), "git root"
+	if top == "" {
+		top, topReason = findUp(dir, "go.mod"), "module root"
+	}
+	for current := dir; ; current = filepath.Dir(current) {
+		reason := "parent directory"
+		switch current {
+		case dir:
+			reason = "working directory"
+		case top:
+			reason = topReason
+		}
+		add(filepath.Join(current, DefaultConfigFile), reason)
+		if top == "" || current == top || filepath.Dir(current) == current {
+			break
+		}
+	}
+
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		add(filepath.Join(homeDir, GlobalConfigFile), "home directory")
+	}
+	return locations
+}
+
+// ChosenConfig returns the location LoadConfig reads, the first of
+// locations holding a config file, or nil when none does
+func ChosenConfig(locations []ConfigLocation) *ConfigLocation {
+	for i := range locations {
+		if locations[i].Found {
+			return &locations[i]
+		}
+	}
+	return nil
+}
+
+// findUp returns the nearest directory from dir upward holding name, or ""
+func findUp(dir, name string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
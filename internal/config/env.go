@@ -0,0 +1,201 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ConfigFromEnvVar names the environment variable that, when true, makes
+// testgen build its configuration from the environment alone, like
+// --config-from-env
+const ConfigFromEnvVar = "TESTGEN_CONFIG_FROM_ENV"
+
+// envAliases are the short names the most common settings were first read
+// from. They still work, and win over the full names.
+var envAliases = []struct {
+	name string
+	key  string
+}{
+	{"TESTGEN_API_KEY", "ai.api_key"},
+	{"TESTGEN_MODEL", "ai.model"},
+	{"TESTGEN_PROVIDER", "ai.provider"},
+	{"TESTGEN_BASE_URL", "ai.base_url"},
+	{"TESTGEN_GIT_BIN", "git.binary"},
+}
+
+// envSetting is a config field settable from the environment
+type envSetting struct {
+	name  string // environment variable, e.g. TESTGEN_AI_TEMPERATURE
+	key   string // dotted config key, e.g. ai.temperature
+	index []int  // field index path in Config
+}
+
+// envSettings lists every config field settable from the environment, in
+// the order they're declared. Names are TESTGEN_ followed by the field's
+// key with dots as underscores, upper-cased; lists are comma separated and
+// ai.temperature_by_type takes type=value pairs. ai.few_shot_examples has no
+// flat form and is left to the config file.
+func envSettings() []envSetting {
+	var settings []envSetting
+	var walk func(typ reflect.Type, index []int, key string)
+	walk = func(typ reflect.Type, index []int, key string) {
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			// extends: only means something in a config file
+			if tag == "" || tag == "-" || tag == "extends" {
+				continue
+			}
+			fieldKey := tag
+			if key != "" {
+				fieldKey = key + "." + tag
+			}
+			fieldIndex := append(slices.Clone(index), i)
+			if field.Type.Kind() == reflect.Struct {
+				walk(field.Type, fieldIndex, fieldKey)
+				continue
+			}
+			if !envSettable(field.Type) {
+				continue
+			}
+			settings = append(settings, envSetting{
+				name:  "TESTGEN_" + strings.ToUpper(strings.ReplaceAll(fieldKey, ".", "_")),
+				key:   fieldKey,
+				index: fieldIndex,
+			})
+		}
+	}
+	walk(reflect.TypeOf(Config{}), nil, "")
+	return settings
+}
+
+// envSettable reports whether values of typ can be written as one
+// environment variable
+func envSettable(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Float64:
+		return true
+	case reflect.Pointer:
+		return typ.Elem().Kind() == reflect.Bool
+	case reflect.Slice:
+		return typ.Elem().Kind() == reflect.String
+	case reflect.Map:
+		return typ.Key().Kind() == reflect.String && typ.Elem().Kind() == reflect.Float64
+	}
+	return false
+}
+
+// overrideWithEnv overrides config with environment variables: every
+// setting's TESTGEN_* name, then the short aliases. Unset and empty
+// variables leave the setting alone.
+func overrideWithEnv(config *Config) error {
+	settings := envSettings()
+	for _, setting := range settings {
+		if err := setFromEnv(config, setting, setting.name); err != nil {
+			return err
+		}
+	}
+	for _, alias := range envAliases {
+		for _, setting := range settings {
+			if setting.key == alias.key {
+				if err := setFromEnv(config, setting, alias.name); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// setFromEnv sets a setting of config from the environment variable name,
+// when it's set
+func setFromEnv(config *Config, setting envSetting, name string) error {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return nil
+	}
+	if err := setEnvValue(reflect.ValueOf(config).Elem().FieldByIndex(setting.index), raw); err != nil {
+		return fmt.Errorf("%s (%s): %w", name, setting.key, err)
+	}
+	return nil
+}
+
+// setEnvValue parses raw into field
+func setEnvValue(field reflect.Value, raw string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		field.SetBool(value)
+	case reflect.Int:
+		value, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		field.SetInt(int64(value))
+	case reflect.Float64:
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		field.SetFloat(value)
+	case reflect.Pointer:
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		field.Set(reflect.ValueOf(&value))
+	case reflect.Slice:
+		values := []string{}
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+		field.Set(reflect.ValueOf(values))
+	case reflect.Map:
+		values := make(map[string]float64)
+		for _, pair := range strings.Split(raw, ",") {
+			name, number, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+			if !ok || err != nil {
+				return fmt.Errorf("invalid pair %q, want name=number", pair)
+			}
+			values[strings.TrimSpace(name)] = value
+		}
+		field.Set(reflect.ValueOf(values))
+	}
+	return nil
+}
+
+// ConfigFromEnvRequested reports whether TESTGEN_CONFIG_FROM_ENV asks for
+// the configuration to come from the environment alone
+func ConfigFromEnvRequested() bool {
+	requested, _ := strconv.ParseBool(os.Getenv(ConfigFromEnvVar))
+	return requested
+}
+
+// LoadConfigFromEnv builds the configuration from the defaults and TESTGEN_*
+// environment variables alone, without looking for a config file, for
+// containers where mounting one is awkward
+func LoadConfigFromEnv() (*Config, error) {
+	config := DefaultConfig()
+
+	if err := overrideWithEnv(config); err != nil {
+		return nil, fmt.Errorf("invalid environment: %w", err)
+	}
+
+	if err := validateConfig(config); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return config, nil
+}
@@ -0,0 +1,226 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Eranmonnie/testgen/internal/httpcache"
+	"gopkg.in/yaml.v3"
+)
+
+// MaxExtendsDepth is how many base configs a chain of extends: may go
+// through below the config file itself
+const MaxExtendsDepth = 3
+
+// ExtendsCacheDir caches remote base configs, relative to the working
+// directory, for ExtendsCacheTTL before they're fetched again
+const (
+	ExtendsCacheDir = ".testgen/extends"
+	ExtendsCacheTTL = time.Hour
+)
+
+var (
+	// offline serves remote base configs from the cache however old (--offline)
+	offline bool
+
+	// extendsTransport fetches remote base configs (nil = http.DefaultTransport)
+	extendsTransport http.RoundTripper
+)
+
+// SetOffline makes remote base configs come from the cache alone, however
+// stale, instead of failing when they can't be fetched
+func SetOffline(on bool) {
+	offline = on
+}
+
+// configLayer is one file of an extends: chain and the settings whose
+// value it decides
+type configLayer struct {
+	Source string   // path or URL
+	Keys   []string // dotted keys, sorted
+}
+
+// loadLayers reads the config at source and the chain of bases it extends,
+// and returns them deep-merged with source on top: maps are merged key by
+// key, anything else, lists included, replaces the base's value. It also
+// returns the layers, source first, with the keys each one decides. chain
+// is the files already extending source.
+func loadLayers(source string, chain []string) (map[string]interface{}, []configLayer, error) {
+	if slices.Contains(chain, source) {
+		return nil, nil, fmt.Errorf("extends loops back to %s", source)
+	}
+	if len(chain) > MaxExtendsDepth {
+		return nil, nil, fmt.Errorf("extends goes more than %d configs deep at %s", MaxExtendsDepth, source)
+	}
+
+	data, err := readConfigSource(source, len(chain) == 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse YAML of %s: %w", source, err)
+	}
+	if doc == nil {
+		doc = make(map[string]interface{})
+	}
+
+	extends, ok := doc["extends"].(string)
+	if _, set := doc["extends"]; set && !ok {
+		return nil, nil, fmt.Errorf("extends in %s must be a path or HTTPS URL", source)
+	}
+	delete(doc, "extends")
+
+	layer := configLayer{Source: source, Keys: leafKeys("", doc)}
+	if extends == "" {
+		return doc, []configLayer{layer}, nil
+	}
+
+	baseSource, err := resolveExtends(source, extends)
+	if err != nil {
+		return nil, nil, err
+	}
+	base, layers, err := loadLayers(baseSource, append(chain, source))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// What source sets is no longer decided by its bases
+	for i := range layers {
+		layers[i].Keys = slices.DeleteFunc(layers[i].Keys, func(key string) bool {
+			return slices.ContainsFunc(layer.Keys, func(own string) bool { return keysOverlap(key, own) })
+		})
+	}
+	return mergeYAML(base, doc), append([]configLayer{layer}, layers...), nil
+}
+
+// mergeYAML returns local deep-merged over base: maps present in both are
+// merged, any other value of local replaces base's
+func mergeYAML(base, local map[string]interface{}) map[string]interface{} {
+	merged := maps.Clone(base)
+	for key, value := range local {
+		baseMap, baseIsMap := merged[key].(map[string]interface{})
+		localMap, localIsMap := value.(map[string]interface{})
+		if baseIsMap && localIsMap {
+			merged[key] = mergeYAML(baseMap, localMap)
+			continue
+		}
+		merged[key] = value
+	}
+	return merged
+}
+
+// leafKeys lists the dotted keys of the values doc sets below prefix, going
+// into maps but not lists, sorted
+func leafKeys(prefix string, doc map[string]interface{}) []string {
+	var keys []string
+	for key, value := range doc {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+			keys = append(keys, leafKeys(key, nested)...)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// keysOverlap reports whether setting one dotted key replaces the other:
+// they're equal or one is inside the other
+func keysOverlap(a, b string) bool {
+	return a == b || strings.HasPrefix(a, b+".") || strings.HasPrefix(b, a+".")
+}
+
+// resolveExtends resolves the extends: value of the config at source: URLs
+// as they are, paths relative to the extending config
+func resolveExtends(source, extends string) (string, error) {
+	if isURL(extends) {
+		return extends, checkURL(extends)
+	}
+	if isURL(source) {
+		base, err := url.Parse(source)
+		if err != nil {
+			return "", fmt.Errorf("invalid URL %s: %w", source, err)
+		}
+		ref, err := url.Parse(filepath.ToSlash(extends))
+		if err != nil {
+			return "", fmt.Errorf("invalid extends %s in %s: %w", extends, source, err)
+		}
+		return base.ResolveReference(ref).String(), nil
+	}
+	if filepath.IsAbs(extends) {
+		return extends, nil
+	}
+	return filepath.Join(filepath.Dir(source), extends), nil
+}
+
+// isURL reports whether an extends: value names a URL rather than a path
+func isURL(s string) bool {
+	return strings.Contains(s, "://")
+}
+
+// checkURL rejects base configs not fetched over HTTPS
+func checkURL(s string) error {
+	if !strings.HasPrefix(s, "https://") {
+		return fmt.Errorf("extends %s must be a path or HTTPS URL", s)
+	}
+	return nil
+}
+
+// readConfigSource reads a config file, or fetches a remote base config.
+// top is the config file itself, whose read errors keep their old wording.
+func readConfigSource(source string, top bool) ([]byte, error) {
+	if isURL(source) {
+		return fetchBaseConfig(source)
+	}
+	data, err := os.ReadFile(source)
+	if err != nil && top {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read base config: %w", err)
+	}
+	return data, nil
+}
+
+// fetchBaseConfig fetches a remote base config through the cache in
+// ExtendsCacheDir. Offline, only the cache is used, with a warning when its
+// copy is past ExtendsCacheTTL.
+func fetchBaseConfig(source string) ([]byte, error) {
+	cache := httpcache.New(ExtendsCacheDir, ExtendsCacheTTL, extendsTransport)
+	cache.Offline = offline
+	client := &http.Client{Transport: cache, Timeout: 30 * time.Second}
+
+	resp, err := client.Get(source)
+	if err != nil && offline {
+		return nil, fmt.Errorf("base config %s has never been fetched, so it can't be used offline", source)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch base config %s: %w (--offline uses the cached copy)", source, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch base config %s: %s", source, resp.Status)
+	}
+	if resp.Header.Get(httpcache.StatusHeader) == "stale" {
+		fmt.Fprintf(os.Stderr, "Warning: offline, using a cached copy of base config %s older than %s\n", source, ExtendsCacheTTL)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch base config %s: %w", source, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// SchemaID is the JSON Schema dialect of the config schema
+const SchemaID = "https://json-schema.org/draft/2020-12/schema"
+
+// jsonSchema is the subset of JSON Schema the config schema uses
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	AdditionalProperties interface{}            `json:"additionalProperties,omitempty"` // false, or the schema of a map's values
+	PropertyNames        *jsonSchema            `json:"propertyNames,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	Examples             []string               `json:"examples,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	Default              interface{}            `json:"default,omitempty"`
+	Deprecated           bool                   `json:"deprecated,omitempty"`
+}
+
+// schemaRule is what validateConfig (or the code reading a setting) allows
+// beyond its Go type, keyed in schemaRules by dotted yaml path
+type schemaRule struct {
+	Enum     []string // allowed values; for lists, of each item
+	Keys     []string // allowed keys of a map; bounds apply to its values
+	Examples []string // suggested values when others are allowed too
+	Minimum  *float64
+	Maximum  *float64
+	Default  interface{} // when the zero value isn't the effective default
+}
+
+// bound returns a pointer for schemaRule limits
+func bound(v float64) *float64 {
+	return &v
+}
+
+// schemaRules adds validation rules to the fields reflection finds
+var schemaRules = map[string]schemaRule{
+	"mode":                        {Enum: validModes},
+	"triggers.blast_radius":       {Enum: validBlastRadii},
+	"ai.provider":                 {Enum: validProviders},
+	"ai.allowed_providers":        {Enum: validProviders},
+	"ai.temperature":              {Minimum: bound(0), Maximum: bound(1)},
+	"ai.temperature_by_type":      {Keys: validTestTypes, Minimum: bound(0), Maximum: bound(1)},
+	"ai.openai_mode":              {Enum: validOpenAIModes},
+	"ai.max_tokens":               {Minimum: bound(1)},
+	"triggers.auto.hook_timeout":  {Minimum: bound(0)},
+	"ai.request_timeout":          {Minimum: bound(0)},
+	"ai.read_timeout":             {Minimum: bound(0)},
+	"ai.max_retries":              {Minimum: bound(0)},
+	"ai.retry_backoff_seconds":    {Minimum: bound(0)},
+	"ai.max_response_bytes":       {Minimum: bound(0)},
+	"ai.max_body_lines":           {Minimum: bound(0)},
+	"ai.max_prompt_tokens":        {Minimum: bound(0)},
+	"ai.recent_commits":           {Minimum: bound(0)},
+	"ai.metadata_cache_max_age":   {Minimum: bound(0)},
+	"output.test_name_style":      {Examples: []string{TestNameStyleGoDefault, TestNameStyleUnderscore}},
+	"output.comment_style":        {Enum: validCommentStyles},
+	"output.flaky_tests":          {Enum: validFlakyModes},
+	"output.do_not_edit":          {Default: true},
+	"output.post_process_timeout": {Minimum: bound(0)},
+	"filtering.side_effects":      {Enum: validSideEffects},
+}
+
+// legacyFields are keys still read from config files that the struct no
+// longer has
+var legacyFields = map[string]*jsonSchema{
+	"ai.timeout": {Type: "integer", Description: "replaced by request_timeout", Deprecated: true},
+}
+
+// JSONSchema returns a JSON Schema describing .testgen.yml, built by
+// reflection over Config so every field is covered, with defaults from
+// DefaultConfig and the values validateConfig accepts
+func JSONSchema() ([]byte, error) {
+	schema, err := schemaFor(reflect.TypeOf(Config{}), reflect.ValueOf(*DefaultConfig()), "")
+	if err != nil {
+		return nil, err
+	}
+	schema.Schema = SchemaID
+	schema.Title = "testgen configuration (" + DefaultConfigFile + ")"
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+// schemaFor describes a value of type t, whose default is def, found at the
+// dotted yaml path
+func schemaFor(t reflect.Type, def reflect.Value, path string) (*jsonSchema, error) {
+	schema := &jsonSchema{}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		if def.IsValid() && !def.IsNil() {
+			def = def.Elem()
+		} else {
+			def = reflect.Value{}
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		schema.Type = "object"
+		schema.AdditionalProperties = false
+		schema.Properties = make(map[string]*jsonSchema)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if name == "" || name == "-" || !field.IsExported() {
+				continue
+			}
+			var fieldDef reflect.Value
+			if def.IsValid() {
+				fieldDef = def.Field(i)
+			}
+			property, err := schemaFor(field.Type, fieldDef, joinPath(path, name))
+			if err != nil {
+				return nil, err
+			}
+			schema.Properties[name] = property
+		}
+		for legacyPath, legacy := range legacyFields {
+			if parent, name := splitPath(legacyPath); parent == path {
+				schema.Properties[name] = legacy
+			}
+		}
+		return schema, nil
+	case reflect.Slice:
+		schema.Type = "array"
+		items, err := schemaFor(t.Elem(), reflect.Value{}, path)
+		if err != nil {
+			return nil, err
+		}
+		schema.Items = items
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("config field %s has unsupported type %s", path, t)
+		}
+		values, err := schemaFor(t.Elem(), reflect.Value{}, path)
+		if err != nil {
+			return nil, err
+		}
+		schema.Type = "object"
+		schema.AdditionalProperties = values
+	case reflect.String:
+		schema.Type = "string"
+	case reflect.Bool:
+		schema.Type = "boolean"
+	case reflect.Int, reflect.Int64:
+		schema.Type = "integer"
+	case reflect.Float64:
+		schema.Type = "number"
+	default:
+		return nil, fmt.Errorf("config field %s has unsupported type %s", path, t)
+	}
+
+	if def.IsValid() && !((def.Kind() == reflect.Slice || def.Kind() == reflect.Map) && def.IsNil()) {
+		schema.Default = def.Interface()
+	}
+
+	rule, ok := schemaRules[path]
+	if !ok {
+		return schema, nil
+	}
+	target := schema
+	if schema.Items != nil {
+		target = schema.Items
+	}
+	if values, ok := schema.AdditionalProperties.(*jsonSchema); ok {
+		target = values
+		if rule.Keys != nil {
+			schema.PropertyNames = &jsonSchema{Enum: rule.Keys}
+		}
+	}
+	target.Enum = rule.Enum
+	target.Examples = rule.Examples
+	target.Minimum = rule.Minimum
+	target.Maximum = rule.Maximum
+	if rule.Default != nil {
+		schema.Default = rule.Default
+	}
+	return schema, nil
+}
+
+// joinPath appends a yaml key to a dotted path
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// splitPath splits a dotted path into its parent and last key
+func splitPath(path string) (string, string) {
+	if i := strings.LastIndex(path, "."); i >= 0 {
+		return path[:i], path[i+1:]
+	}
+	return "", path
+}
@@ -0,0 +1,201 @@
+// Package coverage measures the statement coverage of packages with
+// go test -cover, for generate --run-tests and its --fail-under gate.
+//
+// Tests run in a workspace without candidate files, so they see the real
+// tree while go can't rewrite the user's go.mod or go.sum.
+package coverage
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Eranmonnie/testgen/internal/workspace"
+)
+
+// Result is the coverage of one package
+type Result struct {
+	Package      string  // import path
+	Percent      float64 // statement coverage, 0 for a package without tests
+	Failed       bool    // tests failed or didn't build
+	NoStatements bool    // nothing to cover
+}
+
+// Change is a package's coverage before and after a run
+type Change struct {
+	Package string  `json:"package"`
+	Before  float64 `json:"before"`
+	After   float64 `json:"after"`
+	Failed  bool    `json:"failed,omitempty"` // tests failed after the run
+
+	NoStatements bool `json:"no_statements,omitempty"` // nothing to cover, never below a threshold
+}
+
+// percentRegex finds the percentage in a go test -cover line
+var percentRegex = regexp.MustCompile(`coverage: ([0-9.]+)% of statements`)
+
+// Measure runs go test -cover over the packages in dirs, once per module,
+// and returns their coverage sorted by import path. Failing tests don't
+// make it return an error: they're reported in Result.Failed. Output go
+// test prints that can't be parsed into any result is returned as an error.
+func Measure(ctx context.Context, dirs []string) ([]Result, error) {
+	byModule := make(map[string][]string)
+	for _, dir := range dirs {
+		root, err := workspace.FindModuleRoot(dir)
+		if err != nil {
+			return nil, err
+		}
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(root, abs)
+		if err != nil {
+			return nil, err
+		}
+		pattern := "./" + filepath.ToSlash(rel)
+		if rel == "." {
+			pattern = "."
+		}
+		byModule[root] = append(byModule[root], pattern)
+	}
+
+	roots := make([]string, 0, len(byModule))
+	for root := range byModule {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+
+	var results []Result
+	for _, root := range roots {
+		var output []byte
+		err := workspace.Run(ctx, root, func(ctx context.Context, w *workspace.Workspace) error {
+			var err error
+			output, err = w.Go(ctx, ".", "test", append([]string{"-cover"}, dedupe(byModule[root])...)...)
+			return err
+		})
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		parsed := Parse(string(output))
+		if len(parsed) == 0 {
+			if err == nil {
+				err = fmt.Errorf("no coverage reported")
+			}
+			return nil, fmt.Errorf("failed to measure coverage in %s: %w\n%s", root, err, strings.TrimSpace(string(output)))
+		}
+		results = append(results, parsed...)
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Package < results[j].Package })
+	return results, nil
+}
+
+// Parse reads the per-package lines of go test -cover output. A failing
+// package keeps the coverage its test binary printed before failing.
+func Parse(output string) []Result {
+	var results []Result
+	index := make(map[string]int)
+	add := func(result Result) {
+		if i, ok := index[result.Package]; ok {
+			results[i] = result
+			return
+		}
+		index[result.Package] = len(results)
+		results = append(results, result)
+	}
+
+	lastPercent := -1.0
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		percent, hasPercent := parsePercent(line)
+
+		switch {
+		case len(fields) >= 2 && fields[0] == "ok":
+			add(Result{Package: fields[1], Percent: percent, NoStatements: strings.Contains(line, "[no statements]")})
+		case len(fields) >= 2 && fields[0] == "FAIL":
+			result := Result{Package: fields[1], Failed: true}
+			if lastPercent >= 0 {
+				result.Percent = lastPercent
+			}
+			add(result)
+		case len(fields) >= 2 && fields[0] == "?":
+			add(Result{Package: fields[1]})
+		case len(fields) >= 2 && strings.HasPrefix(line, "\t") && hasPercent:
+			// go 1.22+ reports packages without test files this way
+			add(Result{Package: fields[0], Percent: percent})
+		}
+
+		// A failing test binary prints its coverage on a line of its own
+		// before the FAIL line for its package
+		if hasPercent && strings.HasPrefix(line, "coverage:") {
+			lastPercent = percent
+		} else if len(fields) > 0 && (fields[0] == "ok" || fields[0] == "FAIL" || fields[0] == "?") {
+			lastPercent = -1
+		}
+	}
+	return results
+}
+
+// Compare pairs each package measured after a run with its coverage before
+func Compare(before, after []Result) []Change {
+	previous := make(map[string]float64)
+	for _, result := range before {
+		previous[result.Package] = result.Percent
+	}
+
+	changes := make([]Change, 0, len(after))
+	for _, result := range after {
+		changes = append(changes, Change{
+			Package: result.Package,
+			Before:  previous[result.Package],
+			After:   result.Percent,
+			Failed:  result.Failed,
+
+			NoStatements: result.NoStatements,
+		})
+	}
+	return changes
+}
+
+// Below returns the changes whose coverage after the run is under threshold
+// percent; packages with nothing to cover never are
+func Below(changes []Change, threshold float64) []Change {
+	var below []Change
+	for _, change := range changes {
+		if !change.NoStatements && change.After < threshold {
+			below = append(below, change)
+		}
+	}
+	return below
+}
+
+// parsePercent returns the coverage percentage on a line, if any
+func parsePercent(line string) (float64, bool) {
+	match := percentRegex.FindStringSubmatch(line)
+	if match == nil {
+		return 0, false
+	}
+	percent, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, false
+	}
+	return percent, true
+}
+
+// dedupe removes repeated patterns, keeping their order
+func dedupe(patterns []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, pattern := range patterns {
+		if !seen[pattern] {
+			seen[pattern] = true
+			unique = append(unique, pattern)
+		}
+	}
+	return unique
+}
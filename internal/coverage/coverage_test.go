@@ -0,0 +1,105 @@
+package coverage
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	output := "ok  \texample.com/shop/cart\t0.003s\tcoverage: 81.5% of statements\n" +
+		"\texample.com/shop/tax\t\tcoverage: 0.0% of statements\n" +
+		"?   \texample.com/shop/legacy\t[no test files]\n" +
+		"ok  \texample.com/shop/consts\t0.001s\tcoverage: [no statements]\n" +
+		"--- FAIL: TestTotal (0.00s)\n" +
+		"    cart_test.go:9: got 3, want 4\n" +
+		"FAIL\n" +
+		"coverage: 42.0% of statements\n" +
+		"FAIL\texample.com/shop/money\t0.004s\n" +
+		"FAIL\texample.com/shop/broken [build failed]\n" +
+		"FAIL\n"
+
+	want := []Result{
+		{Package: "example.com/shop/cart", Percent: 81.5},
+		{Package: "example.com/shop/tax"},
+		{Package: "example.com/shop/legacy"},
+		{Package: "example.com/shop/consts", NoStatements: true},
+		{Package: "example.com/shop/money", Percent: 42, Failed: true},
+		{Package: "example.com/shop/broken", Failed: true},
+	}
+	if got := Parse(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestCompareAndBelow(t *testing.T) {
+	before := []Result{{Package: "a", Percent: 40}, {Package: "b", Percent: 90}}
+	after := []Result{
+		{Package: "a", Percent: 75},
+		{Package: "b", Percent: 90},
+		{Package: "c", Percent: 10},
+		{Package: "d", NoStatements: true},
+	}
+
+	changes := Compare(before, after)
+	want := []Change{
+		{Package: "a", Before: 40, After: 75},
+		{Package: "b", Before: 90, After: 90},
+		{Package: "c", After: 10},
+		{Package: "d", NoStatements: true},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Fatalf("Compare() = %+v, want %+v", changes, want)
+	}
+
+	var below []string
+	for _, change := range Below(changes, 80) {
+		below = append(below, change.Package)
+	}
+	if !reflect.DeepEqual(below, []string{"a", "c"}) {
+		t.Errorf("Below(80) = %v, want [a c]", below)
+	}
+}
+
+func TestMeasure(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not available")
+	}
+
+	root := t.TempDir()
+	files := map[string]string{
+		"go.mod":            "module example.com/shop\n\ngo 1.21\n",
+		"cart/cart.go":      "package cart\n\nfunc Total(n int) int {\n\tif n < 0 {\n\t\treturn 0\n\t}\n\treturn n\n}\n",
+		"cart/cart_test.go": "package cart\n\nimport \"testing\"\n\nfunc TestTotal(t *testing.T) {\n\tif Total(2) != 2 {\n\t\tt.Fail()\n\t}\n}\n",
+		"tax/tax.go":        "package tax\n\nfunc Rate() float64 { return 0.2 }\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := Measure(context.Background(), []string{filepath.Join(root, "tax"), filepath.Join(root, "cart"), filepath.Join(root, "cart")})
+	if err != nil {
+		t.Fatalf("Measure failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 packages, got %+v", results)
+	}
+	if cart := results[0]; cart.Package != "example.com/shop/cart" || cart.Percent <= 0 || cart.Percent >= 100 || cart.Failed {
+		t.Errorf("Unexpected cart coverage: %+v", cart)
+	}
+	if tax := results[1]; tax.Package != "example.com/shop/tax" || tax.Percent != 0 || tax.Failed {
+		t.Errorf("Unexpected tax coverage: %+v", tax)
+	}
+	if _, err := os.Stat(filepath.Join(root, "go.sum")); !os.IsNotExist(err) {
+		t.Error("Expected Measure to leave the module files alone")
+	}
+}
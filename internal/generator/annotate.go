@@ -0,0 +1,73 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	goparser "go/parser"
+	"go/scanner"
+	"go/token"
+
+	"github.com/Eranmonnie/testgen/internal/report"
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// Annotations lists the findings about an AI response worth showing on the
+// lines they concern: tests that aren't valid Go, tests quarantined or at
+// risk of being flaky, tests matching no function, and functions left
+// without a test. Findings about a test sit on its function's declaration.
+func (tg *TestGenerator) Annotations(functions []models.FunctionInfo, response *models.TestGenerationResponse) []report.Annotation {
+	var annotations []report.Annotation
+	tested := make([]bool, len(functions))
+	for _, test := range response.Tests {
+		i, _ := resolveTarget(functions, test)
+		at := func(severity, title, message string) report.Annotation {
+			annotation := report.Annotation{Severity: severity, Title: title, Message: message}
+			switch {
+			case i >= 0:
+				annotation.File, annotation.Line = functions[i].SourcePath(), functions[i].StartLine
+			case len(functions) > 0:
+				annotation.File = functions[0].SourcePath()
+			}
+			return annotation
+		}
+
+		// Positions in the snippet mean nothing on the source line
+		if _, err := goparser.ParseFile(token.NewFileSet(), "", snippetPackageHeader+test.Code, goparser.SkipObjectResolution); err != nil {
+			var errs scanner.ErrorList
+			if errors.As(err, &errs) && len(errs) > 0 {
+				err = errors.New(errs[0].Msg)
+			}
+			annotations = append(annotations, at(report.SeverityError, "testgen: invalid test",
+				fmt.Sprintf("%s isn't valid Go and can't be written: %v", test.Name, err)))
+			continue
+		}
+		if i < 0 {
+			annotations = append(annotations, at(report.SeverityWarning, "testgen: unmatched test",
+				fmt.Sprintf("%s doesn't exercise any target function and isn't written", test.Name)))
+			continue
+		}
+		tested[i] = true
+
+		switch risks := flakyRisksIn(test.Code); {
+		case test.QuarantineReason != "":
+			annotations = append(annotations, at(report.SeverityWarning, "testgen: test quarantined",
+				fmt.Sprintf("%s quarantined for review: %s", test.Name, test.QuarantineReason)))
+		case len(risks) > 0:
+			annotations = append(annotations, at(report.SeverityWarning, "testgen: flaky test",
+				fmt.Sprintf("%s: %s", test.Name, describeFlakyRisks(risks))))
+		}
+	}
+
+	for i, fn := range functions {
+		if !tested[i] {
+			annotations = append(annotations, report.Annotation{
+				Severity: report.SeverityWarning,
+				File:     fn.SourcePath(),
+				Line:     fn.StartLine,
+				Title:    "testgen: untested function",
+				Message:  fmt.Sprintf("no test was generated for %s", targetName(fn)),
+			})
+		}
+	}
+	return annotations
+}
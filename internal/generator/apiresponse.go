@@ -0,0 +1,79 @@
+package generator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// maxExcerptLength is how much of a response body errors quote
+const maxExcerptLength = 300
+
+// excerptReadBytes is how much of a response that is only quoted gets read,
+// enough for an excerpt once whitespace is collapsed
+const excerptReadBytes = 4 * maxExcerptLength
+
+// readResponse reads resp's body up to one byte past limit, so a longer
+// body can be told from one at the limit, failing when reading it
+// outlasts ai.read_timeout; cancelRead cancels the request to stop a read.
+// Like doAPIRequest, it reports whether another attempt could succeed.
+func (tg *TestGenerator) readResponse(ctx context.Context, cancelRead context.CancelFunc, resp *http.Response, limit int64) ([]byte, bool, error) {
+	var readTimedOut atomic.Bool
+	if tg.readTimeout > 0 {
+		timer := time.AfterFunc(tg.readTimeout, func() {
+			readTimedOut.Store(true)
+			cancelRead()
+		})
+		defer timer.Stop()
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil && readTimedOut.Load() {
+		return nil, true, fmt.Errorf("failed to read response: timed out after %s (ai.read_timeout): %w", tg.readTimeout, err)
+	}
+	if err != nil {
+		return nil, tg.requestTimedOut(ctx), fmt.Errorf("failed to read response: %w", tg.timeoutError(ctx, err))
+	}
+	return body, false, nil
+}
+
+// responseMediaType returns the media type of resp's Content-Type, e.g.
+// "text/html", or "" when it has none
+func responseMediaType(resp *http.Response) string {
+	header := resp.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(header))
+	}
+	return mediaType
+}
+
+// isJSONMediaType reports whether a response of mediaType can be JSON:
+// application/json, a +json type, or no type at all, which some local
+// servers send
+func isJSONMediaType(mediaType string) bool {
+	return mediaType == "" || mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// bodyExcerpt quotes the start of a response body, read up to
+// excerptReadBytes, for an error message: whitespace collapsed and cut to
+// maxExcerptLength, so an HTML page doesn't flood the terminal
+func bodyExcerpt(body []byte) string {
+	excerpt := string(bytes.Join(bytes.Fields(body[:min(len(body), excerptReadBytes)]), []byte(" ")))
+	if len(body) <= excerptReadBytes && len(excerpt) <= maxExcerptLength {
+		return excerpt
+	}
+
+	cut := min(len(excerpt), maxExcerptLength)
+	for cut > 0 && cut < len(excerpt) && !utf8.RuneStart(excerpt[cut]) {
+		cut--
+	}
+	return excerpt[:cut] + "..."
+}
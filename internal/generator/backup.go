@@ -0,0 +1,62 @@
+package generator
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Eranmonnie/testgen/internal/git"
+	"github.com/Eranmonnie/testgen/internal/report"
+)
+
+// backupSuffix is appended to a file's path for its backup
+const backupSuffix = ".backup"
+
+// gitCommitted reports whether git can restore a file; a variable so tests
+// can replace it
+var gitCommitted = git.Committed
+
+// preserveExisting makes sure the file about to be overwritten at path can be
+// recovered: from a backup when output.backup_existing is set, otherwise
+// from git. A file git can't restore (untracked or with uncommitted changes)
+// is backed up even with backups disabled.
+func (tg *TestGenerator) preserveExisting(path string) error {
+	if tg.config.Output.BackupExisting {
+		return tg.backupFile(path)
+	}
+	if _, err := os.Stat(path); err != nil || gitCommitted(path) {
+		return nil
+	}
+	report.Warnf("%s has changes git can't restore, backing it up despite backups being disabled\n", path)
+	return tg.backupFile(path)
+}
+
+// FindBackups lists the backups of Go files that earlier runs left under
+// root, skipping hidden directories and vendor
+func FindBackups(root string) ([]string, error) {
+	var backups []string
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			name := entry.Name()
+			if path != root && (strings.HasPrefix(name, ".") || name == "vendor") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), ".go"+backupSuffix) {
+			backups = append(backups, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for backups: %w", err)
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
@@ -0,0 +1,133 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	goparser "go/parser"
+	"go/token"
+	"strings"
+
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// bodyEdgeLines is how many lines are kept from each end of a summarized body
+const bodyEdgeLines = 5
+
+// summarizeBody shortens a function body longer than maxLines to its first
+// and last few lines with an outline of the control flow in between, marked
+// as elided. It returns the body unchanged (and false) when it fits or
+// maxLines is 0.
+func summarizeBody(body string, maxLines int) (string, bool) {
+	return elideBody(body, maxLines, "body exceeds ai.max_body_lines")
+}
+
+// skeletonBodyLines is how long a body skeletonized to fit
+// ai.max_prompt_tokens may be
+const skeletonBodyLines = 12
+
+// skeletonizeBody cuts a body down to its edges and control flow outline,
+// the last step before dropping it, when a prompt must shrink
+func skeletonizeBody(body string) (string, bool) {
+	return elideBody(body, skeletonBodyLines, "trimmed to fit ai.max_prompt_tokens")
+}
+
+// elideBody implements summarizeBody and skeletonizeBody, giving reason in
+// the elision marker
+func elideBody(body string, maxLines int, reason string) (string, bool) {
+	lines := strings.Split(strings.TrimRight(body, "\n"), "\n")
+	if maxLines <= 0 || len(lines) <= maxLines {
+		return body, false
+	}
+
+	// Keep the edges small enough that the summary stays within the limit
+	edge := bodyEdgeLines
+	if maxLines/4 < edge {
+		edge = maxLines / 4
+	}
+	if edge < 1 {
+		edge = 1
+	}
+	head, tail := lines[:edge], lines[len(lines)-edge:]
+	elidedFrom, elidedTo := edge+1, len(lines)-edge // 1-based, inclusive
+
+	outline := controlFlowLines(body, lines, elidedFrom, elidedTo)
+	if budget := maxLines - 2*edge - 2; len(outline) > budget {
+		if budget < 0 {
+			budget = 0
+		}
+		outline = append(outline[:budget:budget], "\t// ...")
+	}
+
+	var summary strings.Builder
+	for _, line := range head {
+		summary.WriteString(line + "\n")
+	}
+	summary.WriteString(fmt.Sprintf("\t// ... %d lines elided (%s); control flow:\n", elidedTo-elidedFrom+1, reason))
+	for _, line := range outline {
+		summary.WriteString(line + "\n")
+	}
+	for _, line := range tail {
+		summary.WriteString(line + "\n")
+	}
+
+	return summary.String(), true
+}
+
+// controlFlowLines returns the header lines of the branches, loops, returns
+// and goroutines starting within lines from..to of body, commented out and
+// keeping their indentation so nesting stays visible
+func controlFlowLines(body string, lines []string, from, to int) []string {
+	// Parse the body as a function so statement positions map back to its lines
+	const header = snippetPackageHeader + "func _() "
+	fset := token.NewFileSet()
+	file, err := goparser.ParseFile(fset, "", header+body, 0)
+	if err != nil {
+		return nil
+	}
+	offset := strings.Count(header, "\n")
+
+	seen := make(map[int]bool)
+	var starts []int
+	mark := func(pos token.Pos) {
+		line := fset.Position(pos).Line - offset
+		if line >= from && line <= to && !seen[line] {
+			seen[line] = true
+			starts = append(starts, line)
+		}
+	}
+
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch stmt := n.(type) {
+		case *ast.IfStmt:
+			mark(stmt.Pos())
+			if stmt.Else != nil {
+				mark(stmt.Else.Pos())
+			}
+		case *ast.ForStmt, *ast.RangeStmt, *ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.SelectStmt,
+			*ast.CaseClause, *ast.CommClause, *ast.ReturnStmt, *ast.BranchStmt, *ast.GoStmt, *ast.DeferStmt:
+			mark(stmt.Pos())
+		}
+		return true
+	})
+
+	var outline []string
+	for _, line := range starts {
+		text := lines[line-1]
+		trimmed := strings.TrimLeft(text, "\t")
+		outline = append(outline, text[:len(text)-len(trimmed)]+"// "+trimmed)
+	}
+	return outline
+}
+
+// summarizedBodyWarnings lists the functions whose bodies were summarized in the prompt
+func (tg *TestGenerator) summarizedBodyWarnings(functions []models.FunctionInfo) []string {
+	var warnings []string
+	for _, fn := range functions {
+		if _, summarized := summarizeBody(fn.Body, tg.config.AI.MaxBodyLines); summarized {
+			lines := strings.Count(strings.TrimRight(fn.Body, "\n"), "\n") + 1
+			warnings = append(warnings, fmt.Sprintf("body of %s has %d lines and was summarized in the prompt (ai.max_body_lines is %d)",
+				fn.Name, lines, tg.config.AI.MaxBodyLines))
+		}
+	}
+	return warnings
+}
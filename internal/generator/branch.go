@@ -0,0 +1,153 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Eranmonnie/testgen/internal/git"
+	"github.com/Eranmonnie/testgen/internal/report"
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// BranchCommit is what a --to-branch run committed
+type BranchCommit struct {
+	Branch string
+	RunID  string
+	Commit string
+	Files  []string // repository-relative paths
+}
+
+// CheckBranch refuses a branch name git doesn't accept, or an existing
+// branch unless force is set, so a run fails before any AI call
+func CheckBranch(branch string, force bool) error {
+	if err := git.CheckBranchName(branch); err != nil {
+		return err
+	}
+	if git.BranchExists(branch) && !force {
+		return fmt.Errorf("branch %s already exists; use --force-branch to replace it", branch)
+	}
+	return nil
+}
+
+// CommitToBranch renders the test files for tests, paired with functions by
+// position as in WriteTestFiles, and commits
+// them on top of HEAD to branch, through a temporary worktree, so the
+// working tree is left untouched. Files are rendered against the working
+// tree (merging into its test files as configured). Nothing is committed
+// unless every file renders, and the branch only moves once the commit is
+// made.
+func (tg *TestGenerator) CommitToBranch(branch string, force bool, functions []models.FunctionInfo, tests []models.GeneratedTest, targets []string) (*BranchCommit, error) {
+	files, warnings, err := tg.RenderTestFiles(MatchTestsToFunctions(functions, tests))
+	for _, warning := range warnings {
+		report.Warnf("%s\n", warning.Message)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("nothing committed: %w", err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no test files to commit")
+	}
+
+	root, err := git.TopLevel()
+	if err != nil {
+		return nil, err
+	}
+	relative, err := repoRelativePaths(root, files)
+	if err != nil {
+		return nil, err
+	}
+
+	worktree, err := os.MkdirTemp("", "testgen-worktree-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create worktree directory: %w", err)
+	}
+	if err := git.AddWorktree(worktree); err != nil {
+		os.RemoveAll(worktree)
+		return nil, err
+	}
+	defer func() {
+		if err := git.RemoveWorktree(worktree); err != nil {
+			report.Warnf("%v\n", err)
+		}
+	}()
+
+	paths := make([]string, 0, len(relative))
+	for rel := range relative {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+	for _, rel := range paths {
+		path := filepath.Join(worktree, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create directory for %s: %w", rel, err)
+		}
+		if err := os.WriteFile(path, []byte(relative[rel]), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", rel, err)
+		}
+	}
+
+	result := &BranchCommit{Branch: branch, RunID: time.Now().UTC().Format("20060102-150405"), Files: make([]string, len(paths))}
+	for i, rel := range paths {
+		result.Files[i] = filepath.ToSlash(rel)
+	}
+	if result.Commit, err = git.CommitAll(worktree, branchCommitMessage(result, targets)); err != nil {
+		return nil, err
+	}
+	if err := git.SetBranch(branch, result.Commit, force); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// repoRelativePaths keys rendered files by their path inside the
+// repository rooted at root
+func repoRelativePaths(root string, files map[string]string) (map[string]string, error) {
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+
+	relative := make(map[string]string)
+	var failures []error
+	for path, content := range files {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		// The file may not exist yet; resolve its directory instead
+		if dir, err := filepath.EvalSymlinks(filepath.Dir(abs)); err == nil {
+			abs = filepath.Join(dir, filepath.Base(abs))
+		}
+		rel, err := filepath.Rel(root, abs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			failures = append(failures, fmt.Errorf("%s is outside the repository", path))
+			continue
+		}
+		relative[rel] = content
+	}
+	if len(failures) > 0 {
+		return nil, errors.Join(failures...)
+	}
+	return relative, nil
+}
+
+// branchCommitMessage describes a --to-branch commit: a summary line, then
+// the run id, the targets and the files, one per line
+func branchCommitMessage(result *BranchCommit, targets []string) string {
+	var message strings.Builder
+	message.WriteString(fmt.Sprintf("testgen: add tests for %d functions\n\n", len(targets)))
+	message.WriteString("Run: " + result.RunID + "\n")
+	message.WriteString("\nTargets:\n")
+	for _, target := range targets {
+		message.WriteString("- " + target + "\n")
+	}
+	message.WriteString("\nFiles:\n")
+	for _, file := range result.Files {
+		message.WriteString("- " + file + "\n")
+	}
+	return message.String()
+}
@@ -0,0 +1,108 @@
+package generator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// commentWidth is the column at which generated comment blocks wrap
+const commentWidth = 100
+
+// renderTestComment renders the comment block placed above a generated test.
+// The "minimal" style emits only the description; "full" adds the target
+// signature, covered scenarios and per-test confidence.
+func renderTestComment(style string, test models.GeneratedTest, fn *models.FunctionInfo) string {
+	var comment strings.Builder
+
+	if test.Description != "" {
+		comment.WriteString(wrapComment("// ", "// ", test.Description))
+	}
+
+	if style != "full" {
+		return comment.String()
+	}
+
+	var details strings.Builder
+	if fn != nil && fn.Signature != "" {
+		details.WriteString(wrapComment("// Target: ", "//   ", fn.Signature))
+	}
+	if len(test.Coverage) > 0 {
+		details.WriteString("// Covers:\n")
+		for _, scenario := range test.Coverage {
+			details.WriteString(wrapComment("//   - ", "//     ", scenario))
+		}
+	}
+	if test.Confidence > 0 {
+		details.WriteString(fmt.Sprintf("// Confidence: %.2f\n", test.Confidence))
+	}
+
+	if details.Len() > 0 {
+		if comment.Len() > 0 {
+			comment.WriteString("//\n")
+		}
+		comment.WriteString(details.String())
+	}
+
+	return comment.String()
+}
+
+// wrapComment word-wraps text into comment lines no wider than commentWidth.
+// The first line starts with prefix and continuation lines with indent.
+func wrapComment(prefix, indent, text string) string {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return ""
+	}
+
+	var out strings.Builder
+	line := prefix + words[0]
+
+	for _, word := range words[1:] {
+		if len(line)+1+len(word) > commentWidth {
+			out.WriteString(line + "\n")
+			line = indent + word
+			continue
+		}
+		line += " " + word
+	}
+	out.WriteString(line + "\n")
+
+	return out.String()
+}
+
+// parseTestComment reads a comment block renderTestComment wrote back into
+// the description, covered scenarios and confidence it was rendered from.
+// The target signature is left out; it's rendered from the function as it
+// is now.
+func parseTestComment(block string) models.GeneratedTest {
+	var test models.GeneratedTest
+	var description []string
+	section := "description"
+	for _, line := range strings.Split(strings.TrimSuffix(block, "\n"), "\n") {
+		text, ok := strings.CutPrefix(strings.TrimSpace(line), "//")
+		if !ok {
+			continue
+		}
+		switch trimmed := strings.TrimSpace(text); {
+		case trimmed == "":
+			section = "details"
+		case strings.HasPrefix(text, " Target: "):
+			section = "target"
+		case text == " Covers:":
+			section = "covers"
+		case strings.HasPrefix(text, " Confidence: "):
+			test.Confidence, _ = strconv.ParseFloat(strings.TrimPrefix(text, " Confidence: "), 64)
+		case section == "covers" && strings.HasPrefix(text, "   - "):
+			test.Coverage = append(test.Coverage, strings.TrimPrefix(text, "   - "))
+		case section == "covers" && strings.HasPrefix(text, "     ") && len(test.Coverage) > 0:
+			test.Coverage[len(test.Coverage)-1] += " " + trimmed
+		case section == "description":
+			description = append(description, trimmed)
+		}
+	}
+	test.Description = strings.Join(description, " ")
+	return test
+}
@@ -0,0 +1,116 @@
+package generator
+
+import (
+	goparser "go/parser"
+	"go/token"
+	"strings"
+	"time"
+
+	"github.com/Eranmonnie/testgen/internal/report"
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// ModelRun is one model's answer to a batch's prompt
+type ModelRun struct {
+	Model    string
+	Response *models.TestGenerationResponse // validated; nil when Err is set
+	Latency  time.Duration
+	Err      error
+}
+
+// GenerateCompared generates tests for request as GenerateTests does, then
+// sends the same rendered prompt to model as well (--ab-compare). It
+// returns the configured model's response and both runs, primary first.
+// The comparison model failing doesn't fail the batch; its run records why.
+func (tg *TestGenerator) GenerateCompared(request models.TestGenerationRequest, model string) (*models.TestGenerationResponse, []ModelRun, error) {
+	prompt, reductions := tg.fitPrompt(request)
+	tg.reportPrompt(prompt, reductions)
+
+	primary := tg.timedRespond(request, prompt)
+	if primary.Err != nil {
+		return nil, nil, primary.Err
+	}
+	comparison := tg.withModel(model).timedRespond(request, prompt)
+	if comparison.Err != nil {
+		report.Warnf("comparison model %s failed: %v\n", model, comparison.Err)
+	}
+	return primary.Response, []ModelRun{primary, comparison}, nil
+}
+
+// timedRespond responds to a rendered prompt, timing it
+func (tg *TestGenerator) timedRespond(request models.TestGenerationRequest, prompt string) ModelRun {
+	start := time.Now()
+	response, err := tg.respond(request, prompt)
+	return ModelRun{Model: tg.config.AI.Model, Response: response, Latency: time.Since(start), Err: err}
+}
+
+// withModel returns a generator like tg that asks model instead
+func (tg *TestGenerator) withModel(model string) *TestGenerator {
+	cfg := *tg.config
+	cfg.AI.Model = model
+	other := *tg
+	other.config = &cfg
+	return &other
+}
+
+// CompareRuns breaks the runs of one batch down per function, in the
+// batch's order, for the --ab-compare report
+func CompareRuns(functions []models.FunctionInfo, runs []ModelRun) []report.ModelComparison {
+	var comparisons []report.ModelComparison
+	for i := range functions {
+		for _, run := range runs {
+			comparisons = append(comparisons, compareRun(functions, i, run))
+		}
+	}
+	return comparisons
+}
+
+// compareRun is how run did on functions[i]. The first function takes the
+// tokens left over from splitting them, so totals add up.
+func compareRun(functions []models.FunctionInfo, i int, run ModelRun) report.ModelComparison {
+	share := len(functions)
+	comparison := report.ModelComparison{
+		Function: targetName(functions[i]),
+		Model:    run.Model,
+		Latency:  run.Latency / time.Duration(share),
+	}
+	if run.Err != nil {
+		comparison.Error = run.Err.Error()
+		return comparison
+	}
+	comparison.Tokens = run.Response.TokensUsed / share
+	if i == 0 {
+		comparison.Tokens += run.Response.TokensUsed % share
+	}
+
+	var confidence float64
+	for _, test := range run.Response.Tests {
+		if target := testTarget(functions, test); target == nil || targetName(*target) != comparison.Function {
+			continue
+		}
+		comparison.Tests++
+		if test.QuarantineReason == "" && isValidTest(test) {
+			comparison.Valid++
+		}
+		for _, warning := range run.Response.Warnings {
+			if strings.Contains(warning, test.Name) {
+				comparison.Findings++
+			}
+		}
+		if test.Confidence > 0 {
+			confidence += test.Confidence
+		} else {
+			confidence += run.Response.Confidence
+		}
+	}
+	if comparison.Tests > 0 {
+		comparison.Confidence = confidence / float64(comparison.Tests)
+	}
+	return comparison
+}
+
+// isValidTest reports whether a test's code is valid Go
+func isValidTest(test models.GeneratedTest) bool {
+	_, err := goparser.ParseFile(token.NewFileSet(), "", snippetPackageHeader+test.Code, goparser.SkipObjectResolution)
+	return err == nil
+}
@@ -0,0 +1,109 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Eranmonnie/testgen/internal/report"
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// LowConfidenceThreshold is the confidence below which a generated test is
+// flagged for closer review
+const LowConfidenceThreshold = 0.6
+
+// confidenceBuckets is the number of equal-width histogram buckets over 0-1
+const confidenceBuckets = 5
+
+// ConfidenceBucket counts tests whose confidence falls in [Min, Max)
+// (the last bucket includes 1.0)
+type ConfidenceBucket struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Count int     `json:"count"`
+}
+
+// LowConfidenceTest is a generated test below LowConfidenceThreshold
+type LowConfidenceTest struct {
+	Name       string  `json:"name"`
+	Confidence float64 `json:"confidence"`
+}
+
+// ConfidenceSummary is the distribution of AI confidence across a run
+type ConfidenceSummary struct {
+	Tests         int                 `json:"tests"`
+	Mean          float64             `json:"mean"`
+	Buckets       []ConfidenceBucket  `json:"buckets"`
+	LowConfidence []LowConfidenceTest `json:"low_confidence,omitempty"`
+}
+
+// SummarizeConfidence builds the confidence distribution of every test in
+// responses. A test without its own score takes its response's confidence;
+// tests with neither are left out.
+func SummarizeConfidence(responses []*models.TestGenerationResponse) ConfidenceSummary {
+	summary := ConfidenceSummary{Buckets: make([]ConfidenceBucket, confidenceBuckets)}
+	for i := range summary.Buckets {
+		summary.Buckets[i].Min = float64(i) / confidenceBuckets
+		summary.Buckets[i].Max = float64(i+1) / confidenceBuckets
+	}
+
+	var total float64
+	for _, response := range responses {
+		if response == nil {
+			continue
+		}
+		for _, test := range response.Tests {
+			confidence := test.Confidence
+			if confidence <= 0 {
+				confidence = response.Confidence
+			}
+			if confidence <= 0 {
+				continue
+			}
+			if confidence > 1 {
+				confidence = 1
+			}
+
+			bucket := int(confidence * confidenceBuckets)
+			if bucket == confidenceBuckets {
+				bucket--
+			}
+			summary.Buckets[bucket].Count++
+			summary.Tests++
+			total += confidence
+
+			if confidence < LowConfidenceThreshold {
+				summary.LowConfidence = append(summary.LowConfidence, LowConfidenceTest{Name: test.Name, Confidence: confidence})
+			}
+		}
+	}
+
+	if summary.Tests > 0 {
+		summary.Mean = total / float64(summary.Tests)
+	}
+	return summary
+}
+
+// PrintConfidenceSummary prints the confidence histogram and the tests to
+// review first. It is verbose-only detail.
+func PrintConfidenceSummary(summary ConfidenceSummary) {
+	if summary.Tests == 0 {
+		return
+	}
+
+	report.Verbosef("Confidence distribution (%d tests, mean %.2f):\n", summary.Tests, summary.Mean)
+	for _, bucket := range summary.Buckets {
+		bar := ""
+		if bucket.Count > 0 {
+			bar = fmt.Sprintf(" %s %d", strings.Repeat("#", bucket.Count), bucket.Count)
+		}
+		report.Verbosef("  %.1f-%.1f |%s\n", bucket.Min, bucket.Max, bar)
+	}
+
+	if len(summary.LowConfidence) > 0 {
+		report.Verbosef("Low confidence (below %.2f), review these first:\n", LowConfidenceThreshold)
+		for _, test := range summary.LowConfidence {
+			report.Verbosef("  - %s (%.2f)\n", test.Name, test.Confidence)
+		}
+	}
+}
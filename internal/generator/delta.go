@@ -0,0 +1,375 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	goparser "go/parser"
+	"go/token"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Eranmonnie/testgen/internal/parser"
+	"github.com/Eranmonnie/testgen/internal/report"
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// targetMarkerPrefix tags each generated test with the function it targets
+const targetMarkerPrefix = "//testgen:target "
+
+// errNoTestTable is returned when a test has no table that entries can be added to
+var errNoTestTable = errors.New("test has no recognizable test table")
+
+// targetMarker returns the marker line placed above a generated test
+func targetMarker(functionName string) string {
+	return targetMarkerPrefix + functionName
+}
+
+// ExistingTest is a marker-tracked generated test found in an existing test file
+type ExistingTest struct {
+	Name    string // test function name
+	Target  string // function the test was generated for
+	Code    string // source of the test function
+	Doc     string // source of its doc comment, marker included
+	Comment string // the part of Doc above the marker, as renderTestComment wrote it
+}
+
+// DeltaTarget pairs a changed function with the existing generated test to extend
+type DeltaTarget struct {
+	Function models.FunctionInfo
+	TestFile string
+	Existing ExistingTest
+}
+
+// findMarkedTests returns the marker-tracked tests in a test file
+func findMarkedTests(filePath string) ([]ExistingTest, error) {
+	content, err := parser.ReadSource(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	fset := token.NewFileSet()
+	file, err := goparser.ParseFile(fset, filePath, content, goparser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filePath, err)
+	}
+
+	var tests []ExistingTest
+	for _, decl := range file.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Doc == nil {
+			continue
+		}
+
+		for _, comment := range funcDecl.Doc.List {
+			if !strings.HasPrefix(comment.Text, targetMarkerPrefix) {
+				continue
+			}
+			docStart := fset.Position(funcDecl.Doc.Pos()).Offset
+			markerStart := fset.Position(comment.Pos()).Offset
+			start := fset.Position(funcDecl.Pos()).Offset
+			end := fset.Position(funcDecl.End()).Offset
+			tests = append(tests, ExistingTest{
+				Name:    funcDecl.Name.Name,
+				Target:  strings.TrimSpace(strings.TrimPrefix(comment.Text, targetMarkerPrefix)),
+				Code:    string(content[start:end]),
+				Doc:     string(content[docStart:start]),
+				Comment: string(content[docStart:markerStart]),
+			})
+		}
+	}
+
+	return tests, nil
+}
+
+// PlanDeltas splits targets into functions whose existing generated test can be
+// extended in place and functions that need full generation
+func (tg *TestGenerator) PlanDeltas(functions []models.FunctionInfo) ([]DeltaTarget, []models.FunctionInfo) {
+	if !tg.config.Output.DeltaRegeneration {
+		return nil, functions
+	}
+
+	var deltas []DeltaTarget
+	var full []models.FunctionInfo
+
+	for _, fn := range functions {
+		if delta, ok := tg.planDelta(fn); ok {
+			deltas = append(deltas, delta)
+		} else {
+			full = append(full, fn)
+		}
+	}
+
+	return deltas, full
+}
+
+// planDelta finds a table-driven generated test for fn that can be extended
+func (tg *TestGenerator) planDelta(fn models.FunctionInfo) (DeltaTarget, bool) {
+	// Without a change diff there's nothing to focus the extension on
+	if fn.ChangeDiff == "" {
+		return DeltaTarget{}, false
+	}
+
+	testFile := tg.testOutputPath(fn, nil)
+	existing, err := findMarkedTests(testFile)
+	if err != nil {
+		return DeltaTarget{}, false
+	}
+
+	for _, test := range existing {
+		if test.Target != fn.Name {
+			continue
+		}
+		if _, err := insertTableEntries(test.Code, nil); err == nil {
+			return DeltaTarget{Function: fn, TestFile: testFile, Existing: test}, true
+		}
+	}
+
+	return DeltaTarget{}, false
+}
+
+// RegenerateDelta asks the AI to extend an existing generated test for the
+// change and splices the returned table entries into the test file. An error
+// means the caller should fall back to full regeneration.
+func (tg *TestGenerator) RegenerateDelta(target DeltaTarget) (*models.TestGenerationResponse, error) {
+	// New table entries are unit test cases, whatever --type the run asks for
+	response, err := tg.sendPrompt(tg.buildDeltaPrompt(target), tg.config.AI.TemperatureFor(string(models.UnitTest)))
+	if err != nil {
+		return nil, err
+	}
+
+	var additions []string
+	var update models.GeneratedTest
+	for _, test := range response.Tests {
+		if test.Name == target.Existing.Name || len(response.Tests) == 1 {
+			additions = append(additions, test.Additions...)
+			update.Coverage = append(update.Coverage, test.Coverage...)
+			update.Confidence = max(update.Confidence, test.Confidence)
+		}
+	}
+
+	if len(additions) == 0 {
+		return response, nil
+	}
+
+	if err := tg.applyDelta(target, additions, update); err != nil {
+		return nil, err
+	}
+
+	report.Infof("Extended %s with %d new cases: %s\n", target.Existing.Name, len(additions), target.TestFile)
+	return response, nil
+}
+
+// buildDeltaPrompt creates the "extend, don't rewrite" prompt for a delta target
+func (tg *TestGenerator) buildDeltaPrompt(target DeltaTarget) string {
+	var prompt strings.Builder
+
+	prompt.WriteString("Extend an existing, reviewer-approved Go test for a function that just changed. ")
+	prompt.WriteString("You must return ONLY a valid JSON object with no markdown formatting, no code blocks, and no backticks.\n\n")
+
+	prompt.WriteString("Instructions:\n")
+	prompt.WriteString("- Extend, don't rewrite: the existing test cases must stay exactly as they are\n")
+	prompt.WriteString("- Add new entries to the existing test table ONLY for behavior introduced or changed by the diff\n")
+	prompt.WriteString("- Each addition must be Go source for one table entry that can be appended to the table as-is, using the table's existing fields\n")
+	prompt.WriteString("- Do not repeat or modify existing entries\n")
+	prompt.WriteString("- " + untrustedDataNotice + "\n")
+
+	prompt.WriteString(fmt.Sprintf("Function: %s\n", target.Function.Name))
+	prompt.WriteString(fmt.Sprintf("Signature: %s\n\n", target.Function.Signature))
+
+	prompt.WriteString("Change diff:\n")
+	prompt.WriteString(fenceData("diff", target.Function.ChangeDiff, ""))
+	prompt.WriteString("\n")
+
+	prompt.WriteString(fmt.Sprintf("Existing test %s:\n", target.Existing.Name))
+	prompt.WriteString(fenceData("existing test", target.Existing.Code, ""))
+	prompt.WriteString("\n")
+
+	prompt.WriteString("IMPORTANT: Return only valid JSON in this exact format (no markdown, no code blocks, no backticks):\n")
+	prompt.WriteString(fmt.Sprintf(`{"tests":[{"name":"%s","additions":["{name: \"new case\", ...}"],"description":"what the new cases cover","coverage":["new scenario"]}],"reasoning":"why these cases","confidence":0.85,"warnings":[]}`,
+		target.Existing.Name))
+
+	return prompt.String()
+}
+
+// applyDelta inserts table entries into the marker-tracked test target
+// extends, and brings the comment block above its marker up to date with the
+// function and the scenarios update adds
+func (tg *TestGenerator) applyDelta(target DeltaTarget, additions []string, update models.GeneratedTest) error {
+	testFile, testName := target.TestFile, target.Existing.Name
+	existing, err := findMarkedTests(testFile)
+	if err != nil {
+		return err
+	}
+
+	for _, test := range existing {
+		if test.Name != testName {
+			continue
+		}
+
+		updated, err := insertTableEntries(test.Code, additions)
+		if err != nil {
+			return err
+		}
+		doc := tg.syncTestComment(test, target.Function, update) + strings.TrimPrefix(test.Doc, test.Comment)
+
+		raw, err := os.ReadFile(testFile)
+		if err != nil {
+			return fmt.Errorf("failed to read test file: %w", err)
+		}
+		content := string(parser.NormalizeSource(raw))
+		newContent := withLineEnding(strings.Replace(content, test.Doc+test.Code, doc+updated, 1), lineEnding(testFile, raw))
+
+		if err := tg.preserveExisting(testFile); err != nil {
+			return fmt.Errorf("failed to backup existing file: %w", err)
+		}
+
+		return os.WriteFile(testFile, []byte(newContent), 0644)
+	}
+
+	return fmt.Errorf("test %s not found in %s", testName, testFile)
+}
+
+// syncTestComment re-renders the comment block above an extended test's
+// marker: the function's current signature, the scenarios it covered plus
+// the new ones, and the extension's confidence when it gives one
+func (tg *TestGenerator) syncTestComment(test ExistingTest, fn models.FunctionInfo, update models.GeneratedTest) string {
+	comment := parseTestComment(test.Comment)
+	for _, scenario := range update.Coverage {
+		if !slices.Contains(comment.Coverage, scenario) {
+			comment.Coverage = append(comment.Coverage, scenario)
+		}
+	}
+	if update.Confidence > 0 {
+		comment.Confidence = update.Confidence
+	}
+	return renderTestComment(tg.config.Output.CommentStyle, comment, &fn)
+}
+
+// insertTableEntries appends entries to the table ranged over by a test
+// function and returns the formatted result. It returns errNoTestTable when
+// the test isn't table-driven.
+func insertTableEntries(code string, entries []string) (string, error) {
+	src := snippetPackageHeader + code
+
+	fset := token.NewFileSet()
+	file, err := goparser.ParseFile(fset, "", src, goparser.ParseComments)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse test code: %w", err)
+	}
+
+	var table *ast.CompositeLit
+	for _, decl := range file.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok && funcDecl.Body != nil {
+			if table = findTestTable(funcDecl.Body); table != nil {
+				break
+			}
+		}
+	}
+	if table == nil {
+		return "", errNoTestTable
+	}
+
+	// Splice the entries in before the table's closing brace
+	rbrace := fset.Position(table.Rbrace).Offset
+	before := strings.TrimRight(src[:rbrace], " \t\n")
+
+	// Break single-line tables so every entry ends up on its own line
+	if lbrace := fset.Position(table.Lbrace); len(table.Elts) > 0 && lbrace.Line == fset.Position(table.Rbrace).Line {
+		before = src[:lbrace.Offset+1] + "\n" + before[lbrace.Offset+1:]
+	}
+
+	var insertion strings.Builder
+	if !strings.HasSuffix(before, ",") && !strings.HasSuffix(before, "{") {
+		insertion.WriteString(",")
+	}
+	for _, entry := range entries {
+		entry = strings.TrimSuffix(strings.TrimSpace(entry), ",")
+		if entry == "" {
+			continue
+		}
+		insertion.WriteString("\n" + entry + ",")
+	}
+	insertion.WriteString("\n")
+
+	formatted, err := format.Source([]byte(before + insertion.String() + src[rbrace:]))
+	if err != nil {
+		return "", fmt.Errorf("new table entries are not valid Go: %w", err)
+	}
+
+	return strings.TrimPrefix(string(formatted), snippetPackageHeader), nil
+}
+
+// findTestTable returns the slice or map literal a test body ranges over,
+// either directly or through a variable
+func findTestTable(body *ast.BlockStmt) *ast.CompositeLit {
+	var table *ast.CompositeLit
+
+	ast.Inspect(body, func(n ast.Node) bool {
+		if table != nil {
+			return false
+		}
+		rangeStmt, ok := n.(*ast.RangeStmt)
+		if !ok {
+			return true
+		}
+
+		switch x := rangeStmt.X.(type) {
+		case *ast.CompositeLit:
+			if isTableType(x) {
+				table = x
+			}
+		case *ast.Ident:
+			table = findTableVariable(body, x.Name)
+		}
+		return true
+	})
+
+	return table
+}
+
+// findTableVariable finds the slice or map literal assigned to name in body
+func findTableVariable(body *ast.BlockStmt, name string) *ast.CompositeLit {
+	var table *ast.CompositeLit
+
+	ast.Inspect(body, func(n ast.Node) bool {
+		if table != nil {
+			return false
+		}
+
+		switch x := n.(type) {
+		case *ast.AssignStmt:
+			for i, lhs := range x.Lhs {
+				if ident, ok := lhs.(*ast.Ident); ok && ident.Name == name && i < len(x.Rhs) {
+					if lit, ok := x.Rhs[i].(*ast.CompositeLit); ok && isTableType(lit) {
+						table = lit
+					}
+				}
+			}
+		case *ast.ValueSpec:
+			for i, ident := range x.Names {
+				if ident.Name == name && i < len(x.Values) {
+					if lit, ok := x.Values[i].(*ast.CompositeLit); ok && isTableType(lit) {
+						table = lit
+					}
+				}
+			}
+		}
+		return true
+	})
+
+	return table
+}
+
+// isTableType reports whether a composite literal is a slice or map
+func isTableType(lit *ast.CompositeLit) bool {
+	switch t := lit.Type.(type) {
+	case *ast.ArrayType:
+		return t.Len == nil
+	case *ast.MapType:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package generator
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Eranmonnie/testgen/internal/parser"
+	"github.com/Eranmonnie/testgen/internal/report"
+	"github.com/Eranmonnie/testgen/pkg/models"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// previewContextLines is how many unchanged lines surround each hunk
+const previewContextLines = 3
+
+// TestFileDiff is what writing a run's tests would do to one file
+type TestFileDiff struct {
+	Path    string `json:"path"`
+	Created bool   `json:"created,omitempty"` // the file doesn't exist yet
+	Lines   int    `json:"lines"`             // lines of the rendered file
+	Diff    string `json:"diff,omitempty"`    // unified diff of an existing file, empty if it wouldn't change
+}
+
+// DiffTestFiles renders the test files for tests, paired with functions by
+// position as in WriteTestFiles, and compares each with the file on disk,
+// writing nothing. Existing files get a unified diff of their whole merged
+// or overwritten result, not just the additions. Files are in path order.
+func (tg *TestGenerator) DiffTestFiles(functions []models.FunctionInfo, tests []models.GeneratedTest) ([]TestFileDiff, error) {
+	files, warnings, renderErr := tg.RenderTestFiles(MatchTestsToFunctions(functions, tests))
+	for _, warning := range warnings {
+		report.Warnf("%s\n", warning.Message)
+	}
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var diffs []TestFileDiff
+	for _, path := range paths {
+		content := files[path]
+		fileDiff := TestFileDiff{Path: path, Lines: strings.Count(content, "\n")}
+		current, err := os.ReadFile(path)
+		if err != nil {
+			fileDiff.Created = true
+			diffs = append(diffs, fileDiff)
+			continue
+		}
+
+		// Compare without line endings: a CRLF file is rewritten as CRLF
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(parser.NormalizeSource(current))),
+			B:        difflib.SplitLines(string(parser.NormalizeSource([]byte(content)))),
+			FromFile: "a/" + path,
+			ToFile:   "b/" + path,
+			Context:  previewContextLines,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to diff %s: %w", path, err)
+		}
+		fileDiff.Diff = diff
+		diffs = append(diffs, fileDiff)
+	}
+	return diffs, renderErr
+}
@@ -0,0 +1,69 @@
+package generator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/Eranmonnie/testgen/internal/parser"
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// unsafeNameRegex matches runs of characters kept out of dumped prompt file names
+var unsafeNameRegex = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// DumpPrompts renders one prompt per function of request, as if each were
+// generated on its own, and writes them to dir without calling the AI. It
+// returns the written paths in function order.
+func (tg *TestGenerator) DumpPrompts(request models.TestGenerationRequest, dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create prompt directory: %w", err)
+	}
+
+	var paths []string
+	used := make(map[string]int)
+	for _, fn := range request.Functions {
+		single := request
+		single.Functions = []models.FunctionInfo{fn}
+
+		name := promptFileName(fn)
+		if used[name]++; used[name] > 1 {
+			name = fmt.Sprintf("%s-%d.prompt.txt", strings.TrimSuffix(name, ".prompt.txt"), used[name])
+		}
+
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(tg.buildPrompt(single)), 0644); err != nil {
+			return paths, fmt.Errorf("failed to write prompt for %s: %w", fn.Name, err)
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+// promptFileName names a dumped prompt after its source file and function,
+// e.g. "internal_user_user.go-User.Validate.prompt.txt"
+func promptFileName(fn models.FunctionInfo) string {
+	source := filepath.ToSlash(fn.File)
+	if filepath.IsAbs(fn.File) {
+		if wd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(wd, fn.File); err == nil && !strings.HasPrefix(rel, "..") {
+				source = filepath.ToSlash(rel)
+			}
+		}
+	}
+	source = strings.Trim(unsafeNameRegex.ReplaceAllString(strings.ReplaceAll(source, "/", "_"), "_"), "_.")
+
+	name := fn.Name
+	if fn.Receiver != nil {
+		name = parser.BaseTypeName(fn.Receiver.Type) + "." + name
+	}
+	name = unsafeNameRegex.ReplaceAllString(name, "_")
+
+	if source == "" {
+		return name + ".prompt.txt"
+	}
+	return source + "-" + name + ".prompt.txt"
+}
@@ -0,0 +1,85 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// EmittedRun is the --emit-json output: every response of a generate run,
+// with each test mapped to the function it was generated for, for tools
+// that place or review tests themselves
+type EmittedRun struct {
+	GeneratedAt time.Time         `json:"generated_at"`
+	Provider    string            `json:"provider"`
+	Model       string            `json:"model"`
+	Responses   []EmittedResponse `json:"responses"`
+}
+
+// EmittedResponse is one AI response with its tests mapped to functions
+type EmittedResponse struct {
+	Reasoning  string        `json:"reasoning"`
+	Confidence float64       `json:"confidence"`
+	Warnings   []string      `json:"warnings"`
+	Tests      []EmittedTest `json:"tests"`
+}
+
+// EmittedTest is a generated test with the function it tests and the test
+// file it would be written to. Tests whose function can't be resolved have
+// neither, as they are never written.
+type EmittedTest struct {
+	models.GeneratedTest
+	Function    *models.FunctionInfo `json:"function,omitempty"`
+	Destination string               `json:"destination,omitempty"`
+}
+
+// EmittedResponse maps a response's tests to the functions of its request,
+// resolved as in PairTests
+func (tg *TestGenerator) EmittedResponse(functions []models.FunctionInfo, response *models.TestGenerationResponse) EmittedResponse {
+	emitted := EmittedResponse{
+		Reasoning:  response.Reasoning,
+		Confidence: response.Confidence,
+		Warnings:   response.Warnings,
+		Tests:      make([]EmittedTest, 0, len(response.Tests)),
+	}
+	for _, test := range response.Tests {
+		entry := EmittedTest{GeneratedTest: test}
+		if target := testTarget(functions, test); target != nil {
+			fn := *target
+			entry.Function = &fn
+			entry.Destination = tg.testOutputPath(fn, nil)
+		}
+		emitted.Tests = append(emitted.Tests, entry)
+	}
+	return emitted
+}
+
+// Write saves the run as indented JSON to path
+func (r *EmittedRun) Write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode generated tests: %w", err)
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", path, err)
+		}
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write generated tests: %w", err)
+	}
+	return nil
+}
+
+// Tests counts the tests in the run
+func (r *EmittedRun) Tests() int {
+	count := 0
+	for _, response := range r.Responses {
+		count += len(response.Tests)
+	}
+	return count
+}
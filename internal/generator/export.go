@@ -0,0 +1,218 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	goparser "go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Eranmonnie/testgen/internal/parser"
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// exportTestFile is the shim in the package under test that gives tests in
+// the external <pkg>_test package access to unexported identifiers
+const exportTestFile = "export_test.go"
+
+// exportAliasPrefix starts every alias testgen declares in export_test.go
+const exportAliasPrefix = "ExportedForTest"
+
+// exportMarkerPrefix precedes each alias testgen declares, naming its target
+const exportMarkerPrefix = "//testgen:export "
+
+// exportAlias is an exported name for an unexported function, method or type
+type exportAlias struct {
+	Name   string // exported alias, e.g. ExportedForTestParseAmount
+	Target string // what it stands for, e.g. parseAmount or (*cache).get
+	Decl   string // declaration for export_test.go
+}
+
+// usesExportShim reports whether tests for fn are written next to it in the
+// external test package, the only case an export_test.go shim can serve
+func (tg *TestGenerator) usesExportShim(fn models.FunctionInfo) bool {
+	if !tg.config.Output.ExternalPackage || tg.config.Output.Directory != "" {
+		return false
+	}
+	return filepath.Dir(filepath.Clean(tg.config.GetTestOutputPath(fn.File))) == filepath.Dir(filepath.Clean(fn.File))
+}
+
+// exportAliases returns the aliases tests for fn need from an external test
+// package: one for an unexported function or method and one for an
+// unexported receiver type. Generic functions and receivers can't be aliased
+// without instantiating them, so they return an error saying so.
+func exportAliases(fn models.FunctionInfo) ([]exportAlias, error) {
+	if !fn.IsMethod || fn.Receiver == nil {
+		if ast.IsExported(fn.Name) {
+			return nil, nil
+		}
+		if fn.IsGeneric {
+			return nil, fmt.Errorf("%s is generic and can't be aliased in %s", fn.Name, exportTestFile)
+		}
+		return []exportAlias{valueAlias(exportAliasPrefix+upperFirst(fn.Name), fn.Name)}, nil
+	}
+
+	receiver := strings.TrimSpace(fn.Receiver.Type)
+	typeName := strings.TrimPrefix(receiver, "*")
+	if strings.Contains(typeName, "[") {
+		if ast.IsExported(fn.Name) && ast.IsExported(typeName) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%s has a generic receiver and can't be aliased in %s", fn.Name, exportTestFile)
+	}
+
+	var aliases []exportAlias
+	if !ast.IsExported(typeName) {
+		name := exportAliasPrefix + upperFirst(typeName)
+		aliases = append(aliases, exportAlias{
+			Name:   name,
+			Target: typeName,
+			Decl:   fmt.Sprintf("type %s = %s", name, typeName),
+		})
+	}
+	if !ast.IsExported(fn.Name) {
+		expression := typeName + "." + fn.Name
+		if strings.HasPrefix(receiver, "*") {
+			expression = "(*" + typeName + ")." + fn.Name
+		}
+		aliases = append(aliases, valueAlias(exportAliasPrefix+upperFirst(typeName)+upperFirst(fn.Name), expression))
+	}
+	return aliases, nil
+}
+
+// valueAlias aliases a function or method expression with a package variable
+func valueAlias(name, target string) exportAlias {
+	return exportAlias{Name: name, Target: target, Decl: fmt.Sprintf("var %s = %s", name, target)}
+}
+
+// exportGuidance tells the AI which aliases to use in place of fn's
+// unexported identifiers, or "" when it needs none
+func (tg *TestGenerator) exportGuidance(fn models.FunctionInfo) string {
+	if !tg.usesExportShim(fn) {
+		return ""
+	}
+	aliases, err := exportAliases(fn)
+	if err != nil {
+		return fmt.Sprintf("%v; test it only through the package's exported API", err)
+	}
+	if len(aliases) == 0 {
+		return ""
+	}
+
+	uses := make([]string, len(aliases))
+	for i, alias := range aliases {
+		uses[i] = fmt.Sprintf("%s.%s for %s", fn.Package, alias.Name, alias.Target)
+	}
+	return fmt.Sprintf("tests are in package %s_test, so use the aliases declared in %s: %s",
+		fn.Package, exportTestFile, strings.Join(uses, ", "))
+}
+
+// renderExportShims renders export_test.go for every directory whose tests
+// need aliases, merged into an existing export_test.go by appending only the
+// aliases it doesn't declare yet. Functions that can't be aliased are
+// reported as warnings.
+func (tg *TestGenerator) renderExportShims(matches []TestMatch) (map[string]string, []Warning, error) {
+	var paths []string
+	aliasesByPath := make(map[string][]exportAlias)
+	sourceByPath := make(map[string]models.FunctionInfo)
+	seen := make(map[string]bool)
+	var warnings []Warning
+
+	for _, match := range matches {
+		fn := match.Function
+		if match.Test.QuarantineReason != "" || !tg.usesExportShim(fn) {
+			continue
+		}
+		path := filepath.Join(filepath.Dir(filepath.Clean(fn.SourcePath())), exportTestFile)
+		aliases, err := exportAliases(fn)
+		if err == nil && len(aliases) > 0 && parser.PlatformBuildTag(fn.File) != "" {
+			err = fmt.Errorf("%s is declared in a platform-specific file and can't be aliased in %s", fn.Name, exportTestFile)
+		}
+		if err != nil {
+			warnings = append(warnings, Warning{Path: path, Message: err.Error()})
+			continue
+		}
+		for _, alias := range aliases {
+			if seen[path+"\x00"+alias.Name] {
+				continue
+			}
+			seen[path+"\x00"+alias.Name] = true
+			if _, ok := sourceByPath[path]; !ok {
+				sourceByPath[path] = fn
+				paths = append(paths, path)
+			}
+			aliasesByPath[path] = append(aliasesByPath[path], alias)
+		}
+	}
+
+	files := make(map[string]string)
+	for _, path := range paths {
+		existing, err := parser.ReadSource(path)
+		if err != nil && !os.IsNotExist(err) {
+			return files, warnings, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		source := sourceByPath[path]
+		content, err := tg.renderExportShim(path, string(existing), source, aliasesByPath[path])
+		if err != nil {
+			return files, warnings, err
+		}
+		if content != string(existing) {
+			files[path] = content
+		}
+	}
+	return files, warnings, nil
+}
+
+// renderExportShim renders the aliases into a new export_test.go, or appends
+// those not declared yet to the existing content
+func (tg *TestGenerator) renderExportShim(path, existing string, source models.FunctionInfo, aliases []exportAlias) (string, error) {
+	var content strings.Builder
+	var declared map[string]bool
+	if existing == "" {
+		content.WriteString(generatedFileHeader(source.File, false) + "\n\n")
+		content.WriteString(fmt.Sprintf("package %s\n", source.Package))
+	} else {
+		file, err := goparser.ParseFile(token.NewFileSet(), path, existing, goparser.SkipObjectResolution)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse existing %s: %w", path, err)
+		}
+		declared = topLevelNames(file)
+		content.WriteString(strings.TrimRight(existing, "\n") + "\n")
+	}
+
+	for _, alias := range aliases {
+		if declared[alias.Name] {
+			continue
+		}
+		content.WriteString("\n" + exportMarkerPrefix + alias.Target + "\n")
+		content.WriteString(alias.Decl + "\n")
+	}
+	return content.String(), nil
+}
+
+// topLevelNames lists the names a file declares at package level
+func topLevelNames(file *ast.File) map[string]bool {
+	names := make(map[string]bool)
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil {
+				names[d.Name.Name] = true
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					names[s.Name.Name] = true
+				case *ast.ValueSpec:
+					for _, name := range s.Names {
+						names[name.Name] = true
+					}
+				}
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,70 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Eranmonnie/testgen/internal/config"
+	"github.com/Eranmonnie/testgen/internal/parser"
+	"github.com/Eranmonnie/testgen/internal/report"
+)
+
+// maxFewShotChars caps the rendered ai.few_shot_examples section; examples
+// past the cap are left out of the prompt
+const maxFewShotChars = 8000
+
+// fewShotSection renders the configured example function/test pairs,
+// loading them once per generator. Examples that can no longer be found are
+// skipped with a warning.
+func (tg *TestGenerator) fewShotSection() string {
+	if tg.fewShot != nil {
+		return *tg.fewShot
+	}
+
+	var section strings.Builder
+	examples := tg.config.AI.FewShotExamples
+	for i, example := range examples {
+		rendered, err := renderFewShotExample(i+1, example)
+		if err != nil {
+			report.Warnf("Skipping few-shot example %d: %v\n", i+1, err)
+			continue
+		}
+		if section.Len()+len(rendered) > maxFewShotChars {
+			section.WriteString(fmt.Sprintf("(%d more examples left out to keep the prompt small)\n", len(examples)-i))
+			break
+		}
+		section.WriteString(rendered)
+	}
+
+	rendered := ""
+	if section.Len() > 0 {
+		rendered = "Example tests from this project (match their structure, naming and assertion style; don't copy them):\n" + section.String() + "\n"
+	}
+	tg.fewShot = &rendered
+	return rendered
+}
+
+// renderFewShotExample renders one function and the test written for it
+func renderFewShotExample(n int, example config.FewShotExample) (string, error) {
+	function, err := parser.FunctionSource(example.FunctionFile, example.FunctionName)
+	if err != nil {
+		return "", err
+	}
+	test, err := parser.FunctionSource(example.TestFile, example.TestName)
+	if err != nil {
+		return "", err
+	}
+
+	var rendered strings.Builder
+	rendered.WriteString(fmt.Sprintf("\nExample %d: %s (%s)\n", n, example.FunctionName, example.FunctionFile))
+	rendered.WriteString(fenceData("example function", function, "   "))
+	rendered.WriteString(fmt.Sprintf("   Its test %s (%s):\n", example.TestName, example.TestFile))
+	rendered.WriteString(fenceData("example test", test, "   "))
+	return rendered.String(), nil
+}
+
+// estimateTokens approximates how many tokens a prompt costs, at about four
+// characters per token
+func estimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
@@ -0,0 +1,314 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	goparser "go/parser"
+	"go/token"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Eranmonnie/testgen/internal/report"
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// Ways to handle generated tests at risk of being flaky (output.flaky_tests)
+const (
+	FlakyWarn    = "warn"    // write them with a warning
+	FlakyExclude = "exclude" // quarantine them for review (--no-flaky)
+	FlakyRepair  = "repair"  // ask the AI once to rewrite them, then warn
+)
+
+// flakyRule detects one pattern that makes tests pass or fail depending on
+// timing, the network or chance
+type flakyRule struct {
+	Name   string                        // short name, e.g. "sleep"
+	Detect func(file *ast.File) []string // what matched, e.g. "calls time.Sleep"
+	Fix    string                        // how to avoid it, for the repair prompt
+}
+
+// flakyRules are run over every generated test
+var flakyRules = []flakyRule{
+	{Name: "sleep", Detect: detectSleep, Fix: "synchronize with channels or sync.WaitGroup instead of sleeping"},
+	{Name: "network", Detect: detectRealNetwork, Fix: "serve requests from an httptest.Server and use its URL instead of real hosts"},
+	{Name: "rand", Detect: detectUnseededRand, Fix: "use fixed values or rand.New(rand.NewSource(1)) so runs are reproducible"},
+	{Name: "wall-clock", Detect: detectWallClock, Fix: "compare against fixed times or durations instead of time.Now"},
+}
+
+// flakyRisk is a flaky pattern found in a test
+type flakyRisk struct {
+	Rule  *flakyRule
+	Match string
+}
+
+// flakyRisksIn runs every flaky rule over test code
+func flakyRisksIn(code string) []flakyRisk {
+	file, err := goparser.ParseFile(token.NewFileSet(), "", snippetPackageHeader+code, 0)
+	if err != nil {
+		return nil // unparseable code fails compilation anyway
+	}
+
+	var risks []flakyRisk
+	for i := range flakyRules {
+		seen := make(map[string]bool)
+		for _, match := range flakyRules[i].Detect(file) {
+			if !seen[match] {
+				seen[match] = true
+				risks = append(risks, flakyRisk{Rule: &flakyRules[i], Match: match})
+			}
+		}
+	}
+	return risks
+}
+
+// describeFlakyRisks joins the matches of risks for a warning
+func describeFlakyRisks(risks []flakyRisk) string {
+	matches := make([]string, len(risks))
+	for i, risk := range risks {
+		matches[i] = risk.Match
+	}
+	return "flakiness risk: " + strings.Join(matches, ", ")
+}
+
+// checkFlakyTests warns about tests at risk of being flaky, or quarantines
+// them when output.flaky_tests is "exclude". Tests already quarantined are
+// left alone.
+func (tg *TestGenerator) checkFlakyTests(tests []models.GeneratedTest) []string {
+	var warnings []string
+	for i := range tests {
+		if tests[i].QuarantineReason != "" {
+			continue
+		}
+		risks := flakyRisksIn(tests[i].Code)
+		if len(risks) == 0 {
+			continue
+		}
+
+		if tg.config.Output.FlakyTests == FlakyExclude {
+			tests[i].QuarantineReason = describeFlakyRisks(risks)
+			warnings = append(warnings, fmt.Sprintf("%s quarantined for review: %s", tests[i].Name, tests[i].QuarantineReason))
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("%s: %s", tests[i].Name, describeFlakyRisks(risks)))
+	}
+	return warnings
+}
+
+// repairFlakyTests sends the tests at risk of being flaky back to the AI
+// once, with what to fix, and replaces them with the rewritten tests of the
+// same name. A failed repair keeps the original tests.
+func (tg *TestGenerator) repairFlakyTests(prompt string, temperature float64, response *models.TestGenerationResponse) {
+	var repair strings.Builder
+	flaky := 0
+	for _, test := range response.Tests {
+		risks := flakyRisksIn(test.Code)
+		if len(risks) == 0 {
+			continue
+		}
+		flaky++
+		repair.WriteString(fmt.Sprintf("\n%s (%s):\n", test.Name, describeFlakyRisks(risks)))
+		for _, risk := range risks {
+			repair.WriteString(fmt.Sprintf("- %s: %s\n", risk.Match, risk.Rule.Fix))
+		}
+		repair.WriteString(test.Code + "\n")
+	}
+	if flaky == 0 {
+		return
+	}
+
+	report.Verbosef("Asking the AI to repair %d flaky tests\n", flaky)
+	var followUp strings.Builder
+	followUp.WriteString(prompt)
+	followUp.WriteString("\n\nYou returned these tests, which are at risk of being flaky in CI:\n")
+	followUp.WriteString(repair.String())
+	followUp.WriteString("\nRewrite only these tests without those patterns, keeping their names, and return them in the same JSON format.\n")
+
+	repaired, err := tg.sendPrompt(followUp.String(), temperature)
+	if err != nil {
+		response.Warnings = append(response.Warnings, fmt.Sprintf("failed to repair flaky tests: %v", err))
+		return
+	}
+
+	byName := make(map[string]models.GeneratedTest)
+	for _, test := range repaired.Tests {
+		byName[test.Name] = test
+	}
+	for i, test := range response.Tests {
+		if fixed, ok := byName[test.Name]; ok && fixed.Code != "" {
+			response.Tests[i] = fixed
+		}
+	}
+}
+
+// packageCall returns the package and function of a call like time.Sleep(d).
+// Identifiers declared in the test itself (variables, parameters) aren't
+// packages.
+func packageCall(call *ast.CallExpr) (string, string, bool) {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return "", "", false
+	}
+	pkg, ok := sel.X.(*ast.Ident)
+	if !ok || pkg.Obj != nil {
+		return "", "", false
+	}
+	return pkg.Name, sel.Sel.Name, true
+}
+
+// inspectCalls calls visit for every call expression in file
+func inspectCalls(file *ast.File, visit func(call *ast.CallExpr)) {
+	ast.Inspect(file, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			visit(call)
+		}
+		return true
+	})
+}
+
+// detectSleep finds time.Sleep, which makes tests race the code they wait for
+func detectSleep(file *ast.File) []string {
+	var matches []string
+	inspectCalls(file, func(call *ast.CallExpr) {
+		if pkg, name, ok := packageCall(call); ok && pkg == "time" && name == "Sleep" {
+			matches = append(matches, "calls time.Sleep")
+		}
+	})
+	return matches
+}
+
+// httpCalls are the net/http functions and http.Client methods that send a
+// request to a URL argument
+var httpCalls = map[string]bool{"Get": true, "Head": true, "Post": true, "PostForm": true, "NewRequest": true, "NewRequestWithContext": true}
+
+// detectRealNetwork finds requests to literal URLs of hosts other than the
+// loopback ones an httptest.Server listens on
+func detectRealNetwork(file *ast.File) []string {
+	var matches []string
+	inspectCalls(file, func(call *ast.CallExpr) {
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || !httpCalls[sel.Sel.Name] {
+			return
+		}
+		// httptest.NewRequest and other packages' Get functions never dial
+		if pkg, _, ok := packageCall(call); ok && pkg != "http" {
+			return
+		}
+		for _, arg := range call.Args {
+			if host := literalHTTPHost(arg); host != "" && !isLoopback(host) {
+				matches = append(matches, "requests "+host)
+				return
+			}
+		}
+	})
+	return matches
+}
+
+// literalHTTPHost returns the host of an http(s) URL string literal, or ""
+func literalHTTPHost(expr ast.Expr) string {
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return ""
+	}
+	value, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return ""
+	}
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return ""
+	}
+	return parsed.Hostname()
+}
+
+// isLoopback reports whether host is the local machine
+func isLoopback(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// randConstructors are the math/rand functions that don't draw from the
+// global source
+var randConstructors = map[string]bool{"New": true, "NewSource": true, "NewPCG": true, "NewChaCha8": true, "NewZipf": true, "Seed": true}
+
+// detectUnseededRand finds calls to math/rand's global source in tests that
+// never seed it, so every run sees different values
+func detectUnseededRand(file *ast.File) []string {
+	for _, spec := range file.Imports {
+		if path, _ := strconv.Unquote(spec.Path.Value); path == "crypto/rand" && spec.Name == nil {
+			return nil // rand is crypto/rand, random by design
+		}
+	}
+
+	var matches []string
+	seeded := false
+	inspectCalls(file, func(call *ast.CallExpr) {
+		pkg, name, ok := packageCall(call)
+		if !ok || pkg != "rand" {
+			return
+		}
+		if name == "Seed" {
+			seeded = true
+		}
+		if !randConstructors[name] {
+			matches = append(matches, fmt.Sprintf("uses rand.%s without a seed", name))
+		}
+	})
+	if seeded {
+		return nil
+	}
+	return matches
+}
+
+// wallClockCalls are the time functions that read the wall clock
+var wallClockCalls = map[string]bool{"Now": true, "Since": true, "Until": true}
+
+// detectWallClock finds assertions against the wall clock: comparisons
+// with time.Now, time.Since or time.Until, and equality checks with time.Now
+func detectWallClock(file *ast.File) []string {
+	var matches []string
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.BinaryExpr:
+			switch node.Op {
+			case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+				if call := wallClockCall(node.X); call != "" {
+					matches = append(matches, "compares with "+call)
+				} else if call := wallClockCall(node.Y); call != "" {
+					matches = append(matches, "compares with "+call)
+				}
+			}
+		case *ast.CallExpr:
+			sel, ok := node.Fun.(*ast.SelectorExpr)
+			if !ok || (sel.Sel.Name != "Equal" && sel.Sel.Name != "DeepEqual") {
+				return true
+			}
+			for _, expr := range append([]ast.Expr{sel.X}, node.Args...) {
+				if call := wallClockCall(expr); call == "time.Now" {
+					matches = append(matches, "compares with time.Now")
+					break
+				}
+			}
+		}
+		return true
+	})
+	return matches
+}
+
+// wallClockCall returns the first wall clock call ("time.Now") in expr, or ""
+func wallClockCall(expr ast.Expr) string {
+	found := ""
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok && found == "" {
+			if pkg, name, ok := packageCall(call); ok && pkg == "time" && wallClockCalls[name] {
+				found = "time." + name
+			}
+		}
+		return found == ""
+	})
+	return found
+}
@@ -0,0 +1,220 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	goparser "go/parser"
+	"go/printer"
+	"go/token"
+	"strings"
+
+	"github.com/Eranmonnie/testgen/internal/parser"
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// fuzzMinGoMinor is the first Go 1.x release with testing.F
+const fuzzMinGoMinor = 18
+
+// fuzzable reports whether fn gets a fuzz test under output.fuzz_tests: it
+// takes parameters, all of types testing.F can fuzz, and isn't generic
+func fuzzable(fn models.FunctionInfo) bool {
+	if len(fn.Parameters) == 0 || fn.IsGeneric {
+		return false
+	}
+	for _, param := range fn.Parameters {
+		if !parser.FuzzableType(param.Type) {
+			return false
+		}
+	}
+	return true
+}
+
+// fuzzTestName names the fuzz test of fn: FuzzName, or FuzzType_Name for
+// methods
+func fuzzTestName(fn models.FunctionInfo) string {
+	if fn.Receiver != nil {
+		return "Fuzz" + parser.BaseTypeName(fn.Receiver.Type) + "_" + fn.Name
+	}
+	return "Fuzz" + fn.Name
+}
+
+// fuzzNote returns the prompt note asking for a fuzz test of fn, or "" when
+// output.fuzz_tests is off, fn can't be fuzzed or the module's go directive
+// predates fuzzing
+func (tg *TestGenerator) fuzzNote(fn models.FunctionInfo, goVersion string) string {
+	if !tg.config.Output.FuzzTests || !fuzzable(fn) || !parser.GoVersionAtLeast(goVersion, fuzzMinGoMinor) {
+		return ""
+	}
+	return fmt.Sprintf("also write %s(f *testing.F) in the same test entry's code, after the unit test: seed the corpus with f.Add, then call f.Fuzz with a func(t *testing.T, ...) taking this function's parameter types in order, asserting properties that hold for every input (no panic, invariants, round trips) rather than exact results.", fuzzTestName(fn))
+}
+
+// injectFuzzSeeds adds the mined seeds of each function that its fuzz test
+// left out, as f.Add calls before the test's f.Fuzz call, so the corpus
+// always covers the values the code is really called with. Fuzz tests are
+// matched to functions by name, and only get seeds when their f.Fuzz
+// callback takes the function's parameters. It returns a warning per test
+// that was given seeds.
+func injectFuzzSeeds(functions []models.FunctionInfo, tests []models.GeneratedTest) []string {
+	targets := make(map[string]models.FunctionInfo)
+	for _, fn := range functions {
+		if len(fn.FuzzSeeds) > 0 {
+			targets[fuzzTestName(fn)] = fn
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+
+	var warnings []string
+	for i := range tests {
+		var added []string
+		tests[i].Code, added = addFuzzSeeds(tests[i].Code, targets)
+		warnings = append(warnings, added...)
+	}
+	return warnings
+}
+
+// addFuzzSeeds inserts the missing seeds into the fuzz tests of code that
+// have a target, returning the new code and a warning per test given seeds
+func addFuzzSeeds(code string, targets map[string]models.FunctionInfo) (string, []string) {
+	fset := token.NewFileSet()
+	file, err := goparser.ParseFile(fset, "", snippetPackageHeader+code, 0)
+	if err != nil {
+		return code, nil // unparseable code is reported elsewhere
+	}
+
+	type insertion struct {
+		offset int
+		lines  string
+	}
+	var insertions []insertion
+	var added []string
+	for _, decl := range file.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Body == nil || funcDecl.Recv != nil {
+			continue
+		}
+		target, ok := targets[funcDecl.Name.Name]
+		f := fuzzParam(funcDecl)
+		if !ok || f == "" {
+			continue
+		}
+
+		// The f.Fuzz call at the top of the body, and the seeds added so far
+		var fuzzCall ast.Stmt
+		present := make(map[string]bool)
+		for _, stmt := range funcDecl.Body.List {
+			call := fCall(stmt, f)
+			if call == nil {
+				continue
+			}
+			switch call.Fun.(*ast.SelectorExpr).Sel.Name {
+			case "Add":
+				present[seedKey(fset, call.Args)] = true
+			case "Fuzz":
+				if fuzzCall == nil && len(call.Args) == 1 && fuzzArity(call.Args[0]) == len(target.Parameters)+1 {
+					fuzzCall = stmt
+				}
+			}
+		}
+		if fuzzCall == nil {
+			continue
+		}
+
+		offset := fset.Position(fuzzCall.Pos()).Offset - len(snippetPackageHeader)
+		lineStart := strings.LastIndex(code[:offset], "\n") + 1
+		indent := code[lineStart:offset]
+		var lines strings.Builder
+		count := 0
+		for _, seed := range target.FuzzSeeds {
+			if present[strings.Join(strings.Fields(seed), "")] {
+				continue
+			}
+			lines.WriteString(fmt.Sprintf("%s%s.Add(%s)\n", indent, f, seed))
+			count++
+		}
+		if count > 0 {
+			insertions = append(insertions, insertion{offset: lineStart, lines: lines.String()})
+			added = append(added, fmt.Sprintf("%s: added %d fuzz seeds from literal calls to %s that the model left out", funcDecl.Name.Name, count, target.Name))
+		}
+	}
+
+	// From the end, so earlier offsets stay valid
+	for i := len(insertions) - 1; i >= 0; i-- {
+		code = code[:insertions[i].offset] + insertions[i].lines + code[insertions[i].offset:]
+	}
+	return code, added
+}
+
+// fuzzParam returns the name of a fuzz test's *testing.F parameter, or ""
+// when funcDecl isn't a fuzz test
+func fuzzParam(funcDecl *ast.FuncDecl) string {
+	params := funcDecl.Type.Params.List
+	if len(params) != 1 || len(params[0].Names) != 1 {
+		return ""
+	}
+	star, ok := params[0].Type.(*ast.StarExpr)
+	if !ok {
+		return ""
+	}
+	sel, ok := star.X.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "F" {
+		return ""
+	}
+	if pkg, ok := sel.X.(*ast.Ident); !ok || pkg.Name != "testing" {
+		return ""
+	}
+	return params[0].Names[0].Name
+}
+
+// fCall returns the call of stmt when it is a method call on f
+func fCall(stmt ast.Stmt, f string) *ast.CallExpr {
+	exprStmt, ok := stmt.(*ast.ExprStmt)
+	if !ok {
+		return nil
+	}
+	call, ok := exprStmt.X.(*ast.CallExpr)
+	if !ok {
+		return nil
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return nil
+	}
+	if x, ok := sel.X.(*ast.Ident); !ok || x.Name != f {
+		return nil
+	}
+	return call
+}
+
+// fuzzArity counts the parameters of an f.Fuzz callback, or -1 when it
+// isn't a function literal
+func fuzzArity(expr ast.Expr) int {
+	lit, ok := expr.(*ast.FuncLit)
+	if !ok {
+		return -1
+	}
+	count := 0
+	for _, field := range lit.Type.Params.List {
+		if len(field.Names) == 0 {
+			count++
+		}
+		count += len(field.Names)
+	}
+	return count
+}
+
+// seedKey renders f.Add arguments without spaces, to compare them with
+// mined seeds however the model formatted them
+func seedKey(fset *token.FileSet, args []ast.Expr) string {
+	var parts []string
+	for _, arg := range args {
+		var buf bytes.Buffer
+		if err := printer.Fprint(&buf, fset, arg); err != nil {
+			return ""
+		}
+		parts = append(parts, buf.String())
+	}
+	return strings.Join(strings.Fields(strings.Join(parts, ",")), "")
+}
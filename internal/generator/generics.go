@@ -0,0 +1,151 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	goparser "go/parser"
+	"go/token"
+	"strings"
+
+	"github.com/Eranmonnie/testgen/internal/parser"
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// constraintTypeArgs are concrete types satisfying common named constraints
+var constraintTypeArgs = map[string]string{
+	"constraints.Integer":  "int",
+	"constraints.Signed":   "int",
+	"constraints.Unsigned": "uint",
+	"constraints.Float":    "float64",
+	"constraints.Complex":  "complex128",
+}
+
+// suggestTypeArg returns a concrete type satisfying constraint for the
+// type parameter at index, or "" when none can be derived from the
+// constraint expression alone. Constraints every basic type satisfies get
+// string for the first parameter and int for the next, alternating, so a
+// key/value pair reads naturally; unions get their first term.
+func suggestTypeArg(constraint string, index int) string {
+	constraint = strings.TrimSpace(constraint)
+	switch constraint {
+	case "", "any", "interface{}", "comparable", "cmp.Ordered", "constraints.Ordered":
+		if index%2 == 0 {
+			return "string"
+		}
+		return "int"
+	}
+	if typeArg, ok := constraintTypeArgs[constraint]; ok {
+		return typeArg
+	}
+
+	// A union like ~int | ~int64, or a single ~T: its first term's type,
+	// when that is a plain type name rather than another constraint
+	if strings.Contains(constraint, "|") || strings.HasPrefix(constraint, "~") {
+		first := strings.TrimPrefix(strings.TrimSpace(strings.Split(constraint, "|")[0]), "~")
+		if !strings.ContainsAny(first, ".{[ ") {
+			return first
+		}
+	}
+	return ""
+}
+
+// receiverInstantiation renders fn's generic receiver type instantiated
+// with the suggested type arguments, e.g. Cache[string, int], or "" when a
+// type argument can't be suggested
+func receiverInstantiation(fn models.FunctionInfo) string {
+	params := fn.Receiver.TypeParams
+	args := make([]string, len(params))
+	for i, param := range params {
+		if args[i] = suggestTypeArg(param.Constraint, i); args[i] == "" {
+			return ""
+		}
+	}
+	return parser.BaseTypeName(fn.Receiver.Type) + "[" + strings.Join(args, ", ") + "]"
+}
+
+// genericReceiverNote explains how to instantiate the generic receiver type
+// of a method, or returns "" when the receiver isn't generic
+func genericReceiverNote(fn models.FunctionInfo) string {
+	if fn.Receiver == nil || len(fn.Receiver.TypeParams) == 0 {
+		return ""
+	}
+	typeName := parser.BaseTypeName(fn.Receiver.Type)
+
+	var params []string
+	for _, param := range fn.Receiver.TypeParams {
+		if param.Constraint != "" {
+			params = append(params, param.Name+" "+param.Constraint)
+		} else {
+			params = append(params, param.Name)
+		}
+	}
+	note := fmt.Sprintf("%s is generic over [%s] and can't be used uninstantiated. Instantiate it with concrete type arguments satisfying the constraints", typeName, strings.Join(params, ", "))
+	if example := receiverInstantiation(fn); example != "" {
+		note += fmt.Sprintf(", e.g. %s: create it as &%s{} or through a constructor given the type arguments explicitly", example, example)
+	} else {
+		note += "; for an interface constraint, use a package type that implements it or declare a small one in the test"
+	}
+	return note + fmt.Sprintf(". %s in the signature then stand for those types.", strings.Join(typeParamNames(fn), ", "))
+}
+
+// typeParamNames lists the receiver's type parameter names
+func typeParamNames(fn models.FunctionInfo) []string {
+	names := make([]string, len(fn.Receiver.TypeParams))
+	for i, param := range fn.Receiver.TypeParams {
+		names[i] = param.Name
+	}
+	return names
+}
+
+// checkGenericInstantiations warns about generated tests of methods on
+// generic types that never instantiate the type with type arguments, which
+// can't compile
+func checkGenericInstantiations(functions []models.FunctionInfo, tests []models.GeneratedTest) []string {
+	var warnings []string
+	for _, test := range tests {
+		target := testTarget(functions, test)
+		if target == nil || target.Receiver == nil || len(target.Receiver.TypeParams) == 0 {
+			continue
+		}
+		typeName := parser.BaseTypeName(target.Receiver.Type)
+		instantiated, ok := instantiates(test.Code, typeName)
+		if !ok || instantiated {
+			continue
+		}
+		warning := fmt.Sprintf("%s never instantiates the generic type %s with type arguments", test.Name, typeName)
+		if example := receiverInstantiation(*target); example != "" {
+			warning += fmt.Sprintf(" (e.g. %s)", example)
+		}
+		warnings = append(warnings, warning)
+	}
+	return warnings
+}
+
+// instantiates reports whether code gives typeName type arguments, as
+// typeName[...], pkg.typeName[...] or through a constructor like
+// NewtypeName[...]; ok is false when code doesn't parse
+func instantiates(code, typeName string) (found, ok bool) {
+	file, err := goparser.ParseFile(token.NewFileSet(), "", snippetPackageHeader+code, 0)
+	if err != nil {
+		return false, false
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		var x ast.Expr
+		switch e := n.(type) {
+		case *ast.IndexExpr:
+			x = e.X
+		case *ast.IndexListExpr:
+			x = e.X
+		default:
+			return !found
+		}
+		switch name := x.(type) {
+		case *ast.Ident:
+			found = strings.HasSuffix(name.Name, typeName)
+		case *ast.SelectorExpr:
+			found = strings.HasSuffix(name.Sel.Name, typeName)
+		}
+		return !found
+	})
+	return found, true
+}
@@ -0,0 +1,85 @@
+package generator
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// describeChange summarizes the commit being tested for the prompt, e.g.
+// `on branch fix/validation by Jane: "handle nil user" — focus tests on the changed behavior`.
+// The author is left out when git.omit_author is set.
+func (tg *TestGenerator) describeChange(gitContext models.GitContext) string {
+	if gitContext.CommitMessage == "" {
+		return ""
+	}
+
+	var change strings.Builder
+	if branch := gitContext.Branch; branch != "" && branch != "HEAD" {
+		change.WriteString(fmt.Sprintf("on branch %s ", branch))
+	}
+	if gitContext.Author != "" && !tg.config.Git.OmitAuthor {
+		change.WriteString(fmt.Sprintf("by %s", gitContext.Author))
+	}
+
+	prefix := strings.TrimSpace(change.String())
+	if prefix != "" {
+		prefix += ": "
+	}
+
+	return fmt.Sprintf("%s%q — focus tests on the changed behavior", prefix, sanitizeData(gitContext.CommitMessage))
+}
+
+// recentCommitLines lists, for each file of request's functions in reading
+// order, the recent commits to it on one line, e.g.
+// `Recent commits to user.go: "support unicode emails" (Jane); "fix nil deref" (Sam)`,
+// newest first. Authors are left out when git.omit_author is set.
+func (tg *TestGenerator) recentCommitLines(request models.TestGenerationRequest) []string {
+	recent := request.Context.GitContext.RecentCommits
+	if len(recent) == 0 {
+		return nil
+	}
+
+	var lines []string
+	seen := make(map[string]bool)
+	for _, fn := range sourceOrder(request.Functions) {
+		commits := recent[fn.File]
+		if seen[fn.File] || len(commits) == 0 {
+			continue
+		}
+		seen[fn.File] = true
+
+		entries := make([]string, len(commits))
+		for i, commit := range commits {
+			entries[i] = fmt.Sprintf("%q", sanitizeData(commit.Subject))
+			if commit.Author != "" && !tg.config.Git.OmitAuthor {
+				entries[i] += fmt.Sprintf(" (%s)", commit.Author)
+			}
+		}
+		lines = append(lines, fmt.Sprintf("Recent commits to %s: %s", filepath.Base(fn.File), strings.Join(entries, "; ")))
+	}
+	return lines
+}
+
+// formatLineRanges renders sorted line numbers compactly ("3-5, 9")
+func formatLineRanges(lines []int) string {
+	var ranges []string
+
+	for i := 0; i < len(lines); {
+		j := i
+		for j+1 < len(lines) && lines[j+1] <= lines[j]+1 {
+			j++
+		}
+		if lines[i] == lines[j] {
+			ranges = append(ranges, strconv.Itoa(lines[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", lines[i], lines[j]))
+		}
+		i = j + 1
+	}
+
+	return strings.Join(ranges, ", ")
+}
@@ -0,0 +1,106 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	goparser "go/parser"
+	"go/token"
+
+	"github.com/Eranmonnie/testgen/internal/parser"
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// checkGoVersionFeatures flags constructs in a generated test that need a
+// newer Go version than the module declares
+func checkGoVersionFeatures(test models.GeneratedTest, declared string) []string {
+	if _, _, ok := parser.ParseGoVersion(declared); !ok {
+		return nil
+	}
+
+	fset := token.NewFileSet()
+	file, err := goparser.ParseFile(fset, "", snippetPackageHeader+test.Code, 0)
+	if err != nil {
+		return nil // unparseable code is reported elsewhere
+	}
+
+	// Functions declared in the snippet shadow builtins like min/max
+	declaredFuncs := make(map[string]bool)
+	for _, decl := range file.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil {
+			declaredFuncs[fn.Name.Name] = true
+		}
+	}
+
+	var warnings []string
+	seen := make(map[string]bool)
+	flag := func(feature string, minor int) {
+		if seen[feature] || parser.GoVersionAtLeast(declared, minor) {
+			return
+		}
+		seen[feature] = true
+		warnings = append(warnings, fmt.Sprintf("test %s uses %s which requires go 1.%d, but go.mod declares go %s",
+			test.Name, feature, minor, declared))
+	}
+
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.CallExpr:
+			switch fun := x.Fun.(type) {
+			case *ast.SelectorExpr:
+				receiver, _ := fun.X.(*ast.Ident)
+				if receiver == nil || receiver.Name == "os" {
+					break
+				}
+				switch fun.Sel.Name {
+				case "Setenv":
+					flag(receiver.Name+".Setenv", 17)
+				case "Fuzz":
+					flag(receiver.Name+".Fuzz", 18)
+				case "Chdir":
+					flag(receiver.Name+".Chdir", 24)
+				}
+			case *ast.Ident:
+				switch fun.Name {
+				case "min", "max", "clear":
+					if !declaredFuncs[fun.Name] {
+						flag("the "+fun.Name+" builtin", 21)
+					}
+				}
+			}
+		case *ast.SelectorExpr:
+			if pkg, ok := x.X.(*ast.Ident); ok && pkg.Name == "testing" && x.Sel.Name == "F" {
+				flag("testing.F", 18)
+			}
+		case *ast.RangeStmt:
+			if lit, ok := x.X.(*ast.BasicLit); ok && lit.Kind == token.INT {
+				flag("range over int", 22)
+			}
+		}
+		return true
+	})
+
+	return warnings
+}
+
+// goVersionGuidance returns prompt instructions that keep generated tests
+// within the features available in the module's declared Go version
+func goVersionGuidance(version string) []string {
+	if _, _, ok := parser.ParseGoVersion(version); !ok {
+		return nil
+	}
+
+	var guidance []string
+	if !parser.GoVersionAtLeast(version, 17) {
+		guidance = append(guidance, "Do NOT use t.Setenv (requires go 1.17); use os.Setenv and restore the value with t.Cleanup")
+	}
+	if !parser.GoVersionAtLeast(version, 18) {
+		guidance = append(guidance, "Do NOT write fuzz tests (testing.F) or use generics/type parameters (requires go 1.18)")
+	}
+	if !parser.GoVersionAtLeast(version, 21) {
+		guidance = append(guidance, "Do NOT use the min, max or clear builtins (requires go 1.21)")
+	}
+	if !parser.GoVersionAtLeast(version, 22) {
+		guidance = append(guidance, "Loop variables are shared between iterations: copy them (tt := tt) before capturing them in closures or parallel subtests")
+	}
+	return guidance
+}
@@ -0,0 +1,44 @@
+package generator
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Version is the testgen version recorded in generated file headers
+var Version = "dev"
+
+// now is the clock used for generated file headers (replaced in tests)
+var now = time.Now
+
+// generatedHeaderPrefix starts every header testgen writes, with or without DO NOT EDIT
+const generatedHeaderPrefix = "// Code generated by testgen"
+
+// generatedFileHeader builds the header line for a generated test file. With
+// doNotEdit it follows the Go convention (https://go.dev/s/generatedcode) so
+// linters and tooling treat the file as generated.
+func generatedFileHeader(sourceFile string, doNotEdit bool) string {
+	header := fmt.Sprintf("%s v%s from %s on %s.", generatedHeaderPrefix,
+		strings.TrimPrefix(Version, "v"), filepath.ToSlash(sourceFile), now().Format("2006-01-02"))
+	if doNotEdit {
+		header += " DO NOT EDIT."
+	}
+	return header
+}
+
+// IsGeneratedByTestgen reports whether file content carries a testgen header
+// before its package clause
+func IsGeneratedByTestgen(content string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, generatedHeaderPrefix) {
+			return true
+		}
+		if strings.HasPrefix(line, "package ") {
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+package generator
+
+import (
+	"go/ast"
+	"go/format"
+	goparser "go/parser"
+	"go/token"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// testEntryPrefixes start the names of the functions go test runs itself
+var testEntryPrefixes = []string{"Test", "Benchmark", "Fuzz", "Example"}
+
+// helperParamTypes are the testing types with a Helper method
+var helperParamTypes = map[string]bool{"T": true, "B": true, "F": true, "TB": true}
+
+// enforceHelperCalls makes every test helper in the generated tests, a
+// top-level function taking *testing.T, *testing.B, *testing.F or testing.TB
+// first that go test doesn't run itself, call Helper() as its first
+// statement, so failures report the caller's line. A Helper() call further
+// down the helper is moved up; missing ones are inserted.
+func enforceHelperCalls(tests []models.GeneratedTest) {
+	for i := range tests {
+		tests[i].Code = addHelperCalls(tests[i].Code)
+	}
+}
+
+// addHelperCalls rewrites the helpers of a code snippet to start with a
+// Helper() call
+func addHelperCalls(code string) string {
+	fset := token.NewFileSet()
+	file, err := goparser.ParseFile(fset, "", snippetPackageHeader+code, 0)
+	if err != nil {
+		return code // unparseable code is reported elsewhere
+	}
+	offset := func(pos token.Pos) int {
+		return fset.Position(pos).Offset - len(snippetPackageHeader)
+	}
+
+	// Edits are [start, end) ranges replaced by text, applied from the end
+	type edit struct {
+		start, end int
+		text       string
+	}
+	var edits []edit
+	for _, decl := range file.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Recv != nil || funcDecl.Body == nil || isTestEntryPoint(funcDecl.Name.Name) {
+			continue
+		}
+		t := helperParam(funcDecl)
+		if t == "" {
+			continue
+		}
+
+		body := funcDecl.Body.List
+		if len(body) > 0 && isHelperCall(body[0], t) {
+			continue
+		}
+		for _, stmt := range body[min(1, len(body)):] {
+			if isHelperCall(stmt, t) {
+				start, end := lineRange(code, offset(stmt.Pos()), offset(stmt.End()))
+				edits = append(edits, edit{start: start, end: end})
+			}
+		}
+
+		// After the brace, with whatever follows it on its line moved to the next
+		brace := offset(funcDecl.Body.Lbrace) + 1
+		next := funcDecl.Body.Rbrace
+		if len(body) > 0 {
+			next = body[0].Pos()
+		}
+		text := "\n\t" + t + ".Helper()"
+		if fset.Position(next).Line == fset.Position(funcDecl.Body.Lbrace).Line {
+			text += "\n"
+		}
+		edits = append(edits, edit{start: brace, end: brace, text: text})
+	}
+
+	if len(edits) == 0 {
+		return code
+	}
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	for _, e := range edits {
+		code = code[:e.start] + e.text + code[e.end:]
+	}
+
+	// As renames do, reformat the snippet so inserted lines are laid out
+	formatted, err := format.Source([]byte(snippetPackageHeader + code))
+	if err != nil {
+		return code
+	}
+	return strings.TrimPrefix(string(formatted), snippetPackageHeader)
+}
+
+// isTestEntryPoint reports whether go test runs a function of this name
+// itself: Test, Benchmark, Fuzz or Example, followed by nothing or a
+// character that isn't a lowercase letter
+func isTestEntryPoint(name string) bool {
+	for _, prefix := range testEntryPrefixes {
+		if rest, ok := strings.CutPrefix(name, prefix); ok {
+			r, _ := utf8.DecodeRuneInString(rest)
+			if rest == "" || !unicode.IsLower(r) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// helperParam returns the name of a function's first parameter when it is
+// a named *testing.T, *testing.B, *testing.F or testing.TB, otherwise ""
+func helperParam(funcDecl *ast.FuncDecl) string {
+	params := funcDecl.Type.Params.List
+	if len(params) == 0 || len(params[0].Names) == 0 || params[0].Names[0].Name == "_" {
+		return ""
+	}
+	typ := params[0].Type
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	sel, ok := typ.(*ast.SelectorExpr)
+	if !ok || !helperParamTypes[sel.Sel.Name] {
+		return ""
+	}
+	if pkg, ok := sel.X.(*ast.Ident); !ok || pkg.Name != "testing" {
+		return ""
+	}
+	// testing.TB is an interface; the others are only valid as pointers
+	if _, isPointer := params[0].Type.(*ast.StarExpr); isPointer == (sel.Sel.Name == "TB") {
+		return ""
+	}
+	return params[0].Names[0].Name
+}
+
+// isHelperCall reports whether stmt is t.Helper()
+func isHelperCall(stmt ast.Stmt, t string) bool {
+	exprStmt, ok := stmt.(*ast.ExprStmt)
+	if !ok {
+		return false
+	}
+	call, ok := exprStmt.X.(*ast.CallExpr)
+	if !ok || len(call.Args) != 0 {
+		return false
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "Helper" {
+		return false
+	}
+	x, ok := sel.X.(*ast.Ident)
+	return ok && x.Name == t
+}
+
+// lineRange widens [start, end) to its whole line, newline included, when
+// nothing else is on it
+func lineRange(code string, start, end int) (int, int) {
+	lineStart := strings.LastIndex(code[:start], "\n") + 1
+	lineEnd := strings.Index(code[end:], "\n")
+	if lineEnd < 0 {
+		lineEnd = len(code) - end
+	}
+	if strings.TrimSpace(code[lineStart:start]) != "" || strings.TrimSpace(code[end:end+lineEnd]) != "" {
+		return start, end
+	}
+	return lineStart, min(end+lineEnd+1, len(code))
+}
@@ -0,0 +1,248 @@
+package generator
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	goparser "go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Eranmonnie/testgen/internal/parser"
+	"github.com/Eranmonnie/testgen/internal/report"
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// HistoryFile records every test a generate run writes, one JSON object per
+// line, so testgen explain-test can trace a test back to the run that wrote it
+const HistoryFile = ".testgen/history.jsonl"
+
+// HistoryRecord is one test as a generate run wrote it
+type HistoryRecord struct {
+	RunID      string    `json:"run_id"`
+	RecordedAt time.Time `json:"recorded_at"`
+	Model      string    `json:"model"`
+	Test       string    `json:"test"`
+	TestFile   string    `json:"test_file"`
+	Function   string    `json:"function"` // "Name" or "Type.Method"
+	SourceFile string    `json:"source_file"`
+	SourceHash string    `json:"source_sha256"` // the function's signature and body when generated
+	Confidence float64   `json:"confidence,omitempty"`
+	Prompt     string    `json:"prompt"` // what the prompt said about the function
+}
+
+// TestExplanation is where an existing test came from and whether the
+// function it covers has changed since
+type TestExplanation struct {
+	Record HistoryRecord
+	Stale  string // why the test may be stale, "" while the function is unchanged
+}
+
+// HistoryRecords builds the history of tests written in run runID, paired
+// with functions by position as in WriteTestFiles. A test without its own
+// confidence takes confidence, its response's.
+func (tg *TestGenerator) HistoryRecords(runID string, context models.RequestContext, functions []models.FunctionInfo, tests []models.GeneratedTest, confidence float64) []HistoryRecord {
+	recordedAt := time.Now().UTC()
+	var records []HistoryRecord
+	for i, fn := range functions {
+		if i >= len(tests) {
+			break
+		}
+		if tests[i].QuarantineReason != "" {
+			continue
+		}
+		record := HistoryRecord{
+			RunID:      runID,
+			RecordedAt: recordedAt,
+			Model:      tg.config.AI.Model,
+			Test:       tests[i].Name,
+			TestFile:   filepath.ToSlash(rootRelativePath(fn, tg.testOutputPath(fn, nil))),
+			Function:   targetName(fn),
+			SourceFile: filepath.ToSlash(fn.File),
+			SourceHash: functionHash(fn.Signature, fn.Body),
+			Confidence: tests[i].Confidence,
+			Prompt:     tg.promptExcerpt(context, fn),
+		}
+		if record.Confidence <= 0 {
+			record.Confidence = confidence
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// promptExcerpt renders what a prompt for fn says about it: its entry in
+// the functions to test
+func (tg *TestGenerator) promptExcerpt(context models.RequestContext, fn models.FunctionInfo) string {
+	prompt := tg.buildPrompt(models.TestGenerationRequest{Functions: []models.FunctionInfo{fn}, Context: context})
+	_, excerpt, _ := strings.Cut(prompt, "\nFunctions to test:\n")
+	excerpt, _, _ = strings.Cut(excerpt, "\nGenerate tests that:\n")
+	return strings.TrimSpace(excerpt)
+}
+
+// functionHash returns the hex SHA-256 of a function's signature and body
+func functionHash(signature, body string) string {
+	sum := sha256.Sum256([]byte(signature + "\n" + body))
+	return hex.EncodeToString(sum[:])
+}
+
+// RecordHistory appends records to the history at path
+func RecordHistory(path string, records []HistoryRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	var data []byte
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to encode history: %w", err)
+		}
+		data = append(append(data, line...), '\n')
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	return file.Close()
+}
+
+// LoadHistory reads the history at path, oldest first. A missing history
+// is empty; lines that don't parse are skipped.
+func LoadHistory(path string) ([]HistoryRecord, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	defer file.Close()
+
+	var records []HistoryRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		var record HistoryRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err == nil {
+			records = append(records, record)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	return records, nil
+}
+
+// ExplainTest finds the latest run in the history at historyPath that wrote
+// testName to testFile, and checks whether the function it covers has
+// changed since, from the local checkout alone
+func ExplainTest(historyPath, testFile, testName string) (*TestExplanation, error) {
+	if err := checkTestDeclared(testFile, testName); err != nil {
+		return nil, err
+	}
+	records, err := LoadHistory(historyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var found *HistoryRecord
+	for i := range records {
+		if records[i].Test == testName && samePath(records[i].TestFile, testFile) {
+			found = &records[i]
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%s in %s isn't in %s: testgen didn't write it, or wrote it before runs were recorded", testName, testFile, historyPath)
+	}
+
+	explanation := &TestExplanation{Record: *found}
+	hash, err := currentFunctionHash(found.SourceFile, found.Function)
+	switch {
+	case err != nil:
+		explanation.Stale = fmt.Sprintf("can't read %s: %v", found.SourceFile, err)
+	case hash == "":
+		explanation.Stale = fmt.Sprintf("%s no longer exists in %s", found.Function, found.SourceFile)
+	case hash != found.SourceHash:
+		explanation.Stale = fmt.Sprintf("%s has changed since the run", found.Function)
+	}
+	return explanation, nil
+}
+
+// checkTestDeclared fails unless testFile declares the function testName
+func checkTestDeclared(testFile, testName string) error {
+	file, err := goparser.ParseFile(token.NewFileSet(), testFile, nil, goparser.SkipObjectResolution)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", testFile, err)
+	}
+	for _, decl := range file.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok && funcDecl.Recv == nil && funcDecl.Name.Name == testName {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s declares no test %s", testFile, testName)
+}
+
+// currentFunctionHash hashes function ("Name" or "Type.Method") as it is
+// now in sourceFile, or returns "" when the file no longer declares it
+func currentFunctionHash(sourceFile, function string) (string, error) {
+	analysis, err := parser.ParseFile(sourceFile)
+	if err != nil {
+		return "", err
+	}
+	for _, fn := range analysis.Functions {
+		name := fn.Name
+		if fn.Receiver != nil {
+			name = parser.BaseTypeName(fn.Receiver.Type) + "." + fn.Name
+		}
+		if name == function {
+			return functionHash(fn.Signature, fn.Body), nil
+		}
+	}
+	return "", nil
+}
+
+// samePath reports whether two paths, relative to the working directory or
+// absolute, name the same file
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(filepath.FromSlash(a))
+	absB, errB := filepath.Abs(filepath.FromSlash(b))
+	return errA == nil && errB == nil && absA == absB
+}
+
+// PrintExplanation prints where a test came from, flagging it when the
+// function it covers has changed since
+func PrintExplanation(explanation *TestExplanation) {
+	record := explanation.Record
+	report.Resultf("%s (%s)\n", record.Test, record.TestFile)
+	report.Resultf("  Run:        %s, %s\n", record.RunID, record.RecordedAt.Local().Format("2006-01-02 15:04"))
+	report.Resultf("  Model:      %s\n", record.Model)
+	if record.Confidence > 0 {
+		report.Resultf("  Confidence: %.2f\n", record.Confidence)
+	}
+	report.Resultf("  Function:   %s (%s)\n", record.Function, record.SourceFile)
+	if explanation.Stale != "" {
+		report.Resultf("  Source:     possibly stale: %s\n", explanation.Stale)
+	} else {
+		report.Resultf("  Source:     unchanged since the run\n")
+	}
+	if record.Prompt != "" {
+		report.Resultf("  Prompt:\n")
+		for _, line := range strings.Split(record.Prompt, "\n") {
+			report.Resultf("    %s\n", line)
+		}
+	}
+}
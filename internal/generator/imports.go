@@ -0,0 +1,224 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	goparser "go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Eranmonnie/testgen/internal/workspace"
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// stdlibPackages maps standard library package names generated tests
+// commonly use to their import paths
+var stdlibPackages = map[string]string{
+	"atomic":    "sync/atomic",
+	"base64":    "encoding/base64",
+	"big":       "math/big",
+	"bufio":     "bufio",
+	"bytes":     "bytes",
+	"cmp":       "cmp",
+	"context":   "context",
+	"errors":    "errors",
+	"exec":      "os/exec",
+	"filepath":  "path/filepath",
+	"fmt":       "fmt",
+	"fstest":    "testing/fstest",
+	"heap":      "container/heap",
+	"hex":       "encoding/hex",
+	"http":      "net/http",
+	"httptest":  "net/http/httptest",
+	"io":        "io",
+	"iotest":    "testing/iotest",
+	"json":      "encoding/json",
+	"list":      "container/list",
+	"log":       "log",
+	"maps":      "maps",
+	"math":      "math",
+	"os":        "os",
+	"rand":      "math/rand",
+	"reflect":   "reflect",
+	"regexp":    "regexp",
+	"sha256":    "crypto/sha256",
+	"slices":    "slices",
+	"slog":      "log/slog",
+	"sort":      "sort",
+	"strconv":   "strconv",
+	"strings":   "strings",
+	"sync":      "sync",
+	"tabwriter": "text/tabwriter",
+	"template":  "text/template",
+	"testing":   "testing",
+	"time":      "time",
+	"unicode":   "unicode",
+	"url":       "net/url",
+	"utf8":      "unicode/utf8",
+}
+
+// qualifierRegex finds package qualifiers in code that doesn't parse
+var qualifierRegex = regexp.MustCompile(`\b([a-z][a-zA-Z0-9]*)\.[A-Za-z_]`)
+
+// splitTestImports separates import declarations the AI put in a test's code
+// from the rest of it. It returns the code without them, the declared
+// imports, and the package qualifiers the code uses.
+func splitTestImports(code string) (string, []importSpec, map[string]bool) {
+	fset := token.NewFileSet()
+	file, err := goparser.ParseFile(fset, "", snippetPackageHeader+code, 0)
+	if err != nil {
+		used := make(map[string]bool)
+		for _, match := range qualifierRegex.FindAllStringSubmatch(code, -1) {
+			used[match[1]] = true
+		}
+		return code, nil, used
+	}
+
+	declared := fileImports(file)
+	if len(declared) > 0 {
+		// Cut the import declarations out of the original text
+		var stripped strings.Builder
+		last := 0
+		for _, decl := range file.Decls {
+			if genDecl, ok := decl.(*ast.GenDecl); ok && genDecl.Tok == token.IMPORT {
+				start := fset.Position(genDecl.Pos()).Offset - len(snippetPackageHeader)
+				end := fset.Position(genDecl.End()).Offset - len(snippetPackageHeader)
+				stripped.WriteString(code[last:start])
+				last = end
+			}
+		}
+		stripped.WriteString(code[last:])
+		code = strings.TrimSpace(stripped.String())
+	}
+
+	return code, declared, packageQualifiers(file)
+}
+
+// resolveImports returns the imports for the package qualifiers in used.
+// Imports declared by the AI (keeping their aliases) take precedence over
+// known, which maps extra names such as the source package, and known over
+// the standard library. Unknown qualifiers are left out.
+func resolveImports(used map[string]bool, declared []importSpec, known map[string]importSpec) []importSpec {
+	byName := make(map[string]importSpec)
+	for name, importPath := range stdlibPackages {
+		byName[name] = importSpec{path: importPath}
+	}
+	for name, spec := range known {
+		byName[name] = spec
+	}
+	for _, spec := range declared {
+		if spec.name != "_" && spec.name != "." {
+			byName[spec.localName()] = spec
+		}
+	}
+
+	names := make([]string, 0, len(used))
+	for name := range used {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var imports []importSpec
+	seen := make(map[string]bool)
+	for _, name := range names {
+		spec, ok := byName[name]
+		if !ok || seen[spec.path] {
+			continue
+		}
+		seen[spec.path] = true
+		imports = append(imports, spec)
+	}
+
+	// Blank and dot imports can't be detected from use, so keep them as declared
+	for _, spec := range declared {
+		if (spec.name == "_" || spec.name == ".") && !seen[spec.path] {
+			seen[spec.path] = true
+			imports = append(imports, spec)
+		}
+	}
+
+	return imports
+}
+
+// importGuidance tells the AI to import the packages the sources rename
+// under the same aliases, and to qualify what they dot-import, since a test
+// referring to them any other way doesn't compile
+func importGuidance(context models.RequestContext) []string {
+	var guidance []string
+	if len(context.ImportAliases) > 0 {
+		aliases := make([]string, 0, len(context.ImportAliases))
+		for alias, importPath := range context.ImportAliases {
+			aliases = append(aliases, fmt.Sprintf("%s %q", alias, importPath))
+		}
+		sort.Strings(aliases)
+		guidance = append(guidance, fmt.Sprintf("The source imports packages under aliases; import them under the same aliases and refer to them by those names: %s", strings.Join(aliases, ", ")))
+	}
+	for _, importPath := range context.DotImports {
+		name := defaultPackageName(importPath)
+		guidance = append(guidance, fmt.Sprintf("The source dot-imports %q; never dot-import it in tests: import it as %s and qualify its identifiers (%s.Name, not Name)", importPath, name, name))
+	}
+	return guidance
+}
+
+// sourceImports maps the names sourceFile refers to its imports by to the
+// imports, so a test using one of those names imports the same package
+// under the same alias. A dot import is mapped by its package name, which
+// tests are asked to qualify its identifiers with; blank imports are left
+// out.
+func sourceImports(sourceFile string) map[string]importSpec {
+	file, err := goparser.ParseFile(token.NewFileSet(), sourceFile, nil, goparser.ImportsOnly)
+	if err != nil {
+		return nil
+	}
+	known := make(map[string]importSpec)
+	for _, spec := range fileImports(file) {
+		switch spec.name {
+		case "_":
+			continue
+		case ".":
+			spec.name = ""
+		}
+		known[spec.localName()] = spec
+	}
+	return known
+}
+
+// moduleInfo returns the module path from the go.mod governing sourceFile
+// and the import path of sourceFile's package. Both are empty when no go.mod
+// is found.
+func moduleInfo(sourceFile string) (modulePath, packagePath string) {
+	dir, err := filepath.Abs(filepath.Dir(sourceFile))
+	if err != nil {
+		return "", ""
+	}
+	root, err := workspace.FindModuleRoot(dir)
+	if err != nil {
+		return "", ""
+	}
+	data, err := os.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return "", ""
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "module" {
+			modulePath = strings.Trim(fields[1], `"`)
+			break
+		}
+	}
+	if modulePath == "" {
+		return "", ""
+	}
+
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return modulePath, ""
+	}
+	return modulePath, path.Join(modulePath, filepath.ToSlash(rel))
+}
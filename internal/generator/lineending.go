@@ -0,0 +1,45 @@
+package generator
+
+import (
+	"strings"
+
+	"github.com/Eranmonnie/testgen/internal/git"
+)
+
+// checkoutLineEnding is how git would check a new file out (replaced in tests)
+var checkoutLineEnding = git.CheckoutLineEnding
+
+// lineEnding returns the line ending for generated content at path: the
+// dominant one of the file already there, otherwise the one git would check
+// a new file out with, otherwise \n
+func lineEnding(path string, existing []byte) string {
+	if eol := dominantLineEnding(string(existing)); eol != "" {
+		return eol
+	}
+	if eol := checkoutLineEnding(path); eol != "" {
+		return eol
+	}
+	return "\n"
+}
+
+// dominantLineEnding returns "\r\n" when most lines of content end with it,
+// "\n" when most don't, and "" for content without line breaks
+func dominantLineEnding(content string) string {
+	lines := strings.Count(content, "\n")
+	if lines == 0 {
+		return ""
+	}
+	if crlf := strings.Count(content, "\r\n"); crlf*2 > lines {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// withLineEnding rewrites content, rendered with \n, to use eol
+func withLineEnding(content, eol string) string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	if eol == "\n" {
+		return content
+	}
+	return strings.ReplaceAll(content, "\n", eol)
+}
@@ -0,0 +1,309 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// versionSuffixRegex matches major version path elements such as "v2"
+var versionSuffixRegex = regexp.MustCompile(`^v[0-9]+$`)
+
+// importSpec is a single import: an optional local name and the import path
+type importSpec struct {
+	name string
+	path string
+}
+
+// localName returns the identifier the import is referenced by in code
+func (s importSpec) localName() string {
+	if s.name != "" {
+		return s.name
+	}
+	return defaultPackageName(s.path)
+}
+
+// defaultPackageName guesses the package name of an import path the way
+// goimports does: the last element, skipping major version suffixes
+func defaultPackageName(importPath string) string {
+	base := path.Base(importPath)
+	if versionSuffixRegex.MatchString(base) {
+		base = path.Base(path.Dir(importPath))
+	}
+	if i := strings.Index(base, ".v"); i > 0 {
+		base = base[:i]
+	}
+	base = strings.TrimPrefix(base, "go-")
+	return strings.ReplaceAll(base, "-", "")
+}
+
+// mergeTestFile appends the tests of a rendered test file to an existing test
+// file. The imports needed by the new tests are unioned into a single import
+// block; if an import's name clashes with a different existing import it is
+// aliased and the new code rewritten to use the alias. Tests that already exist
+// are not added again and are returned as skipped.
+func mergeTestFile(existing, addition string) (string, []string, error) {
+	existingSet := token.NewFileSet()
+	existingFile, err := parser.ParseFile(existingSet, "", existing, parser.ParseComments)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse existing test file: %w", err)
+	}
+
+	additionSet := token.NewFileSet()
+	additionFile, err := parser.ParseFile(additionSet, "", addition, parser.ParseComments)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse generated tests: %w", err)
+	}
+
+	// Drop generated tests whose names are already taken
+	existingFuncs := make(map[string]bool)
+	for _, decl := range existingFile.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok && funcDecl.Recv == nil {
+			existingFuncs[funcDecl.Name.Name] = true
+		}
+	}
+
+	var skipped []string
+	var decls []ast.Decl
+	for _, decl := range additionFile.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok && funcDecl.Recv == nil && existingFuncs[funcDecl.Name.Name] {
+			skipped = append(skipped, funcDecl.Name.Name)
+			continue
+		}
+		decls = append(decls, decl)
+	}
+	commentMap := ast.NewCommentMap(additionSet, additionFile, additionFile.Comments)
+	additionFile.Decls = decls
+	additionFile.Comments = commentMap.Filter(additionFile).Comments()
+
+	imports, renames := mergeImports(fileImports(existingFile), fileImports(additionFile), packageQualifiers(additionFile))
+	renameQualifiers(additionFile, renames)
+
+	tests, err := declsAfterImports(additionSet, additionFile)
+	if err != nil {
+		return "", nil, err
+	}
+	if strings.TrimSpace(tests) == "" {
+		return existing, skipped, nil
+	}
+
+	// Replace the existing import declarations with the merged block
+	var prefix, suffix string
+	var importDecls []*ast.GenDecl
+	for _, decl := range existingFile.Decls {
+		if genDecl, ok := decl.(*ast.GenDecl); ok && genDecl.Tok == token.IMPORT {
+			importDecls = append(importDecls, genDecl)
+		}
+	}
+	if len(importDecls) > 0 {
+		prefix = existing[:existingSet.Position(importDecls[0].Pos()).Offset]
+		suffix = existing[existingSet.Position(importDecls[len(importDecls)-1].End()).Offset:]
+	} else {
+		nameEnd := existingSet.Position(existingFile.Name.End()).Offset
+		prefix = existing[:nameEnd] + "\n\n"
+		suffix = existing[nameEnd:]
+	}
+
+	var merged strings.Builder
+	merged.WriteString(prefix)
+	merged.WriteString(renderImportBlock(imports, ""))
+	merged.WriteString(strings.TrimRight(suffix, "\n"))
+	merged.WriteString("\n\n")
+	merged.WriteString(tests)
+
+	formatted, err := format.Source([]byte(merged.String()))
+	if err != nil {
+		return "", nil, fmt.Errorf("merged test file is not valid Go: %w", err)
+	}
+
+	return string(formatted), skipped, nil
+}
+
+// mergeImports unions the existing imports with the new ones that are
+// actually used. It returns the merged list and the qualifier renames the new
+// code needs so it refers to the merged imports.
+func mergeImports(existing, additions []importSpec, used map[string]bool) ([]importSpec, map[string]string) {
+	merged := append([]importSpec(nil), existing...)
+	renames := make(map[string]string)
+
+	byPath := make(map[string]string)
+	byName := make(map[string]string)
+	for _, spec := range existing {
+		byPath[spec.path] = spec.localName()
+		if spec.name != "_" && spec.name != "." {
+			byName[spec.localName()] = spec.path
+		}
+	}
+
+	for _, spec := range additions {
+		name := spec.localName()
+		if spec.name != "_" && spec.name != "." && !used[name] {
+			continue
+		}
+
+		// Already imported: reuse the existing import under its name
+		if existingName, ok := byPath[spec.path]; ok {
+			if existingName != name && spec.name != "_" && spec.name != "." {
+				renames[name] = existingName
+			}
+			continue
+		}
+
+		// The name belongs to a different package: import this one under an alias
+		if _, taken := byName[name]; taken {
+			alias := importAlias(spec.path, name, byName, used)
+			renames[name] = alias
+			spec = importSpec{name: alias, path: spec.path}
+			name = alias
+		}
+
+		merged = append(merged, spec)
+		byPath[spec.path] = name
+		if spec.name != "_" && spec.name != "." {
+			byName[name] = spec.path
+		}
+	}
+
+	return merged, renames
+}
+
+// importAlias picks an unused alias for importPath, prefixing name with the
+// parent path element ("github.com/pkg/errors" -> pkgerrors, "errors" -> stderrors)
+func importAlias(importPath, name string, taken map[string]string, used map[string]bool) string {
+	prefix := "std"
+	if dir := path.Dir(importPath); dir != "." {
+		prefix = defaultPackageName(dir)
+	}
+
+	alias := prefix + name
+	for i := 2; taken[alias] != "" || used[alias]; i++ {
+		alias = fmt.Sprintf("%s%s%d", prefix, name, i)
+	}
+	return alias
+}
+
+// fileImports returns the imports declared in file
+func fileImports(file *ast.File) []importSpec {
+	var specs []importSpec
+	for _, imp := range file.Imports {
+		importPath, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			continue
+		}
+		spec := importSpec{path: importPath}
+		if imp.Name != nil {
+			spec.name = imp.Name.Name
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// packageQualifiers returns the identifiers used as package qualifiers in file
+// (the X of an X.Sel selector that doesn't resolve to a local declaration)
+func packageQualifiers(file *ast.File) map[string]bool {
+	used := make(map[string]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if ident, ok := sel.X.(*ast.Ident); ok && ident.Obj == nil {
+				used[ident.Name] = true
+			}
+		}
+		return true
+	})
+	return used
+}
+
+// renameQualifiers rewrites package qualifiers in file according to renames
+func renameQualifiers(file *ast.File, renames map[string]string) {
+	if len(renames) == 0 {
+		return
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if ident, ok := sel.X.(*ast.Ident); ok && ident.Obj == nil {
+				if newName, ok := renames[ident.Name]; ok {
+					ident.Name = newName
+				}
+			}
+		}
+		return true
+	})
+}
+
+// declsAfterImports prints file and returns the source following its imports
+func declsAfterImports(fset *token.FileSet, file *ast.File) (string, error) {
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, file); err != nil {
+		return "", fmt.Errorf("failed to print generated tests: %w", err)
+	}
+
+	printed := buf.String()
+	printedSet := token.NewFileSet()
+	printedFile, err := parser.ParseFile(printedSet, "", printed, parser.ParseComments)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse generated tests: %w", err)
+	}
+
+	end := printedSet.Position(printedFile.Name.End()).Offset
+	for _, decl := range printedFile.Decls {
+		if genDecl, ok := decl.(*ast.GenDecl); ok && genDecl.Tok == token.IMPORT {
+			end = printedSet.Position(genDecl.End()).Offset
+		}
+	}
+
+	return strings.TrimSpace(printed[end:]) + "\n", nil
+}
+
+// renderImportBlock renders imports as a single parenthesized import
+// declaration grouped the way goimports does: standard library, then other
+// modules, then packages of modulePath (when set), each sorted by path
+func renderImportBlock(imports []importSpec, modulePath string) string {
+	if len(imports) == 0 {
+		return ""
+	}
+
+	var std, external, local []importSpec
+	for _, spec := range imports {
+		switch {
+		case modulePath != "" && (spec.path == modulePath || strings.HasPrefix(spec.path, modulePath+"/")):
+			local = append(local, spec)
+		case strings.Contains(strings.Split(spec.path, "/")[0], "."):
+			external = append(external, spec)
+		default:
+			std = append(std, spec)
+		}
+	}
+
+	var block strings.Builder
+	block.WriteString("import (\n")
+	written := false
+	for _, group := range [][]importSpec{std, external, local} {
+		if len(group) == 0 {
+			continue
+		}
+		if written {
+			block.WriteString("\n")
+		}
+		written = true
+
+		sort.SliceStable(group, func(i, j int) bool { return group[i].path < group[j].path })
+		for _, spec := range group {
+			block.WriteString("\t")
+			if spec.name != "" {
+				block.WriteString(spec.name + " ")
+			}
+			block.WriteString(strconv.Quote(spec.path) + "\n")
+		}
+	}
+	block.WriteString(")")
+	return block.String()
+}
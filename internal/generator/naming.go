@@ -0,0 +1,252 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/Eranmonnie/testgen/internal/config"
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+var (
+	goDefaultTestNameRegex  = regexp.MustCompile(`^Test[A-Z0-9][A-Za-z0-9]*(_[A-Za-z0-9]+)*$`)
+	underscoreTestNameRegex = regexp.MustCompile(`^Test_[A-Za-z0-9]+(_[a-z0-9]+)*$`)
+)
+
+// snippetPackageHeader is prepended to generated test snippets so they can be parsed as a file
+const snippetPackageHeader = "package p\n\n"
+
+// testNameStyle returns the configured test name style, defaulting to go-default
+func (tg *TestGenerator) testNameStyle() string {
+	if tg.config.Output.TestNameStyle == "" {
+		return config.TestNameStyleGoDefault
+	}
+	return tg.config.Output.TestNameStyle
+}
+
+// testNameConvention describes the naming convention for the prompt
+func testNameConvention(style string) string {
+	switch style {
+	case config.TestNameStyleGoDefault:
+		return "TestFunctionName_Scenario (e.g. TestValidateUser_NilUser)"
+	case config.TestNameStyleUnderscore:
+		return "Test_FunctionName_scenario_in_snake_case (e.g. Test_ValidateUser_returns_error_when_nil)"
+	default:
+		return fmt.Sprintf("names must match the regular expression %s", style)
+	}
+}
+
+// testNameMatchesStyle reports whether a test name conforms to the style
+func testNameMatchesStyle(name, style string) bool {
+	switch style {
+	case config.TestNameStyleGoDefault:
+		return goDefaultTestNameRegex.MatchString(name)
+	case config.TestNameStyleUnderscore:
+		return underscoreTestNameRegex.MatchString(name)
+	default:
+		re, err := regexp.Compile(style)
+		if err != nil {
+			return true // invalid regexes are rejected by config validation
+		}
+		return re.MatchString(name)
+	}
+}
+
+// conformTestName returns the nearest name conforming to the style.
+// The second return value is false if no conforming name could be derived.
+func conformTestName(name, style string) (string, bool) {
+	if testNameMatchesStyle(name, style) {
+		return name, true
+	}
+
+	var candidates []string
+	switch style {
+	case config.TestNameStyleGoDefault:
+		candidates = []string{toGoDefaultTestName(name)}
+	case config.TestNameStyleUnderscore:
+		candidates = []string{toUnderscoreTestName(name)}
+	default:
+		// Custom regex: try both built-in forms and keep the first that matches
+		candidates = []string{toGoDefaultTestName(name), toUnderscoreTestName(name)}
+	}
+
+	for _, candidate := range candidates {
+		if candidate != "" && testNameMatchesStyle(candidate, style) {
+			return candidate, true
+		}
+	}
+
+	return "", false
+}
+
+// splitTestName splits a test name into the function part and the scenario parts
+func splitTestName(name string) (string, []string) {
+	body := strings.TrimPrefix(name, "Test")
+
+	var parts []string
+	for _, part := range strings.Split(body, "_") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	if len(parts) == 0 {
+		return "", nil
+	}
+	return upperFirst(parts[0]), parts[1:]
+}
+
+// toGoDefaultTestName converts a name to TestFunctionName_Scenario form
+func toGoDefaultTestName(name string) string {
+	function, scenario := splitTestName(name)
+	if function == "" {
+		return ""
+	}
+
+	var words []string
+	for _, part := range scenario {
+		for _, word := range splitCamelCase(part) {
+			words = append(words, upperFirst(word))
+		}
+	}
+
+	if len(words) == 0 {
+		return "Test" + function
+	}
+	return "Test" + function + "_" + strings.Join(words, "")
+}
+
+// toUnderscoreTestName converts a name to Test_FunctionName_scenario form
+func toUnderscoreTestName(name string) string {
+	function, scenario := splitTestName(name)
+	if function == "" {
+		return ""
+	}
+
+	var words []string
+	for _, part := range scenario {
+		for _, word := range splitCamelCase(part) {
+			words = append(words, strings.ToLower(word))
+		}
+	}
+
+	if len(words) == 0 {
+		return "Test_" + function
+	}
+	return "Test_" + function + "_" + strings.Join(words, "_")
+}
+
+// splitCamelCase splits an identifier into words ("NilUserHTTP" -> Nil, User, HTTP)
+func splitCamelCase(s string) []string {
+	runes := []rune(s)
+	var words []string
+	start := 0
+
+	for i := 1; i < len(runes); i++ {
+		prev, cur := runes[i-1], runes[i]
+		boundary := unicode.IsUpper(cur) && (unicode.IsLower(prev) || unicode.IsDigit(prev))
+		// End of an acronym: "HTTPServer" splits before "Server"
+		if unicode.IsUpper(prev) && unicode.IsUpper(cur) && i+1 < len(runes) && unicode.IsLower(runes[i+1]) {
+			boundary = true
+		}
+		if boundary {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+
+	if start < len(runes) {
+		words = append(words, string(runes[start:]))
+	}
+	return words
+}
+
+// upperFirst upper-cases the first letter of s
+func upperFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	runes := []rune(s)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+// renameTestFunctions rewrites the names of top-level Test functions in a code
+// snippet using the AST. Only function declarations are renamed, so string
+// literals such as t.Run subtest names are left untouched.
+func renameTestFunctions(code string, rename func(string) (string, bool)) (string, map[string]string, []string, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", snippetPackageHeader+code, parser.ParseComments)
+	if err != nil {
+		return code, nil, nil, fmt.Errorf("failed to parse test code: %w", err)
+	}
+
+	renamed := make(map[string]string)
+	var failed []string
+
+	for _, decl := range file.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Recv != nil || !strings.HasPrefix(funcDecl.Name.Name, "Test") {
+			continue
+		}
+
+		oldName := funcDecl.Name.Name
+		newName, ok := rename(oldName)
+		if !ok {
+			failed = append(failed, oldName)
+			continue
+		}
+		if newName != oldName {
+			funcDecl.Name.Name = newName
+			renamed[oldName] = newName
+		}
+	}
+
+	if len(renamed) == 0 {
+		return code, renamed, failed, nil
+	}
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, file); err != nil {
+		return code, nil, nil, fmt.Errorf("failed to print renamed test code: %w", err)
+	}
+
+	return strings.TrimPrefix(buf.String(), snippetPackageHeader), renamed, failed, nil
+}
+
+// enforceTestNameStyle renames generated tests that don't follow the configured
+// naming style and returns warnings for tests that couldn't be renamed
+func (tg *TestGenerator) enforceTestNameStyle(tests []models.GeneratedTest) []string {
+	style := tg.testNameStyle()
+	var warnings []string
+
+	for i := range tests {
+		test := &tests[i]
+
+		code, renamed, failed, err := renameTestFunctions(test.Code, func(name string) (string, bool) {
+			return conformTestName(name, style)
+		})
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("could not check name of test %s: %v", test.Name, err))
+			continue
+		}
+
+		for _, name := range failed {
+			warnings = append(warnings, fmt.Sprintf("test %s does not follow test_name_style %q and could not be renamed", name, style))
+		}
+
+		test.Code = code
+		if newName, ok := renamed[test.Name]; ok {
+			test.Name = newName
+		}
+	}
+
+	return warnings
+}
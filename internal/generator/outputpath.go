@@ -0,0 +1,85 @@
+package generator
+
+import (
+	goparser "go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// packageClaims records which source package's tests each output directory
+// holds during a run, since go allows one package per directory
+type packageClaims map[string]string
+
+// testOutputPath returns the test file for fn, as a path from the working
+// directory like fn.SourcePath. Tests next to their source
+// always share its package. A shared output.directory belongs to the first
+// package whose tests it holds, on disk or earlier in the run (claims, which
+// may be nil); tests of any other package go to a subdirectory named after
+// their package.
+func (tg *TestGenerator) testOutputPath(fn models.FunctionInfo, claims packageClaims) string {
+	path := filepath.Clean(fn.ResolvePath(tg.config.GetTestOutputPath(fn.File)))
+	if tg.config.Output.Directory == "" || fn.Package == "" {
+		return path
+	}
+
+	dir := filepath.Dir(path)
+	owner, ok := claims[dir]
+	if !ok {
+		owner = directoryPackage(dir)
+	}
+	if owner == "" {
+		owner = fn.Package
+	}
+	if owner != fn.Package {
+		dir = filepath.Join(dir, fn.Package)
+		path = filepath.Join(dir, filepath.Base(path))
+	}
+	if claims != nil {
+		if _, ok := claims[dir]; !ok {
+			claims[dir] = fn.Package
+		}
+	}
+	return path
+}
+
+// rootRelativePath returns path, as testOutputPath returns it for fn,
+// relative to the project root like fn.File
+func rootRelativePath(fn models.FunctionInfo, path string) string {
+	if fn.Root == "" {
+		return path
+	}
+	if rel, err := filepath.Rel(fn.Root, path); err == nil && filepath.IsLocal(rel) {
+		return rel
+	}
+	return path
+}
+
+// directoryPackage returns the package whose tests dir already holds, with
+// any _test suffix dropped, or "" when it holds no Go files
+func directoryPackage(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".go") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		file, err := goparser.ParseFile(token.NewFileSet(), filepath.Join(dir, name), nil, goparser.PackageClauseOnly)
+		if err == nil {
+			return strings.TrimSuffix(file.Name.Name, "_test")
+		}
+	}
+	return ""
+}
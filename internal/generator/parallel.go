@@ -0,0 +1,43 @@
+package generator
+
+import (
+	"strings"
+
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// parallelGuidance returns the prompt requirements for output.parallel_subtests
+func (tg *TestGenerator) parallelGuidance() []string {
+	if !tg.config.Output.ParallelSubtests {
+		return nil
+	}
+	return []string{
+		"Call t.Parallel() at the start of each independent subtest (and its parent test) so tests run faster",
+		"NEVER call t.Parallel() in a test, or any of its subtests, that uses t.Setenv or t.Chdir: the testing package panics when they are combined",
+		"Do not call t.Parallel() in subtests that share mutable state (package-level variables, a fixture one subtest modifies, a shared file path); give each subtest its own values, e.g. t.TempDir()",
+	}
+}
+
+// parallelNote explains why tests of a function must not run in parallel,
+// or returns "" when nothing in the function prevents it
+func (tg *TestGenerator) parallelNote(complexity models.ComplexityInfo) string {
+	if !tg.config.Output.ParallelSubtests {
+		return ""
+	}
+
+	var reasons []string
+	if complexity.UsesEnv {
+		reasons = append(reasons, "reads or changes environment variables (use t.Setenv)")
+	}
+	if complexity.UsesWorkingDir {
+		reasons = append(reasons, "depends on the working directory (use t.Chdir)")
+	}
+	if len(reasons) > 0 {
+		return "this function " + strings.Join(reasons, " and ") + ", so do NOT use t.Parallel() in its tests or subtests."
+	}
+
+	if complexity.UsesFilesystem {
+		return "this function uses the filesystem; parallel subtests must each use their own t.TempDir() paths."
+	}
+	return ""
+}
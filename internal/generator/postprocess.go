@@ -0,0 +1,126 @@
+package generator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultPostProcessTimeout applies when output.post_process_timeout is unset
+const defaultPostProcessTimeout = 30 * time.Second
+
+// postProcessPlaceholder is replaced with the path of the file being processed
+const postProcessPlaceholder = "{}"
+
+// runPostProcessors runs the configured output.post_process commands over the
+// rendered content of testFilePath and returns the processed content.
+// Commands operate on a temp file near the target so a failure never
+// corrupts it. If a command fails, the unprocessed content is returned with a
+// warning unless output.post_process_required is set.
+func (tg *TestGenerator) runPostProcessors(testFilePath, content string) (string, []Warning, error) {
+	if len(tg.config.Output.PostProcess) == 0 {
+		return content, nil, nil
+	}
+
+	processed := content
+	for _, command := range tg.config.Output.PostProcess {
+		result, err := tg.runPostProcessor(command, testFilePath, processed)
+		if err != nil {
+			if tg.config.Output.PostProcessRequired {
+				return "", nil, err
+			}
+			return content, []Warning{{Path: testFilePath, Message: fmt.Sprintf("%v (keeping unprocessed %s)", err, testFilePath)}}, nil
+		}
+		processed = result
+	}
+
+	return processed, nil, nil
+}
+
+// runPostProcessor runs a single post-processing command. Commands containing
+// {} get the temp file path substituted and may edit it in place; other
+// commands receive the content on stdin and must write the result to stdout.
+func (tg *TestGenerator) runPostProcessor(command, testFilePath, content string) (string, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return content, nil
+	}
+
+	// Hidden file (leading dot) so the go tool ignores it while it exists;
+	// rendering creates no directories, so it goes in the closest one that exists
+	tmpFile, err := os.CreateTemp(existingDir(filepath.Dir(testFilePath)), ".testgen-*-"+filepath.Base(testFilePath))
+	if err != nil {
+		return "", fmt.Errorf("post-processor %q: failed to create temp file: %w", command, err)
+	}
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmpFile.WriteString(content); err != nil {
+		tmpFile.Close()
+		return "", fmt.Errorf("post-processor %q: failed to write temp file: %w", command, err)
+	}
+	tmpFile.Close()
+
+	usesFile := false
+	for i, arg := range args {
+		if strings.Contains(arg, postProcessPlaceholder) {
+			args[i] = strings.ReplaceAll(arg, postProcessPlaceholder, tmpPath)
+			usesFile = true
+		}
+	}
+
+	timeout := defaultPostProcessTimeout
+	if tg.config.Output.PostProcessTimeout > 0 {
+		timeout = time.Duration(tg.config.Output.PostProcessTimeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if !usesFile {
+		cmd.Stdin = strings.NewReader(content)
+		cmd.Stdout = &stdout
+	}
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("post-processor %q failed: %v: %s", command, err, msg)
+		}
+		return "", fmt.Errorf("post-processor %q failed: %w", command, err)
+	}
+
+	if !usesFile {
+		return stdout.String(), nil
+	}
+
+	data, err := os.ReadFile(tmpPath)
+	if err != nil {
+		return "", fmt.Errorf("post-processor %q: failed to read processed file: %w", command, err)
+	}
+	return string(data), nil
+}
+
+// existingDir returns dir, or its closest ancestor that exists
+func existingDir(dir string) string {
+	for {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,45 @@
+package generator
+
+import (
+	"github.com/Eranmonnie/testgen/internal/config"
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// System messages sent ahead of the prompt, by provider. Anthropic gets the
+// prompt alone.
+const (
+	openAISystemMessage = "You are an expert Go test writer. Generate comprehensive, idiomatic Go tests based on the provided function information."
+	groqSystemMessage   = "You are an expert Go test writer. Generate comprehensive, idiomatic Go tests."
+	localSystemMessage  = "You are an expert Go test writer. Generate comprehensive, idiomatic Go tests. Reply with the JSON object only."
+)
+
+// Preview is what a generate run would send the AI for a request
+type Preview struct {
+	System string // provider's system message, empty if it has none
+	Prompt string
+}
+
+// PreviewRequest renders the prompt and system message for request exactly
+// as GenerateTests would send them, trimming included, without an HTTP
+// client or API key
+func PreviewRequest(cfg *config.Config, request models.TestGenerationRequest) Preview {
+	tg := &TestGenerator{config: cfg}
+	return Preview{
+		System: systemMessage(cfg.AI.Provider),
+		Prompt: tg.buildPrompt(request),
+	}
+}
+
+// systemMessage returns the system message sent to provider, if any
+func systemMessage(provider string) string {
+	switch provider {
+	case "openai":
+		return openAISystemMessage
+	case "groq":
+		return groqSystemMessage
+	case "local":
+		return localSystemMessage
+	default:
+		return ""
+	}
+}
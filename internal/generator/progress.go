@@ -0,0 +1,117 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ProgressFile records which functions a generate run has finished, so an
+// interrupted run can be resumed
+const ProgressFile = ".testgen/progress.json"
+
+// Progress tracks a generate run's targets by name (see analyzer.QualifiedName)
+// and is saved after every completed function
+type Progress struct {
+	StartedAt time.Time `json:"started_at"`
+	Targets   []string  `json:"targets"`
+	Completed []string  `json:"completed"`
+
+	path string
+	done map[string]bool
+}
+
+// NewProgress starts tracking a run over targets, saved to path
+func NewProgress(path string, targets []string) (*Progress, error) {
+	progress := &Progress{
+		StartedAt: time.Now().UTC(),
+		Targets:   targets,
+		Completed: []string{},
+		path:      path,
+		done:      make(map[string]bool),
+	}
+	if err := progress.save(); err != nil {
+		return nil, err
+	}
+	return progress, nil
+}
+
+// LoadProgress reads the progress of an interrupted run from path
+func LoadProgress(path string) (*Progress, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no interrupted run to resume (%s not found)", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read progress: %w", err)
+	}
+
+	var progress Progress
+	if err := json.Unmarshal(data, &progress); err != nil {
+		return nil, fmt.Errorf("failed to parse progress %s: %w", path, err)
+	}
+	progress.path = path
+	progress.done = make(map[string]bool)
+	for _, name := range progress.Completed {
+		progress.done[name] = true
+	}
+	return &progress, nil
+}
+
+// IsDone reports whether the named target already has its tests
+func (p *Progress) IsDone(name string) bool {
+	return p.done[name]
+}
+
+// Done marks targets finished and saves the progress right away
+func (p *Progress) Done(names ...string) error {
+	for _, name := range names {
+		if !p.done[name] {
+			p.done[name] = true
+			p.Completed = append(p.Completed, name)
+		}
+	}
+	return p.save()
+}
+
+// Remaining is the number of targets not finished yet
+func (p *Progress) Remaining() int {
+	remaining := 0
+	for _, name := range p.Targets {
+		if !p.done[name] {
+			remaining++
+		}
+	}
+	return remaining
+}
+
+// Finish removes the progress file once the run has completed
+func (p *Progress) Finish() error {
+	if err := os.Remove(p.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove progress: %w", err)
+	}
+	return nil
+}
+
+// save writes the progress through a temporary file so an interruption
+// mid-write never leaves it truncated
+func (p *Progress) save() error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode progress: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(p.path), 0755); err != nil {
+		return fmt.Errorf("failed to create progress directory: %w", err)
+	}
+
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write progress: %w", err)
+	}
+	if err := os.Rename(tmp, p.path); err != nil {
+		return fmt.Errorf("failed to write progress: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,295 @@
+package generator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// ProposalsDir holds proposed tests awaiting approval, one directory per run
+// (go ignores directories starting with a dot, so candidates never compile)
+const ProposalsDir = ".testgen/proposals"
+
+// proposalManifest is the machine-readable manifest inside a run directory
+const proposalManifest = "manifest.json"
+
+// Proposal is a generate run whose tests wait for approval instead of being
+// written to real test files
+type Proposal struct {
+	RunID     string         `json:"run_id"`
+	CreatedAt time.Time      `json:"created_at"`
+	Root      string         `json:"root,omitempty"` // project root the functions' files are relative to, when it isn't the working directory
+	Tests     []ProposedTest `json:"tests"`
+}
+
+// ProposedTest is one candidate test with what approval needs to write it
+type ProposedTest struct {
+	Name         string               `json:"name"`
+	Function     models.FunctionInfo  `json:"function"`
+	Test         models.GeneratedTest `json:"test"`
+	SourceHash   string               `json:"source_sha256"` // source file content when proposed
+	Destination  string               `json:"destination"`   // real test file, relative to the project root like the function's file
+	ProposalFile string               `json:"proposal_file"` // candidate test file, relative to the run directory
+}
+
+// ApprovalResult reports what approving a proposal did
+type ApprovalResult struct {
+	Approved  []string // tests written to their destinations
+	Conflicts []string // tests left pending because their source changed
+	Remaining int      // tests still pending in the proposal
+}
+
+// Propose writes tests as a proposal under dir instead of into real test
+// files: one candidate file per destination, laid out like the repository,
+// plus a manifest. Tests pair with functions by position, as in WriteTestFiles.
+func (tg *TestGenerator) Propose(dir string, functions []models.FunctionInfo, tests []models.GeneratedTest) (*Proposal, error) {
+	runID, err := newRunID(dir, "")
+	if err != nil {
+		return nil, err
+	}
+
+	proposal := &Proposal{RunID: runID, CreatedAt: time.Now().UTC()}
+	if len(functions) > 0 {
+		proposal.Root = functions[0].Root
+	}
+	hashes := make(map[string]string)
+	claims := make(packageClaims)
+	for i, fn := range functions {
+		if i >= len(tests) {
+			break
+		}
+		hash, ok := hashes[fn.File]
+		if !ok {
+			if hash, err = fileHash(fn.SourcePath()); err != nil {
+				return nil, fmt.Errorf("failed to read source %s: %w", fn.File, err)
+			}
+			hashes[fn.File] = hash
+		}
+
+		destination := rootRelativePath(fn, tg.testOutputPath(fn, claims))
+		proposal.Tests = append(proposal.Tests, ProposedTest{
+			Name:         tests[i].Name,
+			Function:     fn,
+			Test:         tests[i],
+			SourceHash:   hash,
+			Destination:  destination,
+			ProposalFile: proposalFileName(destination),
+		})
+	}
+
+	if err := tg.saveProposal(dir, proposal); err != nil {
+		return nil, err
+	}
+	return proposal, nil
+}
+
+// LoadProposal reads the proposal for runID from dir
+func LoadProposal(dir, runID string) (*Proposal, error) {
+	data, err := os.ReadFile(filepath.Join(dir, runID, proposalManifest))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no pending proposal %q", runID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read proposal %s: %w", runID, err)
+	}
+
+	var proposal Proposal
+	if err := json.Unmarshal(data, &proposal); err != nil {
+		return nil, fmt.Errorf("failed to parse proposal %s: %w", runID, err)
+	}
+	for i := range proposal.Tests {
+		proposal.Tests[i].Function.Root = proposal.Root
+	}
+	return &proposal, nil
+}
+
+// ListProposals returns the pending proposals in dir, oldest first
+func ListProposals(dir string) ([]*Proposal, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read proposals: %w", err)
+	}
+
+	var proposals []*Proposal
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		proposal, err := LoadProposal(dir, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		proposals = append(proposals, proposal)
+	}
+
+	sort.Slice(proposals, func(i, j int) bool { return proposals[i].RunID < proposals[j].RunID })
+	return proposals, nil
+}
+
+// ApproveProposal writes the proposal's tests (or only the named ones) to
+// their destinations with the normal merge and marker handling. Tests whose
+// source changed since the proposal are left pending as conflicts. Approved
+// tests leave the proposal, which is removed once nothing is pending.
+func (tg *TestGenerator) ApproveProposal(dir, runID string, only []string) (*ApprovalResult, error) {
+	proposal, err := LoadProposal(dir, runID)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make(map[string]bool)
+	for _, name := range only {
+		selected[name] = false
+	}
+	for _, proposed := range proposal.Tests {
+		if _, ok := selected[proposed.Name]; ok {
+			selected[proposed.Name] = true
+		}
+	}
+	for _, name := range only {
+		if !selected[name] {
+			return nil, fmt.Errorf("proposal %s has no test %s", runID, name)
+		}
+	}
+
+	result := &ApprovalResult{}
+	var functions []models.FunctionInfo
+	var tests []models.GeneratedTest
+	var pending []ProposedTest
+	for _, proposed := range proposal.Tests {
+		if len(only) > 0 && !selected[proposed.Name] {
+			pending = append(pending, proposed)
+			continue
+		}
+		if hash, err := fileHash(proposed.Function.SourcePath()); err != nil || hash != proposed.SourceHash {
+			result.Conflicts = append(result.Conflicts, proposed.Name)
+			pending = append(pending, proposed)
+			continue
+		}
+		functions = append(functions, proposed.Function)
+		tests = append(tests, proposed.Test)
+		result.Approved = append(result.Approved, proposed.Name)
+	}
+
+	if len(tests) > 0 {
+		if err := tg.WriteTestFiles(functions, tests); err != nil {
+			return nil, err
+		}
+	}
+
+	result.Remaining = len(pending)
+	if len(pending) == 0 {
+		if err := os.RemoveAll(filepath.Join(dir, runID)); err != nil {
+			return nil, fmt.Errorf("failed to remove proposal %s: %w", runID, err)
+		}
+		return result, nil
+	}
+
+	proposal.Tests = pending
+	if err := tg.saveProposal(dir, proposal); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// saveProposal (re)writes a proposal's candidate files and manifest
+func (tg *TestGenerator) saveProposal(dir string, proposal *Proposal) error {
+	runDir := filepath.Join(dir, proposal.RunID)
+	if err := os.RemoveAll(runDir); err != nil {
+		return fmt.Errorf("failed to clear proposal %s: %w", proposal.RunID, err)
+	}
+
+	// One candidate file per destination, rendered like the real test file
+	var files []string
+	byFile := make(map[string][]ProposedTest)
+	for _, proposed := range proposal.Tests {
+		if _, ok := byFile[proposed.ProposalFile]; !ok {
+			files = append(files, proposed.ProposalFile)
+		}
+		byFile[proposed.ProposalFile] = append(byFile[proposed.ProposalFile], proposed)
+	}
+
+	for _, file := range files {
+		var functions []models.FunctionInfo
+		var tests []models.GeneratedTest
+		for _, proposed := range byFile[file] {
+			functions = append(functions, proposed.Function)
+			tests = append(tests, proposed.Test)
+		}
+
+		content, err := tg.buildTestFileContent(functions[0].File, functions, tests)
+		if err != nil {
+			return fmt.Errorf("failed to build proposed tests for %s: %w", functions[0].File, err)
+		}
+
+		path := filepath.Join(runDir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("failed to create proposal directory: %w", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write proposed tests: %w", err)
+		}
+	}
+
+	data, err := json.MarshalIndent(proposal, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode proposal manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(runDir, proposalManifest), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write proposal manifest: %w", err)
+	}
+	return nil
+}
+
+// newRunID names a proposal or queued run after the current time, adding a
+// suffix if a run with that name, plus ext, already exists in dir
+func newRunID(dir, ext string) (string, error) {
+	base := time.Now().UTC().Format("20060102-150405")
+	for n := 1; ; n++ {
+		runID := base
+		if n > 1 {
+			runID = fmt.Sprintf("%s-%d", base, n)
+		}
+		if _, err := os.Stat(filepath.Join(dir, runID+ext)); os.IsNotExist(err) {
+			return runID, nil
+		} else if err != nil {
+			return "", fmt.Errorf("failed to check run %s: %w", runID, err)
+		}
+	}
+}
+
+// proposalFileName places a destination test file inside a run directory:
+// at the same relative path for files in the working tree, otherwise
+// flattened to a single name
+func proposalFileName(destination string) string {
+	if !filepath.IsAbs(destination) {
+		if !strings.HasPrefix(destination, "..") {
+			return destination
+		}
+	} else if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, destination); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return strings.Trim(unsafeNameRegex.ReplaceAllString(filepath.ToSlash(destination), "_"), "_.")
+}
+
+// fileHash returns the hex SHA-256 of a file's content
+func fileHash(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,156 @@
+package generator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// QueueDir holds generate runs deferred while the AI provider was
+// unreachable (triggers.auto.queue_on_failure), one JSON file per run
+const QueueDir = ".testgen/queue"
+
+// QueuedRun is the analyzed targets of a generate run that couldn't reach
+// the AI provider, kept as the request it would have sent
+type QueuedRun struct {
+	ID           string                `json:"id"`
+	QueuedAt     time.Time             `json:"queued_at"`
+	TestType     models.TestType       `json:"test_type,omitempty"`
+	Context      models.RequestContext `json:"context"`
+	Functions    []models.FunctionInfo `json:"functions"`
+	SourceHashes map[string]string     `json:"source_sha256"`  // source file content when queued, by path
+	Root         string                `json:"root,omitempty"` // project root the functions' files are relative to, when it isn't the working directory
+}
+
+// ProviderUnreachable reports whether err means the AI provider couldn't be
+// reached at all, a failed DNS lookup or connection, rather than refusing
+// or failing the request. Only those are worth queueing for later.
+func ProviderUnreachable(err error) bool {
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	return errors.As(err, &dnsErr) || errors.As(err, &opErr)
+}
+
+// Enqueue saves request's targets as a new run in dir, recording their
+// source files' content so later changes can be detected
+func Enqueue(dir string, request models.TestGenerationRequest) (*QueuedRun, error) {
+	id, err := newRunID(dir, ".json")
+	if err != nil {
+		return nil, err
+	}
+
+	run := &QueuedRun{
+		ID:           id,
+		QueuedAt:     time.Now().UTC(),
+		TestType:     request.TestType,
+		Context:      request.Context,
+		Functions:    request.Functions,
+		SourceHashes: make(map[string]string),
+	}
+	if len(request.Functions) > 0 {
+		run.Root = request.Functions[0].Root
+	}
+	for _, fn := range request.Functions {
+		if _, ok := run.SourceHashes[fn.File]; ok {
+			continue
+		}
+		hash, err := fileHash(fn.SourcePath())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read source %s: %w", fn.File, err)
+		}
+		run.SourceHashes[fn.File] = hash
+	}
+
+	if err := run.Save(dir); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// ListQueue returns the runs queued in dir, oldest first
+func ListQueue(dir string) ([]*QueuedRun, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queue: %w", err)
+	}
+
+	var runs []*QueuedRun
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read queued run: %w", err)
+		}
+		var run QueuedRun
+		if err := json.Unmarshal(data, &run); err != nil {
+			return nil, fmt.Errorf("failed to parse queued run %s: %w", path, err)
+		}
+		for i := range run.Functions {
+			run.Functions[i].Root = run.Root
+		}
+		runs = append(runs, &run)
+	}
+
+	sort.Slice(runs, func(i, j int) bool { return runs[i].ID < runs[j].ID })
+	return runs, nil
+}
+
+// Stale reports whether fn's source file changed or disappeared since the
+// run was queued, so fn must be analyzed again before it's sent
+func (r *QueuedRun) Stale(fn models.FunctionInfo) bool {
+	hash, err := fileHash(fn.SourcePath())
+	return err != nil || hash != r.SourceHashes[fn.File]
+}
+
+// Save writes the run to dir, through a temporary file so an interruption
+// never leaves it truncated
+func (r *QueuedRun) Save(dir string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode queued run: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create queue directory: %w", err)
+	}
+
+	path := filepath.Join(dir, r.ID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write queued run: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write queued run: %w", err)
+	}
+	return nil
+}
+
+// Remove deletes the run from dir once its tests are written
+func (r *QueuedRun) Remove(dir string) error {
+	if err := os.Remove(filepath.Join(dir, r.ID+".json")); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove queued run %s: %w", r.ID, err)
+	}
+	return nil
+}
+
+// Age describes how long ago the run was queued, to the minute
+func (r *QueuedRun) Age(now time.Time) string {
+	age := now.Sub(r.QueuedAt).Round(time.Minute)
+	if age < time.Minute {
+		return "just now"
+	}
+	return strings.TrimSuffix(age.String(), "0s") + " ago"
+}
@@ -0,0 +1,281 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"go/format"
+	goparser "go/parser"
+	"go/scanner"
+	"go/token"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Eranmonnie/testgen/internal/parser"
+	"github.com/Eranmonnie/testgen/internal/report"
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// TestMatch pairs a generated test with the function it was generated for
+type TestMatch struct {
+	Function models.FunctionInfo
+	Test     models.GeneratedTest
+}
+
+// Warning is something to review about a rendered file, e.g. a test kept
+// out of it
+type Warning struct {
+	Path    string
+	Message string
+}
+
+// MatchTestsToFunctions pairs tests with functions by position, the order
+// the AI was asked for them in. Tests beyond the last function are dropped.
+func MatchTestsToFunctions(functions []models.FunctionInfo, tests []models.GeneratedTest) []TestMatch {
+	var matches []TestMatch
+	for i, fn := range functions {
+		if i >= len(tests) {
+			break
+		}
+		matches = append(matches, TestMatch{Function: fn, Test: tests[i]})
+	}
+	return matches
+}
+
+// RenderTestFiles renders the final content of every test file the matches
+// lead to, keyed by path, without writing anything: headers, imports,
+// markers, merging into existing test files and post-processing included.
+// Quarantined tests are rendered to a file of their own next to the test
+// file, and the export_test.go aliases external-package tests need are
+// rendered too. A file that fails to render is left out and its error joined into
+// the returned error; the other files are still returned.
+func (tg *TestGenerator) RenderTestFiles(matches []TestMatch) (map[string]string, []Warning, error) {
+	defer report.Time(report.PhaseWrite)()
+	// Group tests by output path, computed once per function, so every test
+	// file is rendered exactly once even when several source paths (or
+	// repeated ones) lead to it
+	var outputPaths []string
+	sourceByPath := make(map[string]string)
+	matchesByPath := make(map[string][]TestMatch)
+	quarantinedByPath := make(map[string][]models.GeneratedTest)
+	packageByPath := make(map[string]string)
+
+	claims := make(packageClaims)
+	var failures []error
+	for _, match := range matches {
+		outputPath := tg.testOutputPath(match.Function, claims)
+		if _, ok := sourceByPath[outputPath]; !ok {
+			sourceByPath[outputPath] = match.Function.File
+			packageByPath[outputPath] = match.Function.Package
+			outputPaths = append(outputPaths, outputPath)
+		}
+		// A test file has one package clause; functions of another package
+		// sharing its name (same directory, different package) can't join it
+		if pkg := packageByPath[outputPath]; pkg != match.Function.Package {
+			failures = append(failures, fmt.Errorf("%s is in package %s but %s holds tests for package %s", match.Function.Name, match.Function.Package, outputPath, pkg))
+			continue
+		}
+		if match.Test.QuarantineReason != "" {
+			quarantinedByPath[outputPath] = append(quarantinedByPath[outputPath], match.Test)
+			continue
+		}
+		matchesByPath[outputPath] = append(matchesByPath[outputPath], match)
+	}
+
+	files := make(map[string]string)
+	var warnings []Warning
+	for _, outputPath := range outputPaths {
+		sourceFile := sourceByPath[outputPath]
+		if quarantined := quarantinedByPath[outputPath]; len(quarantined) > 0 {
+			path := outputPath + quarantineSuffix
+			files[path] = normalizeWhitespace(renderQuarantine(quarantined))
+			warnings = append(warnings, Warning{Path: path, Message: fmt.Sprintf("%d tests quarantined for review: %s", len(quarantined), path)})
+		}
+		if len(matchesByPath[outputPath]) == 0 {
+			continue
+		}
+
+		// Tests follow the functions they cover, so the file mirrors the
+		// source; a function's tests keep their order
+		pathMatches := matchesByPath[outputPath]
+		slices.SortStableFunc(pathMatches, func(a, b TestMatch) int {
+			return compareSourceOrder(a.Function, b.Function)
+		})
+		functions := make([]models.FunctionInfo, len(pathMatches))
+		tests := make([]models.GeneratedTest, len(pathMatches))
+		for i, match := range pathMatches {
+			functions[i], tests[i] = match.Function, match.Test
+		}
+
+		content, fileWarnings, err := tg.renderTestFile(outputPath, sourceFile, functions, tests)
+		warnings = append(warnings, fileWarnings...)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("failed to render test file for %s: %w", sourceFile, err))
+			continue
+		}
+		files[outputPath] = content
+	}
+
+	// Tests in the external test package reach unexported code through
+	// export_test.go in the package under test
+	shims, shimWarnings, err := tg.renderExportShims(matches)
+	warnings = append(warnings, shimWarnings...)
+	if err != nil {
+		failures = append(failures, err)
+	}
+	for path, content := range shims {
+		files[path] = content
+	}
+
+	// Files are rendered with \n; match the endings of the files they
+	// replace, or what git would check them out with
+	for path, content := range files {
+		existing, _ := os.ReadFile(path)
+		files[path] = withLineEnding(content, lineEnding(path, existing))
+	}
+
+	if len(failures) > 0 {
+		return files, warnings, fmt.Errorf("%d of %d test files failed:\n%w", len(failures), len(outputPaths), errors.Join(failures...))
+	}
+	return files, warnings, nil
+}
+
+// renderTestFile renders the test file at testFilePath for the tests of
+// sourceFile's functions, merged into the existing file when configured
+func (tg *TestGenerator) renderTestFile(testFilePath, sourceFile string, functions []models.FunctionInfo, tests []models.GeneratedTest) (string, []Warning, error) {
+	// Check if we should merge into or overwrite an existing file
+	existing, readErr := parser.ReadSource(testFilePath)
+	merge := readErr == nil && tg.config.Output.Merge
+	if readErr == nil && !merge && !tg.config.Output.Overwrite {
+		return "", nil, fmt.Errorf("test file %s already exists (use merge: true to append or overwrite: true to replace)", testFilePath)
+	}
+
+	// Build complete test file content
+	content, err := tg.buildTestFileContent(sourceFile, functions, tests)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to build test content: %w", err)
+	}
+
+	// Tests that don't parse would break the whole package's build; the
+	// rest are gofmt'd, since the AI's indentation rarely is
+	content, err = formatTestFile(testFilePath, content)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Append the new tests to the existing file with a single merged import block
+	var warnings []Warning
+	if merge {
+		merged, skipped, err := mergeTestFile(string(existing), content)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to merge into %s: %w", testFilePath, err)
+		}
+		for _, name := range skipped {
+			warnings = append(warnings, Warning{Path: testFilePath, Message: fmt.Sprintf("%s already exists in %s, keeping the existing test", name, testFilePath)})
+		}
+		content = merged
+	}
+
+	// Imports left duplicated or unused would break the package's build
+	modulePath, _ := moduleInfo(sourcePath(functions, sourceFile))
+	content, err = sanitizeImports(content, modulePath)
+	if err != nil {
+		return "", warnings, err
+	}
+
+	// Run user post-processors over the final content
+	content, processWarnings, err := tg.runPostProcessors(testFilePath, content)
+	if err != nil {
+		return "", warnings, fmt.Errorf("failed to post-process test file: %w", err)
+	}
+	return content, append(warnings, processWarnings...), nil
+}
+
+// sourcePath returns sourceFile, the file of functions relative to the
+// project root, as a path to read from the working directory
+func sourcePath(functions []models.FunctionInfo, sourceFile string) string {
+	if len(functions) == 0 {
+		return sourceFile
+	}
+	return functions[0].ResolvePath(sourceFile)
+}
+
+// formatTestFile formats a rendered test file as gofmt does, failing with
+// the lines around the first syntax error when it isn't valid Go
+func formatTestFile(path, content string) (string, error) {
+	if _, err := goparser.ParseFile(token.NewFileSet(), path, content, goparser.SkipObjectResolution); err != nil {
+		line := 0
+		var errs scanner.ErrorList
+		if errors.As(err, &errs) && len(errs) > 0 {
+			line = errs[0].Pos.Line
+		}
+		return "", fmt.Errorf("generated tests are not valid Go: %w\n%s", err, sourceExcerpt(content, line, 3))
+	}
+	formatted, err := format.Source([]byte(content))
+	if err != nil {
+		return "", fmt.Errorf("failed to format generated tests: %w", err)
+	}
+	return string(formatted), nil
+}
+
+// sourceExcerpt numbers the lines of content within context of line,
+// marking line itself, or all of them when line is 0
+func sourceExcerpt(content string, line, context int) string {
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	first, last := 1, len(lines)
+	if line > 0 {
+		first, last = max(line-context, 1), min(line+context, len(lines))
+	}
+
+	var excerpt strings.Builder
+	for n := first; n <= last; n++ {
+		marker := " "
+		if n == line {
+			marker = ">"
+		}
+		fmt.Fprintf(&excerpt, "%s%5d | %s\n", marker, n, lines[n-1])
+	}
+	return strings.TrimSuffix(excerpt.String(), "\n")
+}
+
+// WriteFiles writes rendered files, in path order, creating directories as
+// needed and backing up existing test files first (see preserveExisting).
+// A failing file doesn't stop the others.
+func (tg *TestGenerator) WriteFiles(files map[string]string) error {
+	defer report.Time(report.PhaseWrite)()
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var failures []error
+	for _, path := range paths {
+		quarantine := strings.HasSuffix(path, quarantineSuffix)
+		if !quarantine {
+			if err := tg.preserveExisting(path); err != nil {
+				failures = append(failures, fmt.Errorf("failed to backup existing file %s: %w", path, err))
+				continue
+			}
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			failures = append(failures, fmt.Errorf("failed to create test directory for %s: %w", path, err))
+			continue
+		}
+		if err := os.WriteFile(path, []byte(files[path]), 0644); err != nil {
+			failures = append(failures, fmt.Errorf("failed to write %s: %w", path, err))
+			continue
+		}
+		if !quarantine {
+			report.Infof("Generated tests: %s\n", path)
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%d of %d files failed to write:\n%w", len(failures), len(paths), errors.Join(failures...))
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package generator
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/Eranmonnie/testgen/internal/config"
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// openAIToolName names the function tool mode forces the model to call
+const openAIToolName = "submit_tests"
+
+// schemaSkippedFields are the response fields testgen fills in itself, which
+// the model isn't asked for
+var schemaSkippedFields = map[string]bool{
+	"matched_by":        true,
+	"quarantine_reason": true,
+}
+
+// responseSchema is the strict JSON Schema of models.TestGenerationResponse
+// sent with json_schema and tool requests, derived from its json tags so it
+// follows the model. Strict mode requires every property to be listed as
+// required and objects to be closed.
+func responseSchema() map[string]interface{} {
+	return typeSchema(reflect.TypeOf(models.TestGenerationResponse{}))
+}
+
+// typeSchema renders the schema of a response type
+func typeSchema(typ reflect.Type) map[string]interface{} {
+	switch typ.Kind() {
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		required := []string{}
+		for i := 0; i < typ.NumField(); i++ {
+			name := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+			if name == "" || name == "-" || schemaSkippedFields[name] {
+				continue
+			}
+			properties[name] = typeSchema(typ.Field(i).Type)
+			required = append(required, name)
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"required":             required,
+			"additionalProperties": false,
+		}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": typeSchema(typ.Elem())}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	}
+	if typ == reflect.TypeOf(models.TestType("")) {
+		return map[string]interface{}{"type": "string", "enum": []models.TestType{
+			models.UnitTest, models.IntegrationTest, models.BenchmarkTest, models.ExampleTest, models.FuzzTest,
+		}}
+	}
+	return map[string]interface{}{"type": "string"}
+}
+
+// addOpenAIResponseMode sets the fields of an OpenAI chat completion request
+// that ask for the response in mode: a json_object or strict json_schema
+// response_format, or a forced call of a function taking the response
+func addOpenAIResponseMode(request map[string]interface{}, mode string) {
+	switch mode {
+	case config.OpenAIModeJSONSchema:
+		request["response_format"] = map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name":   "test_generation_response",
+				"strict": true,
+				"schema": responseSchema(),
+			},
+		}
+	case config.OpenAIModeTool:
+		request["tools"] = []map[string]interface{}{{
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":        openAIToolName,
+				"description": "Submit the generated tests",
+				"strict":      true,
+				"parameters":  responseSchema(),
+			},
+		}}
+		request["tool_choice"] = map[string]interface{}{
+			"type":     "function",
+			"function": map[string]string{"name": openAIToolName},
+		}
+	default:
+		request["response_format"] = map[string]string{"type": "json_object"}
+	}
+}
@@ -0,0 +1,84 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxRetryAfter is the longest Retry-After wait honored; a provider asking
+// for more, like a daily quota, fails the call at once
+const maxRetryAfter = 2 * time.Minute
+
+// retryableStatuses are the responses worth another attempt: rate limiting
+// and transient server or gateway failures
+var retryableStatuses = []int{
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// retryAfterError is a failed response that said, in Retry-After, when to
+// try again
+type retryAfterError struct {
+	err  error
+	wait time.Duration
+}
+
+func (e *retryAfterError) Error() string { return e.err.Error() }
+func (e *retryAfterError) Unwrap() error { return e.err }
+
+// retryableStatus reports whether a response with status code could succeed
+// on another attempt
+func retryableStatus(code int) bool {
+	for _, status := range retryableStatuses {
+		if code == status {
+			return true
+		}
+	}
+	return false
+}
+
+// networkTimeout reports whether err is a network timeout, like a dial or
+// TLS handshake that took too long
+func networkTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// parseRetryAfter reads a Retry-After header, in seconds or an HTTP date,
+// as a wait from now; ok is false when it's missing or malformed
+func parseRetryAfter(header string, now time.Time) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second, true
+	}
+	date, err := http.ParseTime(header)
+	if err != nil {
+		return 0, false
+	}
+	return max(date.Sub(now), 0), true
+}
+
+// retryWait returns how long to wait before retrying a call that failed
+// with err: what its Retry-After asked for, else backoff with jitter, from
+// half of it to all of it so concurrent runs don't retry in step
+func retryWait(backoff time.Duration, err error) (time.Duration, error) {
+	var retryAfter *retryAfterError
+	if errors.As(err, &retryAfter) {
+		if retryAfter.wait > maxRetryAfter {
+			return 0, fmt.Errorf("%w (the provider asked to retry after %s; not waiting that long)", err, retryAfter.wait)
+		}
+		return retryAfter.wait, nil
+	}
+	half := backoff / 2
+	return half + rand.N(half+1), nil
+}
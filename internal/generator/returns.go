@@ -0,0 +1,93 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	goparser "go/parser"
+	"go/token"
+
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// returnPatternNote tells the AI how tests of a function returning results
+// of pattern should assert on them, or returns "" when the pattern needs no
+// particular care
+func returnPatternNote(pattern models.ReturnPattern) string {
+	switch pattern {
+	case models.ReturnsValueErr:
+		return "Returns a value and an error: check err first in every case, compare the value only when err is nil, and include at least one case where an error is expected"
+	case models.ReturnsMultiValueErr:
+		return "Returns several values and an error: check err first in every case, then compare every value, not just the first, when err is nil"
+	case models.ReturnsValueOk:
+		return "Returns a value and an ok bool (the comma-ok idiom): cover both a case where ok is true and one where it is false, comparing the value only when ok is true"
+	case models.ReturnsErrOnly:
+		return "Returns only an error: use a table with a wantErr error field and compare with errors.Is(err, tt.wantErr), so nil means success"
+	}
+	return ""
+}
+
+// checkErrorAssertions warns about tests of functions returning a value and
+// an error that throw the error away at every call, as in got, _ := Fn(),
+// since a test that never looks at err can't tell a failure from a zero
+// value
+func checkErrorAssertions(functions []models.FunctionInfo, tests []models.GeneratedTest) []string {
+	var warnings []string
+	for _, test := range tests {
+		target := testTarget(functions, test)
+		if target == nil || target.ReturnPattern != models.ReturnsValueErr {
+			continue
+		}
+		calls, discarded, ok := errorResults(test.Code, target.Name)
+		if !ok || calls == 0 || discarded < calls {
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("%s discards the error %s returns at every call; assert on it", test.Name, targetName(*target)))
+	}
+	return warnings
+}
+
+// errorResults counts the calls to name in code whose results are
+// assigned, and of those the ones whose last result, the error, is
+// assigned to _ or dropped with the rest; ok is false when code doesn't
+// parse
+func errorResults(code, name string) (calls, discarded int, ok bool) {
+	file, err := goparser.ParseFile(token.NewFileSet(), "", snippetPackageHeader+code, 0)
+	if err != nil {
+		return 0, 0, false
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch stmt := n.(type) {
+		case *ast.AssignStmt:
+			if len(stmt.Rhs) != 1 || len(stmt.Lhs) < 2 || !callsName(stmt.Rhs[0], name) {
+				return true
+			}
+			calls++
+			if last, isIdent := stmt.Lhs[len(stmt.Lhs)-1].(*ast.Ident); isIdent && last.Name == "_" {
+				discarded++
+			}
+		case *ast.ExprStmt:
+			if callsName(stmt.X, name) {
+				calls++
+				discarded++
+			}
+		}
+		return true
+	})
+	return calls, discarded, true
+}
+
+// callsName reports whether expr is a call to name, as a function or a
+// method
+func callsName(expr ast.Expr, name string) bool {
+	call, isCall := expr.(*ast.CallExpr)
+	if !isCall {
+		return false
+	}
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		return fun.Name == name
+	case *ast.SelectorExpr:
+		return fun.Sel.Name == name
+	}
+	return false
+}
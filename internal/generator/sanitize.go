@@ -0,0 +1,70 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/format"
+	goparser "go/parser"
+	"go/token"
+	"strings"
+)
+
+// sanitizeImports removes duplicate and unused imports from a rendered test
+// file, which gofmt leaves alone but which stop the package compiling. An
+// import is used when code qualifies a name with its local name: its alias,
+// or its path's default package name as goimports guesses it. Of an aliased
+// and an unaliased import of one path, each stays only if its name is
+// referenced. Blank and dot imports are only deduplicated. Content that
+// needs nothing removed is returned as is; otherwise the imports are
+// rendered as a single block grouped with modulePath.
+func sanitizeImports(content, modulePath string) (string, error) {
+	fset := token.NewFileSet()
+	file, err := goparser.ParseFile(fset, "", content, goparser.ParseComments)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse test file: %w", err)
+	}
+
+	specs := fileImports(file)
+	used := packageQualifiers(file)
+	var kept []importSpec
+	seen := make(map[importSpec]bool)
+	for _, spec := range specs {
+		// "testing" and testing "testing" are the same import
+		key := importSpec{name: spec.localName(), path: spec.path}
+		if spec.name == "_" || spec.name == "." {
+			key.name = spec.name
+		} else if !used[key.name] {
+			continue
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		kept = append(kept, spec)
+	}
+	if len(kept) == len(specs) {
+		return content, nil
+	}
+
+	// The first import declaration becomes the block, the others go
+	var rewritten strings.Builder
+	last := 0
+	for _, decl := range file.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.IMPORT {
+			continue
+		}
+		rewritten.WriteString(content[last:fset.Position(genDecl.Pos()).Offset])
+		if last == 0 {
+			rewritten.WriteString(renderImportBlock(kept, modulePath))
+		}
+		last = fset.Position(genDecl.End()).Offset
+	}
+	rewritten.WriteString(content[last:])
+
+	formatted, err := format.Source([]byte(rewritten.String()))
+	if err != nil {
+		return "", fmt.Errorf("test file is not valid Go without its unused imports: %w", err)
+	}
+	return string(formatted), nil
+}
@@ -0,0 +1,51 @@
+package generator
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// RenderTestFile renders the single test file tests are written to, paired
+// with functions by position, exactly as RenderTestFiles renders it, and
+// returns its path and content without writing anything. Files rendered
+// alongside it, quarantined tests and export_test.go aliases, aren't part
+// of it and are returned as warnings. It fails when the tests span several
+// test files or none of them would be written.
+func (tg *TestGenerator) RenderTestFile(functions []models.FunctionInfo, tests []models.GeneratedTest) (string, string, []Warning, error) {
+	claims := make(packageClaims)
+	paths := make([]string, len(functions))
+	for i, fn := range functions {
+		paths[i] = tg.testOutputPath(fn, claims)
+	}
+	slices.Sort(paths)
+	paths = slices.Compact(paths)
+	if len(paths) != 1 {
+		return "", "", nil, fmt.Errorf("tests go to %d test files (%s); only one can be printed", len(paths), strings.Join(paths, ", "))
+	}
+	path := paths[0]
+
+	files, warnings, err := tg.RenderTestFiles(MatchTestsToFunctions(functions, tests))
+	if err != nil {
+		return "", "", warnings, err
+	}
+	content, ok := files[path]
+	if !ok {
+		return "", "", warnings, fmt.Errorf("no tests for %s are left to print", path)
+	}
+
+	others := make([]string, 0, len(files))
+	for other := range files {
+		if other != path {
+			others = append(others, other)
+		}
+	}
+	sort.Strings(others)
+	for _, other := range others {
+		warnings = append(warnings, Warning{Path: other, Message: fmt.Sprintf("%s goes with these tests but isn't printed; nothing was written", other)})
+	}
+	return path, content, warnings, nil
+}
@@ -0,0 +1,378 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/format"
+	goparser "go/parser"
+	"go/token"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// caseNameFields are the table struct fields, lowercased, that usually name
+// a case when t.Run doesn't say which one does
+var caseNameFields = []string{"name", "desc", "description", "scenario", "title", "tc"}
+
+// subtestTable is the table of a table-driven test and how its cases are
+// named
+type subtestTable struct {
+	field   string   // struct field naming each case, "" when map keys do
+	names   []string // case names, "" for cases not named by a string literal
+	caseVar string   // the loop variable a case's name is read from
+	stubbed bool     // whether a case with only a name set is valid Go
+
+	runT    string // the *testing.T of the t.Run callback in the loop over the table, "" when there's none
+	runBody int    // offset in the code just inside the callback's opening brace
+}
+
+// checkCoverageSubtests warns about table-driven tests whose Coverage lists
+// scenarios no table case is named for, comparing names and scenarios
+// lowercased with punctuation as underscores, the way t.Run names
+// subtests. With stub, the missing scenarios are added as cases that skip
+// with a TODO, where the table allows it. It returns a warning per test.
+func checkCoverageSubtests(tests []models.GeneratedTest, stub bool) []string {
+	var warnings []string
+	for i := range tests {
+		if len(tests[i].Coverage) == 0 {
+			continue
+		}
+		table := findSubtestTable(tests[i].Code)
+		if table == nil || !slices.ContainsFunc(table.names, func(name string) bool { return name != "" }) {
+			continue
+		}
+		missing := missingScenarios(tests[i].Coverage, table.names)
+		if len(missing) == 0 {
+			continue
+		}
+
+		if stub {
+			if code, err := stubScenarios(tests[i].Code, table, missing); err == nil {
+				tests[i].Code = code
+				warnings = append(warnings, fmt.Sprintf("%s: added %d skipped TODO cases for coverage scenarios it had no subtest for: %s",
+					tests[i].Name, len(missing), strings.Join(missing, ", ")))
+				continue
+			}
+		}
+		warnings = append(warnings, fmt.Sprintf("%s: coverage lists scenarios no subtest is named for: %s", tests[i].Name, strings.Join(missing, ", ")))
+	}
+	return warnings
+}
+
+// missingScenarios returns the scenarios, deduplicated, that no case name
+// matches: equal once normalized, or one containing the other
+func missingScenarios(scenarios, names []string) []string {
+	var normalizedNames []string
+	for _, name := range names {
+		if normalized := normalizeScenario(name); normalized != "" {
+			normalizedNames = append(normalizedNames, normalized)
+		}
+	}
+
+	var missing []string
+	seen := make(map[string]bool)
+	for _, scenario := range scenarios {
+		normalized := normalizeScenario(scenario)
+		if normalized == "" || seen[normalized] {
+			continue
+		}
+		seen[normalized] = true
+		matched := false
+		for _, name := range normalizedNames {
+			if strings.Contains(name, normalized) || strings.Contains(normalized, name) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			missing = append(missing, strings.TrimSpace(scenario))
+		}
+	}
+	return missing
+}
+
+// normalizeScenario lowercases s and turns each run of other characters
+// than letters and digits into one underscore
+func normalizeScenario(s string) string {
+	var normalized strings.Builder
+	pending := false
+	for _, r := range strings.ToLower(s) {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			pending = normalized.Len() > 0
+			continue
+		}
+		if pending {
+			normalized.WriteByte('_')
+			pending = false
+		}
+		normalized.WriteRune(r)
+	}
+	return normalized.String()
+}
+
+// findSubtestTable finds the table of the first table-driven test in code
+// and the names of its cases, or returns nil when there is none or its
+// cases' names can't be told
+func findSubtestTable(code string) *subtestTable {
+	fset := token.NewFileSet()
+	file, err := goparser.ParseFile(fset, "", snippetPackageHeader+code, 0)
+	if err != nil {
+		return nil
+	}
+	for _, decl := range file.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Body == nil || funcDecl.Recv != nil {
+			continue
+		}
+		lit := findTestTable(funcDecl.Body)
+		if lit == nil {
+			continue
+		}
+		table := &subtestTable{}
+		loop := tableLoop(funcDecl.Body, lit)
+
+		// What t.Run names subtests after, as case.field or the map key
+		var runName ast.Expr
+		if loop != nil {
+			var callback *ast.FuncLit
+			if runName, callback = runCall(loop.Body); callback != nil {
+				if params := callback.Type.Params.List; len(params) == 1 && len(params[0].Names) == 1 && isIdent(params[0].Names[0]) {
+					table.runT = params[0].Names[0].Name
+					table.runBody = fset.Position(callback.Body.Lbrace).Offset + 1 - len(snippetPackageHeader)
+				}
+			}
+		}
+
+		if mapType, ok := lit.Type.(*ast.MapType); ok {
+			if loop == nil || !isIdent(loop.Key) {
+				return nil
+			}
+			table.caseVar = loop.Key.(*ast.Ident).Name
+			_, table.stubbed = mapType.Value.(*ast.StructType)
+			for _, elt := range lit.Elts {
+				if kv, ok := elt.(*ast.KeyValueExpr); ok {
+					table.names = append(table.names, stringLiteral(kv.Key))
+				}
+			}
+			return table
+		}
+
+		fields := structFields(file, lit.Type.(*ast.ArrayType).Elt)
+		if len(fields) == 0 {
+			return nil
+		}
+		if selector, ok := runName.(*ast.SelectorExpr); ok && loop != nil && isIdent(loop.Value) &&
+			isIdent(selector.X) && selector.X.(*ast.Ident).Name == loop.Value.(*ast.Ident).Name {
+			table.field = selector.Sel.Name
+		} else {
+			table.field = caseNameField(fields)
+		}
+		index := fieldIndex(fields, table.field)
+		if index < 0 {
+			return nil
+		}
+		if loop != nil && isIdent(loop.Value) {
+			table.caseVar = loop.Value.(*ast.Ident).Name
+		}
+		table.stubbed = true
+
+		for _, elt := range lit.Elts {
+			table.names = append(table.names, caseName(elt, table.field, index))
+		}
+		return table
+	}
+	return nil
+}
+
+// tableField is a field of a table's case struct
+type tableField struct {
+	name    string
+	typeStr string
+}
+
+// structFields lists the fields of a case struct, given inline or as a
+// type declared in file
+func structFields(file *ast.File, elt ast.Expr) []tableField {
+	if star, ok := elt.(*ast.StarExpr); ok {
+		elt = star.X
+	}
+	if ident, ok := elt.(*ast.Ident); ok {
+		elt = nil
+		ast.Inspect(file, func(n ast.Node) bool {
+			if spec, ok := n.(*ast.TypeSpec); ok && spec.Name.Name == ident.Name {
+				elt = spec.Type
+			}
+			return elt == nil
+		})
+	}
+	structType, ok := elt.(*ast.StructType)
+	if !ok {
+		return nil
+	}
+
+	var fields []tableField
+	for _, field := range structType.Fields.List {
+		typeStr := ""
+		if ident, ok := field.Type.(*ast.Ident); ok {
+			typeStr = ident.Name
+		}
+		for _, name := range field.Names {
+			fields = append(fields, tableField{name: name.Name, typeStr: typeStr})
+		}
+	}
+	return fields
+}
+
+// caseNameField picks the field naming each case: one called like
+// caseNameFields, else the first string field
+func caseNameField(fields []tableField) string {
+	for _, candidate := range caseNameFields {
+		for _, field := range fields {
+			if strings.ToLower(field.name) == candidate && field.typeStr == "string" {
+				return field.name
+			}
+		}
+	}
+	for _, field := range fields {
+		if field.typeStr == "string" {
+			return field.name
+		}
+	}
+	return ""
+}
+
+// fieldIndex returns the position of the field called name, or -1
+func fieldIndex(fields []tableField, name string) int {
+	for i, field := range fields {
+		if field.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// caseName returns the name a table case gives field, which is at index
+// for cases listing their values in order
+func caseName(elt ast.Expr, field string, index int) string {
+	if unary, ok := elt.(*ast.UnaryExpr); ok {
+		elt = unary.X
+	}
+	lit, ok := elt.(*ast.CompositeLit)
+	if !ok {
+		return ""
+	}
+	for i, value := range lit.Elts {
+		if kv, ok := value.(*ast.KeyValueExpr); ok {
+			if key, ok := kv.Key.(*ast.Ident); ok && key.Name == field {
+				return stringLiteral(kv.Value)
+			}
+			continue
+		}
+		if i == index {
+			return stringLiteral(value)
+		}
+	}
+	return ""
+}
+
+// tableLoop finds the range loop over lit, directly or through the
+// variable it's assigned to
+func tableLoop(body *ast.BlockStmt, lit *ast.CompositeLit) *ast.RangeStmt {
+	var loop *ast.RangeStmt
+	ast.Inspect(body, func(n ast.Node) bool {
+		rangeStmt, ok := n.(*ast.RangeStmt)
+		if !ok || loop != nil {
+			return loop == nil
+		}
+		switch x := rangeStmt.X.(type) {
+		case *ast.CompositeLit:
+			if x == lit {
+				loop = rangeStmt
+			}
+		case *ast.Ident:
+			if findTableVariable(body, x.Name) == lit {
+				loop = rangeStmt
+			}
+		}
+		return true
+	})
+	return loop
+}
+
+// runCall finds the first x.Run(name, func(...) {...}) call in body and
+// returns its name argument and callback
+func runCall(body *ast.BlockStmt) (ast.Expr, *ast.FuncLit) {
+	var name ast.Expr
+	var callback *ast.FuncLit
+	ast.Inspect(body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || callback != nil {
+			return callback == nil
+		}
+		selector, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || selector.Sel.Name != "Run" || len(call.Args) != 2 {
+			return true
+		}
+		if funcLit, ok := call.Args[1].(*ast.FuncLit); ok {
+			name, callback = call.Args[0], funcLit
+		}
+		return true
+	})
+	return name, callback
+}
+
+// stubScenarios adds a case named for each scenario to table, skipped
+// with a TODO by a switch at the top of the t.Run callback
+func stubScenarios(code string, table *subtestTable, scenarios []string) (string, error) {
+	if table.runT == "" || table.caseVar == "" || !table.stubbed {
+		return "", errNoTestTable
+	}
+
+	quoted := make([]string, len(scenarios))
+	entries := make([]string, len(scenarios))
+	for i, scenario := range scenarios {
+		quoted[i] = strconv.Quote(scenario)
+		if table.field == "" {
+			entries[i] = quoted[i] + ": {}"
+		} else {
+			entries[i] = fmt.Sprintf("{%s: %s}", table.field, quoted[i])
+		}
+	}
+	caseName := table.caseVar
+	if table.field != "" {
+		caseName += "." + table.field
+	}
+	guard := fmt.Sprintf("\nswitch %s {\ncase %s:\n%s.Skip(\"TODO: listed in the test's coverage but not written yet\")\n}\n",
+		caseName, strings.Join(quoted, ", "), table.runT)
+
+	// insertTableEntries finds the table again in the guarded code
+	formatted, err := format.Source([]byte(snippetPackageHeader + code[:table.runBody] + guard + code[table.runBody:]))
+	if err != nil {
+		return "", err
+	}
+	return insertTableEntries(strings.TrimPrefix(string(formatted), snippetPackageHeader), entries)
+}
+
+// stringLiteral returns the value of a string literal, or "" for anything
+// else
+func stringLiteral(expr ast.Expr) string {
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return ""
+	}
+	value, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return ""
+	}
+	return value
+}
+
+// isIdent reports whether expr is a plain identifier other than _
+func isIdent(expr ast.Expr) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.Name != "_"
+}
@@ -0,0 +1,235 @@
+package generator
+
+import (
+	"cmp"
+	"fmt"
+	"go/ast"
+	goparser "go/parser"
+	"go/token"
+	"slices"
+	"strings"
+
+	"github.com/Eranmonnie/testgen/internal/parser"
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// targetName names fn as target_function does: Name, or Type.Method for
+// methods
+func targetName(fn models.FunctionInfo) string {
+	if fn.Receiver != nil {
+		return parser.BaseTypeName(fn.Receiver.Type) + "." + fn.Name
+	}
+	return fn.Name
+}
+
+// resolveTarget finds the function among functions that test exercises,
+// trying in turn: its target_function field, its name (TestName...,
+// TestType_Method...), then the single target its code calls, preferring the
+// one its name mentions when it calls several. Each step only resolves a
+// test to exactly one function; ambiguity falls through to the next. It
+// returns the function's index and how it was found, or -1 and
+// models.MatchUnresolved.
+func resolveTarget(functions []models.FunctionInfo, test models.GeneratedTest) (int, models.MatchMethod) {
+	if i := matchTargetField(functions, test.TargetFunction); i >= 0 {
+		return i, models.MatchByTargetFunction
+	}
+	if i := matchTestName(functions, test.Name); i >= 0 {
+		return i, models.MatchByName
+	}
+	if i := matchCalls(functions, test); i >= 0 {
+		return i, models.MatchByCalls
+	}
+	return -1, models.MatchUnresolved
+}
+
+// matchTargetField returns the function a target_function value names, as
+// Name or Type.Method (pointer receivers and call parentheses tolerated), or
+// -1 when it names none or several
+func matchTargetField(functions []models.FunctionInfo, target string) int {
+	target = strings.TrimSuffix(strings.TrimSpace(target), "()")
+	target = strings.NewReplacer("(", "", ")", "", "*", "").Replace(target)
+	if target == "" {
+		return -1
+	}
+	return unique(functions, func(fn models.FunctionInfo) bool {
+		return targetName(fn) == target || (!strings.Contains(target, ".") && fn.Name == target)
+	})
+}
+
+// matchTestName returns the function a test's name is named after, or -1.
+// TestType_Method matches that method outright; otherwise the longest
+// function name the rest of the test name starts with wins, case-insensitive
+// in its first letter so TestValidate matches validate.
+func matchTestName(functions []models.FunctionInfo, name string) int {
+	rest := entryPointSuffix(name)
+	if rest == "" {
+		return -1
+	}
+	if i := unique(functions, func(fn models.FunctionInfo) bool {
+		return fn.Receiver != nil && strings.HasPrefix(rest, upperFirst(parser.BaseTypeName(fn.Receiver.Type))+"_"+upperFirst(fn.Name))
+	}); i >= 0 {
+		return i
+	}
+
+	longest := 0
+	for _, fn := range functions {
+		if strings.HasPrefix(rest, upperFirst(fn.Name)) {
+			longest = max(longest, len(fn.Name))
+		}
+	}
+	if longest == 0 {
+		return -1
+	}
+	return unique(functions, func(fn models.FunctionInfo) bool {
+		return len(fn.Name) == longest && strings.HasPrefix(rest, upperFirst(fn.Name))
+	})
+}
+
+// matchCalls returns the target function the test code calls, or, when it
+// calls several, the only one of those its name mentions; -1 otherwise
+func matchCalls(functions []models.FunctionInfo, test models.GeneratedTest) int {
+	file, err := goparser.ParseFile(token.NewFileSet(), "", snippetPackageHeader+test.Code, goparser.SkipObjectResolution)
+	if err != nil {
+		return -1
+	}
+
+	// Plain calls can only reach functions; selector calls reach methods, or
+	// functions through the package name in an external test package
+	called := make(map[int]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		for i, fn := range functions {
+			switch fun := call.Fun.(type) {
+			case *ast.Ident:
+				if fn.Receiver == nil && fun.Name == fn.Name {
+					called[i] = true
+				}
+			case *ast.SelectorExpr:
+				if fun.Sel.Name == fn.Name {
+					called[i] = true
+				}
+			}
+		}
+		return true
+	})
+	if len(called) == 1 {
+		for i := range called {
+			return i
+		}
+	}
+
+	rest := entryPointSuffix(test.Name)
+	mentioned := -1
+	for i := range called {
+		if !strings.Contains(rest, upperFirst(functions[i].Name)) {
+			continue
+		}
+		if mentioned >= 0 {
+			return -1
+		}
+		mentioned = i
+	}
+	return mentioned
+}
+
+// entryPointSuffix returns a test name after its Test, Benchmark, Fuzz or
+// Example prefix and any underscores following it, upper-cased like
+// upperFirst, or "" when it has none
+func entryPointSuffix(name string) string {
+	for _, prefix := range testEntryPrefixes {
+		if rest, ok := strings.CutPrefix(name, prefix); ok {
+			return upperFirst(strings.TrimLeft(rest, "_"))
+		}
+	}
+	return ""
+}
+
+// unique returns the index of the only function matching, or -1 when none
+// or several do
+func unique(functions []models.FunctionInfo, match func(models.FunctionInfo) bool) int {
+	found := -1
+	for i, fn := range functions {
+		if !match(fn) {
+			continue
+		}
+		if found >= 0 {
+			return -1
+		}
+		found = i
+	}
+	return found
+}
+
+// resolveTestTargets records the function each test exercises, as its
+// TargetFunction, and how it was found, as its MatchedBy. It returns a
+// warning per test that couldn't be resolved, since those aren't written.
+// Without functions to resolve against, tests are left as they are.
+func resolveTestTargets(functions []models.FunctionInfo, tests []models.GeneratedTest) []string {
+	if len(functions) == 0 {
+		return nil
+	}
+	var warnings []string
+	for i := range tests {
+		index, method := resolveTarget(functions, tests[i])
+		tests[i].MatchedBy = method
+		if index < 0 {
+			warnings = append(warnings, fmt.Sprintf("%s: couldn't tell which function it tests from its target_function, name or calls; it won't be written", tests[i].Name))
+			continue
+		}
+		tests[i].TargetFunction = targetName(functions[index])
+	}
+	return warnings
+}
+
+// sourceOrder returns functions as they appear in the source, by file and
+// then by line, leaving functions itself alone. Functions at the same
+// position keep their order.
+func sourceOrder(functions []models.FunctionInfo) []models.FunctionInfo {
+	ordered := slices.Clone(functions)
+	slices.SortStableFunc(ordered, compareSourceOrder)
+	return ordered
+}
+
+// compareSourceOrder compares functions by file, then by line
+func compareSourceOrder(a, b models.FunctionInfo) int {
+	return cmp.Or(strings.Compare(a.File, b.File), cmp.Compare(a.StartLine, b.StartLine))
+}
+
+// testTarget returns the function a generated test exercises, or nil when
+// it can't be resolved
+func testTarget(functions []models.FunctionInfo, test models.GeneratedTest) *models.FunctionInfo {
+	if i, _ := resolveTarget(functions, test); i >= 0 {
+		return &functions[i]
+	}
+	return nil
+}
+
+// PairTests pairs each of an AI response's tests with the function it
+// exercises, as resolveTarget finds it, for the positional WriteTestFiles,
+// RenderTestFiles and friends. Tests that can't be resolved are left out.
+func (tg *TestGenerator) PairTests(functions []models.FunctionInfo, tests []models.GeneratedTest) ([]models.FunctionInfo, []models.GeneratedTest) {
+	var pairedFunctions []models.FunctionInfo
+	var pairedTests []models.GeneratedTest
+	for _, test := range tests {
+		if i, _ := resolveTarget(functions, test); i >= 0 {
+			pairedFunctions = append(pairedFunctions, functions[i])
+			pairedTests = append(pairedTests, test)
+		}
+	}
+	return pairedFunctions, pairedTests
+}
+
+// MatchCounts counts how a run's tests were resolved to their functions, by
+// MatchedBy
+func MatchCounts(tests []models.GeneratedTest) map[models.MatchMethod]int {
+	counts := make(map[models.MatchMethod]int)
+	for _, test := range tests {
+		if test.MatchedBy != "" {
+			counts[test.MatchedBy]++
+		}
+	}
+	return counts
+}
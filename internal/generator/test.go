@@ -2,9 +2,11 @@ package generator
 
 import (
 	"bytes"
+	"cmp"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -12,6 +14,9 @@ import (
 	"time"
 
 	"github.com/Eranmonnie/testgen/internal/config"
+	"github.com/Eranmonnie/testgen/internal/httpcache"
+	"github.com/Eranmonnie/testgen/internal/parser"
+	"github.com/Eranmonnie/testgen/internal/report"
 	"github.com/Eranmonnie/testgen/pkg/models"
 )
 
@@ -19,102 +24,163 @@ import (
 type TestGenerator struct {
 	config *config.Config
 	client *http.Client
+
+	ctx            context.Context // bounds the whole run (--timeout)
+	requestTimeout time.Duration   // bounds each API call (0 = no limit)
+	readTimeout    time.Duration   // bounds reading a response once its headers arrive (0 = no limit)
+
+	maxResponseBytes int64 // largest response body read
+
+	maxRetries   int           // retries of a call that failed transiently
+	retryBackoff time.Duration // wait before the first retry, doubling per retry
+
+	fewShot *string // rendered ai.few_shot_examples, loaded on first use
 }
 
 // NewTestGenerator creates a new test generator
 func NewTestGenerator(cfg *config.Config) *TestGenerator {
 	return &TestGenerator{
-		config: cfg,
-		client: &http.Client{
-			Timeout: time.Duration(cfg.AI.Timeout) * time.Second,
-		},
+		config:         cfg,
+		client:         &http.Client{Transport: httpcache.New(httpcache.Dir, time.Duration(cfg.AI.MetadataCacheMaxAge)*time.Second, nil)},
+		ctx:            context.Background(),
+		requestTimeout: time.Duration(cfg.AI.RequestTimeout) * time.Second,
+		readTimeout:    time.Duration(cfg.AI.ReadTimeout) * time.Second,
+
+		maxResponseBytes: int64(cmp.Or(cfg.AI.MaxResponseBytes, config.DefaultMaxResponseBytes)),
+
+		maxRetries:   cfg.AI.MaxRetries,
+		retryBackoff: time.Duration(cmp.Or(cfg.AI.RetryBackoffSeconds, config.DefaultRetryBackoffSeconds) * float64(time.Second)),
 	}
 }
 
+// SetContext bounds every API call the generator makes by ctx, e.g. a
+// run-level deadline. Calls cut short by ctx are not retried.
+func (tg *TestGenerator) SetContext(ctx context.Context) {
+	tg.ctx = ctx
+}
+
 // GenerateTests generates tests for the given functions
 func (tg *TestGenerator) GenerateTests(request models.TestGenerationRequest) (*models.TestGenerationResponse, error) {
+	prompt, reductions := tg.fitPrompt(request)
+	tg.reportPrompt(prompt, reductions)
+	return tg.respond(request, prompt)
+}
+
+// respond sends request's rendered prompt and validates the response
+func (tg *TestGenerator) respond(request models.TestGenerationRequest, prompt string) (*models.TestGenerationResponse, error) {
+	temperature := tg.config.AI.TemperatureFor(string(requestedTestType(request)))
+	response, err := tg.sendPrompt(prompt, temperature)
+	if err != nil {
+		return nil, err
+	}
+
+	if tg.config.Output.FlakyTests == FlakyRepair {
+		tg.repairFlakyTests(prompt, temperature, response)
+	}
+	tg.postValidate(request, response)
+	return response, nil
+}
+
+// sendPrompt sends a rendered prompt to the configured AI provider, sampled
+// at temperature
+func (tg *TestGenerator) sendPrompt(prompt string, temperature float64) (*models.TestGenerationResponse, error) {
+	defer report.Time(report.PhaseProvider)()
+	// Checked again here so nothing reaches a provider the environment forbids
+	if err := tg.config.AI.CheckProviderAllowed(); err != nil {
+		return nil, err
+	}
+
 	switch tg.config.AI.Provider {
 	case "openai":
-		return tg.generateWithOpenAI(request)
+		return tg.generateWithOpenAI(prompt, temperature)
 	case "anthropic":
-		return tg.generateWithAnthropic(request)
+		return tg.generateWithAnthropic(prompt, temperature)
 	case "local":
-		return tg.generateWithLocal(request)
+		return tg.generateWithLocal(prompt, temperature)
 	case "groq":
-		return tg.generateWithGroq(request)
+		return tg.generateWithGroq(prompt, temperature)
 	default:
 		return nil, fmt.Errorf("unsupported AI provider: %s", tg.config.AI.Provider)
 	}
 }
 
-// WriteTestFiles writes generated tests to files
-func (tg *TestGenerator) WriteTestFiles(functions []models.FunctionInfo, tests []models.GeneratedTest) error {
-	// Group tests by source file
-	testsByFile := make(map[string][]models.GeneratedTest)
-	functionsByFile := make(map[string][]models.FunctionInfo)
-
-	for i, fn := range functions {
-		if i < len(tests) {
-			testsByFile[fn.File] = append(testsByFile[fn.File], tests[i])
-			functionsByFile[fn.File] = append(functionsByFile[fn.File], fn)
+// postValidate checks generated tests against project conventions, fixing
+// what it can and recording anything else as response warnings
+func (tg *TestGenerator) postValidate(request models.TestGenerationRequest, response *models.TestGenerationResponse) {
+	defer report.Time(report.PhaseValidate)()
+	// Before any renames, so test names are the AI's own
+	response.Warnings = append(response.Warnings, resolveTestTargets(request.Functions, response.Tests)...)
+	response.Warnings = append(response.Warnings, tg.enforceTestNameStyle(response.Tests)...)
+	enforceHelperCalls(response.Tests)
+	response.Warnings = append(response.Warnings, tg.summarizedBodyWarnings(request.Functions)...)
+	response.Warnings = append(response.Warnings, quarantineRiskyTests(request.Functions, response.Tests)...)
+	response.Warnings = append(response.Warnings, tg.checkFlakyTests(response.Tests)...)
+	response.Warnings = append(response.Warnings, injectFuzzSeeds(request.Functions, response.Tests)...)
+	response.Warnings = append(response.Warnings, checkCoverageSubtests(response.Tests, tg.config.Output.StubMissingCoverage)...)
+	response.Warnings = append(response.Warnings, checkGenericInstantiations(request.Functions, response.Tests)...)
+	response.Warnings = append(response.Warnings, checkErrorAssertions(request.Functions, response.Tests)...)
+
+	if request.Context.GoVersion != "" {
+		for _, test := range response.Tests {
+			response.Warnings = append(response.Warnings, checkGoVersionFeatures(test, request.Context.GoVersion)...)
 		}
 	}
+}
 
-	// Write test files
-	for sourceFile, fileTests := range testsByFile {
-		if err := tg.writeTestFile(sourceFile, functionsByFile[sourceFile], fileTests); err != nil {
-			return fmt.Errorf("failed to write test file for %s: %w", sourceFile, err)
-		}
+// WriteTestFiles renders generated tests with RenderTestFiles and writes
+// the files, reporting the render warnings
+func (tg *TestGenerator) WriteTestFiles(functions []models.FunctionInfo, tests []models.GeneratedTest) error {
+	files, warnings, renderErr := tg.RenderTestFiles(MatchTestsToFunctions(functions, tests))
+	for _, warning := range warnings {
+		report.Warnf("%s\n", warning.Message)
 	}
 
-	return nil
+	// Files that rendered are written even when others failed
+	if err := tg.WriteFiles(files); err != nil {
+		return errors.Join(renderErr, err)
+	}
+	return renderErr
 }
 
 // generateWithOpenAI generates tests using OpenAI API
-func (tg *TestGenerator) generateWithOpenAI(request models.TestGenerationRequest) (*models.TestGenerationResponse, error) {
+func (tg *TestGenerator) generateWithOpenAI(prompt string, temperature float64) (*models.TestGenerationResponse, error) {
 	if tg.config.AI.APIKey == "" {
 		return nil, fmt.Errorf("OpenAI API key not configured")
 	}
 
-	prompt := tg.buildPrompt(request)
-
 	// OpenAI API request structure
 	openAIRequest := map[string]interface{}{
 		"model": tg.config.AI.Model,
 		"messages": []map[string]string{
 			{
 				"role":    "system",
-				"content": "You are an expert Go test writer. Generate comprehensive, idiomatic Go tests based on the provided function information.",
+				"content": openAISystemMessage,
 			},
 			{
 				"role":    "user",
 				"content": prompt,
 			},
 		},
-		"temperature": tg.config.AI.Temperature,
+		"temperature": temperature,
 		"max_tokens":  tg.config.AI.MaxTokens,
-		"response_format": map[string]string{
-			"type": "json_object",
-		},
 	}
+	addOpenAIResponseMode(openAIRequest, tg.config.AI.ResponseMode())
 
 	// Fixed: Pass separate header name and value
-	return tg.makeAPIRequest("https://api.openai.com/v1/chat/completions", openAIRequest, "Authorization", "Bearer "+tg.config.AI.APIKey)
+	return tg.makeAPIRequest(tg.baseURL(config.DefaultOpenAIURL)+"/chat/completions", openAIRequest, "Authorization", "Bearer "+tg.config.AI.APIKey)
 }
 
 // generateWithAnthropic generates tests using Anthropic Claude API
-func (tg *TestGenerator) generateWithAnthropic(request models.TestGenerationRequest) (*models.TestGenerationResponse, error) {
+func (tg *TestGenerator) generateWithAnthropic(prompt string, temperature float64) (*models.TestGenerationResponse, error) {
 	if tg.config.AI.APIKey == "" {
 		return nil, fmt.Errorf("Anthropic API key not configured")
 	}
 
-	prompt := tg.buildPrompt(request)
-
 	// Anthropic API request structure
 	anthropicRequest := map[string]interface{}{
 		"model":       tg.config.AI.Model,
 		"max_tokens":  tg.config.AI.MaxTokens,
-		"temperature": tg.config.AI.Temperature,
+		"temperature": temperature,
 		"messages": []map[string]string{
 			{
 				"role":    "user",
@@ -127,50 +193,94 @@ func (tg *TestGenerator) generateWithAnthropic(request models.TestGenerationRequ
 	return tg.makeAPIRequest("https://api.anthropic.com/v1/messages", anthropicRequest, "x-api-key", tg.config.AI.APIKey)
 }
 
-// generateWithLocal generates tests using local AI (placeholder)
-func (tg *TestGenerator) generateWithLocal(request models.TestGenerationRequest) (*models.TestGenerationResponse, error) {
-	// This would integrate with local models like Ollama, LM Studio, etc.
-	return nil, fmt.Errorf("local AI provider not implemented yet")
+// baseURL returns ai.base_url, or defaultURL when it isn't set, without a
+// trailing slash
+func (tg *TestGenerator) baseURL(defaultURL string) string {
+	return strings.TrimSuffix(cmp.Or(tg.config.AI.BaseURL, defaultURL), "/")
+}
+
+// generateWithLocal generates tests using an Ollama server at ai.base_url,
+// which needs no API key; one that is set is sent as a bearer token, for
+// servers behind an authenticating proxy
+func (tg *TestGenerator) generateWithLocal(prompt string, temperature float64) (*models.TestGenerationResponse, error) {
+	baseURL := tg.baseURL(config.DefaultOllamaURL)
+
+	// Ollama chat request, asking for one JSON response rather than a stream
+	ollamaRequest := map[string]interface{}{
+		"model": tg.config.AI.Model,
+		"messages": []map[string]string{
+			{
+				"role":    "system",
+				"content": localSystemMessage,
+			},
+			{
+				"role":    "user",
+				"content": prompt,
+			},
+		},
+		"stream": false,
+		"format": "json",
+		"options": map[string]interface{}{
+			"temperature": temperature,
+			"num_predict": tg.config.AI.MaxTokens,
+		},
+	}
+
+	authHeaderName, authHeaderValue := "", ""
+	if tg.config.AI.APIKey != "" {
+		authHeaderName, authHeaderValue = "Authorization", "Bearer "+tg.config.AI.APIKey
+	}
+	response, err := tg.makeAPIRequest(baseURL+"/api/chat", ollamaRequest, authHeaderName, authHeaderValue)
+	if err != nil && ProviderUnreachable(err) {
+		return nil, fmt.Errorf("can't reach the Ollama server at %s (is `ollama serve` running, and ai.base_url right?): %w", baseURL, err)
+	}
+	return response, err
 }
 
 // Add Groq provider
-func (tg *TestGenerator) generateWithGroq(request models.TestGenerationRequest) (*models.TestGenerationResponse, error) {
+func (tg *TestGenerator) generateWithGroq(prompt string, temperature float64) (*models.TestGenerationResponse, error) {
 	if tg.config.AI.APIKey == "" {
 		return nil, fmt.Errorf("Groq API key not configured")
 	}
 
-	prompt := tg.buildPrompt(request)
-
 	// Groq API request (OpenAI-compatible)
 	groqRequest := map[string]interface{}{
 		"model": tg.config.AI.Model, // e.g., "llama3-8b-8192"
 		"messages": []map[string]string{
 			{
 				"role":    "system",
-				"content": "You are an expert Go test writer. Generate comprehensive, idiomatic Go tests.",
+				"content": groqSystemMessage,
 			},
 			{
 				"role":    "user",
 				"content": prompt,
 			},
 		},
-		"temperature": tg.config.AI.Temperature,
+		"temperature": temperature,
 		"max_tokens":  tg.config.AI.MaxTokens,
 	}
 
-	return tg.makeAPIRequest("https://api.groq.com/openai/v1/chat/completions", groqRequest, "Authorization", "Bearer "+tg.config.AI.APIKey)
+	return tg.makeAPIRequest(tg.baseURL(config.DefaultGroqURL)+"/chat/completions", groqRequest, "Authorization", "Bearer "+tg.config.AI.APIKey)
 }
 
 // filepath: [test.go](http://_vscodecontentref_/0)
-// buildPrompt creates the AI prompt from the request
+// buildPrompt creates the AI prompt from the request, trimmed to fit
+// ai.max_prompt_tokens
 func (tg *TestGenerator) buildPrompt(request models.TestGenerationRequest) string {
+	prompt, _ := tg.fitPrompt(request)
+	return prompt
+}
+
+// renderPrompt renders the prompt for a draft, whatever its size
+func (tg *TestGenerator) renderPrompt(draft promptDraft) string {
+	request := draft.request
 	var prompt strings.Builder
 
 	prompt.WriteString("Generate comprehensive Go tests for the following functions. ")
 	prompt.WriteString("You must return ONLY a valid JSON object with no markdown formatting, no code blocks, and no backticks.\n\n")
 
 	// Determine if tests will be in same directory/package
-	samePackage := tg.config.Output.Directory == ""
+	samePackage := tg.config.Output.SamePackage()
 
 	// Add testing requirements
 	prompt.WriteString("Testing Requirements:\n")
@@ -178,7 +288,19 @@ func (tg *TestGenerator) buildPrompt(request models.TestGenerationRequest) strin
 	prompt.WriteString("- IMPORTANT: Do NOT use external assertion libraries (no testify, assert, etc.)\n")
 	prompt.WriteString("- Use t.Error(), t.Errorf(), t.Fatal(), t.Fatalf() for assertions\n")
 	prompt.WriteString("- Follow Go testing conventions and best practices\n")
-	prompt.WriteString("- Test function names should be descriptive (TestFunctionName_Scenario)\n")
+	prompt.WriteString(fmt.Sprintf("- Test function names must follow this convention: %s\n", testNameConvention(tg.testNameStyle())))
+	for _, guidance := range goVersionGuidance(request.Context.GoVersion) {
+		prompt.WriteString(fmt.Sprintf("- %s\n", guidance))
+	}
+	for _, guidance := range tg.parallelGuidance() {
+		prompt.WriteString(fmt.Sprintf("- %s\n", guidance))
+	}
+	if guidance, ok := testTypeGuidance[requestedTestType(request)]; ok {
+		prompt.WriteString(fmt.Sprintf("- %s\n", guidance))
+	}
+	for _, guidance := range importGuidance(request.Context) {
+		prompt.WriteString(fmt.Sprintf("- %s\n", guidance))
+	}
 
 	if samePackage {
 		prompt.WriteString("- Tests will be in the SAME package as the source code\n")
@@ -187,6 +309,9 @@ func (tg *TestGenerator) buildPrompt(request models.TestGenerationRequest) strin
 		prompt.WriteString("- Tests will be in a SEPARATE package/directory\n")
 		prompt.WriteString("- Import the source package and use qualified function calls\n")
 		prompt.WriteString(fmt.Sprintf("- Import the package being tested: \"%s\"\n", request.Context.PackageName))
+		if tg.config.Output.ExternalPackage {
+			prompt.WriteString("- Never reference unexported identifiers directly; use the export_test.go aliases listed with a function instead\n")
+		}
 	}
 	prompt.WriteString("\n")
 
@@ -200,23 +325,43 @@ func (tg *TestGenerator) buildPrompt(request models.TestGenerationRequest) strin
 	prompt.WriteString("  \"warnings\": [\"any concerns\"]\n")
 	prompt.WriteString("}\n\n")
 
+	// Repository content below is data, never instructions
+	prompt.WriteString(untrustedDataNotice + "\n")
+
 	// Add context information
 	prompt.WriteString("Project Context:\n")
 	prompt.WriteString(fmt.Sprintf("- Package: %s\n", request.Context.PackageName))
 	prompt.WriteString(fmt.Sprintf("- Project: %s\n", request.Context.ProjectName))
 
+	if request.Context.GoVersion != "" {
+		prompt.WriteString(fmt.Sprintf("- Go version: %s (IMPORTANT: do not use language or standard library features newer than go %s)\n",
+			request.Context.GoVersion, request.Context.GoVersion))
+	}
+
 	if len(request.Context.Imports) > 0 {
 		prompt.WriteString(fmt.Sprintf("- Imports: %s\n", strings.Join(request.Context.Imports, ", ")))
 	}
 
-	if request.Context.GitContext.CommitMessage != "" {
-		prompt.WriteString(fmt.Sprintf("- Recent commit: %s\n", request.Context.GitContext.CommitMessage))
+	if change := tg.describeChange(request.Context.GitContext); change != "" {
+		prompt.WriteString(fmt.Sprintf("- Change: %s\n", change))
+	}
+
+	if files := request.Context.GitContext.FilesDiff; len(files) > 0 {
+		prompt.WriteString(fmt.Sprintf("- Files changed: %s\n", strings.Join(files, ", ")))
+	}
+
+	for _, line := range tg.recentCommitLines(request) {
+		prompt.WriteString(fmt.Sprintf("- %s\n", line))
+	}
+
+	if examples := tg.fewShotSection(); examples != "" && !draft.omitExamples {
+		prompt.WriteString("\n" + examples)
 	}
 
 	prompt.WriteString("\nFunctions to test:\n")
 
-	// Add function details
-	for i, fn := range request.Functions {
+	// Add function details, in reading order
+	for i, fn := range sourceOrder(request.Functions) {
 		prompt.WriteString(fmt.Sprintf("\n%d. Function: %s\n", i+1, fn.Name))
 		prompt.WriteString(fmt.Sprintf("   Signature: %s\n", fn.Signature))
 
@@ -236,10 +381,80 @@ func (tg *TestGenerator) buildPrompt(request models.TestGenerationRequest) strin
 					prompt.WriteString(fmt.Sprintf("     - %s\n", ret.Type))
 				}
 			}
+			if note := returnPatternNote(fn.ReturnPattern); note != "" {
+				prompt.WriteString(fmt.Sprintf("   %s\n", note))
+			}
+		}
+
+		for _, iface := range fn.InterfaceReturns {
+			prompt.WriteString(fmt.Sprintf("   Returns interface %s, hiding its concrete type: assert behavior by calling its methods on the result and checking what they return, not by comparing the result to a value\n", iface.Type))
+			if len(iface.Methods) > 0 {
+				prompt.WriteString("     Method set:\n")
+				for _, method := range iface.Methods {
+					prompt.WriteString(fmt.Sprintf("       - %s\n", sanitizeData(method)))
+				}
+			}
+			if iface.Unresolved != "" {
+				prompt.WriteString(fmt.Sprintf("     Method set incomplete: %s\n", sanitizeData(iface.Unresolved)))
+			}
+			if len(iface.Concrete) > 0 {
+				prompt.WriteString(fmt.Sprintf("     Concrete types it returns: %s (a test may also type-assert the result to one of these)\n", strings.Join(iface.Concrete, ", ")))
+			}
+		}
+
+		if guidance := tg.exportGuidance(fn); guidance != "" {
+			prompt.WriteString(fmt.Sprintf("   Unexported: %s\n", guidance))
+		}
+
+		if len(fn.ChangedLines) > 0 {
+			prompt.WriteString(fmt.Sprintf("   Changed lines: %s\n", formatLineRanges(fn.ChangedLines)))
+			if focus := fn.ChangeFocus; focus != nil {
+				prompt.WriteString("   Change focus: this function is long and the diff changed only the lines below. Aim the tests at the behavior they change, through the branches enclosing them, rather than covering the whole function.\n")
+				if len(focus.Branches) > 0 {
+					prompt.WriteString("     Enclosing branches:\n")
+					prompt.WriteString(fenceData("enclosing branches", strings.Join(focus.Branches, "\n"), "       "))
+				}
+				prompt.WriteString("     Changed code:\n")
+				prompt.WriteString(fenceData("changed code", strings.Join(focus.Lines, "\n"), "       "))
+			}
 		}
 
 		if fn.IsMethod {
 			prompt.WriteString(fmt.Sprintf("   Method receiver: %s %s\n", fn.Receiver.Name, fn.Receiver.Type))
+			if note := genericReceiverNote(fn); note != "" {
+				prompt.WriteString("   Generic receiver: " + note + "\n")
+			}
+			if fn.ReceiverDefinition != "" {
+				prompt.WriteString("   Receiver type definition:\n")
+				prompt.WriteString(fenceData("receiver type definition", fn.ReceiverDefinition, "     "))
+			} else if fn.ReceiverUnresolved != "" {
+				prompt.WriteString(fmt.Sprintf("   Receiver type definition: not available (%s); construct the receiver only through what the signature and body show\n",
+					sanitizeData(fn.ReceiverUnresolved)))
+			}
+		}
+
+		if len(fn.CallsModified) > 0 {
+			prompt.WriteString(fmt.Sprintf("   Why this function: it wasn't modified, but it calls the recently modified %s. Focus on behavior that flows through those calls.\n",
+				strings.Join(fn.CallsModified, ", ")))
+		} else if fn.BlastRadius != "" {
+			prompt.WriteString("   Why this function: it wasn't modified, but other code in its package was. Cover its main behavior.\n")
+		}
+
+		if fn.IsExternalImpl {
+			prompt.WriteString("   External implementation: this function is declared without a body; it's implemented in assembly or linked in from elsewhere, so no body is shown. Test it through its signature and documented behavior only.\n")
+		}
+
+		if fn.IsDeprecated {
+			prompt.WriteString("   Deprecated: this function is deprecated. Write a few tests that pin its current behavior so it doesn't change before removal; don't expand coverage or test new edge cases.\n")
+		}
+
+		if fn.PromotedFrom != "" {
+			prompt.WriteString(fmt.Sprintf("   Promoted method: %s is declared on the embedded type %s and promoted to %s. Test it by calling it on a %s value.\n",
+				fn.Name, fn.PromotedFrom, fn.Receiver.Type, fn.Receiver.Type))
+		}
+		if len(fn.TypeDefinitions) > 0 {
+			prompt.WriteString("   Type definitions:\n")
+			prompt.WriteString(fenceData("type definitions", strings.Join(fn.TypeDefinitions, "\n\n"), "     "))
 		}
 
 		// Add complexity hints
@@ -257,15 +472,60 @@ func (tg *TestGenerator) buildPrompt(request models.TestGenerationRequest) strin
 		if complexity.HasChannels {
 			hints = append(hints, "uses channels")
 		}
+		if complexity.MutatesArgs {
+			hints = append(hints, "mutates pointer arguments")
+		}
 		if len(hints) > 0 {
 			prompt.WriteString(fmt.Sprintf("   Complexity: %s\n", strings.Join(hints, ", ")))
 		}
+		if len(fn.Parameters) > 0 && len(fn.Returns) == 0 {
+			prompt.WriteString("   Note: this function returns nothing. Test its observable side effects instead: inject fakes for its dependencies (loggers, metrics, writers) or capture its output (e.g. a bytes.Buffer passed to log.SetOutput) and assert on what was recorded.\n")
+		}
+		if complexity.MutatesArgs {
+			prompt.WriteString("   Note: this function modifies values through its pointer parameters. Pass a pointer and assert the pointee's fields after the call.\n")
+		}
+		if len(fn.UnusedParams) > 0 {
+			prompt.WriteString(fmt.Sprintf("   Note: the body never uses parameter(s) %s. Pass any valid value and don't write cases varying them; if this doesn't look like interface conformance, mention the unused parameter in warnings as a possible incomplete implementation.\n",
+				strings.Join(fn.UnusedParams, ", ")))
+		}
+		if fn.DroppedContext != "" {
+			prompt.WriteString(fmt.Sprintf("   Note: the body accepts context %s but never passes it to a call, so cancelling it can't stop anything. Don't write a test expecting cancellation or a deadline to cut the work short; mention in warnings that %s isn't propagated, a likely bug.\n",
+				fn.DroppedContext, fn.DroppedContext))
+		}
+		if note := tg.parallelNote(complexity); note != "" {
+			prompt.WriteString("   Note: " + note + "\n")
+		}
 
 		if len(fn.Comments) > 0 {
-			prompt.WriteString("   Comments:\n")
+			var comments []string
 			for _, comment := range fn.Comments {
-				prompt.WriteString(fmt.Sprintf("     %s\n", strings.TrimSpace(comment)))
+				comments = append(comments, strings.TrimSpace(comment))
 			}
+			prompt.WriteString("   Comments:\n")
+			prompt.WriteString(fenceData("comments", strings.Join(comments, "\n"), "     "))
+		}
+
+		if len(fn.ExampleValues) > 0 {
+			prompt.WriteString("   Values existing tests use (reuse them where they fit, so fixtures stay consistent):\n")
+			prompt.WriteString(fenceData("existing test values", strings.Join(fn.ExampleValues, "\n"), "     "))
+		}
+
+		if note := tg.fuzzNote(fn, request.Context.GoVersion); note != "" {
+			prompt.WriteString("   Fuzz test: " + note + "\n")
+			if len(fn.FuzzSeeds) > 0 {
+				seeds := make([]string, len(fn.FuzzSeeds))
+				for i, seed := range fn.FuzzSeeds {
+					seeds[i] = "f.Add(" + seed + ")"
+				}
+				prompt.WriteString("   Fuzz seeds (values the package and its tests call it with; add every one):\n")
+				prompt.WriteString(fenceData("fuzz seeds", strings.Join(seeds, "\n"), "     "))
+			}
+		}
+
+		if fn.Body != "" {
+			body, _ := summarizeBody(fn.Body, tg.config.AI.MaxBodyLines)
+			prompt.WriteString("   Body:\n")
+			prompt.WriteString(fenceData("body", body, "     "))
 		}
 	}
 
@@ -275,14 +535,15 @@ func (tg *TestGenerator) buildPrompt(request models.TestGenerationRequest) strin
 	prompt.WriteString("2. Test both happy path and edge cases\n")
 	prompt.WriteString("3. Include table-driven tests when appropriate\n")
 	prompt.WriteString("4. Test error conditions if the function returns errors\n")
-	prompt.WriteString("5. Use meaningful test names (TestFunctionName_Scenario)\n")
+	prompt.WriteString("5. Use meaningful test names that follow the naming convention above\n")
 	prompt.WriteString("6. Include setup and cleanup when needed\n")
 	prompt.WriteString("7. Test nil pointer cases if function uses pointers\n")
-	prompt.WriteString("8. Are readable and well-commented\n\n")
+	prompt.WriteString("8. Are readable and well-commented\n")
+	prompt.WriteString("9. Name the function each test exercises in target_function, as it's listed above: FunctionName, or Type.Method for methods\n\n")
 
 	// Specify response format more clearly
 	prompt.WriteString("IMPORTANT: Return only valid JSON in this exact format (no markdown, no code blocks, no backticks):\n")
-	prompt.WriteString(`{"tests":[{"name":"TestFunctionName_Scenario","code":"func TestFunctionName_Scenario(t *testing.T) { /* test code */ }","description":"what this test validates","test_type":"unit","coverage":["scenario1","scenario2"]}],"reasoning":"explanation of testing approach","confidence":0.85,"warnings":["any potential issues"]}`)
+	prompt.WriteString(fmt.Sprintf(`{"tests":[{"name":"TestFunctionName_Scenario","target_function":"FunctionName","code":"func TestFunctionName_Scenario(t *testing.T) { /* test code */ }","description":"what this test validates","test_type":"%s","coverage":["scenario1","scenario2"],"confidence":0.9}],"reasoning":"explanation of testing approach","confidence":0.85,"warnings":["any potential issues"]}`, requestedTestType(request)))
 
 	return prompt.String()
 }
@@ -295,49 +556,159 @@ func (tg *TestGenerator) makeAPIRequest(url string, requestData map[string]inter
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
+	// Retry timeouts and transient provider failures with backoff
+	var body []byte
+	backoff := tg.retryBackoff
+	for attempt := 1; ; attempt++ {
+		var retryable bool
+		body, retryable, err = tg.doAPIRequest(url, jsonData, authHeaderName, authHeaderValue)
+		if err == nil || !retryable || attempt > tg.maxRetries {
+			break
+		}
+
+		wait, waitErr := retryWait(backoff, err)
+		if waitErr != nil {
+			return nil, waitErr
+		}
+		report.Verbosef("API request attempt %d of %d failed (%v), retrying in %s\n", attempt, tg.maxRetries+1, err, wait.Round(time.Millisecond))
+		select {
+		case <-time.After(wait):
+		case <-tg.ctx.Done():
+			return nil, fmt.Errorf("%w (run timeout reached while waiting to retry)", err)
+		}
+		backoff *= 2
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse response based on provider
+	return tg.parseAPIResponse(body, url)
+}
+
+// doAPIRequest makes one API call bounded by the request timeout and returns
+// the response body. Failures report whether another attempt could succeed:
+// a request or network timeout, rate limiting or a transient server error,
+// but not the run context ending.
+func (tg *TestGenerator) doAPIRequest(url string, jsonData []byte, authHeaderName, authHeaderValue string) ([]byte, bool, error) {
+	ctx, cancel := tg.ctx, context.CancelFunc(func() {})
+	if tg.requestTimeout > 0 {
+		ctx, cancel = context.WithTimeout(tg.ctx, tg.requestTimeout)
+	}
+	defer cancel()
+
+	// Cancelled on its own when reading the body outlasts ai.read_timeout
+	ctx, cancelRead := context.WithCancel(ctx)
+	defer cancelRead()
+
 	// Create HTTP request
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonData))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, false, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Set headers
 	req.Header.Set("Content-Type", "application/json")
 
 	// Fixed: Properly set auth header
-	req.Header.Set(authHeaderName, authHeaderValue)
+	if authHeaderName != "" {
+		req.Header.Set(authHeaderName, authHeaderValue)
+	}
 
 	// Special headers for Anthropic
 	if strings.Contains(url, "anthropic.com") {
 		req.Header.Set("anthropic-version", "2023-06-01")
 	}
 
+	// Org-scoped OpenAI keys route and bill by organization and project
+	if tg.config.AI.Provider == "openai" {
+		if tg.config.AI.Organization != "" {
+			req.Header.Set("OpenAI-Organization", tg.config.AI.Organization)
+		}
+		if tg.config.AI.Project != "" {
+			req.Header.Set("OpenAI-Project", tg.config.AI.Project)
+		}
+	}
+
 	// Make request
 	resp, err := tg.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make API request: %w", err)
+		retryable := tg.requestTimedOut(ctx) || (tg.ctx.Err() == nil && networkTimeout(err))
+		return nil, retryable, fmt.Errorf("failed to make API request: %w", tg.timeoutError(ctx, err))
 	}
 	defer resp.Body.Close()
 
-	// Read response
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+	// Check for API errors, and pages that aren't JSON, reading only as
+	// much of them as an error quotes
+	mediaType := responseMediaType(resp)
+	if resp.StatusCode != http.StatusOK || !isJSONMediaType(mediaType) {
+		excerpt, retryable, err := tg.readResponse(ctx, cancelRead, resp, excerptReadBytes)
+		if err != nil {
+			return nil, retryable, err
+		}
+		retryable = retryableStatus(resp.StatusCode)
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			return nil, false, fmt.Errorf("expected JSON from %s, got %s (is ai.base_url correct?): %s", url, mediaType, bodyExcerpt(excerpt))
+		case !isJSONMediaType(mediaType):
+			err = fmt.Errorf("API request failed with status %d, and %s instead of JSON (is ai.base_url correct?): %s",
+				resp.StatusCode, mediaType, bodyExcerpt(excerpt))
+		default:
+			err = fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, bodyExcerpt(excerpt))
+		}
+		if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok && retryable {
+			err = &retryAfterError{err: err, wait: wait}
+		}
+		return nil, retryable, err
 	}
 
-	// Check for API errors
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	body, retryable, err := tg.readResponse(ctx, cancelRead, resp, tg.maxResponseBytes)
+	if err != nil {
+		return nil, retryable, err
+	}
+	if int64(len(body)) > tg.maxResponseBytes {
+		return nil, false, fmt.Errorf("response is over %d bytes (ai.max_response_bytes), too large for a test generation response; is ai.base_url correct?",
+			tg.maxResponseBytes)
 	}
+	return body, false, nil
+}
 
-	// Parse response based on provider
-	return tg.parseAPIResponse(body, url)
+// requestTimedOut reports whether a call's own timeout, rather than the run
+// context, cut it short
+func (tg *TestGenerator) requestTimedOut(ctx context.Context) bool {
+	return tg.ctx.Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
+
+// timeoutError names which timeout ended a call, if any
+func (tg *TestGenerator) timeoutError(ctx context.Context, err error) error {
+	switch {
+	case tg.ctx.Err() != nil:
+		return fmt.Errorf("run timeout reached: %w", err)
+	case tg.requestTimedOut(ctx):
+		return fmt.Errorf("request timed out after %s (ai.request_timeout): %w", tg.requestTimeout, err)
+	default:
+		return err
+	}
 }
 
 // parseAPIResponse parses AI API response into our format
 func (tg *TestGenerator) parseAPIResponse(body []byte, url string) (*models.TestGenerationResponse, error) {
-	if strings.Contains(url, "openai.com") || strings.Contains(url, "groq.com") {
-		return tg.parseOpenAIResponse(body) // Groq uses OpenAI-compatible format
+	// A local server can be at any address, and so can a custom ai.base_url
+	if tg.config.AI.Provider == "local" {
+		return tg.parseOllamaResponse(body)
+	}
+	if base := tg.config.AI.BaseURL; base != "" && strings.HasPrefix(url, strings.TrimSuffix(base, "/")) {
+		switch tg.config.AI.Provider {
+		case "openai":
+			return tg.parseOpenAIResponse(body, tg.config.AI.ResponseMode())
+		case "groq":
+			return tg.parseOpenAIResponse(body, config.OpenAIModeJSONObject)
+		}
+	}
+	if strings.Contains(url, "openai.com") {
+		return tg.parseOpenAIResponse(body, tg.config.AI.ResponseMode())
+	} else if strings.Contains(url, "groq.com") {
+		return tg.parseOpenAIResponse(body, config.OpenAIModeJSONObject) // Groq uses OpenAI-compatible format
 	} else if strings.Contains(url, "anthropic.com") {
 		return tg.parseAnthropicResponse(body)
 	}
@@ -345,12 +716,21 @@ func (tg *TestGenerator) parseAPIResponse(body []byte, url string) (*models.Test
 	return nil, fmt.Errorf("unknown API response format")
 }
 
-// parseOpenAIResponse parses OpenAI API response
-func (tg *TestGenerator) parseOpenAIResponse(body []byte) (*models.TestGenerationResponse, error) {
+// parseOpenAIResponse parses an OpenAI API response requested in mode. Tool
+// mode reads the arguments of the first tool call, the others the message
+// content; only json_object content can arrive wrapped in markdown.
+func (tg *TestGenerator) parseOpenAIResponse(body []byte, mode string) (*models.TestGenerationResponse, error) {
 	var openAIResp struct {
 		Choices []struct {
 			Message struct {
-				Content string `json:"content"`
+				Content   string `json:"content"`
+				Refusal   string `json:"refusal"`
+				ToolCalls []struct {
+					Function struct {
+						Name      string `json:"name"`
+						Arguments string `json:"arguments"`
+					} `json:"function"`
+				} `json:"tool_calls"`
 			} `json:"message"`
 		} `json:"choices"`
 		Usage struct {
@@ -366,18 +746,32 @@ func (tg *TestGenerator) parseOpenAIResponse(body []byte) (*models.TestGeneratio
 		return nil, fmt.Errorf("no choices in OpenAI response")
 	}
 
-	// Clean the content - remove markdown code blocks if present
-	content := openAIResp.Choices[0].Message.Content
-	content = tg.cleanJSONResponse(content)
+	message := openAIResp.Choices[0].Message
+	if message.Refusal != "" {
+		return nil, fmt.Errorf("OpenAI refused the request: %s", message.Refusal)
+	}
+
+	content := message.Content
+	switch mode {
+	case config.OpenAIModeTool:
+		if len(message.ToolCalls) == 0 {
+			return nil, fmt.Errorf("no tool call in OpenAI response (ai.openai_mode is tool)")
+		}
+		content = message.ToolCalls[0].Function.Arguments
+	case config.OpenAIModeJSONObject:
+		// Clean the content - remove markdown code blocks if present
+		content = tg.cleanJSONResponse(content)
+	}
 
 	// Parse the JSON content
 	var response models.TestGenerationResponse
 	if err := json.Unmarshal([]byte(content), &response); err != nil {
 		// Log the actual content for debugging
-		fmt.Printf("DEBUG: Failed to parse JSON. Content: %s\n", content)
+		report.Verbosef("DEBUG: Failed to parse JSON. Content: %s\n", content)
 		return nil, fmt.Errorf("failed to parse test generation response: %w", err)
 	}
 
+	response.TokensUsed = openAIResp.Usage.TotalTokens
 	return &response, nil
 }
 
@@ -409,10 +803,48 @@ func (tg *TestGenerator) parseAnthropicResponse(body []byte) (*models.TestGenera
 	var response models.TestGenerationResponse
 	if err := json.Unmarshal([]byte(content), &response); err != nil {
 		// Log the actual content for debugging
-		fmt.Printf("DEBUG: Failed to parse JSON. Content: %s\n", content)
+		report.Verbosef("DEBUG: Failed to parse JSON. Content: %s\n", content)
+		return nil, fmt.Errorf("failed to parse test generation response: %w", err)
+	}
+
+	response.TokensUsed = anthropicResp.Usage.InputTokens + anthropicResp.Usage.OutputTokens
+	return &response, nil
+}
+
+// parseOllamaResponse parses a non-streaming Ollama chat response
+func (tg *TestGenerator) parseOllamaResponse(body []byte) (*models.TestGenerationResponse, error) {
+	var ollamaResp struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+		Error           string `json:"error"`
+		PromptEvalCount int    `json:"prompt_eval_count"`
+		EvalCount       int    `json:"eval_count"`
+	}
+
+	if err := json.Unmarshal(body, &ollamaResp); err != nil {
+		return nil, fmt.Errorf("failed to parse Ollama response: %w", err)
+	}
+
+	if ollamaResp.Error != "" {
+		return nil, fmt.Errorf("Ollama returned an error: %s", ollamaResp.Error)
+	}
+
+	// Clean the content - remove markdown code blocks if present
+	content := tg.cleanJSONResponse(ollamaResp.Message.Content)
+	if content == "" {
+		return nil, fmt.Errorf("no content in Ollama response")
+	}
+
+	// Parse the JSON content
+	var response models.TestGenerationResponse
+	if err := json.Unmarshal([]byte(content), &response); err != nil {
+		// Log the actual content for debugging
+		report.Verbosef("DEBUG: Failed to parse JSON. Content: %s\n", content)
 		return nil, fmt.Errorf("failed to parse test generation response: %w", err)
 	}
 
+	response.TokensUsed = ollamaResp.PromptEvalCount + ollamaResp.EvalCount
 	return &response, nil
 }
 
@@ -443,54 +875,22 @@ func (tg *TestGenerator) cleanJSONResponse(content string) string {
 	return strings.TrimSpace(content)
 }
 
-// writeTestFile writes tests to a file
-func (tg *TestGenerator) writeTestFile(sourceFile string, functions []models.FunctionInfo, tests []models.GeneratedTest) error {
-	testFilePath := tg.config.GetTestOutputPath(sourceFile)
-
-	// Check if we should overwrite
-	if _, err := os.Stat(testFilePath); err == nil && !tg.config.Output.Overwrite {
-		return fmt.Errorf("test file %s already exists (use overwrite: true to replace)", testFilePath)
-	}
-
-	// Backup existing file if configured
-	if tg.config.Output.BackupExisting {
-		if err := tg.backupFile(testFilePath); err != nil {
-			return fmt.Errorf("failed to backup existing file: %w", err)
-		}
-	}
-
-	// Build complete test file content
-	content, err := tg.buildTestFileContent(sourceFile, functions, tests)
-	if err != nil {
-		return fmt.Errorf("failed to build test content: %w", err)
-	}
-
-	// Create directory if it doesn't exist
-	dir := filepath.Dir(testFilePath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create test directory: %w", err)
-	}
-
-	// Write the file
-	if err := os.WriteFile(testFilePath, []byte(content), 0644); err != nil {
-		return fmt.Errorf("failed to write test file: %w", err)
-	}
-
-	fmt.Printf("Generated tests: %s\n", testFilePath)
-	return nil
-}
-
 // buildTestFileContent creates the complete test file content
 func (tg *TestGenerator) buildTestFileContent(sourceFile string, functions []models.FunctionInfo, tests []models.GeneratedTest) (string, error) {
 	var content strings.Builder
 
 	// Determine package name and imports based on output directory
 	packageName := "main"
-	samePackage := tg.config.Output.Directory == ""
+	samePackage := tg.config.Output.SamePackage()
 	sourcePackageName := ""
 
 	if len(functions) > 0 {
 		sourcePackageName = functions[0].Package
+		for _, fn := range functions[1:] {
+			if fn.Package != sourcePackageName {
+				return "", fmt.Errorf("can't write tests for packages %s and %s into one file", sourcePackageName, fn.Package)
+			}
+		}
 		if samePackage {
 			// Same directory = same package
 			packageName = sourcePackageName
@@ -500,67 +900,68 @@ func (tg *TestGenerator) buildTestFileContent(sourceFile string, functions []mod
 		}
 	}
 
-	// Package declaration
+	// Platform build tag for platform-specific sources, generated code header, then package declaration
+	if tag := parser.PlatformBuildTag(sourceFile); tag != "" {
+		content.WriteString("//go:build " + tag + "\n\n")
+	}
+	content.WriteString(generatedFileHeader(sourceFile, tg.config.Output.MarkDoNotEdit()) + "\n\n")
 	content.WriteString(fmt.Sprintf("package %s\n\n", packageName))
 
-	// Imports
-	content.WriteString("import (\n")
-	content.WriteString("\t\"testing\"\n")
-
-	// If in different package, import the source package
-	if !samePackage && sourcePackageName != "" {
-		// Get the module name from go.mod or derive from source file path
-		// For now, use a simple approach - this could be enhanced
-		moduleName := tg.getModuleName(sourceFile)
-		if moduleName != "" {
-			content.WriteString(fmt.Sprintf("\t\"%s\"\n", moduleName))
+	// Clean up the test code based on package context and lift out any
+	// imports the AI declared inline
+	modulePath, packagePath := moduleInfo(sourcePath(functions, sourceFile))
+	codes := make([]string, len(tests))
+	used := map[string]bool{"testing": true}
+	var declared []importSpec
+	for i, test := range tests {
+		code, specs, qualifiers := splitTestImports(tg.cleanTestCode(test.Code, samePackage, sourcePackageName))
+		codes[i] = code
+		declared = append(declared, specs...)
+		for name := range qualifiers {
+			used[name] = true
 		}
 	}
 
-	// Add additional imports based on test content
-	importSet := make(map[string]bool)
-	for _, test := range tests {
-		if strings.Contains(test.Code, "reflect.") {
-			importSet["reflect"] = true
-		}
-		if strings.Contains(test.Code, "errors.") {
-			importSet["errors"] = true
-		}
-		if strings.Contains(test.Code, "fmt.") {
-			importSet["fmt"] = true
-		}
-		if strings.Contains(test.Code, "strings.") {
-			importSet["strings"] = true
-		}
-		if strings.Contains(test.Code, "time.") {
-			importSet["time"] = true
+	// Tests name packages the way the source does; in a separate package
+	// the source package is imported too
+	known := sourceImports(sourcePath(functions, sourceFile))
+	if known == nil {
+		known = make(map[string]importSpec)
+	}
+	if !samePackage && sourcePackageName != "" {
+		if packagePath == "" {
+			packagePath = tg.getModuleName(sourceFile)
 		}
-		if strings.Contains(test.Code, "context.") {
-			importSet["context"] = true
+		if packagePath != "" {
+			spec := importSpec{path: packagePath}
+			if defaultPackageName(packagePath) != sourcePackageName {
+				spec.name = sourcePackageName
+			}
+			known[sourcePackageName] = spec
 		}
 	}
 
-	// Add detected imports
-	for imp := range importSet {
-		content.WriteString(fmt.Sprintf("\t\"%s\"\n", imp))
-	}
-
-	content.WriteString(")\n\n")
-
-	// Generated tests comment
-	content.WriteString("// Tests generated by testgen\n\n")
+	// Imports are detected from the code that is actually written, grouped
+	// as standard library, other modules, then this module
+	content.WriteString(renderImportBlock(resolveImports(used, declared, known), modulePath))
+	content.WriteString("\n\n")
 
-	// Add each test with proper function call cleaning
-	for _, test := range tests {
-		// Clean up the test code based on package context
-		cleanCode := tg.cleanTestCode(test.Code, samePackage, sourcePackageName)
+	// Add each test
+	for i, test := range tests {
+		cleanCode := codes[i]
 
-		content.WriteString(fmt.Sprintf("// %s\n", test.Description))
+		// Comments and markers describe the function the test exercises
+		target := testTarget(functions, test)
+		content.WriteString(renderTestComment(tg.config.Output.CommentStyle, test, target))
+		if target != nil {
+			content.WriteString(targetMarker(target.Name) + "\n")
+		}
 		content.WriteString(cleanCode)
 		content.WriteString("\n\n")
 	}
 
-	return content.String(), nil
+	// Clean up the AI's whitespace before anything formats or lints the file
+	return normalizeWhitespace(content.String()), nil
 }
 
 // getModuleName tries to determine the module name for imports
@@ -621,7 +1022,7 @@ func (tg *TestGenerator) backupFile(filePath string) error {
 		return nil // No file to backup
 	}
 
-	backupPath := filePath + ".backup"
+	backupPath := filePath + backupSuffix
 
 	// Read original file
 	data, err := os.ReadFile(filePath)
@@ -634,6 +1035,6 @@ func (tg *TestGenerator) backupFile(filePath string) error {
 		return fmt.Errorf("failed to write backup file: %w", err)
 	}
 
-	fmt.Printf("Created backup: %s\n", backupPath)
+	report.Infof("Created backup: %s\n", backupPath)
 	return nil
 }
@@ -0,0 +1,13 @@
+package codec
+
+import (
+	"strings"
+
+	pb "example.com/gen/proto"
+	. "example.com/gen/units"
+)
+
+// Encode renders a message with its unit suffix
+func Encode(msg *pb.Message) string {
+	return strings.ToUpper(msg.Text) + Suffix
+}
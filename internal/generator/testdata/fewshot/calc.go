@@ -0,0 +1,11 @@
+package calc
+
+import "errors"
+
+// Divide returns a divided by b
+func Divide(a, b int) (int, error) {
+	if b == 0 {
+		return 0, errors.New("division by zero")
+	}
+	return a / b, nil
+}
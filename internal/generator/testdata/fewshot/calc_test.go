@@ -0,0 +1,26 @@
+package calc
+
+import "testing"
+
+func TestDivide(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    int
+		want    int
+		wantErr bool
+	}{
+		{name: "exact", a: 6, b: 3, want: 2},
+		{name: "by zero", a: 1, b: 0, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Divide(tt.a, tt.b)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Divide() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Divide() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+// Generated tests with every flaky pattern testgen detects, one per test
+package flaky
+
+import (
+	"math/rand"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRefresh(t *testing.T) {
+	go Refresh()
+	time.Sleep(100 * time.Millisecond)
+	if !Refreshed() {
+		t.Error("not refreshed")
+	}
+}
+
+func TestFetch(t *testing.T) {
+	resp, err := http.Get("https://example.com/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func TestPick(t *testing.T) {
+	items := []int{1, 2, 3}
+	if got := Pick(items, rand.Intn(len(items))); got == 0 {
+		t.Error("picked nothing")
+	}
+}
+
+func TestStamp(t *testing.T) {
+	if got := Stamp(); got != time.Now() {
+		t.Errorf("Stamp() = %v", got)
+	}
+}
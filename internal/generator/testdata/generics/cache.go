@@ -0,0 +1,31 @@
+package cache
+
+// Cache holds values by key
+type Cache[K comparable, V any] struct {
+	items map[K]V
+}
+
+// NewCache returns an empty cache
+func NewCache[K comparable, V any]() *Cache[K, V] {
+	return &Cache[K, V]{items: make(map[K]V)}
+}
+
+// Get returns the value stored under k
+func (c *Cache[K, V]) Get(k K) (V, bool) {
+	v, ok := c.items[k]
+	return v, ok
+}
+
+// Put stores v under k
+func (c *Cache[Key, Val]) Put(k Key, v Val) {
+	c.items[k] = v
+}
+
+// Totals sums amounts per key
+type Totals[K comparable, N ~int64 | ~float64] map[K]N
+
+// Add adds n to the total of k
+func (t Totals[K, N]) Add(k K, n N) N {
+	t[k] += n
+	return t[k]
+}
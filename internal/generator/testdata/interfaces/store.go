@@ -0,0 +1,62 @@
+package store
+
+import (
+	"errors"
+	"io"
+)
+
+// Store loads and saves records
+type Store interface {
+	Loader
+	io.Closer
+	Save(key string, value []byte) error
+}
+
+// Loader loads records
+type Loader interface {
+	Load(key string) ([]byte, error)
+}
+
+// Backend is another name for Store
+type Backend = Store
+
+type memoryStore struct {
+	records map[string][]byte
+}
+
+func (m *memoryStore) Load(key string) ([]byte, error) { return m.records[key], nil }
+
+func (m *memoryStore) Save(key string, value []byte) error {
+	m.records[key] = value
+	return nil
+}
+
+func (m *memoryStore) Close() error { return nil }
+
+// Open returns an empty in-memory store
+func Open(name string) (Store, error) {
+	if name == "" {
+		return nil, errors.New("name required")
+	}
+	s := &memoryStore{records: map[string][]byte{}}
+	return s, nil
+}
+
+// Reader returns a record as a reader
+func Reader(data []byte) io.Reader {
+	return &recordReader{data: data}
+}
+
+type recordReader struct {
+	data []byte
+	off  int
+}
+
+func (r *recordReader) Read(p []byte) (int, error) {
+	if r.off >= len(r.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data[r.off:])
+	r.off += n
+	return n, nil
+}
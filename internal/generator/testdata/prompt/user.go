@@ -0,0 +1,26 @@
+package user
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrInvalidEmail is returned for addresses without a domain
+var ErrInvalidEmail = errors.New("invalid email")
+
+// User is a registered account
+type User struct {
+	Name  string
+	Email string
+}
+
+// ValidateUser checks that a user has a name and a plausible email address
+func ValidateUser(u *User) error {
+	if u == nil || strings.TrimSpace(u.Name) == "" {
+		return errors.New("name required")
+	}
+	if !strings.Contains(u.Email, "@") {
+		return ErrInvalidEmail
+	}
+	return nil
+}
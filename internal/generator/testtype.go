@@ -0,0 +1,22 @@
+package generator
+
+import (
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// testTypeGuidance is the prompt requirement asking for each kind of test
+// --type can request; unit tests need none
+var testTypeGuidance = map[models.TestType]string{
+	models.IntegrationTest: "Write integration tests: exercise the functions together with their real collaborators (files under t.TempDir(), an httptest.Server, real encoders) instead of fakes",
+	models.BenchmarkTest:   "Write benchmarks, func BenchmarkXxx(b *testing.B), that run the function b.N times over representative inputs, doing setup before b.ResetTimer()",
+	models.ExampleTest:     "Write runnable examples, func ExampleXxx() (ExampleType_Method for methods), that print results and end with an // Output: comment holding the exact output",
+	models.FuzzTest:        "Write fuzz tests, func FuzzXxx(f *testing.F), that seed the corpus with f.Add and assert properties that hold for every input inside f.Fuzz",
+}
+
+// requestedTestType returns the kind of tests a request asks for
+func requestedTestType(request models.TestGenerationRequest) models.TestType {
+	if request.TestType == "" {
+		return models.UnitTest
+	}
+	return request.TestType
+}
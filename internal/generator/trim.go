@@ -0,0 +1,169 @@
+package generator
+
+import (
+	"strings"
+
+	"github.com/Eranmonnie/testgen/internal/report"
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// promptDraft is a request on its way to a prompt, with what trimming has
+// taken out of it so far
+type promptDraft struct {
+	request      models.TestGenerationRequest
+	omitExamples bool
+}
+
+// promptReduction is one trimming pass: it cuts a kind of content from the
+// draft and reports whether there was any to cut
+type promptReduction struct {
+	name   string
+	reduce func(tg *TestGenerator, draft *promptDraft) bool
+}
+
+// promptReductions are applied in order until a prompt fits
+// ai.max_prompt_tokens, least useful content first:
+//
+//  1. examples from existing tests: ai.few_shot_examples and mined values
+//  2. type definitions, the receiver's included
+//  3. doc comments
+//  4. function bodies, cut down to a skeleton of their control flow
+//  5. git context: commit message, branch, author, changed files and lines
+//
+// Helper signatures and constants would come between the examples and the
+// type definitions, but prompts don't include them.
+var promptReductions = []promptReduction{
+	{"examples from existing tests", dropExamples},
+	{"type definitions", dropTypeDefinitions},
+	{"comments", dropComments},
+	{"function bodies skeletonized", skeletonizeBodies},
+	{"git context", dropGitContext},
+}
+
+// fitPrompt renders the prompt for request, applying promptReductions one at
+// a time while the estimate is over ai.max_prompt_tokens. It returns the
+// prompt and the names of the reductions applied; a prompt still too large
+// once every pass ran is returned as is.
+func (tg *TestGenerator) fitPrompt(request models.TestGenerationRequest) (string, []string) {
+	defer report.Time(report.PhasePrompt)()
+	draft := promptDraft{request: request}
+	prompt := tg.renderPrompt(draft)
+
+	budget := tg.config.AI.MaxPromptTokens
+	if budget <= 0 {
+		return prompt, nil
+	}
+
+	// Passes edit the functions, so work on a copy of the caller's slice
+	draft.request.Functions = append([]models.FunctionInfo(nil), request.Functions...)
+
+	var applied []string
+	for _, reduction := range promptReductions {
+		if estimateTokens(prompt) <= budget {
+			break
+		}
+		if reduction.reduce(tg, &draft) {
+			applied = append(applied, reduction.name)
+			prompt = tg.renderPrompt(draft)
+		}
+	}
+	return prompt, applied
+}
+
+// PromptReductions reports the trimming passes the prompt for request needs
+// to fit ai.max_prompt_tokens, and whether it fits after them, without
+// calling the AI
+func (tg *TestGenerator) PromptReductions(request models.TestGenerationRequest) ([]string, bool) {
+	prompt, applied := tg.fitPrompt(request)
+	budget := tg.config.AI.MaxPromptTokens
+	return applied, budget <= 0 || estimateTokens(prompt) <= budget
+}
+
+// reportPrompt reports a prompt's size and trimming in verbose output, and
+// warns when it is still over budget
+func (tg *TestGenerator) reportPrompt(prompt string, reductions []string) {
+	tokens := estimateTokens(prompt)
+	report.Verbosef("Prompt: ~%d tokens\n", tokens)
+	if len(reductions) > 0 {
+		report.Verbosef("Trimmed to fit ai.max_prompt_tokens: %s\n", strings.Join(reductions, ", "))
+	}
+	if budget := tg.config.AI.MaxPromptTokens; budget > 0 && tokens > budget {
+		report.Warnf("prompt is ~%d tokens, over ai.max_prompt_tokens (%d) even after trimming\n", tokens, budget)
+	}
+}
+
+// dropExamples leaves the ai.few_shot_examples section and the values mined
+// from existing tests and call sites out; post-validation still adds the
+// fuzz seeds a fuzz test lacks
+func dropExamples(tg *TestGenerator, draft *promptDraft) bool {
+	cut := false
+	if !draft.omitExamples && tg.fewShotSection() != "" {
+		draft.omitExamples = true
+		cut = true
+	}
+	for i := range draft.request.Functions {
+		if fn := &draft.request.Functions[i]; len(fn.ExampleValues) > 0 || len(fn.FuzzSeeds) > 0 {
+			fn.ExampleValues = nil
+			fn.FuzzSeeds = nil
+			cut = true
+		}
+	}
+	return cut
+}
+
+// dropTypeDefinitions removes the type and receiver type definitions
+func dropTypeDefinitions(_ *TestGenerator, draft *promptDraft) bool {
+	cut := false
+	for i := range draft.request.Functions {
+		fn := &draft.request.Functions[i]
+		if len(fn.TypeDefinitions) > 0 || fn.ReceiverDefinition != "" {
+			fn.TypeDefinitions = nil
+			fn.ReceiverDefinition = ""
+			cut = true
+		}
+	}
+	return cut
+}
+
+// dropComments removes the functions' doc comments
+func dropComments(_ *TestGenerator, draft *promptDraft) bool {
+	cut := false
+	for i := range draft.request.Functions {
+		if fn := &draft.request.Functions[i]; len(fn.Comments) > 0 {
+			fn.Comments = nil
+			cut = true
+		}
+	}
+	return cut
+}
+
+// skeletonizeBodies cuts long function bodies down to their edges and
+// control flow outline
+func skeletonizeBodies(_ *TestGenerator, draft *promptDraft) bool {
+	cut := false
+	for i := range draft.request.Functions {
+		fn := &draft.request.Functions[i]
+		if skeleton, ok := skeletonizeBody(fn.Body); ok {
+			fn.Body = skeleton
+			cut = true
+		}
+	}
+	return cut
+}
+
+// dropGitContext removes what the prompt says about the change being tested
+func dropGitContext(_ *TestGenerator, draft *promptDraft) bool {
+	cut := false
+	gitContext := &draft.request.Context.GitContext
+	if gitContext.CommitMessage != "" || len(gitContext.FilesDiff) > 0 {
+		*gitContext = models.GitContext{}
+		cut = true
+	}
+	for i := range draft.request.Functions {
+		if fn := &draft.request.Functions[i]; len(fn.ChangedLines) > 0 {
+			fn.ChangedLines, fn.ChangeFocus = nil, nil
+			cut = true
+		}
+	}
+	return cut
+}
@@ -0,0 +1,68 @@
+package generator
+
+import (
+	"go/scanner"
+	"go/token"
+	"regexp"
+	"strings"
+)
+
+var (
+	// trailingSpacePattern matches the whitespace ending a line
+	trailingSpacePattern = regexp.MustCompile(`[ \t\r\f\v]+\n`)
+	// blankRunPattern matches three or more consecutive blank lines
+	blankRunPattern = regexp.MustCompile(`\n{4,}`)
+)
+
+// normalizeWhitespace cleans up the whitespace AI output is sloppy with,
+// which pre-commit hooks reject: it trims trailing whitespace from every
+// line, collapses runs of three or more blank lines to two and ends the file
+// with exactly one newline. Raw string literals are copied untouched, since
+// their whitespace is part of the value.
+func normalizeWhitespace(src string) string {
+	var out strings.Builder
+	last := 0
+	for _, raw := range rawStringRanges(src) {
+		out.WriteString(normalizeSpan(src[last:raw[0]]))
+		out.WriteString(src[raw[0]:raw[1]])
+		last = raw[1]
+	}
+	out.WriteString(normalizeSpan(src[last:]))
+	return strings.TrimRight(out.String(), " \t\r\f\v\n") + "\n"
+}
+
+// normalizeSpan trims trailing whitespace and long blank runs in code
+// outside raw string literals
+func normalizeSpan(span string) string {
+	span = trailingSpacePattern.ReplaceAllString(span, "\n")
+	return blankRunPattern.ReplaceAllString(span, "\n\n\n")
+}
+
+// rawStringRanges returns the [start, end) offsets of the raw string
+// literals in src, in order. Source that doesn't scan cleanly still yields
+// the literals found; an unterminated one runs to the end.
+func rawStringRanges(src string) [][2]int {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var s scanner.Scanner
+	s.Init(file, []byte(src), nil, 0)
+
+	var ranges [][2]int
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			return ranges
+		}
+		if tok != token.STRING || !strings.HasPrefix(lit, "`") {
+			continue
+		}
+		// The scanner drops carriage returns from raw strings, so find
+		// the closing quote in the source rather than trusting len(lit)
+		start := file.Offset(pos)
+		end := len(src)
+		if i := strings.IndexByte(src[start+1:], '`'); i >= 0 {
+			end = start + 1 + i + 1
+		}
+		ranges = append(ranges, [2]int{start, end})
+	}
+}
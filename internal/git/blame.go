@@ -0,0 +1,127 @@
+package git
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// blameHeaderPattern matches the line porcelain blame starts each entry with:
+// the commit, the original line and the final line
+var blameHeaderPattern = regexp.MustCompile(`^([0-9a-f]{40,64}) \d+ \d+`)
+
+// Introduction names the commit that brought a span of lines into a range
+type Introduction struct {
+	Commit  string `json:"commit"`
+	Author  string `json:"author"`
+	Summary string `json:"summary"`
+}
+
+// Blamer attributes spans of lines, as they are at the end of a commit range,
+// to the commits within the range that introduced them. Each file is blamed
+// once, so attributing many functions in one file stays cheap.
+type Blamer struct {
+	from, to string
+	order    map[string]int          // commit -> position in the range, oldest first
+	files    map[string][]string     // path -> commit of each line
+	commits  map[string]Introduction // commit -> its author and summary
+}
+
+// NewBlamer creates a Blamer for the commits in from..to, or every commit
+// reachable from to when from is empty
+func NewBlamer(from, to string) *Blamer {
+	return &Blamer{
+		from:    from,
+		to:      to,
+		files:   make(map[string][]string),
+		commits: make(map[string]Introduction),
+	}
+}
+
+// revisionRange is the range argument git log and blame take
+func (b *Blamer) revisionRange() string {
+	if b.from == "" {
+		return b.to
+	}
+	return b.from + ".." + b.to
+}
+
+// Introduced returns the commit in the range that introduced lines start to
+// end (1-based, inclusive) of path, repo-relative or absolute: the oldest
+// commit in the range any of them still comes from, since later commits only
+// edited what it added. It returns nil when every line predates the range.
+func (b *Blamer) Introduced(path string, start, end int) (*Introduction, error) {
+	if b.order == nil {
+		if err := b.loadOrder(); err != nil {
+			return nil, err
+		}
+	}
+	lines, ok := b.files[path]
+	if !ok {
+		var err error
+		if lines, err = b.blame(path); err != nil {
+			return nil, err
+		}
+		b.files[path] = lines
+	}
+
+	oldest := ""
+	for line := max(start, 1); line <= end && line <= len(lines); line++ {
+		commit := lines[line-1]
+		position, inRange := b.order[commit]
+		if inRange && (oldest == "" || position < b.order[oldest]) {
+			oldest = commit
+		}
+	}
+	if oldest == "" {
+		return nil, nil
+	}
+	introduction := b.commits[oldest]
+	return &introduction, nil
+}
+
+// loadOrder records the position of each commit in the range, oldest first
+func (b *Blamer) loadOrder() error {
+	output, err := Command("rev-list", "--reverse", b.revisionRange()).Output()
+	if err != nil {
+		return fmt.Errorf("failed to list commits in %s: %w", b.revisionRange(), err)
+	}
+	b.order = make(map[string]int)
+	for i, commit := range strings.Fields(string(output)) {
+		b.order[commit] = i
+	}
+	return nil
+}
+
+// blame returns the commit each line of path comes from, recording the
+// author and summary of the commits it sees
+func (b *Blamer) blame(path string) ([]string, error) {
+	output, err := Command("blame", "--porcelain", b.revisionRange(), "--", path).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to blame %s: %w", path, err)
+	}
+
+	var lines []string
+	commit := ""
+	for _, line := range strings.Split(string(output), "\n") {
+		if match := blameHeaderPattern.FindStringSubmatch(line); match != nil {
+			commit = match[1]
+			if _, ok := b.commits[commit]; !ok {
+				b.commits[commit] = Introduction{Commit: commit}
+			}
+			continue
+		}
+		introduction := b.commits[commit]
+		switch {
+		case strings.HasPrefix(line, "\t"):
+			lines = append(lines, commit)
+		case strings.HasPrefix(line, "author "):
+			introduction.Author = strings.TrimPrefix(line, "author ")
+			b.commits[commit] = introduction
+		case strings.HasPrefix(line, "summary "):
+			introduction.Summary = strings.TrimPrefix(line, "summary ")
+			b.commits[commit] = introduction
+		}
+	}
+	return lines, nil
+}
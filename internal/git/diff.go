@@ -1,278 +1,165 @@
 package git
 
 import (
-	"bufio"
+	"context"
 	"fmt"
 	"os/exec"
-	"regexp"
+	"path/filepath"
 	"strings"
-)
-
-// DiffChange represents a single change in a diff
-type DiffChange struct {
-	Type     ChangeType // Added, Removed, Modified
-	Line     string
-	LineNum  int
-	Function string // Function this change belongs to
-}
-
-// FileDiff represents all changes in a single file
-type FileDiff struct {
-	OldPath   string
-	NewPath   string
-	Changes   []DiffChange
-	Functions []string // Functions that were modified
-}
 
-// DiffResult represents the complete diff analysis
-type DiffResult struct {
-	Files []FileDiff
-}
+	gitdiff "github.com/Eranmonnie/testgen/pkg/git"
+)
 
-type ChangeType int
+// The diff types live in the public pkg/git, so other tools can reuse
+// testgen's diff-to-function mapping; these aliases keep callers here
+// unchanged.
+type (
+	DiffChange = gitdiff.DiffChange
+	FileDiff   = gitdiff.FileDiff
+	DiffResult = gitdiff.DiffResult
+	ChangeType = gitdiff.ChangeType
+	FileCommit = gitdiff.FileCommit
+)
 
 const (
-	Added ChangeType = iota
-	Removed
-	Modified
-	Context
+	Added    = gitdiff.Added
+	Removed  = gitdiff.Removed
+	Modified = gitdiff.Modified
+	Context  = gitdiff.Context
 )
 
-// entry point
-// GetDiff gets the diff between two git references
-func GetDiff(from, to string) (*DiffResult, error) {
-	// Get the raw diff with function context
-	cmd := exec.Command("git", "diff", "--function-context", from, to)
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get git diff: %w", err)
-	}
+// Binary is the git executable used for every git invocation.
+// It can be changed with Configure (TESTGEN_GIT_BIN or git.binary).
+var Binary = "git"
 
-	return parseDiff(string(output))
-}
+// RepoDir is the directory git commands run in. Empty means the current directory.
+var RepoDir = ""
 
-// GetChangedFiles returns just the list of changed file paths
-func GetChangedFiles(from, to string) ([]string, error) {
-	cmd := exec.Command("git", "diff", "--name-only", from, to)
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get changed files: %w", err)
+// Configure sets the git binary and repository directory used by this package.
+// Empty values leave the current setting unchanged.
+func Configure(binary, repoDir string) {
+	if binary != "" {
+		Binary = binary
 	}
-
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	var files []string
-	for _, line := range lines {
-		if line != "" {
-			files = append(files, line)
-		}
+	if repoDir != "" {
+		RepoDir = repoDir
 	}
-	return files, nil
 }
 
-// Add this helper method to better detect function modifications
-func (fd *FileDiff) addFunctionIfModified(functionName string) {
-	if functionName == "" {
-		return
+// Command builds a git command honoring the configured binary and repo directory
+func Command(args ...string) *exec.Cmd {
+	cmd := exec.Command(Binary, args...)
+	if RepoDir != "" {
+		cmd.Dir = RepoDir
 	}
+	return cmd
+}
 
-	// Check if function already exists
-	for _, existing := range fd.Functions {
-		if existing == functionName {
-			return
-		}
+// ResolvePath resolves a repo-relative path (as reported by git) against RepoDir
+func ResolvePath(path string) string {
+	if RepoDir == "" || filepath.IsAbs(path) {
+		return path
 	}
-
-	// Add the function
-	fd.Functions = append(fd.Functions, functionName)
+	return filepath.Join(RepoDir, path)
 }
 
-// Update the parseDiff function to better handle function detection
-func parseDiff(diffText string) (*DiffResult, error) {
-	result := &DiffResult{}
-	scanner := bufio.NewScanner(strings.NewReader(diffText))
-
-	var currentFile *FileDiff
-	var currentFunction string
-	var lineNum int
-
-	// Regex patterns for parsing
-	fileHeaderRegex := regexp.MustCompile(`^diff --git a/(.*) b/(.*)$`) // file names
-	hunkHeaderRegex := regexp.MustCompile(`^@@ -(\d+),?(\d*) \+(\d+),?(\d*) @@ ?(.*)$`)
+// repo is the repository Configure set up, as the public package runs it
+func repo() gitdiff.Repo {
+	return gitdiff.Repo{Dir: RepoDir, Binary: Binary}
+}
 
-	for scanner.Scan() {
-		line := scanner.Text()
+// GetDiff gets the diff between two git references
+func GetDiff(from, to string) (*DiffResult, error) {
+	return repo().Diff(context.Background(), from, to)
+}
 
-		// New file diff
-		if matches := fileHeaderRegex.FindStringSubmatch(line); matches != nil {
-			if currentFile != nil {
-				result.Files = append(result.Files, *currentFile)
-			}
-			currentFile = &FileDiff{
-				OldPath: matches[1],
-				NewPath: matches[2],
-			}
-			lineNum = 0
-			currentFunction = ""
-			continue
-		}
+// GetChangedFiles returns just the list of changed file paths
+func GetChangedFiles(from, to string) ([]string, error) {
+	return repo().ChangedFiles(context.Background(), from, to)
+}
 
-		// Hunk header (contains function context)
-		if matches := hunkHeaderRegex.FindStringSubmatch(line); matches != nil {
-			if len(matches) > 5 && matches[5] != "" {
-				// Extract function name from context
-				funcContext := matches[5]
-				if extractedFunc := extractFunctionName(funcContext); extractedFunc != "" {
-					currentFunction = extractedFunc
-					if currentFile != nil {
-						currentFile.addFunctionIfModified(currentFunction)
-					}
-				}
-			}
-			lineNum = 0
-			continue
-		}
+// GetRecentCommitsForFile returns the last n commits reachable from HEAD
+// that touched path, newest first
+func GetRecentCommitsForFile(path string, n int) ([]FileCommit, error) {
+	return repo().RecentCommits(context.Background(), path, n)
+}
 
-		// Skip file metadata lines
-		if strings.HasPrefix(line, "index ") ||
-			strings.HasPrefix(line, "--- ") ||
-			strings.HasPrefix(line, "+++ ") {
-			continue
-		}
+// ParseDiff parses the output of git diff
+func ParseDiff(diffText string) (*DiffResult, error) {
+	return gitdiff.ParseDiff(diffText)
+}
 
-		// Parse actual diff content
-		if currentFile != nil {
-			change := parseDiffLine(line, currentFunction)
-			if change != nil {
-				change.LineNum = lineNum
-				currentFile.Changes = append(currentFile.Changes, *change)
+// VerifyRef reports whether ref names a commit in the repository
+func VerifyRef(ref string) bool {
+	return Command("rev-parse", "--verify", "--quiet", ref+"^{commit}").Run() == nil
+}
 
-				// If this line defines a new function, update our tracking
-				if (change.Type == Added || change.Type == Context) && strings.Contains(change.Line, "func ") {
-					if funcName := extractFunctionName(change.Line); funcName != "" {
-						currentFile.addFunctionIfModified(funcName)
-						currentFunction = funcName
-					}
-				}
-			}
-			lineNum++
-		}
+// HeadCommit returns the hash of the commit HEAD points at
+func HeadCommit() (string, error) {
+	output, err := Command("rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve HEAD: %w", err)
 	}
+	return strings.TrimSpace(string(output)), nil
+}
 
-	// Don't forget the last file cuz we add the first file in the loop after it and so on yg
-	if currentFile != nil {
-		result.Files = append(result.Files, *currentFile)
+// MergeBase returns the best common ancestor of two commits, the base git
+// uses for a from...to range
+func MergeBase(from, to string) (string, error) {
+	output, err := Command("merge-base", from, to).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to find merge base of %s and %s: %w", from, to, err)
 	}
-
-	return result, nil
+	return strings.TrimSpace(string(output)), nil
 }
 
-// GetModifiedFunctions extracts function names that were actually modified
-func (fd FileDiff) GetModifiedFunctions() []string {
-	// Track which functions have actual changes (not just context)
-	functionsWithChanges := make(map[string]bool)
-
-	for _, change := range fd.Changes {
-		// Only count functions that have additions or removals
-		if change.Type == Added || change.Type == Removed {
-			if change.Function != "" {
-				functionsWithChanges[change.Function] = true
-			}
-		}
+// Committed reports whether git can restore path as it is now: the file is
+// tracked and matches HEAD. It's false outside a repository.
+func Committed(path string) bool {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
 	}
-
-	// Convert map to slice
-	var result []string
-	for funcName := range functionsWithChanges {
-		result = append(result, funcName)
+	if Command("ls-files", "--error-unmatch", "--", path).Run() != nil {
+		return false
 	}
-
-	return result
+	return Command("diff", "--quiet", "HEAD", "--", path).Run() == nil
 }
 
-// extractFunctionName extracts function name from a function declaration line or context
-func extractFunctionName(line string) string {
-	// Clean up the line
-	line = strings.TrimSpace(line)
-
-	// Handle context lines that might have extra characters
-	if strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-") || strings.HasPrefix(line, " ") {
-		line = strings.TrimSpace(line[1:])
+// CheckoutLineEnding returns the line ending git checks a new file at path
+// out with: "\r\n" or "\n" from its eol attribute, "\r\n" when
+// core.autocrlf is true or core.eol is crlf, otherwise "". It also returns ""
+// outside a repository or for files git doesn't treat as text.
+func CheckoutLineEnding(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
 	}
-
-	// Must start with "func " to be a function declaration
-	if !strings.HasPrefix(line, "func ") {
+	output, err := Command("check-attr", "eol", "text", "--", path).Output()
+	if err != nil {
 		return ""
 	}
 
-	// Remove "func " prefix
-	line = strings.TrimPrefix(line, "func ")
-	line = strings.TrimSpace(line)
-
-	// Handle method declarations: (receiver) FunctionName(
-	if strings.HasPrefix(line, "(") {
-		// Find the closing parenthesis for receiver
-		closeParen := strings.Index(line, ") ")
-		if closeParen != -1 {
-			// Skip the receiver part: ") FunctionName("
-			line = strings.TrimSpace(line[closeParen+2:])
+	attrs := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		// Each line is "<path>: <attribute>: <value>"
+		parts := strings.Split(line, ": ")
+		if len(parts) >= 3 {
+			attrs[parts[len(parts)-2]] = strings.TrimSpace(parts[len(parts)-1])
 		}
 	}
-
-	// Now we should have: FunctionName(params...)
-	// Find the opening parenthesis
-	parenIndex := strings.Index(line, "(")
-	if parenIndex == -1 {
+	switch {
+	case attrs["text"] == "unset":
 		return ""
+	case attrs["eol"] == "crlf":
+		return "\r\n"
+	case attrs["eol"] == "lf":
+		return "\n"
 	}
 
-	// Extract function name (everything before the '(')
-	funcName := strings.TrimSpace(line[:parenIndex])
-
-	// Remove any remaining special characters
-	funcName = strings.Trim(funcName, " \t*&[]")
-
-	return funcName
-}
-
-// parseDiffLine parses a single line from the diff
-func parseDiffLine(line, currentFunction string) *DiffChange {
-	if len(line) == 0 {
-		return nil
-	}
-
-	change := &DiffChange{
-		Function: currentFunction,
-	}
-
-	switch line[0] {
-	case '+':
-		change.Type = Added
-		change.Line = line[1:]
-	case '-':
-		change.Type = Removed
-		change.Line = line[1:]
-	case ' ':
-		change.Type = Context
-		change.Line = line[1:]
-	default:
-		return nil // Skip unrecognized lines
+	if value, err := Command("config", "--get", "core.autocrlf").Output(); err == nil && strings.TrimSpace(string(value)) == "true" {
+		return "\r\n"
 	}
-
-	return change
-}
-
-// FilterGoFiles filters the diff to only include Go files
-func (dr *DiffResult) FilterGoFiles() *DiffResult {
-	filtered := &DiffResult{}
-	for _, file := range dr.Files {
-		if strings.HasSuffix(file.NewPath, ".go") && !strings.HasSuffix(file.NewPath, "_test.go") {
-			filtered.Files = append(filtered.Files, file)
-		}
+	if value, err := Command("config", "--get", "core.eol").Output(); err == nil && strings.TrimSpace(string(value)) == "crlf" {
+		return "\r\n"
 	}
-	return filtered
-}
-
-// ParseDiff is the exported version of parseDiff
-func ParseDiff(diffText string) (*DiffResult, error) {
-	return parseDiff(diffText)
+	return ""
 }
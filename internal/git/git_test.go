@@ -1,124 +1,283 @@
 package git
 
 import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
-func TestParseDiff(t *testing.T) {
-	diffOutput := `diff --git a/user.go b/user.go
-index 1234567..abcdefg 100644
---- a/user.go
-+++ b/user.go
-@@ -10,6 +10,10 @@ func ValidateUser(user *User) error {
- )
- 
- func ValidateUser(user *User) error {
-+	if user == nil {
-+		return errors.New("user is nil")
-+	}
-+	if user.Name == "" {
-+		return errors.New("name required")
-+	}
-     return nil
- }
-+
-+func CreateUser(name, email string) *User {
-+	return &User{
-+		Name:  name,
-+		Email: email,
-+	}
-+}
-@@ -30,7 +40,7 @@ func GetUser(id int) (*User, error) {
-     // This function appears in diff but has no actual changes
-     return findUser(id)
-`
-	result, err := ParseDiff(diffOutput)
+// initTestRepo creates a temporary git repository and returns its path
+func initTestRepo(t *testing.T) string {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	dir := t.TempDir()
+	runGit(t, dir, "init", "-q")
+	runGit(t, dir, "config", "user.email", "test@example.com")
+	runGit(t, dir, "config", "user.name", "Test User")
+	runGit(t, dir, "config", "commit.gpgsign", "false")
+	return dir
+}
+
+// runGit runs a git command in dir and fails the test on error
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
 	if err != nil {
-		t.Fatalf("ParseDiff failed: %v", err)
+		t.Fatalf("git %v failed: %v\n%s", args, err, output)
 	}
+	return string(output)
+}
+
+// commitFile writes a file into the repo and commits it
+func commitFile(t *testing.T, dir, name, content, message string) {
+	t.Helper()
 
-	if len(result.Files) != 1 {
-		t.Fatalf("expected 1 file, got %d", len(result.Files))
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
 	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	runGit(t, dir, "add", name)
+	runGit(t, dir, "commit", "-q", "-m", message)
+}
 
-	file := result.Files[0]
-	functions := file.GetModifiedFunctions()
+// useRepo points the package at dir (and optionally a git binary) for the duration of a test
+func useRepo(t *testing.T, binary, dir string) {
+	t.Helper()
 
-	// Debug: print what we actually found
-	t.Logf("Found functions: %v", functions)
-	t.Logf("File changes count: %d", len(file.Changes))
-	for i, change := range file.Changes {
-		if i < 5 { // Print first 5 changes for debugging
-			t.Logf("Change %d: Type=%v, Line=%q, Function=%q", i, change.Type, change.Line, change.Function)
-		}
+	originalBinary, originalDir := Binary, RepoDir
+	t.Cleanup(func() {
+		Binary, RepoDir = originalBinary, originalDir
+	})
+	Configure(binary, dir)
+}
+
+func TestCommandUsesConfiguredRepo(t *testing.T) {
+	repo := initTestRepo(t)
+	commitFile(t, repo, "user.go", "package user\n", "initial")
+	commitFile(t, repo, "user.go", "package user\n\nfunc ValidateUser() error {\n\treturn nil\n}\n", "add ValidateUser")
+
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Fatalf("Failed to locate git: %v", err)
 	}
 
-	// Should detect both ValidateUser (modified) and CreateUser (added)
-	expectedFunctions := []string{"ValidateUser", "CreateUser"}
-	if len(functions) != len(expectedFunctions) {
-		t.Errorf("expected %d functions, got %d: %v", len(expectedFunctions), len(functions), functions)
+	// The current directory (this package) is not the temp repo
+	useRepo(t, gitPath, repo)
+
+	files, err := GetChangedFiles("HEAD~1", "HEAD")
+	if err != nil {
+		t.Fatalf("GetChangedFiles failed: %v", err)
+	}
+	if len(files) != 1 || files[0] != "user.go" {
+		t.Errorf("Expected [user.go], got %v", files)
 	}
 
-	// Check that both expected functions are found
-	for _, expected := range expectedFunctions {
-		found := false
-		for _, actual := range functions {
-			if actual == expected {
-				found = true
-				break
-			}
-		}
-		if !found {
-			t.Errorf("expected function %s not found in %v", expected, functions)
-		}
+	diff, err := GetDiff("HEAD~1", "HEAD")
+	if err != nil {
+		t.Fatalf("GetDiff failed: %v", err)
+	}
+	if len(diff.Files) != 1 || diff.Files[0].NewPath != "user.go" {
+		t.Fatalf("Expected diff for user.go, got %+v", diff.Files)
+	}
+
+	if resolved := ResolvePath("user.go"); resolved != filepath.Join(repo, "user.go") {
+		t.Errorf("Expected path resolved against repo, got %s", resolved)
+	}
+}
+
+func TestCommandUsesConfiguredBinary(t *testing.T) {
+	useRepo(t, filepath.Join(t.TempDir(), "missing-git"), t.TempDir())
+
+	if _, err := GetChangedFiles("HEAD~1", "HEAD"); err == nil {
+		t.Error("Expected error when the configured git binary doesn't exist")
 	}
 }
 
-func TestExtractFunctionName(t *testing.T) {
+func TestCheckoutLineEnding(t *testing.T) {
+	repo := initTestRepo(t)
+	runGit(t, repo, "config", "core.autocrlf", "false")
+	runGit(t, repo, "config", "core.eol", "lf")
+	useRepo(t, "", repo)
+
+	path := filepath.Join(repo, "user_test.go")
+	if got := CheckoutLineEnding(path); got != "" {
+		t.Errorf("Expected no preference without attributes, got %q", got)
+	}
+
+	runGit(t, repo, "config", "core.autocrlf", "true")
+	if got := CheckoutLineEnding(path); got != "\r\n" {
+		t.Errorf("Expected \\r\\n with core.autocrlf, got %q", got)
+	}
+
+	// Attributes win over core.autocrlf
+	if err := os.WriteFile(filepath.Join(repo, ".gitattributes"), []byte("*.go text eol=lf\n*.bin -text\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := CheckoutLineEnding(path); got != "\n" {
+		t.Errorf("Expected \\n from eol=lf, got %q", got)
+	}
+	if got := CheckoutLineEnding(filepath.Join(repo, "data.bin")); got != "" {
+		t.Errorf("Expected no line ending for a -text file, got %q", got)
+	}
+
+	runGit(t, repo, "config", "core.autocrlf", "false")
+	if err := os.WriteFile(filepath.Join(repo, ".gitattributes"), []byte("*_test.go eol=crlf\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := CheckoutLineEnding(path); got != "\r\n" {
+		t.Errorf("Expected \\r\\n from eol=crlf, got %q", got)
+	}
+
+	if got := CheckoutLineEnding(filepath.Join(t.TempDir(), "elsewhere_test.go")); got != "" {
+		t.Errorf("Expected no line ending outside the repository, got %q", got)
+	}
+}
+
+func TestCommitted(t *testing.T) {
+	repo := initTestRepo(t)
+	commitFile(t, repo, "user_test.go", "package user\n", "initial")
+	useRepo(t, "", repo)
+
+	path := filepath.Join(repo, "user_test.go")
+	if !Committed(path) {
+		t.Error("Expected a committed, unmodified file to be restorable")
+	}
+
+	if err := os.WriteFile(path, []byte("package user\n\n// edited\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if Committed(path) {
+		t.Error("Expected a modified file not to be restorable")
+	}
+
+	untracked := filepath.Join(repo, "order_test.go")
+	if err := os.WriteFile(untracked, []byte("package user\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if Committed(untracked) {
+		t.Error("Expected an untracked file not to be restorable")
+	}
+
+	if Committed(filepath.Join(t.TempDir(), "elsewhere_test.go")) {
+		t.Error("Expected a file outside the repository not to be restorable")
+	}
+}
+
+func TestBlamerIntroduced(t *testing.T) {
+	repo := initTestRepo(t)
+	commitFile(t, repo, "user.go", "package user\n", "initial")
+	commitAs := func(author, content, message string) string {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(repo, "user.go"), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write user.go: %v", err)
+		}
+		runGit(t, repo, "add", "user.go")
+		runGit(t, repo, "commit", "-q", "--author", author+" <"+strings.ToLower(author)+"@example.com>", "-m", message)
+		return strings.TrimSpace(runGit(t, repo, "rev-parse", "HEAD"))
+	}
+	validate := "package user\n\nfunc ValidateUser() error {\n\treturn nil\n}\n"
+	first := commitAs("Ann", validate, "add ValidateUser")
+	second := commitAs("Ben", validate+"\nfunc CreateUser() error {\n\treturn nil\n}\n", "add CreateUser")
+	useRepo(t, "", repo)
+
+	blamer := NewBlamer("HEAD~2", "HEAD")
 	tests := []struct {
-		input    string
-		expected string
+		name       string
+		start, end int
+		want       *Introduction
 	}{
-		{"func ValidateUser(user *User) error {", "ValidateUser"},
-		{"func CreateUser(name, email string) *User {", "CreateUser"},
-		{"func main() {", "main"},
-		{"func (u *User) GetName() string {", "GetName"},
-		{"+func NewUser() *User {", "NewUser"},
-		{" func helper() {", "helper"},
-		{"not a function", ""},
-		{"", ""},
-	}
-
-	for _, test := range tests {
-		t.Run(test.input, func(t *testing.T) {
-			result := extractFunctionName(test.input)
-			if result != test.expected {
-				t.Errorf("extractFunctionName(%q) = %q, expected %q", test.input, result, test.expected)
+		{"first commit", 3, 5, &Introduction{Commit: first, Author: "Ann", Summary: "add ValidateUser"}},
+		{"second commit", 7, 9, &Introduction{Commit: second, Author: "Ben", Summary: "add CreateUser"}},
+		{"oldest commit in the span wins", 3, 9, &Introduction{Commit: first, Author: "Ann", Summary: "add ValidateUser"}},
+		{"before the range", 1, 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := blamer.Introduced("user.go", tt.start, tt.end)
+			if err != nil {
+				t.Fatalf("Introduced failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
 			}
 		})
 	}
-}
 
-func TestFilterGoFiles(t *testing.T) {
-	result := &DiffResult{
-		Files: []FileDiff{
-			{NewPath: "user.go"},
-			{NewPath: "user_test.go"}, // should be filtered out
-			{NewPath: "README.md"},    // should be filtered out
-			{NewPath: "handler.go"},
-		},
+	// The file was blamed once for all spans
+	if len(blamer.files) != 1 {
+		t.Errorf("Expected one cached blame, got %d", len(blamer.files))
 	}
+}
 
-	filtered := result.FilterGoFiles()
+func TestGetRecentCommitsForFile(t *testing.T) {
+	repo := initTestRepo(t)
+	commitAs := func(author, name, content, message string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(repo, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		runGit(t, repo, "add", name)
+		runGit(t, repo, "commit", "-q", "--author", author+" <"+strings.ToLower(author)+"@example.com>", "-m", message)
+	}
+	commitAs("Ann", "user.go", "package user\n", "add validation")
+	commitAs("Ben", "user.go", "package user\n\n// nil-safe\n", "fix nil deref")
+	commitAs("Ann", "order.go", "package user\n", "add orders")
+	commitAs("Cal", "user.go", "package user\n\n// nil-safe, unicode\n", "support unicode emails")
+	useRepo(t, "", repo)
 
-	if len(filtered.Files) != 2 {
-		t.Errorf("expected 2 Go files, got %d", len(filtered.Files))
+	tests := []struct {
+		name string
+		path string
+		n    int
+		want []FileCommit
+	}{
+		{
+			name: "newest first, capped",
+			path: "user.go",
+			n:    2,
+			want: []FileCommit{{Subject: "support unicode emails", Author: "Cal"}, {Subject: "fix nil deref", Author: "Ben"}},
+		},
+		{
+			name: "fewer commits than asked for",
+			path: filepath.Join(repo, "user.go"),
+			n:    5,
+			want: []FileCommit{
+				{Subject: "support unicode emails", Author: "Cal"},
+				{Subject: "fix nil deref", Author: "Ben"},
+				{Subject: "add validation", Author: "Ann"},
+			},
+		},
+		{name: "only the file's own commits", path: "order.go", n: 3, want: []FileCommit{{Subject: "add orders", Author: "Ann"}}},
+		{name: "untracked file", path: "missing.go", n: 3, want: nil},
+		{name: "none asked for", path: "user.go", n: 0, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetRecentCommitsForFile(tt.path, tt.n)
+			if err != nil {
+				t.Fatalf("GetRecentCommitsForFile failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
 	}
 
-	expectedFiles := []string{"user.go", "handler.go"}
-	for i, file := range filtered.Files {
-		if file.NewPath != expectedFiles[i] {
-			t.Errorf("expected %s, got %s", expectedFiles[i], file.NewPath)
-		}
+	useRepo(t, "", t.TempDir())
+	if _, err := GetRecentCommitsForFile("user.go", 3); err == nil {
+		t.Error("Expected an error outside a repository")
 	}
 }
@@ -0,0 +1,112 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CommitAuthor is the identity commits made by testgen are authored with
+const CommitAuthor = "testgen <testgen@localhost>"
+
+// TopLevel returns the root directory of the repository's working tree
+func TopLevel() (string, error) {
+	output, err := Command("rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return "", fmt.Errorf("not a git repository: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// HooksDir returns the absolute path of the directory git runs hooks from,
+// core.hooksPath when it's set, resolved from the repository root so it's
+// the same from any subdirectory
+func HooksDir() (string, error) {
+	root, err := TopLevel()
+	if err != nil {
+		return "", err
+	}
+	output, err := Command("-C", root, "rev-parse", "--git-path", "hooks").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the hooks directory: %w", err)
+	}
+	dir := strings.TrimSpace(string(output))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+	return dir, nil
+}
+
+// CheckBranchName rejects names git doesn't accept for a branch
+func CheckBranchName(name string) error {
+	if Command("check-ref-format", "--branch", name).Run() != nil {
+		return fmt.Errorf("%q is not a valid branch name", name)
+	}
+	return nil
+}
+
+// BranchExists reports whether a local branch exists
+func BranchExists(name string) bool {
+	return Command("rev-parse", "--verify", "--quiet", "refs/heads/"+name).Run() == nil
+}
+
+// AddWorktree checks HEAD out, detached, in a new worktree at path, which
+// must not exist or be empty
+func AddWorktree(path string) error {
+	if output, err := Command("worktree", "add", "--detach", "--quiet", path, "HEAD").CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to add worktree: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// RemoveWorktree removes the worktree at path, discarding its changes, and
+// deletes the directory
+func RemoveWorktree(path string) error {
+	if output, err := Command("worktree", "remove", "--force", path).CombinedOutput(); err != nil {
+		os.RemoveAll(path)
+		Command("worktree", "prune").Run()
+		return fmt.Errorf("failed to remove worktree %s: %w: %s", path, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// CommitAll commits every change in the worktree at dir as CommitAuthor and
+// returns the commit's hash. Hooks are skipped: testgen's own hooks would
+// run generate again.
+func CommitAll(dir, message string) (string, error) {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	if output, err := Command("-C", dir, "add", "--all").CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to stage files: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	name, email, _ := strings.Cut(strings.TrimSuffix(CommitAuthor, ">"), " <")
+	commit := Command("-C", dir,
+		"-c", "user.name="+name, "-c", "user.email="+email, "-c", "core.hooksPath="+os.DevNull, "-c", "commit.gpgsign=false",
+		"commit", "--quiet", "--no-verify", "--file", "-")
+	commit.Stdin = strings.NewReader(message)
+	if output, err := commit.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to commit: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	output, err := Command("-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve commit: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// SetBranch points branch at commit, moving an existing branch only when
+// force is set
+func SetBranch(branch, commit string, force bool) error {
+	args := []string{"branch"}
+	if force {
+		args = append(args, "--force")
+	}
+	if output, err := Command(append(args, branch, commit)...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to create branch %s: %w: %s", branch, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+// Package httpcache caches GET responses, provider model lists and remote
+// base configs, on disk so commands run from every git hook don't fetch them
+// again. Responses stay fresh for their Cache-Control max-age, or a
+// configured max age, and are then revalidated with If-None-Match or
+// If-Modified-Since so an unchanged resource costs a 304. Only GETs are
+// cached: generation requests are POSTs and always reach the provider.
+package httpcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dir is where responses are cached, relative to the working directory
+const Dir = ".testgen/httpcache"
+
+// StatusHeader is set on responses served from the cache: "hit" when the
+// entry was fresh, "revalidated" after a 304, "stale" when an expired entry
+// was served offline
+const StatusHeader = "X-Testgen-Cache"
+
+// credentialHeaders hold the key a request is made with; responses are
+// cached per key since providers list different models to different keys
+var credentialHeaders = []string{"Authorization", "X-Api-Key", "OpenAI-Organization", "OpenAI-Project"}
+
+// entry is a cached response
+type entry struct {
+	URL          string      `json:"url"`
+	Status       int         `json:"status"`
+	Header       http.Header `json:"header"`
+	Body         []byte      `json:"body"`
+	Expires      time.Time   `json:"expires"`
+	ETag         string      `json:"etag,omitempty"`
+	LastModified string      `json:"last_modified,omitempty"`
+}
+
+// Transport is an http.RoundTripper caching GET responses in Dir
+type Transport struct {
+	Dir    string
+	MaxAge time.Duration     // how long responses stay fresh, overriding Cache-Control (0 = as the server says)
+	Base   http.RoundTripper // makes the requests (nil = http.DefaultTransport)
+
+	Offline bool // serve cached GET responses however stale, never making them; a miss is an error
+
+	now func() time.Time
+}
+
+// New returns a Transport caching in dir
+func New(dir string, maxAge time.Duration, base http.RoundTripper) *Transport {
+	return &Transport{Dir: dir, MaxAge: maxAge, Base: base, now: time.Now}
+}
+
+// RoundTrip serves fresh GET responses from the cache, revalidates stale
+// ones and passes every other request through. Offline, GETs are only ever
+// served from the cache.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || hasDirective(req.Header, "no-store") {
+		return t.base().RoundTrip(req)
+	}
+
+	path := t.entryPath(req)
+	cached := t.load(path)
+	if t.Offline {
+		if cached == nil {
+			return nil, fmt.Errorf("%s isn't cached and requests are off", req.URL)
+		}
+		if !t.clock().Before(cached.Expires) {
+			return cached.response(req, "stale"), nil
+		}
+		return cached.response(req, "hit"), nil
+	}
+	if cached != nil && t.clock().Before(cached.Expires) && !hasDirective(req.Header, "no-cache") {
+		return cached.response(req, "hit"), nil
+	}
+
+	if cached != nil && (cached.ETag != "" || cached.LastModified != "") {
+		req = req.Clone(req.Context())
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	resp, err := t.base().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unchanged: the cached body is still good for another max age
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		resp.Body.Close()
+		cached.Expires = t.expires(resp.Header)
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			cached.ETag = etag
+		}
+		t.save(path, cached)
+		return cached.response(req, "revalidated"), nil
+	}
+
+	if resp.StatusCode != http.StatusOK || hasDirective(resp.Header, "no-store") {
+		return resp, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	fresh := &entry{
+		URL:          req.URL.String(),
+		Status:       resp.StatusCode,
+		Header:       resp.Header.Clone(),
+		Body:         body,
+		Expires:      t.expires(resp.Header),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	// Responses that are neither fresh nor revalidatable aren't worth keeping
+	if t.clock().Before(fresh.Expires) || fresh.ETag != "" || fresh.LastModified != "" {
+		t.save(path, fresh)
+	}
+	return resp, nil
+}
+
+// base returns the transport making the requests
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// clock returns the current time
+func (t *Transport) clock() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+	return time.Now()
+}
+
+// expires returns when a response with header stops being fresh
+func (t *Transport) expires(header http.Header) time.Time {
+	if t.MaxAge > 0 {
+		return t.clock().Add(t.MaxAge)
+	}
+	if hasDirective(header, "no-cache") {
+		return time.Time{}
+	}
+	for _, directive := range directives(header) {
+		if value, ok := strings.CutPrefix(directive, "max-age="); ok {
+			if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+				return t.clock().Add(time.Duration(seconds) * time.Second)
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// entryPath names the cache file for a request: its URL and credentials,
+// hashed so no key ends up in a file name
+func (t *Transport) entryPath(req *http.Request) string {
+	hash := sha256.New()
+	io.WriteString(hash, req.URL.String())
+	for _, name := range credentialHeaders {
+		io.WriteString(hash, "\n"+req.Header.Get(name))
+	}
+	return filepath.Join(t.Dir, hex.EncodeToString(hash.Sum(nil))+".json")
+}
+
+// load reads a cache entry; a missing or corrupt one is a miss
+func (t *Transport) load(path string) *entry {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var cached entry
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil
+	}
+	return &cached
+}
+
+// save writes a cache entry through a temporary file so concurrent runs
+// never read half of one. Failing to cache isn't an error.
+func (t *Transport) save(path string, cached *entry) {
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(t.Dir, 0755); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(t.Dir, ".entry-*")
+	if err != nil {
+		return
+	}
+	_, writeErr := tmp.Write(data)
+	closeErr := tmp.Close()
+	if writeErr != nil || closeErr != nil || os.Rename(tmp.Name(), path) != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+// response rebuilds the cached response for req
+func (e *entry) response(req *http.Request, status string) *http.Response {
+	header := e.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set(StatusHeader, status)
+	return &http.Response{
+		Status:        strconv.Itoa(e.Status) + " " + http.StatusText(e.Status),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// directives lists the lowercased Cache-Control directives of header
+func directives(header http.Header) []string {
+	var found []string
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			if directive = strings.ToLower(strings.TrimSpace(directive)); directive != "" {
+				found = append(found, directive)
+			}
+		}
+	}
+	return found
+}
+
+// hasDirective reports whether header's Cache-Control includes directive
+func hasDirective(header http.Header, directive string) bool {
+	for _, found := range directives(header) {
+		if found == directive {
+			return true
+		}
+	}
+	return false
+}
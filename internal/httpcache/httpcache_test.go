@@ -0,0 +1,191 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// modelServer serves a model list with an ETag, answering conditional
+// requests with 304, and records what each request looked like
+type modelServer struct {
+	mu           sync.Mutex
+	requests     int
+	conditional  int
+	cacheControl string
+	etag         string
+}
+
+func (s *modelServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	if s.cacheControl != "" {
+		w.Header().Set("Cache-Control", s.cacheControl)
+	}
+	w.Header().Set("ETag", s.etag)
+	if r.Header.Get("If-None-Match") == s.etag {
+		s.conditional++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	io.WriteString(w, `{"data":[{"id":"gpt-4"}]}`)
+}
+
+// fetch makes a request through client and returns its body and cache status
+func fetch(t *testing.T, client *http.Client, method, url string) (string, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer key")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	return string(body), resp.Header.Get(StatusHeader)
+}
+
+func TestTransportRevalidates(t *testing.T) {
+	server := &modelServer{cacheControl: "max-age=60", etag: `"v1"`}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	transport := New(t.TempDir(), 0, nil)
+	transport.now = func() time.Time { return now }
+	client := &http.Client{Transport: transport}
+
+	body, status := fetch(t, client, http.MethodGet, ts.URL+"/v1/models")
+	if status != "" || !strings.Contains(body, "gpt-4") {
+		t.Fatalf("Expected a fetched model list, got %q (%s)", body, status)
+	}
+
+	// Fresh for max-age: served without asking the server
+	now = now.Add(30 * time.Second)
+	if body, status = fetch(t, client, http.MethodGet, ts.URL+"/v1/models"); status != "hit" || !strings.Contains(body, "gpt-4") {
+		t.Errorf("Expected a cache hit, got %q (%s)", body, status)
+	}
+	if server.requests != 1 {
+		t.Errorf("Expected 1 request to the server, got %d", server.requests)
+	}
+
+	// Expired: revalidated with If-None-Match, a 304 keeps the cached body
+	now = now.Add(time.Minute)
+	if body, status = fetch(t, client, http.MethodGet, ts.URL+"/v1/models"); status != "revalidated" || !strings.Contains(body, "gpt-4") {
+		t.Errorf("Expected a revalidated cached body, got %q (%s)", body, status)
+	}
+	if server.requests != 2 || server.conditional != 1 {
+		t.Errorf("Expected a second, conditional request, got %d requests, %d conditional", server.requests, server.conditional)
+	}
+
+	// The 304 renewed freshness
+	if _, status = fetch(t, client, http.MethodGet, ts.URL+"/v1/models"); status != "hit" || server.requests != 2 {
+		t.Errorf("Expected a hit after revalidation, got %s with %d requests", status, server.requests)
+	}
+
+	// A changed resource replaces the entry
+	now = now.Add(2 * time.Minute)
+	server.etag = `"v2"`
+	if _, status = fetch(t, client, http.MethodGet, ts.URL+"/v1/models"); status != "" || server.conditional != 1 {
+		t.Errorf("Expected a full response for a changed ETag, got %s with %d conditional", status, server.conditional)
+	}
+}
+
+func TestTransportMaxAge(t *testing.T) {
+	// No Cache-Control: every request revalidates, unless a max age is set
+	server := &modelServer{etag: `"v1"`}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	transport := New(dir, 0, nil)
+	transport.now = func() time.Time { return now }
+	client := &http.Client{Transport: transport}
+
+	fetch(t, client, http.MethodGet, ts.URL)
+	if _, status := fetch(t, client, http.MethodGet, ts.URL); status != "revalidated" || server.conditional != 1 {
+		t.Errorf("Expected revalidation without max-age, got %s", status)
+	}
+
+	transport.MaxAge = time.Hour
+	fetch(t, client, http.MethodGet, ts.URL)
+	now = now.Add(59 * time.Minute)
+	if _, status := fetch(t, client, http.MethodGet, ts.URL); status != "hit" {
+		t.Errorf("Expected a hit within the max age, got %q", status)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, status := fetch(t, client, http.MethodGet, ts.URL); status != "revalidated" {
+		t.Errorf("Expected revalidation after the max age, got %q", status)
+	}
+
+	// Entries are per credentials
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	req.Header.Set("Authorization", "Bearer other")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get(StatusHeader) != "" {
+		t.Errorf("Expected another key's request to miss the cache, got %q", resp.Header.Get(StatusHeader))
+	}
+}
+
+func TestTransportNeverCachesPOST(t *testing.T) {
+	server := &modelServer{cacheControl: "max-age=3600", etag: `"v1"`}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	client := &http.Client{Transport: New(t.TempDir(), time.Hour, nil)}
+	for i := 0; i < 3; i++ {
+		if _, status := fetch(t, client, http.MethodPost, ts.URL+"/v1/chat/completions"); status != "" {
+			t.Errorf("Expected POSTs to bypass the cache, got %q", status)
+		}
+	}
+	if server.requests != 3 || server.conditional != 0 {
+		t.Errorf("Expected 3 unconditional requests, got %d (%d conditional)", server.requests, server.conditional)
+	}
+}
+
+func TestTransportOffline(t *testing.T) {
+	server := &modelServer{etag: `"v1"`}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	transport := New(t.TempDir(), time.Hour, nil)
+	transport.now = func() time.Time { return now }
+	client := &http.Client{Transport: transport}
+	fetch(t, client, http.MethodGet, ts.URL)
+
+	transport.Offline = true
+	if _, status := fetch(t, client, http.MethodGet, ts.URL); status != "hit" {
+		t.Errorf("Expected a fresh entry served offline as a hit, got %q", status)
+	}
+	now = now.Add(2 * time.Hour)
+	if body, status := fetch(t, client, http.MethodGet, ts.URL); status != "stale" || !strings.Contains(body, "gpt-4") {
+		t.Errorf("Expected the expired entry served offline as stale, got %q: %s", status, body)
+	}
+	if server.requests != 1 {
+		t.Errorf("Expected no requests offline, got %d in all", server.requests)
+	}
+
+	if _, err := client.Get(ts.URL + "/other"); err == nil || !strings.Contains(err.Error(), "isn't cached") {
+		t.Errorf("Expected a miss to fail offline, got %v", err)
+	}
+}
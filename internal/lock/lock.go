@@ -0,0 +1,205 @@
+// Package lock keeps concurrent testgen runs from racing on the same test
+// files, proposals and progress. Mutating commands hold a project lock file
+// created with O_CREATE|O_EXCL, which is atomic on every platform (no flock),
+// recording the holder's pid and start time so a lock left behind by a
+// crashed run can be recognized and taken over.
+package lock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// File is the project lock, relative to the project root, so runs from any
+// of its directories exclude each other
+const File = ".testgen/lock"
+
+// pollInterval is how often a waiting run retries the lock
+var pollInterval = 100 * time.Millisecond
+
+// beforeSteal runs once a run has judged the lock stale, before it steals
+// it; tests use it to line up competing stealers
+var beforeSteal = func() {}
+
+// unreadableGrace is how long a lock may stay unreadable, while its holder
+// writes it, before it counts as stale
+const unreadableGrace = 10 * time.Second
+
+// Info identifies the run holding a lock
+type Info struct {
+	PID       int       `json:"pid"`
+	StartedAt time.Time `json:"started_at"`
+	Command   string    `json:"command"`
+}
+
+// Options control what Acquire does when the lock is held
+type Options struct {
+	Command    string        // recorded in the lock for other runs' messages
+	Wait       time.Duration // how long to wait for the holder; 0 fails fast
+	StealStale bool          // take over a lock whose process is no longer running
+	Waiting    func(Info)    // called once if Acquire has to wait
+}
+
+// HeldError reports a lock held by another run
+type HeldError struct {
+	Path   string
+	Holder Info
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("another testgen run (%s, pid %d, started %s) holds %s; use --wait to wait for it, or --steal-stale if that process is gone",
+		e.Holder.Command, e.Holder.PID, e.Holder.StartedAt.Local().Format(time.DateTime), e.Path)
+}
+
+// Lock is a held project lock
+type Lock struct {
+	path string
+	info Info
+}
+
+// Acquire takes the lock at path, waiting for or stealing it per opts
+func Acquire(path string, opts Options) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+
+	info := Info{PID: os.Getpid(), StartedAt: time.Now().UTC(), Command: opts.Command}
+	deadline := time.Now().Add(opts.Wait)
+	notified := false
+	for {
+		err := create(path, info)
+		if err == nil {
+			return &Lock{path: path, info: info}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		holder, err := Read(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue // released in the meantime
+		}
+
+		// A stale lock is moved aside before it's removed, so a run that
+		// noticed it too can't remove the lock another run has just taken
+		if opts.StealStale && stale(path, holder, err) {
+			beforeSteal()
+			if err := steal(path, holder, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if !time.Now().Before(deadline) {
+			return nil, &HeldError{Path: path, Holder: holder}
+		}
+		if !notified && opts.Waiting != nil {
+			opts.Waiting(holder)
+			notified = true
+		}
+		time.Sleep(min(pollInterval, time.Until(deadline)))
+	}
+}
+
+// stale reports whether a lock's holder is gone: its process isn't running,
+// or the lock is still unreadable well after it was created (its holder
+// crashed before writing it)
+func stale(path string, holder Info, readErr error) bool {
+	if readErr == nil {
+		// No run holds pid 0 or less; signalling it would reach our own
+		// process group instead
+		return holder.PID <= 0 || !processAlive(holder.PID)
+	}
+	stat, err := os.Stat(path)
+	return err == nil && time.Since(stat.ModTime()) > unreadableGrace
+}
+
+// steal removes the stale lock at path, seen held by holder (or unreadable,
+// per readErr). It renames the lock to a name of its own first and only
+// removes it if it's still the one judged stale; a lock another run took in
+// the meantime is put back, unless yet another run has created one since.
+func steal(path string, holder Info, readErr error) error {
+	aside, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".stale-*")
+	if err != nil {
+		return fmt.Errorf("failed to steal stale lock %s: %w", path, err)
+	}
+	aside.Close()
+	defer os.Remove(aside.Name())
+
+	if err := os.Rename(path, aside.Name()); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil // another run got to it first
+		}
+		return fmt.Errorf("failed to steal stale lock %s: %w", path, err)
+	}
+
+	moved, movedErr := Read(aside.Name())
+	same := readErr == nil && movedErr == nil && moved.PID == holder.PID && moved.StartedAt.Equal(holder.StartedAt)
+	if readErr != nil {
+		same = movedErr != nil && stale(aside.Name(), moved, movedErr)
+	}
+	if same {
+		return nil
+	}
+	if err := os.Link(aside.Name(), path); err != nil && !errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("failed to restore lock %s: %w", path, err)
+	}
+	return nil
+}
+
+// Read returns the holder recorded in the lock at path
+func Read(path string) (Info, error) {
+	var info Info
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return info, err
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return info, fmt.Errorf("failed to parse lock %s: %w", path, err)
+	}
+	return info, nil
+}
+
+// Release removes the lock unless another run has since taken it over
+func (l *Lock) Release() error {
+	holder, err := Read(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err == nil && (holder.PID != l.info.PID || !holder.StartedAt.Equal(l.info.StartedAt)) {
+		return nil
+	}
+	if err := os.Remove(l.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to release lock %s: %w", l.path, err)
+	}
+	return nil
+}
+
+// create atomically creates the lock file, failing with os.ErrExist if it
+// is already there
+func create(path string, info Info) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return err
+		}
+		return fmt.Errorf("failed to create lock %s: %w", path, err)
+	}
+
+	data, err := json.Marshal(info)
+	if err == nil {
+		_, err = file.Write(append(data, '\n'))
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to write lock %s: %w", path, err)
+	}
+	return nil
+}
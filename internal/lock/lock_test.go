@@ -0,0 +1,272 @@
+package lock
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAcquireFailFast(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".testgen", "lock")
+
+	first, err := Acquire(path, Options{Command: "generate"})
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	defer first.Release()
+
+	// A second run without --wait fails at once, naming the holder
+	start := time.Now()
+	_, err = Acquire(path, Options{Command: "approve"})
+	var held *HeldError
+	if !errors.As(err, &held) {
+		t.Fatalf("Expected a HeldError, got %v", err)
+	}
+	if held.Holder.PID != os.Getpid() || held.Holder.Command != "generate" {
+		t.Errorf("Expected the first run as holder, got %+v", held.Holder)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected to fail fast, took %s", elapsed)
+	}
+}
+
+func TestAcquireWaits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lock")
+	original := pollInterval
+	pollInterval = 10 * time.Millisecond
+	defer func() { pollInterval = original }()
+
+	first, err := Acquire(path, Options{Command: "generate"})
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	// Two runs wait for the first; the lock passes to exactly one at a time
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	holders := 0
+	maxHolders := 0
+	errs := make(chan error, 2)
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			waited := false
+			second, err := Acquire(path, Options{Wait: 5 * time.Second, Waiting: func(Info) { waited = true }})
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !waited {
+				errs <- errors.New("expected to be told about waiting")
+			}
+			mu.Lock()
+			holders++
+			maxHolders = max(maxHolders, holders)
+			mu.Unlock()
+			time.Sleep(50 * time.Millisecond)
+			mu.Lock()
+			holders--
+			mu.Unlock()
+			errs <- second.Release()
+		}()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if err := first.Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Waiting run failed: %v", err)
+		}
+	}
+	if maxHolders != 1 {
+		t.Errorf("Expected one holder at a time, saw %d", maxHolders)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the lock to be gone after the last release, got %v", err)
+	}
+}
+
+func TestAcquireWaitTimesOut(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lock")
+
+	first, err := Acquire(path, Options{})
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	defer first.Release()
+
+	start := time.Now()
+	_, err = Acquire(path, Options{Wait: 200 * time.Millisecond})
+	var held *HeldError
+	if !errors.As(err, &held) {
+		t.Fatalf("Expected a HeldError after waiting, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Expected to wait 200ms before giving up, gave up after %s", elapsed)
+	}
+}
+
+func TestAcquireStealStale(t *testing.T) {
+	// A finished child's pid belongs to no running process
+	child := exec.Command(os.Args[0], "-test.run=^$")
+	if err := child.Run(); err != nil {
+		t.Fatalf("Failed to run child process: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "lock")
+	data, _ := json.Marshal(Info{PID: child.Process.Pid, StartedAt: time.Now().Add(-time.Hour), Command: "generate"})
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to write stale lock: %v", err)
+	}
+
+	if _, err := Acquire(path, Options{}); err == nil {
+		t.Fatal("Expected a stale lock to block without --steal-stale")
+	}
+
+	lock, err := Acquire(path, Options{StealStale: true})
+	if err != nil {
+		t.Fatalf("Expected to steal the stale lock, got %v", err)
+	}
+	holder, err := Read(path)
+	if err != nil || holder.PID != os.Getpid() {
+		t.Errorf("Expected this process to hold the lock, got %+v (%v)", holder, err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Errorf("Release failed: %v", err)
+	}
+}
+
+func TestAcquireStealStaleConcurrently(t *testing.T) {
+	original, originalHook := pollInterval, beforeSteal
+	pollInterval = time.Millisecond
+	defer func() { pollInterval, beforeSteal = original, originalHook }()
+
+	child := exec.Command(os.Args[0], "-test.run=^$")
+	if err := child.Run(); err != nil {
+		t.Fatalf("Failed to run child process: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "lock")
+	data, _ := json.Marshal(Info{PID: child.Process.Pid, StartedAt: time.Now().Add(-time.Hour), Command: "generate"})
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to write stale lock: %v", err)
+	}
+
+	// Both runs judge the same lock stale; the second steals only once the
+	// first has taken the lock over
+	var arrived atomic.Int32
+	ready := make(chan struct{})
+	beforeSteal = func() {
+		n := arrived.Add(1)
+		if n == 2 {
+			close(ready)
+		}
+		<-ready
+		for deadline := time.Now().Add(time.Second); n == 2 && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			if holder, err := Read(path); err == nil && holder.PID == os.Getpid() {
+				break
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	holders, maxHolders := 0, 0
+	errs := make(chan error, 2)
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lock, err := Acquire(path, Options{Wait: 5 * time.Second, StealStale: true})
+			if err != nil {
+				errs <- err
+				return
+			}
+			mu.Lock()
+			holders++
+			maxHolders = max(maxHolders, holders)
+			mu.Unlock()
+			time.Sleep(50 * time.Millisecond)
+			mu.Lock()
+			holders--
+			mu.Unlock()
+			errs <- lock.Release()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Stealing run failed: %v", err)
+		}
+	}
+	if maxHolders != 1 {
+		t.Errorf("Expected one holder at a time, saw %d", maxHolders)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 0 {
+		t.Errorf("Expected no lock or stolen copies left, got %d files", len(entries))
+	}
+}
+
+func TestAcquireStealsZeroedLock(t *testing.T) {
+	// No process holds pid 0, though signalling it succeeds
+	path := filepath.Join(t.TempDir(), "lock")
+	data, _ := json.Marshal(Info{StartedAt: time.Now().Add(-time.Hour)})
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to write zeroed lock: %v", err)
+	}
+
+	lock, err := Acquire(path, Options{StealStale: true})
+	if err != nil {
+		t.Fatalf("Expected to steal a lock recording pid 0, got %v", err)
+	}
+	lock.Release()
+}
+
+func TestAcquireNeverStealsLiveLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lock")
+
+	first, err := Acquire(path, Options{})
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	defer first.Release()
+
+	if _, err := Acquire(path, Options{StealStale: true}); err == nil {
+		t.Error("Expected a lock held by a running process not to be stolen")
+	}
+}
+
+func TestReleaseKeepsOtherHoldersLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lock")
+
+	lock, err := Acquire(path, Options{})
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	// Another run took the lock over in the meantime
+	data, _ := json.Marshal(Info{PID: os.Getpid(), StartedAt: time.Now().Add(time.Minute)})
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to rewrite lock: %v", err)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected the other run's lock to remain, got %v", err)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package lock
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processAlive reports whether a process with pid is running. Signal 0
+// checks for existence without signalling; EPERM means it exists but
+// belongs to another user.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package lock
+
+import (
+	"errors"
+	"syscall"
+)
+
+const (
+	processQueryLimitedInformation = 0x1000
+	stillActive                    = 259
+)
+
+// processAlive reports whether a process with pid is running. A handle can
+// outlive its process, so opening it isn't enough: the exit code stays
+// STILL_ACTIVE until it exits. Access denied means it exists but belongs to
+// another user.
+func processAlive(pid int) bool {
+	handle, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return errors.Is(err, syscall.ERROR_ACCESS_DENIED)
+	}
+	defer syscall.CloseHandle(handle)
+
+	var code uint32
+	if err := syscall.GetExitCodeProcess(handle, &code); err != nil {
+		return true
+	}
+	return code == stillActive
+}
@@ -3,270 +3,500 @@ package parser
 import (
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
-func TestParseFile(t *testing.T) {
-	// Create a temporary Go file for testing
-	testCode := `package main
+// setPlatform targets goos/goarch for the rest of the test
+func setPlatform(t *testing.T, goos, goarch string) {
+	t.Helper()
+	t.Cleanup(func() { GOOS, GOARCH = "", "" })
+	GOOS, GOARCH = goos, goarch
+}
 
-import (
-	"errors"
-	"fmt"
-	"strings"
-)
+func TestMatchesPlatform(t *testing.T) {
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"portable.go":           "package p\n",
+		"paths_windows.go":      "package p\n",
+		"paths_linux.go":        "package p\n",
+		"signals.go":            "//go:build linux || darwin\n\npackage p\n",
+		"nonwindows.go":         "//go:build !windows\n\npackage p\n",
+		"simd_windows_arm64.go": "package p\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
 
-const MaxUsers = 100
+	// Without a target platform every file is analyzed
+	for name := range files {
+		if match, err := MatchesPlatform(filepath.Join(tmpDir, name)); err != nil || !match {
+			t.Errorf("%s: expected a match without a target platform, got %t (%v)", name, match, err)
+		}
+	}
 
-type User struct {
-	Name  string
-	Email string
-}
+	setPlatform(t, "windows", "amd64")
 
-// ValidateUser checks if a user is valid
-func ValidateUser(user *User) error {
-	if user == nil {
-		return errors.New("user cannot be nil")
-	}
-	if user.Email == "" {
-		return errors.New("email required")
+	tests := []struct {
+		file     string
+		match    bool
+		specific bool
+		tag      string
+	}{
+		{"portable.go", true, false, ""},
+		{"paths_windows.go", true, true, "windows && amd64"},
+		{"paths_linux.go", false, true, "windows && amd64"},
+		{"signals.go", false, true, "windows && amd64"},
+		{"nonwindows.go", false, false, ""},
+		{"simd_windows_arm64.go", false, true, "windows && amd64"},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(tmpDir, tt.file)
+		match, err := MatchesPlatform(path)
+		if err != nil {
+			t.Fatalf("%s: MatchesPlatform failed: %v", tt.file, err)
+		}
+		if match != tt.match {
+			t.Errorf("%s: expected match %t, got %t", tt.file, tt.match, match)
+		}
+		if got := IsPlatformSpecific(path); got != tt.specific {
+			t.Errorf("%s: expected platform-specific %t, got %t", tt.file, tt.specific, got)
+		}
+		if got := PlatformBuildTag(path); got != tt.tag {
+			t.Errorf("%s: expected build tag %q, got %q", tt.file, tt.tag, got)
+		}
 	}
-	if !strings.Contains(user.Email, "@") {
-		return errors.New("invalid email format")
+
+	setPlatform(t, "windows", "")
+	if got := PlatformBuildTag(filepath.Join(tmpDir, "paths_windows.go")); got != "windows" {
+		t.Errorf("Expected GOOS-only build tag, got %q", got)
 	}
-	return nil
 }
 
-// GetName returns the user's name
-func (u *User) GetName() string {
-	if u == nil {
-		return ""
+func TestGoLanguageVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		goMod    string
+		expected string
+	}{
+		{"minor only", "module example.com/a\n\ngo 1.16\n", "1.16"},
+		{"patch version", "module example.com/a\n\ngo 1.22.2\n\ntoolchain go1.22.5\n", "1.22.2"},
+		{"trailing comment", "module example.com/a\n\ngo 1.21 // pinned\n", "1.21"},
+		{"require block before go", "module example.com/a\n\nrequire (\n\tgolang.org/x/mod v0.1.0\n)\n\ngo 1.18\n", "1.18"},
+		{"no go directive", "module example.com/a\n", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			goModPath := filepath.Join(t.TempDir(), "go.mod")
+			if err := os.WriteFile(goModPath, []byte(tt.goMod), 0644); err != nil {
+				t.Fatalf("Failed to write go.mod: %v", err)
+			}
+
+			version, err := GoLanguageVersion(goModPath)
+			if err != nil {
+				t.Fatalf("GoLanguageVersion failed: %v", err)
+			}
+			if version != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, version)
+			}
+		})
 	}
-	return u.Name
-}
 
-func processUsers(users []User, handler func(User) error) (int, error) {
-	count := 0
-	for _, user := range users {
-		if err := handler(user); err != nil {
-			return count, err
+	if _, err := GoLanguageVersion(filepath.Join(t.TempDir(), "missing.mod")); err == nil {
+		t.Error("Expected error for missing go.mod")
+	}
+
+	// Version comparisons used for feature gating
+	gates := []struct {
+		version string
+		minor   int
+		atLeast bool
+	}{
+		{"1.16", 17, false},
+		{"1.17", 17, true},
+		{"1.21rc1", 21, true},
+		{"1.22.2", 18, true},
+		{"", 18, true},
+	}
+	for _, gate := range gates {
+		if got := GoVersionAtLeast(gate.version, gate.minor); got != gate.atLeast {
+			t.Errorf("GoVersionAtLeast(%q, %d) = %t, expected %t", gate.version, gate.minor, got, gate.atLeast)
 		}
-		count++
 	}
-	return count, nil
 }
 
-func startWorker() {
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				panic(r)
-			}
-		}()
-		// worker logic
-	}()
-}`
-
-	// Write test file
-	tmpDir := t.TempDir()
-	testFile := filepath.Join(tmpDir, "test.go")
-	err := os.WriteFile(testFile, []byte(testCode), 0644)
+func TestReceiverDefinition(t *testing.T) {
+	// Account and Status are declared in types.go, their methods in methods.go
+	decls, err := ParsePackageDecls(filepath.Join("testdata", "receiver", "methods.go"))
 	if err != nil {
-		t.Fatalf("Failed to write test file: %v", err)
+		t.Fatalf("ParsePackageDecls failed: %v", err)
 	}
 
-	// Parse the file
-	analysis, err := ParseFile(testFile)
-	if err != nil {
-		t.Fatalf("ParseFile failed: %v", err)
+	tests := []struct {
+		receiver string
+		contains []string
+		excludes []string
+		err      string
+	}{
+		{
+			receiver: "*Account",
+			contains: []string{"// Account is declared here", "type Account struct", "Balance int64 // in cents", "type audit struct", "type Status int"},
+			excludes: []string{"MaxBalance"},
+		},
+		{
+			receiver: "Status",
+			contains: []string{"type Status int", "Pending Status = iota", "Closed"},
+			excludes: []string{"MaxBalance", "type Account"},
+		},
+		{
+			receiver: "Ledger",
+			contains: []string{"type Ledger = Account", "type Account struct", "type audit struct"},
+		},
+		{receiver: "Client", err: "alias of http.Client from another package"},
+		{receiver: "Missing", err: "type Missing is not declared in package account"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.receiver, func(t *testing.T) {
+			definition, err := decls.ReceiverDefinition(tt.receiver)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReceiverDefinition failed: %v", err)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(definition, want) {
+					t.Errorf("Expected definition to contain %q, got:\n%s", want, definition)
+				}
+			}
+			for _, unwanted := range tt.excludes {
+				if strings.Contains(definition, unwanted) {
+					t.Errorf("Expected definition not to contain %q, got:\n%s", unwanted, definition)
+				}
+			}
+		})
 	}
+}
 
-	// Test package name
-	if analysis.PackageName != "main" {
-		t.Errorf("Expected package name 'main', got '%s'", analysis.PackageName)
+func TestReceiverDefinitionRecursiveTypes(t *testing.T) {
+	decls, err := ParsePackageDecls(filepath.Join("testdata", "recursive", "tree.go"))
+	if err != nil {
+		t.Fatalf("ParsePackageDecls failed: %v", err)
 	}
 
-	// Test imports
-	expectedImports := []string{"errors", "fmt", "strings"}
-	if len(analysis.Imports) != len(expectedImports) {
-		t.Errorf("Expected %d imports, got %d", len(expectedImports), len(analysis.Imports))
+	// Node refers to itself twice and Element and List to each other; each
+	// is rendered once and otherwise referenced by name
+	tests := []struct {
+		receiver string
+		once     []string
+		contains []string
+	}{
+		{
+			receiver: "*Node",
+			once:     []string{"type Node struct", "type Level1 struct", "type Level3 struct"},
+			contains: []string{"// Not expanded (nesting limit): Level4"},
+		},
+		{
+			receiver: "List",
+			once:     []string{"type List struct", "type Element struct"},
+		},
+		{
+			receiver: "*Element",
+			once:     []string{"type Element struct", "type List struct"},
+		},
 	}
 
-	// Test constants
-	if _, exists := analysis.Constants["MaxUsers"]; !exists {
-		t.Error("Expected MaxUsers constant to be found")
+	for _, tt := range tests {
+		t.Run(tt.receiver, func(t *testing.T) {
+			definition, err := decls.ReceiverDefinition(tt.receiver)
+			if err != nil {
+				t.Fatalf("ReceiverDefinition failed: %v", err)
+			}
+			for _, want := range tt.once {
+				if count := strings.Count(definition, want); count != 1 {
+					t.Errorf("Expected %q once, found %d times in:\n%s", want, count, definition)
+				}
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(definition, want) {
+					t.Errorf("Expected definition to contain %q, got:\n%s", want, definition)
+				}
+			}
+		})
 	}
+}
 
-	// Test functions
-	if len(analysis.Functions) != 4 {
-		t.Errorf("Expected 4 functions, got %d", len(analysis.Functions))
+func TestBuildCallGraph(t *testing.T) {
+	// parseAmount is declared in amount.go and called from invoice.go
+	files, err := ParsePackage(filepath.Join("testdata", "blastradius", "amount.go"))
+	if err != nil {
+		t.Fatalf("ParsePackage failed: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected both package files, got %d", len(files))
 	}
 
-	// Test ValidateUser function
-	var validateUser *FunctionInfo
-	for _, fn := range analysis.Functions {
-		if fn.Name == "ValidateUser" {
-			validateUser = &fn
-			break
+	graph := BuildCallGraph(files)
+	callers := func(name string) []string {
+		var names []string
+		for _, fn := range graph.Callers(name) {
+			names = append(names, CallName(fn))
 		}
+		return names
 	}
 
-	if validateUser == nil {
-		t.Fatal("ValidateUser function not found")
+	if got, want := callers("parseAmount"), []string{"Total", "Invoice.Add", "sumParsed"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected parseAmount callers %v, got %v", want, got)
+	}
+	// Method calls on the receiver are attributed to the receiver's type
+	if got, want := callers("Invoice.Add"), []string{"Invoice.AddAll"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected Invoice.Add callers %v, got %v", want, got)
 	}
+	if got := callers("Describe"); len(got) != 0 {
+		t.Errorf("Expected no callers of Describe, got %v", got)
+	}
+}
 
-	// Test function signature
-	expectedSig := "func ValidateUser(user *User) error"
-	if validateUser.Signature != expectedSig {
-		t.Errorf("Expected signature '%s', got '%s'", expectedSig, validateUser.Signature)
+func TestParseTestValues(t *testing.T) {
+	values, err := ParseTestValues(filepath.Join("testdata", "testvalues", "slug.go"))
+	if err != nil {
+		t.Fatalf("ParseTestValues failed: %v", err)
 	}
 
-	// Test parameters
-	if len(validateUser.Parameters) != 1 {
-		t.Errorf("Expected 1 parameter, got %d", len(validateUser.Parameters))
+	// Table entries first, then literal calls; entries with computed values are skipped
+	want := []string{
+		`{name: "spaces", title: "Hello World", want: "hello-world"}`,
+		`{name: "trimmed", title: "  Go  ", want: "go"}`,
+		`Make("")`,
 	}
-	if validateUser.Parameters[0].Name != "user" || validateUser.Parameters[0].Type != "*User" {
-		t.Errorf("Expected parameter 'user *User', got '%s %s'",
-			validateUser.Parameters[0].Name, validateUser.Parameters[0].Type)
+	if got := values.ExamplesFor("Make", ""); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected examples for Make:\n%q\ngot:\n%q", want, got)
 	}
 
-	// Test return types
-	if len(validateUser.Returns) != 1 || validateUser.Returns[0].Type != "error" {
-		t.Errorf("Expected return type 'error', got %v", validateUser.Returns)
+	// Methods are matched by TestType_Method, and map tables keep their keys
+	want = []string{
+		`"short": {max: 10, in: "go", want: "go"}`,
+		`"long": {max: 2, in: "golang", want: "go"}`,
+	}
+	if got := values.ExamplesFor("Cut", "*Limiter"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected examples for Limiter.Cut:\n%q\ngot:\n%q", want, got)
 	}
 
-	// Test complexity analysis
-	if !validateUser.Complexity.HasErrors {
-		t.Error("Expected HasErrors to be true")
+	if got := values.ExamplesFor("Missing", ""); len(got) != 0 {
+		t.Errorf("Expected no examples for an untested function, got %q", got)
 	}
-	if !validateUser.Complexity.HasPointers {
-		t.Error("Expected HasPointers to be true")
+}
+
+func TestExamplesForCap(t *testing.T) {
+	values := &TestValues{calls: map[string][]string{}, tables: map[string][]string{}}
+	for i := 0; i < maxExampleValues+3; i++ {
+		values.calls["Add"] = append(values.calls["Add"], strings.Repeat("x", i+1))
 	}
-	if validateUser.Complexity.ControlFlowCount != 3 { // 3 if statements
-		t.Errorf("Expected ControlFlowCount 3, got %d", validateUser.Complexity.ControlFlowCount)
+	if got := values.ExamplesFor("Add", ""); len(got) != maxExampleValues {
+		t.Errorf("Expected %d examples at most, got %d", maxExampleValues, len(got))
 	}
+}
 
-	// Test method parsing (GetName)
-	var getName *FunctionInfo
-	for _, fn := range analysis.Functions {
-		if fn.Name == "GetName" {
-			getName = &fn
-			break
+func TestInterfaceMethods(t *testing.T) {
+	file := filepath.Join("testdata", "interfaces", "store.go")
+	decls, err := ParsePackageDecls(file)
+	if err != nil {
+		t.Fatalf("ParsePackageDecls failed: %v", err)
+	}
+	imports := []ImportInfo{{Path: "errors"}, {Path: "io"}}
+
+	tests := []struct {
+		typeExpr string
+		want     []string
+		ok       bool
+	}{
+		{"Store", []string{"Load(key string) ([]byte, error)", "Close() error", "Save(key string, value []byte) error"}, true},
+		{"Backend", []string{"Load(key string) ([]byte, error)", "Close() error", "Save(key string, value []byte) error"}, true},
+		{"io.ReadWriter", []string{"Read(p []byte) (n int, err error)", "Write(p []byte) (n int, err error)"}, true},
+		{"memoryStore", nil, false},
+		{"io.EOF", nil, false},
+	}
+	for _, tt := range tests {
+		methods, ok, err := decls.InterfaceMethods(tt.typeExpr, imports)
+		if err != nil {
+			t.Errorf("InterfaceMethods(%s) failed: %v", tt.typeExpr, err)
+		}
+		if ok != tt.ok || !reflect.DeepEqual(methods, tt.want) {
+			t.Errorf("InterfaceMethods(%s) = %v, %t; want %v, %t", tt.typeExpr, methods, ok, tt.want, tt.ok)
 		}
 	}
 
-	if getName == nil {
-		t.Fatal("GetName method not found")
+	if _, _, err := decls.InterfaceMethods("yaml.Node", imports); err == nil {
+		t.Error("Expected an error for a package that isn't imported")
 	}
+}
 
-	if !getName.IsMethod {
-		t.Error("GetName should be identified as a method")
+func TestConcreteTypesIn(t *testing.T) {
+	decls, err := ParsePackageDecls(filepath.Join("testdata", "interfaces", "store.go"))
+	if err != nil {
+		t.Fatalf("ParsePackageDecls failed: %v", err)
 	}
-	if getName.Receiver == nil || getName.Receiver.Type != "*User" {
-		t.Errorf("Expected receiver '*User', got %v", getName.Receiver)
+
+	tests := []struct {
+		body   string
+		result int
+		want   []string
+	}{
+		{"{\n\ts := &memoryStore{}\n\treturn s, nil\n}", 0, []string{"*memoryStore"}},
+		{"{\n\treturn memoryStore{records: nil}, nil\n}", 0, []string{"memoryStore"}},
+		{"{\n\treturn new(memoryStore), nil\n}", 0, []string{"*memoryStore"}},
+		{"{\n\tf := func() Store { return &memoryStore{} }\n\treturn f(), nil\n}", 0, nil},
+		{"{\n\treturn nil, errors.New(\"x\")\n}", 0, nil},
+		{"{\n\treturn &memoryStore{}, nil\n}", 1, nil},
+	}
+	for _, tt := range tests {
+		if got := decls.ConcreteTypesIn(tt.body, tt.result); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ConcreteTypesIn(%q, %d) = %v, want %v", tt.body, tt.result, got, tt.want)
+		}
 	}
+}
 
-	// Test complex function (startWorker)
-	var startWorker *FunctionInfo
-	for _, fn := range analysis.Functions {
-		if fn.Name == "startWorker" {
-			startWorker = &fn
-			break
+func TestChangeFocus(t *testing.T) {
+	source := `package orders
+
+func Process(items []int) int {
+	total := 0
+	for _, item := range items {
+		if item < 0 {
+
+			continue
 		}
+		total += item
+	}
+	return total
+}
+`
+	path := filepath.Join(t.TempDir(), "orders.go")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
 	}
 
-	if startWorker == nil {
-		t.Fatal("startWorker function not found")
+	// Line 7 is blank, line 1 is outside the function
+	lines, branches, err := ChangeFocus(path, 3, []int{8, 7, 1})
+	if err != nil {
+		t.Fatalf("ChangeFocus failed: %v", err)
+	}
+	if want := []string{"8: continue"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("Expected changed lines %v, got %v", want, lines)
+	}
+	if want := []string{"5: for _, item := range items {", "6: if item < 0 {"}; !reflect.DeepEqual(branches, want) {
+		t.Errorf("Expected enclosing branches %v, got %v", want, branches)
 	}
 
-	if !startWorker.Complexity.HasGoroutines {
-		t.Error("Expected HasGoroutines to be true")
+	// A changed branch header is a changed line, not an enclosing branch
+	lines, branches, err = ChangeFocus(path, 3, []int{6})
+	if err != nil {
+		t.Fatalf("ChangeFocus failed: %v", err)
 	}
-	if !startWorker.Complexity.HasDefers {
-		t.Error("Expected HasDefers to be true")
+	if !reflect.DeepEqual(lines, []string{"6: if item < 0 {"}) || !reflect.DeepEqual(branches, []string{"5: for _, item := range items {"}) {
+		t.Errorf("Unexpected focus %v inside %v", lines, branches)
 	}
-	if !startWorker.Complexity.HasPanic {
-		t.Error("Expected HasPanic to be true")
+
+	if _, _, err := ChangeFocus(path, 4, []int{8}); err == nil {
+		t.Error("Expected an error when no function starts at the line")
 	}
 }
 
-func TestFilterFunctions(t *testing.T) {
-	analysis := &FileAnalysis{
-		Functions: []FunctionInfo{
-			{Name: "ValidateUser"},
-			{Name: "GetName"},
-			{Name: "processUsers"},
-			{Name: "startWorker"},
-		},
+func TestParseFuzzSeeds(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"codec.go": "package codec\n\nfunc Pad(s string, n int64, raw []byte) string { return s }\n\nfunc Small(b byte) byte { return b }\n",
+		"callers.go": "package codec\n\nfunc use(name string) {\n" +
+			"\tPad(\"id\", 4, []byte(\"x\"))\n" +
+			"\tPad(\"id\", 4, []byte(\"x\"))\n" +
+			"\tPad(name, 4, nil)\n" +
+			"\tSmall(300)\n\tSmall('a')\n\tSmall(-1)\n}\n",
+		"codec_test.go": "package codec\n\nimport \"testing\"\n\nfunc TestPad(t *testing.T) {\n\tPad(\"\", -2, []byte(\"\"))\n}\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
 	}
 
-	// Filter for specific functions (like from git diff)
-	filtered := analysis.FilterFunctions([]string{"ValidateUser", "startWorker"})
-
-	if len(filtered) != 2 {
-		t.Errorf("Expected 2 filtered functions, got %d", len(filtered))
+	seeds, err := ParseFuzzSeeds(filepath.Join(dir, "codec.go"))
+	if err != nil {
+		t.Fatalf("ParseFuzzSeeds failed: %v", err)
 	}
 
-	expectedNames := []string{"ValidateUser", "startWorker"}
-	for i, fn := range filtered {
-		if fn.Name != expectedNames[i] {
-			t.Errorf("Expected function '%s', got '%s'", expectedNames[i], fn.Name)
-		}
+	// Call sites before tests, deduplicated, converted to the parameter types
+	want := []string{`"id", int64(4), []byte("x")`, `"", int64(-2), []byte("")`}
+	if got := seeds.SeedsFor("Pad", []string{"string", "int64", "[]byte"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected seeds for Pad:\n%q\ngot:\n%q", want, got)
 	}
-}
 
-func TestExtractTypeString(t *testing.T) {
-	tests := []struct {
-		description string
-		// We can't easily test this without creating AST nodes,
-		// but the logic is tested implicitly in the main test
-	}{
-		{"pointer types should have * prefix"},
-		{"slice types should have [] prefix"},
-		{"map types should have map[key]value format"},
-		{"channel types should indicate direction"},
+	// Literals that overflow the parameter type are left out
+	if got := seeds.SeedsFor("Small", []string{"byte"}); !reflect.DeepEqual(got, []string{"byte('a')"}) {
+		t.Errorf("Expected only the rune seed for Small, got %q", got)
 	}
 
-	// This is more of a documentation test
-	for _, test := range tests {
-		t.Log(test.description)
+	// Unfuzzable parameters get no seeds
+	if got := seeds.SeedsFor("Pad", []string{"string", "time.Duration", "[]byte"}); len(got) != 0 {
+		t.Errorf("Expected no seeds for an unfuzzable parameter, got %q", got)
 	}
 }
 
-func TestBuildSignatureString(t *testing.T) {
-	// Test regular function
-	funcInfo := FunctionInfo{
-		Name: "ValidateUser",
-		Parameters: []ParameterInfo{
-			{Name: "user", Type: "*User"},
-		},
-		Returns: []ReturnInfo{
-			{Type: "error"},
-		},
+func TestGenericReceivers(t *testing.T) {
+	file := filepath.Join("testdata", "generics", "cache.go")
+	analysis, err := ParseFile(file)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
 	}
 
-	expected := "func ValidateUser(user *User) error"
-	signature := buildSignatureString(funcInfo)
-	if signature != expected {
-		t.Errorf("Expected '%s', got '%s'", expected, signature)
+	want := map[string]struct {
+		receiver   string
+		typeParams []string
+		signature  string
+	}{
+		"Get": {"*Cache[K, V]", []string{"K", "V"}, "func (c *Cache[K, V]) Get(k K) (V, bool)"},
+		"Put": {"*Cache[Key, Val]", []string{"Key", "Val"}, "func (c *Cache[Key, Val]) Put(k Key, v Val)"},
+		"Add": {"Totals[K, N]", []string{"K", "N"}, "func (t Totals[K, N]) Add(k K, n N) N"},
 	}
-
-	// Test method
-	methodInfo := FunctionInfo{
-		Name:     "GetName",
-		IsMethod: true,
-		Receiver: &ReceiverInfo{
-			Name: "u",
-			Type: "*User",
-		},
-		Returns: []ReturnInfo{
-			{Type: "string"},
-		},
+	for _, fn := range analysis.Functions {
+		expected, ok := want[fn.Name]
+		if !ok {
+			continue
+		}
+		delete(want, fn.Name)
+		if fn.Receiver == nil || fn.Receiver.Type != expected.receiver || !reflect.DeepEqual(fn.Receiver.TypeParams, expected.typeParams) {
+			t.Errorf("%s: expected receiver %s %v, got %+v", fn.Name, expected.receiver, expected.typeParams, fn.Receiver)
+		}
+		if fn.Signature != expected.signature {
+			t.Errorf("%s: expected signature %q, got %q", fn.Name, expected.signature, fn.Signature)
+		}
+		if CallName(fn) != BaseTypeName(expected.receiver)+"."+fn.Name {
+			t.Errorf("%s: expected call name on the base type, got %s", fn.Name, CallName(fn))
+		}
+	}
+	if len(want) != 0 {
+		t.Errorf("Methods not found: %v", want)
 	}
 
-	expectedMethod := "func (u *User) GetName() string"
-	methodSignature := buildSignatureString(methodInfo)
-	if methodSignature != expectedMethod {
-		t.Errorf("Expected '%s', got '%s'", expectedMethod, methodSignature)
+	decls, err := ParsePackageDecls(file)
+	if err != nil {
+		t.Fatalf("ParsePackageDecls failed: %v", err)
+	}
+	if got := decls.TypeParamConstraints("*Cache[Key, Val]"); !reflect.DeepEqual(got, []string{"comparable", "any"}) {
+		t.Errorf("Expected Cache constraints, got %q", got)
+	}
+	if got := decls.TypeParamConstraints("Totals[K, N]"); !reflect.DeepEqual(got, []string{"comparable", "~int64 | ~float64"}) {
+		t.Errorf("Expected Totals constraints, got %q", got)
+	}
+	if got := decls.TypeParamConstraints("Missing"); got != nil {
+		t.Errorf("Expected no constraints for an undeclared type, got %q", got)
 	}
 }
@@ -0,0 +1,88 @@
+package parser
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CallGraph maps each function of a package, by CallName, to the package
+// functions that call it directly
+type CallGraph map[string][]FunctionInfo
+
+// ParsePackage parses every file of the package containing filePath. Files
+// that fail to parse are skipped.
+func ParsePackage(filePath string) ([]*FileAnalysis, error) {
+	_, paths, err := packageFiles(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*FileAnalysis
+	for _, path := range paths {
+		analysis, err := ParseFile(path)
+		if err != nil {
+			continue
+		}
+		files = append(files, analysis)
+	}
+	return files, nil
+}
+
+// BuildCallGraph records who calls whom among the functions of a package's
+// files, as found by CallName in each function's calls
+func BuildCallGraph(files []*FileAnalysis) CallGraph {
+	graph := make(CallGraph)
+	for _, file := range files {
+		for _, fn := range file.Functions {
+			for _, callee := range fn.Calls {
+				if callee != CallName(fn) {
+					graph[callee] = append(graph[callee], fn)
+				}
+			}
+		}
+	}
+	return graph
+}
+
+// Callers returns the functions calling the named function directly
+func (g CallGraph) Callers(name string) []FunctionInfo {
+	return g[name]
+}
+
+// packageFiles lists the files of the package containing filePath: its
+// non-test files in the same directory that build for the --goos/--goarch
+// target and share its package clause
+func packageFiles(filePath string) (string, []string, error) {
+	fset := token.NewFileSet()
+	clause, err := parser.ParseFile(fset, filePath, nil, parser.PackageClauseOnly)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse file %s: %w", filePath, err)
+	}
+
+	dir := filepath.Dir(filePath)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read package directory %s: %w", dir, err)
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if match, err := MatchesPlatform(path); err != nil || !match {
+			continue
+		}
+		if file, err := parser.ParseFile(fset, path, nil, parser.PackageClauseOnly); err != nil || file.Name.Name != clause.Name.Name {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return clause.Name.Name, paths, nil
+}
@@ -0,0 +1,92 @@
+package parser
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// ChangeFocus describes what a diff changed inside the function declared at
+// line start of filePath: the source of the changed lines, as
+// "45: if age < 0 {", and the headers of the branches and loops enclosing
+// them, as "40: switch kind {", in source order. Blank changed lines and
+// lines outside the function are left out.
+func ChangeFocus(filePath string, start int, lines []int) ([]string, []string, error) {
+	src, err := ReadSource(filePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse file %s: %w", filePath, err)
+	}
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, filePath, src, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse file %s: %w", filePath, err)
+	}
+
+	var funcDecl *ast.FuncDecl
+	for _, decl := range node.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Body != nil && fset.Position(fn.Pos()).Line == start {
+			funcDecl = fn
+			break
+		}
+	}
+	if funcDecl == nil {
+		return nil, nil, fmt.Errorf("no function declared at %s:%d", filePath, start)
+	}
+	end := fset.Position(funcDecl.End()).Line
+
+	source := strings.Split(string(src), "\n")
+	sourceLine := func(line int) string {
+		return fmt.Sprintf("%d: %s", line, strings.TrimSpace(source[line-1]))
+	}
+
+	changed := make(map[int]bool)
+	var changedLines []int
+	for _, line := range lines {
+		if line >= start && line <= end && line <= len(source) && !changed[line] {
+			changed[line] = true
+			changedLines = append(changedLines, line)
+		}
+	}
+	sort.Ints(changedLines)
+	var focus []string
+	for _, line := range changedLines {
+		if strings.TrimSpace(source[line-1]) != "" {
+			focus = append(focus, sourceLine(line))
+		}
+	}
+
+	// Branches whose span holds a changed line, other than the changed line itself
+	headers := make(map[int]bool)
+	ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.IfStmt, *ast.ForStmt, *ast.RangeStmt, *ast.SwitchStmt, *ast.TypeSwitchStmt,
+			*ast.SelectStmt, *ast.CaseClause, *ast.CommClause, *ast.FuncLit:
+		default:
+			return true
+		}
+		from, to := fset.Position(n.Pos()).Line, fset.Position(n.End()).Line
+		for line := range changed {
+			if line > from && line <= to {
+				headers[from] = true
+				break
+			}
+		}
+		return true
+	})
+	var branchLines []int
+	for line := range headers {
+		if !changed[line] {
+			branchLines = append(branchLines, line)
+		}
+	}
+	sort.Ints(branchLines)
+	branches := make([]string, len(branchLines))
+	for i, line := range branchLines {
+		branches[i] = sourceLine(line)
+	}
+
+	return focus, branches, nil
+}
@@ -0,0 +1,242 @@
+package parser
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxFuzzSeeds caps how many seeds SeedsFor returns; a fuzz corpus needs a
+// few representative inputs, not every call
+const maxFuzzSeeds = 8
+
+// fuzzableTypes are the parameter types testing.F accepts in f.Add and
+// f.Fuzz, with the bit size of the integer ones (0 for the others)
+var fuzzableTypes = map[string]int{
+	"string": 0, "[]byte": 0, "bool": 0, "float32": 0, "float64": 0,
+	"int": 64, "int8": 8, "int16": 16, "int32": 32, "int64": 64, "rune": 32,
+	"uint": 64, "uint8": 8, "uint16": 16, "uint32": 32, "uint64": 64, "byte": 8,
+}
+
+// literalArg is a call argument made of a basic literal
+type literalArg struct {
+	kind  token.Token // STRING, INT, FLOAT or CHAR, or IDENT for true/false
+	value string      // as written, with its sign
+	bytes bool        // a []byte("...") conversion of a string literal
+}
+
+// FuzzSeeds holds the literal arguments a package passes to its functions:
+// the argument lists of calls made only of basic literals, by called
+// function or method name, from its source files first and then its tests
+type FuzzSeeds struct {
+	calls map[string][][]literalArg
+}
+
+// ParseFuzzSeeds scans the package containing filePath, its source files
+// (found as for the call graph) and the _test.go files next to them, for
+// calls whose arguments are all basic literals. Files that fail to parse are
+// skipped.
+func ParseFuzzSeeds(filePath string) (*FuzzSeeds, error) {
+	_, paths, err := packageFiles(filePath)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(filePath)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), "_test.go") {
+			paths = append(paths, filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	seeds := &FuzzSeeds{calls: make(map[string][][]literalArg)}
+	fset := token.NewFileSet()
+	for _, path := range paths {
+		src, err := ReadSource(path)
+		if err != nil {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, src, 0)
+		if err != nil {
+			continue
+		}
+		seeds.collect(file)
+	}
+	return seeds, nil
+}
+
+// collect records the literal calls of a file
+func (fs *FuzzSeeds) collect(file *ast.File) {
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) == 0 || call.Ellipsis.IsValid() {
+			return true
+		}
+		name := ""
+		switch fun := call.Fun.(type) {
+		case *ast.Ident:
+			name = fun.Name
+		case *ast.SelectorExpr:
+			name = fun.Sel.Name
+		}
+		if name == "" {
+			return true
+		}
+
+		args := make([]literalArg, 0, len(call.Args))
+		for _, expr := range call.Args {
+			arg, ok := basicLiteral(expr)
+			if !ok {
+				return true
+			}
+			args = append(args, arg)
+		}
+		fs.calls[name] = append(fs.calls[name], args)
+		return true
+	})
+}
+
+// basicLiteral returns expr as a literal argument: a basic literal, possibly
+// negated or parenthesized, true/false, or []byte of a string literal
+func basicLiteral(expr ast.Expr) (literalArg, bool) {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		if e.Kind == token.IMAG {
+			return literalArg{}, false
+		}
+		return literalArg{kind: e.Kind, value: e.Value}, true
+	case *ast.Ident:
+		if e.Name == "true" || e.Name == "false" {
+			return literalArg{kind: token.IDENT, value: e.Name}, true
+		}
+	case *ast.ParenExpr:
+		return basicLiteral(e.X)
+	case *ast.UnaryExpr:
+		lit, ok := e.X.(*ast.BasicLit)
+		if ok && e.Op == token.SUB && (lit.Kind == token.INT || lit.Kind == token.FLOAT) {
+			return literalArg{kind: lit.Kind, value: "-" + lit.Value}, true
+		}
+	case *ast.CallExpr:
+		array, ok := e.Fun.(*ast.ArrayType)
+		if !ok || array.Len != nil || len(e.Args) != 1 {
+			break
+		}
+		if elt, ok := array.Elt.(*ast.Ident); ok && elt.Name == "byte" {
+			if lit, ok := e.Args[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+				return literalArg{kind: token.STRING, value: lit.Value, bytes: true}, true
+			}
+		}
+	}
+	return literalArg{}, false
+}
+
+// FuzzableType reports whether a parameter of type typ can be fuzzed
+func FuzzableType(typ string) bool {
+	_, ok := fuzzableTypes[typ]
+	return ok
+}
+
+// SeedsFor returns up to maxFuzzSeeds distinct seeds for a function or
+// method taking parameters of paramTypes, each the arguments of one f.Add
+// call, e.g. `"alice", int64(3)`. Literals are converted to the parameter
+// types, since f.Add must match them exactly; calls whose literals don't fit
+// the parameters are left out, and a function with a parameter testing.F
+// can't fuzz gets none.
+func (fs *FuzzSeeds) SeedsFor(name string, paramTypes []string) []string {
+	if len(paramTypes) == 0 {
+		return nil
+	}
+	for _, typ := range paramTypes {
+		if !FuzzableType(typ) {
+			return nil
+		}
+	}
+
+	var seeds []string
+	seen := make(map[string]bool)
+	for _, args := range fs.calls[name] {
+		if len(args) != len(paramTypes) {
+			continue
+		}
+		values := make([]string, len(args))
+		fits := true
+		for i, arg := range args {
+			if values[i], fits = convertLiteral(arg, paramTypes[i]); !fits {
+				break
+			}
+		}
+		seed := strings.Join(values, ", ")
+		if !fits || seen[seed] {
+			continue
+		}
+		seen[seed] = true
+		seeds = append(seeds, seed)
+		if len(seeds) == maxFuzzSeeds {
+			break
+		}
+	}
+	return seeds
+}
+
+// convertLiteral renders arg as a value of type typ, converting it when its
+// default type differs, and reports whether it fits the type at all
+func convertLiteral(arg literalArg, typ string) (string, bool) {
+	convert := func() string { return typ + "(" + arg.value + ")" }
+	switch arg.kind {
+	case token.STRING:
+		switch {
+		case typ == "string" && !arg.bytes:
+			return arg.value, true
+		case typ == "[]byte":
+			return "[]byte(" + arg.value + ")", true
+		}
+	case token.IDENT:
+		return arg.value, typ == "bool"
+	case token.FLOAT:
+		switch typ {
+		case "float64":
+			return arg.value, true
+		case "float32":
+			return convert(), true
+		}
+	case token.INT, token.CHAR:
+		if typ == "float32" || typ == "float64" {
+			return convert(), arg.kind == token.INT
+		}
+		bits := fuzzableTypes[typ]
+		if bits == 0 || !fitsInteger(arg, typ, bits) {
+			return "", false
+		}
+		if (arg.kind == token.INT && typ == "int") || (arg.kind == token.CHAR && (typ == "rune" || typ == "int32")) {
+			return arg.value, true
+		}
+		return convert(), true
+	}
+	return "", false
+}
+
+// fitsInteger reports whether an integer or rune literal converts to the
+// integer type typ of the given bit size without overflowing
+func fitsInteger(arg literalArg, typ string, bits int) bool {
+	value := arg.value
+	if arg.kind == token.CHAR {
+		char, err := strconv.Unquote(value)
+		if err != nil {
+			return false
+		}
+		value = strconv.Itoa(int([]rune(char)[0]))
+	}
+	if strings.HasPrefix(typ, "uint") || typ == "byte" {
+		_, err := strconv.ParseUint(value, 0, bits)
+		return err == nil
+	}
+	_, err := strconv.ParseInt(value, 0, bits)
+	return err == nil
+}
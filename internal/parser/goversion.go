@@ -0,0 +1,68 @@
+package parser
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// GoLanguageVersion reads the go directive (e.g. "1.22.2") from a go.mod file.
+// It returns an empty string if the file has no go directive.
+func GoLanguageVersion(goModPath string) (string, error) {
+	content, err := os.ReadFile(goModPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", goModPath, err)
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		// Drop trailing comments: "go 1.21 // toolchain pinned separately"
+		if idx := strings.Index(line, "//"); idx != -1 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "go" {
+			return fields[1], nil
+		}
+	}
+
+	return "", nil
+}
+
+// ParseGoVersion parses a Go version such as "1.16", "1.22.2" or "1.21rc1"
+func ParseGoVersion(version string) (major, minor int, ok bool) {
+	parts := strings.SplitN(strings.TrimPrefix(strings.TrimSpace(version), "go"), ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+
+	// Strip pre-release suffixes like "21rc1"
+	digits := parts[1]
+	for i, r := range digits {
+		if r < '0' || r > '9' {
+			digits = digits[:i]
+			break
+		}
+	}
+	minor, err = strconv.Atoi(digits)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return major, minor, true
+}
+
+// GoVersionAtLeast reports whether version is at least go 1.minor.
+// Unknown or empty versions are treated as new enough.
+func GoVersionAtLeast(version string, minor int) bool {
+	major, versionMinor, ok := ParseGoVersion(version)
+	if !ok {
+		return true
+	}
+	return major > 1 || versionMinor >= minor
+}
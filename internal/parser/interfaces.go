@@ -0,0 +1,254 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"path"
+	"strings"
+	"sync"
+
+	pkgparser "github.com/Eranmonnie/testgen/pkg/parser"
+)
+
+// importedInterfaces caches the method sets of interfaces from other
+// packages by "path.Name", since type-checking a package from source is slow
+var (
+	importedInterfacesMu sync.Mutex
+	importedInterfaces   = make(map[string]importedInterface)
+)
+
+// importedInterface is a cached lookup of an interface from another package
+type importedInterface struct {
+	methods []string
+	ok      bool
+	err     error
+}
+
+// InterfaceMethods returns the method set of the interface named by
+// typeExpr, as written in a signature of this package ("Store", "io.Reader"),
+// each rendered like "Read(p []byte) (n int, err error)". Embedded
+// interfaces are expanded. imports are the imports of the file using
+// typeExpr, to resolve package names. ok is false when typeExpr isn't an
+// interface; err says why an interface's methods couldn't be listed.
+func (pd *PackageDecls) InterfaceMethods(typeExpr string, imports []ImportInfo) ([]string, bool, error) {
+	var methods []string
+	seen := make(map[string]bool)
+	ok, err := pd.collectInterfaceMethods(typeExpr, imports, &methods, seen, make(map[string]bool))
+	return methods, ok, err
+}
+
+// collectInterfaceMethods appends the methods of the interface named by
+// typeExpr that aren't in seen yet; visiting guards against embedding cycles
+func (pd *PackageDecls) collectInterfaceMethods(typeExpr string, imports []ImportInfo, methods *[]string, seen, visiting map[string]bool) (bool, error) {
+	if pkgName, name, qualified := strings.Cut(typeExpr, "."); qualified {
+		importPath := importPathFor(pkgName, imports)
+		if importPath == "" {
+			return false, fmt.Errorf("package %s of %s is not imported", pkgName, typeExpr)
+		}
+		imported, ok, err := lookupImportedInterface(importPath, name)
+		for _, method := range imported {
+			addMethod(methods, seen, method)
+		}
+		return ok, err
+	}
+
+	spec, declared := pd.types[typeExpr]
+	if !declared || visiting[typeExpr] {
+		return false, nil
+	}
+	visiting[typeExpr] = true
+	iface, isInterface := spec.Type.(*ast.InterfaceType)
+	if !isInterface {
+		// A local alias or definition of another interface
+		if target := pkgparser.TypeString(spec.Type); target != typeExpr && !strings.ContainsAny(target, "*[]{}") {
+			return pd.collectInterfaceMethods(target, imports, methods, seen, visiting)
+		}
+		return false, nil
+	}
+
+	var failures []string
+	for _, field := range iface.Methods.List {
+		funcType, isMethod := field.Type.(*ast.FuncType)
+		if isMethod {
+			for _, name := range field.Names {
+				addMethod(methods, seen, name.Name+pd.renderSignature(funcType))
+			}
+			continue
+		}
+		// Embedded interfaces; type set elements (a | b) only appear in constraints
+		embedded := pkgparser.TypeString(field.Type)
+		if _, err := pd.collectInterfaceMethods(embedded, imports, methods, seen, visiting); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return true, fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return true, nil
+}
+
+// renderSignature formats a method's parameters and results without "func"
+func (pd *PackageDecls) renderSignature(funcType *ast.FuncType) string {
+	var out bytes.Buffer
+	if err := printer.Fprint(&out, pd.fset, funcType); err != nil {
+		return "(...)"
+	}
+	return strings.TrimPrefix(out.String(), "func")
+}
+
+// addMethod appends a method unless one of the same name is already listed
+func addMethod(methods *[]string, seen map[string]bool, method string) {
+	name := method
+	if i := strings.Index(method, "("); i >= 0 {
+		name = method[:i]
+	}
+	if !seen[name] {
+		seen[name] = true
+		*methods = append(*methods, method)
+	}
+}
+
+// importPathFor resolves a package name used in a file to its import path
+func importPathFor(pkgName string, imports []ImportInfo) string {
+	for _, imp := range imports {
+		if imp.Name == pkgName {
+			return imp.Path
+		}
+	}
+	for _, imp := range imports {
+		base := path.Base(imp.Path)
+		// Major version suffixes (example.com/mod/v2) aren't the package name
+		if strings.HasPrefix(base, "v") && strings.Trim(base[1:], "0123456789") == "" {
+			base = path.Base(path.Dir(imp.Path))
+		}
+		if imp.Name == "" && base == pkgName {
+			return imp.Path
+		}
+	}
+	return ""
+}
+
+// lookupImportedInterface type-checks the package at importPath from source
+// to list the methods of its interface name. Standard library packages
+// always resolve; others only when go can find their source.
+func lookupImportedInterface(importPath, name string) ([]string, bool, error) {
+	key := importPath + "." + name
+	importedInterfacesMu.Lock()
+	defer importedInterfacesMu.Unlock()
+	if cached, ok := importedInterfaces[key]; ok {
+		return cached.methods, cached.ok, cached.err
+	}
+
+	var result importedInterface
+	pkg, err := importer.ForCompiler(token.NewFileSet(), "source", nil).Import(importPath)
+	if err != nil {
+		result.err = fmt.Errorf("methods of %s not available: %v", key, err)
+	} else if obj, isType := pkg.Scope().Lookup(name).(*types.TypeName); isType {
+		if iface, ok := obj.Type().Underlying().(*types.Interface); ok {
+			result.ok = true
+			qualifier := func(other *types.Package) string {
+				if other == pkg {
+					return ""
+				}
+				return other.Name()
+			}
+			for i := 0; i < iface.NumMethods(); i++ {
+				method := iface.Method(i)
+				var signature bytes.Buffer
+				types.WriteSignature(&signature, method.Type().(*types.Signature), qualifier)
+				result.methods = append(result.methods, method.Name()+signature.String())
+			}
+		}
+	}
+	importedInterfaces[key] = result
+	return result.methods, result.ok, result.err
+}
+
+// ConcreteTypesIn lists the package types a function body returns as its
+// result'th result, as "T" or "*T": composite literals, &T{...} and new(T),
+// directly or through a variable assigned one. Interfaces are skipped.
+func (pd *PackageDecls) ConcreteTypesIn(body string, result int) []string {
+	file, err := parser.ParseFile(token.NewFileSet(), "", "package p\n\nfunc _() "+body, parser.SkipObjectResolution)
+	if err != nil {
+		return nil
+	}
+
+	// Constructed values by variable, and the returned expressions
+	assigned := make(map[string][]ast.Expr)
+	var returned []ast.Expr
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.FuncLit:
+			return false // its returns aren't the function's
+		case *ast.AssignStmt:
+			if len(node.Lhs) == len(node.Rhs) {
+				for i, lhs := range node.Lhs {
+					if ident, ok := lhs.(*ast.Ident); ok {
+						assigned[ident.Name] = append(assigned[ident.Name], node.Rhs[i])
+					}
+				}
+			}
+		case *ast.ReturnStmt:
+			if result < len(node.Results) {
+				returned = append(returned, node.Results[result])
+			}
+		}
+		return true
+	})
+
+	var found []string
+	seen := make(map[string]bool)
+	for _, expr := range returned {
+		exprs := []ast.Expr{expr}
+		if ident, ok := expr.(*ast.Ident); ok {
+			exprs = assigned[ident.Name]
+		}
+		for _, expr := range exprs {
+			if name := pd.constructedType(expr); name != "" && !seen[name] {
+				seen[name] = true
+				found = append(found, name)
+			}
+		}
+	}
+	return found
+}
+
+// constructedType returns the package type expr constructs ("T" or "*T"),
+// or "" when it isn't a literal or new(T) of a non-interface package type
+func (pd *PackageDecls) constructedType(expr ast.Expr) string {
+	pointer := false
+	if unary, ok := expr.(*ast.UnaryExpr); ok && unary.Op == token.AND {
+		expr, pointer = unary.X, true
+	}
+	var typeExpr ast.Expr
+	switch node := expr.(type) {
+	case *ast.CompositeLit:
+		typeExpr = node.Type
+	case *ast.CallExpr:
+		if fun, ok := node.Fun.(*ast.Ident); ok && fun.Name == "new" && len(node.Args) == 1 && !pointer {
+			typeExpr, pointer = node.Args[0], true
+		}
+	}
+
+	ident, ok := typeExpr.(*ast.Ident)
+	if !ok {
+		return ""
+	}
+	spec, declared := pd.types[ident.Name]
+	if !declared {
+		return ""
+	}
+	if _, isInterface := spec.Type.(*ast.InterfaceType); isInterface {
+		return ""
+	}
+	if pointer {
+		return "*" + ident.Name
+	}
+	return ident.Name
+}
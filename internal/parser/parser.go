@@ -0,0 +1,50 @@
+package parser
+
+import pkgparser "github.com/Eranmonnie/testgen/pkg/parser"
+
+// Parsing a file into functions and types lives in the public pkg/parser,
+// so other tools can reuse testgen's summaries; these aliases keep callers
+// here unchanged.
+type (
+	FileAnalysis   = pkgparser.FileAnalysis
+	ImportInfo     = pkgparser.ImportInfo
+	TypeInfo       = pkgparser.TypeInfo
+	FunctionInfo   = pkgparser.FunctionInfo
+	ParameterInfo  = pkgparser.ParameterInfo
+	ReturnInfo     = pkgparser.ReturnInfo
+	ReceiverInfo   = pkgparser.ReceiverInfo
+	ComplexityInfo = pkgparser.ComplexityInfo
+)
+
+// ParseFile analyzes a Go source file and extracts function information
+func ParseFile(filePath string) (*FileAnalysis, error) {
+	return pkgparser.ParseFile(filePath)
+}
+
+// FunctionSource renders a function declaration from filePath, doc comment
+// included, as gofmt'd source
+func FunctionSource(filePath, name string) (string, error) {
+	return pkgparser.FunctionSource(filePath, name)
+}
+
+// ReadSource reads a Go file the way testgen parses it
+func ReadSource(filePath string) ([]byte, error) {
+	return pkgparser.ReadSource(filePath)
+}
+
+// NormalizeSource strips a leading UTF-8 BOM and turns \r\n line endings
+// into \n
+func NormalizeSource(data []byte) []byte {
+	return pkgparser.NormalizeSource(data)
+}
+
+// BaseTypeName strips pointers, package qualifiers and type arguments ("*pkg.Base[T]" -> "Base")
+func BaseTypeName(typeStr string) string {
+	return pkgparser.BaseTypeName(typeStr)
+}
+
+// CallName is how calls refer to a function: its name, or "Type.Method"
+// for methods
+func CallName(fn FunctionInfo) string {
+	return pkgparser.CallName(fn)
+}
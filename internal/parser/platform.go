@@ -0,0 +1,92 @@
+package parser
+
+import (
+	"go/build"
+	"path/filepath"
+	"strings"
+)
+
+// GOOS and GOARCH select the target platform whose build constraints decide
+// which files are analyzed. Empty values mean no platform was requested and
+// every file is analyzed. They can be changed with SetPlatform.
+var (
+	GOOS   = ""
+	GOARCH = ""
+)
+
+// SetPlatform sets the target platform used for build constraints.
+// Empty values leave the current setting unchanged.
+func SetPlatform(goos, goarch string) {
+	if goos != "" {
+		GOOS = goos
+	}
+	if goarch != "" {
+		GOARCH = goarch
+	}
+}
+
+// PlatformSet reports whether a target platform was requested
+func PlatformSet() bool {
+	return GOOS != "" || GOARCH != ""
+}
+
+// Platform returns the target platform as "goos/goarch", using the host for unset parts
+func Platform() string {
+	ctx := platformContext()
+	return ctx.GOOS + "/" + ctx.GOARCH
+}
+
+// platformContext returns the build context for the target platform,
+// falling back to the host for whichever of GOOS/GOARCH is unset
+func platformContext() build.Context {
+	ctx := build.Default
+	if GOOS != "" {
+		ctx.GOOS = GOOS
+	}
+	if GOARCH != "" {
+		ctx.GOARCH = GOARCH
+	}
+	return ctx
+}
+
+// MatchesPlatform reports whether the file at filePath is part of the build
+// for the target platform, judging by its file name (e.g. _windows.go) and
+// //go:build line. Every file matches when no platform was requested.
+func MatchesPlatform(filePath string) (bool, error) {
+	if !PlatformSet() {
+		return true, nil
+	}
+	ctx := platformContext()
+	return ctx.MatchFile(filepath.Dir(filePath), filepath.Base(filePath))
+}
+
+// IsPlatformSpecific reports whether the file at filePath is limited to
+// particular operating systems or architectures by its name or build
+// constraints. It is checked against a platform no constraint can name, so
+// files that build everywhere (including "!windows" style constraints) match.
+func IsPlatformSpecific(filePath string) bool {
+	ctx := build.Default
+	ctx.GOOS = "testgen-none"
+	ctx.GOARCH = "testgen-none"
+	ctx.CgoEnabled = true
+
+	match, err := ctx.MatchFile(filepath.Dir(filePath), filepath.Base(filePath))
+	return err == nil && !match
+}
+
+// PlatformBuildTag returns the //go:build expression for the target platform
+// ("windows && amd64") when sourceFile is platform-specific, or "" otherwise,
+// so portable code keeps portable tests
+func PlatformBuildTag(sourceFile string) string {
+	if !PlatformSet() || !IsPlatformSpecific(sourceFile) {
+		return ""
+	}
+
+	var terms []string
+	for _, term := range []string{GOOS, GOARCH} {
+		if term != "" {
+			terms = append(terms, term)
+		}
+	}
+	return strings.Join(terms, " && ")
+}
@@ -0,0 +1,248 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"strings"
+
+	pkgparser "github.com/Eranmonnie/testgen/pkg/parser"
+)
+
+// maxTypeDepth caps how many levels of referenced types are rendered below a
+// receiver type, keeping prompts small for deeply nested structures
+const maxTypeDepth = 3
+
+// PackageDecls holds the type and const declarations of every file in a
+// package, so a method's receiver can be resolved even when its type is
+// declared in another file
+type PackageDecls struct {
+	Name string
+
+	fset     *token.FileSet
+	types    map[string]*ast.TypeSpec
+	comments map[*ast.TypeSpec][]*ast.CommentGroup
+	consts   []constBlock
+}
+
+// constBlock is a const declaration with the comments of its file
+type constBlock struct {
+	decl     *ast.GenDecl
+	comments []*ast.CommentGroup
+}
+
+// ParsePackageDecls parses the declarations of the package containing
+// filePath: its non-test files in the same directory that build for the
+// --goos/--goarch target. Files that fail to parse are skipped.
+func ParsePackageDecls(filePath string) (*PackageDecls, error) {
+	name, paths, err := packageFiles(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	fset := token.NewFileSet()
+	decls := &PackageDecls{
+		Name:     name,
+		fset:     fset,
+		types:    make(map[string]*ast.TypeSpec),
+		comments: make(map[*ast.TypeSpec][]*ast.CommentGroup),
+	}
+	for _, path := range paths {
+		src, err := ReadSource(path)
+		if err != nil {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, src, parser.ParseComments|parser.SkipObjectResolution)
+		if err != nil {
+			continue
+		}
+		for _, decl := range file.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok {
+				continue
+			}
+			switch genDecl.Tok {
+			case token.TYPE:
+				for _, spec := range genDecl.Specs {
+					typeSpec := spec.(*ast.TypeSpec)
+					if _, seen := decls.types[typeSpec.Name.Name]; !seen {
+						decls.types[typeSpec.Name.Name] = typeSpec
+						decls.comments[typeSpec] = file.Comments
+					}
+				}
+			case token.CONST:
+				decls.consts = append(decls.consts, constBlock{decl: genDecl, comments: file.Comments})
+			}
+		}
+	}
+
+	return decls, nil
+}
+
+// TypeParamConstraints returns the constraints of a generic type's type
+// parameters, in declaration order and as written ("comparable",
+// "~int | ~string"), or nil when the type isn't declared in the package or
+// isn't generic
+func (pd *PackageDecls) TypeParamConstraints(typeName string) []string {
+	spec, ok := pd.types[BaseTypeName(typeName)]
+	if !ok || spec.TypeParams == nil {
+		return nil
+	}
+	var constraints []string
+	for _, field := range spec.TypeParams.List {
+		var buf bytes.Buffer
+		if err := printer.Fprint(&buf, pd.fset, field.Type); err != nil {
+			return nil
+		}
+		for range field.Names {
+			constraints = append(constraints, buf.String())
+		}
+	}
+	return constraints
+}
+
+// ReceiverDefinition renders the declaration of a method's receiver type,
+// followed by the package types it embeds and the const blocks declaring
+// values of the type. Local aliases are followed; a receiver that can't be
+// resolved in the package returns an error saying why.
+func (pd *PackageDecls) ReceiverDefinition(receiverType string) (string, error) {
+	name := BaseTypeName(receiverType)
+
+	var parts []string
+	visited := make(map[string]bool)
+	for {
+		spec, ok := pd.types[name]
+		if !ok {
+			return "", fmt.Errorf("type %s is not declared in package %s", name, pd.Name)
+		}
+		visited[name] = true
+		parts = append(parts, pd.renderType(spec))
+
+		if !spec.Assign.IsValid() {
+			break
+		}
+		target := pkgparser.TypeString(spec.Type)
+		if strings.Contains(target, ".") {
+			return "", fmt.Errorf("type %s is an alias of %s from another package, whose definition isn't available", name, target)
+		}
+		if name = BaseTypeName(target); visited[name] {
+			break
+		}
+	}
+
+	parts = append(parts, pd.referencedDefinitions(name, visited)...)
+	for _, block := range pd.consts {
+		if declaresValuesOf(block.decl, name) {
+			parts = append(parts, pd.render(block.decl, block.comments))
+		}
+	}
+
+	return strings.Join(parts, "\n\n"), nil
+}
+
+// referencedDefinitions renders the package types the named type refers to
+// through fields, embedding and element types, breadth first up to
+// maxTypeDepth levels. Each type is rendered once, so a recursive type (a
+// tree node with Children []Node) is referenced by name rather than
+// expanded again; types past the cap are listed by name.
+func (pd *PackageDecls) referencedDefinitions(name string, visited map[string]bool) []string {
+	var parts, truncated []string
+	level := []string{name}
+	for depth := 1; len(level) > 0; depth++ {
+		var next []string
+		for _, typeName := range level {
+			for _, ref := range pd.referencedTypes(pd.types[typeName].Type) {
+				if visited[ref] {
+					continue
+				}
+				visited[ref] = true
+				if depth > maxTypeDepth {
+					truncated = append(truncated, ref)
+					continue
+				}
+				parts = append(parts, pd.renderType(pd.types[ref]))
+				next = append(next, ref)
+			}
+		}
+		level = next
+	}
+
+	if len(truncated) > 0 {
+		parts = append(parts, "// Not expanded (nesting limit): "+strings.Join(truncated, ", "))
+	}
+	return parts
+}
+
+// referencedTypes lists the package types named in a type expression, in
+// order of appearance; types from other packages are skipped
+func (pd *PackageDecls) referencedTypes(expr ast.Expr) []string {
+	var refs []string
+	seen := make(map[string]bool)
+	var visit func(ast.Node) bool
+	visit = func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.SelectorExpr:
+			return false
+		case *ast.Field:
+			// Field, parameter and method names aren't type references
+			ast.Inspect(node.Type, visit)
+			return false
+		case *ast.Ident:
+			if _, ok := pd.types[node.Name]; ok && !seen[node.Name] {
+				seen[node.Name] = true
+				refs = append(refs, node.Name)
+			}
+		}
+		return true
+	}
+	ast.Inspect(expr, visit)
+	return refs
+}
+
+// renderType formats a type declaration on its own, even when it was
+// declared inside a type ( ... ) group
+func (pd *PackageDecls) renderType(spec *ast.TypeSpec) string {
+	single := *spec
+	single.Doc = nil
+	return pd.render(&ast.GenDecl{Doc: spec.Doc, TokPos: spec.Pos(), Tok: token.TYPE, Specs: []ast.Spec{&single}}, pd.comments[spec])
+}
+
+// render formats a declaration with its doc comment and the comments inside it
+func (pd *PackageDecls) render(decl *ast.GenDecl, comments []*ast.CommentGroup) string {
+	start := decl.Pos()
+	if decl.Doc != nil {
+		start = decl.Doc.Pos()
+	}
+	var inside []*ast.CommentGroup
+	for _, group := range comments {
+		if group.Pos() >= start && group.End() <= decl.End() {
+			inside = append(inside, group)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := format.Node(&out, pd.fset, &printer.CommentedNode{Node: decl, Comments: inside}); err != nil {
+		return ""
+	}
+	return out.String()
+}
+
+// declaresValuesOf reports whether a const block declares a constant of the
+// named type, counting specs that repeat the previous type implicitly (iota)
+func declaresValuesOf(decl *ast.GenDecl, name string) bool {
+	var current ast.Expr
+	for _, spec := range decl.Specs {
+		valueSpec := spec.(*ast.ValueSpec)
+		if valueSpec.Type != nil || len(valueSpec.Values) > 0 {
+			current = valueSpec.Type
+		}
+		if ident, ok := current.(*ast.Ident); ok && ident.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,16 @@
+package billing
+
+import (
+	"strconv"
+	"strings"
+)
+
+// parseAmount converts "12.34" to cents
+func parseAmount(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(value * 100), nil
+}
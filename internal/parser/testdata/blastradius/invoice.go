@@ -0,0 +1,53 @@
+package billing
+
+import "fmt"
+
+// Invoice collects line amounts in cents
+type Invoice struct {
+	Lines []int64
+}
+
+// Total sums amounts given as strings
+func Total(amounts []string) (int64, error) {
+	var total int64
+	for _, amount := range amounts {
+		cents, err := parseAmount(amount)
+		if err != nil {
+			return 0, err
+		}
+		total += cents
+	}
+	return total, nil
+}
+
+// Add parses amount and appends it to the invoice
+func (inv *Invoice) Add(amount string) error {
+	cents, err := parseAmount(amount)
+	if err != nil {
+		return err
+	}
+	inv.Lines = append(inv.Lines, cents)
+	return nil
+}
+
+// AddAll adds every amount through Add, without calling parseAmount itself
+func (inv *Invoice) AddAll(amounts []string) error {
+	for _, amount := range amounts {
+		if err := inv.Add(amount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Describe formats the invoice and doesn't parse anything
+func Describe(inv Invoice) string {
+	return fmt.Sprintf("%d lines", len(inv.Lines))
+}
+
+// sumParsed is an unexported caller and never a blast radius target
+func sumParsed(a, b string) int64 {
+	x, _ := parseAmount(a)
+	y, _ := parseAmount(b)
+	return x + y
+}
@@ -0,0 +1,20 @@
+package account
+
+import "errors"
+
+// Withdraw takes amount cents from the account
+func (a *Account) Withdraw(amount int64) error {
+	if a.Status != Active {
+		return errors.New("account is not active")
+	}
+	if amount > a.Balance {
+		return errors.New("insufficient funds")
+	}
+	a.Balance -= amount
+	return nil
+}
+
+// IsActive reports whether the status allows withdrawals
+func (s Status) IsActive() bool {
+	return s == Active
+}
@@ -0,0 +1,36 @@
+package account
+
+import "net/http"
+
+// Status is the lifecycle state of an account
+type Status int
+
+const (
+	Pending Status = iota
+	Active
+	Closed
+)
+
+// MaxBalance is unrelated to Status and stays out of its definition
+const MaxBalance = 1000000
+
+type (
+	// audit records who last touched an account
+	audit struct {
+		UpdatedBy string
+	}
+
+	// Account is declared here but its methods live in methods.go
+	Account struct {
+		audit
+		ID      string
+		Balance int64 // in cents
+		Status  Status
+	}
+)
+
+// Ledger is a local alias; methods on it resolve to Account
+type Ledger = Account
+
+// Client aliases a type from another package
+type Client = http.Client
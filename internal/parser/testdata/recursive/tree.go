@@ -0,0 +1,37 @@
+package tree
+
+// Node is a self-referential tree node
+type Node struct {
+	Value    int
+	Parent   *Node
+	Children []Node
+	Meta     Level1
+}
+
+// Sum adds up the values of the subtree rooted at n
+func (n *Node) Sum() int {
+	total := n.Value
+	for _, child := range n.Children {
+		total += child.Sum()
+	}
+	return total
+}
+
+// List is a linked list whose elements point back at it
+type List struct {
+	Head *Element
+}
+
+// Element is a list element
+type Element struct {
+	Next, Prev *Element
+	List       *List
+}
+
+type Level1 struct{ Next Level2 }
+
+type Level2 struct{ Next Level3 }
+
+type Level3 struct{ Next Level4 }
+
+type Level4 struct{ Next map[string]Level1 }
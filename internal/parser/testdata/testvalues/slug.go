@@ -0,0 +1,21 @@
+package slug
+
+import "strings"
+
+// Make turns a title into a URL slug
+func Make(title string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(title), " ", "-"))
+}
+
+// Limiter caps slug lengths
+type Limiter struct {
+	Max int
+}
+
+// Cut shortens slug to the limit
+func (l *Limiter) Cut(slug string) string {
+	if len(slug) > l.Max {
+		return slug[:l.Max]
+	}
+	return slug
+}
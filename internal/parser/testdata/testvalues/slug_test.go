@@ -0,0 +1,43 @@
+package slug
+
+import "testing"
+
+func TestMake(t *testing.T) {
+	tests := []struct {
+		name  string
+		title string
+		want  string
+	}{
+		{name: "spaces", title: "Hello World", want: "hello-world"},
+		{name: "trimmed", title: "  Go  ", want: "go"},
+		{name: "computed", title: strings.Repeat("a", 3), want: "aaa"},
+	}
+	for _, tt := range tests {
+		if got := Make(tt.title); got != tt.want {
+			t.Errorf("Make(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestMakeEmpty(t *testing.T) {
+	if got := Make(""); got != "" {
+		t.Errorf("Make(\"\") = %q", got)
+	}
+}
+
+func TestLimiter_Cut(t *testing.T) {
+	cases := map[string]struct {
+		max  int
+		in   string
+		want string
+	}{
+		"short": {max: 10, in: "go", want: "go"},
+		"long":  {max: 2, in: "golang", want: "go"},
+	}
+	for name, tc := range cases {
+		l := &Limiter{Max: tc.max}
+		if got := l.Cut(tc.in); got != tc.want {
+			t.Errorf("%s: got %q", name, got)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package parser
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// lineBreakRegex matches a line break and the indentation after it, joined
+// into a space to render an example on one line
+var lineBreakRegex = regexp.MustCompile(`\n\s*`)
+
+// maxExampleValues caps how many examples ExamplesFor returns, keeping
+// prompts small
+const maxExampleValues = 5
+
+// maxExampleValueLen leaves out examples longer than this once rendered on
+// one line; long fixtures read poorly as examples
+const maxExampleValueLen = 160
+
+// TestValues holds the literal values a package's existing tests use: calls
+// whose arguments are all literals, and table entries made only of literals
+type TestValues struct {
+	calls  map[string][]string // called function or method name -> calls
+	tables map[string][]string // test function name -> its table entries
+}
+
+// ParseTestValues mines the _test.go files next to filePath for literal
+// calls and table entries. Files that fail to parse are skipped.
+func ParseTestValues(filePath string) (*TestValues, error) {
+	dir := filepath.Dir(filePath)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	values := &TestValues{calls: make(map[string][]string), tables: make(map[string][]string)}
+	fset := token.NewFileSet()
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), "_test.go") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		src, err := ReadSource(path)
+		if err != nil {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, src, 0)
+		if err != nil {
+			continue
+		}
+		for _, decl := range file.Decls {
+			if funcDecl, ok := decl.(*ast.FuncDecl); ok && funcDecl.Body != nil {
+				values.collect(fset, funcDecl)
+			}
+		}
+	}
+	return values, nil
+}
+
+// ExamplesFor returns up to maxExampleValues examples for a function or
+// method (receiverType set): the table entries of its tests (TestName,
+// TestType_Name or TestTypeName) first, then literal calls to it
+func (tv *TestValues) ExamplesFor(name, receiverType string) []string {
+	testNames := []string{"Test" + name}
+	if receiverType != "" {
+		base := BaseTypeName(receiverType)
+		testNames = append(testNames, "Test"+base+"_"+name, "Test"+base+name)
+	}
+
+	var candidates []string
+	for _, testName := range testNames {
+		candidates = append(candidates, tv.tables[testName]...)
+	}
+	candidates = append(candidates, tv.calls[name]...)
+
+	var examples []string
+	seen := make(map[string]bool)
+	for _, example := range candidates {
+		if seen[example] {
+			continue
+		}
+		seen[example] = true
+		examples = append(examples, example)
+		if len(examples) == maxExampleValues {
+			break
+		}
+	}
+	return examples
+}
+
+// collect records the literal calls and table entries of a test file function
+func (tv *TestValues) collect(fset *token.FileSet, funcDecl *ast.FuncDecl) {
+	ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.CallExpr:
+			name := ""
+			switch fun := node.Fun.(type) {
+			case *ast.Ident:
+				name = fun.Name
+			case *ast.SelectorExpr:
+				name = fun.Sel.Name
+			}
+			if name != "" && len(node.Args) > 0 && allLiterals(node.Args) {
+				tv.calls[name] = appendExample(tv.calls[name], fset, node)
+			}
+		case *ast.CompositeLit:
+			if !isTable(node) {
+				return true
+			}
+			for _, elt := range node.Elts {
+				entry := elt
+				if kv, ok := elt.(*ast.KeyValueExpr); ok {
+					if !isLiteral(kv.Key) {
+						continue
+					}
+					entry = kv.Value
+				}
+				if lit, ok := entry.(*ast.CompositeLit); ok && allLiterals(lit.Elts) {
+					tv.tables[funcDecl.Name.Name] = appendExample(tv.tables[funcDecl.Name.Name], fset, elt)
+				}
+			}
+			return false
+		}
+		return true
+	})
+}
+
+// isTable reports whether a composite literal is a slice or map of test cases
+func isTable(lit *ast.CompositeLit) bool {
+	switch lit.Type.(type) {
+	case *ast.ArrayType, *ast.MapType:
+		return len(lit.Elts) > 0
+	}
+	return false
+}
+
+// allLiterals reports whether every expression is a literal value
+func allLiterals(exprs []ast.Expr) bool {
+	for _, expr := range exprs {
+		if !isLiteral(expr) {
+			return false
+		}
+	}
+	return true
+}
+
+// isLiteral reports whether expr is built only from basic literals: numbers,
+// strings, true/false/nil, negations and composite literals of those
+func isLiteral(expr ast.Expr) bool {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		return true
+	case *ast.Ident:
+		return e.Name == "true" || e.Name == "false" || e.Name == "nil"
+	case *ast.UnaryExpr:
+		return (e.Op == token.SUB || e.Op == token.ADD) && isLiteral(e.X)
+	case *ast.ParenExpr:
+		return isLiteral(e.X)
+	case *ast.KeyValueExpr:
+		return (isLiteral(e.Key) || isFieldName(e.Key)) && isLiteral(e.Value)
+	case *ast.CompositeLit:
+		return allLiterals(e.Elts)
+	}
+	return false
+}
+
+// isFieldName reports whether a key names a struct field
+func isFieldName(expr ast.Expr) bool {
+	_, ok := expr.(*ast.Ident)
+	return ok
+}
+
+// appendExample renders node on one line and appends it, unless it is too long
+func appendExample(examples []string, fset *token.FileSet, node ast.Node) []string {
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fset, node); err != nil {
+		return examples
+	}
+	example := lineBreakRegex.ReplaceAllString(buf.String(), " ")
+	if len(example) > maxExampleValueLen {
+		return examples
+	}
+	return append(examples, example)
+}
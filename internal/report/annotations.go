@@ -0,0 +1,132 @@
+package report
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Annotation formats understood by SetAnnotations
+const (
+	AnnotationsNone   = "none"
+	AnnotationsGitHub = "github" // GitHub Actions workflow commands
+)
+
+// AnnotationFormats lists the values --annotations accepts
+var AnnotationFormats = []string{AnnotationsNone, AnnotationsGitHub}
+
+// Severity of an annotation
+const (
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
+// Annotation is a finding tied to a line of source, which CI systems can
+// show inline on the diff
+type Annotation struct {
+	Severity string // SeverityWarning or SeverityError
+	File     string // as testgen knows it; made relative to the repository root when printed
+	Line     int    // 0 when the finding is about the whole file
+	Title    string
+	Message  string
+}
+
+// annotator formats annotations in one CI system's syntax
+type annotator interface {
+	format(a Annotation) string
+}
+
+var (
+	annotations    annotator // nil when annotations are off
+	annotationRoot string    // repository root annotation paths are relative to
+)
+
+// DetectAnnotations resolves the --annotations flag: format when given,
+// otherwise github inside GitHub Actions and none elsewhere
+func DetectAnnotations(format string) string {
+	if format != "" {
+		return format
+	}
+	if os.Getenv("GITHUB_ACTIONS") == "true" {
+		return AnnotationsGitHub
+	}
+	return AnnotationsNone
+}
+
+// SetAnnotations turns annotations on in format, with file paths relative
+// to root, or off for AnnotationsNone
+func SetAnnotations(format, root string) error {
+	switch format {
+	case AnnotationsNone:
+		annotations = nil
+	case AnnotationsGitHub:
+		annotations = githubAnnotator{}
+	default:
+		return fmt.Errorf("unknown annotation format '%s', must be one of: %s", format, strings.Join(AnnotationFormats, ", "))
+	}
+	annotationRoot = root
+	return nil
+}
+
+// Annotate prints an annotation at every verbosity when annotations are on
+func Annotate(a Annotation) {
+	if annotations == nil {
+		return
+	}
+	a.File = repoRelative(a.File)
+	fmt.Fprintln(stdout, annotations.format(a))
+}
+
+// repoRelative makes path relative to the annotation root, with forward
+// slashes, as CI systems match it against the diff
+func repoRelative(path string) string {
+	if annotationRoot == "" || path == "" {
+		return filepath.ToSlash(path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = resolved
+	}
+	rel, err := filepath.Rel(annotationRoot, abs)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// githubAnnotator writes GitHub Actions ::warning and ::error commands
+type githubAnnotator struct{}
+
+func (githubAnnotator) format(a Annotation) string {
+	var properties []string
+	if a.File != "" {
+		properties = append(properties, "file="+githubProperty(a.File))
+		if a.Line > 0 {
+			properties = append(properties, fmt.Sprintf("line=%d", a.Line))
+		}
+	}
+	if a.Title != "" {
+		properties = append(properties, "title="+githubProperty(a.Title))
+	}
+
+	command := "::" + a.Severity
+	if len(properties) > 0 {
+		command += " " + strings.Join(properties, ",")
+	}
+	return command + "::" + githubData(a.Message)
+}
+
+// githubData escapes a workflow command's message
+func githubData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// githubProperty escapes a workflow command's property value, which also
+// can't contain the separators of the property list
+func githubProperty(s string) string {
+	return strings.NewReplacer(":", "%3A", ",", "%2C").Replace(githubData(s))
+}
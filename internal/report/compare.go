@@ -0,0 +1,79 @@
+package report
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+)
+
+// ModelComparison is how one model did on one function in an --ab-compare
+// run. A batch's functions share one call, so its tokens and latency are
+// split evenly between them.
+type ModelComparison struct {
+	Function   string        `json:"function"`
+	Model      string        `json:"model"`
+	Tokens     int           `json:"tokens"`     // as reported by the provider; 0 when it doesn't say
+	Latency    time.Duration `json:"latency_ns"` // time until the validated response
+	Tests      int           `json:"tests"`
+	Valid      int           `json:"valid"`    // tests that are valid Go, exercise the function and aren't quarantined
+	Findings   int           `json:"findings"` // validation warnings about its tests
+	Confidence float64       `json:"confidence"`
+	Error      string        `json:"error,omitempty"` // why the model produced nothing
+}
+
+// PassRate is the share of tests that passed validation
+func (c ModelComparison) PassRate() float64 {
+	if c.Tests == 0 {
+		return 0
+	}
+	return float64(c.Valid) / float64(c.Tests)
+}
+
+// ComparisonTotals adds comparisons up per model, in the order the models
+// first appear, with confidence averaged over the functions that got tests
+func ComparisonTotals(comparisons []ModelComparison) []ModelComparison {
+	var totals []ModelComparison
+	index := make(map[string]int)
+	scored := make(map[string]int)
+	for _, c := range comparisons {
+		i, ok := index[c.Model]
+		if !ok {
+			i = len(totals)
+			index[c.Model] = i
+			totals = append(totals, ModelComparison{Function: "total", Model: c.Model})
+		}
+		total := &totals[i]
+		total.Tokens += c.Tokens
+		total.Latency += c.Latency
+		total.Tests += c.Tests
+		total.Valid += c.Valid
+		total.Findings += c.Findings
+		if c.Tests > 0 {
+			total.Confidence += c.Confidence
+			scored[c.Model]++
+		}
+	}
+	for i := range totals {
+		if n := scored[totals[i].Model]; n > 0 {
+			totals[i].Confidence /= float64(n)
+		}
+	}
+	return totals
+}
+
+// PrintComparison prints the --ab-compare table: each function's row per
+// model, then each model's totals. It's shown at every verbosity since it
+// was asked for.
+func PrintComparison(comparisons []ModelComparison) {
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Function\tModel\tTokens\tLatency\tTests\tPass rate\tFindings\tConfidence")
+	for _, c := range append(comparisons, ComparisonTotals(comparisons)...) {
+		if c.Error != "" {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t-\t-\t-\tfailed: %s\n", c.Function, c.Model, c.Tokens, c.Latency.Round(time.Millisecond), c.Error)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d\t%.0f%%\t%d\t%.2f\n",
+			c.Function, c.Model, c.Tokens, c.Latency.Round(time.Millisecond), c.Tests, c.PassRate()*100, c.Findings, c.Confidence)
+	}
+	w.Flush()
+}
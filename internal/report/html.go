@@ -0,0 +1,160 @@
+package report
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+//go:embed html.tmpl
+var htmlTemplateText string
+
+// htmlTemplate renders the standalone --report-html page; CSS and the
+// highlighting script are inline so the file works as a CI artifact
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"hints":   complexityHints,
+	"percent": func(f float64) string { return fmt.Sprintf("%.0f%%", f*100) },
+	"totals":  ComparisonTotals,
+}).Parse(htmlTemplateText))
+
+// HTMLReport is a generate run as shown by the HTML report
+type HTMLReport struct {
+	GeneratedAt            time.Time
+	Provider               string
+	Model                  string
+	LowConfidenceThreshold float64 // tests below it are flagged for review
+	Targets                []HTMLTarget
+	Comparison             []ModelComparison // --ab-compare
+}
+
+// HTMLTarget is a target function with the tests generated for it
+type HTMLTarget struct {
+	Function models.FunctionInfo
+	Tests    []HTMLTest
+}
+
+// HTMLTest is a generated test with the warnings that mention it
+type HTMLTest struct {
+	models.GeneratedTest
+	Warnings []string
+	Status   string // "ok", "low-confidence", "warnings" or "quarantined"
+}
+
+// HTMLStats are the run-level numbers at the top of the report
+type HTMLStats struct {
+	Functions      int
+	Tests          int
+	Quarantined    int
+	Warnings       int
+	MeanConfidence float64
+}
+
+// Add records a response's tests against the functions they were generated
+// for, paired by position as when test files are written. Tests without
+// their own confidence take the response's.
+func (r *HTMLReport) Add(functions []models.FunctionInfo, response *models.TestGenerationResponse) {
+	if response == nil {
+		return
+	}
+
+	start := len(r.Targets)
+	for _, fn := range functions {
+		r.Targets = append(r.Targets, HTMLTarget{Function: fn})
+	}
+
+	for i, test := range response.Tests {
+		if test.Confidence <= 0 {
+			test.Confidence = response.Confidence
+		}
+
+		entry := HTMLTest{GeneratedTest: test}
+		for _, warning := range response.Warnings {
+			if strings.Contains(warning, test.Name) {
+				entry.Warnings = append(entry.Warnings, warning)
+			}
+		}
+		entry.Status = r.status(entry)
+
+		// Tests beyond the last function are listed under it; they aren't written
+		index := i
+		if index >= len(functions) {
+			if len(functions) == 0 {
+				return
+			}
+			index = len(functions) - 1
+		}
+		r.Targets[start+index].Tests = append(r.Targets[start+index].Tests, entry)
+	}
+}
+
+// status classifies a test for review, worst first
+func (r *HTMLReport) status(test HTMLTest) string {
+	switch {
+	case test.QuarantineReason != "":
+		return "quarantined"
+	case len(test.Warnings) > 0:
+		return "warnings"
+	case test.Confidence > 0 && test.Confidence < r.LowConfidenceThreshold:
+		return "low-confidence"
+	default:
+		return "ok"
+	}
+}
+
+// Stats summarizes the run
+func (r HTMLReport) Stats() HTMLStats {
+	stats := HTMLStats{Functions: len(r.Targets)}
+	var total float64
+	var scored int
+	for _, target := range r.Targets {
+		for _, test := range target.Tests {
+			stats.Tests++
+			stats.Warnings += len(test.Warnings)
+			if test.QuarantineReason != "" {
+				stats.Quarantined++
+			}
+			if test.Confidence > 0 {
+				total += test.Confidence
+				scored++
+			}
+		}
+	}
+	if scored > 0 {
+		stats.MeanConfidence = total / float64(scored)
+	}
+	return stats
+}
+
+// WriteHTML renders the report as a self-contained HTML page
+func WriteHTML(w io.Writer, r HTMLReport) error {
+	return htmlTemplate.Execute(w, r)
+}
+
+// complexityHints lists the complexity facts worth a reviewer's attention
+func complexityHints(c models.ComplexityInfo) []string {
+	hints := []string{fmt.Sprintf("cyclomatic %d", c.CyclomaticComplexity)}
+	for _, hint := range []struct {
+		set  bool
+		text string
+	}{
+		{c.HasErrors, "returns error"},
+		{c.HasPointers, "pointers"},
+		{c.HasInterfaces, "interfaces"},
+		{c.HasChannels, "channels"},
+		{c.HasGoroutines, "goroutines"},
+		{c.MutatesArgs, "mutates arguments"},
+	} {
+		if hint.set {
+			hints = append(hints, hint.text)
+		}
+	}
+	if len(c.Dependencies) > 0 {
+		hints = append(hints, "uses "+strings.Join(c.Dependencies, ", "))
+	}
+	return hints
+}
@@ -0,0 +1,83 @@
+// Package report routes user-facing output through a single verbosity setting
+// so hooks can run quietly while manual runs stay informative.
+package report
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Level controls how much output testgen prints
+type Level int
+
+const (
+	Quiet   Level = iota // errors and a single final line
+	Summary              // summary tables and warnings, no per-step progress
+	Normal               // progress messages (default)
+	Verbose              // per-file detail and debugging output
+)
+
+var (
+	level            = Normal
+	stdout io.Writer = os.Stdout
+	stderr io.Writer = os.Stderr
+)
+
+// SetLevel sets the verbosity used by all output functions
+func SetLevel(l Level) {
+	level = l
+}
+
+// CurrentLevel returns the configured verbosity
+func CurrentLevel() Level {
+	return level
+}
+
+// SetOutput redirects normal and error output (used by tests)
+func SetOutput(out, errOut io.Writer) {
+	stdout = out
+	stderr = errOut
+}
+
+// Enabled reports whether messages at l are printed
+func Enabled(l Level) bool {
+	return level >= l
+}
+
+// Printf prints a message when the verbosity is at least l
+func Printf(l Level, format string, args ...interface{}) {
+	if Enabled(l) {
+		fmt.Fprintf(stdout, format, args...)
+	}
+}
+
+// Summaryf prints summary lines shown from --summary-only upwards
+func Summaryf(format string, args ...interface{}) {
+	Printf(Summary, format, args...)
+}
+
+// Infof prints progress messages shown in normal and verbose mode
+func Infof(format string, args ...interface{}) {
+	Printf(Normal, format, args...)
+}
+
+// Verbosef prints detail shown only with --verbose
+func Verbosef(format string, args ...interface{}) {
+	Printf(Verbose, format, args...)
+}
+
+// Warnf prints a warning unless running quietly
+func Warnf(format string, args ...interface{}) {
+	Printf(Summary, "Warning: "+format, args...)
+}
+
+// Resultf prints the final result line, which is shown at every verbosity
+func Resultf(format string, args ...interface{}) {
+	fmt.Fprintf(stdout, format, args...)
+}
+
+// Errorf prints an error to stderr at every verbosity
+func Errorf(format string, args ...interface{}) {
+	fmt.Fprintf(stderr, "Error: "+format, args...)
+}
@@ -0,0 +1,209 @@
+package report
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+// assertGolden compares got with testdata/<name>, rewriting it when -update is set
+func assertGolden(t *testing.T, name, got string) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.MkdirAll("testdata", 0755); err != nil {
+			t.Fatalf("Failed to create testdata dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatalf("Failed to update golden file: %v", err)
+		}
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read golden file %s: %v", path, err)
+	}
+
+	if got != string(want) {
+		t.Errorf("Output does not match %s\n--- got ---\n%s\n--- want ---\n%s", path, got, want)
+	}
+}
+
+func TestWriteHTMLGolden(t *testing.T) {
+	r := HTMLReport{
+		GeneratedAt:            time.Now(),
+		Provider:               "openai",
+		Model:                  "gpt-4o",
+		LowConfidenceThreshold: 0.6,
+	}
+
+	functions := []models.FunctionInfo{
+		{
+			Name:       "ValidateUser",
+			File:       "internal/user/user.go",
+			Signature:  "func ValidateUser(u *User) error",
+			Complexity: models.ComplexityInfo{HasErrors: true, HasPointers: true, CyclomaticComplexity: 4},
+			ChangeDiff: "-\tif u == nil {\n+\tif u == nil || u.Email == \"\" {",
+		},
+		{
+			Name:      "Purge",
+			File:      "internal/user/store.go",
+			Signature: "func Purge(dir string) error",
+		},
+		{
+			Name:      "Normalize",
+			File:      "internal/user/user.go",
+			Signature: "func Normalize(s string) string",
+		},
+	}
+	response := &models.TestGenerationResponse{
+		Confidence: 0.8,
+		Tests: []models.GeneratedTest{
+			{Name: "TestValidateUser", Code: "func TestValidateUser(t *testing.T) {\n\t// <script>alert(1)</script>\n\tif err := ValidateUser(nil); err == nil {\n\t\tt.Error(\"expected error\")\n\t}\n}", Description: "Rejects nil & empty users", Confidence: 0.9},
+			{Name: "TestPurge", Code: "func TestPurge(t *testing.T) {\n\tos.RemoveAll(\"/\")\n}", QuarantineReason: "calls os.RemoveAll, which Purge doesn't use"},
+			{Name: "TestNormalize", Code: "func TestNormalize(t *testing.T) {}", Confidence: 0.4},
+		},
+		Warnings: []string{"TestPurge quarantined for review: calls os.RemoveAll, which Purge doesn't use"},
+	}
+	r.Add(functions, response)
+
+	var out bytes.Buffer
+	if err := WriteHTML(&out, r); err != nil {
+		t.Fatalf("WriteHTML failed: %v", err)
+	}
+
+	html := out.String()
+	if strings.Contains(html, "<script>alert(1)") {
+		t.Error("Expected test code to be escaped")
+	}
+	if strings.Contains(html, "http://") || strings.Contains(html, "https://") {
+		t.Error("Expected a self-contained page without external resources")
+	}
+
+	// Timestamps vary between runs
+	timestamp := regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}[^ <]*`)
+	assertGolden(t, "report.html.golden", timestamp.ReplaceAllString(html, "TIMESTAMP"))
+}
+
+func TestAnnotateGitHub(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to resolve temp dir: %v", err)
+	}
+	var out bytes.Buffer
+	SetOutput(&out, &out)
+	defer SetOutput(os.Stdout, os.Stderr)
+	if err := SetAnnotations(AnnotationsGitHub, root); err != nil {
+		t.Fatalf("SetAnnotations failed: %v", err)
+	}
+	defer SetAnnotations(AnnotationsNone, "")
+	SetLevel(Quiet)
+	defer SetLevel(Normal)
+
+	Annotate(Annotation{Severity: SeverityWarning, File: filepath.Join(root, "internal", "user", "user.go"), Line: 12, Title: "testgen: untested function", Message: "no test was generated for ValidateUser"})
+	Annotate(Annotation{Severity: SeverityError, File: filepath.Join(root, "user.go"), Line: 3, Title: "testgen: invalid test", Message: "TestValidateUser isn't valid Go: 100% broken,\nsee line 2"})
+	Annotate(Annotation{Severity: SeverityWarning, File: "/elsewhere/a,b.go", Message: "outside the repository"})
+
+	want := "::warning file=internal/user/user.go,line=12,title=testgen%3A untested function::no test was generated for ValidateUser\n" +
+		"::error file=user.go,line=3,title=testgen%3A invalid test::TestValidateUser isn't valid Go: 100%25 broken,%0Asee line 2\n" +
+		"::warning file=/elsewhere/a%2Cb.go::outside the repository\n"
+	if out.String() != want {
+		t.Errorf("Unexpected annotations\n--- got ---\n%s--- want ---\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	SetAnnotations(AnnotationsNone, root)
+	Annotate(Annotation{Severity: SeverityWarning, File: "user.go", Message: "hidden"})
+	if out.Len() != 0 {
+		t.Errorf("Expected no annotations when they're off, got %q", out.String())
+	}
+	if err := SetAnnotations("gitlab", root); err == nil {
+		t.Error("Expected an unknown format to be rejected")
+	}
+}
+
+func TestDetectAnnotations(t *testing.T) {
+	t.Setenv("GITHUB_ACTIONS", "true")
+	if got := DetectAnnotations(""); got != AnnotationsGitHub {
+		t.Errorf("Expected github under GitHub Actions, got %s", got)
+	}
+	if got := DetectAnnotations(AnnotationsNone); got != AnnotationsNone {
+		t.Errorf("Expected an explicit format to win, got %s", got)
+	}
+	t.Setenv("GITHUB_ACTIONS", "")
+	if got := DetectAnnotations(""); got != AnnotationsNone {
+		t.Errorf("Expected none outside CI, got %s", got)
+	}
+}
+
+func TestTimings(t *testing.T) {
+	ResetTimings()
+	defer ResetTimings()
+
+	Time(PhaseParse)()
+	Time(PhaseParse)()
+	stop := Time(PhaseProvider)
+	time.Sleep(5 * time.Millisecond)
+	stop()
+
+	timings := Timings()
+	if len(timings) != len(Phases) {
+		t.Fatalf("Expected every phase, got %+v", timings)
+	}
+	for i, timing := range timings {
+		if timing.Phase != Phases[i] || timing.Seconds < 0 {
+			t.Errorf("Unexpected timing %+v at %d", timing, i)
+		}
+	}
+	if timings[1].Count != 2 || timings[4].Count != 1 || timings[4].Seconds < 0.005 {
+		t.Errorf("Expected two parses and one provider call of at least 5ms, got %+v", timings)
+	}
+	if timings[0].Count != 0 || timings[0].Seconds != 0 {
+		t.Errorf("Expected git diff never to have run, got %+v", timings[0])
+	}
+
+	var out bytes.Buffer
+	SetOutput(&out, &out)
+	defer SetOutput(os.Stdout, os.Stderr)
+	SetLevel(Quiet)
+	defer SetLevel(Normal)
+	PrintTimings(timings)
+	for _, phase := range append(Phases, "total") {
+		if !regexp.MustCompile(`(?m)^` + phase + ` +[0-9.]+m?s `).MatchString(out.String()) {
+			t.Errorf("Expected a %s row with its time even when quiet, got:\n%s", phase, out.String())
+		}
+	}
+}
+
+func TestPrintComparison(t *testing.T) {
+	var out bytes.Buffer
+	SetOutput(&out, &out)
+	defer SetOutput(os.Stdout, os.Stderr)
+
+	PrintComparison([]ModelComparison{
+		{Function: "Abs", Model: "gpt-4o", Tokens: 600, Latency: 1500 * time.Millisecond, Tests: 2, Valid: 2, Confidence: 0.9},
+		{Function: "Abs", Model: "gpt-4o-mini", Tokens: 250, Latency: 500 * time.Millisecond, Tests: 2, Valid: 1, Findings: 1, Confidence: 0.6},
+		{Function: "Sign", Model: "gpt-4o", Tokens: 400, Latency: time.Second, Tests: 1, Valid: 1, Confidence: 0.7},
+		{Function: "Sign", Model: "gpt-4o-mini", Latency: time.Second, Error: "rate limited"},
+	})
+	for _, want := range []string{
+		"Abs       gpt-4o-mini  250     500ms    2      50%        1         0.60",
+		"Sign      gpt-4o-mini  0       1s       -      -          -         failed: rate limited\n",
+		"total     gpt-4o       1000    2.5s     3      100%       0         0.80",
+		"total     gpt-4o-mini  250     1.5s     2      50%        1         0.60",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package report
+
+import (
+	"fmt"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Eranmonnie/testgen/pkg/models"
+)
+
+// Phases of a generate run that --timings breaks the run down into
+const (
+	PhaseGitDiff  = "git diff"
+	PhaseParse    = "parse"
+	PhaseFilter   = "filtering"
+	PhasePrompt   = "prompt build"
+	PhaseProvider = "provider calls"
+	PhaseValidate = "validation"
+	PhaseWrite    = "write"
+)
+
+// Phases lists every phase in the order a run goes through them
+var Phases = []string{PhaseGitDiff, PhaseParse, PhaseFilter, PhasePrompt, PhaseProvider, PhaseValidate, PhaseWrite}
+
+// phaseTotal is the time spent in a phase and how often it was entered
+type phaseTotal struct {
+	elapsed time.Duration
+	count   int
+}
+
+var (
+	timingsMu sync.Mutex
+	timings   = make(map[string]*phaseTotal)
+)
+
+// Time starts timing one pass through phase and returns the func that
+// stops it, so a call is timed with defer report.Time(phase)(). Passes are
+// added up, including concurrent ones.
+func Time(phase string) func() {
+	start := time.Now()
+	return func() {
+		elapsed := time.Since(start)
+		timingsMu.Lock()
+		defer timingsMu.Unlock()
+		total := timings[phase]
+		if total == nil {
+			total = &phaseTotal{}
+			timings[phase] = total
+		}
+		total.elapsed += elapsed
+		total.count++
+	}
+}
+
+// Timings returns the time spent in each of Phases so far, phases that
+// never ran included at zero
+func Timings() []models.PhaseTiming {
+	timingsMu.Lock()
+	defer timingsMu.Unlock()
+	result := make([]models.PhaseTiming, 0, len(Phases))
+	for _, phase := range Phases {
+		timing := models.PhaseTiming{Phase: phase}
+		if total := timings[phase]; total != nil {
+			timing.Seconds = total.elapsed.Seconds()
+			timing.Count = total.count
+		}
+		result = append(result, timing)
+	}
+	return result
+}
+
+// ResetTimings forgets the time spent in every phase (used by tests)
+func ResetTimings() {
+	timingsMu.Lock()
+	defer timingsMu.Unlock()
+	timings = make(map[string]*phaseTotal)
+}
+
+// PrintTimings prints the --timings table: each phase's time and passes,
+// shown at every verbosity since it was asked for
+func PrintTimings(phases []models.PhaseTiming) {
+	var total time.Duration
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Phase\tTime\tCalls")
+	for _, phase := range phases {
+		elapsed := phaseDuration(phase)
+		total += elapsed
+		fmt.Fprintf(w, "%s\t%s\t%d\n", phase.Phase, elapsed, phase.Count)
+	}
+	fmt.Fprintf(w, "total\t%s\t\n", total)
+	w.Flush()
+}
+
+// phaseDuration returns a phase's time rounded for the table
+func phaseDuration(phase models.PhaseTiming) time.Duration {
+	return time.Duration(phase.Seconds * float64(time.Second)).Round(time.Millisecond)
+}
@@ -0,0 +1,159 @@
+// Package workspace runs go commands against candidate test files without
+// touching the user's tree.
+//
+// Candidate files live in a private temp directory and are mapped into the
+// module with go's -overlay flag, so the package under test keeps its real
+// import path (copying it out of the module would break internal/ imports).
+// Every command also runs with -modfile pointing at a copy of go.mod and
+// go.sum, so dependency resolution can't rewrite the user's module files.
+// Each Workspace is independent, which makes concurrent validations safe.
+package workspace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// waitDelay bounds how long a cancelled go command may keep its output pipes open
+const waitDelay = 5 * time.Second
+
+// Workspace is an isolated overlay of candidate files on top of a Go module
+type Workspace struct {
+	dir        string            // private temp directory
+	moduleRoot string            // directory containing the real go.mod
+	overlay    map[string]string // real path -> candidate file in dir
+}
+
+// New creates a workspace for the module rooted at moduleRoot
+func New(moduleRoot string) (*Workspace, error) {
+	root, err := filepath.Abs(moduleRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve module root: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "testgen-workspace-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+
+	w := &Workspace{dir: dir, moduleRoot: root, overlay: make(map[string]string)}
+
+	// Private copies of the module files for -modfile (go.sum is optional)
+	for _, name := range []string{"go.mod", "go.sum"} {
+		data, err := os.ReadFile(filepath.Join(root, name))
+		if errors.Is(err, os.ErrNotExist) && name == "go.sum" {
+			continue
+		}
+		if err == nil {
+			err = os.WriteFile(filepath.Join(dir, name), data, 0644)
+		}
+		if err != nil {
+			w.Close()
+			return nil, fmt.Errorf("failed to copy %s into workspace: %w", name, err)
+		}
+	}
+
+	return w, nil
+}
+
+// Run creates a workspace, passes it to fn and always removes it afterwards,
+// including when fn fails, panics or ctx is cancelled
+func Run(ctx context.Context, moduleRoot string, fn func(context.Context, *Workspace) error) error {
+	w, err := New(moduleRoot)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	return fn(ctx, w)
+}
+
+// FindModuleRoot returns the nearest directory at or above dir containing go.mod
+func FindModuleRoot(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for current := abs; ; current = filepath.Dir(current) {
+		if _, err := os.Stat(filepath.Join(current, "go.mod")); err == nil {
+			return current, nil
+		}
+		if filepath.Dir(current) == current {
+			return "", fmt.Errorf("no go.mod found above %s", abs)
+		}
+	}
+}
+
+// Dir returns the workspace's private temp directory
+func (w *Workspace) Dir() string {
+	return w.dir
+}
+
+// AddFile places a candidate file at path (absolute, or relative to the
+// module root) as seen by go commands. The real file is never written.
+func (w *Workspace) AddFile(path string, content []byte) error {
+	target := path
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(w.moduleRoot, target)
+	}
+
+	candidate := filepath.Join(w.dir, "files", fmt.Sprintf("%d-%s", len(w.overlay), filepath.Base(target)))
+	if existing, ok := w.overlay[target]; ok {
+		candidate = existing
+	}
+
+	if err := os.MkdirAll(filepath.Dir(candidate), 0755); err != nil {
+		return fmt.Errorf("failed to create workspace directory: %w", err)
+	}
+	if err := os.WriteFile(candidate, content, 0644); err != nil {
+		return fmt.Errorf("failed to write candidate file: %w", err)
+	}
+
+	w.overlay[target] = candidate
+	return nil
+}
+
+// Go runs a go subcommand (such as "test" or "vet") in pkgDir, a directory
+// relative to the module root, with the workspace's overlay and module files.
+// It returns the combined output.
+func (w *Workspace) Go(ctx context.Context, pkgDir, subcommand string, args ...string) ([]byte, error) {
+	overlayPath := filepath.Join(w.dir, "overlay.json")
+	data, err := json.Marshal(map[string]map[string]string{"Replace": w.overlay})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode overlay: %w", err)
+	}
+	if err := os.WriteFile(overlayPath, data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write overlay: %w", err)
+	}
+
+	goArgs := []string{subcommand, "-modfile=" + filepath.Join(w.dir, "go.mod"), "-overlay=" + overlayPath}
+	goArgs = append(goArgs, args...)
+
+	cmd := exec.CommandContext(ctx, "go", goArgs...)
+	cmd.Dir = filepath.Join(w.moduleRoot, pkgDir)
+	cmd.WaitDelay = waitDelay
+	// A go.work would conflict with -modfile, and -mod=mod keeps any module
+	// updates in the workspace copies instead of failing
+	cmd.Env = append(os.Environ(), "GOWORK=off", "GOFLAGS=-mod=mod")
+
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return output, fmt.Errorf("go %s cancelled: %w", subcommand, ctx.Err())
+	}
+	if err != nil {
+		return output, fmt.Errorf("go %s failed: %w", subcommand, err)
+	}
+	return output, nil
+}
+
+// Close removes the workspace. It is safe to call more than once.
+func (w *Workspace) Close() error {
+	return os.RemoveAll(w.dir)
+}
@@ -0,0 +1,164 @@
+package workspace
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestModule writes a small module with one internal package and returns its root
+func newTestModule(t *testing.T) string {
+	t.Helper()
+
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not available")
+	}
+
+	root := t.TempDir()
+	files := map[string]string{
+		"go.mod":                "module example.com/shop\n\ngo 1.21\n",
+		"internal/tax/tax.go":   "package tax\n\nfunc Rate() float64 { return 0.2 }\n",
+		"cart/cart.go":          "package cart\n\nimport \"example.com/shop/internal/tax\"\n\nfunc Total(price float64) float64 { return price * (1 + tax.Rate()) }\n",
+		"cart/existing_test.go": "package cart\n\nimport \"testing\"\n\nfunc TestExisting(t *testing.T) {}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	return root
+}
+
+// snapshotTree returns every file under root with its content
+func snapshotTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+
+	snapshot := make(map[string]string)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		snapshot[path] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to snapshot %s: %v", root, err)
+	}
+	return snapshot
+}
+
+func TestConcurrentValidationsAreIsolated(t *testing.T) {
+	root := newTestModule(t)
+	before := snapshotTree(t, root)
+
+	candidates := map[string]string{
+		"passing": "package cart\n\nimport \"testing\"\n\nfunc TestTotal(t *testing.T) {\n\tif Total(10) != 12 {\n\t\tt.Errorf(\"got %v\", Total(10))\n\t}\n}\n",
+		"failing": "package cart\n\nimport \"testing\"\n\nfunc TestTotal(t *testing.T) {\n\tif Total(10) != 10 {\n\t\tt.Errorf(\"got %v\", Total(10))\n\t}\n}\n",
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	results := make(map[string]error)
+	dirs := make(map[string]string)
+
+	for name, code := range candidates {
+		wg.Add(1)
+		go func(name, code string) {
+			defer wg.Done()
+			err := Run(context.Background(), root, func(ctx context.Context, w *Workspace) error {
+				mu.Lock()
+				dirs[name] = w.Dir()
+				mu.Unlock()
+
+				// Both candidates use the same file name, like two batches for one source file
+				if err := w.AddFile("cart/cart_test.go", []byte(code)); err != nil {
+					return err
+				}
+				if output, err := w.Go(ctx, "cart", "vet"); err != nil {
+					t.Errorf("%s: go vet failed: %v\n%s", name, err, output)
+				}
+				_, err := w.Go(ctx, "cart", "test", "-count=1", "-run", "TestTotal")
+				return err
+			})
+			mu.Lock()
+			results[name] = err
+			mu.Unlock()
+		}(name, code)
+	}
+	wg.Wait()
+
+	if results["passing"] != nil {
+		t.Errorf("Expected passing candidate to pass, got %v", results["passing"])
+	}
+	if results["failing"] == nil {
+		t.Error("Expected failing candidate to fail")
+	}
+
+	after := snapshotTree(t, root)
+	if len(after) != len(before) {
+		t.Errorf("Expected %d files in the user tree, got %d", len(before), len(after))
+	}
+	for path, content := range before {
+		if after[path] != content {
+			t.Errorf("User file %s changed", path)
+		}
+	}
+
+	for name, dir := range dirs {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("Expected %s workspace %s to be removed", name, dir)
+		}
+	}
+}
+
+func TestRunCleansUpOnCancellation(t *testing.T) {
+	root := newTestModule(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var dir string
+	err := Run(ctx, root, func(ctx context.Context, w *Workspace) error {
+		dir = w.Dir()
+		if err := w.AddFile("cart/cart_test.go", []byte("package cart\n")); err != nil {
+			return err
+		}
+		_, err := w.Go(ctx, "cart", "test")
+		return err
+	})
+
+	if err == nil || !strings.Contains(err.Error(), "cancelled") {
+		t.Errorf("Expected cancellation error, got %v", err)
+	}
+	if _, statErr := os.Stat(dir); !os.IsNotExist(statErr) {
+		t.Errorf("Expected workspace %s to be removed", dir)
+	}
+	if _, statErr := os.Stat(filepath.Join(root, "cart", "cart_test.go")); !os.IsNotExist(statErr) {
+		t.Error("Expected candidate file not to be written into the user tree")
+	}
+}
+
+func TestFindModuleRoot(t *testing.T) {
+	root := newTestModule(t)
+
+	found, err := FindModuleRoot(filepath.Join(root, "internal", "tax"))
+	if err != nil {
+		t.Fatalf("FindModuleRoot failed: %v", err)
+	}
+	if found != root {
+		t.Errorf("Expected %s, got %s", root, found)
+	}
+}
@@ -0,0 +1,307 @@
+// Package git maps git diffs to the Go functions they change.
+//
+// ParseDiff reads the output of git diff --function-context into files,
+// changes and the functions each change falls in; Repo runs git itself,
+// with every call bounded by a context. Nothing is printed: failures are
+// returned as errors.
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DiffChange represents a single change in a diff
+type DiffChange struct {
+	Type     ChangeType `json:"type"` // Added, Removed, Modified
+	Line     string     `json:"line"`
+	LineNum  int        `json:"line_num"` // line in the new file (for removals, the new-file line that follows them)
+	Function string     `json:"function"` // Function this change belongs to
+}
+
+// FileDiff represents all changes in a single file
+type FileDiff struct {
+	OldPath   string       `json:"old_path"`
+	NewPath   string       `json:"new_path"`
+	Changes   []DiffChange `json:"changes"`
+	Functions []string     `json:"functions"` // Functions that were modified
+}
+
+// DiffResult represents the complete diff analysis
+type DiffResult struct {
+	Files []FileDiff `json:"files"`
+}
+
+// ChangeType is what a diff line does. It's written to JSON by name, e.g.
+// "added".
+type ChangeType int
+
+const (
+	Added ChangeType = iota
+	Removed
+	Modified
+	Context
+)
+
+// changeTypeNames are the names ChangeType values marshal to, in order
+var changeTypeNames = []string{"added", "removed", "modified", "context"}
+
+// String returns the change type's name, e.g. "added"
+func (c ChangeType) String() string {
+	if c < 0 || int(c) >= len(changeTypeNames) {
+		return "ChangeType(" + strconv.Itoa(int(c)) + ")"
+	}
+	return changeTypeNames[c]
+}
+
+// MarshalText writes the change type by name
+func (c ChangeType) MarshalText() ([]byte, error) {
+	if c < 0 || int(c) >= len(changeTypeNames) {
+		return nil, fmt.Errorf("unknown change type %d", int(c))
+	}
+	return []byte(changeTypeNames[c]), nil
+}
+
+// UnmarshalText reads a change type written by MarshalText
+func (c *ChangeType) UnmarshalText(text []byte) error {
+	for i, name := range changeTypeNames {
+		if name == string(text) {
+			*c = ChangeType(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown change type %q", text)
+}
+
+// Add this helper method to better detect function modifications
+func (fd *FileDiff) addFunctionIfModified(functionName string) {
+	if functionName == "" {
+		return
+	}
+
+	// Check if function already exists
+	for _, existing := range fd.Functions {
+		if existing == functionName {
+			return
+		}
+	}
+
+	// Add the function
+	fd.Functions = append(fd.Functions, functionName)
+}
+
+// Update the parseDiff function to better handle function detection
+func parseDiff(diffText string) (*DiffResult, error) {
+	result := &DiffResult{}
+	scanner := bufio.NewScanner(strings.NewReader(diffText))
+
+	var currentFile *FileDiff
+	var currentFunction string
+	var newLine int // next line number in the new file
+
+	// Regex patterns for parsing
+	fileHeaderRegex := regexp.MustCompile(`^diff --git a/(.*) b/(.*)$`) // file names
+	hunkHeaderRegex := regexp.MustCompile(`^@@ -(\d+),?(\d*) \+(\d+),?(\d*) @@ ?(.*)$`)
+
+	for scanner.Scan() {
+		// Files checked out with core.autocrlf show up with \r\n endings
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+
+		// New file diff
+		if matches := fileHeaderRegex.FindStringSubmatch(line); matches != nil {
+			if currentFile != nil {
+				result.Files = append(result.Files, *currentFile)
+			}
+			currentFile = &FileDiff{
+				OldPath: matches[1],
+				NewPath: matches[2],
+			}
+			newLine = 0
+			currentFunction = ""
+			continue
+		}
+
+		// Hunk header (contains function context)
+		if matches := hunkHeaderRegex.FindStringSubmatch(line); matches != nil {
+			if len(matches) > 5 && matches[5] != "" {
+				// Extract function name from context
+				funcContext := matches[5]
+				if extractedFunc := extractFunctionName(funcContext); extractedFunc != "" {
+					currentFunction = extractedFunc
+					if currentFile != nil {
+						currentFile.addFunctionIfModified(currentFunction)
+					}
+				}
+			}
+			newLine, _ = strconv.Atoi(matches[3])
+			continue
+		}
+
+		// Skip file metadata lines
+		if strings.HasPrefix(line, "index ") ||
+			strings.HasPrefix(line, "--- ") ||
+			strings.HasPrefix(line, "+++ ") {
+			continue
+		}
+
+		// Parse actual diff content
+		if currentFile != nil {
+			change := parseDiffLine(line, currentFunction)
+			if change != nil {
+				change.LineNum = newLine
+				currentFile.Changes = append(currentFile.Changes, *change)
+				if change.Type != Removed {
+					newLine++
+				}
+
+				// If this line defines a new function, update our tracking
+				if (change.Type == Added || change.Type == Context) && strings.Contains(change.Line, "func ") {
+					if funcName := extractFunctionName(change.Line); funcName != "" {
+						currentFile.addFunctionIfModified(funcName)
+						currentFunction = funcName
+					}
+				}
+			}
+		}
+	}
+
+	// Don't forget the last file cuz we add the first file in the loop after it and so on yg
+	if currentFile != nil {
+		result.Files = append(result.Files, *currentFile)
+	}
+
+	return result, nil
+}
+
+// GetModifiedFunctions extracts function names that were actually modified,
+// in the order the diff first changes them
+func (fd FileDiff) GetModifiedFunctions() []string {
+	// Track which functions have actual changes (not just context)
+	functionsWithChanges := make(map[string]bool)
+
+	var result []string
+	for _, change := range fd.Changes {
+		// Only count functions that have additions or removals
+		if change.Type == Added || change.Type == Removed {
+			if change.Function != "" && !functionsWithChanges[change.Function] {
+				functionsWithChanges[change.Function] = true
+				result = append(result, change.Function)
+			}
+		}
+	}
+
+	return result
+}
+
+// ChangedLines returns the sorted new-file line numbers of the added and
+// removed lines attributed to functionName
+func (fd FileDiff) ChangedLines(functionName string) []int {
+	seen := make(map[int]bool)
+	var lines []int
+
+	for _, change := range fd.Changes {
+		if change.Function != functionName || (change.Type != Added && change.Type != Removed) {
+			continue
+		}
+		if !seen[change.LineNum] {
+			seen[change.LineNum] = true
+			lines = append(lines, change.LineNum)
+		}
+	}
+
+	sort.Ints(lines)
+	return lines
+}
+
+// extractFunctionName extracts function name from a function declaration line or context
+func extractFunctionName(line string) string {
+	// Clean up the line
+	line = strings.TrimSpace(line)
+
+	// Handle context lines that might have extra characters
+	if strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-") || strings.HasPrefix(line, " ") {
+		line = strings.TrimSpace(line[1:])
+	}
+
+	// Must start with "func " to be a function declaration
+	if !strings.HasPrefix(line, "func ") {
+		return ""
+	}
+
+	// Remove "func " prefix
+	line = strings.TrimPrefix(line, "func ")
+	line = strings.TrimSpace(line)
+
+	// Handle method declarations: (receiver) FunctionName(
+	if strings.HasPrefix(line, "(") {
+		// Find the closing parenthesis for receiver
+		closeParen := strings.Index(line, ") ")
+		if closeParen != -1 {
+			// Skip the receiver part: ") FunctionName("
+			line = strings.TrimSpace(line[closeParen+2:])
+		}
+	}
+
+	// Now we should have: FunctionName(params...)
+	// Find the opening parenthesis
+	parenIndex := strings.Index(line, "(")
+	if parenIndex == -1 {
+		return ""
+	}
+
+	// Extract function name (everything before the '(')
+	funcName := strings.TrimSpace(line[:parenIndex])
+
+	// Remove any remaining special characters
+	funcName = strings.Trim(funcName, " \t*&[]")
+
+	return funcName
+}
+
+// parseDiffLine parses a single line from the diff
+func parseDiffLine(line, currentFunction string) *DiffChange {
+	if len(line) == 0 {
+		return nil
+	}
+
+	change := &DiffChange{
+		Function: currentFunction,
+	}
+
+	switch line[0] {
+	case '+':
+		change.Type = Added
+		change.Line = line[1:]
+	case '-':
+		change.Type = Removed
+		change.Line = line[1:]
+	case ' ':
+		change.Type = Context
+		change.Line = line[1:]
+	default:
+		return nil // Skip unrecognized lines
+	}
+
+	return change
+}
+
+// FilterGoFiles filters the diff to only include Go files
+func (dr *DiffResult) FilterGoFiles() *DiffResult {
+	filtered := &DiffResult{}
+	for _, file := range dr.Files {
+		if strings.HasSuffix(file.NewPath, ".go") && !strings.HasSuffix(file.NewPath, "_test.go") {
+			filtered.Files = append(filtered.Files, file)
+		}
+	}
+	return filtered
+}
+
+// ParseDiff parses the output of git diff, best with --function-context so
+// every change can be placed in its function
+func ParseDiff(diffText string) (*DiffResult, error) {
+	return parseDiff(diffText)
+}
@@ -0,0 +1,56 @@
+package git_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Eranmonnie/testgen/pkg/git"
+)
+
+func ExampleParseDiff() {
+	diff := `diff --git a/user.go b/user.go
+--- a/user.go
++++ b/user.go
+@@ -3,6 +3,9 @@ import "errors"
+ func ValidateUser(name string) error {
++	if name == "" {
++		return errors.New("name required")
++	}
+ 	return nil
+ }
+ 
+@@ -12,3 +15,3 @@ func ValidateUser(name string) error {
+ func Greet(name string) string {
+-	return "hi " + name
++	return "hello " + name
+ }
+`
+	result, err := git.ParseDiff(diff)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, file := range result.Files {
+		for _, function := range file.GetModifiedFunctions() {
+			fmt.Printf("%s: %s changed lines %v\n", file.NewPath, function, file.ChangedLines(function))
+		}
+	}
+	// Output:
+	// user.go: ValidateUser changed lines [4 5 6]
+	// user.go: Greet changed lines [16]
+}
+
+func ExampleRepo_Diff() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	repo := git.Repo{Dir: "."}
+	result, err := repo.Diff(ctx, "HEAD~1", "HEAD")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, file := range result.FilterGoFiles().Files {
+		fmt.Println(file.NewPath, file.GetModifiedFunctions())
+	}
+}
@@ -0,0 +1,245 @@
+package git
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseDiff(t *testing.T) {
+	diffOutput := `diff --git a/user.go b/user.go
+index 1234567..abcdefg 100644
+--- a/user.go
++++ b/user.go
+@@ -10,6 +10,10 @@ func ValidateUser(user *User) error {
+ )
+ 
+ func ValidateUser(user *User) error {
++	if user == nil {
++		return errors.New("user is nil")
++	}
++	if user.Name == "" {
++		return errors.New("name required")
++	}
+     return nil
+ }
++
++func CreateUser(name, email string) *User {
++	return &User{
++		Name:  name,
++		Email: email,
++	}
++}
+@@ -30,7 +40,7 @@ func GetUser(id int) (*User, error) {
+     // This function appears in diff but has no actual changes
+     return findUser(id)
+`
+	result, err := ParseDiff(diffOutput)
+	if err != nil {
+		t.Fatalf("ParseDiff failed: %v", err)
+	}
+
+	if len(result.Files) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(result.Files))
+	}
+
+	file := result.Files[0]
+	functions := file.GetModifiedFunctions()
+
+	// Debug: print what we actually found
+	t.Logf("Found functions: %v", functions)
+	t.Logf("File changes count: %d", len(file.Changes))
+	for i, change := range file.Changes {
+		if i < 5 { // Print first 5 changes for debugging
+			t.Logf("Change %d: Type=%v, Line=%q, Function=%q", i, change.Type, change.Line, change.Function)
+		}
+	}
+
+	// Should detect both ValidateUser (modified) and CreateUser (added)
+	expectedFunctions := []string{"ValidateUser", "CreateUser"}
+	if len(functions) != len(expectedFunctions) {
+		t.Errorf("expected %d functions, got %d: %v", len(expectedFunctions), len(functions), functions)
+	}
+
+	// Check that both expected functions are found
+	for _, expected := range expectedFunctions {
+		found := false
+		for _, actual := range functions {
+			if actual == expected {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected function %s not found in %v", expected, functions)
+		}
+	}
+}
+
+func TestExtractFunctionName(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"func ValidateUser(user *User) error {", "ValidateUser"},
+		{"func CreateUser(name, email string) *User {", "CreateUser"},
+		{"func main() {", "main"},
+		{"func (u *User) GetName() string {", "GetName"},
+		{"+func NewUser() *User {", "NewUser"},
+		{" func helper() {", "helper"},
+		{"not a function", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			result := extractFunctionName(test.input)
+			if result != test.expected {
+				t.Errorf("extractFunctionName(%q) = %q, expected %q", test.input, result, test.expected)
+			}
+		})
+	}
+}
+
+func TestFilterGoFiles(t *testing.T) {
+	result := &DiffResult{
+		Files: []FileDiff{
+			{NewPath: "user.go"},
+			{NewPath: "user_test.go"}, // should be filtered out
+			{NewPath: "README.md"},    // should be filtered out
+			{NewPath: "handler.go"},
+		},
+	}
+
+	filtered := result.FilterGoFiles()
+
+	if len(filtered.Files) != 2 {
+		t.Errorf("expected 2 Go files, got %d", len(filtered.Files))
+	}
+
+	expectedFiles := []string{"user.go", "handler.go"}
+	for i, file := range filtered.Files {
+		if file.NewPath != expectedFiles[i] {
+			t.Errorf("expected %s, got %s", expectedFiles[i], file.NewPath)
+		}
+	}
+}
+
+func TestChangedLines(t *testing.T) {
+	diffOutput := `diff --git a/user.go b/user.go
+index 1234567..abcdefg 100644
+--- a/user.go
++++ b/user.go
+@@ -20,7 +20,9 @@ func ValidateUser(user *User) error {
+ 	if user == nil {
+ 		return ErrNilUser
+ 	}
+-	if user.Name == "" {
++	name := strings.TrimSpace(user.Name)
++	if name == "" {
+ 		return ErrEmptyName
+ 	}
++	user.Name = name
+ 	return nil
+`
+	result, err := ParseDiff(diffOutput)
+	if err != nil {
+		t.Fatalf("ParseDiff failed: %v", err)
+	}
+
+	// Removed line 23 is reported at the new-file line that replaced it
+	got := result.Files[0].ChangedLines("ValidateUser")
+	want := []int{23, 24, 27}
+	if len(got) != len(want) {
+		t.Fatalf("Expected changed lines %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Expected changed lines %v, got %v", want, got)
+			break
+		}
+	}
+
+	if lines := result.Files[0].ChangedLines("GetUser"); len(lines) != 0 {
+		t.Errorf("Expected no changed lines for GetUser, got %v", lines)
+	}
+}
+
+func TestParseDiffCRLF(t *testing.T) {
+	diffOutput := "diff --git a/user.go b/user.go\n" +
+		"index 1234567..abcdefg 100644\n" +
+		"--- a/user.go\n" +
+		"+++ b/user.go\n" +
+		"@@ -20,7 +20,9 @@ func (s *Store) Validate(user *User) error {\n" +
+		" \tif user == nil {\n" +
+		" \t\treturn ErrNilUser\n" +
+		" \t}\n" +
+		"-\tif user.Name == \"\" {\n" +
+		"+\tname := strings.TrimSpace(user.Name)\n" +
+		"+\tif name == \"\" {\n" +
+		" \t\treturn ErrEmptyName\n" +
+		" \t}\n" +
+		"+\n" +
+		"+func Normalize(name string) string {\n" +
+		"+\treturn strings.ToLower(name)\n" +
+		"+}\n"
+
+	lf, err := ParseDiff(diffOutput)
+	if err != nil {
+		t.Fatalf("ParseDiff failed: %v", err)
+	}
+	crlf, err := ParseDiff(strings.ReplaceAll(diffOutput, "\n", "\r\n"))
+	if err != nil {
+		t.Fatalf("ParseDiff failed on CRLF input: %v", err)
+	}
+
+	// A checkout with core.autocrlf parses exactly like the LF original
+	if !reflect.DeepEqual(crlf, lf) {
+		t.Errorf("CRLF diff parsed differently:\n%+v\nwant\n%+v", crlf, lf)
+	}
+	if len(crlf.FilterGoFiles().Files) != 1 || crlf.Files[0].NewPath != "user.go" {
+		t.Errorf("Expected user.go as a Go file, got %+v", crlf.Files)
+	}
+	functions := crlf.Files[0].GetModifiedFunctions()
+	sort.Strings(functions)
+	if !reflect.DeepEqual(functions, []string{"Normalize", "Validate"}) {
+		t.Errorf("Expected [Normalize Validate], got %v", functions)
+	}
+	for _, change := range crlf.Files[0].Changes {
+		if strings.Contains(change.Line, "\r") {
+			t.Errorf("Change line %q carries a \\r", change.Line)
+		}
+	}
+}
+
+func TestDiffResultJSON(t *testing.T) {
+	result, err := ParseDiff("diff --git a/sum.go b/sum.go\n@@ -1,3 +1,3 @@\n func Sum(a, b int) int {\n-\treturn a - b\n+\treturn a + b\n }\n")
+	if err != nil {
+		t.Fatalf("ParseDiff failed: %v", err)
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !strings.Contains(string(data), `{"type":"removed","line":"\treturn a - b","line_num":2,"function":"Sum"}`) {
+		t.Errorf("Expected change types by name, got %s", data)
+	}
+
+	var decoded DiffResult
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(&decoded, result) {
+		t.Errorf("Expected %+v to round-trip, got %+v", result, decoded)
+	}
+	if got := decoded.Files[0].GetModifiedFunctions(); !reflect.DeepEqual(got, []string{"Sum"}) {
+		t.Errorf("Expected [Sum] modified, got %v", got)
+	}
+
+	if err := json.Unmarshal([]byte(`{"type":"renamed"}`), &DiffChange{}); err == nil {
+		t.Error("Expected an unknown change type to fail")
+	}
+}
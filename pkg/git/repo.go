@@ -0,0 +1,84 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Repo runs git against one repository
+type Repo struct {
+	Dir    string // directory git runs in; empty means the current directory
+	Binary string // git executable; empty means "git" on PATH
+}
+
+// Command builds a git command run in the repository, killed when ctx ends
+func (r Repo) Command(ctx context.Context, args ...string) *exec.Cmd {
+	binary := r.Binary
+	if binary == "" {
+		binary = "git"
+	}
+	cmd := exec.CommandContext(ctx, binary, args...)
+	cmd.Dir = r.Dir
+	return cmd
+}
+
+// Diff returns the changes between two refs, each placed in the function it
+// falls in
+func (r Repo) Diff(ctx context.Context, from, to string) (*DiffResult, error) {
+	// Get the raw diff with function context
+	output, err := r.Command(ctx, "diff", "--function-context", from, to).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get git diff: %w", err)
+	}
+
+	return parseDiff(string(output))
+}
+
+// ChangedFiles returns the paths, relative to the repository root, of the
+// files changed between two refs
+func (r Repo) ChangedFiles(ctx context.Context, from, to string) ([]string, error) {
+	output, err := r.Command(ctx, "diff", "--name-only", from, to).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get changed files: %w", err)
+	}
+
+	var files []string
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
+
+// FileCommit is a commit that touched a file, as git log summarizes it
+type FileCommit struct {
+	Subject string `json:"subject"`
+	Author  string `json:"author"`
+}
+
+// RecentCommits returns the last n commits reachable from HEAD that touched
+// path, newest first. Renames aren't followed: rename detection mistakes
+// small files that start alike for copies of each other.
+func (r Repo) RecentCommits(ctx context.Context, path string, n int) ([]FileCommit, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	output, err := r.Command(ctx, "log", "-n", strconv.Itoa(n), "--format=%an%x00%s", "--", path).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history of %s: %w", path, err)
+	}
+
+	var commits []FileCommit
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		author, subject, ok := strings.Cut(line, "\x00")
+		if !ok {
+			continue
+		}
+		commits = append(commits, FileCommit{Subject: strings.TrimSpace(subject), Author: strings.TrimSpace(author)})
+	}
+	return commits, nil
+}
@@ -1,17 +1,98 @@
 package models
 
+import (
+	"path/filepath"
+	"time"
+)
+
 // FunctionInfo represents a Go function to generate tests for
 type FunctionInfo struct {
 	Name       string          `json:"name"`
 	Package    string          `json:"package"`
-	File       string          `json:"file"`
+	File       string          `json:"file"`                 // relative to the project root, or absolute for files outside it
+	StartLine  int             `json:"start_line,omitempty"` // line of the func keyword
+	EndLine    int             `json:"end_line,omitempty"`   // line of the closing brace
 	Signature  string          `json:"signature"`
 	Parameters []ParameterInfo `json:"parameters"`
 	Returns    []ReturnInfo    `json:"returns"`
 	IsMethod   bool            `json:"is_method"`
-	Receiver   *ReceiverInfo   `json:"receiver,omitempty"`
-	Comments   []string        `json:"comments"`
-	Complexity ComplexityInfo  `json:"complexity"`
+
+	ReturnPattern ReturnPattern  `json:"return_pattern,omitempty"` // shape of Returns the tests' assertions follow
+	Receiver      *ReceiverInfo  `json:"receiver,omitempty"`
+	Comments      []string       `json:"comments"`
+	Complexity    ComplexityInfo `json:"complexity"`
+	ChangeDiff    string         `json:"change_diff,omitempty"` // added/removed lines from the git diff
+
+	ChangedLines []int        `json:"changed_lines,omitempty"` // new-file lines touched by the git diff
+	ChangeFocus  *ChangeFocus `json:"change_focus,omitempty"`  // in long functions, the changed code to aim tests at
+	Body         string       `json:"body,omitempty"`          // function body source, braces included
+
+	PromotedFrom    string   `json:"promoted_from,omitempty"`    // embedded type declaring a promoted method
+	TypeDefinitions []string `json:"type_definitions,omitempty"` // source of the types involved, for context
+
+	ReceiverDefinition string `json:"receiver_definition,omitempty"` // receiver type source, wherever it's declared in the package
+	ReceiverUnresolved string `json:"receiver_unresolved,omitempty"` // why ReceiverDefinition couldn't be resolved
+
+	ExampleValues []string `json:"example_values,omitempty"` // literal calls and table entries existing tests use for it
+	FuzzSeeds     []string `json:"fuzz_seeds,omitempty"`     // f.Add arguments from literal calls to it in the package and its tests
+
+	InterfaceReturns []InterfaceReturn `json:"interface_returns,omitempty"` // results of interface type, with what tests can call on them
+
+	IsDeprecated bool `json:"is_deprecated,omitempty"` // doc comment has a "Deprecated:" paragraph
+	IsGeneric    bool `json:"is_generic,omitempty"`    // declares type parameters
+
+	IsExternalImpl bool `json:"is_external_impl,omitempty"` // declared without a body, implemented in assembly or linked in
+	Explicit       bool `json:"explicit,omitempty"`         // named with --function or picked by a file.go:40-80 range, so the filters don't apply
+
+	UnusedParams   []string `json:"unused_params,omitempty"`   // named parameters the body never refers to
+	DroppedContext string   `json:"dropped_context,omitempty"` // context.Context parameter no call in the body receives
+
+	BlastRadius   string   `json:"blast_radius,omitempty"`   // triggers.blast_radius mode that added it as a target
+	CallsModified []string `json:"calls_modified,omitempty"` // modified functions it calls, when added by blast radius
+
+	Root string `json:"-"` // absolute project root File is relative to, when it isn't the working directory
+}
+
+// SourcePath returns File as a path to read from the working directory
+func (fn FunctionInfo) SourcePath() string {
+	return fn.ResolvePath(fn.File)
+}
+
+// ResolvePath returns path, relative to the project root like File, as a
+// path to read or write from the working directory
+func (fn FunctionInfo) ResolvePath(path string) string {
+	if fn.Root == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(fn.Root, path)
+}
+
+// ReturnPattern is the shape of a function's results, which decides how its
+// tests assert on them
+type ReturnPattern string
+
+const (
+	ReturnsNone          ReturnPattern = "none"            // no results
+	ReturnsErrOnly       ReturnPattern = "err_only"        // error
+	ReturnsValueErr      ReturnPattern = "value_err"       // (T, error)
+	ReturnsValueOk       ReturnPattern = "value_ok"        // (T, bool), the comma-ok idiom
+	ReturnsMultiValueErr ReturnPattern = "multi_value_err" // (T1, T2, ..., error)
+)
+
+// ChangeFocus is what a diff changed inside a long function, so its tests can
+// target the change rather than the whole function
+type ChangeFocus struct {
+	Lines    []string `json:"lines"`              // changed source lines, as "45: if age < 0 {"
+	Branches []string `json:"branches,omitempty"` // headers of the branches enclosing them, as "40: switch kind {"
+}
+
+// InterfaceReturn is a result of interface type, whose concrete type a test
+// can't see from the signature
+type InterfaceReturn struct {
+	Type       string   `json:"type"`                 // as written in the signature, e.g. io.Reader
+	Methods    []string `json:"methods,omitempty"`    // method set, e.g. "Read(p []byte) (n int, err error)"
+	Concrete   []string `json:"concrete,omitempty"`   // package types the function returns for it, e.g. *fileReader
+	Unresolved string   `json:"unresolved,omitempty"` // why Methods is incomplete or missing
 }
 
 // ParameterInfo represents a function parameter
@@ -28,8 +109,15 @@ type ReturnInfo struct {
 
 // ReceiverInfo represents method receiver
 type ReceiverInfo struct {
-	Name string `json:"name"`
-	Type string `json:"type"`
+	Name       string      `json:"name"`
+	Type       string      `json:"type"`
+	TypeParams []TypeParam `json:"type_params,omitempty"` // of a generic receiver type, e.g. K and V of *Cache[K, V]
+}
+
+// TypeParam is a type parameter of a generic receiver type
+type TypeParam struct {
+	Name       string `json:"name"`                 // as the method's receiver spells it
+	Constraint string `json:"constraint,omitempty"` // from the type's declaration, e.g. "comparable"
 }
 
 // ComplexityInfo provides hints for test generation
@@ -39,6 +127,10 @@ type ComplexityInfo struct {
 	HasInterfaces        bool     `json:"has_interfaces"`        // uses interfaces
 	HasChannels          bool     `json:"has_channels"`          // uses channels
 	HasGoroutines        bool     `json:"has_goroutines"`        // spawns goroutines
+	MutatesArgs          bool     `json:"mutates_args"`          // assigns through pointer params
+	UsesEnv              bool     `json:"uses_env"`              // reads or changes environment variables
+	UsesWorkingDir       bool     `json:"uses_working_dir"`      // reads or changes the working directory
+	UsesFilesystem       bool     `json:"uses_filesystem"`       // opens, writes or removes files
 	Dependencies         []string `json:"dependencies"`          // external dependencies
 	CyclomaticComplexity int      `json:"cyclomatic_complexity"` // rough estimate
 }
@@ -47,25 +139,39 @@ type ComplexityInfo struct {
 type TestGenerationRequest struct {
 	Functions []FunctionInfo `json:"functions"`
 	Context   RequestContext `json:"context"`
+	TestType  TestType       `json:"test_type,omitempty"` // kind of tests asked for with --type (empty = unit)
 }
 
 // RequestContext provides additional context for test generation
 type RequestContext struct {
 	ProjectName   string            `json:"project_name"`
 	PackageName   string            `json:"package_name"`
-	ExistingTests []string          `json:"existing_tests"` // existing test function names
-	Imports       []string          `json:"imports"`        // package imports
-	Constants     map[string]string `json:"constants"`      // relevant constants
+	ExistingTests []string          `json:"existing_tests"`           // existing test function names
+	Imports       []string          `json:"imports"`                  // package imports
+	ImportAliases map[string]string `json:"import_aliases,omitempty"` // alias -> path of imports the sources rename
+	DotImports    []string          `json:"dot_imports,omitempty"`    // paths the sources dot-import
+	Constants     map[string]string `json:"constants"`                // relevant constants
+	Variables     map[string]string `json:"variables,omitempty"`      // package-level variables with initializers
 	GitContext    GitContext        `json:"git_context"`
+	GoVersion     string            `json:"go_version,omitempty"` // go directive from go.mod
 }
 
 // GitContext provides git-related context
 type GitContext struct {
 	CommitMessage string   `json:"commit_message"`
-	ChangedLines  []int    `json:"changed_lines"`
+	ChangedLines  []int    `json:"changed_lines"` // changed lines of the targets, when they share one file
 	Author        string   `json:"author"`
 	Branch        string   `json:"branch"`
 	FilesDiff     []string `json:"files_diff"`
+
+	RecentCommits map[string][]RecentCommit `json:"recent_commits,omitempty"` // by target file, newest first (ai.recent_commits)
+}
+
+// RecentCommit is an earlier commit to a target's file, hinting at the
+// intent behind its code
+type RecentCommit struct {
+	Subject string `json:"subject"`
+	Author  string `json:"author"`
 }
 
 // TestGenerationResponse represents the AI's test generation response
@@ -74,15 +180,24 @@ type TestGenerationResponse struct {
 	Reasoning  string          `json:"reasoning"`  // why these tests were chosen
 	Confidence float64         `json:"confidence"` // AI's confidence level
 	Warnings   []string        `json:"warnings"`   // potential issues
+
+	TokensUsed int `json:"-"` // as the provider reports them, 0 when it doesn't
 }
 
 // GeneratedTest represents a single generated test
 type GeneratedTest struct {
-	Name        string   `json:"name"`        // test function name
-	Code        string   `json:"code"`        // complete test code
-	Description string   `json:"description"` // what the test does
-	TestType    TestType `json:"test_type"`   // unit, integration, etc.
-	Coverage    []string `json:"coverage"`    // what scenarios it covers
+	Name        string   `json:"name"`                 // test function name
+	Code        string   `json:"code"`                 // complete test code
+	Description string   `json:"description"`          // what the test does
+	TestType    TestType `json:"test_type"`            // unit, integration, etc.
+	Coverage    []string `json:"coverage"`             // what scenarios it covers
+	Confidence  float64  `json:"confidence,omitempty"` // per-test confidence, if the AI provides one
+	Additions   []string `json:"additions,omitempty"`  // new table entries for delta regeneration
+
+	TargetFunction string      `json:"target_function,omitempty"` // function ("Name" or "Type.Method") the test exercises, as resolved
+	MatchedBy      MatchMethod `json:"matched_by,omitempty"`      // how TargetFunction was resolved
+
+	QuarantineReason string `json:"quarantine_reason,omitempty"` // set when the test is held back for review instead of written
 }
 
 // TestType represents different types of tests
@@ -96,6 +211,17 @@ const (
 	FuzzTest        TestType = "fuzz"
 )
 
+// MatchMethod records how a generated test was paired with the function it
+// exercises, from most to least reliable
+type MatchMethod string
+
+const (
+	MatchByTargetFunction MatchMethod = "target_function" // the AI's target_function field
+	MatchByName           MatchMethod = "name"            // the test name, e.g. TestType_Method
+	MatchByCalls          MatchMethod = "calls"           // the target function the test code calls
+	MatchUnresolved       MatchMethod = "unresolved"      // none of the above; the test isn't written
+)
+
 // GenerationStats tracks test generation statistics
 type GenerationStats struct {
 	FilesProcessed  int            `json:"files_processed"`
@@ -106,4 +232,32 @@ type GenerationStats struct {
 	AITokensUsed    int            `json:"ai_tokens_used"`
 	ErrorsByType    map[string]int `json:"errors_by_type"`
 	FunctionsByType map[string]int `json:"functions_by_type"`
+
+	RecordedAt             time.Time      `json:"recorded_at"`
+	Commit                 string         `json:"commit,omitempty"`                  // HEAD when the stats were taken
+	WouldGenerate          int            `json:"would_generate"`                    // functions a generate run would target
+	ComplexityDistribution map[string]int `json:"complexity_distribution,omitempty"` // functions per cyclomatic complexity bucket
+	DependencyHotspots     map[string]int `json:"dependency_hotspots,omitempty"`     // functions using each dependency
+	Timings                []PhaseTiming  `json:"timings,omitempty"`                 // --timings
+
+	Introduced []TargetIntroduction `json:"introduced,omitempty"` // --why
+}
+
+// TargetIntroduction names the commit in the analyzed range that introduced
+// a function that would get tests
+type TargetIntroduction struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Commit   string `json:"commit,omitempty"` // empty when the function predates the range
+	Author   string `json:"author,omitempty"`
+	Summary  string `json:"summary,omitempty"`
+}
+
+// PhaseTiming is the time a run spent in one of its phases, such as parsing
+// or provider calls, added up over Count passes
+type PhaseTiming struct {
+	Phase   string  `json:"phase"`
+	Seconds float64 `json:"seconds"`
+	Count   int     `json:"count"`
 }
@@ -0,0 +1,852 @@
+// Package parser summarizes Go source files for test generation: each
+// function's signature, parameters, results, body, doc comments, the calls
+// it makes and complexity signals such as error returns, goroutines or
+// environment access, along with the file's imports, constants, variables
+// and types.
+//
+// The summaries are plain structs with JSON tags. Nothing is printed:
+// files that can't be read or parsed are reported as errors.
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileAnalysis contains all parsed information from a Go file
+type FileAnalysis struct {
+	PackageName string            `json:"package_name"`
+	Imports     []ImportInfo      `json:"imports"`
+	Functions   []FunctionInfo    `json:"functions"`
+	Constants   map[string]string `json:"constants"` // by name, the literal or identifier each is set to, "unknown" for other expressions
+	Variables   map[string]string `json:"variables"` // package-level variables with initializers, as Constants
+	Types       []TypeInfo        `json:"types"`
+	IsCgo       bool              `json:"is_cgo"` // imports "C", which isn't listed in Imports
+}
+
+// ImportInfo represents an import statement
+type ImportInfo struct {
+	Name string `json:"name,omitempty"` // alias name (if any)
+	Path string `json:"path"`           // import path
+}
+
+// TypeInfo represents type definitions in the file
+type TypeInfo struct {
+	Name       string   `json:"name"`
+	Kind       string   `json:"kind"` // struct, interface, etc.
+	Fields     []string `json:"fields"`
+	Embedded   []string `json:"embedded"`   // embedded struct fields, by type name without pointer or package
+	Definition string   `json:"definition"` // source of the type declaration
+}
+
+// FunctionInfo represents detailed function analysis
+type FunctionInfo struct {
+	Name       string          `json:"name"`
+	Package    string          `json:"package"`
+	File       string          `json:"file"`
+	StartLine  int             `json:"start_line"`
+	EndLine    int             `json:"end_line"`
+	Signature  string          `json:"signature"`
+	Parameters []ParameterInfo `json:"parameters"`
+	Returns    []ReturnInfo    `json:"returns"`
+	IsMethod   bool            `json:"is_method"`
+	Receiver   *ReceiverInfo   `json:"receiver,omitempty"`
+	Comments   []string        `json:"comments"`
+	Complexity ComplexityInfo  `json:"complexity"`
+	Body       string          `json:"body"` // function body for context
+
+	IsDeprecated bool     `json:"is_deprecated"` // a comment paragraph starts with "Deprecated:"
+	IsGeneric    bool     `json:"is_generic"`    // declares type parameters
+	Calls        []string `json:"calls"`         // package functions ("name") and receiver methods ("Type.Method") it calls, see CallName
+	UnusedParams []string `json:"unused_params"` // named parameters the body never refers to
+
+	DroppedContext string `json:"dropped_context,omitempty"` // context.Context parameter no call in the body receives
+
+	IsExternalImpl bool `json:"is_external_impl"` // declared without a body: implemented in assembly or linked in
+}
+
+// ParameterInfo is a function parameter; Name is empty for unnamed ones
+type ParameterInfo struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// ReturnInfo is a function result; Name is empty for unnamed ones
+type ReturnInfo struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// ReceiverInfo is a method's receiver
+type ReceiverInfo struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	TypeParams []string `json:"type_params,omitempty"` // type parameter names of a generic receiver type, as the method spells them
+}
+
+// ComplexityInfo is what a function's body does that its tests have to
+// account for
+type ComplexityInfo struct {
+	HasErrors            bool     `json:"has_errors"`
+	HasPointers          bool     `json:"has_pointers"`
+	HasInterfaces        bool     `json:"has_interfaces"`
+	HasChannels          bool     `json:"has_channels"`
+	HasGoroutines        bool     `json:"has_goroutines"`
+	HasDefers            bool     `json:"has_defers"`
+	HasPanic             bool     `json:"has_panic"`
+	MutatesArgs          bool     `json:"mutates_args"`     // assigns through a pointer parameter
+	UsesEnv              bool     `json:"uses_env"`         // reads or changes environment variables
+	UsesWorkingDir       bool     `json:"uses_working_dir"` // reads or changes the working directory
+	UsesFilesystem       bool     `json:"uses_filesystem"`  // opens, writes or removes files
+	Dependencies         []string `json:"dependencies"`     // import paths the body uses
+	CyclomaticComplexity int      `json:"cyclomatic_complexity"`
+	ControlFlowCount     int      `json:"control_flow_count"` // if, for, switch, select statements
+}
+
+// ParseFile analyzes a Go source file and extracts function information
+func ParseFile(filePath string) (*FileAnalysis, error) {
+	src, err := ReadSource(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse file %s: %w", filePath, err)
+	}
+	return ParseSource(filePath, src)
+}
+
+// ParseSource analyzes Go source read from elsewhere, e.g. a file at some
+// git revision; filePath is only recorded in the results and errors
+func ParseSource(filePath string, src []byte) (*FileAnalysis, error) {
+	src = NormalizeSource(src)
+	fset := token.NewFileSet()
+
+	// Parse the file
+	node, err := parser.ParseFile(fset, filePath, src, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse file %s: %w", filePath, err)
+	}
+
+	analysis := &FileAnalysis{
+		PackageName: node.Name.Name,
+		Constants:   make(map[string]string),
+		Variables:   make(map[string]string),
+	}
+
+	// Extract imports; the cgo pseudo-package isn't one tests can use
+	for _, imp := range node.Imports {
+		importInfo := ImportInfo{
+			Path: strings.Trim(imp.Path.Value, `"`),
+		}
+		if importInfo.Path == "C" {
+			analysis.IsCgo = true
+			continue
+		}
+		if imp.Name != nil {
+			importInfo.Name = imp.Name.Name
+		}
+		analysis.Imports = append(analysis.Imports, importInfo)
+	}
+
+	// Associate comments with declarations so comments detached from a
+	// function (blank line, /* */ block) are still found
+	comments := ast.NewCommentMap(fset, node, node.Comments)
+
+	// Walk the AST and extract information
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.FuncDecl:
+			// Include all functions, not just exported ones
+			// We'll filter later based on requirements
+			funcInfo := analyzeFunctionDecl(x, fset, filePath, comments)
+			funcInfo.Package = analysis.PackageName
+			if x.Body != nil {
+				funcInfo.Complexity.Dependencies = usedImports(x.Body, analysis.Imports)
+			}
+			analysis.Functions = append(analysis.Functions, funcInfo)
+		case *ast.GenDecl:
+			// Handle constants and type declarations
+			analyzeGenDecl(x, fset, analysis)
+		}
+		return true
+	})
+
+	return analysis, nil
+}
+
+// analyzeFunctionDecl extracts detailed information from a function declaration
+func analyzeFunctionDecl(funcDecl *ast.FuncDecl, fset *token.FileSet, filePath string, comments ast.CommentMap) FunctionInfo {
+	funcInfo := FunctionInfo{
+		Name:           funcDecl.Name.Name,
+		Package:        filepath.Base(filepath.Dir(filePath)),
+		File:           filePath,
+		IsGeneric:      funcDecl.Type.TypeParams != nil && len(funcDecl.Type.TypeParams.List) > 0,
+		IsExternalImpl: funcDecl.Body == nil,
+	}
+
+	// Get line numbers
+	startPos := fset.Position(funcDecl.Pos())
+	endPos := fset.Position(funcDecl.End())
+	funcInfo.StartLine = startPos.Line
+	funcInfo.EndLine = endPos.Line
+
+	// Check if it's a method (has receiver)
+	if funcDecl.Recv != nil && len(funcDecl.Recv.List) > 0 {
+		funcInfo.IsMethod = true
+		receiver := funcDecl.Recv.List[0]
+		funcInfo.Receiver = &ReceiverInfo{
+			Type:       TypeString(receiver.Type),
+			TypeParams: receiverTypeParams(receiver.Type),
+		}
+		if len(receiver.Names) > 0 {
+			funcInfo.Receiver.Name = receiver.Names[0].Name
+		}
+	}
+
+	// Extract parameters
+	if funcDecl.Type.Params != nil {
+		for _, param := range funcDecl.Type.Params.List {
+			typeStr := TypeString(param.Type)
+			if len(param.Names) > 0 {
+				// Named parameters
+				for _, name := range param.Names {
+					funcInfo.Parameters = append(funcInfo.Parameters, ParameterInfo{
+						Name: name.Name,
+						Type: typeStr,
+					})
+				}
+			} else {
+				// Unnamed parameter (interface{}, etc.)
+				funcInfo.Parameters = append(funcInfo.Parameters, ParameterInfo{
+					Name: "",
+					Type: typeStr,
+				})
+			}
+		}
+	}
+
+	// Extract return types
+	if funcDecl.Type.Results != nil {
+		for _, result := range funcDecl.Type.Results.List {
+			typeStr := TypeString(result.Type)
+			if len(result.Names) > 0 {
+				// Named returns
+				for _, name := range result.Names {
+					funcInfo.Returns = append(funcInfo.Returns, ReturnInfo{
+						Name: name.Name,
+						Type: typeStr,
+					})
+				}
+			} else {
+				// Unnamed return
+				funcInfo.Returns = append(funcInfo.Returns, ReturnInfo{
+					Type: typeStr,
+				})
+			}
+		}
+	}
+
+	// Extract comments
+	for _, group := range leadingComments(funcDecl, comments) {
+		lines := commentLines(group)
+		funcInfo.Comments = append(funcInfo.Comments, lines...)
+		if deprecated(lines) {
+			funcInfo.IsDeprecated = true
+		}
+	}
+
+	// Build signature string
+	funcInfo.Signature = buildSignatureString(funcInfo)
+
+	// Analyze complexity
+	if funcDecl.Body != nil {
+		funcInfo.Complexity = analyzeComplexity(funcDecl.Body)
+		funcInfo.Body = extractBodyString(funcDecl.Body, fset)
+		funcInfo.Calls = collectCalls(funcDecl, funcInfo.Receiver)
+		funcInfo.UnusedParams = unusedParams(funcDecl)
+		funcInfo.DroppedContext = droppedContext(funcDecl)
+	}
+
+	// Additional complexity analysis from signature
+	// Check for error returns
+	for _, ret := range funcInfo.Returns {
+		if ret.Type == "error" {
+			funcInfo.Complexity.HasErrors = true
+		}
+	}
+
+	// Check for pointer parameters
+	for _, param := range funcInfo.Parameters {
+		if strings.HasPrefix(param.Type, "*") {
+			funcInfo.Complexity.HasPointers = true
+		}
+	}
+
+	// Check for pointer receiver
+	if funcInfo.IsMethod && funcInfo.Receiver != nil && strings.HasPrefix(funcInfo.Receiver.Type, "*") {
+		funcInfo.Complexity.HasPointers = true
+	}
+
+	// Check for mutation through pointer parameters
+	if funcDecl.Body != nil {
+		funcInfo.Complexity.MutatesArgs = detectPointerMutation(funcDecl.Body, funcInfo.Parameters)
+	}
+
+	return funcInfo
+}
+
+// leadingComments returns the comment groups above a function: its doc
+// comment plus any detached comments the comment map associates with it.
+// Comments on the line after the previous declaration, followed by a blank
+// line, belong to that declaration and aren't included.
+func leadingComments(funcDecl *ast.FuncDecl, comments ast.CommentMap) []*ast.CommentGroup {
+	var groups []*ast.CommentGroup
+	for _, group := range comments[funcDecl] {
+		// Trailing and body comments are associated with the function too
+		if group.End() <= funcDecl.Pos() {
+			groups = append(groups, group)
+		}
+	}
+	if len(groups) == 0 && funcDecl.Doc != nil {
+		groups = append(groups, funcDecl.Doc)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Pos() < groups[j].Pos() })
+	return groups
+}
+
+// commentLines returns the text of each line of a comment group without the
+// comment markers
+func commentLines(group *ast.CommentGroup) []string {
+	var lines []string
+	for _, comment := range group.List {
+		if !strings.HasPrefix(comment.Text, "/*") {
+			lines = append(lines, strings.TrimPrefix(comment.Text, "//"))
+			continue
+		}
+		block := strings.TrimSuffix(strings.TrimPrefix(comment.Text, "/*"), "*/")
+		for _, line := range strings.Split(strings.Trim(block, "\n"), "\n") {
+			lines = append(lines, strings.TrimRight(line, " \t"))
+		}
+	}
+	return lines
+}
+
+// deprecated reports whether comment lines carry the standard deprecation
+// marker: a paragraph starting with "Deprecated:"
+func deprecated(lines []string) bool {
+	paragraphStart := true
+	for _, line := range lines {
+		text := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "*"))
+		if text == "" {
+			paragraphStart = true
+			continue
+		}
+		if paragraphStart && strings.HasPrefix(text, "Deprecated:") {
+			return true
+		}
+		paragraphStart = false
+	}
+	return false
+}
+
+// TypeString converts an ast.Expr to a string representation
+func TypeString(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.StarExpr:
+		return "*" + TypeString(t.X)
+	case *ast.ArrayType:
+		if t.Len == nil {
+			return "[]" + TypeString(t.Elt)
+		}
+		return "[...]" + TypeString(t.Elt) // simplified
+	case *ast.MapType:
+		return "map[" + TypeString(t.Key) + "]" + TypeString(t.Value)
+	case *ast.ChanType:
+		switch t.Dir {
+		case ast.SEND:
+			return "chan<- " + TypeString(t.Value)
+		case ast.RECV:
+			return "<-chan " + TypeString(t.Value)
+		default:
+			return "chan " + TypeString(t.Value)
+		}
+	case *ast.InterfaceType:
+		return "interface{}" // simplified
+	case *ast.StructType:
+		return "struct{}" // simplified
+	case *ast.FuncType:
+		return "func(...)" // simplified
+	case *ast.SelectorExpr:
+		return TypeString(t.X) + "." + t.Sel.Name
+	case *ast.IndexExpr:
+		return TypeString(t.X) + "[" + TypeString(t.Index) + "]"
+	case *ast.IndexListExpr:
+		args := make([]string, len(t.Indices))
+		for i, index := range t.Indices {
+			args[i] = TypeString(index)
+		}
+		return TypeString(t.X) + "[" + strings.Join(args, ", ") + "]"
+	default:
+		return "unknown"
+	}
+}
+
+// receiverTypeParams returns the type parameter names of a generic receiver
+// type such as *Cache[K, V], or nil when it isn't generic
+func receiverTypeParams(expr ast.Expr) []string {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	var indices []ast.Expr
+	switch t := expr.(type) {
+	case *ast.IndexExpr:
+		indices = []ast.Expr{t.Index}
+	case *ast.IndexListExpr:
+		indices = t.Indices
+	}
+	var names []string
+	for _, index := range indices {
+		names = append(names, TypeString(index))
+	}
+	return names
+}
+
+// analyzeComplexity analyzes function body for complexity indicators
+func analyzeComplexity(body *ast.BlockStmt) ComplexityInfo {
+	complexity := ComplexityInfo{}
+
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.CallExpr:
+			// Check for common patterns
+			if ident, ok := x.Fun.(*ast.Ident); ok {
+				switch ident.Name {
+				case "panic":
+					complexity.HasPanic = true
+				}
+			}
+			// Check for method calls that might indicate error handling
+			if sel, ok := x.Fun.(*ast.SelectorExpr); ok {
+				if sel.Sel.Name == "Error" {
+					complexity.HasErrors = true
+				}
+				detectProcessState(sel, &complexity)
+			}
+		case *ast.DeferStmt:
+			complexity.HasDefers = true
+		case *ast.GoStmt:
+			complexity.HasGoroutines = true
+		case *ast.IfStmt, *ast.ForStmt, *ast.RangeStmt, *ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.SelectStmt:
+			complexity.ControlFlowCount++
+		case *ast.StarExpr:
+			complexity.HasPointers = true
+		case *ast.ChanType:
+			complexity.HasChannels = true
+		case *ast.InterfaceType:
+			complexity.HasInterfaces = true
+		case *ast.Ident:
+			// Check for error type usage
+			if x.Name == "error" {
+				complexity.HasErrors = true
+			}
+		}
+		return true
+	})
+
+	// Also check function signature for error returns and pointer params
+	// This will be set by the calling function
+
+	// Simple cyclomatic complexity approximation
+	complexity.CyclomaticComplexity = complexity.ControlFlowCount + 1
+
+	return complexity
+}
+
+// osEnvFuncs, osWorkingDirFuncs and osFileFuncs are the os functions that
+// touch process-wide state or the filesystem
+var (
+	osEnvFuncs        = map[string]bool{"Getenv": true, "LookupEnv": true, "Setenv": true, "Unsetenv": true, "Clearenv": true, "Environ": true, "ExpandEnv": true}
+	osWorkingDirFuncs = map[string]bool{"Getwd": true, "Chdir": true}
+	osFileFuncs       = map[string]bool{"Open": true, "OpenFile": true, "Create": true, "ReadFile": true, "WriteFile": true, "Remove": true, "RemoveAll": true, "Rename": true, "Mkdir": true, "MkdirAll": true, "ReadDir": true, "Stat": true}
+)
+
+// detectProcessState records calls to os functions that use environment
+// variables, the working directory or files (tests of such functions can't
+// always run in parallel)
+func detectProcessState(sel *ast.SelectorExpr, complexity *ComplexityInfo) {
+	pkg, ok := sel.X.(*ast.Ident)
+	if !ok || pkg.Name != "os" || pkg.Obj != nil {
+		return
+	}
+	switch name := sel.Sel.Name; {
+	case osEnvFuncs[name]:
+		complexity.UsesEnv = true
+	case osWorkingDirFuncs[name]:
+		complexity.UsesWorkingDir = true
+	case osFileFuncs[name]:
+		complexity.UsesFilesystem = true
+	}
+}
+
+// detectPointerMutation reports whether the body assigns through a pointer
+// parameter, e.g. `u.Name = ...` or `*u = ...`
+func detectPointerMutation(body *ast.BlockStmt, params []ParameterInfo) bool {
+	pointerParams := make(map[string]bool)
+	for _, param := range params {
+		if strings.HasPrefix(param.Type, "*") && param.Name != "" && param.Name != "_" {
+			pointerParams[param.Name] = true
+		}
+	}
+
+	if len(pointerParams) == 0 {
+		return false
+	}
+
+	mutates := false
+	ast.Inspect(body, func(n ast.Node) bool {
+		if mutates {
+			return false
+		}
+
+		var targets []ast.Expr
+		switch x := n.(type) {
+		case *ast.AssignStmt:
+			if x.Tok != token.DEFINE {
+				targets = x.Lhs
+			}
+		case *ast.IncDecStmt:
+			targets = []ast.Expr{x.X}
+		}
+
+		for _, target := range targets {
+			if pointerParams[dereferencedRoot(target)] {
+				mutates = true
+			}
+		}
+		return true
+	})
+
+	return mutates
+}
+
+// unusedParams returns the named parameters, other than _, that the body
+// never refers to, in declaration order. References are matched by the
+// parser's identifier resolution, so a local shadowing a parameter doesn't
+// count as a use of it.
+func unusedParams(funcDecl *ast.FuncDecl) []string {
+	used := make(map[*ast.Object]bool)
+	ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok && ident.Obj != nil {
+			used[ident.Obj] = true
+		}
+		return true
+	})
+
+	var unused []string
+	for _, field := range funcDecl.Type.Params.List {
+		for _, name := range field.Names {
+			if name.Name != "_" && name.Obj != nil && !used[name.Obj] {
+				unused = append(unused, name.Name)
+			}
+		}
+	}
+	return unused
+}
+
+// droppedContext returns the name of the first context.Context parameter no
+// call in the body refers to, as an argument (doWork(ctx), WithTimeout(ctx,
+// d)) or receiver (ctx.Done()), so cancelling it can't reach anything the
+// function does. It's a lightweight check: a context stored in a struct or
+// returned counts as dropped, and one passed along after being derived from
+// counts as propagated.
+func droppedContext(funcDecl *ast.FuncDecl) string {
+	var contexts []*ast.Ident
+	for _, field := range funcDecl.Type.Params.List {
+		if TypeString(field.Type) != "context.Context" {
+			continue
+		}
+		for _, name := range field.Names {
+			if name.Name != "_" && name.Obj != nil {
+				contexts = append(contexts, name)
+			}
+		}
+	}
+	if len(contexts) == 0 {
+		return ""
+	}
+
+	passed := make(map[*ast.Object]bool)
+	ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			ast.Inspect(call, func(n ast.Node) bool {
+				if ident, ok := n.(*ast.Ident); ok && ident.Obj != nil {
+					passed[ident.Obj] = true
+				}
+				return true
+			})
+		}
+		return true
+	})
+
+	for _, ctx := range contexts {
+		if !passed[ctx.Obj] {
+			return ctx.Name
+		}
+	}
+	return ""
+}
+
+// dereferencedRoot returns the identifier an assignment target writes through.
+// Plain identifiers return "" since reassigning a pointer doesn't mutate the pointee.
+func dereferencedRoot(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.SelectorExpr:
+		return rootIdentName(e.X)
+	case *ast.StarExpr:
+		return rootIdentName(e.X)
+	case *ast.IndexExpr:
+		return dereferencedRoot(e.X)
+	case *ast.ParenExpr:
+		return dereferencedRoot(e.X)
+	}
+	return ""
+}
+
+// rootIdentName walks selectors, derefs and index expressions down to the base identifier
+func rootIdentName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.SelectorExpr:
+		return rootIdentName(e.X)
+	case *ast.StarExpr:
+		return rootIdentName(e.X)
+	case *ast.IndexExpr:
+		return rootIdentName(e.X)
+	case *ast.ParenExpr:
+		return rootIdentName(e.X)
+	}
+	return ""
+}
+
+// buildSignatureString creates a human-readable function signature
+func buildSignatureString(funcInfo FunctionInfo) string {
+	var sig strings.Builder
+
+	sig.WriteString("func ")
+
+	// Add receiver if it's a method
+	if funcInfo.IsMethod && funcInfo.Receiver != nil {
+		sig.WriteString("(")
+		if funcInfo.Receiver.Name != "" {
+			sig.WriteString(funcInfo.Receiver.Name + " ")
+		}
+		sig.WriteString(funcInfo.Receiver.Type)
+		sig.WriteString(") ")
+	}
+
+	sig.WriteString(funcInfo.Name)
+	sig.WriteString("(")
+
+	// Add parameters
+	for i, param := range funcInfo.Parameters {
+		if i > 0 {
+			sig.WriteString(", ")
+		}
+		if param.Name != "" {
+			sig.WriteString(param.Name + " ")
+		}
+		sig.WriteString(param.Type)
+	}
+
+	sig.WriteString(")")
+
+	// Add return types
+	if len(funcInfo.Returns) > 0 {
+		sig.WriteString(" ")
+		if len(funcInfo.Returns) > 1 {
+			sig.WriteString("(")
+		}
+		for i, ret := range funcInfo.Returns {
+			if i > 0 {
+				sig.WriteString(", ")
+			}
+			if ret.Name != "" {
+				sig.WriteString(ret.Name + " ")
+			}
+			sig.WriteString(ret.Type)
+		}
+		if len(funcInfo.Returns) > 1 {
+			sig.WriteString(")")
+		}
+	}
+
+	return sig.String()
+}
+
+// extractBodyString renders the function body, braces included, as gofmt'd source
+func extractBodyString(body *ast.BlockStmt, fset *token.FileSet) string {
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, body); err != nil {
+		start := fset.Position(body.Pos())
+		end := fset.Position(body.End())
+		return fmt.Sprintf("// Function body from line %d to %d", start.Line, end.Line)
+	}
+	return buf.String()
+}
+
+// usedImports lists the import paths a function body refers to, sorted;
+// local names shadowing an import don't count
+func usedImports(body *ast.BlockStmt, imports []ImportInfo) []string {
+	byName := make(map[string]string)
+	for _, imp := range imports {
+		name := imp.Name
+		if name == "" {
+			name = path.Base(imp.Path)
+		}
+		if name != "_" && name != "." {
+			byName[name] = imp.Path
+		}
+	}
+
+	seen := make(map[string]bool)
+	var used []string
+	ast.Inspect(body, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if ident, ok := sel.X.(*ast.Ident); ok && ident.Obj == nil {
+			if importPath, ok := byName[ident.Name]; ok && !seen[importPath] {
+				seen[importPath] = true
+				used = append(used, importPath)
+			}
+		}
+		return true
+	})
+	sort.Strings(used)
+	return used
+}
+
+// analyzeGenDecl handles const and type declarations
+func analyzeGenDecl(decl *ast.GenDecl, fset *token.FileSet, analysis *FileAnalysis) {
+	for _, spec := range decl.Specs {
+		switch s := spec.(type) {
+		case *ast.ValueSpec:
+			// Constants and variables
+			if decl.Tok == token.CONST {
+				for i, name := range s.Names {
+					if len(s.Values) > i {
+						// Simplified constant value extraction
+						analysis.Constants[name.Name] = extractValue(s.Values[i])
+					}
+				}
+			} else if decl.Tok == token.VAR {
+				// add variable handling
+				for i, name := range s.Names {
+					if len(s.Values) > i {
+						// Simplified variable value extraction
+						analysis.Variables[name.Name] = extractValue(s.Values[i])
+					}
+				}
+			}
+
+		case *ast.TypeSpec:
+			// Type definitions
+			typeInfo := TypeInfo{
+				Name: s.Name.Name,
+				Kind: TypeString(s.Type),
+			}
+			if structType, ok := s.Type.(*ast.StructType); ok {
+				typeInfo.Fields, typeInfo.Embedded = analyzeStructFields(structType)
+			}
+			var def bytes.Buffer
+			if err := format.Node(&def, fset, &ast.GenDecl{Tok: token.TYPE, Specs: []ast.Spec{s}}); err == nil {
+				typeInfo.Definition = def.String()
+			}
+			analysis.Types = append(analysis.Types, typeInfo)
+		}
+	}
+}
+func extractValue(expr ast.Expr) string {
+	switch v := expr.(type) {
+	case *ast.BasicLit:
+		return v.Value
+	case *ast.Ident:
+		return v.Name
+	}
+	return "unknown"
+}
+
+// FilterFunctions filters functions by names (from git diff analysis)
+func (fa *FileAnalysis) FilterFunctions(functionNames []string) []FunctionInfo {
+	nameSet := make(map[string]bool)
+	for _, name := range functionNames {
+		nameSet[name] = true
+	}
+
+	var filtered []FunctionInfo
+	for _, fn := range fa.Functions {
+		if nameSet[fn.Name] {
+			filtered = append(filtered, fn)
+		}
+	}
+	return filtered
+}
+
+// FunctionSource renders a function declaration from filePath, doc comment
+// included, as gofmt'd source. name is a plain function name or
+// "Type.Method" for a method.
+func FunctionSource(filePath, name string) (string, error) {
+	src, err := ReadSource(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse file %s: %w", filePath, err)
+	}
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, filePath, src, parser.ParseComments)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse file %s: %w", filePath, err)
+	}
+
+	for _, decl := range node.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || declName(funcDecl) != name {
+			continue
+		}
+
+		var inside []*ast.CommentGroup
+		start := funcDecl.Pos()
+		if funcDecl.Doc != nil {
+			start = funcDecl.Doc.Pos()
+		}
+		for _, group := range node.Comments {
+			if group.Pos() >= start && group.End() <= funcDecl.End() {
+				inside = append(inside, group)
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := format.Node(&buf, fset, &printer.CommentedNode{Node: funcDecl, Comments: inside}); err != nil {
+			return "", fmt.Errorf("failed to render %s: %w", name, err)
+		}
+		return buf.String(), nil
+	}
+
+	return "", fmt.Errorf("function %s not found in %s", name, filePath)
+}
+
+// declName names a function declaration as FunctionSource looks it up
+func declName(funcDecl *ast.FuncDecl) string {
+	if funcDecl.Recv == nil || len(funcDecl.Recv.List) == 0 {
+		return funcDecl.Name.Name
+	}
+	return BaseTypeName(TypeString(funcDecl.Recv.List[0].Type)) + "." + funcDecl.Name.Name
+}
@@ -0,0 +1,897 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFile(t *testing.T) {
+	// Create a temporary Go file for testing
+	testCode := `package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const MaxUsers = 100
+
+type User struct {
+	Name  string
+	Email string
+}
+
+// ValidateUser checks if a user is valid
+func ValidateUser(user *User) error {
+	if user == nil {
+		return errors.New("user cannot be nil")
+	}
+	if user.Email == "" {
+		return errors.New("email required")
+	}
+	if !strings.Contains(user.Email, "@") {
+		return errors.New("invalid email format")
+	}
+	return nil
+}
+
+// GetName returns the user's name
+func (u *User) GetName() string {
+	if u == nil {
+		return ""
+	}
+	return u.Name
+}
+
+func processUsers(users []User, handler func(User) error) (int, error) {
+	count := 0
+	for _, user := range users {
+		if err := handler(user); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func startWorker() {
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				panic(r)
+			}
+		}()
+		// worker logic
+	}()
+}`
+
+	// Write test file
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.go")
+	err := os.WriteFile(testFile, []byte(testCode), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	// Parse the file
+	analysis, err := ParseFile(testFile)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+
+	// Test package name
+	if analysis.PackageName != "main" {
+		t.Errorf("Expected package name 'main', got '%s'", analysis.PackageName)
+	}
+
+	// Test imports
+	expectedImports := []string{"errors", "fmt", "strings"}
+	if len(analysis.Imports) != len(expectedImports) {
+		t.Errorf("Expected %d imports, got %d", len(expectedImports), len(analysis.Imports))
+	}
+
+	// Test constants
+	if _, exists := analysis.Constants["MaxUsers"]; !exists {
+		t.Error("Expected MaxUsers constant to be found")
+	}
+
+	// Test functions
+	if len(analysis.Functions) != 4 {
+		t.Errorf("Expected 4 functions, got %d", len(analysis.Functions))
+	}
+
+	// Test ValidateUser function
+	var validateUser *FunctionInfo
+	for _, fn := range analysis.Functions {
+		if fn.Name == "ValidateUser" {
+			validateUser = &fn
+			break
+		}
+	}
+
+	if validateUser == nil {
+		t.Fatal("ValidateUser function not found")
+	}
+
+	// Test function signature
+	expectedSig := "func ValidateUser(user *User) error"
+	if validateUser.Signature != expectedSig {
+		t.Errorf("Expected signature '%s', got '%s'", expectedSig, validateUser.Signature)
+	}
+
+	// Test parameters
+	if len(validateUser.Parameters) != 1 {
+		t.Errorf("Expected 1 parameter, got %d", len(validateUser.Parameters))
+	}
+	if validateUser.Parameters[0].Name != "user" || validateUser.Parameters[0].Type != "*User" {
+		t.Errorf("Expected parameter 'user *User', got '%s %s'",
+			validateUser.Parameters[0].Name, validateUser.Parameters[0].Type)
+	}
+
+	// Test return types
+	if len(validateUser.Returns) != 1 || validateUser.Returns[0].Type != "error" {
+		t.Errorf("Expected return type 'error', got %v", validateUser.Returns)
+	}
+
+	// Test complexity analysis
+	if !validateUser.Complexity.HasErrors {
+		t.Error("Expected HasErrors to be true")
+	}
+	if !validateUser.Complexity.HasPointers {
+		t.Error("Expected HasPointers to be true")
+	}
+	if validateUser.Complexity.ControlFlowCount != 3 { // 3 if statements
+		t.Errorf("Expected ControlFlowCount 3, got %d", validateUser.Complexity.ControlFlowCount)
+	}
+
+	// Test method parsing (GetName)
+	var getName *FunctionInfo
+	for _, fn := range analysis.Functions {
+		if fn.Name == "GetName" {
+			getName = &fn
+			break
+		}
+	}
+
+	if getName == nil {
+		t.Fatal("GetName method not found")
+	}
+
+	if !getName.IsMethod {
+		t.Error("GetName should be identified as a method")
+	}
+	if getName.Receiver == nil || getName.Receiver.Type != "*User" {
+		t.Errorf("Expected receiver '*User', got %v", getName.Receiver)
+	}
+	expectedBody := "{\n\tif u == nil {\n\t\treturn \"\"\n\t}\n\treturn u.Name\n}"
+	if getName.Body != expectedBody {
+		t.Errorf("Expected body %q, got %q", expectedBody, getName.Body)
+	}
+
+	// Test complex function (startWorker)
+	var startWorker *FunctionInfo
+	for _, fn := range analysis.Functions {
+		if fn.Name == "startWorker" {
+			startWorker = &fn
+			break
+		}
+	}
+
+	if startWorker == nil {
+		t.Fatal("startWorker function not found")
+	}
+
+	if !startWorker.Complexity.HasGoroutines {
+		t.Error("Expected HasGoroutines to be true")
+	}
+	if !startWorker.Complexity.HasDefers {
+		t.Error("Expected HasDefers to be true")
+	}
+	if !startWorker.Complexity.HasPanic {
+		t.Error("Expected HasPanic to be true")
+	}
+}
+
+func TestFilterFunctions(t *testing.T) {
+	analysis := &FileAnalysis{
+		Functions: []FunctionInfo{
+			{Name: "ValidateUser"},
+			{Name: "GetName"},
+			{Name: "processUsers"},
+			{Name: "startWorker"},
+		},
+	}
+
+	// Filter for specific functions (like from git diff)
+	filtered := analysis.FilterFunctions([]string{"ValidateUser", "startWorker"})
+
+	if len(filtered) != 2 {
+		t.Errorf("Expected 2 filtered functions, got %d", len(filtered))
+	}
+
+	expectedNames := []string{"ValidateUser", "startWorker"}
+	for i, fn := range filtered {
+		if fn.Name != expectedNames[i] {
+			t.Errorf("Expected function '%s', got '%s'", expectedNames[i], fn.Name)
+		}
+	}
+}
+
+func TestExtractTypeString(t *testing.T) {
+	tests := []struct {
+		description string
+		// We can't easily test this without creating AST nodes,
+		// but the logic is tested implicitly in the main test
+	}{
+		{"pointer types should have * prefix"},
+		{"slice types should have [] prefix"},
+		{"map types should have map[key]value format"},
+		{"channel types should indicate direction"},
+	}
+
+	// This is more of a documentation test
+	for _, test := range tests {
+		t.Log(test.description)
+	}
+}
+
+func TestBuildSignatureString(t *testing.T) {
+	// Test regular function
+	funcInfo := FunctionInfo{
+		Name: "ValidateUser",
+		Parameters: []ParameterInfo{
+			{Name: "user", Type: "*User"},
+		},
+		Returns: []ReturnInfo{
+			{Type: "error"},
+		},
+	}
+
+	expected := "func ValidateUser(user *User) error"
+	signature := buildSignatureString(funcInfo)
+	if signature != expected {
+		t.Errorf("Expected '%s', got '%s'", expected, signature)
+	}
+
+	// Test method
+	methodInfo := FunctionInfo{
+		Name:     "GetName",
+		IsMethod: true,
+		Receiver: &ReceiverInfo{
+			Name: "u",
+			Type: "*User",
+		},
+		Returns: []ReturnInfo{
+			{Type: "string"},
+		},
+	}
+
+	expectedMethod := "func (u *User) GetName() string"
+	methodSignature := buildSignatureString(methodInfo)
+	if methodSignature != expectedMethod {
+		t.Errorf("Expected '%s', got '%s'", expectedMethod, methodSignature)
+	}
+}
+
+func TestParseFilePointerMutation(t *testing.T) {
+	testCode := `package user
+
+type User struct {
+	Name  string
+	Email string
+	Tags  []string
+}
+
+func normalize(u *User) {
+	u.Email = strings.ToLower(u.Email)
+}
+
+func reset(u *User) {
+	*u = User{}
+}
+
+func tag(u *User) {
+	u.Tags[0] = "new"
+}
+
+func reassign(u *User) *User {
+	u = &User{}
+	return u
+}
+
+func read(u *User) string {
+	name := u.Name
+	return name
+}
+
+func copyValue(u User) {
+	u.Name = "changed"
+}`
+
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "user.go")
+	if err := os.WriteFile(testFile, []byte(testCode), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	analysis, err := ParseFile(testFile)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+
+	expected := map[string]bool{
+		"normalize": true,  // pointer field assignment
+		"reset":     true,  // pointee replaced
+		"tag":       true,  // element assignment through pointer field
+		"reassign":  false, // only the local pointer changes
+		"read":      false, // no writes
+		"copyValue": false, // value parameter
+	}
+
+	for _, fn := range analysis.Functions {
+		want, ok := expected[fn.Name]
+		if !ok {
+			continue
+		}
+		if fn.Complexity.MutatesArgs != want {
+			t.Errorf("%s: expected MutatesArgs %t, got %t", fn.Name, want, fn.Complexity.MutatesArgs)
+		}
+	}
+}
+
+func TestParseFileUnusedParams(t *testing.T) {
+	testCode := `package store
+
+type Store struct{ items map[string]string }
+
+func (s *Store) Get(ctx context.Context, key string) string {
+	return s.items[key]
+}
+
+func (s *Store) Close(force bool) error {
+	return nil
+}
+
+func Shadowed(limit int) int {
+	total := 0
+	for limit := 0; limit < 3; limit++ {
+		total += limit
+	}
+	return total
+}
+
+func Closure(prefix string, _ int, names ...string) func() string {
+	return func() string {
+		return prefix + names[0]
+	}
+}
+
+func Unnamed(string, int) {}
+
+func Field(name string) Store {
+	return Store{items: map[string]string{"name": name}}
+}`
+
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "store.go")
+	if err := os.WriteFile(testFile, []byte(testCode), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	analysis, err := ParseFile(testFile)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+
+	expected := map[string][]string{
+		"Get":      {"ctx"},   // only key is read
+		"Close":    {"force"}, // stub body
+		"Shadowed": {"limit"}, // the loop variable shadows the parameter
+		"Closure":  nil,       // used inside a closure; _ is never reported
+		"Unnamed":  nil,       // nothing to name
+		"Field":    nil,       // used as a composite literal value
+	}
+	for _, fn := range analysis.Functions {
+		want, ok := expected[fn.Name]
+		if !ok {
+			continue
+		}
+		if !reflect.DeepEqual(fn.UnusedParams, want) {
+			t.Errorf("%s: expected unused parameters %v, got %v", fn.Name, want, fn.UnusedParams)
+		}
+	}
+}
+
+func TestParseFileDroppedContext(t *testing.T) {
+	testCode := `package fetch
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+func Ignored(ctx context.Context, url string) (*http.Response, error) {
+	return http.Get(url)
+}
+
+func Passed(ctx context.Context, url string) (*http.Request, error) {
+	return http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+}
+
+func Derived(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func Waited(ctx context.Context, done chan struct{}) {
+	select {
+	case <-ctx.Done():
+	case <-done:
+	}
+}
+
+func Stored(ctx context.Context) *http.Request {
+	return &http.Request{Method: "GET"}
+}
+
+func Blank(_ context.Context, url string) string {
+	return url
+}
+
+func Second(ctx context.Context, parent context.Context) error {
+	return parent.Err()
+}
+
+func NoContext(url string) string {
+	return url
+}`
+
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "fetch.go")
+	if err := os.WriteFile(testFile, []byte(testCode), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	analysis, err := ParseFile(testFile)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"Ignored":   "ctx", // accepted but never used
+		"Passed":    "",    // passed as an argument
+		"Derived":   "",    // a derived context is still propagation
+		"Waited":    "",    // ctx.Done() is a call on it
+		"Stored":    "ctx", // never reaches a call
+		"Blank":     "",    // _ is deliberately ignored
+		"Second":    "ctx", // only the second context is used
+		"NoContext": "",
+	}
+	for _, fn := range analysis.Functions {
+		want, ok := expected[fn.Name]
+		if !ok {
+			continue
+		}
+		if fn.DroppedContext != want {
+			t.Errorf("%s: expected dropped context %q, got %q", fn.Name, want, fn.DroppedContext)
+		}
+	}
+}
+
+func TestParseFileDetachedComments(t *testing.T) {
+	testCode := `package user
+
+func Previous() {}
+// Previous trails here and belongs to it.
+
+// Normalize lowercases the email before storage.
+
+func Normalize(email string) string {
+	return strings.ToLower(email) // inline, not intent
+}
+
+/*
+Reset clears every field,
+including tags.
+*/
+
+// Reset keeps the ID.
+func Reset(u *User) {}
+
+func Bare() {}
+`
+
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "user.go")
+	if err := os.WriteFile(testFile, []byte(testCode), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	analysis, err := ParseFile(testFile)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+
+	expected := map[string][]string{
+		"Previous":  nil,
+		"Normalize": {" Normalize lowercases the email before storage."},
+		"Reset":     {"Reset clears every field,", "including tags.", " Reset keeps the ID."},
+		"Bare":      nil,
+	}
+
+	for _, fn := range analysis.Functions {
+		want := expected[fn.Name]
+		if strings.Join(fn.Comments, "|") != strings.Join(want, "|") {
+			t.Errorf("%s: expected comments %q, got %q", fn.Name, want, fn.Comments)
+		}
+	}
+}
+
+func TestParseFileDeprecated(t *testing.T) {
+	testCode := `package user
+
+// Deprecated: use NewUser instead.
+func FirstLine() {}
+
+// OwnParagraph validates a user.
+//
+// Deprecated: use Validate, which also checks the email.
+func OwnParagraph(u User) error { return nil }
+
+/*
+BlockComment formats a name.
+
+Deprecated: use FormatName.
+*/
+func BlockComment(name string) string { return name }
+
+// Deprecated: detached from the function by a blank line.
+
+func Detached() {}
+
+func Previous() {}
+// Deprecated: trails Previous, not Trailing.
+
+// Trailing is current.
+func Trailing() {}
+
+// MidSentence is not Deprecated: the word only appears mid-sentence.
+func MidSentence() {}
+
+// Lowercase says deprecated: in the wrong case.
+func Lowercase() {}
+`
+
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "user.go")
+	if err := os.WriteFile(testFile, []byte(testCode), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	analysis, err := ParseFile(testFile)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+
+	expected := map[string]bool{
+		"FirstLine":    true,
+		"OwnParagraph": true,
+		"BlockComment": true,
+		"Detached":     true,
+		"Previous":     false,
+		"Trailing":     false,
+		"MidSentence":  false,
+		"Lowercase":    false,
+	}
+
+	for _, fn := range analysis.Functions {
+		if fn.IsDeprecated != expected[fn.Name] {
+			t.Errorf("%s: expected IsDeprecated %v, got %v", fn.Name, expected[fn.Name], fn.IsDeprecated)
+		}
+	}
+}
+
+func TestParseFileDependencies(t *testing.T) {
+	testCode := `package store
+
+import (
+	"fmt"
+	stdjson "encoding/json"
+	"strings"
+)
+
+func Encode(v any) (string, error) {
+	data, err := stdjson.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("encode: %w", err)
+	}
+	return string(data), nil
+}
+
+func Shadowed(fmt printer) string {
+	return fmt.Sprint(strings.ToUpper("a"))
+}
+`
+
+	testFile := filepath.Join(t.TempDir(), "store.go")
+	if err := os.WriteFile(testFile, []byte(testCode), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	analysis, err := ParseFile(testFile)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+
+	expected := map[string][]string{
+		"Encode":   {"encoding/json", "fmt"},
+		"Shadowed": {"strings"}, // a parameter named fmt isn't the package
+	}
+	for _, fn := range analysis.Functions {
+		if !reflect.DeepEqual(fn.Complexity.Dependencies, expected[fn.Name]) {
+			t.Errorf("%s: expected dependencies %v, got %v", fn.Name, expected[fn.Name], fn.Complexity.Dependencies)
+		}
+	}
+}
+
+func TestParseFileCgo(t *testing.T) {
+	testCode := `package sys
+
+/*
+#include <unistd.h>
+*/
+import "C"
+
+import "strconv"
+
+func PageSize() string {
+	return strconv.Itoa(int(C.getpagesize()))
+}
+`
+
+	testFile := filepath.Join(t.TempDir(), "sys.go")
+	if err := os.WriteFile(testFile, []byte(testCode), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	analysis, err := ParseFile(testFile)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+	if !analysis.IsCgo {
+		t.Error("Expected the file to be detected as cgo")
+	}
+	if !reflect.DeepEqual(analysis.Imports, []ImportInfo{{Path: "strconv"}}) {
+		t.Errorf("Expected only strconv among the imports, got %v", analysis.Imports)
+	}
+	if len(analysis.Functions) != 1 || !reflect.DeepEqual(analysis.Functions[0].Complexity.Dependencies, []string{"strconv"}) {
+		t.Errorf("Expected PageSize to depend on strconv alone, got %+v", analysis.Functions)
+	}
+}
+
+func TestParseFileBodyless(t *testing.T) {
+	testCode := `package mathx
+
+import _ "unsafe"
+
+// Sqrt is implemented in sqrt_amd64.s.
+func Sqrt(x float64) float64
+
+//go:linkname nanotime runtime.nanotime
+func nanotime() int64
+
+func Abs(x float64) float64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+`
+
+	testFile := filepath.Join(t.TempDir(), "mathx.go")
+	if err := os.WriteFile(testFile, []byte(testCode), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	analysis, err := ParseFile(testFile)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+	if analysis.IsCgo {
+		t.Error("Expected a file without import \"C\" not to be cgo")
+	}
+
+	expected := map[string]bool{"Sqrt": true, "nanotime": true, "Abs": false}
+	for _, fn := range analysis.Functions {
+		if fn.IsExternalImpl != expected[fn.Name] {
+			t.Errorf("%s: expected IsExternalImpl %v, got %v", fn.Name, expected[fn.Name], fn.IsExternalImpl)
+		}
+		if fn.IsExternalImpl && fn.Body != "" {
+			t.Errorf("%s: expected no body, got %q", fn.Name, fn.Body)
+		}
+	}
+}
+
+func TestParseFileProcessState(t *testing.T) {
+	testCode := `package settings
+
+import "os"
+
+func FromEnv() string {
+	return os.Getenv("APP_MODE")
+}
+
+func Root() (string, error) {
+	return os.Getwd()
+}
+
+func Save(path string, data []byte) error {
+	return os.WriteFile(path, data, 0644)
+}
+
+func Shadowed(os fakeOS) string {
+	return os.Getenv("APP_MODE")
+}
+`
+
+	testFile := filepath.Join(t.TempDir(), "settings.go")
+	if err := os.WriteFile(testFile, []byte(testCode), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	analysis, err := ParseFile(testFile)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+
+	expected := map[string][3]bool{ // env, working dir, filesystem
+		"FromEnv":  {true, false, false},
+		"Root":     {false, true, false},
+		"Save":     {false, false, true},
+		"Shadowed": {false, false, false}, // a parameter named os isn't the package
+	}
+	for _, fn := range analysis.Functions {
+		c := fn.Complexity
+		if got := [3]bool{c.UsesEnv, c.UsesWorkingDir, c.UsesFilesystem}; got != expected[fn.Name] {
+			t.Errorf("%s: expected env/workdir/fs %v, got %v", fn.Name, expected[fn.Name], got)
+		}
+	}
+}
+
+func TestPromotingTypes(t *testing.T) {
+	analysis, err := ParseFile(filepath.Join("testdata", "embedding", "store.go"))
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+
+	// Store embeds *cache directly, Layered through Store; Shadowed declares
+	// its own Get and private is unexported
+	got := analysis.PromotingTypes("*cache", "Get")
+	want := []string{"Store", "Layered"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected promoting types %v, got %v", want, got)
+	}
+
+	// Shadowing is per method: Shadowed still promotes other cache methods
+	got = analysis.PromotingTypes("cache", "Put")
+	want = []string{"Store", "Layered", "Shadowed"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected promoting types %v, got %v", want, got)
+	}
+
+	var store TypeInfo
+	for _, typeInfo := range analysis.Types {
+		if typeInfo.Name == "Store" {
+			store = typeInfo
+		}
+	}
+	if !reflect.DeepEqual(store.Embedded, []string{"cache"}) {
+		t.Errorf("Expected Store to embed cache, got %v", store.Embedded)
+	}
+	if !reflect.DeepEqual(store.Fields, []string{"Name string"}) {
+		t.Errorf("Expected Store fields [Name string], got %v", store.Fields)
+	}
+
+	definition := analysis.TypeDefinition("Store")
+	if !strings.HasPrefix(definition, "type Store struct {") || !strings.Contains(definition, "*cache") {
+		t.Errorf("Unexpected Store definition:\n%s", definition)
+	}
+}
+
+func TestParseFileCRLFAndBOM(t *testing.T) {
+	testCode := "package user\n\n" +
+		"// Greet says hello.\n" +
+		"// It trims the name first.\n" +
+		"func Greet(name string) string {\n" +
+		"\tname = strings.TrimSpace(name)\n" +
+		"\treturn `hello\n" +
+		"` + name\n" +
+		"}\n"
+
+	dir := t.TempDir()
+	lfFile := filepath.Join(dir, "lf.go")
+	crlfFile := filepath.Join(dir, "crlf.go")
+	if err := os.WriteFile(lfFile, []byte(testCode), 0644); err != nil {
+		t.Fatal(err)
+	}
+	windows := append([]byte{0xEF, 0xBB, 0xBF}, strings.ReplaceAll(testCode, "\n", "\r\n")...)
+	if err := os.WriteFile(crlfFile, windows, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lf, err := ParseFile(lfFile)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+	crlf, err := ParseFile(crlfFile)
+	if err != nil {
+		t.Fatalf("ParseFile failed on a CRLF file with a BOM: %v", err)
+	}
+	if len(crlf.Functions) != 1 || crlf.PackageName != "user" {
+		t.Fatalf("Expected one function in package user, got %+v", crlf)
+	}
+
+	got, want := crlf.Functions[0], lf.Functions[0]
+	got.File, want.File = "", ""
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CRLF file parsed differently:\n%+v\nwant\n%+v", got, want)
+	}
+	for _, text := range append([]string{got.Body, got.Signature}, got.Comments...) {
+		if strings.Contains(text, "\r") || strings.Contains(text, "\ufeff") {
+			t.Errorf("Expected no stray \\r or BOM, got %q", text)
+		}
+	}
+
+	source, err := FunctionSource(crlfFile, "Greet")
+	if err != nil {
+		t.Fatalf("FunctionSource failed: %v", err)
+	}
+	if strings.Contains(source, "\r") {
+		t.Errorf("Expected FunctionSource without \\r, got %q", source)
+	}
+}
+
+func TestParseFileVariables(t *testing.T) {
+	source := `package config
+
+var DefaultPort = 8080
+
+var (
+	Host, Scheme = "localhost", "https"
+	Fallback     = DefaultHost
+	Timeout      int
+)
+
+var retries = computeRetries()
+
+func Port() int { return DefaultPort }
+`
+	path := filepath.Join(t.TempDir(), "config.go")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	analysis, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+
+	// Only variables with initializers are recorded
+	expected := map[string]string{
+		"DefaultPort": "8080",
+		"Host":        `"localhost"`,
+		"Scheme":      `"https"`,
+		"Fallback":    "DefaultHost",
+		"retries":     "unknown",
+	}
+	if !reflect.DeepEqual(analysis.Variables, expected) {
+		t.Errorf("Expected variables %v, got %v", expected, analysis.Variables)
+	}
+}
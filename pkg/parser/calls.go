@@ -0,0 +1,43 @@
+package parser
+
+import "go/ast"
+
+// CallName is how calls refer to a function: its name, or "Type.Method"
+// for methods
+func CallName(fn FunctionInfo) string {
+	if fn.Receiver == nil {
+		return fn.Name
+	}
+	return BaseTypeName(fn.Receiver.Type) + "." + fn.Name
+}
+
+// collectCalls lists, by CallName and in order of first call, the package
+// functions a function calls and the methods it calls on its own receiver.
+// Calls into other packages or through other values can't be attributed
+// without type information and are left out.
+func collectCalls(funcDecl *ast.FuncDecl, receiver *ReceiverInfo) []string {
+	var calls []string
+	seen := make(map[string]bool)
+	ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+
+		var name string
+		switch fun := call.Fun.(type) {
+		case *ast.Ident:
+			name = fun.Name
+		case *ast.SelectorExpr:
+			if x, ok := fun.X.(*ast.Ident); ok && receiver != nil && x.Name == receiver.Name && x.Name != "" {
+				name = BaseTypeName(receiver.Type) + "." + fun.Sel.Name
+			}
+		}
+		if name != "" && !seen[name] {
+			seen[name] = true
+			calls = append(calls, name)
+		}
+		return true
+	})
+	return calls
+}
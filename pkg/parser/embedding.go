@@ -0,0 +1,108 @@
+package parser
+
+import (
+	"go/ast"
+	"strings"
+)
+
+// analyzeStructFields returns the named fields ("Name Type") of a struct and
+// the type names of its embedded fields
+func analyzeStructFields(structType *ast.StructType) ([]string, []string) {
+	var fields, embedded []string
+
+	for _, field := range structType.Fields.List {
+		typeStr := TypeString(field.Type)
+		if len(field.Names) == 0 {
+			embedded = append(embedded, BaseTypeName(typeStr))
+			continue
+		}
+		for _, name := range field.Names {
+			fields = append(fields, name.Name+" "+typeStr)
+		}
+	}
+
+	return fields, embedded
+}
+
+// BaseTypeName strips pointers, package qualifiers and type arguments ("*pkg.Base[T]" -> "Base")
+func BaseTypeName(typeStr string) string {
+	typeStr = strings.TrimLeft(typeStr, "*")
+	if i := strings.Index(typeStr, "["); i >= 0 {
+		typeStr = typeStr[:i]
+	}
+	if i := strings.LastIndex(typeStr, "."); i >= 0 {
+		typeStr = typeStr[i+1:]
+	}
+	return typeStr
+}
+
+// PromotingTypes returns the exported struct types in the file that expose
+// method of embeddedType through embedding, directly or through nested
+// embedded fields. A type that declares its own method or field of the same
+// name shadows the promotion and is not returned.
+func (fa *FileAnalysis) PromotingTypes(embeddedType, method string) []string {
+	embeddedType = BaseTypeName(embeddedType)
+
+	types := make(map[string]TypeInfo)
+	for _, typeInfo := range fa.Types {
+		types[typeInfo.Name] = typeInfo
+	}
+
+	var outers []string
+	for _, typeInfo := range fa.Types {
+		if typeInfo.Name == embeddedType || !ast.IsExported(typeInfo.Name) {
+			continue
+		}
+		if fa.embeds(types, typeInfo.Name, embeddedType, method, make(map[string]bool)) {
+			outers = append(outers, typeInfo.Name)
+		}
+	}
+
+	return outers
+}
+
+// embeds reports whether typeName reaches target through embedded fields
+// without method being shadowed along the way
+func (fa *FileAnalysis) embeds(types map[string]TypeInfo, typeName, target, method string, visited map[string]bool) bool {
+	if visited[typeName] {
+		return false
+	}
+	visited[typeName] = true
+
+	typeInfo, ok := types[typeName]
+	if !ok || fa.shadows(typeInfo, method) {
+		return false
+	}
+
+	for _, embedded := range typeInfo.Embedded {
+		if embedded == target || fa.embeds(types, embedded, target, method, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// shadows reports whether a type declares its own method or field named name
+func (fa *FileAnalysis) shadows(typeInfo TypeInfo, name string) bool {
+	for _, field := range typeInfo.Fields {
+		if strings.Fields(field)[0] == name {
+			return true
+		}
+	}
+	for _, fn := range fa.Functions {
+		if fn.IsMethod && fn.Name == name && BaseTypeName(fn.Receiver.Type) == typeInfo.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// TypeDefinition returns the source of the named type declaration, if present
+func (fa *FileAnalysis) TypeDefinition(name string) string {
+	for _, typeInfo := range fa.Types {
+		if typeInfo.Name == name {
+			return typeInfo.Definition
+		}
+	}
+	return ""
+}
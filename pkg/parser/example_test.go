@@ -0,0 +1,59 @@
+package parser_test
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/Eranmonnie/testgen/pkg/parser"
+)
+
+func ExampleParseSource() {
+	src := `package user
+
+import (
+	"errors"
+	"os"
+)
+
+// ValidateUser reports why a name can't be used
+func ValidateUser(name string) error {
+	if name == "" {
+		return errors.New("name required")
+	}
+	return nil
+}
+
+func (s *Store) Home() string {
+	return os.Getenv("HOME") + "/" + s.dir
+}
+
+type Store struct{ dir string }
+`
+	analysis, err := parser.ParseSource("user.go", []byte(src))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, fn := range analysis.Functions {
+		fmt.Printf("%s: %s\n", parser.CallName(fn), fn.Signature)
+		fmt.Printf("  lines %d-%d, returns error: %t, reads env: %t, uses %v\n",
+			fn.StartLine, fn.EndLine, fn.Complexity.HasErrors, fn.Complexity.UsesEnv, fn.Complexity.Dependencies)
+	}
+	// Output:
+	// ValidateUser: func ValidateUser(name string) error
+	//   lines 9-14, returns error: true, reads env: false, uses [errors]
+	// Store.Home: func (s *Store) Home() string
+	//   lines 16-18, returns error: false, reads env: true, uses [os]
+}
+
+func ExampleParseFile() {
+	analysis, err := parser.ParseFile("testdata/embedding/store.go")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, typ := range analysis.PromotingTypes("cache", "Get") {
+		fmt.Println(typ, "promotes cache.Get")
+	}
+	// Output:
+	// Store promotes cache.Get
+	// Layered promotes cache.Get
+}
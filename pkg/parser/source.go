@@ -0,0 +1,29 @@
+package parser
+
+import (
+	"bytes"
+	"os"
+)
+
+// utf8BOM is the byte order mark some Windows editors put at the start of files
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// ReadSource reads a Go file the way testgen parses it: see NormalizeSource
+func ReadSource(filePath string) ([]byte, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return NormalizeSource(data), nil
+}
+
+// NormalizeSource strips a leading UTF-8 BOM and turns \r\n line endings
+// into \n, so files checked out with core.autocrlf yield offsets, bodies
+// and signatures without stray \r
+func NormalizeSource(data []byte) []byte {
+	data = bytes.TrimPrefix(data, utf8BOM)
+	if !bytes.Contains(data, []byte("\r\n")) {
+		return data
+	}
+	return bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+}
@@ -0,0 +1,39 @@
+package store
+
+// cache is an unexported building block embedded by the exported stores
+type cache struct {
+	items map[string]string
+}
+
+// Get returns the value stored for key
+func (c *cache) Get(key string) (string, bool) {
+	value, ok := c.items[key]
+	return value, ok
+}
+
+// Store exposes cache.Get through embedding
+type Store struct {
+	*cache
+	Name string
+}
+
+// Layered reaches cache.Get through Store
+type Layered struct {
+	Store
+	Fallback *Store
+}
+
+// Shadowed declares its own Get, hiding the embedded one
+type Shadowed struct {
+	cache
+}
+
+// Get always misses
+func (s Shadowed) Get(key string) (string, bool) {
+	return "", false
+}
+
+// private embeds cache but is unexported
+type private struct {
+	cache
+}